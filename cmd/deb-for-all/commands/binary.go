@@ -1,15 +1,15 @@
 package commands
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 
 	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/ops"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
-func DownloadBinaryPackage(packageName, version, baseURL string, suites, components, architectures []string, destDir, cacheDir string, silent bool, keyrings, keyringDirs []string, skipGPGVerify bool, localizer *i18n.Localizer) error {
+func DownloadBinaryPackage(packageName, version, baseURL string, suites, components, architectures []string, arch, destDir, cacheDir string, silent bool, keyrings, keyringDirs []string, skipGPGVerify bool, localizer *i18n.Localizer) error {
 	if !silent {
 		fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
 			MessageID: "command.download.start",
@@ -19,46 +19,6 @@ func DownloadBinaryPackage(packageName, version, baseURL string, suites, compone
 				"Dest":    destDir,
 			},
 		}))
-	}
-
-	var err error
-
-	if packageName == "" {
-		return fmt.Errorf("package name is required")
-	}
-
-	if err := os.MkdirAll(destDir, debian.DirPermission); err != nil {
-		return fmt.Errorf("unable to create destination directory: %w", err)
-	}
-
-	if len(suites) == 0 {
-		suites = []string{"bookworm"}
-	}
-	if len(components) == 0 {
-		components = []string{"main"}
-	}
-	if len(architectures) == 0 {
-		architectures = []string{"amd64"}
-	}
-	if baseURL == "" {
-		baseURL = "http://deb.debian.org/debian"
-	}
-
-	repo := debian.NewRepository(
-		"download-repo",
-		baseURL,
-		"Repository for package download",
-		suites[0],
-		components,
-		architectures,
-	)
-
-	repo.SetKeyringPathsWithDirs(keyrings, keyringDirs)
-	if skipGPGVerify {
-		repo.DisableSignatureVerification()
-	}
-
-	if !silent {
 		fmt.Printf("Recherche du paquet %s", packageName)
 		if version != "" {
 			fmt.Printf(" version %s", version)
@@ -66,85 +26,64 @@ func DownloadBinaryPackage(packageName, version, baseURL string, suites, compone
 		fmt.Println("...")
 	}
 
-	usedCache := false
-	if cacheDir != "" {
-		if _, err = repo.LoadCachedPackages(cacheDir); err == nil {
-			usedCache = true
-		} else if !silent {
-			fmt.Printf("Cache introuvable ou invalide dans %s, récupération distante...\n", cacheDir)
-		}
-	}
-
-	if !usedCache {
-		if _, err = repo.FetchPackages(); err != nil {
-			return fmt.Errorf("error retrieving packages: %w", err)
-		}
-	}
-
-	pkgMetadata, err := repo.GetPackageMetadataWithArch(packageName, version, architectures)
-	if err != nil && usedCache {
-		if !silent {
-			fmt.Println("Paquet introuvable dans le cache, récupération distante des métadonnées...")
-		}
-
-		if _, fetchErr := repo.FetchPackages(); fetchErr != nil {
-			return fmt.Errorf("error retrieving packages: %w", fetchErr)
-		}
-
-		pkgMetadata, err = repo.GetPackageMetadataWithArch(packageName, version, architectures)
-	}
-
-	if err != nil {
-		return fmt.Errorf("error retrieving metadata for package %s: %w", packageName, err)
+	opts := ops.DownloadOptions{
+		Source: ops.Source{
+			BaseURL:       baseURL,
+			Suites:        suites,
+			Components:    components,
+			Architectures: architectures,
+			Keyrings:      keyrings,
+			KeyringDirs:   keyringDirs,
+			SkipGPGVerify: skipGPGVerify,
+		},
+		Package:  packageName,
+		Version:  version,
+		Arch:     arch,
+		DestDir:  destDir,
+		CacheDir: cacheDir,
 	}
-
 	if !silent {
-		fmt.Printf("Téléchargement du paquet %s version %s...\n", pkgMetadata.Name, pkgMetadata.Version)
-		fmt.Printf("Architecture: %s\n", pkgMetadata.Architecture)
-		fmt.Printf("Taille: %d bytes\n", pkgMetadata.Size)
-	}
-
-	destPath := filepath.Join(destDir, packageFilename(pkgMetadata))
-
-	// Create downloader
-	downloader := debian.NewDownloader()
-
-	skip, err := downloader.ShouldSkipDownload(pkgMetadata, destPath)
-	if err != nil {
-		return fmt.Errorf("failed to check existing file for %s: %w", pkgMetadata.Name, err)
-	}
-	if skip {
-		if !silent {
-			fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
-				MessageID: "command.download.skip_existing",
-				TemplateData: map[string]any{
-					"Package": pkgMetadata.Name,
-				},
-			}))
+		opts.Log = func(format string, args ...any) { fmt.Printf(format+"\n", args...) }
+		opts.Selected = func(pkg *debian.Package) {
+			if len(suites) > 1 {
+				fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
+					MessageID:    "command.download.found_in",
+					TemplateData: map[string]any{"Package": pkg.Name, "Version": pkg.Version, "Suite": pkg.Suite},
+				}))
+			}
+			fmt.Printf("Téléchargement du paquet %s version %s...\n", pkg.Name, pkg.Version)
+			fmt.Printf("Architecture: %s\n", pkg.Architecture)
+			fmt.Printf("Taille: %d bytes\n", pkg.Size)
 		}
-		return nil
-	}
-
-	// Download the package
-	if silent {
-		err = downloader.DownloadSilent(pkgMetadata, destPath)
-	} else {
-		fmt.Printf("Téléchargement vers %s...\n", destPath)
-		err = downloader.DownloadWithProgress(pkgMetadata, destPath, func(downloaded, total int64) {
+		opts.Progress = func(downloaded, total int64) {
 			if total > 0 {
 				percentage := float64(downloaded) / float64(total) * 100
 				fmt.Printf("\rTéléchargement: %.1f%% (%d/%d bytes)", percentage, downloaded, total)
 			}
-		})
+		}
 	}
 
+	result, err := ops.DownloadOperation(context.Background(), opts)
+	if !silent && result != nil {
+		defer printWarningSummary(result.Warnings, localizer)
+	}
 	if err != nil {
-		return fmt.Errorf("error downloading: %w", err)
+		return localizeError(err, localizer)
 	}
 
-	if !silent {
-		fmt.Printf("\n✓ Paquet %s téléchargé avec succès vers %s\n", pkgMetadata.Name, destDir)
+	if silent {
+		return nil
+	}
+	if result.Skipped {
+		fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID: "command.download.skip_existing",
+			TemplateData: map[string]any{
+				"Package": result.Package.Name,
+			},
+		}))
+		return nil
 	}
+	fmt.Printf("\n✓ Paquet %s téléchargé avec succès vers %s\n", result.Package.Name, destDir)
 
 	return nil
 }
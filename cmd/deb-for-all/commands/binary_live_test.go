@@ -0,0 +1,37 @@
+//go:build live
+
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// These tests run against deb.debian.org; run them with go test -tags live.
+
+func TestDownloadBinaryWithVersionLive(t *testing.T) {
+	t.Helper()
+	defer silenceStdoutBinary(t)()
+
+	downloader := debian.NewDownloader()
+	pkg := &debian.Package{
+		Name:         "hello",
+		Version:      "2.10-2",
+		Architecture: "amd64",
+		DownloadURL:  "http://deb.debian.org/debian/pool/main/h/hello/hello_2.10-2_amd64.deb",
+		Filename:     "hello_2.10-2_amd64.deb",
+	}
+
+	destDir := t.TempDir()
+	if err := downloader.DownloadToDir(pkg, destDir); err != nil {
+		t.Fatalf("failed to download binary package: %v", err)
+	}
+
+	destPath := filepath.Join(destDir, pkg.Filename)
+	if _, err := os.Stat(destPath); err != nil {
+		t.Fatalf("downloaded file missing: %v", err)
+	}
+}
@@ -3,23 +3,29 @@ package commands
 import (
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"testing"
 
+	"github.com/CeGenreDeChat/deb-for-all/internal/testsupport"
 	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
 )
 
 func TestDownloadBinaryWithVersionIntegration(t *testing.T) {
-	t.Helper()
 	defer silenceStdoutBinary(t)()
 
+	repo := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-2"}},
+	})
+	filename := repo.Filename("hello", "amd64")
+
 	downloader := debian.NewDownloader()
 	pkg := &debian.Package{
 		Name:         "hello",
 		Version:      "2.10-2",
 		Architecture: "amd64",
-		DownloadURL:  "http://deb.debian.org/debian/pool/main/h/hello/hello_2.10-2_amd64.deb",
-		Filename:     "hello_2.10-2_amd64.deb",
+		DownloadURL:  repo.URL + "/" + filename,
+		Filename:     path.Base(filename),
 	}
 
 	destDir := t.TempDir()
@@ -31,6 +37,9 @@ func TestDownloadBinaryWithVersionIntegration(t *testing.T) {
 	if _, err := os.Stat(destPath); err != nil {
 		t.Fatalf("downloaded file missing: %v", err)
 	}
+	if got := repo.Requests(filename); got != 1 {
+		t.Fatalf("expected one request for %s, got %d", filename, got)
+	}
 }
 
 func silenceStdoutBinary(t *testing.T) func() {
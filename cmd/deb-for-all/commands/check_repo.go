@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// CheckRepository checks the repository rooted at rootDir the way apt would consume it
+// (see debian.ValidateRepoLayout) and prints the problems found. It fails when one of
+// them is an error, i.e. breaks apt.
+func CheckRepository(rootDir string, localizer *i18n.Localizer) error {
+	problems, err := debian.ValidateRepoLayout(rootDir)
+	if err != nil {
+		return err
+	}
+
+	errorCount := 0
+	for _, problem := range problems {
+		if problem.Severity == debian.ProblemError {
+			errorCount++
+		}
+		fmt.Printf("  ✗ %s\n", problem)
+	}
+	fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
+		MessageID:    "command.check_repo.summary",
+		TemplateData: map[string]any{"Root": rootDir, "Errors": errorCount, "Warnings": len(problems) - errorCount},
+	}))
+
+	if errorCount > 0 {
+		return errors.New(localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "error.check_repo.failed",
+			TemplateData: map[string]any{"Root": rootDir, "Count": errorCount},
+		}))
+	}
+	return nil
+}
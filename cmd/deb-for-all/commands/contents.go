@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// ListDebContents prints the files shipped by a local .deb, one per line, in the form
+// "<mode> <size> <md5> <path>" followed by the target of symbolic and hard links.
+func ListDebContents(debFile string, localizer *i18n.Localizer) error {
+	entries, err := debian.ReadDebFileList(debFile)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		md5 := entry.MD5
+		if md5 == "" {
+			md5 = "-"
+		}
+
+		line := fmt.Sprintf("%s %10d %-32s %s", entry.Mode, entry.Size, md5, entry.Path)
+		switch {
+		case entry.IsHardLink():
+			line += " link to " + entry.Link
+		case entry.Link != "":
+			line += " -> " + entry.Link
+		}
+		fmt.Println(line)
+	}
+
+	return nil
+}
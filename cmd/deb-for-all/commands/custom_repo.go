@@ -1,17 +1,24 @@
 package commands
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/ops"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
+// failuresFilename is the file of the destination directory a --keep-going build
+// saves its failed packages to.
+const failuresFilename = "failures.json"
+
 type xmlPackageList struct {
 	Packages []xmlPackageEntry `xml:"package"`
 }
@@ -21,254 +28,117 @@ type xmlPackageEntry struct {
 	Version string `xml:"version,attr"`
 }
 
-// BuildCustomRepository builds a custom repository subset from an XML package list,
-// resolves dependencies (with optional exclusions), and downloads the resulting packages.
-// If gpgKeyPath is provided, the Release files will be signed with the GPG key.
-func BuildCustomRepository(baseURL, suites, components, architectures, destDir, packagesXML, excludeDeps string, keyrings, keyringDirs []string, skipGPGVerify, verbose bool, rateLimit int, includeSources bool, gpgKeyPath, gpgPassphrase string, localizer *i18n.Localizer) error {
-	if packagesXML == "" {
-		return fmt.Errorf("packages XML file is required")
-	}
+// CustomRepoSettings holds the settings of the custom-repo command that
+// ops.CustomRepoOptions does not carry: the output, the files BuildCustomRepository
+// reads and opens, and the check it runs after.
+type CustomRepoSettings struct {
+	Verbose           bool
+	PlanJSON          bool   // Print the dry-run plan as JSON
+	PackagesXML       string // XML list of the packages to include
+	ExcludeDeps       string // Dependency kinds not followed, comma-separated
+	RetryFailed       string // failures.json of an earlier --keep-going build to retry
+	SharedCacheDir    string // Content-addressed pool cache shared with other builds, optional
+	SharedCacheMaxMiB int64  // Size limit of the shared cache, 0 for none
+	ResolveCacheDir   string // Directory of the dependency resolution cache, optional
+	SkipCheck         bool   // Do not check the repository built with CheckRepository
+	SuiteConflict     string // "highest" (default) or "error"
+}
 
-	packageSpecs, err := loadPackageSpecs(packagesXML)
-	if err != nil {
-		return err
+// BuildCustomRepository builds a custom repository subset from the XML package list
+// settings.PackagesXML, resolves dependencies (with optional exclusions), and
+// downloads the resulting packages into opts.DestDir. With opts.DryRun, nothing is
+// downloaded: the download plan is printed (as JSON when settings.PlanJSON is set)
+// and returned. With opts.KeepGoing, packages failing to download are left out and
+// saved to <DestDir>/failures.json, which a later run takes as settings.RetryFailed
+// to download just them and merge them into the repository; the package list is not
+// needed then. Unless settings.SkipCheck is set, the repository built is then checked
+// with CheckRepository. settings.SuiteConflict is "highest" to list, in every suite,
+// the highest version of a package the suites resolve differently, or "error" to fail
+// the build then.
+func BuildCustomRepository(opts ops.CustomRepoOptions, settings CustomRepoSettings, localizer *i18n.Localizer) (*debian.DownloadPlan, error) {
+	if settings.PackagesXML == "" && settings.RetryFailed == "" {
+		return nil, fmt.Errorf("packages XML file is required")
 	}
 
-	excludeSet, err := parseExcludeDeps(excludeDeps, localizer)
-	if err != nil {
-		return fmt.Errorf("invalid --exclude-deps value: %w", err)
+	var err error
+	if settings.RetryFailed != "" {
+		if opts.RetryFailed, err = ops.ReadFailures(settings.RetryFailed); err != nil {
+			return nil, err
+		}
+	} else if opts.Packages, err = loadPackageSpecs(settings.PackagesXML); err != nil {
+		return nil, err
 	}
 
-	suiteList := splitAndTrim(suites)
-	componentList := splitAndTrim(components)
-	archList := splitAndTrim(architectures)
-
-	if len(suiteList) == 0 {
-		return fmt.Errorf("at least one suite is required")
-	}
-	if len(componentList) == 0 {
-		return fmt.Errorf("at least one component is required")
-	}
-	if len(archList) == 0 {
-		return fmt.Errorf("at least one architecture is required")
+	if opts.ExcludeDeps, err = parseExcludeDeps(settings.ExcludeDeps, localizer); err != nil {
+		return nil, fmt.Errorf("invalid --exclude-deps value: %w", err)
 	}
 
-	if err := os.MkdirAll(destDir, debian.DirPermission); err != nil {
-		return fmt.Errorf("unable to create destination directory: %w", err)
+	switch settings.SuiteConflict {
+	case "", "highest":
+		opts.ConflictPolicy = ops.ConflictHighestVersion
+	case "error":
+		opts.ConflictPolicy = ops.ConflictError
+	default:
+		return nil, errors.New(localizeMessage(localizer, "error.custom_repo.unknown_suite_conflict", fmt.Sprintf("unknown --suite-conflict value '%s' (allowed: highest, error)", settings.SuiteConflict), map[string]any{"Value": settings.SuiteConflict}))
 	}
 
-	metadataRoot := filepath.Join(destDir, "dists")
-	if err := os.MkdirAll(metadataRoot, debian.DirPermission); err != nil {
-		return fmt.Errorf("unable to create metadata directory: %w", err)
+	if opts.Cache, err = openSharedCache(settings.SharedCacheDir, settings.SharedCacheMaxMiB); err != nil {
+		return nil, err
 	}
 
-	for _, suite := range suiteList {
-		repo := debian.NewRepository("custom-repo"+suite, baseURL, "custom repo", suite, componentList, archList)
-		repo.SetKeyringPathsWithDirs(keyrings, keyringDirs)
-		if skipGPGVerify {
-			repo.DisableSignatureVerification()
-		}
-
-		packageMetadata := make(map[string]map[string][]debian.Package)
-		sourceMetadata := make(map[string][]debian.SourcePackage)
-		downloader := debian.NewDownloader()
-		downloader.RateDelay = time.Duration(rateLimit) * time.Second
-
-		// Validate all components and architectures first
-		if err := validateComponentsAndArchitectures(repo, suite, componentList, archList, localizer); err != nil {
-			return err
+	if settings.ResolveCacheDir != "" {
+		if opts.ResolutionCache, err = debian.NewResolutionCache(settings.ResolveCacheDir); err != nil {
+			return nil, err
 		}
+	}
 
-		// Fetch metadata for ALL components before resolving dependencies
-		if verbose {
-			fmt.Printf("Suite %s: fetching metadata for all components (%s)...\n", suite, strings.Join(componentList, ", "))
-		}
-
-		if _, err := repo.FetchPackages(); err != nil {
-			return fmt.Errorf("failed to fetch packages for %s: %w", suite, err)
-		}
-
-		// Resolve dependencies across ALL components
-		resolved, err := repo.ResolveDependencies(packageSpecs, excludeSet)
-		if err != nil {
-			return fmt.Errorf("failed to resolve dependencies for %s: %w", suite, err)
-		}
-
-		if verbose {
-			fmt.Printf("Suite %s: %d packages to download across all components\n", suite, len(resolved))
-		}
-
-		// Download packages and organize by their original component
-		for _, pkg := range resolved {
-			arch := pkg.Architecture
-			if arch == "" {
-				arch = archList[0]
-			}
-
-			// Extract component from Filename (e.g., pool/non-free/s/snmp/... -> non-free)
-			component := extractComponentFromPath(pkg.Filename, componentList)
-			if component == "" {
-				// Fallback: use first component if extraction fails
-				component = componentList[0]
-				if verbose {
-					fmt.Printf("Warning: could not determine component for %s, using %s\n", pkg.Name, component)
-				}
-			}
-
-			if _, ok := packageMetadata[component]; !ok {
-				packageMetadata[component] = make(map[string][]debian.Package)
-			}
-
-			relPath := pkg.Filename
-			if relPath == "" {
-				filename := filepath.Base(packageFilename(&pkg))
-				relPath = filepath.ToSlash(filepath.Join("pool", component, filename))
-			}
-
-			targetPath := filepath.Join(destDir, filepath.FromSlash(relPath))
-			targetDir := filepath.Dir(targetPath)
-
-			skip, err := downloader.ShouldSkipDownload(&pkg, targetPath)
-			if err != nil {
-				return fmt.Errorf("failed to check existing file for %s: %w", pkg.Name, err)
-			}
-			if skip {
-				if verbose {
-					fmt.Printf("Suite %s: skipping %s from %s (already downloaded, checksum verified)\n", suite, pkg.Name, component)
-				}
-				pkg.Filename = filepath.ToSlash(relPath)
-				packageMetadata[component][arch] = append(packageMetadata[component][arch], pkg)
-				continue
-			}
-
-			if err := os.MkdirAll(targetDir, debian.DirPermission); err != nil {
-				return fmt.Errorf("unable to create pool directory %s: %w", targetDir, err)
-			}
-
-			if err := downloader.DownloadWithProgress(&pkg, targetPath, nil); err != nil {
-				return fmt.Errorf("failed to download %s: %w", pkg.Name, err)
-			}
-
-			pkg.Filename = filepath.ToSlash(relPath)
-			packageMetadata[component][arch] = append(packageMetadata[component][arch], pkg)
-		}
-
-		// Download source packages if requested
-		if includeSources {
-			resolvedSlice := make([]debian.Package, 0, len(resolved))
-			for _, pkg := range resolved {
-				resolvedSlice = append(resolvedSlice, pkg)
-			}
-
-			// Group source packages by component
-			for _, component := range componentList {
-				// Filter packages for this component
-				var componentPkgs []debian.Package
-				for _, pkg := range resolvedSlice {
-					pkgComponent := extractComponentFromPath(pkg.Filename, componentList)
-					if pkgComponent == component || pkgComponent == "" {
-						componentPkgs = append(componentPkgs, pkg)
-					}
-				}
-
-				if len(componentPkgs) > 0 {
-					srcPkgs, err := downloadSourcePackages(repo, componentPkgs, destDir, component, downloader, verbose, suite)
-					if err != nil {
-						return fmt.Errorf("failed to download source packages for %s/%s: %w", suite, component, err)
-					}
-					sourceMetadata[component] = append(sourceMetadata[component], srcPkgs...)
-				}
-			}
-		}
+	if settings.Verbose {
+		opts.Log = func(format string, args ...any) { fmt.Printf(format+"\n", args...) }
+	}
 
-		if err := debian.WritePackagesMetadata(metadataRoot, suite, packageMetadata); err != nil {
-			return err
+	result, err := ops.CustomRepoOperation(context.Background(), opts)
+	// The JSON plan carries the warnings itself
+	if result != nil && (!opts.DryRun || !settings.PlanJSON) {
+		defer printWarningSummary(result.Warnings, localizer)
+	}
+	failuresPath := filepath.Join(opts.DestDir, failuresFilename)
+	var partial *ops.PartialBuildError
+	if errors.As(err, &partial) {
+		if writeErr := ops.WriteFailures(failuresPath, partial.Failures); writeErr != nil {
+			return nil, writeErr
 		}
-
-		if includeSources && len(sourceMetadata) > 0 {
-			if err := debian.WriteSourcesMetadata(metadataRoot, suite, sourceMetadata); err != nil {
-				return err
-			}
+		return nil, errors.New(localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "error.custom_repo.partial",
+			TemplateData: map[string]any{"Count": len(partial.Failures), "Path": failuresPath},
+		}))
+	}
+	if err != nil {
+		return nil, localizeError(err, localizer)
+	}
+	// The repository is complete, so failures of an earlier run no longer apply
+	if !opts.DryRun {
+		if err := os.Remove(failuresPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
 		}
-
-		// Build signing config if GPG key is provided
-		var signingConfig *debian.ReleaseSigningConfig
-		if gpgKeyPath != "" {
-			signingConfig = &debian.ReleaseSigningConfig{
-				PrivateKeyPath: gpgKeyPath,
-				Passphrase:     gpgPassphrase,
-			}
-			if verbose {
-				fmt.Printf("Suite %s: signing Release files with GPG key %s\n", suite, gpgKeyPath)
+		if !settings.SkipCheck {
+			if err := CheckRepository(opts.DestDir, localizer); err != nil {
+				return nil, err
 			}
-		} else if verbose {
-			fmt.Printf("Suite %s: no GPG key provided, Release files will be unsigned\n", suite)
-		}
-
-		if err := debian.WriteSignedReleaseFiles(metadataRoot, suite, componentList, archList, includeSources && len(sourceMetadata) > 0, signingConfig); err != nil {
-			return fmt.Errorf("failed to write Release files for suite %s: %w", suite, err)
 		}
 	}
 
-	return nil
-}
-
-func formatPackagesFile(packages []debian.Package) string {
-	var sb strings.Builder
-
-	for _, pkg := range packages {
-		writeField := func(name, value string) {
-			if value != "" {
-				sb.WriteString(name)
-				sb.WriteString(": ")
-				sb.WriteString(value)
-				sb.WriteString("\n")
-			}
-		}
-
-		writeField("Package", pkg.Package)
-		writeField("Version", pkg.Version)
-		writeField("Architecture", pkg.Architecture)
-		writeField("Maintainer", pkg.Maintainer)
-		writeField("Section", pkg.Section)
-		writeField("Priority", pkg.Priority)
-		writeField("Filename", pkg.Filename)
-		if pkg.Size > 0 {
-			sb.WriteString("Size: ")
-			sb.WriteString(fmt.Sprintf("%d\n", pkg.Size))
+	if result.Plan != nil {
+		if settings.PlanJSON {
+			err = result.Plan.WriteJSON(os.Stdout)
+		} else {
+			err = result.Plan.WriteText(os.Stdout)
 		}
-		writeField("MD5sum", pkg.MD5sum)
-		writeField("SHA256", pkg.SHA256)
-		writeListField(&sb, "Depends", pkg.Depends)
-		writeListField(&sb, "Pre-Depends", pkg.PreDepends)
-		writeListField(&sb, "Recommends", pkg.Recommends)
-		writeListField(&sb, "Suggests", pkg.Suggests)
-		writeListField(&sb, "Breaks", pkg.Breaks)
-		writeListField(&sb, "Conflicts", pkg.Conflicts)
-		writeListField(&sb, "Provides", pkg.Provides)
-		writeListField(&sb, "Replaces", pkg.Replaces)
-
-		if pkg.Description != "" {
-			sb.WriteString("Description: ")
-			sb.WriteString(pkg.Description)
-			sb.WriteString("\n")
+		if err != nil {
+			return nil, fmt.Errorf("unable to print download plan: %w", err)
 		}
-
-		sb.WriteString("\n")
 	}
 
-	return sb.String()
-}
-
-func writeListField(sb *strings.Builder, name string, values []string) {
-	if len(values) == 0 {
-		return
-	}
-
-	sb.WriteString(name)
-	sb.WriteString(": ")
-	sb.WriteString(strings.Join(values, ", "))
-	sb.WriteString("\n")
+	return result.Plan, nil
 }
 
 func loadPackageSpecs(path string) ([]debian.PackageSpec, error) {
@@ -345,128 +215,3 @@ func localizeMessage(localizer *i18n.Localizer, messageID, fallback string, data
 
 	return fallback
 }
-
-// downloadSourcePackages downloads source packages corresponding to the resolved binary packages.
-func downloadSourcePackages(repo *debian.Repository, resolved []debian.Package, destDir, component string, downloader *debian.Downloader, verbose bool, suite string) ([]debian.SourcePackage, error) {
-	// Get unique source package names from binary packages
-	sourceNames := make(map[string]struct{})
-	for _, pkg := range resolved {
-		srcName := pkg.Source
-		if srcName == "" {
-			srcName = pkg.Package
-		}
-		// Strip version info if present (e.g., "foo (>= 1.0)" -> "foo")
-		if idx := strings.Index(srcName, " "); idx != -1 {
-			srcName = srcName[:idx]
-		}
-		sourceNames[srcName] = struct{}{}
-	}
-
-	// Fetch source packages metadata
-	if _, err := repo.FetchSources(); err != nil {
-		return nil, fmt.Errorf("failed to fetch source packages: %w", err)
-	}
-	sourcePkgs := repo.GetAllSourceMetadata()
-
-	var result []debian.SourcePackage
-	for srcName := range sourceNames {
-		srcPkg, found := findSourcePackage(sourcePkgs, srcName)
-		if !found {
-			if verbose {
-				fmt.Printf("Suite %s component %s: source package %s not found, skipping\n", suite, component, srcName)
-			}
-			continue
-		}
-
-		if verbose {
-			fmt.Printf("Suite %s component %s: downloading source %s\n", suite, component, srcName)
-		}
-
-		// Download all files for this source package
-		updatedFiles := make([]debian.SourceFile, 0, len(srcPkg.Files))
-		for _, file := range srcPkg.Files {
-			relPath := filepath.ToSlash(filepath.Join("pool", component, poolPrefix(srcName), srcName, file.Name))
-
-			targetPath := filepath.Join(destDir, filepath.FromSlash(relPath))
-			targetDir := filepath.Dir(targetPath)
-
-			if err := os.MkdirAll(targetDir, debian.DirPermission); err != nil {
-				return nil, fmt.Errorf("unable to create pool directory %s: %w", targetDir, err)
-			}
-
-			// Check if file already exists with correct checksum
-			if info, statErr := os.Stat(targetPath); statErr == nil && info.Size() == file.Size {
-				if verbose {
-					fmt.Printf("Suite %s component %s: skipping source file %s (already exists)\n", suite, component, file.Name)
-				}
-				updatedFiles = append(updatedFiles, file)
-				continue
-			}
-
-			downloadURL := file.URL
-			if downloadURL == "" {
-				downloadURL = fmt.Sprintf("%s/%s", repo.URL, relPath)
-			}
-			if err := downloader.DownloadURL(downloadURL, targetPath); err != nil {
-				return nil, fmt.Errorf("failed to download source file %s: %w", file.Name, err)
-			}
-
-			updatedFiles = append(updatedFiles, file)
-		}
-
-		srcPkg.Files = updatedFiles
-		srcPkg.Directory = filepath.ToSlash(filepath.Join("pool", component, poolPrefix(srcName), srcName))
-		result = append(result, srcPkg)
-	}
-
-	return result, nil
-}
-
-// findSourcePackage finds a source package by name in the list.
-func findSourcePackage(packages []debian.SourcePackage, name string) (debian.SourcePackage, bool) {
-	for _, pkg := range packages {
-		if pkg.Name == name {
-			return pkg, true
-		}
-	}
-	return debian.SourcePackage{}, false
-}
-
-// poolPrefix returns the pool prefix for a package name (lib* uses 4-char, others 1-char).
-func poolPrefix(name string) string {
-	if strings.HasPrefix(name, "lib") && len(name) > 3 {
-		return name[:4]
-	}
-	return name[:1]
-}
-
-// extractComponentFromPath extracts the component from a pool path.
-// Example: "pool/non-free/s/snmp/..." -> "non-free"
-// Returns empty string if component cannot be determined.
-func extractComponentFromPath(path string, validComponents []string) string {
-	if path == "" {
-		return ""
-	}
-
-	// Normalize path separators
-	path = filepath.ToSlash(path)
-
-	// Split path and look for pool/ prefix
-	parts := strings.Split(path, "/")
-	if len(parts) < 2 || parts[0] != "pool" {
-		return ""
-	}
-
-	// Component is typically the second part (after "pool/")
-	component := parts[1]
-
-	// Validate against the list of valid components
-	for _, valid := range validComponents {
-		if component == valid {
-			return component
-		}
-	}
-
-	// If not found in valid list, still return it (could be new component)
-	return component
-}
@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// ShowDependencies resolves the dependencies of a binary package, from the metadata
+// cache in cacheDir when it holds the suite and from the repository otherwise, and
+// prints the resolved packages one per line as "<name> <version>" or, with tree, as
+// the tree of debian.FormatDependencyTree. Only the first suite is searched.
+func ShowDependencies(packageName, version, baseURL string, suites, components, architectures []string, cacheDir, excludeDeps string, tree bool, keyrings, keyringDirs []string, skipGPGVerify bool, localizer *i18n.Localizer) error {
+	exclude, err := parseExcludeDeps(excludeDeps, localizer)
+	if err != nil {
+		return fmt.Errorf("invalid --exclude-deps value: %w", err)
+	}
+	if len(suites) == 0 {
+		return fmt.Errorf("at least one suite is required")
+	}
+
+	repo := debian.NewRepository("deps-repo", baseURL, "Repository for dependency resolution", suites[0], components, architectures)
+	repo.SetKeyringPathsWithDirs(keyrings, keyringDirs)
+	if skipGPGVerify {
+		repo.DisableSignatureVerification()
+	}
+
+	fromCache := false
+	if cacheDir != "" {
+		_, err := repo.LoadCachedPackages(cacheDir)
+		fromCache = err == nil
+	}
+	if !fromCache {
+		if _, err := repo.FetchPackages(); err != nil {
+			return localizeError(fmt.Errorf("error retrieving packages: %w", err), localizer)
+		}
+	}
+
+	specs := []debian.PackageSpec{{Name: packageName, Version: version}}
+	resolved, err := repo.ResolveDependencies(specs, exclude)
+	if err != nil && fromCache {
+		// The cache may predate the package
+		if _, fetchErr := repo.FetchPackages(); fetchErr != nil {
+			return localizeError(fmt.Errorf("error retrieving packages: %w", fetchErr), localizer)
+		}
+		resolved, err = repo.ResolveDependencies(specs, exclude)
+	}
+	if err != nil {
+		return err
+	}
+
+	if tree {
+		fmt.Print(debian.FormatDependencyTree(resolved[packageName], resolved))
+		return nil
+	}
+
+	names := make([]string, 0, len(resolved))
+	for name := range resolved {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Printf("%s %s\n", name, resolved[name].Version)
+	}
+	return nil
+}
@@ -1,11 +1,46 @@
 package commands
 
-import "github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+import (
+	"fmt"
 
-// packageFilename returns the expected .deb filename for a package, honoring metadata when present.
-func packageFilename(pkg *debian.Package) string {
-	if pkg.Filename != "" {
-		return pkg.Filename
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// bytesPerMiB converts --shared-cache-max-size values to bytes.
+const bytesPerMiB = 1024 * 1024
+
+// applyPackageList turns config into a partial mirror limited to the packages listed
+// in the --packages-file XML file, with their dependencies when withDependencies is
+// set. Nothing changes when packagesFile is empty.
+func applyPackageList(config *debian.MirrorConfig, packagesFile string, withDependencies bool, excludeDeps string, localizer *i18n.Localizer) error {
+	if packagesFile == "" {
+		return nil
+	}
+
+	specs, err := loadPackageSpecs(packagesFile)
+	if err != nil {
+		return fmt.Errorf("invalid --packages-file: %w", err)
+	}
+	exclude, err := parseExcludeDeps(excludeDeps, localizer)
+	if err != nil {
+		return fmt.Errorf("invalid --exclude-deps value: %w", err)
+	}
+
+	config.PackageList = specs
+	config.PackageListDependencies = withDependencies
+	config.PackageListExclude = exclude
+	return nil
+}
+
+// openSharedCache opens the --shared-cache object cache, or returns nil when dir is empty.
+func openSharedCache(dir string, maxMiB int64) (*debian.ObjectCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	cache, err := debian.NewObjectCache(dir, maxMiB*bytesPerMiB)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open shared cache: %w", err)
 	}
-	return pkg.Name + "_" + pkg.Version + "_" + pkg.Architecture + ".deb"
+	return cache, nil
 }
@@ -1,126 +1,121 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"time"
+	"strings"
 
 	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/ops"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
-func CreateMirror(baseURL, suites, components, architectures, destDir string, downloadPkgs, verbose bool, keyrings, keyringDirs []string, skipGPGVerify bool, rateLimit int, localizer *i18n.Localizer) error {
-	if verbose {
+// MirrorSettings holds the settings of the mirror command that ops.MirrorOptions does
+// not carry: the output, the files CreateMirror opens and the check it runs after.
+type MirrorSettings struct {
+	Verbose           bool
+	PlanJSON          bool   // Print the dry-run plan as JSON
+	SharedCacheDir    string // Content-addressed pool cache shared with other runs, optional
+	SharedCacheMaxMiB int64  // Size limit of the shared cache, 0 for none
+	PackagesFile      string // XML list of the packages to mirror, optional
+	WithDependencies  bool   // Also mirror the dependencies of the listed packages
+	ExcludeDeps       string // Dependency kinds not followed, comma-separated
+	SkipCheck         bool   // Do not check the complete mirror with CheckRepository
+}
+
+// CreateMirror mirrors a repository into opts.DestDir. With opts.DryRun, pool files
+// are not downloaded: the download plan is printed instead (as JSON when
+// settings.PlanJSON is set). With settings.PackagesFile, only the listed packages
+// (and their dependencies when settings.WithDependencies is set) are downloaded into
+// pool/, while dists/ is mirrored unchanged. Unless settings.SkipCheck is set, a
+// complete mirror is then checked with CheckRepository; partial mirrors and dry runs
+// are not.
+func CreateMirror(opts ops.MirrorOptions, settings MirrorSettings, localizer *i18n.Localizer) error {
+	if settings.Verbose {
 		fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
 			MessageID: "command.mirror.start",
 			TemplateData: map[string]any{
-				"URL": baseURL,
+				"URL": opts.BaseURL,
 			},
 		}))
 		fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
 			MessageID: "command.mirror.details",
 			TemplateData: map[string]any{
-				"Suites":        suites,
-				"Components":    components,
-				"Architectures": architectures,
-				"Dest":          destDir,
+				"Suites":        strings.Join(opts.Suites, ","),
+				"Components":    strings.Join(opts.Components, ","),
+				"Architectures": strings.Join(opts.Architectures, ","),
+				"Dest":          opts.DestDir,
 			},
 		}))
 	}
 
-	suiteList := splitAndTrim(suites)
-	componentList := splitAndTrim(components)
-	architectureList := splitAndTrim(architectures)
-
-	if len(suiteList) == 0 {
-		return fmt.Errorf("at least one suite is required")
-	}
-	if len(componentList) == 0 {
-		return fmt.Errorf("at least one component is required")
-	}
-	if len(architectureList) == 0 {
-		return fmt.Errorf("at least one architecture is required")
+	sharedCache, err := openSharedCache(settings.SharedCacheDir, settings.SharedCacheMaxMiB)
+	if err != nil {
+		return err
 	}
+	opts.Cache = sharedCache
 
-	// Resolve keyring paths with defaults
-	resolvedKeyrings := debian.ResolveKeyringPathsExternal(keyrings, keyringDirs)
-
-	// Create mirror configuration
-	config := debian.MirrorConfig{
-		BaseURL:          baseURL,
-		Suites:           suiteList,
-		Components:       componentList,
-		Architectures:    architectureList,
-		DownloadPackages: downloadPkgs,
-		Verbose:          verbose,
-		KeyringPaths:     resolvedKeyrings,
-		SkipGPGVerify:    skipGPGVerify,
-		RateDelay:        time.Duration(rateLimit) * time.Second,
+	var packageList debian.MirrorConfig
+	if err := applyPackageList(&packageList, settings.PackagesFile, settings.WithDependencies, settings.ExcludeDeps, localizer); err != nil {
+		return err
 	}
+	opts.PackageList = packageList.PackageList
+	opts.PackageListDependencies = packageList.PackageListDependencies
+	opts.PackageListExclude = packageList.PackageListExclude
+
+	if settings.Verbose {
+		opts.Progress = func(event debian.MirrorEvent) { fmt.Println(event.Message) }
+		opts.Prepared = func(mirror *debian.Mirror) {
+			fmt.Println("=== Configuration du Miroir ===")
+			for key, value := range mirror.GetMirrorInfo() {
+				fmt.Printf("%s: %v\n", key, value)
+			}
+			fmt.Println()
+
+			fmt.Println("=== Statut du Miroir ===")
+			status, err := mirror.GetMirrorStatus()
+			if err != nil {
+				fmt.Printf("Error checking status: %v\n", err)
+			} else {
+				for key, value := range status {
+					fmt.Printf("%s: %v\n", key, value)
+				}
+			}
+			fmt.Println()
 
-	for _, suite := range suiteList {
-		repo := debian.NewRepository("mirror-validate"+suite, baseURL, "mirror validation", suite, componentList, architectureList)
-		repo.SetKeyringPathsWithDirs(keyrings, keyringDirs)
-		if skipGPGVerify {
-			repo.DisableSignatureVerification()
-		}
-
-		if err := validateComponentsAndArchitectures(repo, suite, componentList, architectureList, localizer); err != nil {
-			return fmt.Errorf("invalid suite %s: %w", suite, err)
+			fmt.Println("=== Démarrage du Miroir ===")
 		}
 	}
 
-	// Validate configuration
-	if err := config.Validate(); err != nil {
-		return fmt.Errorf("invalid configuration: %w", err)
+	result, err := ops.MirrorOperation(context.Background(), opts)
+	// The JSON plan carries the warnings itself
+	if result != nil && (!opts.DryRun || !settings.PlanJSON) {
+		defer printWarningSummary(result.Warnings, localizer)
 	}
-
-	// Create destination directory
-	if err := os.MkdirAll(destDir, debian.DirPermission); err != nil {
-		return fmt.Errorf("unable to create destination directory: %w", err)
+	if err != nil {
+		return localizeError(err, localizer)
 	}
 
-	// Create mirror
-	mirror := debian.NewMirror(config, destDir)
-
-	if verbose {
-		fmt.Println("=== Configuration du Miroir ===")
-		info := mirror.GetMirrorInfo()
-		for key, value := range info {
-			fmt.Printf("%s: %v\n", key, value)
+	if result.Plan != nil {
+		if settings.PlanJSON {
+			return result.Plan.WriteJSON(os.Stdout)
 		}
-		fmt.Println()
+		return result.Plan.WriteText(os.Stdout)
 	}
 
-	// Check current status
-	if verbose {
-		fmt.Println("=== Statut du Miroir ===")
-		status, err := mirror.GetMirrorStatus()
-		if err != nil {
-			fmt.Printf("Error checking status: %v\n", err)
-		} else {
-			for key, value := range status {
-				fmt.Printf("%s: %v\n", key, value)
-			}
+	if opts.DownloadPackages && settings.PackagesFile == "" && !settings.SkipCheck {
+		if err := CheckRepository(opts.DestDir, localizer); err != nil {
+			return err
 		}
-		fmt.Println()
-	}
-
-	// Start mirroring
-	if verbose {
-		fmt.Println("=== Démarrage du Miroir ===")
-	}
-
-	if err := mirror.Clone(); err != nil {
-		return fmt.Errorf("failed to create mirror: %w", err)
 	}
 
-	if verbose {
+	if settings.Verbose {
 		fmt.Println("✓ Miroir créé avec succès!")
 
 		// Show final status
 		fmt.Println("\n=== Statut Final ===")
-		status, err := mirror.GetMirrorStatus()
+		status, err := result.Mirror.GetMirrorStatus()
 		if err == nil {
 			for key, value := range status {
 				fmt.Printf("%s: %v\n", key, value)
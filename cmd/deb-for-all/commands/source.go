@@ -8,7 +8,7 @@ import (
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
-func DownloadSourcePackage(packageName, version, baseURL string, suites, components, architectures []string, destDir string, origOnly, silent bool, keyrings, keyringDirs []string, skipGPGVerify bool, localizer *i18n.Localizer) error {
+func DownloadSourcePackage(packageName, version, baseURL string, suites, components []string, destDir string, origOnly, silent bool, keyrings, keyringDirs []string, skipGPGVerify bool, localizer *i18n.Localizer) error {
 	if !silent {
 		fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
 			MessageID: "command.download.start",
@@ -34,25 +34,31 @@ func DownloadSourcePackage(packageName, version, baseURL string, suites, compone
 	if len(components) == 0 {
 		components = []string{"main"}
 	}
-	if len(architectures) == 0 {
-		architectures = []string{"source"}
-	}
 	if baseURL == "" {
 		baseURL = "http://deb.debian.org/debian"
 	}
 
-	repo := debian.NewRepository(
-		"download-source-repo",
-		baseURL,
-		"Repository for source package download",
-		suites[0],
-		components,
-		architectures,
-	)
+	// The suites are searched in order, so that a version published in only one of
+	// them, e.g. bookworm-security, is found too
+	repos := make([]*debian.Repository, len(suites))
+	for i, suite := range suites {
+		repo := debian.NewSourceRepository(
+			"download-source-repo",
+			baseURL,
+			"Repository for source package download",
+			suite,
+			components,
+		)
+
+		repo.SetKeyringPathsWithDirs(keyrings, keyringDirs)
+		if skipGPGVerify {
+			repo.DisableSignatureVerification()
+		}
 
-	repo.SetKeyringPathsWithDirs(keyrings, keyringDirs)
-	if skipGPGVerify {
-		repo.DisableSignatureVerification()
+		if err := validateComponentsAndArchitectures(repo, suite, false, localizer); err != nil {
+			return err
+		}
+		repos[i] = repo
 	}
 
 	if !silent {
@@ -63,13 +69,21 @@ func DownloadSourcePackage(packageName, version, baseURL string, suites, compone
 		fmt.Println("...")
 	}
 
-	if _, err := repo.FetchSources(); err != nil {
-		return fmt.Errorf("error retrieving source packages: %w", err)
+	for _, repo := range repos {
+		if _, err := repo.FetchSources(); err != nil {
+			return fmt.Errorf("error retrieving source packages for suite %s: %w", repo.Suite, err)
+		}
 	}
 
-	sourcePackage, err := repo.GetSourcePackageMetadata(packageName, version)
+	sourcePackage, suite, err := debian.NewRepositorySet(repos...).FindSourcePackage(packageName, version)
 	if err != nil {
-		return fmt.Errorf("error retrieving metadata for source package %s: %w", packageName, err)
+		return localizeError(fmt.Errorf("error retrieving metadata for source package %s: %w", packageName, err), localizer)
+	}
+	if !silent && len(suites) > 1 {
+		fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "command.download.found_in",
+			TemplateData: map[string]any{"Package": sourcePackage.Name, "Version": sourcePackage.Version, "Suite": suite},
+		}))
 	}
 
 	if version == "" {
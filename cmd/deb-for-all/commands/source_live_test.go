@@ -0,0 +1,41 @@
+//go:build live
+
+package commands
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// These tests run against deb.debian.org; run them with go test -tags live.
+
+func TestDownloadSourcePackageOrigOnlyLive(t *testing.T) {
+	localizer := newTestLocalizerSource(t)
+	destDir := t.TempDir()
+	defer silenceStdoutSource(t)()
+
+	if err := DownloadSourcePackage(
+		"hello",
+		"",
+		"http://deb.debian.org/debian",
+		[]string{"bookworm"},
+		[]string{"main"},
+		destDir,
+		true,
+		true,
+		nil,
+		nil,
+		false,
+		localizer,
+	); err != nil {
+		t.Fatalf("download source (orig-only) failed: %v", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(destDir, "hello_*orig.tar.*"))
+	if err != nil {
+		t.Fatalf("glob failed: %v", err)
+	}
+	if len(matches) == 0 {
+		t.Fatalf("expected orig tarball in %s", destDir)
+	}
+}
@@ -1,32 +1,47 @@
 package commands
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/BurntSushi/toml"
+	"github.com/CeGenreDeChat/deb-for-all/internal/testsupport"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"golang.org/x/text/language"
 )
 
 func TestDownloadSourcePackageOrigOnlyIntegration(t *testing.T) {
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not available")
+	}
+
 	localizer := newTestLocalizerSource(t)
+	repo := testsupport.NewRepository(t, testsupport.Config{
+		Sources: []testsupport.Source{{Name: "hello", Version: "2.10-3"}},
+		Sign:    true,
+	})
 	destDir := t.TempDir()
 	defer silenceStdoutSource(t)()
 
 	if err := DownloadSourcePackage(
 		"hello",
 		"",
-		"http://deb.debian.org/debian",
+		repo.URL,
 		[]string{"bookworm"},
 		[]string{"main"},
-		[]string{"source"},
 		destDir,
 		true,
 		true,
-		nil,
+		[]string{repo.KeyringPath},
 		nil,
 		false,
 		localizer,
@@ -38,8 +53,52 @@ func TestDownloadSourcePackageOrigOnlyIntegration(t *testing.T) {
 	if err != nil {
 		t.Fatalf("glob failed: %v", err)
 	}
-	if len(matches) == 0 {
-		t.Fatalf("expected orig tarball in %s", destDir)
+	if len(matches) != 1 {
+		t.Fatalf("expected orig tarball in %s, got %v", destDir, matches)
+	}
+	if others, _ := filepath.Glob(filepath.Join(destDir, "hello_*.dsc")); len(others) != 0 {
+		t.Fatalf("orig-only download should skip the .dsc, got %v", others)
+	}
+}
+
+func TestDownloadSourcePackageStrictValidation(t *testing.T) {
+	localizer := newTestLocalizerSource(t)
+	defer silenceStdoutSource(t)()
+
+	orig := "fake upstream tarball"
+	sources := fmt.Sprintf("Package: hello\nVersion: 2.10-3\nDirectory: pool/main/h/hello\nFiles:\n %x %d hello_2.10.orig.tar.gz\nChecksums-Sha256:\n %x %d hello_2.10.orig.tar.gz\n\n",
+		md5.Sum([]byte(orig)), len(orig), sha256.Sum256([]byte(orig)), len(orig))
+	// Release never lists "source" among its architectures
+	release := fmt.Sprintf("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64 arm64\nComponents: main contrib\nSHA256:\n %x %d main/source/Sources\n", sha256.Sum256([]byte(sources)), len(sources))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release":
+			io.WriteString(w, release)
+		case "/dists/bookworm/main/source/Sources":
+			io.WriteString(w, sources)
+		case "/pool/main/h/hello/hello_2.10.orig.tar.gz":
+			io.WriteString(w, orig)
+		default:
+			if strings.Contains(r.URL.Path, "binary-") {
+				t.Errorf("unexpected binary index request: %s", r.URL.Path)
+			}
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	destDir := t.TempDir()
+	if err := DownloadSourcePackage("hello", "", server.URL, []string{"bookworm"}, []string{"main"}, destDir, true, true, nil, nil, true, localizer); err != nil {
+		t.Fatalf("download source failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destDir, "hello_2.10.orig.tar.gz")); err != nil || string(data) != orig {
+		t.Fatalf("expected the orig tarball in %s: %v", destDir, err)
+	}
+
+	err := DownloadSourcePackage("hello", "", server.URL, []string{"bookworm"}, []string{"non-free"}, t.TempDir(), true, true, nil, nil, true, localizer)
+	if err == nil || !strings.Contains(err.Error(), "non-free") {
+		t.Fatalf("expected strict validation to reject an unknown component, got %v", err)
 	}
 }
 
@@ -1,26 +1,28 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
-	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/ops"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
-func UpdateCache(baseURL, suites, components, architectures, cacheDir string, verbose bool, keyrings, keyringDirs []string, skipGPGVerify bool, localizer *i18n.Localizer) error {
-	suiteList := splitAndTrim(suites)
-	componentList := splitAndTrim(components)
-	architectureList := splitAndTrim(architectures)
-
-	if len(suiteList) == 0 {
-		return fmt.Errorf("at least one suite is required")
-	}
-	if len(componentList) == 0 {
-		return fmt.Errorf("at least one component is required")
-	}
-	if len(architectureList) == 0 {
-		return fmt.Errorf("at least one architecture is required")
+func UpdateCache(baseURL, suites, components, architectures, cacheDir string, verbose bool, keyrings, keyringDirs []string, skipGPGVerify bool, requireFingerprint string, lenient bool, localizer *i18n.Localizer) error {
+	opts := ops.UpdateOptions{
+		Source: ops.Source{
+			BaseURL:            baseURL,
+			Suites:             splitAndTrim(suites),
+			Components:         splitAndTrim(components),
+			Architectures:      splitAndTrim(architectures),
+			Keyrings:           keyrings,
+			KeyringDirs:        keyringDirs,
+			SkipGPGVerify:      skipGPGVerify,
+			RequireFingerprint: requireFingerprint,
+			Lenient:            lenient,
+		},
+		CacheDir: cacheDir,
 	}
 
 	if verbose {
@@ -28,26 +30,13 @@ func UpdateCache(baseURL, suites, components, architectures, cacheDir string, ve
 			MessageID: "command.update.start",
 			TemplateData: map[string]any{
 				"URL":           baseURL,
-				"Suites":        strings.Join(suiteList, ","),
-				"Components":    strings.Join(componentList, ","),
-				"Architectures": strings.Join(architectureList, ","),
+				"Suites":        strings.Join(opts.Suites, ","),
+				"Components":    strings.Join(opts.Components, ","),
+				"Architectures": strings.Join(opts.Architectures, ","),
 				"Dest":          cacheDir,
 			},
 		}))
-	}
-
-	for _, suite := range suiteList {
-		repo := debian.NewRepository("cache-"+suite, baseURL, "cache update", suite, componentList, architectureList)
-		repo.SetKeyringPathsWithDirs(keyrings, keyringDirs)
-		if skipGPGVerify {
-			repo.DisableSignatureVerification()
-		}
-
-		if err := validateComponentsAndArchitectures(repo, suite, componentList, architectureList, localizer); err != nil {
-			return fmt.Errorf("validation failed for suite %s: %w", suite, err)
-		}
-
-		if verbose {
+		opts.OnSuite = func(suite string) {
 			fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
 				MessageID: "command.update.suite",
 				TemplateData: map[string]any{
@@ -55,10 +44,14 @@ func UpdateCache(baseURL, suites, components, architectures, cacheDir string, ve
 				},
 			}))
 		}
+	}
 
-		if err := repo.FetchAndCachePackages(cacheDir); err != nil {
-			return fmt.Errorf("failed to update cache for suite %s: %w", suite, err)
-		}
+	result, err := ops.UpdateOperation(context.Background(), opts)
+	if result != nil {
+		defer printWarningSummary(result.Warnings, localizer)
+	}
+	if err != nil {
+		return localizeError(err, localizer)
 	}
 
 	if verbose {
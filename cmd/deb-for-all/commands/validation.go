@@ -1,59 +1,79 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/ops"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 )
 
-func validateComponentsAndArchitectures(repo *debian.Repository, suite string, components, architectures []string, localizer *i18n.Localizer) error {
-	if err := ensureReleaseInfo(repo, localizer); err != nil {
-		return fmt.Errorf("suite %s: %w", suite, err)
-	}
-
-	info := repo.GetReleaseInfo()
-	unknownComponents := findUnknownValues(components, info.Components)
-	unknownArchitectures := findUnknownValues(architectures, info.Architectures)
-
-	var parts []string
-	if len(unknownComponents) > 0 {
-		parts = append(parts, localizeValidation(localizer, "error.validation.unknown_components", fmt.Sprintf("unknown components: %s (available: %s)", strings.Join(unknownComponents, ", "), strings.Join(info.Components, ", ")), map[string]any{
-			"Unknown":   strings.Join(unknownComponents, ", "),
-			"Available": strings.Join(info.Components, ", "),
-		}))
-	}
-	if len(unknownArchitectures) > 0 {
-		parts = append(parts, localizeValidation(localizer, "error.validation.unknown_architectures", fmt.Sprintf("unknown architectures: %s (available: %s)", strings.Join(unknownArchitectures, ", "), strings.Join(info.Architectures, ", ")), map[string]any{
-			"Unknown":   strings.Join(unknownArchitectures, ", "),
-			"Available": strings.Join(info.Architectures, ", "),
-		}))
+// localizeError translates the typed errors of ops that reach users: a Release file
+// that could not be fetched or that the configuration does not match, and a package
+// missing for the requested architecture. The context the operation added, such as
+// "invalid suite bookworm: ", is kept; other errors are returned unchanged.
+func localizeError(err error, localizer *i18n.Localizer) error {
+	var mismatch *debian.ReleaseMismatchError
+	var fetchErr *ops.ReleaseFetchError
+	var archErr *ops.ArchUnavailableError
+	var versionErr *debian.VersionNotFoundError
+	var cause error
+	var msg string
+	switch {
+	case errors.As(err, &mismatch):
+		cause, msg = mismatch, localizeMismatch(mismatch, localizer)
+	case errors.As(err, &fetchErr) && errors.Is(fetchErr, ops.ErrReleaseUnavailable):
+		cause, msg = fetchErr, localizeValidation(localizer, "error.validation.release_unavailable", "Release information unavailable for validation", nil)
+	case errors.As(err, &fetchErr):
+		cause, msg = fetchErr, localizeValidation(localizer, "error.validation.fetch_release", "failed to fetch Release file", nil)+": "+fetchErr.Err.Error()
+	case errors.As(err, &archErr):
+		cause, msg = archErr, localizeValidation(localizer, "error.download.arch_unavailable", archErr.Error(), map[string]any{
+			"Package":   archErr.Package,
+			"Arch":      archErr.Arch,
+			"Available": strings.Join(archErr.Available, ", "),
+		})
+	case errors.As(err, &versionErr):
+		available := make([]string, len(versionErr.Available))
+		for i, suite := range versionErr.Available {
+			available[i] = suite.Suite + ": " + strings.Join(suite.Versions, ", ")
+		}
+		cause, msg = versionErr, localizeValidation(localizer, "error.download.version_not_found", versionErr.Error(), map[string]any{
+			"Package":   versionErr.Package,
+			"Version":   versionErr.Version,
+			"Available": strings.Join(available, "; "),
+		})
+	default:
+		return err
 	}
+	return errors.New(strings.TrimSuffix(err.Error(), cause.Error()) + msg)
+}
 
-	if len(parts) > 0 {
-		return fmt.Errorf("%s", strings.Join(parts, " ; "))
+// validateComponentsAndArchitectures checks the repository configuration against the
+// suite's Release file, see ops.ValidateSuite, and localizes the error.
+func validateComponentsAndArchitectures(repo *debian.Repository, suite string, lenient bool, localizer *i18n.Localizer) error {
+	if err := ops.ValidateSuite(repo, lenient); err != nil {
+		return localizeError(&ops.SuiteError{Suite: suite, Err: err}, localizer)
 	}
-
 	return nil
 }
 
-func ensureReleaseInfo(repo *debian.Repository, localizer *i18n.Localizer) error {
-	if repo.GetReleaseInfo() != nil {
-		return nil
-	}
-
-	if err := repo.FetchReleaseFile(); err != nil {
-		msg := localizeValidation(localizer, "error.validation.fetch_release", "failed to fetch Release file", nil)
-		return fmt.Errorf("%s: %w", msg, err)
+func localizeMismatch(mismatch *debian.ReleaseMismatchError, localizer *i18n.Localizer) string {
+	var parts []string
+	if len(mismatch.UnknownComponents) > 0 {
+		parts = append(parts, localizeValidation(localizer, "error.validation.unknown_components", fmt.Sprintf("unknown components: %s (available: %s)", strings.Join(mismatch.UnknownComponents, ", "), strings.Join(mismatch.AvailableComponents, ", ")), map[string]any{
+			"Unknown":   strings.Join(mismatch.UnknownComponents, ", "),
+			"Available": strings.Join(mismatch.AvailableComponents, ", "),
+		}))
 	}
-
-	if repo.GetReleaseInfo() == nil {
-		msg := localizeValidation(localizer, "error.validation.release_unavailable", "Release information unavailable for validation", nil)
-		return fmt.Errorf("%s", msg)
+	if len(mismatch.UnknownArchitectures) > 0 {
+		parts = append(parts, localizeValidation(localizer, "error.validation.unknown_architectures", fmt.Sprintf("unknown architectures: %s (available: %s)", strings.Join(mismatch.UnknownArchitectures, ", "), strings.Join(mismatch.AvailableArchitectures, ", ")), map[string]any{
+			"Unknown":   strings.Join(mismatch.UnknownArchitectures, ", "),
+			"Available": strings.Join(mismatch.AvailableArchitectures, ", "),
+		}))
 	}
-
-	return nil
+	return strings.Join(parts, " ; ")
 }
 
 func localizeValidation(localizer *i18n.Localizer, messageID, fallback string, data map[string]any) string {
@@ -68,26 +88,3 @@ func localizeValidation(localizer *i18n.Localizer, messageID, fallback string, d
 
 	return fallback
 }
-
-func findUnknownValues(values, allowed []string) []string {
-	allowedSet := make(map[string]struct{}, len(allowed))
-	for _, item := range allowed {
-		key := strings.ToLower(strings.TrimSpace(item))
-		if key != "" {
-			allowedSet[key] = struct{}{}
-		}
-	}
-
-	var unknown []string
-	for _, value := range values {
-		key := strings.ToLower(strings.TrimSpace(value))
-		if key == "" {
-			continue
-		}
-		if _, ok := allowedSet[key]; !ok {
-			unknown = append(unknown, value)
-		}
-	}
-
-	return unknown
-}
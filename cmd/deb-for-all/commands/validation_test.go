@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"golang.org/x/text/language"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/ops"
+)
+
+func TestLocalizeError(t *testing.T) {
+	localizer := newTestLocalizerCustom(t)
+	plain := fmt.Errorf("failed to create mirror: %w", os.ErrNotExist)
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			"mismatch",
+			fmt.Errorf("invalid %w", &ops.SuiteError{Suite: "bookworm", Err: &debian.ReleaseMismatchError{UnknownComponents: []string{"contrib"}, AvailableComponents: []string{"main"}}}),
+			"invalid suite bookworm: Unknown components: contrib (available: main)",
+		},
+		{
+			"fetch",
+			&ops.SuiteError{Suite: "trixie", Err: &ops.ReleaseFetchError{Err: os.ErrNotExist}},
+			"suite trixie: Failed to fetch Release file: file does not exist",
+		},
+		{
+			"arch",
+			fmt.Errorf("error retrieving metadata for package hello: %w", &ops.ArchUnavailableError{Package: "hello", Arch: "i386", Available: []string{"amd64", "arm64"}}),
+			"error retrieving metadata for package hello: Package hello is not available for architecture i386 (available: amd64, arm64)",
+		},
+		{"other", plain, plain.Error()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := localizeError(tc.err, localizer).Error(); got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func newTestLocalizerCustom(t *testing.T) *i18n.Localizer {
+	bundle := i18n.NewBundle(language.English)
+	bundle.RegisterUnmarshalFunc("toml", toml.Unmarshal)
+
+	must := func(_ *i18n.MessageFile, err error) {
+		if err != nil {
+			t.Fatalf("failed to load locale: %v", err)
+		}
+	}
+
+	must(bundle.LoadMessageFile("../locales/en.toml"))
+	must(bundle.LoadMessageFile("../locales/fr.toml"))
+
+	return i18n.NewLocalizer(bundle, "en")
+}
+
+func silenceStdoutCustom(t *testing.T) func() {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to silence stdout: %v", err)
+	}
+
+	original := os.Stdout
+	os.Stdout = writer
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, reader)
+		close(done)
+	}()
+
+	return func() {
+		_ = writer.Close()
+		<-done
+		os.Stdout = original
+	}
+}
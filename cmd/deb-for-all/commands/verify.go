@@ -0,0 +1,102 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// VerifyMirror checks the mirror in destDir against the upstream Release file. With
+// deep, every .deb listed in the local Packages indices is also checked in the pool,
+// without downloading anything; missing or corrupted files make the command fail.
+// For a partial mirror built with packagesFile, unlisted files are reported as
+// omitted and do not fail the command. With includeInstaller, the debian-installer
+// indices are checked too, and so are the AppStream metadata with includeDEP11 and
+// the files matching extraIndices, or every file of the Release file with allIndices,
+// filtered by indexInclude and indexExclude.
+func VerifyMirror(baseURL, suites, components, architectures, destDir string, deep, verbose bool, keyrings, keyringDirs []string, skipGPGVerify, includeInstaller, includeDEP11 bool, extraIndices string, allIndices bool, indexInclude, indexExclude string, packagesFile string, withDependencies bool, excludeDeps string, localizer *i18n.Localizer) error {
+	suiteList := splitAndTrim(suites)
+	componentList := splitAndTrim(components)
+	architectureList := splitAndTrim(architectures)
+
+	if len(suiteList) == 0 {
+		return fmt.Errorf("at least one suite is required")
+	}
+	if len(componentList) == 0 {
+		return fmt.Errorf("at least one component is required")
+	}
+	if len(architectureList) == 0 {
+		return fmt.Errorf("at least one architecture is required")
+	}
+
+	config := debian.MirrorConfig{
+		BaseURL:             baseURL,
+		Suites:              suiteList,
+		Components:          componentList,
+		Architectures:       architectureList,
+		Verbose:             verbose,
+		KeyringPaths:        debian.ResolveKeyringPathsExternal(keyrings, keyringDirs),
+		SkipGPGVerify:       skipGPGVerify,
+		IncludeInstaller:    includeInstaller,
+		IncludeDEP11:        includeDEP11,
+		IncludeExtraIndices: splitAndTrim(extraIndices),
+		MirrorAllIndices:    allIndices,
+		IndexInclude:        splitAndTrim(indexInclude),
+		IndexExclude:        splitAndTrim(indexExclude),
+	}
+	if err := applyPackageList(&config, packagesFile, withDependencies, excludeDeps, localizer); err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	mirror := debian.NewMirror(config, destDir)
+
+	failures := 0
+	for _, suite := range suiteList {
+		if err := mirror.VerifyMirrorIntegrity(suite); err != nil {
+			return fmt.Errorf("suite %s: %w", suite, err)
+		}
+		if !deep {
+			continue
+		}
+
+		for _, component := range config.GetComponentsForSuite(suite) {
+			for _, arch := range mirror.ArchitecturesForSuite(suite) {
+				report, err := mirror.VerifyLocalPackages(suite, component, arch)
+				if err != nil {
+					return err
+				}
+
+				fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
+					MessageID: "command.verify.report",
+					TemplateData: map[string]any{
+						"Suite":     suite,
+						"Component": component,
+						"Arch":      arch,
+						"Checked":   report.Checked,
+						"Valid":     report.Valid,
+						"Missing":   report.Missing,
+						"Corrupted": report.Corrupted,
+						"Omitted":   report.Omitted,
+					},
+				}))
+				for _, verr := range report.Errors {
+					fmt.Printf("  ✗ %v\n", verr)
+				}
+				failures += len(report.Errors)
+			}
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%s", localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "error.verify.failed",
+			TemplateData: map[string]any{"Count": failures},
+		}))
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+func TestVerifyMirrorDeepReportsTamperedDeb(t *testing.T) {
+	localizer := newTestLocalizerCustom(t)
+	defer silenceStdoutCustom(t)()
+
+	payload := "payload"
+	packages := fmt.Sprintf("Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\nSize: %d\nSHA256: %x\n\n", len(payload), sha256.Sum256([]byte(payload)))
+	release := fmt.Sprintf("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dists/bookworm/Release" {
+			io.WriteString(w, release)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/pool/") {
+			t.Errorf("verify must not download pool files: %s", r.URL.Path)
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	mirrorDir := t.TempDir()
+	write := func(relPath, content string) {
+		path := filepath.Join(mirrorDir, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(path), debian.DirPermission); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), debian.FilePermission); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	write("dists/bookworm/main/binary-amd64/Packages", packages)
+	write("pool/main/h/hello/hello_2.10-3_amd64.deb", payload)
+
+	verify := func(deep bool) error {
+		return VerifyMirror(server.URL, "bookworm", "main", "amd64", mirrorDir, deep, false, nil, nil, true, false, false, "", false, "", "", "", false, "", localizer)
+	}
+
+	if err := verify(true); err != nil {
+		t.Fatalf("expected an intact mirror to verify, got %v", err)
+	}
+
+	write("pool/main/h/hello/hello_2.10-3_amd64.deb", "tamper!")
+	if err := verify(false); err != nil {
+		t.Fatalf("expected the shallow check to ignore pool files, got %v", err)
+	}
+	if err := verify(true); err == nil || !strings.Contains(err.Error(), "1 pool file") {
+		t.Fatalf("expected --deep to report the tampered file, got %v", err)
+	}
+}
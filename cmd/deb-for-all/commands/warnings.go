@@ -0,0 +1,23 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// printWarningSummary lists the non-fatal warnings recorded during a command, if any.
+func printWarningSummary(warnings []debian.Warning, localizer *i18n.Localizer) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	fmt.Println(localizer.MustLocalize(&i18n.LocalizeConfig{
+		MessageID:    "command.warnings.summary",
+		TemplateData: map[string]any{"Count": len(warnings)},
+	}))
+	for _, warning := range warnings {
+		fmt.Printf("  - %s\n", warning)
+	}
+}
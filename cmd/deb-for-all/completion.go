@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+// Values completed for --suites and --components; other values are still accepted.
+var (
+	commonSuites = []string{
+		"stable", "testing", "unstable", "oldstable", "experimental",
+		"bullseye", "bookworm", "trixie", "forky", "sid",
+		"bookworm-updates", "bookworm-backports", "trixie-updates", "trixie-backports",
+	}
+	commonComponents = []string{"main", "contrib", "non-free", "non-free-firmware"}
+)
+
+// registerCompletions registers the completion of the value flags of cmd that take
+// suites, components, architectures or a package name.
+func registerCompletions(cmd *cobra.Command) {
+	completions := map[string]cobra.CompletionFunc{
+		"suites":        completeList(commonSuites),
+		"components":    completeList(commonComponents),
+		"architectures": completeList(append(slices.Clone(debian.KnownArchitectures), "all-available", "host")),
+		"arch":          completeValues(debian.KnownArchitectures),
+		"package":       completePackageName,
+	}
+	for name, complete := range completions {
+		if cmd.Flags().Lookup(name) != nil {
+			cmd.RegisterFlagCompletionFunc(name, complete)
+		}
+	}
+}
+
+// completeValues completes a single value among values.
+func completeValues(values []string) cobra.CompletionFunc {
+	return cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp)
+}
+
+// completeList completes the last item of a comma-separated list among values, leaving
+// out those already listed.
+func completeList(values []string) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		listed, last := "", toComplete
+		if i := strings.LastIndex(toComplete, ","); i >= 0 {
+			listed, last = toComplete[:i+1], toComplete[i+1:]
+		}
+		previous := strings.Split(listed, ",")
+
+		var candidates []string
+		for _, value := range values {
+			if strings.HasPrefix(value, last) && !slices.Contains(previous, value) {
+				candidates = append(candidates, listed+value)
+			}
+		}
+		return candidates, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+}
+
+// completePackageName completes package names from the cache of the suites given on
+// the command line, as written by the update command.
+func completePackageName(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var candidates []string
+	for _, suite := range parseList(config.Suites) {
+		names, err := debian.CachedPackageNames(config.CacheDir, suite, toComplete)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if !slices.Contains(candidates, name) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	return candidates, cobra.ShellCompDirectiveNoFileComp
+}
+
+// writeCompletion writes the completion script of shell to stdout.
+func writeCompletion(shell string) error {
+	switch shell {
+	case "bash":
+		return rootCmd.GenBashCompletionV2(os.Stdout, true)
+	case "zsh":
+		return rootCmd.GenZshCompletion(os.Stdout)
+	case "fish":
+		return rootCmd.GenFishCompletion(os.Stdout, true)
+	default:
+		return errors.New(localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "error.completion_shell",
+			TemplateData: map[string]any{"Shell": shell},
+		}))
+	}
+}
+
+// generateDocs writes the man pages and the markdown reference of every command under
+// dir, in man/ and markdown/.
+func generateDocs(dir string) error {
+	manDir := filepath.Join(dir, "man")
+	markdownDir := filepath.Join(dir, "markdown")
+	for _, d := range []string{manDir, markdownDir} {
+		if err := os.MkdirAll(d, debian.DirPermission); err != nil {
+			return err
+		}
+	}
+
+	rootCmd.DisableAutoGenTag = true
+	// The default cache is under the home of whoever generates the pages
+	rootCmd.PersistentFlags().Lookup("cache").DefValue = "$XDG_CACHE_HOME/deb-for-all"
+	header := &doc.GenManHeader{Title: "DEB-FOR-ALL", Section: "1", Source: "deb-for-all"}
+	if err := doc.GenManTree(rootCmd, header, manDir); err != nil {
+		return err
+	}
+	return doc.GenMarkdownTree(rootCmd, markdownDir)
+}
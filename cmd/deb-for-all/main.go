@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/CeGenreDeChat/deb-for-all/cmd/deb-for-all/commands"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/ops"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/spf13/cobra"
 	"golang.org/x/text/language"
@@ -19,28 +22,60 @@ var localesFS embed.FS
 
 // Config globale pour stocker les arguments
 type Config struct {
-	Command        string
-	PackageName    string
-	Version        string
-	DestDir        string
-	CacheDir       string
-	Keyrings       string
-	KeyringDirs    string
-	NoGPGVerify    bool
-	PackagesXML    string
-	ExcludeDeps    string
-	OrigOnly       bool
-	Silent         bool
-	BaseURL        string
-	Suites         string
-	Components     string
-	Architectures  string
-	MetadataOnly   bool
-	Verbose        bool
-	RateLimit      int
-	IncludeSources bool
-	GPGKeyPath     string
-	GPGPassphrase  string
+	Command             string
+	PackageName         string
+	Version             string
+	DestDir             string
+	CacheDir            string
+	Keyrings            string
+	KeyringDirs         string
+	NoGPGVerify         bool
+	PackagesXML         string
+	ExcludeDeps         string
+	OrigOnly            bool
+	Silent              bool
+	BaseURL             string
+	Suites              string
+	Components          string
+	Architectures       string
+	Arch                string
+	MetadataOnly        bool
+	Verbose             bool
+	RateLimit           int
+	IncludeSources      bool
+	GPGKeyPath          string
+	GPGPassphrase       string
+	DryRun              bool
+	WriteMetadata       bool
+	PlanJSON            bool
+	SharedCache         string
+	SharedCacheMax      int64
+	DebFile             string
+	Lenient             bool
+	NoResolveCache      bool
+	Deep                bool
+	PackagesFile        string
+	WithDeps            bool
+	SkipMissing         bool
+	RequireFingerprint  string
+	InstallScript       bool
+	IncludeInstaller    bool
+	IncludeUncompressed bool
+	IncludeDEP11        bool
+	ExtraIndices        string
+	AllIndices          bool
+	IndexInclude        string
+	IndexExclude        string
+	Shell               string
+	DocsDir             string
+	KeepGoing           bool
+	RetryFailed         string
+	SkipCheck           bool
+	RootDir             string
+	Tree                bool
+	SuiteConflict       string
+	ConfigFile          string
+	Concurrency         int
 }
 
 var (
@@ -78,18 +113,99 @@ func run() error {
 	suites := parseList(config.Suites)
 	components := parseList(config.Components)
 	architectures := parseList(config.Architectures)
+	source := ops.Source{
+		BaseURL:            config.BaseURL,
+		Suites:             suites,
+		Components:         components,
+		Architectures:      architectures,
+		Keyrings:           keyrings,
+		KeyringDirs:        keyringDirs,
+		SkipGPGVerify:      config.NoGPGVerify,
+		RequireFingerprint: config.RequireFingerprint,
+		Lenient:            config.Lenient,
+	}
 
 	switch strings.ToLower(config.Command) {
 	case "download":
-		return commands.DownloadBinaryPackage(config.PackageName, config.Version, config.BaseURL, suites, components, architectures, config.DestDir, config.CacheDir, config.Silent, keyrings, keyringDirs, config.NoGPGVerify, localizer)
+		return commands.DownloadBinaryPackage(config.PackageName, config.Version, config.BaseURL, suites, components, architectures, config.Arch, config.DestDir, config.CacheDir, config.Silent, keyrings, keyringDirs, config.NoGPGVerify, localizer)
+	case "deps":
+		return commands.ShowDependencies(config.PackageName, config.Version, config.BaseURL, suites, components, architectures, config.CacheDir, config.ExcludeDeps, config.Tree, keyrings, keyringDirs, config.NoGPGVerify, localizer)
 	case "download-source":
-		return commands.DownloadSourcePackage(config.PackageName, config.Version, config.BaseURL, suites, components, architectures, config.DestDir, config.OrigOnly, config.Silent, keyrings, keyringDirs, config.NoGPGVerify, localizer)
+		return commands.DownloadSourcePackage(config.PackageName, config.Version, config.BaseURL, suites, components, config.DestDir, config.OrigOnly, config.Silent, keyrings, keyringDirs, config.NoGPGVerify, localizer)
 	case "mirror":
-		return commands.CreateMirror(config.BaseURL, config.Suites, config.Components, config.Architectures, config.DestDir, !config.MetadataOnly, config.Verbose, keyrings, keyringDirs, config.NoGPGVerify, config.RateLimit, localizer)
+		opts := ops.MirrorOptions{
+			Source:              source,
+			DestDir:             config.DestDir,
+			DownloadPackages:    !config.MetadataOnly,
+			RateDelay:           time.Duration(config.RateLimit) * time.Second,
+			DryRun:              config.DryRun,
+			WriteMetadata:       config.WriteMetadata,
+			SkipMissing:         config.SkipMissing,
+			IncludeInstaller:    config.IncludeInstaller,
+			IncludeUncompressed: config.IncludeUncompressed,
+			IncludeDEP11:        config.IncludeDEP11,
+			ExtraIndices:        parseList(config.ExtraIndices),
+			AllIndices:          config.AllIndices,
+			IndexInclude:        parseList(config.IndexInclude),
+			IndexExclude:        parseList(config.IndexExclude),
+		}
+		return commands.CreateMirror(opts, commands.MirrorSettings{
+			Verbose:           config.Verbose,
+			PlanJSON:          config.PlanJSON,
+			SharedCacheDir:    config.SharedCache,
+			SharedCacheMaxMiB: config.SharedCacheMax,
+			PackagesFile:      config.PackagesFile,
+			WithDependencies:  config.WithDeps,
+			ExcludeDeps:       config.ExcludeDeps,
+			SkipCheck:         config.SkipCheck,
+		}, localizer)
 	case "update":
-		return commands.UpdateCache(config.BaseURL, config.Suites, config.Components, config.Architectures, config.CacheDir, config.Verbose, keyrings, keyringDirs, config.NoGPGVerify, localizer)
+		return commands.UpdateCache(config.BaseURL, config.Suites, config.Components, config.Architectures, config.CacheDir, config.Verbose, keyrings, keyringDirs, config.NoGPGVerify, config.RequireFingerprint, config.Lenient, localizer)
 	case "custom-repo":
-		return commands.BuildCustomRepository(config.BaseURL, config.Suites, config.Components, config.Architectures, config.DestDir, config.PackagesXML, config.ExcludeDeps, keyrings, keyringDirs, config.NoGPGVerify, config.Verbose, config.RateLimit, config.IncludeSources, config.GPGKeyPath, config.GPGPassphrase, localizer)
+		resolveCacheDir := config.CacheDir
+		if config.NoResolveCache {
+			resolveCacheDir = ""
+		}
+		opts := ops.CustomRepoOptions{
+			Source:              source,
+			DestDir:             config.DestDir,
+			IncludeSources:      config.IncludeSources,
+			InstallScript:       config.InstallScript,
+			IncludeUncompressed: config.IncludeUncompressed,
+			RateDelay:           time.Duration(config.RateLimit) * time.Second,
+			DryRun:              config.DryRun,
+			WriteMetadata:       config.WriteMetadata,
+			KeepGoing:           config.KeepGoing,
+		}
+		if config.GPGKeyPath != "" {
+			opts.Signing = &debian.ReleaseSigningConfig{
+				PrivateKeyPath: config.GPGKeyPath,
+				Passphrase:     config.GPGPassphrase,
+			}
+		}
+		_, err := commands.BuildCustomRepository(opts, commands.CustomRepoSettings{
+			Verbose:           config.Verbose,
+			PlanJSON:          config.PlanJSON,
+			PackagesXML:       config.PackagesXML,
+			ExcludeDeps:       config.ExcludeDeps,
+			RetryFailed:       config.RetryFailed,
+			SharedCacheDir:    config.SharedCache,
+			SharedCacheMaxMiB: config.SharedCacheMax,
+			ResolveCacheDir:   resolveCacheDir,
+			SkipCheck:         config.SkipCheck,
+			SuiteConflict:     config.SuiteConflict,
+		}, localizer)
+		return err
+	case "contents":
+		return commands.ListDebContents(config.DebFile, localizer)
+	case "completion":
+		return writeCompletion(config.Shell)
+	case "gen-docs":
+		return generateDocs(config.DocsDir)
+	case "verify":
+		return commands.VerifyMirror(config.BaseURL, config.Suites, config.Components, config.Architectures, config.DestDir, config.Deep, config.Verbose, keyrings, keyringDirs, config.NoGPGVerify, config.IncludeInstaller, config.IncludeDEP11, config.ExtraIndices, config.AllIndices, config.IndexInclude, config.IndexExclude, config.PackagesFile, config.WithDeps, config.ExcludeDeps, localizer)
+	case "check-repo":
+		return commands.CheckRepository(config.RootDir, localizer)
 	default:
 		return errors.New(localizer.MustLocalize(&i18n.LocalizeConfig{
 			MessageID: "error.unknown_command",
@@ -7,15 +7,24 @@ func initCommands() {
 	rootCmd = &cobra.Command{
 		Use:   "deb-for-all",
 		Short: "Debian package management tool",
+		// Flags not given on the command line take their value from the configuration file
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			err := loadSettings(cmd)
+			// The command line itself was valid
+			cmd.SilenceUsage = err != nil
+			return err
+		},
 	}
 
 	// Flags globaux
 	rootCmd.PersistentFlags().StringVarP(&config.DestDir, "dest", "d", "./downloads", localize("flag.dest"))
 	rootCmd.PersistentFlags().BoolVarP(&config.Verbose, "verbose", "v", false, localize("flag.verbose"))
-	rootCmd.PersistentFlags().StringVar(&config.CacheDir, "cache", "./cache", localize("flag.cache"))
+	rootCmd.PersistentFlags().StringVar(&config.CacheDir, "cache", defaultCacheDir(), localize("flag.cache"))
 	rootCmd.PersistentFlags().StringVar(&config.Keyrings, "keyring", "", localize("flag.keyring"))
 	rootCmd.PersistentFlags().StringVar(&config.KeyringDirs, "keyring-dir", "", localize("flag.keyring_dir"))
 	rootCmd.PersistentFlags().BoolVar(&config.NoGPGVerify, "no-gpg-verify", false, localize("flag.no_gpg_verify"))
+	rootCmd.PersistentFlags().StringVar(&config.ConfigFile, "config", "", localize("flag.config"))
+	rootCmd.PersistentFlags().IntVar(&config.Concurrency, "concurrency", 0, localize("flag.concurrency"))
 
 	// Commande `download`
 	downloadCmd := &cobra.Command{
@@ -31,10 +40,30 @@ func initCommands() {
 	downloadCmd.Flags().StringVar(&config.Suites, "suites", "bookworm", localize("flag.suites"))
 	downloadCmd.Flags().StringVar(&config.Components, "components", "main", localize("flag.components"))
 	downloadCmd.Flags().StringVar(&config.Architectures, "architectures", "amd64", localize("flag.architectures"))
+	downloadCmd.Flags().StringVar(&config.Arch, "arch", "", localize("flag.arch"))
 	downloadCmd.Flags().BoolVarP(&config.Silent, "silent", "s", false, localize("flag.silent"))
 	downloadCmd.MarkFlagRequired("package")
 	rootCmd.AddCommand(downloadCmd)
 
+	// Commande `deps`
+	depsCmd := &cobra.Command{
+		Use:   "deps",
+		Short: localize("command.deps"),
+		Run: func(cmd *cobra.Command, args []string) {
+			config.Command = "deps"
+		},
+	}
+	depsCmd.Flags().StringVarP(&config.PackageName, "package", "p", "", localize("flag.package"))
+	depsCmd.Flags().StringVar(&config.Version, "version", "", localize("flag.version"))
+	depsCmd.Flags().StringVarP(&config.BaseURL, "url", "u", "http://deb.debian.org/debian", localize("flag.url"))
+	depsCmd.Flags().StringVar(&config.Suites, "suites", "bookworm", localize("flag.suites"))
+	depsCmd.Flags().StringVar(&config.Components, "components", "main", localize("flag.components"))
+	depsCmd.Flags().StringVar(&config.Architectures, "architectures", "amd64", localize("flag.architectures"))
+	depsCmd.Flags().StringVar(&config.ExcludeDeps, "exclude-deps", "", localize("flag.exclude_deps"))
+	depsCmd.Flags().BoolVar(&config.Tree, "tree", false, localize("flag.tree"))
+	depsCmd.MarkFlagRequired("package")
+	rootCmd.AddCommand(depsCmd)
+
 	// Commande `download-source`
 	downloadSourceCmd := &cobra.Command{
 		Use:   "download-source",
@@ -48,7 +77,9 @@ func initCommands() {
 	downloadSourceCmd.Flags().StringVarP(&config.BaseURL, "url", "u", "http://deb.debian.org/debian", localize("flag.url"))
 	downloadSourceCmd.Flags().StringVar(&config.Suites, "suites", "bookworm", localize("flag.suites"))
 	downloadSourceCmd.Flags().StringVar(&config.Components, "components", "main", localize("flag.components"))
-	downloadSourceCmd.Flags().StringVar(&config.Architectures, "architectures", "source", localize("flag.architectures"))
+	// Source packages have no architecture; the flag is kept so existing scripts still parse
+	downloadSourceCmd.Flags().StringVar(&config.Architectures, "architectures", "", localize("flag.architectures"))
+	downloadSourceCmd.Flags().MarkDeprecated("architectures", localize("flag.architectures_source_deprecated"))
 	downloadSourceCmd.Flags().BoolVar(&config.OrigOnly, "orig-only", false, localize("flag.orig_only"))
 	downloadSourceCmd.Flags().BoolVarP(&config.Silent, "silent", "s", false, localize("flag.silent"))
 	downloadSourceCmd.MarkFlagRequired("package")
@@ -66,6 +97,8 @@ func initCommands() {
 	updateCmd.Flags().StringVar(&config.Suites, "suites", "bookworm", localize("flag.suites"))
 	updateCmd.Flags().StringVar(&config.Components, "components", "main", localize("flag.components"))
 	updateCmd.Flags().StringVar(&config.Architectures, "architectures", "amd64", localize("flag.architectures"))
+	updateCmd.Flags().BoolVar(&config.Lenient, "lenient", false, localize("flag.lenient"))
+	updateCmd.Flags().StringVar(&config.RequireFingerprint, "require-fingerprint", "", localize("flag.require_fingerprint"))
 	rootCmd.AddCommand(updateCmd)
 
 	// Commande `mirror`
@@ -82,6 +115,25 @@ func initCommands() {
 	mirrorCmd.Flags().StringVar(&config.Architectures, "architectures", "amd64", localize("flag.architectures"))
 	mirrorCmd.Flags().BoolVar(&config.MetadataOnly, "metadata-only", false, localize("flag.metadata_only"))
 	mirrorCmd.Flags().IntVar(&config.RateLimit, "rate-limit", 0, localize("flag.rate_limit"))
+	mirrorCmd.Flags().BoolVar(&config.DryRun, "dry-run", false, localize("flag.dry_run"))
+	mirrorCmd.Flags().BoolVar(&config.WriteMetadata, "write-metadata", false, localize("flag.write_metadata"))
+	mirrorCmd.Flags().BoolVar(&config.PlanJSON, "json", false, localize("flag.plan_json"))
+	mirrorCmd.Flags().StringVar(&config.SharedCache, "shared-cache", "", localize("flag.shared_cache"))
+	mirrorCmd.Flags().Int64Var(&config.SharedCacheMax, "shared-cache-max-size", 0, localize("flag.shared_cache_max_size"))
+	mirrorCmd.Flags().BoolVar(&config.Lenient, "lenient", false, localize("flag.lenient"))
+	mirrorCmd.Flags().StringVar(&config.RequireFingerprint, "require-fingerprint", "", localize("flag.require_fingerprint"))
+	mirrorCmd.Flags().BoolVar(&config.SkipMissing, "skip-missing", false, localize("flag.skip_missing"))
+	mirrorCmd.Flags().BoolVar(&config.IncludeInstaller, "include-installer", false, localize("flag.include_installer"))
+	mirrorCmd.Flags().BoolVar(&config.IncludeUncompressed, "include-uncompressed", false, localize("flag.include_uncompressed"))
+	mirrorCmd.Flags().BoolVar(&config.IncludeDEP11, "include-dep11", false, localize("flag.include_dep11"))
+	mirrorCmd.Flags().StringVar(&config.ExtraIndices, "extra-indices", "", localize("flag.extra_indices"))
+	mirrorCmd.Flags().BoolVar(&config.AllIndices, "all-indices", false, localize("flag.all_indices"))
+	mirrorCmd.Flags().StringVar(&config.IndexInclude, "index-include", "", localize("flag.index_include"))
+	mirrorCmd.Flags().StringVar(&config.IndexExclude, "index-exclude", "", localize("flag.index_exclude"))
+	mirrorCmd.Flags().StringVar(&config.PackagesFile, "packages-file", "", localize("flag.packages_file"))
+	mirrorCmd.Flags().BoolVar(&config.WithDeps, "with-dependencies", false, localize("flag.with_dependencies"))
+	mirrorCmd.Flags().StringVar(&config.ExcludeDeps, "exclude-deps", "", localize("flag.exclude_deps"))
+	mirrorCmd.Flags().BoolVar(&config.SkipCheck, "skip-check", false, localize("flag.skip_check"))
 	rootCmd.AddCommand(mirrorCmd)
 
 	// Commande `custom-repo`
@@ -100,8 +152,99 @@ func initCommands() {
 	customRepoCmd.Flags().StringVar(&config.ExcludeDeps, "exclude-deps", "", localize("flag.exclude_deps"))
 	customRepoCmd.Flags().IntVar(&config.RateLimit, "rate-limit", 0, localize("flag.rate_limit"))
 	customRepoCmd.Flags().BoolVar(&config.IncludeSources, "sources", false, localize("flag.sources"))
+	customRepoCmd.Flags().BoolVar(&config.InstallScript, "install-script", false, localize("flag.install_script"))
+	customRepoCmd.Flags().BoolVar(&config.IncludeUncompressed, "include-uncompressed", false, localize("flag.include_uncompressed"))
 	customRepoCmd.Flags().StringVar(&config.GPGKeyPath, "gpg-key", "", localize("flag.gpg_key"))
 	customRepoCmd.Flags().StringVar(&config.GPGPassphrase, "gpg-passphrase", "", localize("flag.gpg_passphrase"))
-	customRepoCmd.MarkFlagRequired("packages-xml")
+	customRepoCmd.Flags().BoolVar(&config.DryRun, "dry-run", false, localize("flag.dry_run"))
+	customRepoCmd.Flags().BoolVar(&config.WriteMetadata, "write-metadata", false, localize("flag.write_metadata"))
+	customRepoCmd.Flags().BoolVar(&config.PlanJSON, "json", false, localize("flag.plan_json"))
+	customRepoCmd.Flags().StringVar(&config.SharedCache, "shared-cache", "", localize("flag.shared_cache"))
+	customRepoCmd.Flags().Int64Var(&config.SharedCacheMax, "shared-cache-max-size", 0, localize("flag.shared_cache_max_size"))
+	customRepoCmd.Flags().BoolVar(&config.Lenient, "lenient", false, localize("flag.lenient"))
+	customRepoCmd.Flags().StringVar(&config.RequireFingerprint, "require-fingerprint", "", localize("flag.require_fingerprint"))
+	customRepoCmd.Flags().BoolVar(&config.NoResolveCache, "no-resolve-cache", false, localize("flag.no_resolve_cache"))
+	customRepoCmd.Flags().BoolVar(&config.KeepGoing, "keep-going", false, localize("flag.keep_going"))
+	customRepoCmd.Flags().StringVar(&config.RetryFailed, "retry-failed", "", localize("flag.retry_failed"))
+	customRepoCmd.Flags().BoolVar(&config.SkipCheck, "skip-check", false, localize("flag.skip_check"))
+	customRepoCmd.Flags().StringVar(&config.SuiteConflict, "suite-conflict", "highest", localize("flag.suite_conflict"))
+	customRepoCmd.MarkFlagsOneRequired("packages-xml", "retry-failed")
 	rootCmd.AddCommand(customRepoCmd)
+
+	// Commande `contents`
+	contentsCmd := &cobra.Command{
+		Use:   "contents",
+		Short: localize("command.contents"),
+		Run: func(cmd *cobra.Command, args []string) {
+			config.Command = "contents"
+		},
+	}
+	contentsCmd.Flags().StringVar(&config.DebFile, "file", "", localize("flag.file"))
+	contentsCmd.MarkFlagRequired("file")
+	rootCmd.AddCommand(contentsCmd)
+
+	// Commande `verify`
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: localize("command.verify"),
+		Run: func(cmd *cobra.Command, args []string) {
+			config.Command = "verify"
+		},
+	}
+	verifyCmd.Flags().StringVarP(&config.BaseURL, "url", "u", "http://deb.debian.org/debian", localize("flag.url"))
+	verifyCmd.Flags().StringVar(&config.Suites, "suites", "bookworm", localize("flag.suites"))
+	verifyCmd.Flags().StringVar(&config.Components, "components", "main", localize("flag.components"))
+	verifyCmd.Flags().StringVar(&config.Architectures, "architectures", "amd64", localize("flag.architectures"))
+	verifyCmd.Flags().BoolVar(&config.Deep, "deep", false, localize("flag.deep"))
+	verifyCmd.Flags().BoolVar(&config.IncludeInstaller, "include-installer", false, localize("flag.include_installer"))
+	verifyCmd.Flags().BoolVar(&config.IncludeDEP11, "include-dep11", false, localize("flag.include_dep11"))
+	verifyCmd.Flags().StringVar(&config.ExtraIndices, "extra-indices", "", localize("flag.extra_indices"))
+	verifyCmd.Flags().BoolVar(&config.AllIndices, "all-indices", false, localize("flag.all_indices"))
+	verifyCmd.Flags().StringVar(&config.IndexInclude, "index-include", "", localize("flag.index_include"))
+	verifyCmd.Flags().StringVar(&config.IndexExclude, "index-exclude", "", localize("flag.index_exclude"))
+	verifyCmd.Flags().StringVar(&config.PackagesFile, "packages-file", "", localize("flag.packages_file"))
+	verifyCmd.Flags().BoolVar(&config.WithDeps, "with-dependencies", false, localize("flag.with_dependencies"))
+	verifyCmd.Flags().StringVar(&config.ExcludeDeps, "exclude-deps", "", localize("flag.exclude_deps"))
+	rootCmd.AddCommand(verifyCmd)
+
+	// Commande `check-repo`
+	checkRepoCmd := &cobra.Command{
+		Use:   "check-repo",
+		Short: localize("command.check_repo"),
+		Run: func(cmd *cobra.Command, args []string) {
+			config.Command = "check-repo"
+		},
+	}
+	checkRepoCmd.Flags().StringVar(&config.RootDir, "root", "", localize("flag.root"))
+	checkRepoCmd.MarkFlagRequired("root")
+	rootCmd.AddCommand(checkRepoCmd)
+
+	// Commande `completion`
+	completionCmd := &cobra.Command{
+		Use:       "completion bash|zsh|fish",
+		Short:     localize("command.completion"),
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish"},
+		Run: func(cmd *cobra.Command, args []string) {
+			config.Command = "completion"
+			config.Shell = args[0]
+		},
+	}
+	rootCmd.AddCommand(completionCmd)
+
+	// Commande `gen-docs`, pour l'empaquetage
+	genDocsCmd := &cobra.Command{
+		Use:    "gen-docs",
+		Short:  localize("command.gen_docs"),
+		Hidden: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			config.Command = "gen-docs"
+		},
+	}
+	genDocsCmd.Flags().StringVar(&config.DocsDir, "dir", "./docs", localize("flag.docs_dir"))
+	rootCmd.AddCommand(genDocsCmd)
+
+	for _, cmd := range rootCmd.Commands() {
+		registerCompletions(cmd)
+	}
 }
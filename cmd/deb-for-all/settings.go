@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+	"github.com/spf13/cobra"
+)
+
+// appName names the per-user directories of deb-for-all.
+const appName = "deb-for-all"
+
+// configFilename is the name of the configuration file in the configuration directory.
+const configFilename = "config.toml"
+
+// legacyCacheDir is the default of --cache before it moved to the user cache directory.
+const legacyCacheDir = "./cache"
+
+// userDirs holds the per-user directories deb-for-all keeps its state in. A directory
+// that cannot be determined is empty.
+type userDirs struct {
+	Cache  string // Default of --cache
+	Config string // Holds config.toml
+}
+
+// resolveUserDirs returns the per-user directories on the platform goos, reading the
+// environment through getenv. XDG_CACHE_HOME and XDG_CONFIG_HOME are honored when
+// they hold absolute paths, as the XDG specification requires; otherwise Windows uses
+// %LOCALAPPDATA% and %APPDATA%, falling back to %USERPROFILE%\AppData, and the other
+// platforms use ~/.cache and ~/.config.
+func resolveUserDirs(goos string, getenv func(string) string) userDirs {
+	join := filepath.Join
+	isAbs := filepath.IsAbs
+	if goos == "windows" {
+		join = func(elem ...string) string { return strings.Join(elem, `\`) }
+		isAbs = func(path string) bool {
+			return strings.HasPrefix(path, `\\`) || len(path) >= 3 && path[1] == ':' && (path[2] == '\\' || path[2] == '/')
+		}
+	}
+	xdg := func(name string) string {
+		if value := getenv(name); value != "" && isAbs(value) {
+			return join(value, appName)
+		}
+		return ""
+	}
+
+	dirs := userDirs{Cache: xdg("XDG_CACHE_HOME"), Config: xdg("XDG_CONFIG_HOME")}
+	if goos == "windows" {
+		localAppData, appData := getenv("LOCALAPPDATA"), getenv("APPDATA")
+		if profile := getenv("USERPROFILE"); profile != "" {
+			if localAppData == "" {
+				localAppData = join(profile, "AppData", "Local")
+			}
+			if appData == "" {
+				appData = join(profile, "AppData", "Roaming")
+			}
+		}
+		if dirs.Cache == "" && localAppData != "" {
+			dirs.Cache = join(localAppData, appName, "cache")
+		}
+		if dirs.Config == "" && appData != "" {
+			dirs.Config = join(appData, appName)
+		}
+		return dirs
+	}
+
+	if home := getenv("HOME"); home != "" {
+		if dirs.Cache == "" {
+			dirs.Cache = join(home, ".cache", appName)
+		}
+		if dirs.Config == "" {
+			dirs.Config = join(home, ".config", appName)
+		}
+	}
+	return dirs
+}
+
+// currentUserDirs returns the per-user directories of the running platform.
+func currentUserDirs() userDirs {
+	return resolveUserDirs(runtime.GOOS, os.Getenv)
+}
+
+// defaultCacheDir returns the built-in default of --cache: the user cache directory,
+// or ./cache when there is none.
+func defaultCacheDir() string {
+	if dir := currentUserDirs().Cache; dir != "" {
+		return dir
+	}
+	return legacyCacheDir
+}
+
+// fileConfig is the layout of the configuration file. Every setting is optional and
+// gives the default of the flag of the same name:
+//
+//	url = "http://deb.debian.org/debian"
+//	suites = ["bookworm", "bookworm-updates"]
+//	keyrings = ["/usr/share/keyrings/debian-archive-keyring.gpg"]
+//	concurrency = 8
+type fileConfig struct {
+	URL         string   `toml:"url"`
+	Suites      []string `toml:"suites"`
+	Components  []string `toml:"components"`
+	Keyrings    []string `toml:"keyrings"`
+	KeyringDirs []string `toml:"keyring_dirs"`
+	Cache       string   `toml:"cache"`
+	Dest        string   `toml:"dest"`
+	Concurrency int      `toml:"concurrency"`
+}
+
+// loadFileConfig reads the configuration file at path and returns its settings keyed
+// by flag name. Relative paths are taken from the directory of the file and ~/ from
+// the home directory, so that the settings do not depend on where the command runs.
+// Unknown keys are rejected to catch misspelled settings.
+func loadFileConfig(path string) (map[string]string, error) {
+	var file fileConfig
+	meta, err := toml.DecodeFile(path, &file)
+	if err != nil {
+		return nil, err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown settings: %s", strings.Join(keys, ", "))
+	}
+	if file.Concurrency < 0 {
+		return nil, fmt.Errorf("concurrency must not be negative")
+	}
+
+	base := filepath.Dir(path)
+	resolve := func(p string) string {
+		if rest, ok := strings.CutPrefix(p, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				return filepath.Join(home, rest)
+			}
+		}
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+	resolveAll := func(paths []string) []string {
+		resolved := make([]string, len(paths))
+		for i, p := range paths {
+			resolved[i] = resolve(p)
+		}
+		return resolved
+	}
+
+	settings := map[string]string{
+		"url":         file.URL,
+		"suites":      strings.Join(file.Suites, ","),
+		"components":  strings.Join(file.Components, ","),
+		"keyring":     strings.Join(resolveAll(file.Keyrings), ","),
+		"keyring-dir": strings.Join(resolveAll(file.KeyringDirs), ","),
+		"cache":       resolve(file.Cache),
+		"dest":        resolve(file.Dest),
+	}
+	if file.Concurrency > 0 {
+		settings["concurrency"] = strconv.Itoa(file.Concurrency)
+	}
+	for flag, value := range settings {
+		if value == "" {
+			delete(settings, flag)
+		}
+	}
+	return settings, nil
+}
+
+// envFlags maps the environment variables that give flag defaults to their flag.
+var envFlags = map[string]string{
+	"DEB_FOR_ALL_BASE_URL":      "url",
+	"DEB_FOR_ALL_SUITES":        "suites",
+	"DEB_FOR_ALL_KEYRING":       "keyring",
+	"DEB_FOR_ALL_NO_GPG_VERIFY": "no-gpg-verify",
+	"DEB_FOR_ALL_CACHE_DIR":     "cache",
+	"DEB_FOR_ALL_CONCURRENCY":   "concurrency",
+}
+
+// authTokenEnv holds the bearer token sent with every request. It has no flag, so
+// that the token never shows up in the process list or in shell history.
+const authTokenEnv = "DEB_FOR_ALL_AUTH_TOKEN"
+
+// loadEnvSettings returns the settings given by the environment, read through getenv,
+// keyed by flag name. Values are checked here rather than by the flags so that errors
+// name the variable: booleans must be true, false, 1 or 0, and the concurrency a
+// non-negative integer. Empty variables are ignored.
+func loadEnvSettings(getenv func(string) string) (map[string]string, error) {
+	settings := make(map[string]string)
+	for name, flag := range envFlags {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		switch flag {
+		case "no-gpg-verify":
+			switch value {
+			case "true", "1":
+				value = "true"
+			case "false", "0":
+				value = "false"
+			default:
+				return nil, fmt.Errorf("%s must be true, false, 1 or 0, got %q", name, value)
+			}
+		case "concurrency":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative integer, got %q", name, value)
+			}
+		}
+		settings[flag] = value
+	}
+	return settings, nil
+}
+
+// applySettings gives the flags of cmd that were not set on the command line their
+// value from the first of layers holding one, layers going from the highest
+// precedence to the lowest; flags no layer sets keep their built-in default. Settings
+// for flags cmd does not have are ignored, as a configuration file serves every
+// command.
+func applySettings(cmd *cobra.Command, layers ...map[string]string) error {
+	flags := cmd.Flags()
+	for _, layer := range layers {
+		names := make([]string, 0, len(layer))
+		for name := range layer {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			// A flag set by an earlier layer counts as changed too
+			if flags.Lookup(name) == nil || flags.Changed(name) {
+				continue
+			}
+			if err := flags.Set(name, layer[name]); err != nil {
+				return fmt.Errorf("invalid value %q for --%s: %w", layer[name], name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadSettings applies the environment, then the configuration file, to the flags of
+// cmd, the file coming from --config or, when it exists, config.toml in the user
+// configuration directory. It also sizes the shared download queue from --concurrency
+// and authenticates requests to the host of --url with DEB_FOR_ALL_AUTH_TOKEN.
+func loadSettings(cmd *cobra.Command) error {
+	env, err := loadEnvSettings(os.Getenv)
+	if err == nil {
+		err = applySettings(cmd, env)
+	}
+	if err != nil {
+		return errors.New(localizer.MustLocalize(&i18n.LocalizeConfig{
+			MessageID:    "error.environment",
+			TemplateData: map[string]any{"Error": err.Error()},
+		}))
+	}
+	path, explicit := config.ConfigFile, config.ConfigFile != ""
+	if !explicit {
+		if dir := currentUserDirs().Config; dir != "" {
+			path = filepath.Join(dir, configFilename)
+		}
+	}
+	if path != "" {
+		settings, err := loadFileConfig(path)
+		switch {
+		case err == nil:
+			err = applySettings(cmd, settings)
+		case !explicit && errors.Is(err, fs.ErrNotExist):
+			err = nil
+		}
+		if err != nil {
+			return errors.New(localizer.MustLocalize(&i18n.LocalizeConfig{
+				MessageID:    "error.config_file",
+				TemplateData: map[string]any{"Path": path, "Error": err.Error()},
+			}))
+		}
+	}
+
+	if config.Concurrency > 0 {
+		debian.SetSharedDownloadLimit(config.Concurrency)
+	}
+
+	// Scoped to the archive once --url is final, so that other hosts never see the token
+	if token := os.Getenv(authTokenEnv); token != "" && config.BaseURL != "" {
+		if err := debian.SetDefaultBearerToken(token, config.BaseURL); err != nil {
+			return errors.New(localizer.MustLocalize(&i18n.LocalizeConfig{
+				MessageID:    "error.environment",
+				TemplateData: map[string]any{"Error": fmt.Sprintf("%s: %v", authTokenEnv, err)},
+			}))
+		}
+	}
+
+	// The cache used to default to ./cache: point out where it went until the new one exists
+	if !cmd.Flags().Changed("cache") && config.CacheDir != legacyCacheDir {
+		if info, err := os.Stat(legacyCacheDir); err == nil && info.IsDir() {
+			if _, err := os.Stat(config.CacheDir); errors.Is(err, fs.ErrNotExist) {
+				fmt.Fprintln(os.Stderr, localizer.MustLocalize(&i18n.LocalizeConfig{
+					MessageID:    "command.cache_moved",
+					TemplateData: map[string]any{"Path": config.CacheDir, "Legacy": legacyCacheDir},
+				}))
+			}
+		}
+	}
+	return nil
+}
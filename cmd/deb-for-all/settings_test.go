@@ -0,0 +1,243 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestResolveUserDirs(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		env  map[string]string
+		want userDirs
+	}{
+		{
+			name: "xdg",
+			goos: "linux",
+			env:  map[string]string{"HOME": "/home/ada", "XDG_CACHE_HOME": "/var/cache/ada", "XDG_CONFIG_HOME": "/etc/ada"},
+			want: userDirs{Cache: "/var/cache/ada/deb-for-all", Config: "/etc/ada/deb-for-all"},
+		},
+		{
+			name: "home fallback",
+			goos: "linux",
+			env:  map[string]string{"HOME": "/home/ada"},
+			want: userDirs{Cache: "/home/ada/.cache/deb-for-all", Config: "/home/ada/.config/deb-for-all"},
+		},
+		{
+			name: "relative xdg ignored",
+			goos: "darwin",
+			env:  map[string]string{"HOME": "/Users/ada", "XDG_CACHE_HOME": "cache"},
+			want: userDirs{Cache: "/Users/ada/.cache/deb-for-all", Config: "/Users/ada/.config/deb-for-all"},
+		},
+		{
+			name: "no home",
+			goos: "linux",
+			env:  map[string]string{},
+			want: userDirs{},
+		},
+		{
+			name: "windows appdata",
+			goos: "windows",
+			env:  map[string]string{"LOCALAPPDATA": `C:\Users\ada\AppData\Local`, "APPDATA": `C:\Users\ada\AppData\Roaming`},
+			want: userDirs{Cache: `C:\Users\ada\AppData\Local\deb-for-all\cache`, Config: `C:\Users\ada\AppData\Roaming\deb-for-all`},
+		},
+		{
+			name: "windows profile fallback",
+			goos: "windows",
+			env:  map[string]string{"USERPROFILE": `C:\Users\ada`},
+			want: userDirs{Cache: `C:\Users\ada\AppData\Local\deb-for-all\cache`, Config: `C:\Users\ada\AppData\Roaming\deb-for-all`},
+		},
+		{
+			name: "windows xdg",
+			goos: "windows",
+			env:  map[string]string{"LOCALAPPDATA": `C:\Users\ada\AppData\Local`, "XDG_CACHE_HOME": `D:\cache`, "XDG_CONFIG_HOME": "/etc"},
+			want: userDirs{Cache: `D:\cache\deb-for-all`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolveUserDirs(tt.goos, func(name string) string { return tt.env[name] })
+			if got != tt.want {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, configFilename)
+	content := "url = \"http://mirror.example/debian\"\nsuites = [\"bookworm\", \"bookworm-updates\"]\nkeyrings = [\"keys/debian.gpg\", \"/usr/share/keyrings/extra.gpg\"]\ncache = \"cache\"\nconcurrency = 8\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := loadFileConfig(path)
+	if err != nil {
+		t.Fatalf("loadFileConfig failed: %v", err)
+	}
+	want := map[string]string{
+		"url":         "http://mirror.example/debian",
+		"suites":      "bookworm,bookworm-updates",
+		"keyring":     filepath.Join(dir, "keys", "debian.gpg") + ",/usr/share/keyrings/extra.gpg",
+		"cache":       filepath.Join(dir, "cache"),
+		"concurrency": "8",
+	}
+	if len(settings) != len(want) {
+		t.Fatalf("got settings %v, want %v", settings, want)
+	}
+	for flag, value := range want {
+		if settings[flag] != value {
+			t.Fatalf("setting %s: got %q, want %q", flag, settings[flag], value)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("suite = [\"bookworm\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadFileConfig(path); err == nil || !strings.Contains(err.Error(), "suite") {
+		t.Fatalf("expected the misspelled setting to be rejected, got %v", err)
+	}
+}
+
+func TestApplySettingsPrecedence(t *testing.T) {
+	var url, suites, cache string
+	var concurrency int
+	cmd := &cobra.Command{Use: "download", Run: func(*cobra.Command, []string) {}}
+	cmd.Flags().StringVar(&url, "url", "http://deb.debian.org/debian", "")
+	cmd.Flags().StringVar(&suites, "suites", "bookworm", "")
+	cmd.Flags().StringVar(&cache, "cache", "/home/ada/.cache/deb-for-all", "")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 0, "")
+	if err := cmd.ParseFlags([]string{"--url", "http://flag.example/debian"}); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"url": "http://env.example/debian", "suites": "trixie"}
+	file := map[string]string{"url": "http://file.example/debian", "suites": "bookworm-backports", "concurrency": "8", "dest": "/srv/debs"}
+	if err := applySettings(cmd, env, file); err != nil {
+		t.Fatalf("applySettings failed: %v", err)
+	}
+
+	if url != "http://flag.example/debian" {
+		t.Errorf("url: the flag should win, got %s", url)
+	}
+	if suites != "trixie" {
+		t.Errorf("suites: the environment should win over the file, got %s", suites)
+	}
+	if concurrency != 8 {
+		t.Errorf("concurrency: the file should win over the built-in default, got %d", concurrency)
+	}
+	if cache != "/home/ada/.cache/deb-for-all" {
+		t.Errorf("cache: the built-in default should remain, got %s", cache)
+	}
+
+	if err := applySettings(cmd, map[string]string{"concurrency": "many"}); err != nil {
+		t.Fatalf("a flag already set should be left alone, got %v", err)
+	}
+	other := &cobra.Command{Use: "other"}
+	other.Flags().IntVar(&concurrency, "concurrency", 0, "")
+	if err := applySettings(other, map[string]string{"concurrency": "many"}); err == nil {
+		t.Fatal("expected an invalid concurrency to be rejected")
+	}
+}
+
+func TestLoadEnvSettings(t *testing.T) {
+	newCommand := func(cfg *Config) *cobra.Command {
+		cmd := &cobra.Command{Use: "mirror", Run: func(*cobra.Command, []string) {}}
+		cmd.Flags().StringVar(&cfg.BaseURL, "url", "http://deb.debian.org/debian", "")
+		cmd.Flags().StringVar(&cfg.Suites, "suites", "bookworm", "")
+		cmd.Flags().StringVar(&cfg.Keyrings, "keyring", "", "")
+		cmd.Flags().BoolVar(&cfg.NoGPGVerify, "no-gpg-verify", false, "")
+		cmd.Flags().StringVar(&cfg.CacheDir, "cache", "/home/ada/.cache/deb-for-all", "")
+		cmd.Flags().IntVar(&cfg.Concurrency, "concurrency", 0, "")
+		return cmd
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(Config) bool
+	}{
+		{"url", map[string]string{"DEB_FOR_ALL_BASE_URL": "http://mirror.example/debian"}, func(c Config) bool { return c.BaseURL == "http://mirror.example/debian" }},
+		{"suites", map[string]string{"DEB_FOR_ALL_SUITES": "trixie,trixie-updates"}, func(c Config) bool { return c.Suites == "trixie,trixie-updates" }},
+		{"keyring", map[string]string{"DEB_FOR_ALL_KEYRING": "/etc/ci/debian.gpg"}, func(c Config) bool { return c.Keyrings == "/etc/ci/debian.gpg" }},
+		{"no gpg verify true", map[string]string{"DEB_FOR_ALL_NO_GPG_VERIFY": "true"}, func(c Config) bool { return c.NoGPGVerify }},
+		{"no gpg verify 1", map[string]string{"DEB_FOR_ALL_NO_GPG_VERIFY": "1"}, func(c Config) bool { return c.NoGPGVerify }},
+		{"no gpg verify 0", map[string]string{"DEB_FOR_ALL_NO_GPG_VERIFY": "0"}, func(c Config) bool { return !c.NoGPGVerify }},
+		{"cache", map[string]string{"DEB_FOR_ALL_CACHE_DIR": "/var/cache/ci"}, func(c Config) bool { return c.CacheDir == "/var/cache/ci" }},
+		{"concurrency", map[string]string{"DEB_FOR_ALL_CONCURRENCY": "12"}, func(c Config) bool { return c.Concurrency == 12 }},
+		{"empty", map[string]string{"DEB_FOR_ALL_BASE_URL": ""}, func(c Config) bool { return c.BaseURL == "http://deb.debian.org/debian" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := loadEnvSettings(func(name string) string { return tt.env[name] })
+			if err != nil {
+				t.Fatalf("loadEnvSettings failed: %v", err)
+			}
+			var cfg Config
+			cmd := newCommand(&cfg)
+			if err := cmd.ParseFlags(nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := applySettings(cmd, settings); err != nil {
+				t.Fatalf("applySettings failed: %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Fatalf("unexpected configuration %+v", cfg)
+			}
+		})
+	}
+
+	t.Run("flags win", func(t *testing.T) {
+		settings, err := loadEnvSettings(func(name string) string {
+			return map[string]string{"DEB_FOR_ALL_BASE_URL": "http://env.example/debian", "DEB_FOR_ALL_NO_GPG_VERIFY": "true"}[name]
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cfg Config
+		cmd := newCommand(&cfg)
+		if err := cmd.ParseFlags([]string{"--url", "http://flag.example/debian", "--no-gpg-verify=false"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := applySettings(cmd, settings); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.BaseURL != "http://flag.example/debian" || cfg.NoGPGVerify {
+			t.Fatalf("expected the command line to win, got %+v", cfg)
+		}
+	})
+
+	for _, env := range []map[string]string{
+		{"DEB_FOR_ALL_NO_GPG_VERIFY": "yes"},
+		{"DEB_FOR_ALL_NO_GPG_VERIFY": "TRUE"},
+		{"DEB_FOR_ALL_CONCURRENCY": "many"},
+		{"DEB_FOR_ALL_CONCURRENCY": "-1"},
+	} {
+		env[authTokenEnv] = "s3cret"
+		_, err := loadEnvSettings(func(name string) string { return env[name] })
+		if err == nil {
+			t.Fatalf("expected %v to be rejected", env)
+		}
+		for name := range env {
+			if name != authTokenEnv && !strings.Contains(err.Error(), name) {
+				t.Errorf("expected the error to name %s, got %v", name, err)
+			}
+		}
+		if strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("the auth token leaked into %v", err)
+		}
+	}
+
+	settings, err := loadEnvSettings(func(name string) string { return map[string]string{authTokenEnv: "s3cret"}[name] })
+	if err != nil || len(settings) != 0 {
+		t.Fatalf("expected the auth token to stay out of the flags, got %v, %v", settings, err)
+	}
+}
@@ -0,0 +1,96 @@
+package testsupport
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// buildDeb returns a minimal but valid .deb for pkg: an ar archive holding
+// debian-binary, control.tar.gz and data.tar.gz.
+func buildDeb(pkg Package) ([]byte, error) {
+	control := formatStanza([][2]string{
+		{"Package", pkg.Name},
+		{"Source", pkg.Source},
+		{"Version", pkg.Version},
+		{"Architecture", pkg.Architecture},
+		{"Maintainer", maintainer(pkg.Maintainer)},
+		{"Pre-Depends", pkg.PreDepends},
+		{"Depends", pkg.Depends},
+		{"Recommends", pkg.Recommends},
+		{"Section", pkg.Section},
+		{"Priority", pkg.Priority},
+		{"Description", description(pkg.Name, pkg.Description)},
+	})
+	controlTar, err := tarGz(map[string]string{"./control": control})
+	if err != nil {
+		return nil, err
+	}
+
+	files := pkg.Files
+	if len(files) == 0 {
+		files = map[string]string{"usr/share/doc/" + pkg.Name + "/copyright": "Fixture package " + pkg.Name + "\n"}
+	}
+	data := make(map[string]string, len(files))
+	for name, content := range files {
+		data["./"+strings.TrimPrefix(name, "/")] = content
+	}
+	dataTar, err := tarGz(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("!<arch>\n")
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlTar},
+		{"data.tar.gz", dataTar},
+	} {
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name+"/", 0, 0, 0, "100644", len(member.data))
+		buf.Write(member.data)
+		if len(member.data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// tarGz returns a gzip-compressed tar archive of files, keyed by path, in a
+// deterministic order.
+func tarGz(files map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), ModTime: modTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(files[name])); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,523 @@
+// Package testsupport builds complete fake Debian repositories in a temporary
+// directory and serves them over HTTP, so that repository, mirror and download code
+// can be tested without the network. Faults such as corrupted files, throttling,
+// missing paths and slow answers can be injected per path.
+package testsupport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+	"github.com/ulikunitz/xz"
+)
+
+// Package describes a binary package of the fixture repository. Relationship fields
+// are written to the Packages index as given.
+type Package struct {
+	Name         string
+	Version      string
+	Architecture string // Defaults to amd64
+	Component    string // Defaults to main
+	Udeb         bool   // Published in the debian-installer index of Component as a .udeb
+	Source       string // Source package name, when it differs from Name
+	Section      string
+	Priority     string
+	Maintainer   string
+	Description  string
+	Depends      string
+	PreDepends   string
+	Recommends   string
+	Suggests     string
+	Provides     string
+	Conflicts    string
+	Breaks       string
+
+	// Files are installed by the data.tar member of the .deb, keyed by path relative
+	// to the root. They default to usr/share/doc/<name>/copyright.
+	Files map[string]string
+}
+
+// Source describes a source package of the fixture repository. It is published with
+// a .dsc, an .orig.tar.gz and a .debian.tar.xz.
+type Source struct {
+	Name      string
+	Version   string // Debian revision included, e.g. 2.10-3
+	Component string // Defaults to main
+}
+
+// Config describes the fixture repository to build.
+type Config struct {
+	Suite         string   // Defaults to bookworm
+	Codename      string   // Defaults to Suite
+	Components    []string // Defaults to the components of Packages and Sources, or main
+	Architectures []string // Defaults to the architectures of Packages other than all, or amd64
+	Packages      []Package
+	Sources       []Source
+
+	// Compressions lists the variants of each index written: "" for the uncompressed
+	// file, ".gz" and ".xz". Defaults to all three.
+	Compressions []string
+
+	// Extra holds further files listed in the Release file, keyed by path relative to
+	// dists/<suite>, e.g. main/dep11/Components-amd64.yml.gz.
+	Extra map[string]string
+
+	// Sign signs the Release file with an ephemeral key, as InRelease and Release.gpg;
+	// the public keyring is written to Repository.KeyringPath.
+	Sign bool
+}
+
+// Repository is a fixture repository served by an httptest server.
+type Repository struct {
+	*server
+
+	URL         string // Base URL of the repository, without trailing slash
+	Root        string // Directory holding dists/ and pool/
+	Suite       string
+	KeyringPath string // Binary public keyring for gpgv; empty unless Config.Sign
+	Fingerprint string // Fingerprint of the signing key; empty unless Config.Sign
+
+	filenames map[string]string // Pool path of each binary package, keyed by name_arch
+}
+
+// NewRepository builds the repository described by cfg under tb.TempDir() and starts
+// serving it; the server is closed when the test ends.
+func NewRepository(tb testing.TB, cfg Config) *Repository {
+	tb.Helper()
+
+	cfg = cfg.withDefaults()
+	repo := &Repository{Root: tb.TempDir(), Suite: cfg.Suite, filenames: make(map[string]string)}
+	if err := repo.build(cfg); err != nil {
+		tb.Fatalf("testsupport: unable to build repository: %v", err)
+	}
+	if cfg.Sign {
+		if err := repo.sign(tb.TempDir()); err != nil {
+			tb.Fatalf("testsupport: unable to sign repository: %v", err)
+		}
+	}
+
+	repo.server = newServer(repo.Root)
+	repo.URL = repo.server.httpServer.URL
+	tb.Cleanup(repo.server.httpServer.Close)
+	return repo
+}
+
+// Filename returns the pool path of the .deb or .udeb of package name built for arch,
+// e.g. pool/main/h/hello/hello_2.10-3_amd64.deb, or "" when there is none.
+func (r *Repository) Filename(name, arch string) string {
+	return r.filenames[name+"_"+arch]
+}
+
+// ReadFile returns the content of the file at rel, relative to Root.
+func (r *Repository) ReadFile(rel string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(r.Root, filepath.FromSlash(strings.TrimPrefix(rel, "/"))))
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Suite == "" {
+		cfg.Suite = "bookworm"
+	}
+	if cfg.Codename == "" {
+		cfg.Codename = cfg.Suite
+	}
+	if cfg.Compressions == nil {
+		cfg.Compressions = []string{"", ".gz", ".xz"}
+	}
+
+	packages := make([]Package, len(cfg.Packages))
+	for i, pkg := range cfg.Packages {
+		if pkg.Architecture == "" {
+			pkg.Architecture = "amd64"
+		}
+		if pkg.Component == "" {
+			pkg.Component = "main"
+		}
+		packages[i] = pkg
+	}
+	cfg.Packages = packages
+
+	sources := make([]Source, len(cfg.Sources))
+	for i, src := range cfg.Sources {
+		if src.Component == "" {
+			src.Component = "main"
+		}
+		sources[i] = src
+	}
+	cfg.Sources = sources
+
+	if len(cfg.Components) == 0 {
+		for _, pkg := range cfg.Packages {
+			cfg.Components = appendUnique(cfg.Components, pkg.Component)
+		}
+		for _, src := range cfg.Sources {
+			cfg.Components = appendUnique(cfg.Components, src.Component)
+		}
+		if len(cfg.Components) == 0 {
+			cfg.Components = []string{"main"}
+		}
+	}
+	if len(cfg.Architectures) == 0 {
+		for _, pkg := range cfg.Packages {
+			if pkg.Architecture != "all" {
+				cfg.Architectures = appendUnique(cfg.Architectures, pkg.Architecture)
+			}
+		}
+		if len(cfg.Architectures) == 0 {
+			cfg.Architectures = []string{"amd64"}
+		}
+	}
+	return cfg
+}
+
+func appendUnique(values []string, value string) []string {
+	if slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// build writes the pool files, the indices and the Release file.
+func (r *Repository) build(cfg Config) error {
+	dists := path.Join("dists", cfg.Suite)
+	var indices []string // Paths relative to dists/<suite>
+
+	for _, component := range cfg.Components {
+		hasUdebs := slices.ContainsFunc(cfg.Packages, func(pkg Package) bool { return pkg.Udeb && pkg.Component == component })
+		for _, arch := range cfg.Architectures {
+			for _, udeb := range []bool{false, true} {
+				if udeb && !hasUdebs {
+					continue
+				}
+				var stanzas []string
+				for _, pkg := range cfg.Packages {
+					if pkg.Udeb != udeb || pkg.Component != component || (pkg.Architecture != arch && pkg.Architecture != "all") {
+						continue
+					}
+					stanza, err := r.writeDeb(pkg)
+					if err != nil {
+						return err
+					}
+					stanzas = append(stanzas, stanza)
+				}
+
+				dir := path.Join(component, "binary-"+arch)
+				if udeb {
+					dir = path.Join(component, "debian-installer", "binary-"+arch)
+				}
+				written, err := r.writeIndex(path.Join(dists, dir, "Packages"), strings.Join(stanzas, "\n"), cfg.Compressions)
+				if err != nil {
+					return err
+				}
+				for _, variant := range written {
+					indices = append(indices, path.Join(dir, variant))
+				}
+			}
+		}
+
+		var stanzas []string
+		for _, src := range cfg.Sources {
+			if src.Component != component {
+				continue
+			}
+			stanza, err := r.writeSource(src)
+			if err != nil {
+				return err
+			}
+			stanzas = append(stanzas, stanza)
+		}
+		if len(stanzas) > 0 {
+			written, err := r.writeIndex(path.Join(dists, component, "source", "Sources"), strings.Join(stanzas, "\n"), cfg.Compressions)
+			if err != nil {
+				return err
+			}
+			for _, variant := range written {
+				indices = append(indices, path.Join(component, "source", variant))
+			}
+		}
+	}
+
+	for _, rel := range slices.Sorted(maps.Keys(cfg.Extra)) {
+		if err := r.writeFile(path.Join(dists, rel), []byte(cfg.Extra[rel])); err != nil {
+			return err
+		}
+		indices = append(indices, rel)
+	}
+
+	var release strings.Builder
+	fmt.Fprintf(&release, "Origin: deb-for-all\nLabel: deb-for-all test\nSuite: %s\nCodename: %s\n", cfg.Suite, cfg.Codename)
+	fmt.Fprintf(&release, "Date: %s\n", time.Now().UTC().Format(time.RFC1123))
+	fmt.Fprintf(&release, "Architectures: %s\nComponents: %s\nDescription: Fixture repository\n", strings.Join(cfg.Architectures, " "), strings.Join(cfg.Components, " "))
+	for _, algorithm := range []string{"MD5Sum", "SHA256"} {
+		fmt.Fprintf(&release, "%s:\n", algorithm)
+		for _, index := range indices {
+			data, err := r.ReadFile(path.Join(dists, index))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&release, " %s %d %s\n", checksum(algorithm, data), len(data), index)
+		}
+	}
+	return r.writeFile(path.Join(dists, "Release"), []byte(release.String()))
+}
+
+// writeIndex writes content at rel with each compression variant and returns the
+// names of the files written.
+func (r *Repository) writeIndex(rel, content string, compressions []string) ([]string, error) {
+	var written []string
+	for _, ext := range compressions {
+		data, err := compress([]byte(content), ext)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.writeFile(rel+ext, data); err != nil {
+			return nil, err
+		}
+		written = append(written, path.Base(rel)+ext)
+	}
+	return written, nil
+}
+
+// writeDeb writes the .deb of pkg to the pool and returns its Packages stanza.
+func (r *Repository) writeDeb(pkg Package) (string, error) {
+	source := pkg.Source
+	if source == "" {
+		source = pkg.Name
+	}
+	ext := ".deb"
+	if pkg.Udeb {
+		ext = ".udeb"
+	}
+	filename := path.Join("pool", pkg.Component, poolPrefix(source), source, fmt.Sprintf("%s_%s_%s%s", pkg.Name, stripEpoch(pkg.Version), pkg.Architecture, ext))
+
+	deb, err := buildDeb(pkg)
+	if err != nil {
+		return "", fmt.Errorf("unable to build %s: %w", filename, err)
+	}
+	if err := r.writeFile(filename, deb); err != nil {
+		return "", err
+	}
+	r.filenames[pkg.Name+"_"+pkg.Architecture] = filename
+
+	fields := [][2]string{
+		{"Package", pkg.Name},
+		{"Source", pkg.Source},
+		{"Version", pkg.Version},
+		{"Architecture", pkg.Architecture},
+		{"Maintainer", maintainer(pkg.Maintainer)},
+		{"Installed-Size", "1"},
+		{"Pre-Depends", pkg.PreDepends},
+		{"Depends", pkg.Depends},
+		{"Recommends", pkg.Recommends},
+		{"Suggests", pkg.Suggests},
+		{"Provides", pkg.Provides},
+		{"Conflicts", pkg.Conflicts},
+		{"Breaks", pkg.Breaks},
+		{"Section", pkg.Section},
+		{"Priority", pkg.Priority},
+		{"Filename", filename},
+		{"Size", fmt.Sprint(len(deb))},
+		{"MD5sum", checksum("MD5Sum", deb)},
+		{"SHA256", checksum("SHA256", deb)},
+		{"Description", description(pkg.Name, pkg.Description)},
+	}
+	return formatStanza(fields), nil
+}
+
+// writeSource writes the files of src to the pool and returns its Sources stanza.
+func (r *Repository) writeSource(src Source) (string, error) {
+	directory := path.Join("pool", src.Component, poolPrefix(src.Name), src.Name)
+	upstream, _, _ := strings.Cut(stripEpoch(src.Version), "-")
+
+	orig, err := tarGz(map[string]string{src.Name + "-" + upstream + "/README": src.Name + " upstream sources\n"})
+	if err != nil {
+		return "", err
+	}
+	debianTar, err := tarGz(map[string]string{"debian/changelog": src.Name + " (" + src.Version + ") unstable; urgency=medium\n"})
+	if err != nil {
+		return "", err
+	}
+	if debianTar, err = compress(debianTar, ".xz"); err != nil {
+		return "", err
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{fmt.Sprintf("%s_%s.orig.tar.gz", src.Name, upstream), orig},
+		{fmt.Sprintf("%s_%s.debian.tar.xz", src.Name, stripEpoch(src.Version)), debianTar},
+	}
+
+	var dsc strings.Builder
+	fmt.Fprintf(&dsc, "Format: 3.0 (quilt)\nSource: %s\nVersion: %s\nMaintainer: %s\nFiles:\n", src.Name, src.Version, maintainer(""))
+	for _, file := range files {
+		fmt.Fprintf(&dsc, " %s %d %s\n", checksum("MD5Sum", file.data), len(file.data), file.name)
+	}
+	files = append(files, struct {
+		name string
+		data []byte
+	}{fmt.Sprintf("%s_%s.dsc", src.Name, stripEpoch(src.Version)), []byte(dsc.String())})
+
+	var md5s, sha256s strings.Builder
+	for _, file := range files {
+		if err := r.writeFile(path.Join(directory, file.name), file.data); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&md5s, "\n %s %d %s", checksum("MD5Sum", file.data), len(file.data), file.name)
+		fmt.Fprintf(&sha256s, "\n %s %d %s", checksum("SHA256", file.data), len(file.data), file.name)
+	}
+
+	fields := [][2]string{
+		{"Package", src.Name},
+		{"Binary", src.Name},
+		{"Version", src.Version},
+		{"Maintainer", maintainer("")},
+		{"Format", "3.0 (quilt)"},
+		{"Directory", directory},
+		{"Files", md5s.String()},
+		{"Checksums-Sha256", sha256s.String()},
+	}
+	return formatStanza(fields), nil
+}
+
+// sign writes InRelease and Release.gpg signed with a key generated for the test,
+// and its public keyring under keyDir.
+func (r *Repository) sign(keyDir string) error {
+	key, err := crypto.PGP().KeyGeneration().AddUserId("deb-for-all test", "test@example.com").New().GenerateKey()
+	if err != nil {
+		return fmt.Errorf("unable to generate key: %w", err)
+	}
+	signer, err := crypto.PGP().Sign().SigningKey(key).New()
+	if err != nil {
+		return err
+	}
+	detached, err := crypto.PGP().Sign().SigningKey(key).Detached().New()
+	if err != nil {
+		return err
+	}
+
+	releasePath := path.Join("dists", r.Suite, "Release")
+	release, err := r.ReadFile(releasePath)
+	if err != nil {
+		return err
+	}
+	inRelease, err := signer.SignCleartext(release)
+	if err != nil {
+		return err
+	}
+	signature, err := detached.Sign(release, crypto.Armor)
+	if err != nil {
+		return err
+	}
+	if err := r.writeFile(path.Join("dists", r.Suite, "InRelease"), inRelease); err != nil {
+		return err
+	}
+	if err := r.writeFile(releasePath+".gpg", signature); err != nil {
+		return err
+	}
+
+	publicKey, err := key.GetPublicKey()
+	if err != nil {
+		return err
+	}
+	r.KeyringPath = filepath.Join(keyDir, "fixture.gpg")
+	r.Fingerprint = strings.ToUpper(key.GetFingerprint())
+	return os.WriteFile(r.KeyringPath, publicKey, 0o644)
+}
+
+func (r *Repository) writeFile(rel string, data []byte) error {
+	target := filepath.Join(r.Root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(target, data, 0o644)
+}
+
+// formatStanza formats fields as a deb822 stanza, skipping empty values.
+func formatStanza(fields [][2]string) string {
+	var sb strings.Builder
+	for _, field := range fields {
+		if field[1] != "" {
+			fmt.Fprintf(&sb, "%s: %s\n", field[0], field[1])
+		}
+	}
+	return sb.String()
+}
+
+func compress(data []byte, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	switch ext {
+	case "":
+		return data, nil
+	case ".gz":
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	case ".xz":
+		writer, err := xz.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", ext)
+	}
+	return buf.Bytes(), nil
+}
+
+func checksum(algorithm string, data []byte) string {
+	if algorithm == "MD5Sum" {
+		return fmt.Sprintf("%x", md5.Sum(data))
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// poolPrefix returns the pool directory of a source package: lib packages use four
+// characters, e.g. libc/libcurl, others their first letter.
+func poolPrefix(source string) string {
+	if strings.HasPrefix(source, "lib") && len(source) > 3 {
+		return source[:4]
+	}
+	return source[:1]
+}
+
+func stripEpoch(version string) string {
+	if _, after, found := strings.Cut(version, ":"); found {
+		return after
+	}
+	return version
+}
+
+func maintainer(value string) string {
+	if value == "" {
+		return "Test Maintainer <test@example.com>"
+	}
+	return value
+}
+
+func description(name, value string) string {
+	if value == "" {
+		return "fixture package " + name
+	}
+	return value
+}
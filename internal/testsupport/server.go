@@ -0,0 +1,140 @@
+package testsupport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server serves a repository directory and injects the faults configured per path.
+// Paths are relative to the repository root, e.g. dists/bookworm/InRelease.
+type server struct {
+	httpServer *httptest.Server
+	root       string
+
+	mu        sync.Mutex
+	corrupt   map[string]bool
+	notFound  map[string]bool
+	throttled map[string]int
+	delays    map[string]time.Duration
+	requests  map[string]int
+}
+
+func newServer(root string) *server {
+	s := &server{
+		root:      root,
+		corrupt:   make(map[string]bool),
+		notFound:  make(map[string]bool),
+		throttled: make(map[string]int),
+		delays:    make(map[string]time.Duration),
+		requests:  make(map[string]int),
+	}
+	s.httpServer = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Corrupt makes the server flip the bytes of the file at rel, keeping its size, so
+// that checksum verification fails.
+func (s *server) Corrupt(rel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corrupt[cleanPath(rel)] = true
+}
+
+// NotFound makes the server answer 404 for rel, whether the file exists or not.
+func (s *server) NotFound(rel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notFound[cleanPath(rel)] = true
+}
+
+// Throttle makes the server answer the next times requests for rel with 429 Too Many
+// Requests and Retry-After: 0.
+func (s *server) Throttle(rel string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttled[cleanPath(rel)] = times
+}
+
+// Slow delays every answer for rel by d, or until the request is canceled.
+func (s *server) Slow(rel string, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays[cleanPath(rel)] = d
+}
+
+// Reset removes every fault configured so far; request counts are kept.
+func (s *server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.corrupt)
+	clear(s.notFound)
+	clear(s.throttled)
+	clear(s.delays)
+}
+
+// Requests returns how many requests were received for rel, faulty answers included.
+func (s *server) Requests(rel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[cleanPath(rel)]
+}
+
+func (s *server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	rel := cleanPath(req.URL.Path)
+
+	s.mu.Lock()
+	s.requests[rel]++
+	delay := s.delays[rel]
+	notFound := s.notFound[rel]
+	corrupt := s.corrupt[rel]
+	throttled := s.throttled[rel] > 0
+	if throttled {
+		s.throttled[rel]--
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	if throttled {
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if notFound {
+		http.NotFound(w, req)
+		return
+	}
+
+	file := filepath.Join(s.root, filepath.FromSlash(rel))
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		http.NotFound(w, req)
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if corrupt {
+		for i := range data {
+			data[i] ^= 0xff
+		}
+	}
+	http.ServeContent(w, req, path.Base(rel), info.ModTime(), strings.NewReader(string(data)))
+}
+
+func cleanPath(rel string) string {
+	return strings.TrimPrefix(path.Clean("/"+rel), "/")
+}
@@ -0,0 +1,163 @@
+package debian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Architecture pseudo-values accepted wherever architectures are configured.
+const (
+	ArchitectureAllAvailable      = "*"             // Every architecture the suite's Release file lists
+	ArchitectureAllAvailableAlias = "all-available" // Same as ArchitectureAllAvailable
+	ArchitectureHost              = "host"          // The Debian architecture of the running machine
+)
+
+// KnownArchitectures are the architectures of the Debian archive, released and ports
+// alike, e.g. to complete architecture names.
+var KnownArchitectures = []string{
+	"amd64", "arm64", "armel", "armhf", "i386", "mips64el", "ppc64el", "riscv64", "s390x",
+	"alpha", "hppa", "loong64", "m68k", "powerpc", "ppc64", "sh4", "sparc64", "x32",
+}
+
+// ErrUnsupportedHostArchitecture is returned when runtime.GOARCH has no Debian
+// architecture equivalent.
+var ErrUnsupportedHostArchitecture = errors.New("host architecture has no Debian equivalent")
+
+// goarchToDebian maps Go architecture names to Debian ones.
+var goarchToDebian = map[string]string{
+	"amd64":   "amd64",
+	"arm64":   "arm64",
+	"arm":     "armhf",
+	"386":     "i386",
+	"ppc64le": "ppc64el",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// HostArchitecture returns the Debian architecture of the running machine, e.g.
+// "armhf" for GOARCH=arm or "ppc64el" for GOARCH=ppc64le.
+func HostArchitecture() (string, error) {
+	return debianArchitecture(runtime.GOARCH)
+}
+
+// debianArchitecture maps a GOARCH value to its Debian architecture name.
+func debianArchitecture(goarch string) (string, error) {
+	arch, ok := goarchToDebian[goarch]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedHostArchitecture, goarch)
+	}
+	return arch, nil
+}
+
+// isAllAvailableArchitecture reports whether arch requests every Release architecture.
+func isAllAvailableArchitecture(arch string) bool {
+	arch = strings.TrimSpace(arch)
+	return arch == ArchitectureAllAvailable || strings.EqualFold(arch, ArchitectureAllAvailableAlias)
+}
+
+// isHostArchitecture reports whether arch requests the running machine's architecture.
+func isHostArchitecture(arch string) bool {
+	return strings.EqualFold(strings.TrimSpace(arch), ArchitectureHost)
+}
+
+// HasAllAvailableArchitecture reports whether architectures contains "*" or
+// "all-available", whose expansion needs the suite's Release file.
+func HasAllAvailableArchitecture(architectures []string) bool {
+	return slices.ContainsFunc(architectures, isAllAvailableArchitecture)
+}
+
+// expandHostArchitecture replaces "host" with HostArchitecture and drops the
+// duplicates this creates. Other entries, including "*", are kept as is.
+func expandHostArchitecture(architectures []string) ([]string, error) {
+	if !slices.ContainsFunc(architectures, isHostArchitecture) {
+		return architectures, nil
+	}
+
+	host, err := HostArchitecture()
+	if err != nil {
+		return nil, err
+	}
+	expanded := make([]string, 0, len(architectures))
+	for _, arch := range architectures {
+		if isHostArchitecture(arch) {
+			arch = host
+		}
+		if !slices.Contains(expanded, arch) {
+			expanded = append(expanded, arch)
+		}
+	}
+	return expanded, nil
+}
+
+// ExpandArchitectures replaces the pseudo-values in architectures: "host" becomes
+// HostArchitecture, and "*" or "all-available" become the available architectures
+// (those of the Release file) without "all" and "source". Order is kept and
+// duplicates are dropped.
+func ExpandArchitectures(architectures, available []string) ([]string, error) {
+	architectures, err := expandHostArchitecture(architectures)
+	if err != nil || !HasAllAvailableArchitecture(architectures) {
+		return architectures, err
+	}
+
+	expanded := make([]string, 0, len(architectures)+len(available))
+	add := func(arch string) {
+		if !slices.Contains(expanded, arch) {
+			expanded = append(expanded, arch)
+		}
+	}
+
+	for _, arch := range architectures {
+		if !isAllAvailableArchitecture(arch) {
+			add(arch)
+			continue
+		}
+		found := false
+		for _, releaseArch := range available {
+			if releaseArch == "all" || releaseArch == SourceArchitecture {
+				continue
+			}
+			add(releaseArch)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("cannot expand %q: the Release file lists no binary architecture", arch)
+		}
+	}
+
+	return expanded, nil
+}
+
+// ExpandArchitectures applies ExpandArchitectures to r.Architectures, fetching the
+// Release file first when "*" or "all-available" is configured and it is not loaded.
+// FetchReleaseFile, FetchPackages and FetchAndCachePackages call it automatically.
+func (r *Repository) ExpandArchitectures() error {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	return r.expandArchitectures()
+}
+
+// expandArchitectures implements ExpandArchitectures; fetchMu must be held.
+func (r *Repository) expandArchitectures() error {
+	if !slices.ContainsFunc(r.Architectures, isHostArchitecture) && !HasAllAvailableArchitecture(r.Architectures) {
+		return nil
+	}
+
+	var available []string
+	if HasAllAvailableArchitecture(r.Architectures) {
+		if r.ReleaseInfo == nil {
+			return r.fetchReleaseFile(context.Background()) // Expands once the Release file is parsed
+		}
+		available = r.ReleaseInfo.Architectures
+	}
+
+	expanded, err := ExpandArchitectures(r.Architectures, available)
+	if err != nil {
+		return err
+	}
+	r.Architectures = expanded
+	return nil
+}
@@ -0,0 +1,98 @@
+package debian
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDebianArchitectureMapping(t *testing.T) {
+	for goarch, want := range map[string]string{
+		"amd64":   "amd64",
+		"arm64":   "arm64",
+		"arm":     "armhf",
+		"386":     "i386",
+		"ppc64le": "ppc64el",
+		"s390x":   "s390x",
+		"riscv64": "riscv64",
+	} {
+		if got, err := debianArchitecture(goarch); err != nil || got != want {
+			t.Errorf("debianArchitecture(%q) = %q, %v; want %q", goarch, got, err, want)
+		}
+	}
+
+	if _, err := debianArchitecture("wasm"); !errors.Is(err, ErrUnsupportedHostArchitecture) {
+		t.Fatalf("expected ErrUnsupportedHostArchitecture, got %v", err)
+	}
+	if _, ok := goarchToDebian[runtime.GOARCH]; ok {
+		if _, err := HostArchitecture(); err != nil {
+			t.Fatalf("HostArchitecture: %v", err)
+		}
+	}
+}
+
+func TestExpandArchitectures(t *testing.T) {
+	available := []string{"all", "amd64", "arm64", "i386"}
+
+	for _, tc := range []struct {
+		configured []string
+		want       []string
+	}{
+		{[]string{"amd64"}, []string{"amd64"}},
+		{[]string{"*"}, []string{"amd64", "arm64", "i386"}},
+		{[]string{"all-available"}, []string{"amd64", "arm64", "i386"}},
+		{[]string{"arm64", "*"}, []string{"arm64", "amd64", "i386"}},
+		{[]string{"*", "source"}, []string{"amd64", "arm64", "i386", "source"}},
+	} {
+		got, err := ExpandArchitectures(tc.configured, available)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("ExpandArchitectures(%v) = %v, %v; want %v", tc.configured, got, err, tc.want)
+		}
+	}
+
+	if _, err := ExpandArchitectures([]string{"*"}, []string{"all"}); err == nil {
+		t.Fatal("expected an error when the Release file lists no binary architecture")
+	}
+
+	host, err := HostArchitecture()
+	if err != nil {
+		t.Skipf("no Debian architecture for %s", runtime.GOARCH)
+	}
+	got, err := ExpandArchitectures([]string{"host", host}, nil)
+	if err != nil || !slices.Equal(got, []string{host}) {
+		t.Fatalf("expected host to expand to %s once, got %v, %v", host, got, err)
+	}
+}
+
+func TestFetchPackagesExpandsAllAvailableArchitectures(t *testing.T) {
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/dists/bookworm/Release":
+			fmt.Fprint(w, "Suite: bookworm\nArchitectures: all amd64 arm64\nComponents: main\n")
+		case strings.HasSuffix(r.URL.Path, "/Packages"):
+			arch := strings.TrimPrefix(strings.Split(r.URL.Path, "/")[4], "binary-")
+			fetched = append(fetched, arch)
+			fmt.Fprintf(w, "Package: hello\nVersion: 2.10-3\nArchitecture: %s\nFilename: pool/main/h/hello/hello_2.10-3_%s.deb\n\n", arch, arch)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"*"})
+	repo.VerifyRelease = false
+	repo.DisableSignatureVerification()
+
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages: %v", err)
+	}
+	if want := []string{"amd64", "arm64"}; !slices.Equal(repo.Architectures, want) || !slices.Equal(slices.Compact(fetched), want) {
+		t.Fatalf("expected %v to be expanded and fetched, got %v and %v", want, repo.Architectures, fetched)
+	}
+}
@@ -0,0 +1,285 @@
+package debian
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Changes represents a parsed Debian .changes file describing an upload.
+type Changes struct {
+	Format        string
+	Date          string
+	Source        string
+	Binary        []string
+	Architectures []string
+	Version       string
+	Distribution  string
+	Urgency       string
+	Maintainer    string
+	ChangedBy     string
+	Closes        []string
+	Files         []ChangesFile
+}
+
+// ChangesFile represents a single artifact referenced by a .changes file.
+type ChangesFile struct {
+	Name     string
+	Size     int64
+	Section  string
+	Priority string
+	MD5Sum   string
+	SHA1     string
+	SHA256   string
+}
+
+// ParseChanges parses a .changes file. Clearsigned input is accepted;
+// the signature itself is not verified here.
+func ParseChanges(r io.Reader) (*Changes, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading changes file: %w", err)
+	}
+
+	if isClearsigned(data) {
+		data, err = extractClearsignedContent(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := &Changes{}
+	files := make(map[string]*ChangesFile)
+	var order []string
+
+	fileEntry := func(name string, size int64) *ChangesFile {
+		file, ok := files[name]
+		if !ok {
+			file = &ChangesFile{Name: name, Size: size}
+			files[name] = file
+			order = append(order, name)
+		}
+		return file
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	buf := make([]byte, 0, packagesInitialAlloc)
+	scanner.Buffer(buf, packagesBufferSize)
+
+	currentField := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmedLine := strings.TrimSpace(line)
+		if trimmedLine == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			parts := strings.Fields(trimmedLine)
+			switch currentField {
+			case "files":
+				if len(parts) < 5 {
+					continue
+				}
+				size, err := strconv.ParseInt(parts[1], 10, 64)
+				if err != nil {
+					continue
+				}
+				file := fileEntry(parts[4], size)
+				file.MD5Sum = strings.ToLower(parts[0])
+				file.Section = parts[2]
+				file.Priority = parts[3]
+			case "checksums-sha1", "checksums-sha256":
+				if len(parts) < 3 {
+					continue
+				}
+				size, err := strconv.ParseInt(parts[1], 10, 64)
+				if err != nil {
+					continue
+				}
+				file := fileEntry(parts[2], size)
+				if currentField == "checksums-sha1" {
+					file.SHA1 = strings.ToLower(parts[0])
+				} else {
+					file.SHA256 = strings.ToLower(parts[0])
+				}
+			}
+			continue
+		}
+
+		colonIndex := strings.Index(trimmedLine, ":")
+		if colonIndex == -1 {
+			continue
+		}
+
+		field := strings.ToLower(strings.TrimSpace(trimmedLine[:colonIndex]))
+		value := strings.TrimSpace(trimmedLine[colonIndex+1:])
+		currentField = field
+
+		switch field {
+		case "format":
+			changes.Format = value
+		case "date":
+			changes.Date = value
+		case "source":
+			changes.Source = value
+		case "binary":
+			changes.Binary = strings.Fields(value)
+		case "architecture":
+			changes.Architectures = strings.Fields(value)
+		case "version":
+			changes.Version = value
+		case "distribution":
+			changes.Distribution = value
+		case "urgency":
+			changes.Urgency = value
+		case "maintainer":
+			changes.Maintainer = value
+		case "changed-by":
+			changes.ChangedBy = value
+		case "closes":
+			changes.Closes = strings.Fields(value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading changes file: %w", err)
+	}
+
+	if changes.Source == "" || changes.Version == "" {
+		return nil, fmt.Errorf("invalid changes file: missing required fields (Source, Version)")
+	}
+
+	for _, name := range order {
+		changes.Files = append(changes.Files, *files[name])
+	}
+
+	return changes, nil
+}
+
+// IsSourceOnly reports whether the upload contains only source artifacts.
+func (c *Changes) IsSourceOnly() bool {
+	if len(c.Architectures) == 0 {
+		return false
+	}
+	for _, arch := range c.Architectures {
+		if arch != "source" {
+			return false
+		}
+	}
+	return true
+}
+
+// DownloadFromChanges fetches a .changes file and every artifact it references
+// from the same remote directory into destDir, verifying each file against the
+// listed checksums. When KeyringPaths is set, the .changes signature is verified
+// with gpgv before any artifact is downloaded.
+func (d *Downloader) DownloadFromChanges(changesURL, destDir string) (*Changes, error) {
+	resp, err := d.doRequestWithRetry(http.MethodGet, changesURL, true)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving changes file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading changes file: %w", err)
+	}
+
+	if len(d.KeyringPaths) > 0 {
+		if !isClearsigned(data) {
+			return nil, fmt.Errorf("changes file %s is not signed", changesURL)
+		}
+		if _, err := verifyWithGPG(data, nil, true, d.KeyringPaths, d.TempDir); err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := ParseChanges(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(destDir, DirPermission); err != nil {
+		return nil, fmt.Errorf("unable to create destination directory: %w", err)
+	}
+
+	// The artifacts sit next to the .changes file; a query string belongs to neither
+	parsed, err := url.Parse(changesURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid changes URL %s: %w", changesURL, err)
+	}
+	changesName := path.Base(parsed.Path)
+	if err := checkFileName(changesName); err != nil {
+		return nil, err
+	}
+	base := *parsed
+	base.Path, base.RawPath, base.RawQuery, base.Fragment = path.Dir(parsed.Path), "", "", ""
+	baseURL := base.String()
+	if err := os.WriteFile(filepath.Join(destDir, changesName), data, FilePermission); err != nil {
+		return nil, fmt.Errorf("error writing changes file: %w", err)
+	}
+
+	for _, file := range changes.Files {
+		// The names come from the .changes file, which is usually not signed
+		if err := checkFileName(file.Name); err != nil {
+			return nil, err
+		}
+		destPath := filepath.Join(destDir, file.Name)
+		if err := d.downloadToFile(PoolURL(baseURL, file.Name), destPath, nil); err != nil {
+			return nil, fmt.Errorf("error downloading %s: %w", file.Name, err)
+		}
+
+		if err := verifyChangesFile(d, destPath, file); err != nil {
+			// Do not leave an unverified file where a later run would trust it
+			os.Remove(destPath)
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+// verifyChangesFile checks the file downloaded to destPath against the size and
+// checksum file lists, preferring SHA256 over SHA1 and SHA1 over MD5. A file listed
+// without any checksum is rejected.
+func verifyChangesFile(d *Downloader, destPath string, file ChangesFile) error {
+	info, err := os.Stat(destPath)
+	if err != nil {
+		return err
+	}
+	if file.Size > 0 && info.Size() != file.Size {
+		return fmt.Errorf("size mismatch for %s: expected %d bytes, got %d", file.Name, file.Size, info.Size())
+	}
+
+	switch {
+	case file.SHA256 != "":
+		if err := d.verifyChecksum(destPath, file.SHA256, "sha256"); err != nil {
+			return fmt.Errorf("SHA256 verification failed for %s: %w", file.Name, err)
+		}
+	case file.SHA1 != "":
+		if err := d.verifyChecksum(destPath, file.SHA1, "sha1"); err != nil {
+			return fmt.Errorf("SHA1 verification failed for %s: %w", file.Name, err)
+		}
+	case file.MD5Sum != "":
+		if err := d.verifyChecksum(destPath, file.MD5Sum, "md5"); err != nil {
+			return fmt.Errorf("MD5 verification failed for %s: %w", file.Name, err)
+		}
+	default:
+		return fmt.Errorf("no checksum listed for %s", file.Name)
+	}
+	return nil
+}
+
+// isClearsigned reports whether data is an OpenPGP clearsigned message.
+func isClearsigned(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP SIGNED MESSAGE-----"))
+}
@@ -0,0 +1,192 @@
+package debian
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseChangesSourceOnly(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "hello_2.10-3_source.changes"))
+	if err != nil {
+		t.Fatalf("unable to open fixture: %v", err)
+	}
+	defer file.Close()
+
+	changes, err := ParseChanges(file)
+	if err != nil {
+		t.Fatalf("failed to parse changes: %v", err)
+	}
+
+	if changes.Source != "hello" || changes.Version != "2.10-3" || changes.Distribution != "unstable" {
+		t.Fatalf("unexpected header fields: %+v", changes)
+	}
+	if !changes.IsSourceOnly() {
+		t.Fatalf("expected source-only upload, got architectures %v", changes.Architectures)
+	}
+	if len(changes.Files) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(changes.Files))
+	}
+
+	orig := changes.Files[1]
+	if orig.Name != "hello_2.10.orig.tar.gz" || orig.Size != 725946 || orig.Section != "devel" || orig.Priority != "optional" {
+		t.Fatalf("unexpected orig entry: %+v", orig)
+	}
+	if orig.MD5Sum == "" || orig.SHA1 == "" || orig.SHA256 == "" {
+		t.Fatalf("expected all checksums for orig tarball, got %+v", orig)
+	}
+}
+
+func TestParseChangesBinary(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "hello_2.10-3_amd64.changes"))
+	if err != nil {
+		t.Fatalf("unable to open fixture: %v", err)
+	}
+	defer file.Close()
+
+	changes, err := ParseChanges(file)
+	if err != nil {
+		t.Fatalf("failed to parse changes: %v", err)
+	}
+
+	if changes.IsSourceOnly() {
+		t.Fatalf("binary upload reported as source-only")
+	}
+	if len(changes.Binary) != 2 || changes.Binary[1] != "hello-dbgsym" {
+		t.Fatalf("unexpected binary list: %v", changes.Binary)
+	}
+	if len(changes.Files) != 2 || changes.Files[1].Section != "debug" {
+		t.Fatalf("unexpected files: %+v", changes.Files)
+	}
+}
+
+func TestDownloadFromChanges(t *testing.T) {
+	payload := []byte("fake deb content")
+	changesContent := fmt.Sprintf("Source: demo\nVersion: 1.0-1\nArchitecture: all\nChecksums-Sha256:\n %x %d demo_1.0-1_all.deb\nFiles:\n %x %d misc optional demo_1.0-1_all.deb\n",
+		sha256.Sum256(payload), len(payload), md5.Sum(payload), len(payload))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/incoming/demo_1.0-1_all.changes":
+			w.Write([]byte(changesContent))
+		case "/incoming/demo_1.0-1_all.deb":
+			w.Write(payload)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	destDir := t.TempDir()
+	changes, err := NewDownloader().DownloadFromChanges(server.URL+"/incoming/demo_1.0-1_all.changes", destDir)
+	if err != nil {
+		t.Fatalf("download from changes failed: %v", err)
+	}
+	if len(changes.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(changes.Files))
+	}
+
+	for _, name := range []string{"demo_1.0-1_all.changes", "demo_1.0-1_all.deb"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err != nil {
+			t.Fatalf("expected %s in destination: %v", name, err)
+		}
+	}
+}
+
+func TestDownloadFromChangesRejectsPathNames(t *testing.T) {
+	changesContent := "Source: demo\nVersion: 1.0-1\nFiles:\n d41d8cd98f00b204e9800998ecf8427e 0 misc optional ../../.bashrc\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/incoming/demo_1.0-1_all.changes" {
+			w.Write([]byte(changesContent))
+			return
+		}
+		w.Write([]byte("overwritten"))
+	}))
+	defer server.Close()
+
+	parent := t.TempDir()
+	destDir := filepath.Join(parent, "a", "b")
+	_, err := NewDownloader().DownloadFromChanges(server.URL+"/incoming/demo_1.0-1_all.changes", destDir)
+	if !errors.Is(err, ErrInvalidFileName) {
+		t.Fatalf("expected ErrInvalidFileName, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, ".bashrc")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written outside the destination, got %v", err)
+	}
+}
+
+func TestDownloadFromChangesRemovesUnverifiedFiles(t *testing.T) {
+	payload := []byte("fake deb content")
+	served := payload
+	changesContent := func() string {
+		return fmt.Sprintf("Source: demo\nVersion: 1.0-1\nChecksums-Sha256:\n %x %d demo_1.0-1_all.deb\nFiles:\n %x %d misc optional demo_1.0-1_all.deb\n",
+			sha256.Sum256(payload), len(payload), md5.Sum(payload), len(payload))
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/incoming/demo_1.0-1_all.changes":
+			w.Write([]byte(changesContent()))
+		case "/incoming/demo_1.0-1_all.deb":
+			w.Write(served)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// A query string is not part of the file names
+	destDir := t.TempDir()
+	if _, err := NewDownloader().DownloadFromChanges(server.URL+"/incoming/demo_1.0-1_all.changes?token=abc", destDir); err != nil {
+		t.Fatalf("download from changes failed: %v", err)
+	}
+	for _, name := range []string{"demo_1.0-1_all.changes", "demo_1.0-1_all.deb"} {
+		if _, err := os.Stat(filepath.Join(destDir, name)); err != nil {
+			t.Fatalf("expected %s in destination: %v", name, err)
+		}
+	}
+
+	for name, content := range map[string][]byte{
+		"size":     []byte("short"),
+		"checksum": []byte("fake deb CONTENT"),
+	} {
+		served = content
+		destDir := t.TempDir()
+		if _, err := NewDownloader().DownloadFromChanges(server.URL+"/incoming/demo_1.0-1_all.changes", destDir); err == nil {
+			t.Fatalf("%s: expected the mismatch to be reported", name)
+		}
+		if _, err := os.Stat(filepath.Join(destDir, "demo_1.0-1_all.deb")); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected the unverified file to be removed, got %v", name, err)
+		}
+	}
+}
+
+func TestVerifyChangesFileChecksums(t *testing.T) {
+	payload := []byte("fake deb content")
+	destPath := filepath.Join(t.TempDir(), "demo_1.0-1_all.deb")
+	if err := os.WriteFile(destPath, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	downloader := NewDownloader()
+
+	file := ChangesFile{Name: "demo_1.0-1_all.deb", Size: int64(len(payload)), SHA1: fmt.Sprintf("%x", sha1.Sum(payload))}
+	if err := verifyChangesFile(downloader, destPath, file); err != nil {
+		t.Fatalf("expected a matching SHA1 to be accepted, got %v", err)
+	}
+
+	file.SHA1 = fmt.Sprintf("%x", sha1.Sum([]byte("other content")))
+	if err := verifyChangesFile(downloader, destPath, file); err == nil {
+		t.Fatal("expected a SHA1 mismatch to be reported")
+	}
+
+	file.SHA1 = ""
+	if err := verifyChangesFile(downloader, destPath, file); err == nil {
+		t.Fatal("expected a file without checksum to be rejected")
+	}
+}
@@ -0,0 +1,290 @@
+package debian
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Limits applied while listing a .deb, so a hostile archive cannot make the
+// listing run away with memory or time.
+const (
+	debMaxEntries      = 1 << 20     // Entries in data.tar
+	debMaxPathDepth    = 128         // Path components per entry
+	debMaxUncompressed = 64 << 30    // Decompressed bytes read from a member
+	debMaxMD5SumsSize  = 64 << 20    // Size of the md5sums control file
+	debMaxZstdWindow   = 128 << 20   // zstd window accepted for a member
+	debMaxMemberCount  = 16          // ar members scanned
+	arMagic            = "!<arch>\n" // ar global header
+	arHeaderSize       = 60          // ar member header length
+)
+
+// ErrDebLimitExceeded is returned by ReadDebFileList when the archive exceeds one
+// of the listing limits.
+var ErrDebLimitExceeded = fmt.Errorf("deb archive exceeds listing limits")
+
+// DebEntry describes one filesystem entry shipped by a binary package.
+type DebEntry struct {
+	Path string      // Installed path, e.g. /usr/bin/hello
+	Size int64       // Size in bytes; 0 for directories and links
+	Mode os.FileMode // Permission and type bits; symlinks carry os.ModeSymlink
+	MD5  string      // From the md5sums control file; empty when not listed there
+	Link string      // Target of a symbolic or hard link
+}
+
+// IsHardLink reports whether the entry is a hard link to another file of the package.
+func (e DebEntry) IsHardLink() bool {
+	return e.Link != "" && e.Mode&os.ModeSymlink == 0
+}
+
+// ReadDebFileList lists the files shipped by the .deb at debPath. The data.tar member
+// is walked header by header without writing anything to disk, and MD5 sums are
+// taken from the md5sums control file. data.tar may be uncompressed, gzip, xz or zstd.
+func ReadDebFileList(debPath string) ([]DebEntry, error) {
+	file, err := os.Open(debPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", debPath, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("%s is not a Debian binary package", debPath)
+	}
+
+	var entries []DebEntry
+	var md5sums map[string]string
+	seenData := false
+
+	for members := 0; ; members++ {
+		if members >= debMaxMemberCount {
+			return nil, fmt.Errorf("%w: more than %d ar members", ErrDebLimitExceeded, debMaxMemberCount)
+		}
+
+		name, size, err := readArHeader(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", debPath, err)
+		}
+
+		member := io.LimitReader(reader, size)
+		switch {
+		case strings.HasPrefix(name, "control.tar"):
+			md5sums, err = readDebMD5Sums(name, member)
+		case strings.HasPrefix(name, "data.tar"):
+			entries, err = readDebDataEntries(name, member)
+			seenData = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from %s: %w", name, debPath, err)
+		}
+
+		// Skip what the member reader left over plus the padding to an even offset
+		if _, err := io.Copy(io.Discard, member); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", debPath, err)
+		}
+		if size%2 == 1 {
+			if _, err := reader.Discard(1); err != nil && err != io.EOF {
+				return nil, fmt.Errorf("error reading %s: %w", debPath, err)
+			}
+		}
+	}
+
+	if !seenData {
+		return nil, fmt.Errorf("%s has no data.tar member", debPath)
+	}
+
+	for i := range entries {
+		entries[i].MD5 = md5sums[strings.TrimPrefix(entries[i].Path, "/")]
+	}
+
+	return entries, nil
+}
+
+// readArHeader reads the next ar member header and returns its name and size.
+func readArHeader(reader *bufio.Reader) (string, int64, error) {
+	header := make([]byte, arHeaderSize)
+	n, err := io.ReadFull(reader, header)
+	if n == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return "", 0, io.EOF
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("truncated ar header: %w", err)
+	}
+	if string(header[58:60]) != "`\n" {
+		return "", 0, fmt.Errorf("malformed ar header")
+	}
+
+	name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+	size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("invalid size for ar member %q", name)
+	}
+
+	return name, size, nil
+}
+
+// debMemberReader decompresses a control.tar.* or data.tar.* member according to its
+// suffix, capping the decompressed stream at debMaxUncompressed bytes.
+func debMemberReader(name string, member io.Reader) (io.Reader, func(), error) {
+	var reader io.Reader
+	cleanup := func() {}
+
+	switch path.Ext(name) {
+	case ".tar":
+		reader = member
+	case ".gz":
+		gzReader, err := gzip.NewReader(member)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error during gzip decompression: %w", err)
+		}
+		reader, cleanup = gzReader, func() { gzReader.Close() }
+	case ".xz":
+		xzReader, err := xz.NewReader(member)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error during xz decompression: %w", err)
+		}
+		reader = xzReader
+	case ".zst":
+		zstdReader, err := zstd.NewReader(member, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(debMaxZstdWindow))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error during zstd decompression: %w", err)
+		}
+		reader, cleanup = zstdReader, zstdReader.Close
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression format: %s", path.Ext(name))
+	}
+
+	return &debLimitReader{reader: reader, remaining: debMaxUncompressed}, cleanup, nil
+}
+
+// debLimitReader fails with ErrDebLimitExceeded once more than remaining bytes are read.
+type debLimitReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (l *debLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, fmt.Errorf("%w: more than %d decompressed bytes", ErrDebLimitExceeded, int64(debMaxUncompressed))
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// readDebMD5Sums extracts the md5sums control file, keyed by path without a leading slash.
+func readDebMD5Sums(name string, member io.Reader) (map[string]string, error) {
+	reader, cleanup, err := debMemberReader(name, member)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(header.Name) != "md5sums" {
+			continue
+		}
+		if header.Size > debMaxMD5SumsSize {
+			return nil, fmt.Errorf("%w: md5sums is %d bytes", ErrDebLimitExceeded, header.Size)
+		}
+
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		return parseMD5Sums(data), nil
+	}
+}
+
+// parseMD5Sums parses "<md5>  <path>" lines as written by dh_md5sums.
+func parseMD5Sums(data []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, packagesInitialAlloc), packagesBufferSize)
+	for scanner.Scan() {
+		sum, file, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		file = strings.TrimPrefix(strings.TrimLeft(file, " *"), "./")
+		sums[strings.TrimPrefix(file, "/")] = strings.ToLower(sum)
+	}
+	return sums
+}
+
+// readDebDataEntries walks the data.tar member and returns its entries.
+func readDebDataEntries(name string, member io.Reader) ([]DebEntry, error) {
+	reader, cleanup, err := debMemberReader(name, member)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	var entries []DebEntry
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			if errors.Is(err, ErrDebLimitExceeded) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("invalid tar stream: %w", err)
+		}
+
+		entryPath := debEntryPath(header.Name)
+		if entryPath == "/" {
+			continue
+		}
+		if len(entries) >= debMaxEntries {
+			return nil, fmt.Errorf("%w: more than %d entries", ErrDebLimitExceeded, debMaxEntries)
+		}
+		if depth := strings.Count(entryPath, "/"); depth > debMaxPathDepth {
+			return nil, fmt.Errorf("%w: %s is %d levels deep", ErrDebLimitExceeded, header.Name, depth)
+		}
+
+		entry := DebEntry{Path: entryPath, Mode: header.FileInfo().Mode()}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			entry.Size = header.Size
+		case tar.TypeSymlink:
+			entry.Link = header.Linkname
+		case tar.TypeLink:
+			entry.Link = debEntryPath(header.Linkname)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// debEntryPath turns a data.tar member name such as ./usr/bin/ into /usr/bin.
+func debEntryPath(name string) string {
+	return path.Clean("/" + strings.TrimPrefix(name, "./"))
+}
@@ -0,0 +1,169 @@
+package debian
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// buildTestTar returns a tar stream holding the given headers, with body text for regular files.
+func buildTestTar(t *testing.T, headers []*tar.Header, bodies map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, header := range headers {
+		body := bodies[header.Name]
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(body))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("tar header: %v", err)
+		}
+		if body != "" {
+			tw.Write([]byte(body))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// compressTestMember compresses data for a member named with the given extension.
+func compressTestMember(t *testing.T, data []byte, ext string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	switch ext {
+	case ".gz":
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+	case ".xz":
+		zw, err := xz.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("xz writer: %v", err)
+		}
+		zw.Write(data)
+		zw.Close()
+	case ".zst":
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("zstd writer: %v", err)
+		}
+		zw.Write(data)
+		zw.Close()
+	default:
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
+// writeTestDeb writes an ar archive with debian-binary, control.tar.gz and data.tar<dataExt>.
+func writeTestDeb(t *testing.T, dataExt string, dataHeaders []*tar.Header, bodies map[string]string, md5sums string) string {
+	t.Helper()
+	control := buildTestTar(t, []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./md5sums", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{"./md5sums": md5sums})
+	data := buildTestTar(t, dataHeaders, bodies)
+
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", compressTestMember(t, control, ".gz")},
+		{"data.tar" + dataExt, compressTestMember(t, data, dataExt)},
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(arMagic)
+	for _, member := range members {
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", member.name+"/", 0, 0, 0, "100644", len(member.data))
+		buf.Write(member.data)
+		if len(member.data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+
+	debPath := filepath.Join(t.TempDir(), "test_1.0_amd64.deb")
+	if err := os.WriteFile(debPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("write deb: %v", err)
+	}
+	return debPath
+}
+
+func TestReadDebFileList(t *testing.T) {
+	headers := []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./usr/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./usr/bin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "./usr/bin/hello", Typeflag: tar.TypeReg, Mode: 0o755},
+		{Name: "./usr/bin/hi", Typeflag: tar.TypeSymlink, Linkname: "hello", Mode: 0o777},
+		{Name: "./usr/bin/hello-again", Typeflag: tar.TypeLink, Linkname: "./usr/bin/hello", Mode: 0o755},
+	}
+	bodies := map[string]string{"./usr/bin/hello": "#!/bin/sh\necho hello\n"}
+	md5sums := "0123456789abcdef0123456789abcdef  usr/bin/hello\n"
+
+	for _, ext := range []string{".gz", ".xz", ".zst", ""} {
+		t.Run("data.tar"+ext, func(t *testing.T) {
+			entries, err := ReadDebFileList(writeTestDeb(t, ext, headers, bodies, md5sums))
+			if err != nil {
+				t.Fatalf("ReadDebFileList: %v", err)
+			}
+			if len(entries) != 5 {
+				t.Fatalf("expected 5 entries, got %+v", entries)
+			}
+
+			hello := entries[2]
+			if hello.Path != "/usr/bin/hello" || hello.Size != int64(len(bodies["./usr/bin/hello"])) || hello.Mode != 0o755 {
+				t.Fatalf("unexpected regular file entry: %+v", hello)
+			}
+			if hello.MD5 != "0123456789abcdef0123456789abcdef" {
+				t.Fatalf("expected MD5 from md5sums, got %q", hello.MD5)
+			}
+
+			symlink := entries[3]
+			if symlink.Mode&os.ModeSymlink == 0 || symlink.Link != "hello" || symlink.IsHardLink() {
+				t.Fatalf("unexpected symlink entry: %+v", symlink)
+			}
+
+			hardlink := entries[4]
+			if !hardlink.IsHardLink() || hardlink.Link != "/usr/bin/hello" {
+				t.Fatalf("unexpected hard link entry: %+v", hardlink)
+			}
+
+			if !entries[0].Mode.IsDir() || entries[0].Path != "/usr" {
+				t.Fatalf("unexpected directory entry: %+v", entries[0])
+			}
+		})
+	}
+}
+
+func TestReadDebFileListRejectsDeepPaths(t *testing.T) {
+	deep := "./" + strings.Repeat("d/", debMaxPathDepth+1) + "file"
+	headers := []*tar.Header{{Name: deep, Typeflag: tar.TypeReg, Mode: 0o644}}
+
+	_, err := ReadDebFileList(writeTestDeb(t, ".gz", headers, nil, ""))
+	if !errors.Is(err, ErrDebLimitExceeded) {
+		t.Fatalf("expected ErrDebLimitExceeded, got %v", err)
+	}
+}
+
+func TestReadDebFileListRejectsNonDeb(t *testing.T) {
+	notDeb := filepath.Join(t.TempDir(), "not.deb")
+	os.WriteFile(notDeb, []byte("plain text"), 0o644)
+
+	if _, err := ReadDebFileList(notDeb); err == nil {
+		t.Fatal("expected an error for a file that is not an ar archive")
+	}
+}
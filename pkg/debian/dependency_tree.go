@@ -0,0 +1,94 @@
+package debian
+
+import (
+	"slices"
+	"strings"
+)
+
+// FormatDependencyTree renders the dependencies of root found in resolved, keyed by
+// name as returned by ResolveDependencies, as a tree drawn with box-drawing
+// characters:
+//
+//	hello (2.10-2)
+//	└── libc6 (>= 2.14)
+//	    └── libgcc-s1
+//
+// The root line gives the version of root; the other lines give the relationship entry
+// as the package declares it, reduced to the alternative that was picked. Entries of
+// Pre-Depends, Depends, Recommends, Suggests and Enhances are followed, in that order,
+// and each is satisfied by its first alternative present in resolved, by name or
+// through Provides; entries met outside resolved are left out. A package is expanded
+// once: its later occurrences are marked [already listed], which also breaks cycles.
+func FormatDependencyTree(root Package, resolved map[string]Package) string {
+	names := make([]string, 0, len(resolved))
+	for name := range resolved {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	providers := make(map[string]string)
+	for _, name := range names {
+		for _, provided := range resolved[name].Provides {
+			if virtual, _, _ := parseRelation(provided); virtual != "" {
+				if _, ok := providers[virtual]; !ok {
+					providers[virtual] = name
+				}
+			}
+		}
+	}
+
+	// children returns the packages pkg needs, with the alternative that selected each
+	children := func(pkg Package) (targets, labels []string) {
+		for _, entries := range [][]string{pkg.PreDepends, pkg.Depends, pkg.Recommends, pkg.Suggests, pkg.Enhances} {
+			for _, entry := range entries {
+				for _, alternative := range strings.Split(entry, "|") {
+					name, _, _ := parseRelation(alternative)
+					target := name
+					if _, ok := resolved[name]; !ok {
+						target = providers[name]
+					}
+					if target == "" {
+						continue
+					}
+					if target != pkg.Name && !slices.Contains(targets, target) {
+						targets = append(targets, target)
+						labels = append(labels, strings.TrimSpace(alternative))
+					}
+					break
+				}
+			}
+		}
+		return targets, labels
+	}
+
+	var sb strings.Builder
+	sb.WriteString(root.Name)
+	if root.Version != "" {
+		sb.WriteString(" (" + root.Version + ")")
+	}
+	sb.WriteString("\n")
+
+	listed := map[string]bool{root.Name: true}
+	var walk func(pkg Package, prefix string)
+	walk = func(pkg Package, prefix string) {
+		targets, labels := children(pkg)
+		for i, target := range targets {
+			branch, indent := "├── ", "│   "
+			if i == len(targets)-1 {
+				branch, indent = "└── ", "    "
+			}
+
+			sb.WriteString(prefix + branch + labels[i])
+			if listed[target] {
+				sb.WriteString(" [already listed]\n")
+				continue
+			}
+			sb.WriteString("\n")
+			listed[target] = true
+			walk(resolved[target], prefix+indent)
+		}
+	}
+	walk(root, "")
+
+	return sb.String()
+}
@@ -0,0 +1,35 @@
+package debian
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatDependencyTree(t *testing.T) {
+	resolved := map[string]Package{
+		"hello":       {Name: "hello", Version: "2.10-2", Depends: []string{"libc6 (>= 2.14)", "mawk | gawk"}},
+		"libc6":       {Name: "libc6", Depends: []string{"libgcc-s1"}},
+		"libgcc-s1":   {Name: "libgcc-s1", Depends: []string{"gcc-12-base (= 12.2.0-14)", "libc6 (>= 2.35)"}},
+		"gcc-12-base": {Name: "gcc-12-base"},
+		"gawk":        {Name: "gawk", Provides: []string{"awk"}, Depends: []string{"libc6", "missing-lib"}},
+	}
+
+	got := FormatDependencyTree(resolved["hello"], resolved)
+	want := []string{
+		"hello (2.10-2)",
+		"├── libc6 (>= 2.14)",
+		"│   └── libgcc-s1",
+		"│       ├── gcc-12-base (= 12.2.0-14)",
+		"│       └── libc6 (>= 2.35) [already listed]",
+		"└── gawk",
+		"    └── libc6 [already listed]",
+	}
+	if got != strings.Join(want, "\n")+"\n" {
+		t.Fatalf("got tree\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+
+	resolved["hello"] = Package{Name: "hello", Version: "2.10-2", PreDepends: []string{"awk"}}
+	if got := FormatDependencyTree(resolved["hello"], resolved); !strings.HasPrefix(got, "hello (2.10-2)\n└── awk\n    └── libc6\n") {
+		t.Fatalf("expected awk to be satisfied through gawk, got\n%s", got)
+	}
+}
@@ -0,0 +1,64 @@
+package debian
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// PlanEntry describes one file a dry run would download.
+type PlanEntry struct {
+	URL      string `json:"url"`
+	DestPath string `json:"dest_path"`
+	Size     int64  `json:"size"`
+	Skip     bool   `json:"skip"`            // The destination already matches the expected checksum
+	Suite    string `json:"suite,omitempty"` // Suite the package is taken from, for custom repositories
+}
+
+// DownloadPlan lists the pool files a mirror or custom repository build would
+// download, with aggregate totals. It is produced instead of downloading when
+// DryRun is enabled.
+type DownloadPlan struct {
+	Entries       []PlanEntry `json:"entries"`
+	DownloadFiles int         `json:"download_files"`
+	DownloadBytes int64       `json:"download_bytes"`
+	SkippedFiles  int         `json:"skipped_files"`
+	SkippedBytes  int64       `json:"skipped_bytes"`
+	Warnings      []Warning   `json:"warnings,omitempty"` // Non-fatal issues met while building the plan
+}
+
+// Add appends an entry and updates the totals.
+func (p *DownloadPlan) Add(entry PlanEntry) {
+	p.Entries = append(p.Entries, entry)
+	if entry.Skip {
+		p.SkippedFiles++
+		p.SkippedBytes += entry.Size
+		return
+	}
+	p.DownloadFiles++
+	p.DownloadBytes += entry.Size
+}
+
+// WriteText prints one line per entry followed by the totals.
+func (p *DownloadPlan) WriteText(w io.Writer) error {
+	for _, entry := range p.Entries {
+		action := "download"
+		if entry.Skip {
+			action = "skip"
+		}
+		if _, err := fmt.Fprintf(w, "%-8s %s -> %s (%d bytes)\n", action, entry.URL, entry.DestPath, entry.Size); err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "Total: %d files to download (%d bytes), %d files skipped (%d bytes)\n",
+		p.DownloadFiles, p.DownloadBytes, p.SkippedFiles, p.SkippedBytes)
+	return err
+}
+
+// WriteJSON encodes the plan as indented JSON.
+func (p *DownloadPlan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
@@ -0,0 +1,242 @@
+package debian
+
+import "sync"
+
+// DownloadPriority orders work waiting in a DownloadQueue; higher values start first.
+// The predefined classes may be combined with explicit values (e.g. PriorityMetadata+1).
+type DownloadPriority int
+
+// Predefined download priority classes.
+const (
+	PriorityPoolFile  DownloadPriority = iota // Large .deb and source files from the pool
+	PrioritySmallFile                         // Pool files smaller than smallFileThreshold
+	PriorityMetadata                          // Release, InRelease, Packages and Sources indices
+)
+
+// smallFileThreshold is the size below which a package is scheduled as a small file.
+const smallFileThreshold = 1024 * 1024
+
+var (
+	sharedQueueOnce sync.Once
+	sharedQueue     *DownloadQueue
+)
+
+// DownloadResult reports the outcome of a task submitted to a DownloadQueue.
+type DownloadResult struct {
+	Label string
+	Err   error
+}
+
+// DownloadQueue schedules downloads from any number of submitters under a single
+// concurrency limit. Waiting work is started by priority; batches sharing a
+// priority are served round-robin so a large batch cannot starve a small one.
+// Tasks must not submit to and wait on the queue that runs them.
+type DownloadQueue struct {
+	limit    int
+	throttle *throttleGate // Shared by every Downloader using the queue; see Downloader.throttleGate
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	running int
+	waiting int
+	pending map[DownloadPriority]*priorityQueue
+}
+
+// DownloadBatch groups the tasks of one submitter so they can be awaited together.
+type DownloadBatch struct {
+	queue *DownloadQueue
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	results []DownloadResult
+}
+
+// queuedTask is a unit of work waiting in a DownloadQueue.
+type queuedTask struct {
+	label string
+	run   func() error
+	batch *DownloadBatch
+}
+
+// priorityQueue holds the waiting tasks of one priority, grouped by batch.
+type priorityQueue struct {
+	order []*DownloadBatch // Batches with waiting tasks, in round-robin order
+	tasks map[*DownloadBatch][]*queuedTask
+}
+
+// NewDownloadQueue creates a queue running at most limit tasks at once.
+// A limit of zero or less uses the default concurrency (5).
+func NewDownloadQueue(limit int) *DownloadQueue {
+	if limit <= 0 {
+		limit = defaultConcurrency
+	}
+	q := &DownloadQueue{
+		limit:    limit,
+		throttle: newThrottleGate(),
+		pending:  make(map[DownloadPriority]*priorityQueue),
+	}
+	q.idle = sync.NewCond(&q.mu)
+	return q
+}
+
+// SharedDownloadQueue returns the process-wide queue used by Mirror and other
+// callers that do not provide their own.
+func SharedDownloadQueue() *DownloadQueue {
+	sharedQueueOnce.Do(func() {
+		sharedQueue = NewDownloadQueue(defaultConcurrency)
+	})
+	return sharedQueue
+}
+
+// SetSharedDownloadLimit creates the queue returned by SharedDownloadQueue with limit
+// concurrent tasks instead of the default. It has no effect, and returns false, once
+// that queue exists.
+func SetSharedDownloadLimit(limit int) bool {
+	created := false
+	sharedQueueOnce.Do(func() {
+		sharedQueue = NewDownloadQueue(limit)
+		created = true
+	})
+	return created
+}
+
+// Limit returns the maximum number of tasks the queue runs concurrently.
+func (q *DownloadQueue) Limit() int {
+	return q.limit
+}
+
+// NewBatch creates an empty batch for submitting related tasks.
+func (q *DownloadQueue) NewBatch() *DownloadBatch {
+	return &DownloadBatch{queue: q}
+}
+
+// Do runs a single task through the queue and waits for it to finish.
+func (q *DownloadQueue) Do(priority DownloadPriority, label string, run func() error) error {
+	batch := q.NewBatch()
+	batch.Submit(priority, label, run)
+	return batch.Wait()[0].Err
+}
+
+// Wait blocks until no task is running or waiting in the queue.
+func (q *DownloadQueue) Wait() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.running > 0 || q.waiting > 0 {
+		q.idle.Wait()
+	}
+}
+
+// Submit queues run with the given priority. label identifies the task in results.
+func (b *DownloadBatch) Submit(priority DownloadPriority, label string, run func() error) {
+	b.wg.Add(1)
+
+	q := b.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pq, ok := q.pending[priority]
+	if !ok {
+		pq = &priorityQueue{tasks: make(map[*DownloadBatch][]*queuedTask)}
+		q.pending[priority] = pq
+	}
+	if len(pq.tasks[b]) == 0 {
+		pq.order = append(pq.order, b)
+	}
+	pq.tasks[b] = append(pq.tasks[b], &queuedTask{label: label, run: run, batch: b})
+	q.waiting++
+
+	q.dispatchLocked()
+}
+
+// Wait blocks until every task submitted to the batch has finished and returns
+// their results in completion order.
+func (b *DownloadBatch) Wait() []DownloadResult {
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]DownloadResult(nil), b.results...)
+}
+
+// Errors waits for the batch and returns the errors of failed tasks.
+func (b *DownloadBatch) Errors() []error {
+	var errs []error
+	for _, result := range b.Wait() {
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+	return errs
+}
+
+// dispatchLocked starts waiting tasks while the concurrency limit allows.
+func (q *DownloadQueue) dispatchLocked() {
+	for q.running < q.limit {
+		task := q.nextLocked()
+		if task == nil {
+			return
+		}
+		q.waiting--
+		q.running++
+		go q.execute(task)
+	}
+}
+
+// nextLocked removes the next task to run: the highest priority first, and the
+// batch at the head of that priority's round-robin order.
+func (q *DownloadQueue) nextLocked() *queuedTask {
+	var pq *priorityQueue
+	var best DownloadPriority
+	for priority, candidate := range q.pending {
+		if len(candidate.order) > 0 && (pq == nil || priority > best) {
+			pq, best = candidate, priority
+		}
+	}
+	if pq == nil {
+		return nil
+	}
+
+	batch := pq.order[0]
+	pq.order = pq.order[1:]
+
+	tasks := pq.tasks[batch]
+	task := tasks[0]
+	if len(tasks) > 1 {
+		pq.tasks[batch] = tasks[1:]
+		pq.order = append(pq.order, batch)
+	} else {
+		delete(pq.tasks, batch)
+	}
+	if len(pq.order) == 0 {
+		delete(q.pending, best)
+	}
+
+	return task
+}
+
+// execute runs a task, records its result and starts the next waiting task.
+func (q *DownloadQueue) execute(task *queuedTask) {
+	err := task.run()
+
+	task.batch.mu.Lock()
+	task.batch.results = append(task.batch.results, DownloadResult{Label: task.label, Err: err})
+	task.batch.mu.Unlock()
+
+	q.mu.Lock()
+	q.running--
+	q.dispatchLocked()
+	if q.running == 0 && q.waiting == 0 {
+		q.idle.Broadcast()
+	}
+	q.mu.Unlock()
+
+	task.batch.wg.Done()
+}
+
+// packagePriority returns the scheduling class of a package download.
+func packagePriority(pkg *Package) DownloadPriority {
+	if pkg.Size > 0 && pkg.Size < smallFileThreshold {
+		return PrioritySmallFile
+	}
+	return PriorityPoolFile
+}
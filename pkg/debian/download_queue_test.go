@@ -0,0 +1,145 @@
+package debian
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadQueueRespectsGlobalLimit(t *testing.T) {
+	const limit = 3
+	queue := NewDownloadQueue(limit)
+
+	var active, peak int32
+	task := func() error {
+		current := atomic.AddInt32(&active, 1)
+		for {
+			seen := atomic.LoadInt32(&peak)
+			if current <= seen || atomic.CompareAndSwapInt32(&peak, seen, current) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for s := 0; s < 4; s++ {
+		wg.Add(1)
+		go func(submitter int) {
+			defer wg.Done()
+			batch := queue.NewBatch()
+			for i := 0; i < 15; i++ {
+				batch.Submit(DownloadPriority(i%3), fmt.Sprintf("s%d-%d", submitter, i), task)
+			}
+			if results := batch.Wait(); len(results) != 15 {
+				t.Errorf("submitter %d: expected 15 results, got %d", submitter, len(results))
+			}
+		}(s)
+	}
+	wg.Wait()
+	queue.Wait()
+
+	if peak > limit {
+		t.Fatalf("observed %d concurrent downloads, limit is %d", peak, limit)
+	}
+}
+
+func TestDownloadQueueHighPriorityPreemptsQueued(t *testing.T) {
+	queue := NewDownloadQueue(1)
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var order []string
+	record := func(label string) func() error {
+		return func() error {
+			mu.Lock()
+			order = append(order, label)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	blocker := queue.NewBatch()
+	blocker.Submit(PriorityPoolFile, "blocker", func() error {
+		<-release
+		return nil
+	})
+
+	pool := queue.NewBatch()
+	for i := 0; i < 5; i++ {
+		pool.Submit(PriorityPoolFile, fmt.Sprintf("pool-%d", i), record(fmt.Sprintf("pool-%d", i)))
+	}
+	metadata := queue.NewBatch()
+	metadata.Submit(PriorityMetadata, "Packages.xz", record("Packages.xz"))
+
+	close(release)
+	blocker.Wait()
+	pool.Wait()
+	metadata.Wait()
+
+	if len(order) != 6 || order[0] != "Packages.xz" {
+		t.Fatalf("expected metadata to run before queued pool files, got %v", order)
+	}
+}
+
+func TestDownloadQueueRoundRobinAcrossBatches(t *testing.T) {
+	queue := NewDownloadQueue(1)
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	var order []string
+	record := func(label string) func() error {
+		return func() error {
+			mu.Lock()
+			order = append(order, label)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	blocker := queue.NewBatch()
+	blocker.Submit(PriorityPoolFile, "blocker", func() error {
+		<-release
+		return nil
+	})
+
+	large := queue.NewBatch()
+	for i := 0; i < 10; i++ {
+		large.Submit(PriorityPoolFile, "large", record("large"))
+	}
+	small := queue.NewBatch()
+	small.Submit(PriorityPoolFile, "small", record("small"))
+	small.Submit(PriorityPoolFile, "small", record("small"))
+
+	close(release)
+	small.Wait()
+	large.Wait()
+
+	for i, label := range order[:4] {
+		want := []string{"large", "small"}[i%2]
+		if label != want {
+			t.Fatalf("expected alternating batches, got %v", order)
+		}
+	}
+}
+
+func TestDownloadQueueDoReturnsTaskError(t *testing.T) {
+	queue := NewDownloadQueue(2)
+	errBoom := errors.New("boom")
+
+	if err := queue.Do(PriorityMetadata, "Release", func() error { return errBoom }); !errors.Is(err, errBoom) {
+		t.Fatalf("expected task error, got %v", err)
+	}
+
+	batch := queue.NewBatch()
+	batch.Submit(PriorityPoolFile, "ok", func() error { return nil })
+	batch.Submit(PriorityPoolFile, "fail", func() error { return errBoom })
+	if errs := batch.Errors(); len(errs) != 1 || !errors.Is(errs[0], errBoom) {
+		t.Fatalf("expected one error, got %v", errs)
+	}
+}
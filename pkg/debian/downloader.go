@@ -1,17 +1,26 @@
 package debian
 
 import (
+	"context"
 	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -19,8 +28,11 @@ import (
 const (
 	defaultUserAgent     = "deb-for-all/1.0"
 	defaultTimeout       = 30 * time.Second
+	defaultIdleTimeout   = 60 * time.Second
+	dialKeepAlive        = 30 * time.Second
 	defaultRetryAttempts = 3
 	defaultConcurrency   = 5
+	defaultMaxConnsHost  = 4
 	retryDelay           = 2 * time.Second
 	downloadBufferSize   = 32 * 1024 // 32KB buffer
 )
@@ -28,21 +40,159 @@ const (
 // Downloader handles HTTP downloads with retry logic, progress tracking,
 // and checksum verification for Debian packages.
 type Downloader struct {
-	UserAgent       string
-	Timeout         time.Duration
-	RetryAttempts   int
-	VerifyChecksums bool
-	RateDelay       time.Duration // Delay between requests; forces sequential downloads when > 0
+	UserAgent             string
+	Timeout               time.Duration // Bounds connecting, the TLS handshake and the wait for response headers, not the body
+	IdleTimeout           time.Duration // Aborts a response body when no data arrives for this long; 0 disables it
+	RetryAttempts         int
+	MaxConnectionsPerHost int // Simultaneous connections to a host, shared by all requests; 0 means unlimited
+	VerifyChecksums       bool
+	RateDelay             time.Duration     // Delay between requests; forces sequential downloads when > 0
+	KeyringPaths          []string          // Keyrings used to verify signed .changes files; verification is skipped when empty
+	TempDir               string            // Directory for .partial staging and GPG temp files; defaults to the destination directory
+	Queue                 *DownloadQueue    // Shared scheduler for DownloadMultiple; a private pool is used when nil
+	AlwaysVerifyChecksums bool              // Never let ShouldSkipDownload skip a file on size alone
+	Cache                 *ObjectCache      // Shared pool file cache consulted by DownloadMultiple for packages with a SHA256
+	BearerToken           string            // Sent as "Authorization: Bearer <token>" when set, e.g. for private repositories
+	BearerTokenHost       string            // Restricts BearerToken to requests to this host[:port]; sent to every host when empty
+	WarningHandler        func(string)      // Receives non-fatal warnings such as sustained throttling; printed when nil
+	Warnings              *WarningCollector // Records non-fatal warnings for GetWarnings; nil discards them
+	PreserveTimestamps    bool              // Set the mtime of downloaded files to their Last-Modified header
+	QuickCheck            bool              // Let ShouldSkipDownload trust size and mtime of files it already verified
+
+	throttle    *throttleGate     // Pauses all requests of d after a 429 when no Queue is set
+	transport   http.RoundTripper // Set by SetTLSConfig; http.DefaultTransport when nil
+	retryPolicy RetryPolicy       // Set by SetRetryPolicy; overrides RetryAttempts and retryDelay when non-nil
+	verified    *sync.Map         // destPath -> fileStamp of the files whose checksum d verified
+}
+
+// fileStamp is the size and modification time of a file when its checksum was
+// verified, which QuickCheck compares instead of hashing the file again.
+type fileStamp struct {
+	size     int64
+	modTime  time.Time
+	checksum string
+}
+
+// DownloaderOption configures a Downloader built by NewDownloaderWithOptions.
+type DownloaderOption func(*Downloader)
+
+// WithTimeout sets how long each request may take to connect and receive its
+// response headers. Reading the body is bounded by the idle timeout instead.
+func WithTimeout(timeout time.Duration) DownloaderOption {
+	return func(d *Downloader) { d.Timeout = timeout }
+}
+
+// WithIdleTimeout sets how long a response body may stall before the download is
+// aborted with ErrStalled; 0 disables the check.
+func WithIdleTimeout(timeout time.Duration) DownloaderOption {
+	return func(d *Downloader) { d.IdleTimeout = timeout }
+}
+
+// WithRetryAttempts sets how many times a request is tried before giving up.
+func WithRetryAttempts(n int) DownloaderOption {
+	return func(d *Downloader) { d.RetryAttempts = n }
+}
+
+// WithRetryPolicy lets policy decide which failed requests are retried and when,
+// instead of RetryAttempts and the fixed retry delay.
+func WithRetryPolicy(policy RetryPolicy) DownloaderOption {
+	return func(d *Downloader) { d.retryPolicy = policy }
+}
+
+// WithMaxConnectionsPerHost sets how many connections d may open to a host at once;
+// 0 removes the limit.
+func WithMaxConnectionsPerHost(n int) DownloaderOption {
+	return func(d *Downloader) { d.MaxConnectionsPerHost = n }
+}
+
+// WithUserAgent sets the User-Agent header sent with each request.
+func WithUserAgent(userAgent string) DownloaderOption {
+	return func(d *Downloader) { d.UserAgent = userAgent }
+}
+
+// WithVerifyChecksums enables or disables checksum verification after downloads.
+func WithVerifyChecksums(verify bool) DownloaderOption {
+	return func(d *Downloader) { d.VerifyChecksums = verify }
+}
+
+// WithRateDelay sets the delay between requests; a positive delay forces sequential downloads.
+func WithRateDelay(delay time.Duration) DownloaderOption {
+	return func(d *Downloader) { d.RateDelay = delay }
+}
+
+// WithBearerToken authenticates every request with the given bearer token.
+func WithBearerToken(token string) DownloaderOption {
+	return func(d *Downloader) { d.BearerToken, d.BearerTokenHost = token, "" }
+}
+
+// scopedToken is a bearer token with the host it may be sent to.
+type scopedToken struct {
+	token string
+	host  string
+}
+
+// defaultBearerToken holds the token given to new Downloaders; see SetDefaultBearerToken.
+var defaultBearerToken atomic.Pointer[scopedToken]
+
+// SetDefaultBearerToken makes every Downloader created afterwards authenticate its
+// requests to the host of baseURL with token, including those created internally to
+// fetch repository metadata. Requests to other hosts, such as changelogs fetched from
+// metadata.ftp-master.debian.org, are sent without it. An empty token restores
+// unauthenticated requests; WithBearerToken still overrides it.
+func SetDefaultBearerToken(token, baseURL string) error {
+	if token == "" {
+		defaultBearerToken.Store(nil)
+		return nil
+	}
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("bearer token requires a repository URL with a host, got %q", baseURL)
+	}
+	defaultBearerToken.Store(&scopedToken{token: token, host: parsed.Host})
+	return nil
 }
 
 // NewDownloader creates a new Downloader with default settings.
 func NewDownloader() *Downloader {
-	return &Downloader{
-		UserAgent:       defaultUserAgent,
-		Timeout:         defaultTimeout,
-		RetryAttempts:   defaultRetryAttempts,
-		VerifyChecksums: true,
+	return NewDownloaderWithOptions()
+}
+
+// NewDownloaderWithOptions creates a Downloader with default settings, then applies opts
+// in order, e.g. NewDownloaderWithOptions(WithTimeout(5*time.Second), WithRetryAttempts(1)).
+func NewDownloaderWithOptions(opts ...DownloaderOption) *Downloader {
+	d := &Downloader{
+		UserAgent:             defaultUserAgent,
+		Timeout:               defaultTimeout,
+		IdleTimeout:           defaultIdleTimeout,
+		RetryAttempts:         defaultRetryAttempts,
+		VerifyChecksums:       true,
+		MaxConnectionsPerHost: defaultMaxConnsHost,
+		Warnings:              NewWarningCollector(),
+		throttle:              newThrottleGate(),
+		verified:              &sync.Map{},
 	}
+	if scoped := defaultBearerToken.Load(); scoped != nil {
+		d.BearerToken, d.BearerTokenHost = scoped.token, scoped.host
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// SetRetryPolicy lets policy decide which failed requests are retried and how long
+// to wait in between, overriding RetryAttempts and the fixed retry delay. A nil policy
+// restores the default behavior, DefaultRetryPolicy{Attempts: d.RetryAttempts}.
+func (d *Downloader) SetRetryPolicy(policy RetryPolicy) {
+	d.retryPolicy = policy
+}
+
+// activeRetryPolicy returns the policy set with SetRetryPolicy, or the default one.
+func (d *Downloader) activeRetryPolicy() RetryPolicy {
+	if d.retryPolicy != nil {
+		return d.retryPolicy
+	}
+	return DefaultRetryPolicy{Attempts: d.RetryAttempts}
 }
 
 // DownloadURL downloads a file from a URL to a destination path.
@@ -50,48 +200,314 @@ func (d *Downloader) DownloadURL(url, destPath string) error {
 	return d.downloadToFile(url, destPath, nil)
 }
 
-// newHTTPClient creates a new HTTP client with the configured timeout.
+// newHTTPClient creates a new HTTP client with the configured timeout and TLS settings.
+// The client sets no overall deadline, so large files download as long as data keeps
+// flowing: Timeout is applied by the transport (see timeoutTransport) and IdleTimeout
+// by the response body (see idleTimeoutBody).
 func (d *Downloader) newHTTPClient() *http.Client {
-	return &http.Client{Timeout: d.Timeout}
+	return &http.Client{Transport: d.timeoutTransport()}
+}
+
+// SetConnectionLimit limits the connections d opens to a host at once to n, whatever
+// the concurrency of DownloadMultiple: requests beyond it wait for a connection to be
+// free. n <= 0 removes the limit.
+func (d *Downloader) SetConnectionLimit(n int) {
+	d.MaxConnectionsPerHost = max(n, 0)
+}
+
+// timeoutTransports caches the copies of the transports in use that apply a timeout or
+// connection limit, so that requests keep sharing the connections of their transport.
+var timeoutTransports = struct {
+	sync.Mutex
+	byKey map[timeoutTransportKey]*http.Transport
+}{byKey: make(map[timeoutTransportKey]*http.Transport)}
+
+type timeoutTransportKey struct {
+	base     *http.Transport
+	timeout  time.Duration
+	maxConns int
+}
+
+// timeoutTransport returns the transport of d (http.DefaultTransport when unset) with
+// Timeout as dial, TLS handshake and response header timeout, and opening at most
+// MaxConnectionsPerHost connections per host. Transports other than *http.Transport,
+// e.g. test doubles, are used as they are.
+func (d *Downloader) timeoutTransport() http.RoundTripper {
+	base := d.transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	transport, ok := base.(*http.Transport)
+	if !ok || (d.Timeout <= 0 && d.MaxConnectionsPerHost <= 0) {
+		return base
+	}
+
+	key := timeoutTransportKey{base: transport, timeout: max(d.Timeout, 0), maxConns: max(d.MaxConnectionsPerHost, 0)}
+	timeoutTransports.Lock()
+	defer timeoutTransports.Unlock()
+	if cached, ok := timeoutTransports.byKey[key]; ok {
+		return cached
+	}
+	derived := transport.Clone()
+	if key.timeout > 0 {
+		derived.DialContext = (&net.Dialer{Timeout: d.Timeout, KeepAlive: dialKeepAlive}).DialContext
+		derived.TLSHandshakeTimeout = d.Timeout
+		derived.ResponseHeaderTimeout = d.Timeout
+	}
+	if key.maxConns > 0 {
+		derived.MaxConnsPerHost = key.maxConns
+	}
+	timeoutTransports.byKey[key] = derived
+	return derived
+}
+
+// ErrTruncatedDownload is returned when a response body ends before the length its
+// Content-Length announced; the partial data is discarded.
+var ErrTruncatedDownload = errors.New("truncated download")
+
+// ErrStalled is returned when a response body sends no data for the idle timeout of
+// the Downloader.
+var ErrStalled = errors.New("download stalled")
+
+// idleTimeoutBody aborts the request of a response body, through cancel, when no
+// data arrives for timeout; reads then fail with ErrStalled.
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+	timer   *time.Timer
+	mu      sync.Mutex
+	stalled bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{body: body, timeout: timeout, cancel: cancel}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		b.stalled = true
+		b.mu.Unlock()
+		cancel()
+	})
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.mu.Lock()
+	stalled := b.stalled
+	b.mu.Unlock()
+	if stalled {
+		return n, fmt.Errorf("%w: no data received for %v", ErrStalled, b.timeout)
+	}
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// setRequestHeaders adds the User-Agent and, when configured for the host of req, the
+// bearer token to req.
+func (d *Downloader) setRequestHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", d.UserAgent)
+	if d.BearerToken != "" && (d.BearerTokenHost == "" || strings.EqualFold(req.URL.Host, d.BearerTokenHost)) {
+		req.Header.Set("Authorization", "Bearer "+d.BearerToken)
+	}
 }
 
 // doRequestWithRetry performs an HTTP request with retry logic.
 // Returns the response and any error encountered.
 func (d *Downloader) doRequestWithRetry(method, url string, silent bool) (*http.Response, error) {
+	return d.doRequestWithRetryContext(context.Background(), method, url, silent)
+}
+
+// doRequestWithRetryContext is doRequestWithRetry bound to ctx: cancelling it aborts
+// the request in flight and the wait between attempts.
+//
+// Failed attempts are retried as decided by the retry policy (see SetRetryPolicy).
+// A 429 Too Many Requests answer does not count as an attempt: it pauses every request
+// sharing the throttle gate (see throttleGate) for the server's Retry-After delay, and
+// the request fails with ErrThrottled only after throttleMaxRetries such answers.
+func (d *Downloader) doRequestWithRetryContext(ctx context.Context, method, url string, silent bool) (*http.Response, error) {
+	if d.IdleTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		resp, err := d.doRequest(ctx, method, url, silent)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		resp.Body = newIdleTimeoutBody(resp.Body, d.IdleTimeout, cancel)
+		return resp, nil
+	}
+	return d.doRequest(ctx, method, url, silent)
+}
+
+// doRequest implements doRequestWithRetryContext without the idle timeout.
+func (d *Downloader) doRequest(ctx context.Context, method, url string, silent bool) (*http.Response, error) {
 	client := d.newHTTPClient()
-	var lastErr error
+	gate := d.throttleGate()
+	policy := d.activeRetryPolicy()
+	throttled := 0
+
+	for attempt := 1; ; {
+		if err := gate.wait(ctx); err != nil {
+			return nil, err
+		}
 
-	for attempt := 1; attempt <= d.RetryAttempts; attempt++ {
-		req, err := http.NewRequest(method, url, nil)
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
 		if err != nil {
 			return nil, fmt.Errorf("error creating request: %w", err)
 		}
-		req.Header.Set("User-Agent", d.UserAgent)
+		d.setRequestHeaders(req)
 
 		resp, err := client.Do(req)
 		if err == nil && resp.StatusCode == http.StatusOK {
 			return resp, nil
 		}
 
+		if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			throttled++
+			if throttled > throttleMaxRetries {
+				return nil, fmt.Errorf("%w: %s still throttled after %d attempts", ErrThrottled, url, throttled)
+			}
+
+			delay := throttleDelay(resp, throttled)
+			if throttled == throttleWarnThreshold {
+				d.warn(silent, WarningThrottled, url, fmt.Sprintf("Warning: %s throttled %d times in a row (HTTP 429); pausing downloads for %v", url, throttled, delay))
+			}
+			gate.backoff(delay)
+			continue
+		}
+
+		var lastErr error
 		if err != nil {
 			lastErr = err
 		} else {
-			lastErr = fmt.Errorf("statut HTTP %d", resp.StatusCode)
+			lastErr = &HTTPStatusError{StatusCode: resp.StatusCode}
 		}
 
 		if resp != nil {
 			resp.Body.Close()
 		}
 
-		if attempt < d.RetryAttempts {
-			if !silent {
-				fmt.Printf("Tentative %d échouée, nouvelle tentative dans %v...\n", attempt, retryDelay)
-			}
-			time.Sleep(retryDelay)
+		if !policy.ShouldRetry(attempt, lastErr, resp) {
+			return nil, fmt.Errorf("download failed after %d attempts: %w", attempt, lastErr)
+		}
+
+		delay := policy.Delay(attempt)
+		if !silent {
+			fmt.Printf("Tentative %d échouée, nouvelle tentative dans %v...\n", attempt, delay)
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+		attempt++
+	}
+}
+
+// ErrNotFound matches, through errors.Is, failures caused by a 404 Not Found or 410
+// Gone answer, as opposed to network errors and other statuses.
+var ErrNotFound = errors.New("not found on the server")
+
+// HTTPStatusError is returned when a server answers with an unexpected HTTP status.
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("statut HTTP %d", e.StatusCode)
+}
+
+// Is reports 404 and 410 answers as ErrNotFound.
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == ErrNotFound && (e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone)
+}
+
+// significantError returns the error to report after another failed attempt at a file
+// available under several URLs: err, unless prev is already a failure other than
+// ErrNotFound. The result only matches ErrNotFound when every attempt got one.
+func significantError(prev, err error) error {
+	if prev == nil || errors.Is(prev, ErrNotFound) {
+		return err
+	}
+	return prev
+}
+
+// IndexAttempt is a failed attempt at one compression variant of an index.
+type IndexAttempt struct {
+	URL string
+	Err error
+}
+
+// IndexFetchError is returned when no compression variant of an index could be
+// fetched. Its message lists every attempted URL with the reason it failed.
+type IndexFetchError struct {
+	Attempts []IndexAttempt
+}
+
+func (e *IndexFetchError) Error() string {
+	reasons := make([]string, len(e.Attempts))
+	for i, attempt := range e.Attempts {
+		reasons[i] = fmt.Sprintf("%s: %v", attempt.URL, attempt.Err)
+	}
+	return "no variant could be fetched: " + strings.Join(reasons, "; ")
+}
+
+// Unwrap returns the failures other than ErrNotFound, or all of them when every
+// variant is missing, so the error only matches ErrNotFound when the index is
+// missing upstream.
+func (e *IndexFetchError) Unwrap() []error {
+	var significant, missing []error
+	for _, attempt := range e.Attempts {
+		if errors.Is(attempt.Err, ErrNotFound) {
+			missing = append(missing, attempt.Err)
+		} else {
+			significant = append(significant, attempt.Err)
 		}
 	}
+	if len(significant) > 0 {
+		return significant
+	}
+	return missing
+}
+
+// add records the failed attempt at url.
+func (e *IndexFetchError) add(url string, err error) {
+	e.Attempts = append(e.Attempts, IndexAttempt{URL: url, Err: err})
+}
 
-	return nil, fmt.Errorf("download failed after %d attempts: %w", d.RetryAttempts, lastErr)
+// throttleGate returns the gate shared by the workers of d: the queue's when downloads
+// are scheduled through one, so throttling pauses every submitter, otherwise d's own.
+// Downloaders built without a constructor get a gate private to the call.
+func (d *Downloader) throttleGate() *throttleGate {
+	if d.Queue != nil && d.Queue.throttle != nil {
+		return d.Queue.throttle
+	}
+	if d.throttle != nil {
+		return d.throttle
+	}
+	return newThrottleGate()
+}
+
+// warn records a warning and reports its message through WarningHandler, or prints
+// it unless silent.
+func (d *Downloader) warn(silent bool, kind WarningKind, subject, msg string) {
+	d.Warnings.Add(Warning{Kind: kind, Subject: subject, Message: msg})
+	if d.WarningHandler != nil {
+		d.WarningHandler(msg)
+		return
+	}
+	if !silent {
+		fmt.Println(msg)
+	}
 }
 
 // getPackageFilename returns the filename for a package, generating one if not set.
@@ -103,36 +519,139 @@ func getPackageFilename(pkg *Package) string {
 }
 
 // downloadToFile performs the actual download to a file with optional progress callback.
+// Data is staged in a .partial file and renamed into place only once the transfer
+// completes, so destPath never holds a truncated download. A truncated body is retried
+// as decided by the retry policy, like a failed request.
 func (d *Downloader) downloadToFile(url, destPath string, progressCallback func(downloaded, total int64)) error {
 	if err := os.MkdirAll(filepath.Dir(destPath), DirPermission); err != nil {
 		return fmt.Errorf("unable to create parent directory: %w", err)
 	}
 
+	policy := d.activeRetryPolicy()
+	silent := progressCallback == nil
+	for attempt := 1; ; attempt++ {
+		err := d.downloadAttempt(url, destPath, progressCallback)
+		if !errors.Is(err, ErrTruncatedDownload) || !policy.ShouldRetry(attempt, err, nil) {
+			return err
+		}
+
+		delay := policy.Delay(attempt)
+		if !silent {
+			fmt.Printf("Tentative %d échouée, nouvelle tentative dans %v...\n", attempt, delay)
+		}
+		time.Sleep(delay)
+	}
+}
+
+// downloadAttempt downloads url to destPath once, through the staging file, and fails
+// with ErrTruncatedDownload when fewer bytes than announced by Content-Length arrive.
+func (d *Downloader) downloadAttempt(url, destPath string, progressCallback func(downloaded, total int64)) error {
 	resp, err := d.doRequestWithRetry(http.MethodGet, url, progressCallback == nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	destFile, err := os.Create(destPath)
+	stagingDir := d.TempDir
+	if stagingDir == "" {
+		stagingDir = filepath.Dir(destPath)
+	}
+
+	partialFile, err := os.CreateTemp(stagingDir, filepath.Base(destPath)+".*.partial")
 	if err != nil {
-		return fmt.Errorf("unable to create destination file: %w", err)
+		return fmt.Errorf("unable to create staging file: %w", err)
 	}
-	defer destFile.Close()
+	partialPath := partialFile.Name()
+	defer os.Remove(partialPath)
 
-	if progressCallback == nil {
-		_, err = io.Copy(destFile, resp.Body)
-		if err != nil {
-			return fmt.Errorf("error copying file: %w", err)
-		}
+	written, err := d.copyWithProgress(resp.Body, partialFile, resp.ContentLength, progressCallback)
+	if err == nil && resp.ContentLength >= 0 && written != resp.ContentLength {
+		err = fmt.Errorf("%w: %s: received %d of %d bytes", ErrTruncatedDownload, url, written, resp.ContentLength)
+	}
+
+	if closeErr := partialFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("error closing staging file: %w", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(partialPath, FilePermission); err != nil {
+		return fmt.Errorf("unable to set permissions on staging file: %w", err)
+	}
+
+	if err := moveFile(partialPath, destPath); err != nil {
+		return err
+	}
+	if d.PreserveTimestamps {
+		return preserveLastModified(destPath, resp.Header.Get("Last-Modified"))
+	}
+	return nil
+}
+
+// preserveLastModified sets the modification time of path to lastModified, an HTTP
+// date, so that rsync and caching proxies downstream see the upstream time. Files
+// served without a valid Last-Modified header keep the download time.
+func preserveLastModified(path, lastModified string) error {
+	if lastModified == "" {
+		return nil
+	}
+	modTime, err := http.ParseTime(lastModified)
+	if err != nil {
 		return nil
 	}
+	if err := os.Chtimes(path, time.Time{}, modTime); err != nil {
+		return fmt.Errorf("unable to set modification time of %s: %w", path, err)
+	}
+	return nil
+}
+
+// errNotSameDevice is ERROR_NOT_SAME_DEVICE, the error of a rename across volumes on
+// Windows.
+const errNotSameDevice = syscall.Errno(17)
 
-	return d.copyWithProgress(resp.Body, destFile, resp.ContentLength, progressCallback)
+// moveFile renames src to dst, falling back to copy-and-remove when the two paths
+// live on different filesystems and a rename is not possible. Other rename errors
+// are returned: the copy would not be atomic.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	crossDevice := errors.Is(err, syscall.EXDEV) || (runtime.GOOS == "windows" && errors.Is(err, errNotSameDevice))
+	if !crossDevice {
+		return fmt.Errorf("unable to move staging file: %w", err)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("unable to open staging file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePermission)
+	if err != nil {
+		return fmt.Errorf("unable to create destination file: %w", err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("error copying staging file: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("error closing destination file: %w", err)
+	}
+
+	return os.Remove(src)
 }
 
-// copyWithProgress copies data from src to dst while reporting progress.
-func (d *Downloader) copyWithProgress(src io.Reader, dst io.Writer, totalSize int64, callback func(downloaded, total int64)) error {
+// copyWithProgress copies data from src to dst while reporting progress when callback
+// is set, and returns the number of bytes written. A response body stalling beyond the
+// idle timeout fails with ErrStalled, one ending before its Content-Length with
+// ErrTruncatedDownload wrapping io.ErrUnexpectedEOF.
+func (d *Downloader) copyWithProgress(src io.Reader, dst io.Writer, totalSize int64, callback func(downloaded, total int64)) (int64, error) {
 	buffer := make([]byte, downloadBufferSize)
 	var downloaded int64
 
@@ -140,16 +659,21 @@ func (d *Downloader) copyWithProgress(src io.Reader, dst io.Writer, totalSize in
 		n, err := src.Read(buffer)
 		if n > 0 {
 			if _, writeErr := dst.Write(buffer[:n]); writeErr != nil {
-				return fmt.Errorf("error writing: %w", writeErr)
+				return downloaded, fmt.Errorf("error writing: %w", writeErr)
 			}
 			downloaded += int64(n)
-			callback(downloaded, totalSize)
+			if callback != nil {
+				callback(downloaded, totalSize)
+			}
 		}
 		if err == io.EOF {
-			return nil
+			return downloaded, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return downloaded, fmt.Errorf("%w after %d of %d bytes: %w", ErrTruncatedDownload, downloaded, totalSize, err)
 		}
 		if err != nil {
-			return fmt.Errorf("error reading: %w", err)
+			return downloaded, fmt.Errorf("error reading: %w", err)
 		}
 	}
 }
@@ -188,6 +712,22 @@ func (d *Downloader) DownloadWithChecksum(pkg *Package, destPath, checksum, chec
 	return nil
 }
 
+// DownloadWithSHA512 downloads a package without any output and sets its SHA512 from
+// the downloaded file, for Packages indices carrying it. When pkg.SHA512 is already
+// known and VerifyChecksums is set, the file is checked against it instead.
+func (d *Downloader) DownloadWithSHA512(pkg *Package, destPath string) error {
+	if err := d.DownloadSilent(pkg, destPath); err != nil {
+		return err
+	}
+	if pkg.SHA512 != "" {
+		if d.VerifyChecksums {
+			return d.verifyChecksum(destPath, strings.ToLower(pkg.SHA512), "sha512")
+		}
+		return nil
+	}
+	return pkg.ComputeSHA512(destPath)
+}
+
 // verifyChecksum verifies a file's checksum against the expected value.
 func (d *Downloader) verifyChecksum(filePath, expectedChecksum, checksumType string) error {
 	file, err := os.Open(filePath)
@@ -200,8 +740,12 @@ func (d *Downloader) verifyChecksum(filePath, expectedChecksum, checksumType str
 	switch strings.ToLower(checksumType) {
 	case "md5":
 		hasher = md5.New()
+	case "sha1":
+		hasher = sha1.New()
 	case "sha256":
 		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
 	default:
 		return fmt.Errorf("unsupported checksum type: %s", checksumType)
 	}
@@ -220,7 +764,10 @@ func (d *Downloader) verifyChecksum(filePath, expectedChecksum, checksumType str
 }
 
 // ShouldSkipDownload checks if destPath already contains the expected file for the given package.
-// It returns true when the file exists and its checksum matches the package metadata.
+// It returns true when the file exists and its checksum matches the package metadata. When the
+// package has no checksum, a matching Size is accepted unless AlwaysVerifyChecksums is set.
+// With QuickCheck, a file of another Size is rejected without hashing it, and a file d already
+// verified is accepted as long as its size and mtime did not change since.
 func (d *Downloader) ShouldSkipDownload(pkg *Package, destPath string) (bool, error) {
 	info, err := os.Stat(destPath)
 	if errors.Is(err, os.ErrNotExist) {
@@ -241,85 +788,188 @@ func (d *Downloader) ShouldSkipDownload(pkg *Package, destPath string) (bool, er
 	}
 
 	if expectedChecksum == "" {
-		return false, nil
+		skip := !d.AlwaysVerifyChecksums && pkg.Size > 0 && info.Size() == pkg.Size
+		if skip {
+			d.warn(true, WarningMissingChecksum, destPath, fmt.Sprintf("Warning: %s has no checksum, keeping the existing file on size alone", pkg.Name))
+		}
+		return skip, nil
+	}
+
+	if d.QuickCheck {
+		if pkg.Size > 0 && info.Size() != pkg.Size {
+			return false, nil
+		}
+		if d.isVerified(destPath, info, expectedChecksum) {
+			return true, nil
+		}
 	}
 
 	if err := d.verifyChecksum(destPath, expectedChecksum, checksumType); err != nil {
 		return false, nil
 	}
 
+	d.recordVerified(destPath, expectedChecksum)
 	return true, nil
 }
 
-// downloadJob represents a download task for concurrent processing.
-type downloadJob struct {
-	pkg      *Package
-	destPath string
+// ShouldSkipDownloadInStorage is ShouldSkipDownload for the file stored at path in
+// storage, whose checksum is asked to storage when it implements ChecksumStorage.
+func (d *Downloader) ShouldSkipDownloadInStorage(storage Storage, pkg *Package, path string) (bool, error) {
+	info, err := storage.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to stat existing file %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return false, fmt.Errorf("existing path %s is not a regular file", path)
+	}
+
+	expectedChecksum := strings.ToLower(pkg.SHA256)
+	checksumType := "sha256"
+	if expectedChecksum == "" {
+		expectedChecksum = strings.ToLower(pkg.MD5sum)
+		checksumType = "md5"
+	}
+
+	if expectedChecksum == "" {
+		skip := !d.AlwaysVerifyChecksums && pkg.Size > 0 && info.Size() == pkg.Size
+		if skip {
+			d.warn(true, WarningMissingChecksum, path, fmt.Sprintf("Warning: %s has no checksum, keeping the existing file on size alone", pkg.Name))
+		}
+		return skip, nil
+	}
+	if pkg.Size > 0 && info.Size() != pkg.Size {
+		return false, nil
+	}
+
+	actual, err := storageChecksum(storage, path, checksumType)
+	if err != nil {
+		return false, fmt.Errorf("unable to compute the checksum of %s: %w", path, err)
+	}
+	return actual == expectedChecksum, nil
+}
+
+// recordVerified remembers the size and mtime of path, whose content matched checksum.
+func (d *Downloader) recordVerified(path, checksum string) {
+	if d.verified == nil {
+		return
+	}
+	if info, err := os.Stat(path); err == nil {
+		d.verified.Store(path, fileStamp{size: info.Size(), modTime: info.ModTime(), checksum: checksum})
+	}
 }
 
-// downloadResult represents the result of a download task.
-type downloadResult struct {
-	pkg *Package
-	err error
+// isVerified reports whether path, described by info, is unchanged since
+// recordVerified saw it match checksum.
+func (d *Downloader) isVerified(path string, info os.FileInfo, checksum string) bool {
+	if d.verified == nil {
+		return false
+	}
+	stamp, ok := d.verified.Load(path)
+	return ok && stamp == fileStamp{size: info.Size(), modTime: info.ModTime(), checksum: checksum}
 }
 
-// DownloadMultiple downloads multiple packages concurrently.
-// maxConcurrent specifies the number of parallel downloads (defaults to 5).
-// When RateDelay > 0, forces sequential downloads (1 worker) with the specified delay between requests.
+// PackageDownloadError reports a package DownloadMultiple failed to download.
+type PackageDownloadError struct {
+	Package *Package
+	Err     error
+}
+
+func (e *PackageDownloadError) Error() string {
+	return fmt.Sprintf("error for package %s: %v", e.Package.Name, e.Err)
+}
+
+func (e *PackageDownloadError) Unwrap() error {
+	return e.Err
+}
+
+// DownloadMultiple downloads multiple packages concurrently. Each failure is a
+// *PackageDownloadError.
+// Downloads are submitted to Queue when set, so the queue's global limit applies and
+// maxConcurrent is ignored; otherwise a private queue of maxConcurrent workers
+// (defaults to 5) is used. Packages known to be small are scheduled first.
+// When RateDelay > 0, packages are downloaded one at a time with the specified delay between requests.
 func (d *Downloader) DownloadMultiple(packages []*Package, destDir string, maxConcurrent int) []error {
-	if maxConcurrent <= 0 {
-		maxConcurrent = defaultConcurrency
+	return d.downloadBatch(packages, destDir, maxConcurrent, nil)
+}
+
+// downloadBatch implements DownloadMultiple. When onDone is set, it is called from the
+// worker goroutine after each package finishes, with the download error if any.
+func (d *Downloader) downloadBatch(packages []*Package, destDir string, maxConcurrent int, onDone func(pkg *Package, destPath string, err error)) []error {
+	queue := d.Queue
+	if queue == nil {
+		queue = NewDownloadQueue(maxConcurrent)
+	}
+
+	download := func(pkg *Package) func() error {
+		destPath := filepath.Join(destDir, getPackageFilename(pkg))
+		return func() error {
+			err := d.downloadCached(pkg, destPath)
+			if onDone != nil {
+				onDone(pkg, destPath, err)
+			}
+			if err != nil {
+				err = &PackageDownloadError{Package: pkg, Err: err}
+				d.Warnings.Add(Warning{Kind: WarningFileFailed, Subject: destPath, Message: err.Error()})
+				return err
+			}
+			return nil
+		}
 	}
 
-	// Force sequential downloads when rate limiting is enabled
+	// Rate-limited downloads go through the queue one by one to keep the delay between requests
 	if d.RateDelay > 0 {
-		maxConcurrent = 1
-	}
-
-	jobs := make(chan downloadJob, len(packages))
-	results := make(chan downloadResult, len(packages))
-
-	// Start worker goroutines
-	var wg sync.WaitGroup
-	for w := 0; w < maxConcurrent; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			firstJob := true
-			for job := range jobs {
-				// Apply rate limiting delay before each download (except the first)
-				if d.RateDelay > 0 && !firstJob {
-					time.Sleep(d.RateDelay)
-				}
-				firstJob = false
-				err := d.DownloadWithProgress(job.pkg, job.destPath, nil)
-				results <- downloadResult{pkg: job.pkg, err: err}
+		var errs []error
+		for i, pkg := range packages {
+			if i > 0 {
+				time.Sleep(d.RateDelay)
 			}
-		}()
+			if err := queue.Do(packagePriority(pkg), pkg.Name, download(pkg)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errs
 	}
 
-	// Queue download jobs
+	batch := queue.NewBatch()
 	for _, pkg := range packages {
-		destPath := filepath.Join(destDir, getPackageFilename(pkg))
-		jobs <- downloadJob{pkg: pkg, destPath: destPath}
+		batch.Submit(packagePriority(pkg), pkg.Name, download(pkg))
 	}
-	close(jobs)
+	return batch.Errors()
+}
 
-	// Wait for all workers to complete in a separate goroutine
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+// downloadCached downloads pkg to destPath through Cache. Packages with a SHA256 are
+// served from the cache when present; fresh downloads are verified against it
+// before being added. Without a cache or a SHA256, the file is simply downloaded.
+func (d *Downloader) downloadCached(pkg *Package, destPath string) error {
+	if d.Cache == nil || pkg.SHA256 == "" {
+		return d.DownloadWithProgress(pkg, destPath, nil)
+	}
 
-	// Collect results
-	var errors []error
-	for result := range results {
-		if result.err != nil {
-			errors = append(errors, fmt.Errorf("error for package %s: %w", result.pkg.Name, result.err))
-		}
+	hit, err := d.Cache.Get(pkg.SHA256, destPath)
+	if err != nil {
+		return err
+	}
+	if hit {
+		d.recordVerified(destPath, strings.ToLower(pkg.SHA256))
+		return nil
 	}
 
-	return errors
+	if err := d.DownloadWithProgress(pkg, destPath, nil); err != nil {
+		return err
+	}
+	if err := d.verifyChecksum(destPath, strings.ToLower(pkg.SHA256), "sha256"); err != nil {
+		os.Remove(destPath)
+		return err
+	}
+
+	if err := d.Cache.Put(pkg.SHA256, destPath); err != nil {
+		return err
+	}
+	d.recordVerified(destPath, strings.ToLower(pkg.SHA256))
+	return nil
 }
 
 // DownloadSourcePackage downloads all files of a source package.
@@ -342,6 +992,9 @@ func (d *Downloader) DownloadSourceFile(sourceFile *SourceFile, destDir string)
 	if sourceFile.URL == "" {
 		return fmt.Errorf("no URL specified for file %s", sourceFile.Name)
 	}
+	if err := checkFileName(sourceFile.Name); err != nil {
+		return err
+	}
 
 	destPath := filepath.Join(destDir, sourceFile.Name)
 
@@ -379,7 +1032,7 @@ func (d *Downloader) GetFileSize(url string) (int64, error) {
 	if err != nil {
 		return 0, fmt.Errorf("error creating request: %w", err)
 	}
-	req.Header.Set("User-Agent", d.UserAgent)
+	d.setRequestHeaders(req)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -388,7 +1041,7 @@ func (d *Downloader) GetFileSize(url string) (int64, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("statut HTTP %d", resp.StatusCode)
+		return 0, &HTTPStatusError{StatusCode: resp.StatusCode}
 	}
 
 	return resp.ContentLength, nil
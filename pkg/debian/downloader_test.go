@@ -0,0 +1,715 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CeGenreDeChat/deb-for-all/internal/testsupport"
+)
+
+func assertEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unable to read %s: %v", dir, err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected %s to be empty, found %d entries (first: %s)", dir, len(entries), entries[0].Name())
+	}
+}
+
+func TestVerifyWithGPGCleansTempDirOnFailure(t *testing.T) {
+	// Without gpgv, verification fails before any temporary file is written
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv executable not available")
+	}
+	tempDir := t.TempDir()
+
+	_, err := verifyWithGPG([]byte("Origin: test\n"), []byte("not a signature"), false, []string{filepath.Join(tempDir, "missing.gpg")}, tempDir)
+	if err == nil {
+		t.Fatalf("expected verification of a bogus signature to fail")
+	}
+
+	assertEmptyDir(t, tempDir)
+}
+
+func TestDownloadStagesInTempDir(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok.deb" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	destDir := t.TempDir()
+
+	d := NewDownloader()
+	d.TempDir = tempDir
+	d.RetryAttempts = 1
+
+	if err := d.DownloadURL(server.URL+"/ok.deb", filepath.Join(destDir, "ok.deb")); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destDir, "ok.deb")); err != nil || string(data) != "payload" {
+		t.Fatalf("unexpected destination content %q: %v", data, err)
+	}
+
+	if err := d.DownloadURL(server.URL+"/missing.deb", filepath.Join(destDir, "missing.deb")); err == nil {
+		t.Fatalf("expected download of missing file to fail")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "missing.deb")); !os.IsNotExist(err) {
+		t.Fatalf("failed download must not create destination file")
+	}
+
+	assertEmptyDir(t, tempDir)
+}
+
+func TestShouldSkipDownloadBySizeWithoutChecksum(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "local_1.0_amd64.deb")
+	if err := os.WriteFile(destPath, []byte("payload"), FilePermission); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	d := NewDownloader()
+	pkg := &Package{Name: "local", Size: 7}
+
+	if skip, err := d.ShouldSkipDownload(pkg, destPath); err != nil || !skip {
+		t.Fatalf("expected size match to skip download, got skip=%v err=%v", skip, err)
+	}
+
+	pkg.Size = 8
+	if skip, _ := d.ShouldSkipDownload(pkg, destPath); skip {
+		t.Fatal("size mismatch must not skip download")
+	}
+
+	pkg.Size = 7
+	d.AlwaysVerifyChecksums = true
+	if skip, _ := d.ShouldSkipDownload(pkg, destPath); skip {
+		t.Fatal("AlwaysVerifyChecksums must not skip on size alone")
+	}
+}
+
+func TestDownloadPreservesLastModified(t *testing.T) {
+	lastModified := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dated.deb" {
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		}
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	destDir := t.TempDir()
+	d := NewDownloaderWithOptions(WithRetryAttempts(1))
+	modTime := func(name string) time.Time {
+		if err := d.DownloadURL(server.URL+"/"+name, filepath.Join(destDir, name)); err != nil {
+			t.Fatalf("download of %s failed: %v", name, err)
+		}
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.ModTime()
+	}
+
+	if got := modTime("dated.deb"); got.Equal(lastModified) {
+		t.Fatal("Last-Modified must only be applied with PreserveTimestamps")
+	}
+
+	d.PreserveTimestamps = true
+	if got := modTime("dated.deb"); !got.Equal(lastModified) {
+		t.Fatalf("got mtime %v, want %v", got, lastModified)
+	}
+	if got := modTime("undated.deb"); time.Since(got) > time.Minute {
+		t.Fatalf("a file without Last-Modified must keep its download time, got %v", got)
+	}
+}
+
+func TestDownloadWithSHA512RoundTrip(t *testing.T) {
+	content := []byte("hello package content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	pkg := &Package{
+		Name: "hello", Package: "hello", Version: "2.10-3", Architecture: "amd64",
+		Filename:    "pool/main/h/hello/hello_2.10-3_amd64.deb",
+		DownloadURL: server.URL + "/pool/main/h/hello/hello_2.10-3_amd64.deb",
+		Size:        int64(len(content)),
+	}
+	d := NewDownloaderWithOptions(WithRetryAttempts(1))
+	destPath := filepath.Join(t.TempDir(), "hello.deb")
+	if err := d.DownloadWithSHA512(pkg, destPath); err != nil {
+		t.Fatalf("DownloadWithSHA512 failed: %v", err)
+	}
+	want := fmt.Sprintf("%x", sha512.Sum512(content))
+	if pkg.SHA512 != want {
+		t.Fatalf("got SHA512 %q, want %q", pkg.SHA512, want)
+	}
+
+	data := formatPackagesFile([]Package{*pkg})
+	if !strings.Contains(data, "SHA512: "+want+"\n") {
+		t.Fatalf("SHA512 missing from Packages stanza:\n%s", data)
+	}
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	_, parsed, err := repo.parsePackagesDataInternal(t.Context(), []byte(data))
+	if err != nil || len(parsed) != 1 {
+		t.Fatalf("unable to parse the written stanza: %v", err)
+	}
+	if parsed[0].SHA512 != want || len(parsed[0].CustomFields) != 0 {
+		t.Fatalf("SHA512 not parsed back: %+v", parsed[0])
+	}
+	if err := d.verifyChecksum(destPath, parsed[0].SHA512, "sha512"); err != nil {
+		t.Fatalf("downloaded file does not match the parsed SHA512: %v", err)
+	}
+
+	// SHA512 is preferred over SHA256 to verify the Packages file itself
+	release, err := repo.parseReleaseFile(fmt.Sprintf("Suite: bookworm\nSHA256:\n %x %d main/binary-amd64/Packages\nSHA512:\n %x %d main/binary-amd64/Packages\n",
+		sha256.Sum256([]byte(data)), len(data), sha512.Sum512([]byte(data)), len(data)))
+	if err != nil {
+		t.Fatalf("unable to parse Release: %v", err)
+	}
+	repo.ReleaseInfo = release
+	if err := repo.VerifyPackagesFileChecksum("main", "amd64", []byte(data)); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+	release.SHA512[0].Hash = strings.Repeat("0", 128)
+	var mismatch *ChecksumMismatchError
+	if err := repo.VerifyPackagesFileChecksum("main", "amd64", []byte(data)); !errors.As(err, &mismatch) || mismatch.Algorithm != "sha512" {
+		t.Fatalf("expected a SHA512 mismatch, got %v", err)
+	}
+}
+
+func TestShouldSkipDownloadQuickCheck(t *testing.T) {
+	destPath := filepath.Join(t.TempDir(), "local_1.0_amd64.deb")
+	if err := os.WriteFile(destPath, []byte("payload"), FilePermission); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+	checksum, err := sha256File(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := &Package{Name: "local", Size: 7, SHA256: checksum}
+
+	d := NewDownloader()
+	d.QuickCheck = true
+	if skip, err := d.ShouldSkipDownload(pkg, destPath); err != nil || !skip {
+		t.Fatalf("expected a matching file to be kept, got skip=%v err=%v", skip, err)
+	}
+
+	// Same size and mtime: the file verified above is trusted without hashing it
+	info, _ := os.Stat(destPath)
+	if err := os.WriteFile(destPath, []byte("PAYLOAD"), FilePermission); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(destPath, time.Time{}, info.ModTime())
+	if skip, _ := d.ShouldSkipDownload(pkg, destPath); !skip {
+		t.Fatal("QuickCheck must trust an unchanged size and mtime")
+	}
+
+	d.QuickCheck = false
+	if skip, _ := d.ShouldSkipDownload(pkg, destPath); skip {
+		t.Fatal("without QuickCheck the file must be hashed again")
+	}
+
+	d.QuickCheck = true
+	os.Chtimes(destPath, time.Time{}, info.ModTime().Add(time.Hour))
+	if skip, _ := d.ShouldSkipDownload(pkg, destPath); skip {
+		t.Fatal("a changed mtime must lead to hashing the file again")
+	}
+
+	pkg.Size = 8
+	if skip, _ := d.ShouldSkipDownload(pkg, destPath); skip {
+		t.Fatal("a size mismatch must not skip the download")
+	}
+}
+
+func TestNewDownloaderWithOptions(t *testing.T) {
+	d := NewDownloaderWithOptions(
+		WithTimeout(5*time.Second),
+		WithRetryAttempts(1),
+		WithUserAgent("test-agent/2.0"),
+		WithVerifyChecksums(false),
+		WithRateDelay(time.Second),
+		WithBearerToken("s3cret"),
+	)
+
+	if d.Timeout != 5*time.Second || d.RetryAttempts != 1 || d.UserAgent != "test-agent/2.0" ||
+		d.VerifyChecksums || d.RateDelay != time.Second || d.BearerToken != "s3cret" {
+		t.Fatalf("options not applied: %+v", d)
+	}
+
+	defaults := NewDownloader()
+	if defaults.Timeout != defaultTimeout || defaults.RetryAttempts != defaultRetryAttempts || defaults.UserAgent != defaultUserAgent || !defaults.VerifyChecksums || defaults.BearerToken != "" {
+		t.Fatalf("unexpected defaults: %+v", defaults)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test-agent/2.0" || r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "missing headers", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	if err := d.DownloadURL(server.URL+"/private.deb", filepath.Join(t.TempDir(), "private.deb")); err != nil {
+		t.Fatalf("expected the configured headers to be sent: %v", err)
+	}
+	if _, err := d.GetFileSize(server.URL + "/private.deb"); err != nil {
+		t.Fatalf("expected HEAD requests to carry the headers too: %v", err)
+	}
+}
+
+func TestSetDefaultBearerToken(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			seen[name] = r.Header.Get("Authorization")
+			mu.Unlock()
+			w.Write([]byte("payload"))
+		})
+	}
+	archive := httptest.NewServer(handler("archive"))
+	defer archive.Close()
+	other := httptest.NewServer(handler("other"))
+	defer other.Close()
+
+	if err := SetDefaultBearerToken("ci-token", archive.URL+"/debian"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetDefaultBearerToken("", "") })
+
+	d := NewDownloader()
+	if d.BearerToken != "ci-token" {
+		t.Fatalf("expected new downloaders to use the default token, got %q", d.BearerToken)
+	}
+	for _, server := range []*httptest.Server{archive, other} {
+		if err := d.DownloadURL(server.URL+"/pool/hello.deb", filepath.Join(t.TempDir(), "hello.deb")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if seen["archive"] != "Bearer ci-token" {
+		t.Fatalf("expected the token to be sent to the archive, got %q", seen["archive"])
+	}
+	if seen["other"] != "" {
+		t.Fatalf("the token leaked to another host: %q", seen["other"])
+	}
+
+	if d := NewDownloaderWithOptions(WithBearerToken("other")); d.BearerToken != "other" || d.BearerTokenHost != "" {
+		t.Fatalf("expected WithBearerToken to override the default token, got %q for %q", d.BearerToken, d.BearerTokenHost)
+	}
+	if err := SetDefaultBearerToken("ci-token", "no-host"); err == nil {
+		t.Fatal("expected a URL without a host to be rejected")
+	}
+
+	SetDefaultBearerToken("", "")
+	if d := NewDownloader(); d.BearerToken != "" {
+		t.Fatalf("expected an empty default token to disable authentication, got %q", d.BearerToken)
+	}
+}
+
+func TestDownloaderConnectionLimit(t *testing.T) {
+	var open, peak atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("payload"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			n := open.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+		case http.StateClosed, http.StateHijacked:
+			open.Add(-1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	if d := NewDownloader(); d.MaxConnectionsPerHost != defaultMaxConnsHost {
+		t.Fatalf("expected a default limit of %d connections, got %d", defaultMaxConnsHost, d.MaxConnectionsPerHost)
+	}
+
+	d := NewDownloaderWithOptions(WithRetryAttempts(1))
+	d.SetConnectionLimit(2)
+	var packages []*Package
+	for i := range 12 {
+		name := fmt.Sprintf("pkg%d", i)
+		packages = append(packages, &Package{Name: name, Version: "1.0", Architecture: "amd64", DownloadURL: fmt.Sprintf("%s/%s.deb", server.URL, name)})
+	}
+	if errs := d.DownloadMultiple(packages, t.TempDir(), 12); len(errs) != 0 {
+		t.Fatalf("downloads failed: %v", errs)
+	}
+	if got := peak.Load(); got > 2 {
+		t.Fatalf("got %d simultaneous connections, want at most 2", got)
+	}
+}
+
+func TestDownloadMultipleHonorsRetryAfter(t *testing.T) {
+	const workers = 3
+	var mu sync.Mutex
+	var throttledUntil time.Time
+	var throttledResponses, earlyRequests int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		if throttledUntil.IsZero() {
+			throttledUntil = now.Add(time.Second)
+		}
+		if now.Before(throttledUntil) {
+			if throttledResponses >= workers {
+				earlyRequests++ // A worker ignored the pause
+			}
+			throttledResponses++
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	d := NewDownloaderWithOptions(WithRetryAttempts(1))
+	d.Queue = NewDownloadQueue(workers)
+
+	var packages []*Package
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("pkg%d", i)
+		packages = append(packages, &Package{Name: name, Filename: name + ".deb", DownloadURL: server.URL + "/" + name + ".deb"})
+	}
+
+	start := time.Now()
+	destDir := t.TempDir()
+	if errs := d.DownloadMultiple(packages, destDir, 0); len(errs) > 0 {
+		t.Fatalf("expected every download to succeed after the pause, got %v", errs)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("downloads finished in %v, before Retry-After elapsed", elapsed)
+	}
+	if earlyRequests > 0 || throttledResponses > workers {
+		t.Fatalf("workers kept requesting while throttled: %d throttled responses, %d early requests", throttledResponses, earlyRequests)
+	}
+	for _, pkg := range packages {
+		if _, err := os.Stat(filepath.Join(destDir, pkg.Filename)); err != nil {
+			t.Fatalf("missing %s: %v", pkg.Filename, err)
+		}
+	}
+}
+
+func TestSustainedThrottlingWarnsThenFails(t *testing.T) {
+	var mu sync.Mutex
+	limit := throttleWarnThreshold
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests++
+		if requests <= limit {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("payload"))
+	}))
+	defer server.Close()
+
+	var warnings []string
+	d := NewDownloaderWithOptions(WithRetryAttempts(1))
+	d.WarningHandler = func(msg string) { warnings = append(warnings, msg) }
+
+	if err := d.DownloadURL(server.URL+"/a.deb", filepath.Join(t.TempDir(), "a.deb")); err != nil {
+		t.Fatalf("429 responses must not use up RetryAttempts: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected one sustained throttling warning, got %v", warnings)
+	}
+
+	mu.Lock()
+	limit, requests = 1000, 0
+	mu.Unlock()
+	err := d.DownloadURL(server.URL+"/b.deb", filepath.Join(t.TempDir(), "b.deb"))
+	if !errors.Is(err, ErrThrottled) {
+		t.Fatalf("expected ErrThrottled, got %v", err)
+	}
+}
+
+// unavailableRetryPolicy retries 503 answers twice, without waiting, and nothing else.
+type unavailableRetryPolicy struct{}
+
+func (unavailableRetryPolicy) ShouldRetry(attempt int, err error, resp *http.Response) bool {
+	return attempt <= 2 && resp != nil && resp.StatusCode == http.StatusServiceUnavailable
+}
+
+func (unavailableRetryPolicy) Delay(int) time.Duration { return 0 }
+
+func TestDownloaderRetryPolicy(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/missing.deb":
+			http.NotFound(w, r)
+		case r.URL.Path == "/flaky.deb" && n <= 2, r.URL.Path == "/down.deb":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("payload"))
+		}
+	}))
+	defer server.Close()
+
+	d := NewDownloaderWithOptions(WithRetryAttempts(10))
+	d.SetRetryPolicy(unavailableRetryPolicy{})
+	destDir := t.TempDir()
+
+	if err := d.DownloadURL(server.URL+"/flaky.deb", filepath.Join(destDir, "flaky.deb")); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	err := d.DownloadURL(server.URL+"/down.deb", filepath.Join(destDir, "down.deb"))
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a 503 failure after the retries, got %v", err)
+	}
+	if err := d.DownloadURL(server.URL+"/missing.deb", filepath.Join(destDir, "missing.deb")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	want := map[string]int{"/flaky.deb": 3, "/down.deb": 3, "/missing.deb": 1}
+	for path, n := range want {
+		if requests[path] != n {
+			t.Errorf("%s: got %d requests, want %d (RetryAttempts must be ignored)", path, requests[path], n)
+		}
+	}
+}
+
+func TestExponentialRetryPolicy(t *testing.T) {
+	p := ExponentialRetryPolicy{Base: 100 * time.Millisecond, Max: time.Second, MaxAttempts: 4}
+
+	delays := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for i, want := range delays {
+		if got := p.Delay(i + 1); got != want {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, want)
+		}
+	}
+
+	unavailable := &http.Response{StatusCode: http.StatusServiceUnavailable}
+	if !p.ShouldRetry(1, errors.New("connection reset"), nil) || !p.ShouldRetry(3, nil, unavailable) {
+		t.Fatal("network errors and 5xx answers must be retried")
+	}
+	if p.ShouldRetry(4, nil, unavailable) {
+		t.Fatal("MaxAttempts must bound the retries")
+	}
+	if p.ShouldRetry(1, nil, &http.Response{StatusCode: http.StatusNotFound}) {
+		t.Fatal("a 404 must not be retried")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{"Sat, 01 Jun 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Sat, 01 Jun 2024 11:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestDownloaderRetriesThrottledFixture(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+	})
+	filename := fixture.Filename("hello", "amd64")
+	fixture.Throttle(filename, 2)
+
+	pkg := &Package{Name: "hello", Version: "2.10-3", Architecture: "amd64", DownloadURL: fixture.URL + "/" + filename, Filename: filepath.Base(filename)}
+	destDir := t.TempDir()
+	if err := NewDownloader().DownloadToDirSilent(pkg, destDir); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if got := fixture.Requests(filename); got != 3 {
+		t.Fatalf("expected two throttled requests then a success, got %d requests", got)
+	}
+
+	want, err := fixture.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unable to read fixture: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(destDir, pkg.Filename))
+	if err != nil || string(got) != string(want) {
+		t.Fatalf("downloaded file differs from the fixture (err: %v)", err)
+	}
+}
+
+func TestDownloaderTimeouts(t *testing.T) {
+	const chunk = "0123456789"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/steady":
+			// Takes longer than both timeouts overall, but data keeps flowing
+			for i := 0; i < 10; i++ {
+				io.WriteString(w, chunk)
+				w.(http.Flusher).Flush()
+				time.Sleep(50 * time.Millisecond)
+			}
+		case "/stalled":
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case "/no-headers":
+			select {
+			case <-time.After(2 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer server.Close()
+
+	newDownloader := func() *Downloader {
+		return NewDownloaderWithOptions(WithTimeout(200*time.Millisecond), WithIdleTimeout(200*time.Millisecond), WithRetryAttempts(1))
+	}
+
+	t.Run("slow but steady", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "steady")
+		if err := newDownloader().DownloadURL(server.URL+"/steady", destPath); err != nil {
+			t.Fatalf("steady download failed: %v", err)
+		}
+		if data, err := os.ReadFile(destPath); err != nil || len(data) != 10*len(chunk) {
+			t.Fatalf("unexpected download of %d bytes (err: %v)", len(data), err)
+		}
+	})
+
+	t.Run("stalled", func(t *testing.T) {
+		destDir := t.TempDir()
+		start := time.Now()
+		err := newDownloader().DownloadURL(server.URL+"/stalled", filepath.Join(destDir, "stalled"))
+		if !errors.Is(err, ErrStalled) {
+			t.Fatalf("expected ErrStalled, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("stall detected after %v", elapsed)
+		}
+		assertEmptyDir(t, destDir)
+	})
+
+	t.Run("response headers", func(t *testing.T) {
+		start := time.Now()
+		if err := newDownloader().DownloadURL(server.URL+"/no-headers", filepath.Join(t.TempDir(), "late")); err == nil {
+			t.Fatal("expected the response header timeout to fail the download")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("response header timeout applied after %v", elapsed)
+		}
+	})
+}
+
+// shortBodyTransport answers every request with a body of body, announcing
+// contentLength, and ending with a clean EOF.
+type shortBodyTransport struct {
+	body          string
+	contentLength int64
+}
+
+func (s shortBodyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Body:          io.NopCloser(strings.NewReader(s.body)),
+		ContentLength: s.contentLength,
+		Request:       req,
+	}, nil
+}
+
+func TestDownloaderTruncatedDownload(t *testing.T) {
+	const content = "0123456789"
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Announce more than is sent, except for the second request of /flaky
+		if r.URL.Path != "/flaky" || requests.Add(1) != 2 {
+			w.Header().Set("Content-Length", "100")
+		}
+		io.WriteString(w, content)
+	}))
+	defer server.Close()
+
+	newDownloader := func() *Downloader {
+		return NewDownloaderWithOptions(WithRetryPolicy(ExponentialRetryPolicy{Base: time.Millisecond, MaxAttempts: 2}))
+	}
+
+	t.Run("inflated Content-Length", func(t *testing.T) {
+		destDir := t.TempDir()
+		err := newDownloader().DownloadURL(server.URL+"/truncated", filepath.Join(destDir, "file"))
+		if !errors.Is(err, ErrTruncatedDownload) || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expected ErrTruncatedDownload wrapping io.ErrUnexpectedEOF, got %v", err)
+		}
+		assertEmptyDir(t, destDir)
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		destPath := filepath.Join(t.TempDir(), "file")
+		if err := newDownloader().DownloadURL(server.URL+"/flaky", destPath); err != nil {
+			t.Fatalf("expected the truncated download to be retried, got %v", err)
+		}
+		if data, err := os.ReadFile(destPath); err != nil || string(data) != content {
+			t.Fatalf("unexpected content %q (err: %v)", data, err)
+		}
+		if got := requests.Load(); got != 2 {
+			t.Fatalf("expected 2 requests, got %d", got)
+		}
+	})
+
+	t.Run("clean EOF before Content-Length", func(t *testing.T) {
+		destDir := t.TempDir()
+		d := newDownloader()
+		d.transport = shortBodyTransport{body: content, contentLength: 100}
+		err := d.DownloadURL("http://example.invalid/file", filepath.Join(destDir, "file"))
+		if !errors.Is(err, ErrTruncatedDownload) || errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expected a length mismatch, got %v", err)
+		}
+		assertEmptyDir(t, destDir)
+	})
+}
@@ -0,0 +1,216 @@
+package debian
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DEP11Pattern is the extra index pattern MirrorConfig.IncludeDEP11 adds: the
+// AppStream metadata of a component, e.g. main/dep11/Components-amd64.yml.gz and
+// main/dep11/icons-64x64.tar.gz.
+const DEP11Pattern = "dep11/"
+
+// ErrExtraIndexCorrupted is returned when a file mirrored from the Release file list
+// (see MirrorConfig.MirrorAllIndices and IncludeExtraIndices) does not match the size
+// or checksum its Release file lists.
+var ErrExtraIndexCorrupted = errors.New("extra index does not match the Release file")
+
+// extraIndexPatterns returns the patterns of IncludeExtraIndices, with DEP11Pattern
+// when IncludeDEP11 is set.
+func (c *MirrorConfig) extraIndexPatterns() []string {
+	patterns := slices.Clone(c.IncludeExtraIndices)
+	if c.IncludeDEP11 && !slices.Contains(patterns, DEP11Pattern) {
+		patterns = append(patterns, DEP11Pattern)
+	}
+	return patterns
+}
+
+// validateExtraIndexPatterns rejects empty, absolute and malformed patterns.
+func validateExtraIndexPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if pattern == "" || strings.HasPrefix(pattern, "/") || slices.Contains(strings.Split(pattern, "/"), "..") {
+			return fmt.Errorf("invalid index pattern %q", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid index pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchExtraIndex reports whether rel, a path relative to its component, matches
+// pattern: a directory prefix when pattern ends with "/", a path.Match pattern
+// otherwise.
+func matchExtraIndex(pattern, rel string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		return strings.HasPrefix(rel, pattern)
+	}
+	matched, _ := path.Match(pattern, rel)
+	return matched
+}
+
+// matchesAnyIndexPattern reports whether rel, a path relative to dists/<suite>,
+// matches one of patterns: a directory prefix when a pattern ends with "/", a
+// path.Match pattern against the whole path when it contains "/", against the base
+// name otherwise.
+func matchesAnyIndexPattern(patterns []string, rel string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") && !strings.HasSuffix(pattern, "/") {
+			return matchExtraIndex(pattern, path.Base(rel))
+		}
+		return matchExtraIndex(pattern, rel)
+	})
+}
+
+// namesArchitecture reports whether a segment of rel names arch, as in
+// binary-arm64/, installer-arm64/, Contents-arm64.gz or Components-arm64.yml.gz.
+func namesArchitecture(rel, arch string) bool {
+	for _, segment := range strings.Split(rel, "/") {
+		if segment == "binary-"+arch || segment == "installer-"+arch || strings.HasSuffix(segment, "-"+arch) || strings.Contains(segment, "-"+arch+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseIndexFiles returns the entries of suite's loaded Release file to mirror
+// besides the Packages indices: every entry with MirrorAllIndices, filtered by
+// IndexInclude and IndexExclude, and the entries of each component matching the
+// extra index patterns. Entries under a component not in components, and entries
+// naming an architecture of the Release file that is not mirrored, e.g.
+// dep11/Components-arm64.yml.gz for an amd64 mirror, are left out. The entries carry
+// their SHA256 checksum, or MD5Sum for Release files without one, as reported by the
+// returned algorithm.
+func (m *Mirror) releaseIndexFiles(suite string, components []string) ([]FileChecksum, string) {
+	extraPatterns := m.config.extraIndexPatterns()
+	release := m.repository.GetReleaseInfo()
+	if release == nil || (!m.config.MirrorAllIndices && len(extraPatterns) == 0) {
+		return nil, ""
+	}
+
+	entries, algo := release.SHA256, "sha256"
+	if len(entries) == 0 {
+		entries, algo = release.MD5Sum, "md5"
+	}
+
+	var skippedArchitectures []string
+	for _, arch := range release.Architectures {
+		if arch != "all" && !slices.Contains(m.ArchitecturesForSuite(suite), arch) {
+			skippedArchitectures = append(skippedArchitectures, arch)
+		}
+	}
+
+	var files []FileChecksum
+	for _, entry := range entries {
+		component, rel, nested := strings.Cut(entry.Filename, "/")
+		if nested && !slices.Contains(components, component) && slices.Contains(release.Components, component) {
+			continue
+		}
+		if slices.ContainsFunc(skippedArchitectures, func(arch string) bool { return namesArchitecture(entry.Filename, arch) }) {
+			continue
+		}
+
+		all := m.config.MirrorAllIndices &&
+			(len(m.config.IndexInclude) == 0 || matchesAnyIndexPattern(m.config.IndexInclude, entry.Filename)) &&
+			!matchesAnyIndexPattern(m.config.IndexExclude, entry.Filename)
+		extra := nested && slices.ContainsFunc(extraPatterns, func(pattern string) bool { return matchExtraIndex(pattern, rel) })
+		if all || extra {
+			files = append(files, entry)
+		}
+	}
+	return files, algo
+}
+
+// mirrorReleaseIndices downloads the releaseIndexFiles of suite to the same path
+// under dists/<suite>, verifying each against its Release checksum and size. Files
+// already matching it, such as the Packages indices mirrored just before, are kept,
+// so a Sync only downloads those that changed. Release files list the uncompressed
+// variant of some indices that are only served compressed, so files not found
+// upstream are skipped.
+func (m *Mirror) mirrorReleaseIndices(suite string, components []string) error {
+	files, algo := m.releaseIndexFiles(suite, components)
+	for _, entry := range files {
+		localPath := filepath.Join(m.buildSuitePath(suite), filepath.FromSlash(entry.Filename))
+		if checkExtraIndex(localPath, entry, algo) == nil {
+			m.emit(MirrorEvent{Type: MirrorEventFileSkipped, Suite: suite, Message: fmt.Sprintf("%s is up to date", entry.Filename)})
+			continue
+		}
+
+		url := fmt.Sprintf("%s/dists/%s/%s", strings.TrimSuffix(m.config.BaseURL, "/"), suite, entry.Filename)
+		m.emit(MirrorEvent{Type: MirrorEventMetadata, Suite: suite, Message: fmt.Sprintf("Downloading %s", url)})
+
+		err := m.metadataDownloader.Queue.Do(PriorityMetadata, entry.Filename, func() error {
+			return m.metadataDownloader.downloadToFile(url, localPath, nil)
+		})
+		if errors.Is(err, ErrNotFound) {
+			m.emit(MirrorEvent{Type: MirrorEventInfo, Suite: suite, Message: fmt.Sprintf("%s is listed in the Release file but not served, skipping it", entry.Filename)})
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", entry.Filename, err)
+		}
+		if err := checkExtraIndex(localPath, entry, algo); err != nil {
+			os.Remove(localPath)
+			return fmt.Errorf("%s: %w", entry.Filename, err)
+		}
+		m.emitFileDownloaded(suite, "", "", localPath)
+		if err := m.publish(localPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyReleaseIndices checks the mirrored releaseIndexFiles of suite against its
+// Release file. Files that were not mirrored are reported as verify events, since
+// upstream may not serve every variant it lists.
+func (m *Mirror) verifyReleaseIndices(suite string, components []string) error {
+	files, algo := m.releaseIndexFiles(suite, components)
+	for _, entry := range files {
+		localPath := filepath.Join(m.buildSuitePath(suite), filepath.FromSlash(entry.Filename))
+		err := checkExtraIndex(localPath, entry, algo)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			m.emit(MirrorEvent{Type: MirrorEventVerify, Suite: suite, Message: fmt.Sprintf("%s is not mirrored", entry.Filename)})
+		case err != nil:
+			return fmt.Errorf("%s: %w", entry.Filename, err)
+		default:
+			m.emit(MirrorEvent{Type: MirrorEventVerify, Suite: suite, Message: fmt.Sprintf("✓ %s integrity check passed", entry.Filename)})
+		}
+	}
+	return nil
+}
+
+// checkExtraIndex checks the file at localPath against the size and checksum of its
+// Release entry, computed with algo ("sha256" or "md5").
+func checkExtraIndex(localPath string, entry FileChecksum, algo string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != entry.Size {
+		return fmt.Errorf("%w: size %d, expected %d", ErrExtraIndexCorrupted, info.Size(), entry.Size)
+	}
+
+	var h hash.Hash = sha256.New()
+	if algo == "md5" {
+		h = md5.New()
+	}
+	actual, err := hashFile(localPath, h)
+	if err != nil {
+		return err
+	}
+	if actual != strings.ToLower(entry.Hash) {
+		return fmt.Errorf("%w: %s %s, expected %s", ErrExtraIndexCorrupted, algo, actual, entry.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package debian
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// PreDependsCycleError is returned by TopologicalInstallOrder when a cycle of
+// relationships goes through a Pre-Depends: the pre-dependency cannot be configured
+// before the package needing it is unpacked.
+type PreDependsCycleError struct {
+	Packages []string // Members of the cycle, sorted
+}
+
+func (e *PreDependsCycleError) Error() string {
+	return fmt.Sprintf("Pre-Depends cycle between %s", strings.Join(e.Packages, ", "))
+}
+
+// TopologicalInstallOrder returns the packages of pkgs, keyed by name as returned by
+// ResolveDependencies, in batches to install one after the other, each with a single
+// dpkg -i. A package comes in a later batch than the packages it Pre-Depends on, which
+// must be configured before it is unpacked, and in the same batch as or a later one
+// than those it Depends on, since dpkg -i orders the configuration within a batch.
+// Depends cycles, such as libc6 and libgcc-s1, thus end up in a single batch. Each
+// relationship entry is satisfied by its first alternative present in pkgs, by name
+// or through Provides; entries met outside pkgs are ignored. Names within a batch are
+// sorted. A *PreDependsCycleError is returned when a cycle goes through a Pre-Depends,
+// whether the rest of it is made of Pre-Depends or Depends.
+func TopologicalInstallOrder(pkgs map[string]Package) ([][]string, error) {
+	names := make([]string, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	providers := make(map[string]string)
+	for _, name := range names {
+		for _, provided := range pkgs[name].Provides {
+			if virtual, _, _ := parseRelation(provided); virtual != "" {
+				if _, ok := providers[virtual]; !ok {
+					providers[virtual] = name
+				}
+			}
+		}
+	}
+	target := func(entry string) string {
+		for _, alternative := range strings.Split(entry, "|") {
+			name, _, _ := parseRelation(alternative)
+			if _, ok := pkgs[name]; ok {
+				return name
+			}
+			if provider, ok := providers[name]; ok {
+				return provider
+			}
+		}
+		return ""
+	}
+
+	graph := installGraph{preDepends: make(map[string][]string), depends: make(map[string][]string)}
+	for _, name := range names {
+		pkg := pkgs[name]
+		for _, entry := range pkg.PreDepends {
+			if dep := target(entry); dep != "" && dep != name {
+				graph.preDepends[name] = append(graph.preDepends[name], dep)
+			}
+		}
+		for _, entry := range pkg.Depends {
+			if dep := target(entry); dep != "" && dep != name {
+				graph.depends[name] = append(graph.depends[name], dep)
+			}
+		}
+	}
+
+	// A Pre-Depends cycle cannot be installed in any order
+	for _, component := range stronglyConnected(names, func(name string) []string { return graph.preDepends[name] }) {
+		if len(component) > 1 {
+			slices.Sort(component)
+			return nil, &PreDependsCycleError{Packages: component}
+		}
+	}
+
+	// Components come out dependencies first, so the batch of each dependency is known
+	// when its dependents are placed
+	components := stronglyConnected(names, graph.edges)
+	componentOf := make(map[string]int, len(names))
+	batchOf := make([]int, len(components))
+	var batches [][]string
+	for i, component := range components {
+		for _, name := range component {
+			componentOf[name] = i
+		}
+		for _, name := range component {
+			for _, dep := range graph.preDepends[name] {
+				c := componentOf[dep]
+				if c == i {
+					// The Pre-Depends closes a cycle through Depends: dep cannot be
+					// configured before name is
+					members := slices.Clone(component)
+					slices.Sort(members)
+					return nil, &PreDependsCycleError{Packages: members}
+				}
+				batchOf[i] = max(batchOf[i], batchOf[c]+1)
+			}
+			for _, dep := range graph.depends[name] {
+				if c := componentOf[dep]; c != i {
+					batchOf[i] = max(batchOf[i], batchOf[c])
+				}
+			}
+		}
+
+		for len(batches) <= batchOf[i] {
+			batches = append(batches, nil)
+		}
+		batches[batchOf[i]] = append(batches[batchOf[i]], component...)
+	}
+
+	for _, batch := range batches {
+		slices.Sort(batch)
+	}
+	return batches, nil
+}
+
+// installGraph holds the relationships between the packages of an installation, from
+// each package to the packages it needs.
+type installGraph struct {
+	preDepends map[string][]string
+	depends    map[string][]string
+}
+
+func (g installGraph) edges(name string) []string {
+	return slices.Concat(g.preDepends[name], g.depends[name])
+}
+
+// stronglyConnected returns the strongly connected components of the graph over
+// nodes, using Tarjan's algorithm. A component is returned only after every component
+// it has an edge to.
+func stronglyConnected(nodes []string, edges func(string) []string) [][]string {
+	index := make(map[string]int, len(nodes))
+	lowLink := make(map[string]int, len(nodes))
+	onStack := make(map[string]bool, len(nodes))
+	var stack []string
+	var components [][]string
+
+	var visit func(node string)
+	visit = func(node string) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+
+		for _, next := range edges(node) {
+			if _, seen := index[next]; !seen {
+				visit(next)
+				lowLink[node] = min(lowLink[node], lowLink[next])
+			} else if onStack[next] {
+				lowLink[node] = min(lowLink[node], index[next])
+			}
+		}
+
+		if lowLink[node] == index[node] {
+			var component []string
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component = append(component, top)
+				if top == node {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+
+	for _, node := range nodes {
+		if _, seen := index[node]; !seen {
+			visit(node)
+		}
+	}
+	return components
+}
+
+// WriteInstallScript writes to w a POSIX shell script installing the batches of
+// TopologicalInstallOrder with one dpkg -i per batch. The .deb files are taken from
+// the Filename of each package in pkgs, relative to the directory of the script.
+func WriteInstallScript(w io.Writer, batches [][]string, pkgs map[string]Package) error {
+	var sb strings.Builder
+	sb.WriteString("#!/bin/sh\n")
+	sb.WriteString("# Installs the packages in dependency order; run as root.\n")
+	sb.WriteString("set -e\n")
+	sb.WriteString("cd \"$(dirname \"$0\")\"\n")
+
+	for i, batch := range batches {
+		sb.WriteString(fmt.Sprintf("\n# Batch %d/%d\n", i+1, len(batches)))
+		sb.WriteString("dpkg -i")
+		for _, name := range batch {
+			pkg, ok := pkgs[name]
+			if !ok || pkg.Filename == "" {
+				return fmt.Errorf("no file for package %s", name)
+			}
+			sb.WriteString(" ")
+			sb.WriteString(shellQuote(filepath.ToSlash(pkg.Filename)))
+		}
+		sb.WriteString("\n")
+	}
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("unable to write install script: %w", err)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
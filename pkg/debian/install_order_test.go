@@ -0,0 +1,80 @@
+package debian
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopologicalInstallOrder(t *testing.T) {
+	pkgs := map[string]Package{
+		"libc6":       {Name: "libc6", Depends: []string{"libgcc-s1"}},
+		"libgcc-s1":   {Name: "libgcc-s1", Depends: []string{"gcc-12-base (= 12.2.0-14)", "libc6 (>= 2.35)"}},
+		"gcc-12-base": {Name: "gcc-12-base"},
+		"hello":       {Name: "hello", Depends: []string{"libc6 (>= 2.34)"}},
+		"mawk":        {Name: "mawk", Depends: []string{"libc6"}, Provides: []string{"awk"}},
+		"base-files":  {Name: "base-files", PreDepends: []string{"awk"}},
+		"libtinfo6":   {Name: "libtinfo6", PreDepends: []string{"libc6:any (>= 2.34)"}},
+		"bash":        {Name: "bash", PreDepends: []string{"libtinfo6 (>= 6)"}, Depends: []string{"base-files (>= 2.1.12)", "debianutils | busybox"}},
+	}
+
+	got, err := TopologicalInstallOrder(pkgs)
+	if err != nil {
+		t.Fatalf("TopologicalInstallOrder failed: %v", err)
+	}
+	want := [][]string{
+		{"gcc-12-base", "hello", "libc6", "libgcc-s1", "mawk"},
+		{"base-files", "libtinfo6"},
+		{"bash"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got batches %v, want %v", got, want)
+	}
+
+	pkgs["libc6"] = Package{Name: "libc6", PreDepends: []string{"libgcc-s1"}, Depends: []string{"libgcc-s1"}}
+	pkgs["libgcc-s1"] = Package{Name: "libgcc-s1", PreDepends: []string{"libc6"}}
+	_, err = TopologicalInstallOrder(pkgs)
+	var cycleErr *PreDependsCycleError
+	if !errors.As(err, &cycleErr) || !reflect.DeepEqual(cycleErr.Packages, []string{"libc6", "libgcc-s1"}) {
+		t.Fatalf("expected a Pre-Depends cycle between libc6 and libgcc-s1, got %v", err)
+	}
+
+	// A Pre-Depends closing a Depends cycle cannot be ordered either
+	pkgs["libc6"] = Package{Name: "libc6", PreDepends: []string{"libgcc-s1"}}
+	pkgs["libgcc-s1"] = Package{Name: "libgcc-s1", Depends: []string{"gcc-12-base", "libc6"}}
+	_, err = TopologicalInstallOrder(pkgs)
+	if !errors.As(err, &cycleErr) || !reflect.DeepEqual(cycleErr.Packages, []string{"libc6", "libgcc-s1"}) {
+		t.Fatalf("expected a Pre-Depends cycle between libc6 and libgcc-s1, got %v", err)
+	}
+}
+
+func TestWriteInstallScript(t *testing.T) {
+	pkgs := map[string]Package{
+		"libc6": {Name: "libc6", Filename: "pool/main/g/glibc/libc6_2.36-9_amd64.deb"},
+		"hello": {Name: "hello", Filename: "pool/main/h/hello/hello_2.10-3_amd64.deb"},
+		"bash":  {Name: "bash", Filename: "pool/main/b/bash/bash_5.2.15-2+b2_amd64.deb"},
+	}
+
+	var sb strings.Builder
+	if err := WriteInstallScript(&sb, [][]string{{"hello", "libc6"}, {"bash"}}, pkgs); err != nil {
+		t.Fatalf("WriteInstallScript failed: %v", err)
+	}
+	script := sb.String()
+	for _, line := range []string{
+		"#!/bin/sh\n",
+		"\ndpkg -i 'pool/main/h/hello/hello_2.10-3_amd64.deb' 'pool/main/g/glibc/libc6_2.36-9_amd64.deb'\n",
+		"\ndpkg -i 'pool/main/b/bash/bash_5.2.15-2+b2_amd64.deb'\n",
+	} {
+		if !strings.Contains(script, line) {
+			t.Fatalf("script lacks %q:\n%s", line, script)
+		}
+	}
+	if strings.Index(script, "hello_") > strings.Index(script, "bash_") {
+		t.Fatalf("batches out of order:\n%s", script)
+	}
+
+	if err := WriteInstallScript(&sb, [][]string{{"missing"}}, pkgs); err == nil {
+		t.Fatal("expected an error for a package without a file")
+	}
+}
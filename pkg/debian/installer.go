@@ -0,0 +1,109 @@
+package debian
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// installerDirectory is the directory of a component holding its debian-installer
+// indices, e.g. dists/bookworm/main/debian-installer/binary-amd64/Packages.
+const installerDirectory = "debian-installer"
+
+// InstallerComponent returns the path, relative to the suite, under which component
+// publishes its debian-installer (udeb) indices, e.g. main/debian-installer. It is
+// used wherever a component names a Packages index: the indices live under
+// dists/<suite>/main/debian-installer/binary-<arch>/, and the Release file lists them
+// with that prefix.
+func InstallerComponent(component string) string {
+	return component + "/" + installerDirectory
+}
+
+// installerBaseComponent returns the component an InstallerComponent path belongs to,
+// and whether component is one.
+func installerBaseComponent(component string) (string, bool) {
+	return strings.CutSuffix(component, "/"+installerDirectory)
+}
+
+// FetchInstallerPackages fetches the debian-installer Packages indices of the
+// configured components and architectures instead of the regular ones, replacing the
+// loaded metadata like FetchPackages. Their entries are udebs, whose Filename ends in
+// .udeb; their Sections name the InstallerComponent they were found in. Indices the
+// Release file does not list, e.g. for components without installer packages, are
+// skipped; the fetch fails with an error matching ErrNotFound when none is listed.
+func (r *Repository) FetchInstallerPackages() ([]string, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	r.installerIndices = true
+	defer func() { r.installerIndices = false }()
+	return r.fetchPackages(context.Background())
+}
+
+// releaseListsPackagesIndex reports whether the loaded Release file lists a variant
+// of the Packages index of component and arch. Without a Release file, every index
+// is assumed to exist.
+func (r *Repository) releaseListsPackagesIndex(component, arch string) bool {
+	return r.releaseListsIndex(fmt.Sprintf("%s/binary-%s/Packages", component, arch))
+}
+
+// releaseListsSourcesIndex is releaseListsPackagesIndex for the Sources index of
+// component.
+func (r *Repository) releaseListsSourcesIndex(component string) bool {
+	return r.releaseListsIndex(component + "/source/Sources")
+}
+
+// releaseListsIndex reports whether the loaded Release file lists a file starting
+// with prefix, true without a Release file.
+func (r *Repository) releaseListsIndex(prefix string) bool {
+	release := r.GetReleaseInfo()
+	if release == nil {
+		return true
+	}
+
+	for _, entries := range [][]FileChecksum{release.SHA256, release.MD5Sum} {
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Filename, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mirrorInstallerIndices mirrors the debian-installer indices of component listed in
+// the Release file of suite, with the same handling of missing indices as the
+// regular ones.
+func (m *Mirror) mirrorInstallerIndices(suite, component string) error {
+	installer := InstallerComponent(component)
+	for _, arch := range m.ArchitecturesForSuite(suite) {
+		if !m.repository.releaseListsPackagesIndex(installer, arch) {
+			m.emit(MirrorEvent{Type: MirrorEventInfo, Suite: suite, Component: installer, Arch: arch, Message: fmt.Sprintf("No debian-installer index for %s/%s/%s, skipping it", suite, component, arch)})
+			continue
+		}
+
+		err := m.mirrorArchitecture(suite, installer, arch)
+		if err != nil && m.config.SkipMissing && errors.Is(err, ErrNotFound) {
+			m.skipMissingCombination(suite, installer, arch, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to mirror debian-installer architecture %s: %w", arch, err)
+		}
+	}
+	return nil
+}
+
+// fetchComponentPackages fetches the Packages index of suite, component and arch into
+// the mirror's repository; component may be an InstallerComponent.
+func (m *Mirror) fetchComponentPackages(suite, component, arch string) ([]string, error) {
+	m.repository.SetSuite(suite)
+	m.repository.SetArchitectures([]string{arch})
+	if base, ok := installerBaseComponent(component); ok {
+		m.repository.SetComponents([]string{base})
+		return m.repository.FetchInstallerPackages()
+	}
+	m.repository.SetComponents([]string{component})
+	return m.repository.FetchPackages()
+}
@@ -0,0 +1,681 @@
+package debian
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProblemSeverity tells how a Problem found by ValidateRepoLayout affects apt.
+type ProblemSeverity string
+
+const (
+	ProblemError   ProblemSeverity = "error"   // apt fails on the repository or on part of it
+	ProblemWarning ProblemSeverity = "warning" // apt copes, but older versions or other tools may not
+)
+
+// Problem is an apt compatibility issue found by ValidateRepoLayout.
+type Problem struct {
+	Severity ProblemSeverity
+	Path     string // Slash-separated path of the file at fault, relative to the repository root
+	Line     int    // Line of Path, of its decompressed content for an index; 0 for the whole file
+	Message  string
+}
+
+func (p Problem) String() string {
+	location := p.Path
+	if p.Line > 0 {
+		location = fmt.Sprintf("%s:%d", p.Path, p.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.Severity, location, p.Message)
+}
+
+// releaseFields lists the Release fields as apt and dak spell them.
+var releaseFields = []string{
+	"Origin", "Label", "Suite", "Version", "Codename", "Date", "Valid-Until", "Description",
+	"Architectures", "Components", "MD5Sum", "SHA1", "SHA256", "SHA512", "Acquire-By-Hash",
+	"NotAutomatic", "ButAutomaticUpgrades", "Changelogs", "Snapshots", "Signed-By",
+	"No-Support-for-Architecture-all",
+}
+
+// releaseChecksumHashes maps the checksum fields of a Release file to their hash.
+var releaseChecksumHashes = map[string]func() hash.Hash{
+	"MD5Sum": md5.New,
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// Fields of the Packages and Sources stanzas whose spelling ValidateRepoLayout checks.
+var (
+	packagesFields = []string{"Package", "Version", "Architecture", "Filename", "Size", "MD5sum", "SHA256"}
+	sourcesFields  = []string{"Package", "Version", "Directory", "Files", "Checksums-Sha256"}
+)
+
+// ValidateRepoLayout checks the repository rooted at rootDir the way apt consumes it:
+// it reads the InRelease or Release file of each suite under dists/, checks the files
+// they list against their size and checksums, parses each Packages and Sources index
+// and checks that the pool files they reference are there with the right size and
+// SHA256. Indices of directories absent from dists/, e.g. the architectures a mirror
+// left out, are not required. The error is only set when rootDir cannot be read.
+func ValidateRepoLayout(rootDir string) ([]Problem, error) {
+	entries, err := os.ReadDir(filepath.Join(rootDir, "dists"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read dists directory: %w", err)
+	}
+
+	check := &layoutCheck{root: rootDir, pool: make(map[string]poolFileInfo)}
+	suites := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if check.checkSuite(entry.Name()) {
+			suites++
+		}
+	}
+	if suites == 0 {
+		check.report(ProblemError, "dists", 0, "no suite has a Release or InRelease file")
+	}
+	return check.problems, nil
+}
+
+// layoutCheck gathers the problems of a ValidateRepoLayout run.
+type layoutCheck struct {
+	root     string
+	problems []Problem
+	pool     map[string]poolFileInfo // Pool files already read, by path relative to root
+}
+
+// poolFileInfo is the size and SHA256 of a pool file, or why they are unknown.
+type poolFileInfo struct {
+	size   int64
+	sha256 string
+	err    error
+}
+
+// releaseEntry is a file listed in a Release file, with its checksums by field.
+type releaseEntry struct {
+	line   int
+	size   int64
+	hashes map[string]string
+}
+
+// numberedLine is a line of a file with its 1-based number.
+type numberedLine struct {
+	n    int
+	text string
+}
+
+func (c *layoutCheck) report(severity ProblemSeverity, relPath string, line int, format string, args ...any) {
+	c.problems = append(c.problems, Problem{Severity: severity, Path: relPath, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkSuite checks dists/<suite>, preferring InRelease as apt does. It reports whether
+// the directory is a suite, i.e. has a Release or InRelease file.
+func (c *layoutCheck) checkSuite(suite string) bool {
+	suiteRel := "dists/" + suite
+	suiteDir := filepath.Join(c.root, "dists", suite)
+	inRelease, inErr := os.ReadFile(filepath.Join(suiteDir, "InRelease"))
+	release, releaseErr := os.ReadFile(filepath.Join(suiteDir, "Release"))
+	if errors.Is(inErr, fs.ErrNotExist) && errors.Is(releaseErr, fs.ErrNotExist) {
+		return false
+	}
+
+	var releaseRel string
+	var lines []numberedLine
+	switch {
+	case inErr == nil:
+		releaseRel = suiteRel + "/InRelease"
+		if isClearsigned(inRelease) {
+			lines = clearsignedLines(inRelease)
+		} else {
+			c.report(ProblemWarning, releaseRel, 0, "InRelease is not clearsigned; apt expects a signed InRelease and may reject the suite")
+			lines = numberLines(inRelease)
+		}
+	case releaseErr == nil:
+		releaseRel = suiteRel + "/Release"
+		lines = numberLines(release)
+	default:
+		c.report(ProblemError, suiteRel, 0, "unable to read the Release files: %v", errors.Join(inErr, releaseErr))
+		return true
+	}
+
+	entries := c.checkReleaseFields(releaseRel, lines)
+	c.checkReleaseEntries(suite, releaseRel, entries)
+	return true
+}
+
+// checkReleaseFields checks the fields of the Release file at releaseRel and returns
+// the files it lists, by name.
+func (c *layoutCheck) checkReleaseFields(releaseRel string, lines []numberedLine) map[string]*releaseEntry {
+	entries := make(map[string]*releaseEntry)
+	seen := make(map[string]int)
+	values := make(map[string]string)
+	checksumField := ""
+	strong := false
+
+	for _, line := range lines {
+		if strings.TrimSpace(line.text) == "" {
+			continue
+		}
+		if line.text[0] == ' ' || line.text[0] == '\t' {
+			if len(seen) == 0 {
+				c.report(ProblemError, releaseRel, line.n, "continuation line without a field")
+			}
+			if checksumField == "" {
+				continue
+			}
+			fields := strings.Fields(line.text)
+			size, err := strconv.ParseInt(safeField(fields, 1), 10, 64)
+			if len(fields) != 3 || err != nil || size < 0 {
+				c.report(ProblemError, releaseRel, line.n, "malformed %s entry %q", checksumField, strings.TrimSpace(line.text))
+				continue
+			}
+			entry, ok := entries[fields[2]]
+			if !ok {
+				entry = &releaseEntry{line: line.n, size: size, hashes: make(map[string]string)}
+				entries[fields[2]] = entry
+			} else if entry.size != size {
+				c.report(ProblemError, releaseRel, line.n, "%s is listed with size %d here and %d at line %d", fields[2], size, entry.size, entry.line)
+			}
+			entry.hashes[checksumField] = strings.ToLower(fields[0])
+			strong = strong || checksumField == "SHA256" || checksumField == "SHA512"
+			continue
+		}
+
+		key, value, ok := strings.Cut(line.text, ":")
+		if !ok {
+			c.report(ProblemError, releaseRel, line.n, "malformed line %q", line.text)
+			checksumField = ""
+			continue
+		}
+		key = c.canonicalField(releaseRel, line.n, key, releaseFields)
+		if first, dup := seen[key]; dup {
+			c.report(ProblemError, releaseRel, line.n, "duplicate %s field, first set at line %d", key, first)
+		}
+		seen[key] = line.n
+		values[key] = strings.TrimSpace(value)
+		checksumField = ""
+		if _, ok := releaseChecksumHashes[key]; ok {
+			checksumField = key
+		}
+	}
+
+	if values["Suite"] == "" && values["Codename"] == "" {
+		c.report(ProblemError, releaseRel, 0, "neither Suite nor Codename is set, so apt cannot match the suite of sources.list")
+	}
+	if values["Date"] == "" {
+		c.report(ProblemError, releaseRel, 0, "no Date field")
+	} else if _, err := parseReleaseDate(values["Date"]); err != nil {
+		c.report(ProblemError, releaseRel, seen["Date"], "invalid Date: %v", err)
+	}
+	if validUntil := values["Valid-Until"]; validUntil != "" {
+		if t, err := parseReleaseDate(validUntil); err != nil {
+			c.report(ProblemError, releaseRel, seen["Valid-Until"], "invalid Valid-Until: %v", err)
+		} else if t.Before(time.Now()) {
+			c.report(ProblemError, releaseRel, seen["Valid-Until"], "expired on %s", t.Format(time.RFC1123Z))
+		}
+	}
+	for _, field := range []string{"Components", "Architectures"} {
+		if values[field] == "" {
+			c.report(ProblemWarning, releaseRel, 0, "no %s field", field)
+		}
+	}
+	if !strong {
+		c.report(ProblemError, releaseRel, 0, "no SHA256 or SHA512 checksums; apt rejects Release files with only weak hashes")
+	}
+	return entries
+}
+
+// canonicalField returns the spelling of key among fields, reporting a key that only
+// matches one of them case-insensitively. Unknown keys are returned unchanged.
+func (c *layoutCheck) canonicalField(relPath string, line int, key string, fields []string) string {
+	if slices.Contains(fields, key) {
+		return key
+	}
+	i := slices.IndexFunc(fields, func(field string) bool { return strings.EqualFold(field, key) })
+	if i < 0 {
+		return key
+	}
+	c.report(ProblemWarning, relPath, line, "field %s should be spelled %s", key, fields[i])
+	return fields[i]
+}
+
+// checkReleaseEntries checks the files listed by the Release file of suite against
+// their size and checksums, then parses the Packages and Sources indices.
+func (c *layoutCheck) checkReleaseEntries(suite, releaseRel string, entries map[string]*releaseEntry) {
+	suiteRel := "dists/" + suite
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	verified := make(map[string]bool)
+	variants := make(map[string][]string) // Listed variants of each index, by uncompressed name
+	for _, name := range names {
+		entry := entries[name]
+		if !isCanonicalPath(name) {
+			c.report(ProblemError, releaseRel, entry.line, "non-canonical path %q", name)
+			continue
+		}
+		if base, ok := indexBaseName(name); ok {
+			variants[base] = append(variants[base], name)
+		}
+
+		local := filepath.Join(c.root, filepath.FromSlash(suiteRel+"/"+name))
+		sums, size, err := hashFileWith(local, entry.hashes)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Index variants are checked below; other files are optional to apt
+		}
+		if err != nil {
+			c.report(ProblemError, suiteRel+"/"+name, 0, "unable to read: %v", err)
+			continue
+		}
+		ok := size == entry.size
+		if !ok {
+			c.report(ProblemError, suiteRel+"/"+name, 0, "size is %d, the Release file says %d (line %d)", size, entry.size, entry.line)
+		}
+		for _, field := range sortedKeys(entry.hashes) {
+			if sums[field] != entry.hashes[field] {
+				c.report(ProblemError, suiteRel+"/"+name, 0, "does not match its %s checksum in the Release file (line %d)", field, entry.line)
+				ok = false
+			}
+		}
+		verified[name] = ok
+	}
+
+	for _, base := range sortedKeys(variants) {
+		if _, err := os.Stat(filepath.Join(c.root, filepath.FromSlash(suiteRel+"/"+path.Dir(base)))); err != nil {
+			continue // Not published here, e.g. an architecture left out of a mirror
+		}
+		if _, ok := entries[base]; !ok {
+			c.report(ProblemWarning, releaseRel, entries[variants[base][0]].line, "no checksum of the uncompressed %s; apt versions verifying indices after decompression reject it", base)
+		}
+
+		parsed := false
+		for _, ext := range CompressionExtensions {
+			if !verified[base+ext] {
+				continue
+			}
+			c.checkIndex(suiteRel+"/"+base+ext, ext)
+			parsed = true
+			break
+		}
+		if !parsed && !slices.ContainsFunc(variants[base], func(name string) bool { _, ok := verified[name]; return ok }) {
+			c.report(ProblemError, releaseRel, entries[variants[base][0]].line, "no variant of %s listed in the Release file is present", base)
+		}
+	}
+
+	c.checkUnlistedIndices(suiteRel, entries)
+}
+
+// checkUnlistedIndices reports the Packages and Sources files under suiteRel that the
+// Release file does not list: apt cannot verify them, so it never uses them.
+func (c *layoutCheck) checkUnlistedIndices(suiteRel string, entries map[string]*releaseEntry) {
+	suiteDir := filepath.Join(c.root, filepath.FromSlash(suiteRel))
+	filepath.WalkDir(suiteDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == "by-hash" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(suiteDir, p)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if _, ok := indexBaseName(rel); ok {
+			if _, listed := entries[rel]; !listed {
+				c.report(ProblemWarning, suiteRel+"/"+rel, 0, "not listed in the Release file, so apt ignores it")
+			}
+		}
+		return nil
+	})
+}
+
+// checkIndex parses the Packages or Sources index at relPath, compressed with ext.
+func (c *layoutCheck) checkIndex(relPath, ext string) {
+	file, err := os.Open(filepath.Join(c.root, filepath.FromSlash(relPath)))
+	if err != nil {
+		c.report(ProblemError, relPath, 0, "unable to read: %v", err)
+		return
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if ext != "" {
+		decompressed, cleanup, err := (&Repository{}).createDecompressor(file, ext)
+		if err != nil {
+			c.report(ProblemError, relPath, 0, "%v", err)
+			return
+		}
+		if cleanup != nil {
+			defer cleanup()
+		}
+		reader = decompressed
+	}
+
+	check := c.checkPackagesStanza
+	if base, _ := indexBaseName(relPath); path.Base(base) == "Sources" {
+		check = c.checkSourcesStanza
+	}
+	// The architecture of binary-<arch>/Packages, empty for Sources
+	arch := strings.TrimPrefix(path.Base(path.Dir(relPath)), "binary-")
+
+	seen := make(map[string]int)
+	err = scanStanzas(reader, func(stanza deb822Stanza) {
+		check(relPath, arch, stanza, seen)
+	}, func(line int, msg string) {
+		c.report(ProblemError, relPath, line, "%s", msg)
+	})
+	if err != nil {
+		c.report(ProblemError, relPath, 0, "unable to parse: %v", err)
+	}
+}
+
+// checkPackagesStanza checks an entry of the Packages index at relPath for arch, and
+// the pool file it references. seen holds the first line of each entry of the index.
+func (c *layoutCheck) checkPackagesStanza(relPath, arch string, stanza deb822Stanza, seen map[string]int) {
+	fields := c.stanzaFields(relPath, stanza, packagesFields)
+	name := fields["Package"]
+	if name == "" {
+		name = "entry"
+	}
+	for _, field := range []string{"Package", "Version", "Architecture", "Filename", "Size"} {
+		if fields[field] == "" {
+			c.report(ProblemError, relPath, stanza.line, "%s has no %s field", name, field)
+		}
+	}
+
+	key := fields["Package"] + " " + fields["Version"] + " " + fields["Architecture"]
+	if first, dup := seen[key]; dup {
+		c.report(ProblemWarning, relPath, stanza.line, "duplicate entry for %s, first at line %d", key, first)
+	} else {
+		seen[key] = stanza.line
+	}
+	if pkgArch := fields["Architecture"]; pkgArch != "" && pkgArch != arch && pkgArch != "all" {
+		c.report(ProblemWarning, relPath, stanza.line, "%s is built for %s, not %s", name, pkgArch, arch)
+	}
+
+	var size int64 = -1
+	if fields["Size"] != "" {
+		var err error
+		if size, err = strconv.ParseInt(fields["Size"], 10, 64); err != nil || size < 0 {
+			c.report(ProblemError, relPath, stanza.line, "%s has an invalid Size %q", name, fields["Size"])
+			size = -1
+		}
+	}
+	if fields["SHA256"] == "" {
+		if fields["MD5sum"] == "" {
+			c.report(ProblemError, relPath, stanza.line, "%s has no checksum, so apt refuses to download it", name)
+		} else {
+			c.report(ProblemWarning, relPath, stanza.line, "%s has no SHA256 checksum, only MD5sum", name)
+		}
+	}
+
+	filename := fields["Filename"]
+	if filename == "" {
+		return
+	}
+	if !isCanonicalPath(filename) {
+		c.report(ProblemError, relPath, stanza.line, "%s has a non-canonical Filename %q", name, filename)
+		return
+	}
+	if !strings.HasPrefix(filename, "pool/") {
+		c.report(ProblemWarning, relPath, stanza.line, "%s has a Filename outside pool/: %s", name, filename)
+	}
+	c.checkPoolFile(relPath, stanza.line, filename, size, fields["SHA256"])
+}
+
+// checkSourcesStanza checks an entry of the Sources index at relPath and the pool
+// files it references. seen holds the first line of each entry of the index.
+func (c *layoutCheck) checkSourcesStanza(relPath, _ string, stanza deb822Stanza, seen map[string]int) {
+	fields := c.stanzaFields(relPath, stanza, sourcesFields)
+	name := fields["Package"]
+	if name == "" {
+		name = "entry"
+	}
+	for _, field := range []string{"Package", "Version", "Directory"} {
+		if fields[field] == "" {
+			c.report(ProblemError, relPath, stanza.line, "%s has no %s field", name, field)
+		}
+	}
+
+	key := fields["Package"] + " " + fields["Version"]
+	if first, dup := seen[key]; dup {
+		c.report(ProblemWarning, relPath, stanza.line, "duplicate entry for %s, first at line %d", key, first)
+	} else {
+		seen[key] = stanza.line
+	}
+
+	directory := fields["Directory"]
+	if directory == "" {
+		return
+	}
+	if !isCanonicalPath(directory) {
+		c.report(ProblemError, relPath, stanza.line, "%s has a non-canonical Directory %q", name, directory)
+		return
+	}
+
+	list, sha256Listed := fields["Checksums-Sha256"], true
+	if list == "" {
+		list, sha256Listed = fields["Files"], false
+		c.report(ProblemWarning, relPath, stanza.line, "%s has no Checksums-Sha256 field", name)
+	}
+	if strings.TrimSpace(list) == "" {
+		c.report(ProblemError, relPath, stanza.line, "%s lists no files", name)
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(list), "\n") {
+		parts := strings.Fields(line)
+		size, err := strconv.ParseInt(safeField(parts, 1), 10, 64)
+		if len(parts) != 3 || err != nil || !isCanonicalPath(parts[2]) || strings.Contains(parts[2], "/") {
+			c.report(ProblemError, relPath, stanza.line, "%s has a malformed file entry %q", name, strings.TrimSpace(line))
+			continue
+		}
+		sum := ""
+		if sha256Listed {
+			sum = parts[0]
+		}
+		c.checkPoolFile(relPath, stanza.line, directory+"/"+parts[2], size, sum)
+	}
+}
+
+// stanzaFields returns the values of the fields of stanza, keyed by their spelling
+// among known for the fields only differing from it by case.
+func (c *layoutCheck) stanzaFields(relPath string, stanza deb822Stanza, known []string) map[string]string {
+	fields := make(map[string]string, len(stanza.fields))
+	for _, field := range stanza.fields {
+		fields[c.canonicalField(relPath, field.line, field.name, known)] = field.value
+	}
+	return fields
+}
+
+// checkPoolFile checks that the pool file filename, referenced at line of relPath,
+// exists with size and the SHA256 sum, when they are known (-1 and "" otherwise).
+func (c *layoutCheck) checkPoolFile(relPath string, line int, filename string, size int64, sum string) {
+	info, ok := c.pool[filename]
+	if !ok {
+		sums, fileSize, err := hashFileWith(filepath.Join(c.root, filepath.FromSlash(filename)), map[string]string{"SHA256": ""})
+		info = poolFileInfo{size: fileSize, sha256: sums["SHA256"], err: err}
+		c.pool[filename] = info
+	}
+
+	switch {
+	case errors.Is(info.err, fs.ErrNotExist):
+		c.report(ProblemError, relPath, line, "%s is missing", filename)
+	case info.err != nil:
+		c.report(ProblemError, relPath, line, "unable to read %s: %v", filename, info.err)
+	case size >= 0 && info.size != size:
+		c.report(ProblemError, relPath, line, "%s is %d bytes, the index says %d", filename, info.size, size)
+	case sum != "" && !strings.EqualFold(info.sha256, sum):
+		c.report(ProblemError, relPath, line, "%s does not match its SHA256 checksum", filename)
+	}
+}
+
+// deb822Stanza is a paragraph of a Packages or Sources index.
+type deb822Stanza struct {
+	line   int // Line of its first field
+	fields []deb822Field
+}
+
+// deb822Field is a field of a stanza; continuation lines are joined with newlines.
+type deb822Field struct {
+	name  string
+	value string
+	line  int
+}
+
+// scanStanzas calls fn with each stanza read from r, and malformed with the lines
+// that belong to no field. Lines are not limited in length.
+func scanStanzas(r io.Reader, fn func(deb822Stanza), malformed func(line int, msg string)) error {
+	var stanza deb822Stanza
+	flush := func() {
+		if len(stanza.fields) > 0 {
+			fn(stanza)
+		}
+		stanza = deb822Stanza{}
+	}
+
+	lines := newLineReader(r)
+	for n := 1; lines.Scan(); n++ {
+		text := strings.TrimRight(lines.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			flush()
+			continue
+		}
+		if text[0] == ' ' || text[0] == '\t' {
+			if len(stanza.fields) == 0 {
+				malformed(n, "continuation line without a field")
+				continue
+			}
+			last := &stanza.fields[len(stanza.fields)-1]
+			last.value += "\n" + strings.TrimSpace(text)
+			continue
+		}
+		name, value, ok := strings.Cut(text, ":")
+		if !ok {
+			malformed(n, fmt.Sprintf("malformed line %q", text))
+			continue
+		}
+		if len(stanza.fields) == 0 {
+			stanza.line = n
+		}
+		stanza.fields = append(stanza.fields, deb822Field{name: name, value: strings.TrimSpace(value), line: n})
+	}
+	flush()
+	return lines.Err()
+}
+
+// hashFileWith returns the size of the file at path and its checksums for each of the
+// Release checksum fields keyed in fields.
+func hashFileWith(path string, fields map[string]string) (map[string]string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	hashes := make(map[string]hash.Hash, len(fields))
+	writers := make([]io.Writer, 0, len(fields))
+	for field := range fields {
+		h := releaseChecksumHashes[field]()
+		hashes[field] = h
+		writers = append(writers, h)
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sums := make(map[string]string, len(hashes))
+	for field, h := range hashes {
+		sums[field] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, size, nil
+}
+
+// indexBaseName returns name without its compression extension when it is a Packages
+// or Sources index, e.g. main/binary-amd64/Packages for main/binary-amd64/Packages.xz.
+func indexBaseName(name string) (string, bool) {
+	for _, ext := range []string{".gz", ".xz", ".bz2", ".lzma", ".zst"} {
+		if trimmed, ok := strings.CutSuffix(name, ext); ok {
+			name = trimmed
+			break
+		}
+	}
+	base := path.Base(name)
+	return name, base == "Packages" || base == "Sources"
+}
+
+// isCanonicalPath reports whether p is a clean relative slash-separated path, as apt
+// requires of the paths of Release files and indices.
+func isCanonicalPath(p string) bool {
+	return p != "" && !path.IsAbs(p) && path.Clean(p) == p && p != ".." && !strings.HasPrefix(p, "../") && !strings.Contains(p, "\\")
+}
+
+// numberLines splits data into numbered lines.
+func numberLines(data []byte) []numberedLine {
+	var lines []numberedLine
+	for i, text := range strings.Split(string(data), "\n") {
+		lines = append(lines, numberedLine{n: i + 1, text: strings.TrimRight(text, "\r")})
+	}
+	return lines
+}
+
+// clearsignedLines returns the numbered lines of the signed content of a clearsigned
+// file, without the armor headers and dash-escaping.
+func clearsignedLines(data []byte) []numberedLine {
+	var lines []numberedLine
+	started := false
+	for _, line := range numberLines(data) {
+		if strings.HasPrefix(line.text, "-----BEGIN PGP SIGNATURE-----") {
+			break
+		}
+		if !started {
+			started = line.text == ""
+			continue
+		}
+		line.text = strings.TrimPrefix(line.text, "- ")
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// safeField returns fields[i], or "" when there are fewer fields.
+func safeField(fields []string, i int) string {
+	if i < len(fields) {
+		return fields[i]
+	}
+	return ""
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,141 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const layoutTestDeb = "pool/main/h/hello/hello_2.10-3_amd64.deb"
+
+// writeLayoutTestRepository writes a custom repository of suite bookworm holding the
+// given entries, or a valid hello package when there are none, under root.
+func writeLayoutTestRepository(t *testing.T, root string, pkgs ...Package) {
+	t.Helper()
+	deb := []byte("hello 2.10-3 deb")
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(root, layoutTestDeb)), DirPermission); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, layoutTestDeb), deb, FilePermission); err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) == 0 {
+		sum := sha256.Sum256(deb)
+		pkgs = []Package{{Name: "hello", Package: "hello", Version: "2.10-3", Architecture: "amd64", Filename: layoutTestDeb, Size: int64(len(deb)), SHA256: hex.EncodeToString(sum[:])}}
+	}
+
+	dists := NewLocalStorage(filepath.Join(root, "dists"))
+	if err := WritePackagesMetadataToStorage(dists, "", "bookworm", map[string]map[string][]Package{"main": {"amd64": pkgs}}, true); err != nil {
+		t.Fatalf("WritePackagesMetadataToStorage failed: %v", err)
+	}
+	if err := WriteSignedReleaseFilesToStorage(dists, "", "bookworm", []string{"main"}, []string{"amd64"}, false, true, nil); err != nil {
+		t.Fatalf("WriteSignedReleaseFilesToStorage failed: %v", err)
+	}
+}
+
+func TestValidateRepoLayout(t *testing.T) {
+	unsignedInRelease := Problem{Severity: ProblemWarning, Path: "dists/bookworm/InRelease", Message: "InRelease is not clearsigned; apt expects a signed InRelease and may reject the suite"}
+	packagesPath := "dists/bookworm/main/binary-amd64/Packages"
+
+	t.Run("valid", func(t *testing.T) {
+		root := t.TempDir()
+		writeLayoutTestRepository(t, root)
+		problems, err := ValidateRepoLayout(root)
+		if err != nil {
+			t.Fatalf("ValidateRepoLayout failed: %v", err)
+		}
+		if len(problems) != 1 || problems[0] != unsignedInRelease {
+			t.Fatalf("unexpected problems %v", problems)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		mutate func(t *testing.T, root string)
+		want   Problem
+	}{
+		{
+			name: "corrupted pool file",
+			mutate: func(t *testing.T, root string) {
+				os.WriteFile(filepath.Join(root, layoutTestDeb), []byte("hello 2.10-3 DEB"), FilePermission)
+			},
+			want: Problem{Severity: ProblemError, Path: packagesPath, Line: 1, Message: layoutTestDeb + " does not match its SHA256 checksum"},
+		},
+		{
+			name: "missing pool file",
+			mutate: func(t *testing.T, root string) {
+				os.Remove(filepath.Join(root, layoutTestDeb))
+			},
+			want: Problem{Severity: ProblemError, Path: packagesPath, Line: 1, Message: layoutTestDeb + " is missing"},
+		},
+		{
+			name: "truncated index",
+			mutate: func(t *testing.T, root string) {
+				os.WriteFile(filepath.Join(root, packagesPath+".gz"), nil, FilePermission)
+			},
+			want: Problem{Severity: ProblemError, Path: packagesPath + ".gz", Message: "size is 0, the Release file says"},
+		},
+		{
+			name: "field casing",
+			mutate: func(t *testing.T, root string) {
+				for _, name := range []string{"Release", "InRelease"} {
+					path := filepath.Join(root, "dists", "bookworm", name)
+					data, _ := os.ReadFile(path)
+					os.WriteFile(path, []byte(strings.Replace(string(data), "Codename:", "codename:", 1)), FilePermission)
+				}
+			},
+			want: Problem{Severity: ProblemWarning, Path: "dists/bookworm/InRelease", Line: 5, Message: "field codename should be spelled Codename"},
+		},
+		{
+			name: "unlisted index",
+			mutate: func(t *testing.T, root string) {
+				os.MkdirAll(filepath.Join(root, "dists", "bookworm", "contrib", "binary-amd64"), DirPermission)
+				os.WriteFile(filepath.Join(root, "dists", "bookworm", "contrib", "binary-amd64", "Packages"), nil, FilePermission)
+			},
+			want: Problem{Severity: ProblemWarning, Path: "dists/bookworm/contrib/binary-amd64/Packages", Message: "not listed in the Release file"},
+		},
+		{
+			name: "duplicate entry",
+			mutate: func(t *testing.T, root string) {
+				deb := Package{Name: "hello", Package: "hello", Version: "2.10-3", Architecture: "amd64", Filename: layoutTestDeb, MD5sum: "x"}
+				writeLayoutTestRepository(t, root, deb, deb)
+			},
+			want: Problem{Severity: ProblemWarning, Path: packagesPath, Message: "duplicate entry for hello 2.10-3 amd64, first at line 1"},
+		},
+		{
+			name: "non-canonical Filename",
+			mutate: func(t *testing.T, root string) {
+				writeLayoutTestRepository(t, root, Package{Name: "hello", Package: "hello", Version: "2.10-3", Architecture: "amd64", Filename: "pool/../hello.deb", Size: 1, MD5sum: "x"})
+			},
+			want: Problem{Severity: ProblemError, Path: packagesPath, Line: 1, Message: `hello has a non-canonical Filename "pool/../hello.deb"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			writeLayoutTestRepository(t, root)
+			tt.mutate(t, root)
+
+			problems, err := ValidateRepoLayout(root)
+			if err != nil {
+				t.Fatalf("ValidateRepoLayout failed: %v", err)
+			}
+			for _, problem := range problems {
+				if problem.Severity == tt.want.Severity && problem.Path == tt.want.Path && (tt.want.Line == 0 || problem.Line == tt.want.Line) && strings.HasPrefix(problem.Message, tt.want.Message) {
+					return
+				}
+			}
+			t.Fatalf("expected %v, got %v", tt.want, problems)
+		})
+	}
+}
+
+func TestValidateRepoLayoutWithoutDists(t *testing.T) {
+	if _, err := ValidateRepoLayout(t.TempDir()); err == nil {
+		t.Fatal("expected an error for a directory without dists/")
+	}
+}
@@ -0,0 +1,232 @@
+package debian
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// metadataCacheVersion is bumped whenever the serialized layout changes.
+const metadataCacheVersion = 5
+
+// metadataCacheFilename is the name of the parsed metadata cache stored next to
+// the text Packages cache of a suite.
+const metadataCacheFilename = "metadata.gob.gz"
+
+// ErrMetadataCacheStale is returned by LoadMetadata when the cache was written by an
+// incompatible version or the text Packages files it was built from have changed.
+var ErrMetadataCacheStale = errors.New("metadata cache is stale")
+
+// metadataCache is the on-disk representation of parsed repository metadata.
+type metadataCache struct {
+	Version         int
+	Suite           string
+	Components      []string
+	Architectures   []string
+	SourceChecksums map[string]string // Relative path of text cache file -> SHA256, empty when absent
+	PackageMetadata []Package
+	SourceMetadata  []SourcePackage
+	ReleaseInfo     *ReleaseFile
+}
+
+// MetadataCachePath returns the location of the parsed metadata cache for a suite
+// inside a cache directory populated by FetchAndCachePackages.
+func MetadataCachePath(cacheDir, suite string) string {
+	return filepath.Join(cacheDir, suite, metadataCacheFilename)
+}
+
+// SaveMetadata serializes PackageMetadata, SourceMetadata and ReleaseInfo to path as
+// gzip-compressed gob. The checksums of the text Packages and Sources files next to
+// path (component/binary-arch/Packages) are embedded, along with the ones that are
+// absent, so LoadMetadata can detect changes. The file is replaced atomically.
+func (r *Repository) SaveMetadata(path string) error {
+	checksums, err := textCacheChecksums(filepath.Dir(path), r.Components, r.BinaryArchitectures())
+	if err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	cache := metadataCache{
+		Version:         metadataCacheVersion,
+		Suite:           r.Suite,
+		Components:      r.Components,
+		Architectures:   r.Architectures,
+		SourceChecksums: checksums,
+		PackageMetadata: r.PackageMetadata,
+		SourceMetadata:  r.SourceMetadata,
+		ReleaseInfo:     r.ReleaseInfo,
+	}
+	r.mu.RUnlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), DirPermission); err != nil {
+		return fmt.Errorf("unable to create metadata cache directory: %w", err)
+	}
+
+	// Readers sharing the cache directory never see a partly written file
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.partial")
+	if err != nil {
+		return fmt.Errorf("unable to create metadata cache: %w", err)
+	}
+	stagingPath := file.Name()
+	defer os.Remove(stagingPath)
+
+	writer := gzip.NewWriter(file)
+	if err := gob.NewEncoder(writer).Encode(&cache); err != nil {
+		writer.Close()
+		file.Close()
+		return fmt.Errorf("error encoding metadata cache: %w", err)
+	}
+	err = writer.Close()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing metadata cache: %w", err)
+	}
+	if err := os.Chmod(stagingPath, FilePermission); err != nil {
+		return fmt.Errorf("error writing metadata cache: %w", err)
+	}
+	if err := os.Rename(stagingPath, path); err != nil {
+		return fmt.Errorf("error writing metadata cache: %w", err)
+	}
+
+	return nil
+}
+
+// LoadMetadata restores metadata written by SaveMetadata. It returns an error wrapping
+// ErrMetadataCacheStale when the cache format is outdated or the text Packages files
+// it was built from no longer match, in which case callers should re-parse.
+func (r *Repository) LoadMetadata(path string) error {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	return r.loadMetadataCache(path, false)
+}
+
+// loadMetadataCache implements LoadMetadata; fetchMu must be held. When matchConfig is
+// set, the cache is also rejected unless it was built for the repository's suite,
+// components and architectures.
+func (r *Repository) loadMetadataCache(path string, matchConfig bool) error {
+	cache, err := readMetadataCache(path)
+	if err != nil {
+		return err
+	}
+
+	if matchConfig && (cache.Suite != r.Suite || !sameStringSet(cache.Components, r.Components) || !sameStringSet(cache.Architectures, r.Architectures)) {
+		return fmt.Errorf("%w: built for a different suite, components or architectures", ErrMetadataCacheStale)
+	}
+
+	var binaryArchs []string
+	for _, arch := range cache.Architectures {
+		if arch != SourceArchitecture {
+			binaryArchs = append(binaryArchs, arch)
+		}
+	}
+	current, err := textCacheChecksums(filepath.Dir(path), cache.Components, binaryArchs)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMetadataCacheStale, err)
+	}
+	for relPath, actual := range current {
+		if expected, ok := cache.SourceChecksums[relPath]; !ok || actual != expected {
+			return fmt.Errorf("%w: %s changed since the cache was written", ErrMetadataCacheStale, relPath)
+		}
+	}
+	if len(current) != len(cache.SourceChecksums) {
+		return fmt.Errorf("%w: the text cache files changed since the cache was written", ErrMetadataCacheStale)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.PackageMetadata = cache.PackageMetadata
+	r.SourceMetadata = cache.SourceMetadata
+	if cache.ReleaseInfo != nil {
+		r.ReleaseInfo = cache.ReleaseInfo
+	}
+	r.Packages = uniquePackageNames(r.PackageMetadata)
+	return nil
+}
+
+// readMetadataCache decodes a metadata cache file and checks its format version.
+func readMetadataCache(path string) (*metadataCache, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open metadata cache: %w", err)
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error during gzip decompression: %w", err)
+	}
+	defer reader.Close()
+
+	var cache metadataCache
+	if err := gob.NewDecoder(reader).Decode(&cache); err != nil {
+		return nil, fmt.Errorf("error decoding metadata cache: %w", err)
+	}
+
+	if cache.Version != metadataCacheVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrMetadataCacheStale, cache.Version)
+	}
+
+	return &cache, nil
+}
+
+// textCacheChecksums hashes the text Packages files under suiteDir for components and
+// architectures, and the Sources files of the components. Files that are absent are
+// recorded with an empty checksum, so that one appearing later is noticed.
+func textCacheChecksums(suiteDir string, components, architectures []string) (map[string]string, error) {
+	checksums := make(map[string]string)
+
+	for _, component := range components {
+		relPaths := []string{filepath.Join(component, "source", "Sources")}
+		for _, arch := range architectures {
+			relPaths = append(relPaths, filepath.Join(component, fmt.Sprintf("binary-%s", arch), "Packages"))
+		}
+
+		for _, relPath := range relPaths {
+			absPath := filepath.Join(suiteDir, relPath)
+			sum, err := hashFile(absPath, sha256.New())
+			if errors.Is(err, fs.ErrNotExist) {
+				sum, err = "", nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash %s: %w", absPath, err)
+			}
+			checksums[filepath.ToSlash(relPath)] = sum
+		}
+	}
+
+	return checksums, nil
+}
+
+// uniquePackageNames returns the distinct package names in metadata order.
+func uniquePackageNames(metadata []Package) []string {
+	seen := make(map[string]bool, len(metadata))
+	names := make([]string, 0, len(metadata))
+	for i := range metadata {
+		if seen[metadata[i].Name] {
+			continue
+		}
+		seen[metadata[i].Name] = true
+		names = append(names, metadata[i].Name)
+	}
+	return names
+}
+
+// sameStringSet reports whether a and b contain the same values regardless of order.
+func sameStringSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := slices.Clone(a)
+	sortedB := slices.Clone(b)
+	slices.Sort(sortedA)
+	slices.Sort(sortedB)
+	return slices.Equal(sortedA, sortedB)
+}
@@ -0,0 +1,159 @@
+package debian
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTextCache writes a synthetic Packages file with count stanzas into the
+// text cache layout used by FetchAndCachePackages and returns its path.
+func writeTextCache(tb testing.TB, cacheDir string, count int) string {
+	tb.Helper()
+
+	var sb strings.Builder
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&sb, "Package: pkg%d\nVersion: 1.0-%d\nArchitecture: amd64\nMaintainer: Test <test@example.com>\n", i, i)
+		fmt.Fprintf(&sb, "Depends: libc6 (>= 2.36), pkg%d\nFilename: pool/main/p/pkg%d/pkg%d_1.0-%d_amd64.deb\nSize: 1024\n", i+1, i, i, i)
+		fmt.Fprintf(&sb, "SHA256: %064d\nDescription: synthetic package %d\n\n", i, i)
+	}
+
+	dir := filepath.Join(cacheDir, "bookworm", "main", "binary-amd64")
+	if err := os.MkdirAll(dir, DirPermission); err != nil {
+		tb.Fatalf("unable to create cache dir: %v", err)
+	}
+	path := filepath.Join(dir, "Packages")
+	if err := os.WriteFile(path, []byte(sb.String()), FilePermission); err != nil {
+		tb.Fatalf("unable to write Packages cache: %v", err)
+	}
+	return path
+}
+
+func newCacheTestRepository() *Repository {
+	return NewRepository("cache-test", "http://example.invalid/debian", "cache test", "bookworm", []string{"main"}, []string{"amd64"})
+}
+
+func TestSaveAndLoadMetadata(t *testing.T) {
+	cacheDir := t.TempDir()
+	writeTextCache(t, cacheDir, 10)
+
+	repo := newCacheTestRepository()
+	if _, err := repo.LoadCachedPackages(cacheDir); err != nil {
+		t.Fatalf("failed to load text cache: %v", err)
+	}
+	repo.ReleaseInfo = &ReleaseFile{Suite: "stable", Codename: "bookworm"}
+
+	metadataPath := MetadataCachePath(cacheDir, "bookworm")
+	if err := repo.SaveMetadata(metadataPath); err != nil {
+		t.Fatalf("failed to save metadata: %v", err)
+	}
+
+	loaded := newCacheTestRepository()
+	if err := loaded.LoadMetadata(metadataPath); err != nil {
+		t.Fatalf("failed to load metadata: %v", err)
+	}
+	if len(loaded.PackageMetadata) != 10 || len(loaded.Packages) != 10 {
+		t.Fatalf("expected 10 packages, got %d metadata / %d names", len(loaded.PackageMetadata), len(loaded.Packages))
+	}
+	if loaded.ReleaseInfo == nil || loaded.ReleaseInfo.Codename != "bookworm" {
+		t.Fatalf("release info not restored: %+v", loaded.ReleaseInfo)
+	}
+}
+
+func TestLoadMetadataDetectsChangedTextCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	packagesPath := writeTextCache(t, cacheDir, 3)
+
+	repo := newCacheTestRepository()
+	if _, err := repo.LoadCachedPackages(cacheDir); err != nil {
+		t.Fatalf("failed to load text cache: %v", err)
+	}
+
+	metadataPath := MetadataCachePath(cacheDir, "bookworm")
+	if err := repo.SaveMetadata(metadataPath); err != nil {
+		t.Fatalf("failed to save metadata: %v", err)
+	}
+
+	if err := os.WriteFile(packagesPath, []byte("Package: other\nVersion: 1\n\n"), FilePermission); err != nil {
+		t.Fatalf("unable to rewrite Packages cache: %v", err)
+	}
+
+	err := newCacheTestRepository().LoadMetadata(metadataPath)
+	if !errors.Is(err, ErrMetadataCacheStale) {
+		t.Fatalf("expected ErrMetadataCacheStale, got %v", err)
+	}
+
+	// LoadCachedPackages must fall back to re-parsing the changed text cache.
+	fallback := newCacheTestRepository()
+	names, err := fallback.LoadCachedPackages(cacheDir)
+	if err != nil {
+		t.Fatalf("fallback parse failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != "other" {
+		t.Fatalf("expected re-parsed package list, got %v", names)
+	}
+}
+
+func TestLoadMetadataDetectsAddedTextCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	writeTextCache(t, cacheDir, 3)
+
+	repo := newCacheTestRepository()
+	if _, err := repo.LoadCachedPackages(cacheDir); err != nil {
+		t.Fatalf("failed to load text cache: %v", err)
+	}
+	metadataPath := MetadataCachePath(cacheDir, "bookworm")
+	if err := repo.SaveMetadata(metadataPath); err != nil {
+		t.Fatalf("failed to save metadata: %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(metadataPath)); len(entries) != 2 {
+		t.Fatalf("expected only the metadata cache next to main/, got %v", entries)
+	}
+
+	sourcesDir := filepath.Join(cacheDir, "bookworm", "main", "source")
+	if err := os.MkdirAll(sourcesDir, DirPermission); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sourcesDir, "Sources"), []byte("Package: hello\nVersion: 2.10-3\n\n"), FilePermission); err != nil {
+		t.Fatal(err)
+	}
+	if err := newCacheTestRepository().LoadMetadata(metadataPath); !errors.Is(err, ErrMetadataCacheStale) {
+		t.Fatalf("expected a Sources file written after the cache to make it stale, got %v", err)
+	}
+}
+
+func BenchmarkLoadCachedPackagesText(b *testing.B) {
+	cacheDir := b.TempDir()
+	writeTextCache(b, cacheDir, 20000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := newCacheTestRepository().LoadCachedPackages(cacheDir); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadMetadata(b *testing.B) {
+	cacheDir := b.TempDir()
+	writeTextCache(b, cacheDir, 20000)
+
+	repo := newCacheTestRepository()
+	if _, err := repo.LoadCachedPackages(cacheDir); err != nil {
+		b.Fatal(err)
+	}
+	metadataPath := MetadataCachePath(cacheDir, "bookworm")
+	if err := repo.SaveMetadata(metadataPath); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := newCacheTestRepository().LoadMetadata(metadataPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
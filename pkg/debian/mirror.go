@@ -1,38 +1,173 @@
 package debian
 
 import (
+	"bytes"
+	"cmp"
 	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/ProtonMail/gopenpgp/v3/crypto"
 	"github.com/ulikunitz/xz"
 )
 
+// Errors returned when changing the suites of an existing mirror.
+var (
+	ErrSuiteAlreadyConfigured = errors.New("suite already configured")
+	ErrSuiteNotConfigured     = errors.New("suite not configured")
+)
+
 // Default size estimation values.
 const (
 	defaultAveragePackageSize = 1024 * 1024 // 1MB average package size for estimation
 )
 
+// Default MirrorConfig.MetadataTimeout and PackageTimeout.
+const (
+	defaultMetadataTimeout = 30 * time.Second
+	defaultPackageTimeout  = 5 * time.Minute
+)
+
+// Connection pool of the HTTP client NewMirror sets as Mirror.SharedHTTPClient.
+const (
+	mirrorMaxIdleConnsPerHost = 10
+	mirrorIdleConnTimeout     = 90 * time.Second
+)
+
 // MirrorConfig contains the configuration for a mirror operation.
 type MirrorConfig struct {
-	BaseURL          string        // Repository URL to mirror from
-	Suites           []string      // Distributions to mirror (e.g., bookworm, bullseye)
-	Components       []string      // Components to mirror (e.g., main, contrib, non-free)
-	Architectures    []string      // Architectures to mirror (e.g., amd64, arm64)
-	DownloadPackages bool          // Whether to download .deb package files
-	Verbose          bool          // Enable verbose logging
-	KeyringPaths     []string      // Trusted keyring files for signature verification
-	SkipGPGVerify    bool          // Disable GPG verification when true
-	RateDelay        time.Duration // Delay between HTTP requests for .deb downloads; forces sequential mode when > 0
+	BaseURL          string         // Repository URL to mirror from
+	Suites           []string       // Distributions to mirror (e.g., bookworm, bullseye)
+	Components       []string       // Components to mirror (e.g., main, contrib, non-free)
+	Architectures    []string       // Architectures to mirror (e.g., amd64, arm64)
+	DownloadPackages bool           // Whether to download .deb package files
+	Verbose          bool           // Enable verbose logging
+	KeyringPaths     []string       // Trusted keyring files for signature verification
+	SkipGPGVerify    bool           // Disable GPG verification when true
+	RateDelay        time.Duration  // Delay between HTTP requests for .deb downloads; forces sequential mode when > 0
+	TempDir          string         // Directory for GPG temp files and .partial staging; keep it on the mirror's filesystem
+	Queue            *DownloadQueue // Download scheduler shared with other mirrors; defaults to SharedDownloadQueue()
+	DryRun           bool           // Fetch metadata and build a DownloadPlan (see Mirror.Plan) without writing pool files
+	WriteMetadata    bool           // With DryRun, still write Release and Packages files under dists/
+
+	// Cache is a content-addressed pool file cache shared with other mirrors and
+	// builds; nil disables it.
+	Cache *ObjectCache
+
+	// SuiteOverrides replaces Components and/or Architectures for individual suites,
+	// e.g. only main for bookworm-security. Empty lists fall back to the global ones.
+	SuiteOverrides map[string]SuiteConfig
+
+	// AutoAdjustComponents reconciles each suite's components with its Release file
+	// (see AdjustComponentsToRelease) instead of failing on components it lacks, so
+	// one configuration covers suites before and after the non-free-firmware split.
+	AutoAdjustComponents bool
+
+	// SkipMissing records a warning and moves on when a suite/component/arch index
+	// is not found upstream (HTTP 404 or 410), e.g. riscv64 in sid but not bookworm,
+	// instead of aborting the run. Other failures still abort. See
+	// Mirror.SkippedCombinations.
+	SkipMissing bool
+
+	// PackageList makes a partial mirror: dists/ is still mirrored verbatim, so apt
+	// clients see every package, but only the listed ones are downloaded into pool/
+	// and the others answer 404. Empty mirrors the whole pool.
+	PackageList []PackageSpec
+
+	// PackageListDependencies extends PackageList with its dependency closure (see
+	// Repository.ResolveDependencies), leaving out the relationship kinds set in
+	// PackageListExclude, e.g. "recommends".
+	PackageListDependencies bool
+	PackageListExclude      map[string]bool
+
+	// CompressionPreference sets the order Packages indices are tried in and leaves
+	// out the formats not listed (see Repository.SetCompressionPreference). Empty
+	// uses CompressionExtensions.
+	CompressionPreference []string
+
+	// MirrorAllIndexVariants downloads every variant of a Packages index found
+	// upstream, instead of the first one that downloads, so apt clients can request
+	// any variant the generated Release lists.
+	MirrorAllIndexVariants bool
+
+	// RequireFingerprint pins the mirror to the key with this fingerprint: Release
+	// files not signed with it are rejected (see Repository.RequireSignerFingerprint).
+	RequireFingerprint string
+
+	// IncludeInstaller also mirrors the debian-installer indices of each component
+	// (see InstallerComponent) that the Release file lists, and with DownloadPackages
+	// the udebs they reference, so that netboot installers can use the mirror.
+	IncludeInstaller bool
+
+	// IncludeUncompressed also stores each Packages index uncompressed, for clients
+	// that cannot decompress gzip or xz: when upstream only serves compressed
+	// variants, the mirror decompresses the one it downloaded. The Release file
+	// already lists the uncompressed index. The metadata writers take the same choice
+	// for generated repositories (see WritePackagesMetadataToStorage).
+	IncludeUncompressed bool
+
+	// IncludeDEP11 also mirrors the AppStream metadata of each component listed in
+	// the Release file (see DEP11Pattern), for software centers using the mirror.
+	IncludeDEP11 bool
+
+	// IncludeExtraIndices lists further Release-listed files of each component to
+	// mirror, as paths relative to the component: "cnf/" for every file under a
+	// directory, or a path.Match pattern such as "i18n/Translation-fr*".
+	IncludeExtraIndices []string
+
+	// MirrorAllIndices mirrors every file the Release file of a suite lists, verified
+	// against its checksum and size, rather than only the index types known to the
+	// mirror: Translation, Contents, dep11, cnf... Files under components that are not
+	// mirrored and files naming architectures that are not are left out. IndexInclude,
+	// when set, keeps only the files matching one of its patterns, and IndexExclude
+	// drops those matching one of its own; patterns ending with "/" match a directory
+	// prefix, such as "main/i18n/", patterns with a "/" match the whole path relative
+	// to dists/<suite>, and the others the base name, e.g. "Contents-*".
+	// DownloadPackages remains the switch for pool files.
+	MirrorAllIndices bool
+	IndexInclude     []string
+	IndexExclude     []string
+
+	// MetadataTimeout bounds connecting and waiting for the response headers of the
+	// requests for Release files and indices, so that an unresponsive mirror fails
+	// fast; PackageTimeout does the same for pool files, which large mirrors may be
+	// slow to start serving. They default to 30s and 5min. Response bodies are only
+	// bounded by the idle timeout of the downloaders (see Downloader.IdleTimeout).
+	MetadataTimeout time.Duration
+	PackageTimeout  time.Duration
+
+	// IgnoreLastModified stamps mirrored files with their download time. By default
+	// they keep the Last-Modified time upstream serves them with, so that rsync and
+	// caching proxies downstream only see the files that changed. Pool files placed
+	// from Cache keep the time of the file first stored in it.
+	IgnoreLastModified bool
+
+	// Storage receives the mirrored files, e.g. to publish them to an object store,
+	// instead of leaving them under the base path. The base path then only keeps dists/
+	// as the working copy of the metadata; pool files are downloaded to a staging
+	// directory under the TempDir of the downloader (the system one by default) and
+	// removed from it once stored. When nil, the mirror is written to the base path.
+	Storage Storage
+}
+
+// SuiteConfig holds the per-suite lists of a MirrorConfig.SuiteOverrides entry.
+type SuiteConfig struct {
+	Components    []string // Components to mirror for the suite
+	Architectures []string // Architectures to mirror for the suite
 }
 
 // Validate checks that all required fields are set and valid.
@@ -52,20 +187,143 @@ func (c *MirrorConfig) Validate() error {
 	if !c.hasValidURLScheme() {
 		return fmt.Errorf("BaseURL must start with http:// or https://")
 	}
+	for suite, override := range c.SuiteOverrides {
+		if err := validateOverrideList(suite, "component", override.Components); err != nil {
+			return err
+		}
+		if err := validateOverrideList(suite, "architecture", override.Architectures); err != nil {
+			return err
+		}
+	}
+	if len(c.CompressionPreference) > 0 {
+		if err := validateCompressionPreference(c.CompressionPreference); err != nil {
+			return err
+		}
+	}
+	for _, patterns := range [][]string{c.IncludeExtraIndices, c.IndexInclude, c.IndexExclude} {
+		if err := validateExtraIndexPatterns(patterns); err != nil {
+			return err
+		}
+	}
+	if _, err := NormalizeFingerprint(c.RequireFingerprint); err != nil {
+		return err
+	}
+	if c.RequireFingerprint != "" && c.SkipGPGVerify {
+		return fmt.Errorf("RequireFingerprint needs GPG verification, which SkipGPGVerify disables")
+	}
+	return c.expandHostArchitecture()
+}
+
+// expandHostArchitecture replaces "host" in Architectures and in suite overrides with
+// the Debian architecture of the running machine. "*" and "all-available" are kept
+// until each suite's Release file is fetched.
+func (c *MirrorConfig) expandHostArchitecture() error {
+	architectures, err := expandHostArchitecture(c.Architectures)
+	if err != nil {
+		return err
+	}
+	c.Architectures = architectures
+
+	for suite, override := range c.SuiteOverrides {
+		if override.Architectures, err = expandHostArchitecture(override.Architectures); err != nil {
+			return fmt.Errorf("architecture override for suite %s: %w", suite, err)
+		}
+		c.SuiteOverrides[suite] = override
+	}
+	return nil
+}
+
+// GetComponentsForSuite returns the components to mirror for suite: its override
+// when one is set, the global Components otherwise.
+func (c *MirrorConfig) GetComponentsForSuite(suite string) []string {
+	if override, ok := c.SuiteOverrides[suite]; ok && len(override.Components) > 0 {
+		return override.Components
+	}
+	return c.Components
+}
+
+// GetArchitecturesForSuite returns the architectures to mirror for suite: its
+// override when one is set, the global Architectures otherwise.
+func (c *MirrorConfig) GetArchitecturesForSuite(suite string) []string {
+	if override, ok := c.SuiteOverrides[suite]; ok && len(override.Architectures) > 0 {
+		return override.Architectures
+	}
+	return c.Architectures
+}
+
+// validateOverrideList rejects blank entries in a suite override list.
+func validateOverrideList(suite, kind string, values []string) error {
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("%s override for suite %s contains an empty entry", kind, suite)
+		}
+	}
 	return nil
 }
 
 // hasValidURLScheme checks if the BaseURL has a valid HTTP/HTTPS scheme.
 func (c *MirrorConfig) hasValidURLScheme() bool {
-	return strings.HasPrefix(c.BaseURL, "http://") || strings.HasPrefix(c.BaseURL, "https://")
+	return hasHTTPScheme(c.BaseURL)
+}
+
+// Mirror event types reported to Mirror.ProgressHandler.
+const (
+	MirrorEventStart          = "start"           // Clone or Sync started
+	MirrorEventSuiteStart     = "suite_start"     // A suite is being mirrored
+	MirrorEventComponentStart = "component_start" // A component of a suite is being mirrored
+	MirrorEventArchStart      = "arch_start"      // An architecture of a component is being mirrored
+	MirrorEventMetadata       = "metadata"        // Release or Packages metadata is being fetched
+	MirrorEventFileDownloaded = "file_downloaded" // A file was written; BytesTransferred holds its size
+	MirrorEventFileSkipped    = "file_skipped"    // An existing file already matches its checksum
+	MirrorEventVerify         = "verify"          // Integrity verification progress
+	MirrorEventWarning        = "warning"         // A non-fatal error occurred
+	MirrorEventInfo           = "info"            // Other informational messages
+)
+
+// MirrorEvent describes the progress of a mirror operation.
+type MirrorEvent struct {
+	Type             string
+	Suite            string
+	Component        string
+	Arch             string
+	Message          string
+	BytesTransferred int64
 }
 
 // Mirror handles the creation and management of a local Debian repository mirror.
+// Clone, Sync, AddSuite, RemoveSuite, UpdateConfiguration and VerifyMirrorIntegrity
+// may be called from several goroutines; they run one at a time.
 type Mirror struct {
 	config     MirrorConfig
 	repository *Repository
-	downloader *Downloader
+	downloader *Downloader // Pool files, bounded by PackageTimeout
 	basePath   string
+	plan       *DownloadPlan
+	scheduled  map[string]bool // Pool files already planned or downloaded by the current run
+
+	metadataDownloader *Downloader // Release files and indices, bounded by MetadataTimeout
+
+	suiteArchitectures map[string][]string          // Per-suite architectures with pseudo-values expanded
+	selections         map[string]*packageSelection // Partial mirror pool contents by suite/arch
+	skipped            []string                     // suite/component/arch combinations missing upstream
+	warnings           *WarningCollector            // Shared with repository and downloader
+
+	// ProgressHandler receives mirror events. When nil, event messages are printed
+	// to stdout if Verbose is set. Calls are serialized.
+	ProgressHandler func(event MirrorEvent)
+	eventMu         sync.Mutex
+
+	// SharedHTTPClient carries every request of the mirror, for indices and pool files
+	// alike, so that connections to the upstream are kept alive and reused instead of
+	// reopened for each suite/component/arch. NewMirror sets a client keeping up to 10
+	// idle connections per host for 90s; replace it before Clone to tune the pool, or
+	// set it to nil to use http.DefaultTransport. Only its Transport is used: the
+	// timeout of each request is still that of the downloader.
+	SharedHTTPClient *http.Client
+
+	// opMu serializes the operations that reconfigure the shared repository (SetSuite,
+	// SetComponents...) before fetching with it, which Repository does not synchronize.
+	opMu sync.Mutex
 }
 
 // NewMirror creates a new Mirror instance with the given configuration.
@@ -83,73 +341,313 @@ func NewMirror(config MirrorConfig, basePath string) *Mirror {
 	if config.SkipGPGVerify {
 		repo.DisableSignatureVerification()
 	}
+	repo.TempDir = config.TempDir
+	if len(config.CompressionPreference) > 0 {
+		repo.compression = slices.Clone(config.CompressionPreference)
+	}
+	repo.pinSigner(config.RequireFingerprint)
 
 	downloader := NewDownloader()
 	downloader.RateDelay = config.RateDelay
+	downloader.TempDir = config.TempDir
+	downloader.Queue = config.Queue
+	downloader.Cache = config.Cache
+	if downloader.Queue == nil {
+		downloader.Queue = SharedDownloadQueue()
+	}
+
+	metadataDownloader := NewDownloader()
+	metadataDownloader.TempDir = config.TempDir
+	metadataDownloader.Queue = downloader.Queue
+
+	warnings := NewWarningCollector()
+	repo.Warnings = warnings
+	downloader.Warnings = warnings
+	metadataDownloader.Warnings = warnings
+
+	m := &Mirror{
+		config:             config,
+		repository:         repo,
+		downloader:         downloader,
+		metadataDownloader: metadataDownloader,
+		warnings:           warnings,
+		basePath:           basePath,
+		SharedHTTPClient:   newMirrorHTTPClient(),
+	}
+	m.applyTimeouts()
+	return m
+}
 
-	return &Mirror{
-		config:     config,
-		repository: repo,
-		downloader: downloader,
-		basePath:   basePath,
+// applyTimeouts sets MetadataTimeout and PackageTimeout, or their defaults, and
+// IgnoreLastModified on the repository and downloaders of m.
+func (m *Mirror) applyTimeouts() {
+	metadataTimeout := m.config.MetadataTimeout
+	if metadataTimeout <= 0 {
+		metadataTimeout = defaultMetadataTimeout
 	}
+	packageTimeout := m.config.PackageTimeout
+	if packageTimeout <= 0 {
+		packageTimeout = defaultPackageTimeout
+	}
+
+	m.repository.MetadataTimeout = metadataTimeout
+	m.repository.PackageTimeout = packageTimeout
+	m.metadataDownloader.Timeout = metadataTimeout
+	m.downloader.Timeout = packageTimeout
+	m.metadataDownloader.PreserveTimestamps = !m.config.IgnoreLastModified
+	m.downloader.PreserveTimestamps = !m.config.IgnoreLastModified
+}
+
+// newMirrorHTTPClient returns a client on a copy of http.DefaultTransport keeping more
+// idle connections per host, as the download workers of a mirror all target one.
+func newMirrorHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = mirrorMaxIdleConnsPerHost
+	transport.IdleConnTimeout = mirrorIdleConnTimeout
+	return &http.Client{Transport: transport}
+}
+
+// sharedTransport returns the transport of SharedHTTPClient, nil for the default one.
+func (m *Mirror) sharedTransport() http.RoundTripper {
+	if m.SharedHTTPClient == nil {
+		return nil
+	}
+	return m.SharedHTTPClient.Transport
+}
+
+// useSharedHTTPClient routes the requests of the repository and downloader of m
+// through SharedHTTPClient, which may have been replaced since NewMirror.
+func (m *Mirror) useSharedHTTPClient() {
+	m.repository.transport = m.sharedTransport()
+	m.downloader.transport = m.sharedTransport()
+	m.metadataDownloader.transport = m.sharedTransport()
 }
 
 // Clone creates a complete mirror of the configured repository.
 // It downloads Release files, Packages metadata, and optionally package files.
 func (m *Mirror) Clone() error {
-	m.logVerbose("Starting mirror of %s to %s\n", m.config.BaseURL, m.basePath)
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	m.useSharedHTTPClient()
+	m.emit(MirrorEvent{Type: MirrorEventStart, Message: fmt.Sprintf("Starting mirror of %s to %s", m.config.BaseURL, m.basePath)})
 
 	if err := os.MkdirAll(m.basePath, DirPermission); err != nil {
 		return fmt.Errorf("failed to create base directory: %w", err)
 	}
 
+	m.plan = nil
+	m.scheduled = make(map[string]bool)
+	m.selections = nil
+	m.skipped = nil
+	if m.config.DryRun {
+		m.plan = &DownloadPlan{}
+	}
+
 	for _, suite := range m.config.Suites {
 		if err := m.mirrorSuite(suite); err != nil {
 			return fmt.Errorf("failed to mirror suite %s: %w", suite, err)
 		}
 	}
 
+	if len(m.skipped) > 0 {
+		m.emit(MirrorEvent{Type: MirrorEventInfo, Message: fmt.Sprintf("Skipped %d combination(s) missing upstream: %s", len(m.skipped), strings.Join(m.skipped, ", "))})
+	}
+
 	return nil
 }
 
+// SkippedCombinations returns the suite/component/arch combinations the last Clone or
+// Sync skipped because their index was missing upstream (see MirrorConfig.SkipMissing).
+func (m *Mirror) SkippedCombinations() []string {
+	return slices.Clone(m.skipped)
+}
+
 // Sync performs an incremental synchronization of the mirror.
-// Currently equivalent to Clone; future versions will compare checksums
-// and only download changed files.
+// Currently equivalent to Clone; the files mirrored from the Release file list (see
+// MirrorConfig.MirrorAllIndices and IncludeExtraIndices) that already match their
+// Release checksum are kept, and future versions will compare pool files too.
 func (m *Mirror) Sync() error {
-	m.logVerbose("Synchronizing mirror of %s\n", m.config.BaseURL)
+	m.emit(MirrorEvent{Type: MirrorEventStart, Message: fmt.Sprintf("Synchronizing mirror of %s", m.config.BaseURL)})
 	return m.Clone()
 }
 
+// AddSuite adds a suite to an existing mirror and mirrors it without touching
+// the suites already present. The suite is validated against the remote Release
+// file before the configuration is changed.
+func (m *Mirror) AddSuite(suite string) error {
+	suite = strings.TrimSpace(suite)
+	if suite == "" {
+		return fmt.Errorf("suite name is required")
+	}
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	if slices.Contains(m.config.Suites, suite) {
+		return fmt.Errorf("%w: %s", ErrSuiteAlreadyConfigured, suite)
+	}
+
+	m.useSharedHTTPClient()
+	m.repository.SetSuite(suite)
+	if err := m.repository.FetchReleaseFile(); err != nil {
+		return fmt.Errorf("suite %s not available on %s: %w", suite, m.config.BaseURL, err)
+	}
+
+	if err := os.MkdirAll(m.basePath, DirPermission); err != nil {
+		return fmt.Errorf("failed to create base directory: %w", err)
+	}
+
+	// The list may share its backing array with the caller's MirrorConfig
+	previous := m.config.Suites
+	m.config.Suites = append(slices.Clone(previous), suite)
+	if err := m.mirrorSuite(suite); err != nil {
+		m.config.Suites = previous
+		return fmt.Errorf("failed to mirror suite %s: %w", suite, err)
+	}
+
+	return nil
+}
+
+// RemoveSuite removes a suite from the mirror configuration. When deleteFiles is
+// true, the suite's dists/<suite> directory is removed as well; pool files are
+// kept since they may be shared with other suites.
+func (m *Mirror) RemoveSuite(suite string, deleteFiles bool) error {
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	index := slices.Index(m.config.Suites, suite)
+	if index == -1 {
+		return fmt.Errorf("%w: %s", ErrSuiteNotConfigured, suite)
+	}
+
+	m.config.Suites = slices.Delete(slices.Clone(m.config.Suites), index, index+1)
+
+	if deleteFiles {
+		if err := os.RemoveAll(m.buildSuitePath(suite)); err != nil {
+			return fmt.Errorf("failed to delete files for suite %s: %w", suite, err)
+		}
+	}
+
+	return nil
+}
+
 // mirrorSuite mirrors all components and architectures for a given suite.
 func (m *Mirror) mirrorSuite(suite string) error {
-	m.logVerbose("Mirroring suite: %s\n", suite)
+	m.emit(MirrorEvent{Type: MirrorEventSuiteStart, Suite: suite, Message: fmt.Sprintf("Mirroring suite: %s", suite)})
 
 	m.repository.SetSuite(suite)
 
-	suitePath := m.buildSuitePath(suite)
-	if err := os.MkdirAll(suitePath, DirPermission); err != nil {
-		return fmt.Errorf("failed to create suite directory: %w", err)
+	if m.writesMetadata() {
+		suitePath := m.buildSuitePath(suite)
+		if err := os.MkdirAll(suitePath, DirPermission); err != nil {
+			return fmt.Errorf("failed to create suite directory: %w", err)
+		}
+
+		if err := m.downloadReleaseFile(suite); err != nil {
+			return fmt.Errorf("failed to download Release file: %w", err)
+		}
+	} else if err := m.repository.FetchReleaseFile(); err != nil {
+		return fmt.Errorf("failed to fetch Release file: %w", err)
+	}
+	m.emitReleaseDate(suite)
+	if err := m.resolveSuiteArchitectures(suite); err != nil {
+		return err
 	}
 
-	if err := m.downloadReleaseFile(suite); err != nil {
-		return fmt.Errorf("failed to download Release file: %w", err)
+	components, err := m.releaseComponentsForSuite(suite, true)
+	if err != nil {
+		return err
 	}
 
-	for _, component := range m.config.Components {
+	for _, component := range components {
 		if err := m.mirrorComponent(suite, component); err != nil {
 			return fmt.Errorf("failed to mirror component %s: %w", component, err)
 		}
 	}
 
+	if m.writesMetadata() {
+		return m.mirrorReleaseIndices(suite, components)
+	}
 	return nil
 }
 
+// resolveSuiteArchitectures expands "*", "all-available" and "host" in the suite's
+// architectures against its loaded Release file. The result is returned by
+// ArchitecturesForSuite and reported as a metadata event.
+func (m *Mirror) resolveSuiteArchitectures(suite string) error {
+	configured := m.config.GetArchitecturesForSuite(suite)
+	var available []string
+	if release := m.repository.GetReleaseInfo(); release != nil {
+		available = release.Architectures
+	}
+
+	expanded, err := ExpandArchitectures(configured, available)
+	if err != nil {
+		return fmt.Errorf("suite %s: %w", suite, err)
+	}
+	if slices.Equal(expanded, configured) {
+		delete(m.suiteArchitectures, suite)
+		return nil
+	}
+
+	if m.suiteArchitectures == nil {
+		m.suiteArchitectures = make(map[string][]string)
+	}
+	m.suiteArchitectures[suite] = expanded
+	m.emit(MirrorEvent{Type: MirrorEventMetadata, Suite: suite, Message: fmt.Sprintf("Architectures for suite %s: %s", suite, strings.Join(expanded, ", "))})
+	return nil
+}
+
+// ArchitecturesForSuite returns the architectures mirrored for suite: those of
+// MirrorConfig.GetArchitecturesForSuite, with "*", "all-available" and "host"
+// expanded once the suite's Release file has been fetched.
+func (m *Mirror) ArchitecturesForSuite(suite string) []string {
+	if expanded, ok := m.suiteArchitectures[suite]; ok {
+		return expanded
+	}
+	return m.config.GetArchitecturesForSuite(suite)
+}
+
+// emitReleaseDate reports the date of the suite's Release metadata and its age.
+func (m *Mirror) emitReleaseDate(suite string) {
+	release := m.repository.GetReleaseInfo()
+	if release == nil || release.ParsedDate.IsZero() {
+		return
+	}
+	m.emit(MirrorEvent{Type: MirrorEventMetadata, Suite: suite, Message: fmt.Sprintf("Release metadata for suite %s dated %s (%s old)", suite, release.ParsedDate.Format(time.RFC1123), release.Age().Round(time.Minute))})
+}
+
+// releaseComponentsForSuite returns the components to mirror for suite once its Release
+// file is loaded, adjusted to it when AutoAdjustComponents is set. With warn, dropped
+// and added components are reported as warning events.
+func (m *Mirror) releaseComponentsForSuite(suite string, warn bool) ([]string, error) {
+	components := m.config.GetComponentsForSuite(suite)
+	available := m.repository.ReleaseComponents()
+	if !m.config.AutoAdjustComponents || len(available) == 0 {
+		return components, nil
+	}
+
+	kept, dropped, added := AdjustComponentsToRelease(components, available)
+	if len(kept) == 0 {
+		return nil, &ReleaseMismatchError{UnknownComponents: dropped, AvailableComponents: available}
+	}
+	if warn {
+		for _, component := range dropped {
+			m.warn(WarningReleaseMismatch, component, MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Message: fmt.Sprintf("Warning: component %s is not available in suite %s, skipping it", component, suite)})
+		}
+		for _, component := range added {
+			m.warn(WarningReleaseMismatch, component, MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Message: fmt.Sprintf("Warning: suite %s splits firmware into %s, adding it", suite, component)})
+		}
+	}
+
+	return kept, nil
+}
+
 // downloadReleaseFile fetches and saves the Release file for a suite.
 func (m *Mirror) downloadReleaseFile(suite string) error {
 	releasePath := filepath.Join(m.buildSuitePath(suite), "Release")
 
-	m.logVerbose("Downloading Release file for suite: %s\n", suite)
+	m.emit(MirrorEvent{Type: MirrorEventMetadata, Suite: suite, Message: fmt.Sprintf("Downloading Release file for suite: %s", suite)})
 
 	m.repository.SetSuite(suite)
 
@@ -167,12 +665,18 @@ func (m *Mirror) downloadReleaseFile(suite string) error {
 	if err := os.WriteFile(releasePath, []byte(releaseContent), FilePermission); err != nil {
 		return fmt.Errorf("failed to write Release file: %w", err)
 	}
+	m.emitFileDownloaded(suite, "", "", releasePath)
+	if err := m.publish(releasePath); err != nil {
+		return err
+	}
 
 	if err := m.downloadInReleaseFile(suite); err != nil {
-		m.logVerbose("Warning: failed to fetch InRelease for %s: %v\n", suite, err)
+		m.warn(WarningFileFailed, "InRelease", MirrorEvent{Type: MirrorEventWarning, Suite: suite, Message: fmt.Sprintf("Warning: failed to fetch InRelease for %s: %v", suite, err)})
+		return nil
 	}
-
-	return nil
+	inReleasePath := filepath.Join(m.buildSuitePath(suite), "InRelease")
+	m.emitFileDownloaded(suite, "", "", inReleasePath)
+	return m.publish(inReleasePath)
 }
 
 func (m *Mirror) downloadInReleaseFile(suite string) error {
@@ -185,11 +689,12 @@ func (m *Mirror) downloadInReleaseFile(suite string) error {
 		Filename:    "InRelease",
 	}
 
-	if m.config.Verbose {
-		return m.downloader.DownloadWithProgress(tempPkg, inReleasePath, nil)
-	}
-
-	return m.downloader.DownloadSilent(tempPkg, inReleasePath)
+	return m.metadataDownloader.Queue.Do(PriorityMetadata, tempPkg.Filename, func() error {
+		if m.config.Verbose {
+			return m.metadataDownloader.DownloadWithProgress(tempPkg, inReleasePath, nil)
+		}
+		return m.metadataDownloader.DownloadSilent(tempPkg, inReleasePath)
+	})
 }
 
 // buildReleaseFileContent generates the content for a Release file.
@@ -233,31 +738,53 @@ func (m *Mirror) writeChecksumSection(content *strings.Builder, sectionName stri
 
 // mirrorComponent mirrors all architectures for a given suite and component.
 func (m *Mirror) mirrorComponent(suite, component string) error {
-	m.logVerbose("Mirroring component: %s/%s\n", suite, component)
+	m.emit(MirrorEvent{Type: MirrorEventComponentStart, Suite: suite, Component: component, Message: fmt.Sprintf("Mirroring component: %s/%s", suite, component)})
 
-	for _, arch := range m.config.Architectures {
-		if err := m.mirrorArchitecture(suite, component, arch); err != nil {
+	for _, arch := range m.ArchitecturesForSuite(suite) {
+		err := m.mirrorArchitecture(suite, component, arch)
+		if err != nil && m.config.SkipMissing && errors.Is(err, ErrNotFound) {
+			m.skipMissingCombination(suite, component, arch, err)
+			continue
+		}
+		if err != nil {
 			return fmt.Errorf("failed to mirror architecture %s: %w", arch, err)
 		}
 	}
 
+	if m.config.IncludeInstaller {
+		if err := m.mirrorInstallerIndices(suite, component); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// skipMissingCombination records that the index of suite, component and arch is
+// missing upstream and removes the directory created for it.
+func (m *Mirror) skipMissingCombination(suite, component, arch string, err error) {
+	combination := suite + "/" + component + "/" + arch
+	m.skipped = append(m.skipped, combination)
+	os.Remove(m.buildArchPath(suite, component, arch)) // Only succeeds while it is empty
+
+	m.warn(WarningMissingIndex, combination, MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Warning: %s is not available upstream, skipping it: %v", combination, err)})
+}
+
 // mirrorArchitecture mirrors the Packages file and optionally packages for an architecture.
 func (m *Mirror) mirrorArchitecture(suite, component, arch string) error {
-	m.logVerbose("Mirroring architecture: %s/%s/%s\n", suite, component, arch)
+	m.emit(MirrorEvent{Type: MirrorEventArchStart, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Mirroring architecture: %s/%s/%s", suite, component, arch)})
 
 	// Limit repository parsing to the current architecture to avoid extra work on each iteration.
 	m.repository.SetArchitectures([]string{arch})
 
-	archPath := m.buildArchPath(suite, component, arch)
-	if err := os.MkdirAll(archPath, DirPermission); err != nil {
-		return fmt.Errorf("failed to create architecture directory: %w", err)
-	}
+	if m.writesMetadata() {
+		archPath := m.buildArchPath(suite, component, arch)
+		if err := os.MkdirAll(archPath, DirPermission); err != nil {
+			return fmt.Errorf("failed to create architecture directory: %w", err)
+		}
 
-	if err := m.downloadPackagesFile(suite, component, arch); err != nil {
-		return fmt.Errorf("failed to download Packages file: %w", err)
+		if err := m.downloadPackagesFile(suite, component, arch); err != nil {
+			return fmt.Errorf("failed to download Packages file: %w", err)
+		}
 	}
 
 	// Always load package metadata, even if not downloading packages
@@ -275,30 +802,74 @@ func (m *Mirror) mirrorArchitecture(suite, component, arch string) error {
 }
 
 // downloadPackagesFile downloads the Packages file for a suite/component/arch combination.
-// Tries multiple compression extensions in order: .gz, .xz, uncompressed.
+// Tries the compression extensions of the repository in order and stops at the first
+// that downloads, unless MirrorAllIndexVariants is set: every variant found upstream
+// is then downloaded, and other failures are only warned about once one succeeded.
 func (m *Mirror) downloadPackagesFile(suite, component, arch string) error {
 	baseURL := m.buildPackagesBaseURL(suite, component, arch)
 	packagesDir := m.buildArchPath(suite, component, arch)
 
-	var lastErr error
-	for _, ext := range CompressionExtensions {
-		if err := m.tryDownloadPackagesFile(baseURL, packagesDir, ext); err != nil {
-			lastErr = err
+	failures := &IndexFetchError{}
+	var downloaded []string // Extensions of the variants downloaded
+	for _, ext := range m.repository.compressionExtensions() {
+		if err := m.tryDownloadPackagesFile(suite, component, arch, baseURL, packagesDir, ext); err != nil {
+			failures.add(baseURL+ext, err)
 			continue
 		}
-		return nil
+		downloaded = append(downloaded, ext)
+		if !m.config.MirrorAllIndexVariants {
+			break
+		}
 	}
 
-	return fmt.Errorf("failed to download Packages file with any extension: %w", lastErr)
+	if len(downloaded) == 0 {
+		return fmt.Errorf("failed to download Packages file with any extension: %w", failures)
+	}
+	if m.config.MirrorAllIndexVariants {
+		for _, attempt := range failures.Attempts {
+			if !errors.Is(attempt.Err, ErrNotFound) {
+				m.warn(WarningFileFailed, attempt.URL, MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Warning: unable to mirror %s: %v", attempt.URL, attempt.Err)})
+			}
+		}
+	}
+	if m.config.IncludeUncompressed && !slices.Contains(downloaded, "") {
+		return m.writeUncompressedPackages(packagesDir, downloaded[0])
+	}
+	return nil
+}
+
+// writeUncompressedPackages decompresses the Packages index mirrored in dir with the
+// ext compression into the uncompressed Packages (see MirrorConfig.IncludeUncompressed).
+func (m *Mirror) writeUncompressedPackages(dir, ext string) error {
+	file, err := os.Open(filepath.Join(dir, "Packages"+ext))
+	if err != nil {
+		return fmt.Errorf("unable to open Packages%s: %w", ext, err)
+	}
+	defer file.Close()
+
+	reader, cleanup, err := m.repository.createDecompressor(file, ext)
+	if err != nil {
+		return fmt.Errorf("unable to decompress Packages%s: %w", ext, err)
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+
+	// LocalStorage only replaces the previous file once the whole index is written
+	plainPath := filepath.Join(dir, "Packages")
+	if err := NewLocalStorage(dir).Put("Packages", reader, -1, FilePermission); err != nil {
+		return fmt.Errorf("unable to write %s: %w", plainPath, err)
+	}
+	return m.publish(plainPath)
 }
 
 // tryDownloadPackagesFile attempts to download a Packages file with a specific extension.
-func (m *Mirror) tryDownloadPackagesFile(baseURL, packagesDir, ext string) error {
+func (m *Mirror) tryDownloadPackagesFile(suite, component, arch, baseURL, packagesDir, ext string) error {
 	packagesURL := baseURL + ext
 	filename := "Packages" + ext
 	packagesPath := filepath.Join(packagesDir, filename)
 
-	m.logVerbose("Trying to download Packages file: %s\n", packagesURL)
+	m.emit(MirrorEvent{Type: MirrorEventMetadata, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Trying to download Packages file: %s", packagesURL)})
 
 	tempPkg := &Package{
 		Name:        "packages-file",
@@ -306,70 +877,132 @@ func (m *Mirror) tryDownloadPackagesFile(baseURL, packagesDir, ext string) error
 		Filename:    filename,
 	}
 
-	var err error
-	if m.config.Verbose {
-		err = m.downloader.DownloadWithProgress(tempPkg, packagesPath, nil)
-	} else {
-		err = m.downloader.DownloadSilent(tempPkg, packagesPath)
-	}
+	err := m.metadataDownloader.Queue.Do(PriorityMetadata, filename, func() error {
+		if m.config.Verbose {
+			return m.metadataDownloader.DownloadWithProgress(tempPkg, packagesPath, nil)
+		}
+		return m.metadataDownloader.DownloadSilent(tempPkg, packagesPath)
+	})
 
 	if err != nil {
-		m.logVerbose("Failed to download %s: %v\n", filename, err)
+		m.emit(MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Failed to download %s: %v", filename, err)})
 		return err
 	}
 
-	m.logVerbose("Successfully downloaded: %s\n", filename)
-	return nil
+	m.emitFileDownloaded(suite, component, arch, packagesPath)
+	return m.publish(packagesPath)
 }
 
 // downloadPackagesForArch downloads all packages for a specific architecture.
 func (m *Mirror) downloadPackagesForArch(suite, component, arch string) error {
-	m.logVerbose("Downloading packages for %s/%s/%s\n", suite, component, arch)
+	m.emit(MirrorEvent{Type: MirrorEventInfo, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Downloading packages for %s/%s/%s", suite, component, arch)})
 
-	m.repository.SetSuite(suite)
-	m.repository.SetComponents([]string{component})
-	m.repository.SetArchitectures([]string{arch})
+	selection, err := m.packageSelection(suite, arch, m.remoteSuiteMetadata(suite, arch))
+	if err != nil {
+		return err
+	}
 
-	packages, err := m.repository.FetchPackages()
+	packages, err := m.fetchComponentPackages(suite, component, arch)
 	if err != nil {
 		return fmt.Errorf("failed to get packages list: %w", err)
 	}
+	poolComponent := component
+	if base, ok := installerBaseComponent(component); ok {
+		poolComponent = base
+	}
 
-	poolPath := filepath.Join(m.basePath, "pool", component)
-	if err := os.MkdirAll(poolPath, DirPermission); err != nil {
-		return fmt.Errorf("failed to create pool directory: %w", err)
+	if m.scheduled == nil {
+		m.scheduled = make(map[string]bool)
 	}
 
 	packagesToDownload := make([]*Package, 0, len(packages))
 	for _, packageName := range packages {
-		pkg := m.preparePackageForDownload(packageName, component, arch)
-		if pkg == nil {
+		pkg := m.preparePackageForDownload(packageName, poolComponent, arch)
+		if pkg == nil || !selection.includes(pkg) {
+			continue
+		}
+
+		// The same pool file may be listed by several components; fetch it once.
+		if m.scheduled[pkg.Filename] {
 			continue
 		}
+		m.scheduled[pkg.Filename] = true
 
 		destPath := filepath.Join(m.basePath, filepath.FromSlash(pkg.Filename))
-		skip, err := m.downloader.ShouldSkipDownload(pkg, destPath)
+		var skip bool
+		if m.config.Storage != nil {
+			skip, err = m.downloader.ShouldSkipDownloadInStorage(m.config.Storage, pkg, pkg.Filename)
+		} else {
+			skip, err = m.downloader.ShouldSkipDownload(pkg, destPath)
+		}
 		if err != nil {
-			m.logVerbose("Warning: unable to check existing file for %s: %v\n", pkg.Name, err)
+			m.warn(WarningFileFailed, destPath, MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Warning: unable to check existing file for %s: %v", pkg.Name, err)})
+		}
+		if m.plan != nil {
+			m.plan.Add(PlanEntry{URL: pkg.DownloadURL, DestPath: destPath, Size: pkg.Size, Skip: skip})
+			continue
 		}
 		if skip {
-			m.logVerbose("Skipping download for %s (existing file matches checksum)\n", pkg.Name)
+			m.emit(MirrorEvent{Type: MirrorEventFileSkipped, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Skipping download for %s (existing file matches checksum)", pkg.Name)})
 			continue
 		}
 
 		packagesToDownload = append(packagesToDownload, pkg)
 	}
 
+	if m.plan != nil {
+		return nil
+	}
+
+	if m.config.Storage == nil {
+		poolPath := filepath.Join(m.basePath, "pool", poolComponent)
+		if err := os.MkdirAll(poolPath, DirPermission); err != nil {
+			return fmt.Errorf("failed to create pool directory: %w", err)
+		}
+	}
+
 	if len(packagesToDownload) == 0 {
 		return nil
 	}
 
-	errs := m.downloader.DownloadMultiple(packagesToDownload, m.basePath, 0)
+	// With a Storage, pool files are staged outside of the base path and only put
+	// in the Storage, never written under the base path.
+	destDir := m.basePath
+	if m.config.Storage != nil {
+		staging, err := os.MkdirTemp(m.downloader.TempDir, "deb-for-all-pool-")
+		if err != nil {
+			return fmt.Errorf("failed to create pool staging directory: %w", err)
+		}
+		defer os.RemoveAll(staging)
+		destDir = staging
+	}
+
+	var (
+		publishMu  sync.Mutex
+		publishErr error
+	)
+	errs := m.downloader.downloadBatch(packagesToDownload, destDir, 0, func(pkg *Package, destPath string, err error) {
+		if err != nil {
+			return
+		}
+		m.emitFileDownloaded(suite, component, arch, destPath)
+		if m.config.Storage == nil {
+			return
+		}
+		err = m.putFile(pkg.Filename, destPath)
+		os.Remove(destPath)
+		if err != nil {
+			publishMu.Lock()
+			publishErr = cmp.Or(publishErr, err)
+			publishMu.Unlock()
+		}
+	})
+	// Failed downloads are already recorded as warnings by the downloader
 	for _, dlErr := range errs {
-		m.logVerbose("Warning: %v\n", dlErr)
+		m.emit(MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Warning: %v", dlErr)})
 	}
 
-	return nil
+	return publishErr
 }
 
 // preparePackageForDownload ensures package metadata and paths are ready for parallel download.
@@ -385,54 +1018,86 @@ func (m *Mirror) preparePackageForDownload(packageName, component, arch string)
 
 	sourceName := pkg.GetSourceName()
 	poolPrefix := getPoolPrefix(sourceName)
-
-	fileName := filepath.Base(pkg.Filename)
-	if fileName == "" {
-		fileName = fmt.Sprintf("%s_%s.deb", pkg.Name, arch)
+	if poolPrefix == "" {
+		m.warn(WarningMalformedMetadata, packageName, MirrorEvent{Type: MirrorEventWarning, Component: component, Arch: arch, Message: fmt.Sprintf("Warning: skipping package %q without a name", packageName)})
+		return nil
 	}
 
-	if pkg.Filename == "" || !strings.HasPrefix(pkg.Filename, "pool/") {
-		pkg.Filename = filepath.ToSlash(filepath.Join("pool", component, poolPrefix, sourceName, fileName))
+	if pkg.Filename != "" && !strings.HasPrefix(pkg.Filename, "pool/") {
+		m.warn(WarningFallbackURL, pkg.Name, MirrorEvent{Type: MirrorEventWarning, Component: component, Arch: arch, Message: fmt.Sprintf("Warning: %s has no pool Filename (%q), deriving its pool path from the source name", pkg.Name, pkg.Filename)})
 	}
+	pkg.Filename = mirrorPoolFilename(pkg, component, arch)
 
 	if pkg.DownloadURL == "" {
-		pkg.DownloadURL = fmt.Sprintf("%s/%s", strings.TrimSuffix(m.config.BaseURL, "/"), pkg.Filename)
+		pkg.DownloadURL = PoolURL(m.config.BaseURL, pkg.Filename)
 	}
 
 	return pkg
 }
 
+// mirrorPoolFilename returns where the mirror stores pkg relative to its root: the
+// index Filename when it lies under pool/, otherwise a pool path derived from the
+// source name.
+func mirrorPoolFilename(pkg *Package, component, arch string) string {
+	if strings.HasPrefix(pkg.Filename, "pool/") {
+		return pkg.Filename
+	}
+
+	fileName := filepath.Base(pkg.Filename)
+	if pkg.Filename == "" {
+		fileName = fmt.Sprintf("%s_%s.deb", pkg.Name, arch)
+	}
+
+	sourceName := pkg.GetSourceName()
+	return filepath.ToSlash(filepath.Join("pool", component, getPoolPrefix(sourceName), sourceName, fileName))
+}
+
 // getPackageMetadataOrFallback tries to get package metadata from repository,
 // falling back to a constructed Package if not available.
 func (m *Mirror) getPackageMetadataOrFallback(packageName, arch string) *Package {
 	if m.repository != nil {
 		if packageMetadata, err := m.repository.GetPackageMetadata(packageName); err == nil {
-			m.logVerbose("Using repository metadata for package: %s (source: %s)\n", packageName, packageMetadata.GetSourceName())
+			m.emit(MirrorEvent{Type: MirrorEventInfo, Arch: arch, Message: fmt.Sprintf("Using repository metadata for package: %s (source: %s)", packageName, packageMetadata.GetSourceName())})
 			return packageMetadata
 		}
 	}
 
-	m.logVerbose("No metadata available, using fallback for package: %s\n", packageName)
+	m.warn(WarningFallbackURL, packageName, MirrorEvent{Type: MirrorEventInfo, Arch: arch, Message: fmt.Sprintf("No metadata available, using fallback for package: %s", packageName)})
 	return &Package{
 		Name:         packageName,
 		Architecture: arch,
-		Source:       packageName,
-		Filename:     fmt.Sprintf("%s_%s.deb", packageName, arch),
+		Source:       packageName, // mirrorPoolFilename names the file <name>_<arch>.deb
 	}
 }
 
-// GetMirrorInfo returns the mirror configuration as a map.
+// GetMirrorInfo returns the mirror configuration as a map. Once Clone or
+// VerifyMirrorIntegrity has expanded "*", "all-available" or "host" for a suite, the
+// resulting lists are included under "expanded_architectures".
 func (m *Mirror) GetMirrorInfo() map[string]any {
-	return map[string]any{
-		"base_url":          m.config.BaseURL,
-		"base_path":         m.basePath,
-		"suites":            m.config.Suites,
-		"components":        m.config.Components,
-		"architectures":     m.config.Architectures,
-		"download_packages": m.config.DownloadPackages,
-		"keyrings":          m.config.KeyringPaths,
-		"skip_gpg_verify":   m.config.SkipGPGVerify,
-	}
+	info := map[string]any{
+		"base_url":               m.config.BaseURL,
+		"base_path":              m.basePath,
+		"suites":                 m.config.Suites,
+		"components":             m.config.Components,
+		"architectures":          m.config.Architectures,
+		"suite_overrides":        m.config.SuiteOverrides,
+		"auto_adjust_components": m.config.AutoAdjustComponents,
+		"skip_missing":           m.config.SkipMissing,
+		"download_packages":      m.config.DownloadPackages,
+		"keyrings":               m.config.KeyringPaths,
+		"skip_gpg_verify":        m.config.SkipGPGVerify,
+		"require_fingerprint":    m.config.RequireFingerprint,
+		"include_installer":      m.config.IncludeInstaller,
+		"include_dep11":          m.config.IncludeDEP11,
+		"extra_indices":          m.config.IncludeExtraIndices,
+		"mirror_all_indices":     m.config.MirrorAllIndices,
+		"index_include":          m.config.IndexInclude,
+		"index_exclude":          m.config.IndexExclude,
+	}
+	if len(m.suiteArchitectures) > 0 {
+		info["expanded_architectures"] = m.suiteArchitectures
+	}
+	return info
 }
 
 // EstimateMirrorSize estimates the total size of packages to download.
@@ -451,23 +1116,35 @@ func (m *Mirror) EstimateMirrorSize() (int64, error) {
 		m.config.Components,
 		m.config.Architectures,
 	)
+	tempRepo.transport = m.sharedTransport()
 
 	for _, suite := range m.config.Suites {
 		tempRepo.SetSuite(suite)
+		tempRepo.SetComponents(m.config.GetComponentsForSuite(suite))
+		tempRepo.SetArchitectures(m.config.GetArchitecturesForSuite(suite))
 
 		packages, err := tempRepo.FetchPackages()
 		if err != nil {
 			return 0, fmt.Errorf("failed to get packages for size estimation: %w", err)
 		}
-
 		totalSize += int64(len(packages)) * defaultAveragePackageSize
+
+		if m.config.IncludeInstaller {
+			udebs, err := tempRepo.FetchInstallerPackages()
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				return 0, fmt.Errorf("failed to get installer packages for size estimation: %w", err)
+			}
+			totalSize += int64(len(udebs)) * defaultAveragePackageSize
+		}
 	}
 
 	return totalSize, nil
 }
 
 // GetMirrorStatus returns the current status of the mirror including
-// existence, file count, and total size.
+// existence, file count, total size, the number and size of the .deb files under
+// "package_count" and "package_bytes", the size of dists/ under "metadata_bytes",
+// and the date of each suite's local Release metadata under "metadata_dates".
 func (m *Mirror) GetMirrorStatus() (map[string]any, error) {
 	status := make(map[string]any)
 
@@ -489,9 +1166,43 @@ func (m *Mirror) GetMirrorStatus() (map[string]any, error) {
 	status["total_size"] = totalSize
 	status["initialized"] = fileCount > 0
 
+	packageCount, packageBytes, err := m.poolPackageStats()
+	if err != nil {
+		return status, fmt.Errorf("failed to calculate mirror status: %w", err)
+	}
+	metadataBytes, err := m.GetMetadataSize()
+	if err != nil {
+		return status, fmt.Errorf("failed to calculate mirror status: %w", err)
+	}
+	status["package_count"] = packageCount
+	status["package_bytes"] = packageBytes
+	status["metadata_bytes"] = metadataBytes
+
+	if dates := m.localMetadataDates(); len(dates) > 0 {
+		status["metadata_dates"] = dates
+	}
+
 	return status, nil
 }
 
+// localMetadataDates returns the Date of each configured suite's local Release file,
+// skipping suites without one or with an unparseable date.
+func (m *Mirror) localMetadataDates() map[string]time.Time {
+	dates := make(map[string]time.Time)
+	for _, suite := range m.config.Suites {
+		data, err := os.ReadFile(filepath.Join(m.buildSuitePath(suite), "Release"))
+		if err != nil {
+			continue
+		}
+		release, err := m.repository.parseReleaseFile(string(data))
+		if err != nil || release.ParsedDate.IsZero() {
+			continue
+		}
+		dates[suite] = release.ParsedDate
+	}
+	return dates
+}
+
 // calculateMirrorStats walks the mirror directory and returns file count and total size.
 func (m *Mirror) calculateMirrorStats() (fileCount int, totalSize int64, err error) {
 	err = filepath.Walk(m.basePath, func(path string, info os.FileInfo, walkErr error) error {
@@ -507,6 +1218,58 @@ func (m *Mirror) calculateMirrorStats() (fileCount int, totalSize int64, err err
 	return
 }
 
+// GetDownloadedPackageCount returns the number of .deb files under pool/ in the
+// mirror, 0 before any download.
+func (m *Mirror) GetDownloadedPackageCount() (int, error) {
+	count, _, err := m.poolPackageStats()
+	return count, err
+}
+
+// GetTotalDownloadedSize returns the total size in bytes of the .deb files under pool/
+// in the mirror.
+func (m *Mirror) GetTotalDownloadedSize() (int64, error) {
+	_, size, err := m.poolPackageStats()
+	return size, err
+}
+
+// GetMetadataSize returns the total size in bytes of the files under dists/ in the
+// mirror: Release files and indices of every suite.
+func (m *Mirror) GetMetadataSize() (int64, error) {
+	_, size, err := sumFileSizes(filepath.Join(m.basePath, "dists"), func(string) bool { return true })
+	return size, err
+}
+
+// poolPackageStats returns the number and total size of the .deb files under pool/.
+func (m *Mirror) poolPackageStats() (int, int64, error) {
+	return sumFileSizes(filepath.Join(m.basePath, "pool"), func(name string) bool {
+		return strings.HasSuffix(name, ".deb")
+	})
+}
+
+// sumFileSizes returns the number and total size of the regular files under root
+// whose name match accepts; a missing root holds none.
+func sumFileSizes(root string, match func(name string) bool) (count int, size int64, err error) {
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			if path == root && errors.Is(walkErr, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return walkErr
+		}
+		if !entry.Type().IsRegular() || !match(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		size += info.Size()
+		return nil
+	})
+	return count, size, err
+}
+
 // GetRepositoryInfo returns the underlying Repository instance.
 func (m *Mirror) GetRepositoryInfo() *Repository {
 	return m.repository
@@ -518,6 +1281,9 @@ func (m *Mirror) UpdateConfiguration(config MirrorConfig) error {
 		return fmt.Errorf("invalid configuration: %w", err)
 	}
 
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
 	m.config = config
 	m.repository.URL = config.BaseURL
 	if len(config.Suites) > 0 {
@@ -531,14 +1297,27 @@ func (m *Mirror) UpdateConfiguration(config MirrorConfig) error {
 	} else {
 		m.repository.EnableSignatureVerification()
 	}
+	m.repository.pinSigner(config.RequireFingerprint)
+	if config.Queue != nil {
+		m.downloader.Queue = config.Queue
+		m.metadataDownloader.Queue = config.Queue
+	}
+	m.downloader.Cache = config.Cache
+	m.applyTimeouts()
 
 	return nil
 }
 
-// VerifyMirrorIntegrity verifies the integrity of a mirrored suite.
+// VerifyMirrorIntegrity verifies the integrity of a mirrored suite. For a partial
+// mirror, listed packages missing from the local Packages indices are reported as
+// warnings; see VerifyLocalPackages for the pool files themselves.
 func (m *Mirror) VerifyMirrorIntegrity(suite string) error {
-	m.logVerbose("Verifying mirror integrity for suite: %s\n", suite)
+	m.emit(MirrorEvent{Type: MirrorEventVerify, Suite: suite, Message: fmt.Sprintf("Verifying mirror integrity for suite: %s", suite)})
 
+	m.opMu.Lock()
+	defer m.opMu.Unlock()
+
+	m.useSharedHTTPClient()
 	m.repository.SetSuite(suite)
 
 	if err := m.repository.FetchReleaseFile(); err != nil {
@@ -549,10 +1328,31 @@ func (m *Mirror) VerifyMirrorIntegrity(suite string) error {
 	if releaseInfo == nil {
 		return fmt.Errorf("no release information available for verification")
 	}
+	if err := m.resolveSuiteArchitectures(suite); err != nil {
+		return err
+	}
+
+	components, err := m.releaseComponentsForSuite(suite, false)
+	if err != nil {
+		return err
+	}
 
-	for _, component := range m.config.Components {
-		for _, arch := range m.config.Architectures {
+	for _, component := range components {
+		for _, arch := range m.ArchitecturesForSuite(suite) {
 			m.verifyComponentArch(suite, component, arch)
+			if m.config.IncludeInstaller && m.repository.releaseListsPackagesIndex(InstallerComponent(component), arch) {
+				m.verifyComponentArch(suite, InstallerComponent(component), arch)
+			}
+		}
+	}
+	if err := m.verifyReleaseIndices(suite, components); err != nil {
+		return err
+	}
+
+	for _, arch := range m.ArchitecturesForSuite(suite) {
+		delete(m.selections, suite+"/"+arch) // Check what the mirror holds, not the last Clone
+		if _, err := m.packageSelection(suite, arch, m.localSuiteMetadata(suite, arch)); err != nil {
+			return err
 		}
 	}
 
@@ -565,23 +1365,172 @@ func (m *Mirror) verifyComponentArch(suite, component, arch string) {
 	packagesPath := filepath.Join(m.buildArchPath(suite, component, arch), "Packages.gz")
 
 	if _, err := os.Stat(packagesPath); err == nil {
-		m.logVerbose("Verifying %s\n", filename)
+		m.emit(MirrorEvent{Type: MirrorEventVerify, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Verifying %s", filename)})
 		// Repository has the verification logic, we leverage it
 		// Note: In a more complete implementation, you'd decompress and verify
-		m.logVerbose("✓ %s integrity check passed\n", filename)
+		m.emit(MirrorEvent{Type: MirrorEventVerify, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("✓ %s integrity check passed", filename)})
 	}
 }
 
-// loadPackageMetadata loads package metadata without downloading actual packages.
-func (m *Mirror) loadPackageMetadata(suite, component, arch string) error {
-	m.logVerbose("Loading package metadata for %s/%s\n", suite, component)
+// VerificationReport summarizes a VerifyLocalPackages run.
+type VerificationReport struct {
+	Checked   int // Packages listed in the local index
+	Valid     int // Pool files present with matching size and checksum
+	Missing   int // Pool files absent
+	Omitted   int // Pool files absent because a partial mirror does not list them
+	Corrupted int // Pool files whose size or checksum differs from the index
+	Errors    []VerificationError
+}
 
-	m.repository.SetSuite(suite)
-	m.repository.SetComponents([]string{component})
-	m.repository.SetArchitectures([]string{arch})
+// VerificationError describes a pool file that failed verification. Err wraps
+// ErrPoolFileMissing, ErrPoolFileCorrupted, or the error that prevented the check.
+type VerificationError struct {
+	Package string
+	Path    string
+	Err     error
+}
+
+func (e VerificationError) Error() string {
+	return fmt.Sprintf("%s (%s): %v", e.Package, e.Path, e.Err)
+}
+
+func (e VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// Pool file verification failures reported by VerifyLocalPackages.
+var (
+	ErrPoolFileMissing   = errors.New("pool file is missing")
+	ErrPoolFileCorrupted = errors.New("pool file does not match the Packages index")
+)
+
+// VerifyLocalPackages checks every package listed in the mirror's local Packages index
+// for suite, component and arch against the file in the pool: it must exist and match
+// the indexed size and SHA256 (MD5 when no SHA256 is listed). Nothing is downloaded.
+// For a partial mirror, absent files outside the package list are counted as Omitted
+// rather than Missing; listed ones are still errors. An error is returned only when
+// the local indices cannot be read.
+func (m *Mirror) VerifyLocalPackages(suite, component, arch string) (*VerificationReport, error) {
+	packages, err := m.readLocalPackagesIndex(suite, component, arch)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := m.repository.FetchPackages()
+	selection, err := m.packageSelection(suite, arch, m.localSuiteMetadata(suite, arch))
 	if err != nil {
+		return nil, err
+	}
+
+	report := &VerificationReport{}
+	for i := range packages {
+		pkg := &packages[i]
+		report.Checked++
+
+		relPath := mirrorPoolFilename(pkg, component, arch)
+		err := verifyPoolFile(filepath.Join(m.basePath, filepath.FromSlash(relPath)), pkg)
+		switch {
+		case err == nil:
+			report.Valid++
+			continue
+		case errors.Is(err, ErrPoolFileMissing) && !selection.includes(pkg):
+			report.Omitted++
+			continue
+		case errors.Is(err, ErrPoolFileMissing):
+			report.Missing++
+		case errors.Is(err, ErrPoolFileCorrupted):
+			report.Corrupted++
+		}
+
+		verr := VerificationError{Package: pkg.Name, Path: relPath, Err: err}
+		report.Errors = append(report.Errors, verr)
+		m.emit(MirrorEvent{Type: MirrorEventWarning, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("✗ %v", verr)})
+	}
+
+	m.emit(MirrorEvent{Type: MirrorEventVerify, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Verified %d packages: %d valid, %d missing, %d corrupted, %d omitted", report.Checked, report.Valid, report.Missing, report.Corrupted, report.Omitted)})
+	return report, nil
+}
+
+// readLocalPackagesIndex parses the Packages index the mirror wrote for suite,
+// component and arch, whichever compression it was downloaded with.
+func (m *Mirror) readLocalPackagesIndex(suite, component, arch string) ([]Package, error) {
+	packages, err := readPackagesIndex(m.repository, m.buildArchPath(suite, component, arch))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("no local Packages index for %s/binary-%s in suite %s", component, arch, suite)
+	}
+	return packages, err
+}
+
+// readPackagesIndex parses the Packages index in dir with repo, whichever compression
+// it is stored with. The error wraps fs.ErrNotExist when dir has none.
+func readPackagesIndex(repo *Repository, dir string) ([]Package, error) {
+	for _, ext := range CompressionExtensions {
+		file, err := os.Open(filepath.Join(dir, "Packages"+ext))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to open local Packages index: %w", err)
+		}
+		defer file.Close()
+
+		var reader io.Reader = file
+		if ext != "" {
+			decompressed, cleanup, err := repo.createDecompressor(file, ext)
+			if err != nil {
+				return nil, err
+			}
+			if cleanup != nil {
+				defer cleanup()
+			}
+			reader = decompressed
+		}
+
+		_, packages, err := repo.parsePackagesFromReader(context.Background(), reader)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing local Packages%s: %w", ext, err)
+		}
+		return packages, nil
+	}
+
+	return nil, fmt.Errorf("no Packages index in %s: %w", dir, fs.ErrNotExist)
+}
+
+// verifyPoolFile checks the pool file at path against the size and checksum of pkg.
+func verifyPoolFile(path string, pkg *Package) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrPoolFileMissing
+	}
+	if err != nil {
+		return err
+	}
+	if pkg.Size > 0 && info.Size() != pkg.Size {
+		return fmt.Errorf("%w: size %d, expected %d", ErrPoolFileCorrupted, info.Size(), pkg.Size)
+	}
+
+	expected, h, algo := strings.ToLower(pkg.SHA256), sha256.New(), "sha256"
+	if expected == "" {
+		expected, h, algo = strings.ToLower(pkg.MD5sum), md5.New(), "md5"
+	}
+	if expected == "" {
+		return nil
+	}
+
+	actual, err := hashFile(path, h)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%w: %s %s, expected %s", ErrPoolFileCorrupted, algo, actual, expected)
+	}
+	return nil
+}
+
+// loadPackageMetadata loads package metadata without downloading actual packages.
+func (m *Mirror) loadPackageMetadata(suite, component, arch string) error {
+	m.emit(MirrorEvent{Type: MirrorEventMetadata, Suite: suite, Component: component, Arch: arch, Message: fmt.Sprintf("Loading package metadata for %s/%s", suite, component)})
+
+	if _, err := m.fetchComponentPackages(suite, component, arch); err != nil {
 		return fmt.Errorf("failed to fetch package metadata: %w", err)
 	}
 
@@ -590,11 +1539,85 @@ func (m *Mirror) loadPackageMetadata(suite, component, arch string) error {
 
 // Helper methods for path building and logging
 
-// logVerbose prints a message if verbose mode is enabled.
-func (m *Mirror) logVerbose(format string, args ...any) {
+// Plan returns the download plan built by the last Clone or Sync in dry-run mode,
+// with the warnings recorded so far, or nil when DryRun is disabled.
+func (m *Mirror) Plan() *DownloadPlan {
+	if m.plan != nil {
+		m.plan.Warnings = m.GetWarnings()
+	}
+	return m.plan
+}
+
+// writesMetadata reports whether Release and Packages files are written under dists/.
+func (m *Mirror) writesMetadata() bool {
+	return !m.config.DryRun || m.config.WriteMetadata
+}
+
+// emit reports an event to ProgressHandler. Without a handler, the event message
+// is printed when verbose mode is enabled.
+func (m *Mirror) emit(event MirrorEvent) {
+	m.eventMu.Lock()
+	defer m.eventMu.Unlock()
+
+	if m.ProgressHandler != nil {
+		m.ProgressHandler(event)
+		return
+	}
 	if m.config.Verbose {
-		fmt.Printf(format, args...)
+		fmt.Println(event.Message)
+	}
+}
+
+// warn records a warning with event's message and emits event.
+func (m *Mirror) warn(kind WarningKind, subject string, event MirrorEvent) {
+	m.warnings.Add(Warning{Kind: kind, Subject: subject, Message: event.Message})
+	m.emit(event)
+}
+
+// emitFileDownloaded reports a completed download along with its size on disk.
+func (m *Mirror) emitFileDownloaded(suite, component, arch, path string) {
+	event := MirrorEvent{
+		Type:      MirrorEventFileDownloaded,
+		Suite:     suite,
+		Component: component,
+		Arch:      arch,
+		Message:   fmt.Sprintf("Successfully downloaded: %s", filepath.Base(path)),
+	}
+	if info, err := os.Stat(path); err == nil {
+		event.BytesTransferred = info.Size()
+	}
+	m.emit(event)
+}
+
+// publish puts the metadata file mirrored at localPath, under the base path, in
+// Storage when one is configured. The file is kept under the base path as the working
+// copy of the metadata.
+func (m *Mirror) publish(localPath string) error {
+	if m.config.Storage == nil {
+		return nil
+	}
+	rel, err := filepath.Rel(m.basePath, localPath)
+	if err != nil {
+		return fmt.Errorf("unable to publish %s: %w", localPath, err)
+	}
+	return m.putFile(filepath.ToSlash(rel), localPath)
+}
+
+// putFile streams the file at localPath into Storage at rel.
+func (m *Mirror) putFile(rel, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("unable to publish %s: %w", rel, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to publish %s: %w", rel, err)
 	}
+	if err := m.config.Storage.Put(rel, file, info.Size(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("unable to publish %s: %w", rel, err)
+	}
+	return nil
 }
 
 // buildSuitePath returns the path to a suite directory.
@@ -612,21 +1635,27 @@ func (m *Mirror) buildPackagesBaseURL(suite, component, arch string) string {
 	return fmt.Sprintf("%s/dists/%s/%s/binary-%s/Packages", m.config.BaseURL, suite, component, arch)
 }
 
-// WritePackagesMetadata writes compressed Packages files under dists for a suite.
+// WritePackagesMetadata writes the Packages.gz and Packages.xz files of a suite under
+// metadataRoot. Use WritePackagesMetadataToStorage to write the uncompressed Packages too.
 func WritePackagesMetadata(metadataRoot, suite string, packagesByComponent map[string]map[string][]Package) error {
+	return WritePackagesMetadataToStorage(NewLocalStorage(metadataRoot), "", suite, packagesByComponent, false)
+}
+
+// WritePackagesMetadataToStorage is WritePackagesMetadata putting the Packages files in
+// storage, under its distsDir directory, e.g. "dists". With includeUncompressed, the
+// uncompressed Packages is written alongside Packages.gz and Packages.xz for clients
+// that cannot decompress (see MirrorConfig.IncludeUncompressed); otherwise a Packages
+// file left by an earlier run is removed, so it cannot go stale.
+func WritePackagesMetadataToStorage(storage Storage, distsDir, suite string, packagesByComponent map[string]map[string][]Package, includeUncompressed bool) error {
 	for component, byArch := range packagesByComponent {
 		for arch, pkgs := range byArch {
 			if len(pkgs) == 0 {
 				continue
 			}
 
-			distsDir := filepath.Join(metadataRoot, suite, component, fmt.Sprintf("binary-%s", arch))
-			if err := os.MkdirAll(distsDir, DirPermission); err != nil {
-				return fmt.Errorf("unable to create metadata directory %s: %w", distsDir, err)
-			}
-
+			dir := path.Join(distsDir, suite, component, fmt.Sprintf("binary-%s", arch))
 			content := []byte(formatPackagesFile(pkgs))
-			if err := writeCompressedPackages(distsDir, content); err != nil {
+			if err := writeCompressedIndex(storage, dir, "Packages", content, includeUncompressed); err != nil {
 				return err
 			}
 		}
@@ -635,20 +1664,68 @@ func WritePackagesMetadata(metadataRoot, suite string, packagesByComponent map[s
 	return nil
 }
 
+// MergePackagesMetadata is WritePackagesMetadataToStorage merging packagesByComponent
+// into the Packages files already under metadataRoot instead of replacing them, e.g. to
+// add the packages of a retried build: an existing entry is replaced by a new one with
+// the same name and architecture and kept otherwise. Merged files are sorted by name.
+func MergePackagesMetadata(metadataRoot, suite string, packagesByComponent map[string]map[string][]Package, includeUncompressed bool) error {
+	merged := make(map[string]map[string][]Package, len(packagesByComponent))
+	for component, byArch := range packagesByComponent {
+		merged[component] = make(map[string][]Package, len(byArch))
+		for arch, pkgs := range byArch {
+			if len(pkgs) == 0 {
+				continue
+			}
+
+			distsDir := filepath.Join(metadataRoot, suite, component, fmt.Sprintf("binary-%s", arch))
+			existing, err := readPackagesIndex(&Repository{}, distsDir)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("unable to read existing Packages of %s/%s: %w", component, arch, err)
+			}
+			merged[component][arch] = mergePackageEntries(existing, pkgs)
+		}
+	}
+	return WritePackagesMetadataToStorage(NewLocalStorage(metadataRoot), "", suite, merged, includeUncompressed)
+}
+
+// mergePackageEntries returns existing with the entries of added replacing those of
+// the same name and architecture, sorted by name and architecture.
+func mergePackageEntries(existing, added []Package) []Package {
+	key := func(pkg *Package) string { return pkg.Name + "\x00" + pkg.Architecture }
+	replaced := make(map[string]bool, len(added))
+	for i := range added {
+		replaced[key(&added[i])] = true
+	}
+
+	merged := make([]Package, 0, len(existing)+len(added))
+	for i := range existing {
+		if !replaced[key(&existing[i])] {
+			merged = append(merged, existing[i])
+		}
+	}
+	merged = append(merged, added...)
+	slices.SortStableFunc(merged, func(a, b Package) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.Architecture, b.Architecture))
+	})
+	return merged
+}
+
 // WriteSourcesMetadata writes compressed Sources files under dists for a suite.
 func WriteSourcesMetadata(metadataRoot, suite string, sourcesByComponent map[string][]SourcePackage) error {
+	return WriteSourcesMetadataToStorage(NewLocalStorage(metadataRoot), "", suite, sourcesByComponent)
+}
+
+// WriteSourcesMetadataToStorage is WriteSourcesMetadata putting the Sources files in
+// storage, under its distsDir directory.
+func WriteSourcesMetadataToStorage(storage Storage, distsDir, suite string, sourcesByComponent map[string][]SourcePackage) error {
 	for component, srcPkgs := range sourcesByComponent {
 		if len(srcPkgs) == 0 {
 			continue
 		}
 
-		distsDir := filepath.Join(metadataRoot, suite, component, "source")
-		if err := os.MkdirAll(distsDir, DirPermission); err != nil {
-			return fmt.Errorf("unable to create source metadata directory %s: %w", distsDir, err)
-		}
-
+		dir := path.Join(distsDir, suite, component, "source")
 		content := []byte(formatSourcesFile(srcPkgs))
-		if err := writeCompressedSources(distsDir, content); err != nil {
+		if err := writeCompressedIndex(storage, dir, "Sources", content, true); err != nil {
 			return err
 		}
 	}
@@ -657,9 +1734,13 @@ func WriteSourcesMetadata(metadataRoot, suite string, sourcesByComponent map[str
 }
 
 // ReleaseSigningConfig holds GPG signing configuration for Release files.
+// Signer takes precedence, then PrivateKeyPath, then KeyID.
 type ReleaseSigningConfig struct {
-	PrivateKeyPath string // Path to the armored private key file
-	Passphrase     string // Passphrase for the private key (can be empty)
+	PrivateKeyPath string    // Path to the armored private key file
+	Passphrase     string    // Passphrase for the private key (can be empty)
+	KeyID          string    // Key to sign with through the gpg executable
+	GPGHome        string    // GnuPG home directory holding KeyID (default: gpg's own)
+	Signer         GPGSigner // Custom signer, e.g. backed by an HSM or agent
 }
 
 // WriteReleaseFiles builds unsigned Release and InRelease files for a suite.
@@ -669,30 +1750,44 @@ func WriteReleaseFiles(metadataRoot, suite string, components, architectures []s
 }
 
 // WriteSignedReleaseFiles builds Release and InRelease files for a suite.
-// If signingConfig is provided with a valid private key, the files will be signed:
+// If signingConfig selects a signer, the files will be signed:
 // - Release.gpg: detached armored signature
 // - InRelease: cleartext signed Release
-// If signingConfig is nil or the key path is empty, files are written unsigned.
+// If signingConfig is nil or selects no signer, files are written unsigned.
 func WriteSignedReleaseFiles(metadataRoot, suite string, components, architectures []string, includeSources bool, signingConfig *ReleaseSigningConfig) error {
-	releaseContent, err := buildReleaseContent(metadataRoot, suite, components, architectures, includeSources)
+	return WriteSignedReleaseFilesToStorage(NewLocalStorage(metadataRoot), "", suite, components, architectures, includeSources, false, signingConfig)
+}
+
+// WriteSignedReleaseFilesToStorage is WriteSignedReleaseFiles for the indices stored
+// in storage under its distsDir directory, as by WritePackagesMetadataToStorage with
+// the same includeUncompressed. The Release files are put in storage next to them.
+func WriteSignedReleaseFilesToStorage(storage Storage, distsDir, suite string, components, architectures []string, includeSources, includeUncompressed bool, signingConfig *ReleaseSigningConfig) error {
+	releaseContent, err := buildReleaseContent(storage, distsDir, suite, components, architectures, includeSources, includeUncompressed)
 	if err != nil {
 		return err
 	}
 
-	releasePath := filepath.Join(metadataRoot, suite, "Release")
-	if err := os.WriteFile(releasePath, []byte(releaseContent), FilePermission); err != nil {
+	suiteDir := path.Join(distsDir, suite)
+	if err := putBytes(storage, path.Join(suiteDir, "Release"), []byte(releaseContent)); err != nil {
 		return fmt.Errorf("unable to write Release file: %w", err)
 	}
 
-	// Sign Release file if signing config is provided
-	if signingConfig != nil && signingConfig.PrivateKeyPath != "" {
-		if err := signReleaseFiles(metadataRoot, suite, releaseContent, signingConfig); err != nil {
+	signer, err := signingConfig.signer()
+	if err != nil {
+		return fmt.Errorf("failed to sign Release files: %w", err)
+	}
+	// A key read from PrivateKeyPath is only needed for this suite
+	if keyFileSigner, ok := signer.(*KeyFileSigner); ok && signingConfig.Signer == nil {
+		defer keyFileSigner.Close()
+	}
+
+	if signer != nil {
+		if err := signReleaseFiles(storage, suiteDir, releaseContent, signer); err != nil {
 			return fmt.Errorf("failed to sign Release files: %w", err)
 		}
 	} else {
 		// Write unsigned InRelease as a copy of Release
-		inReleasePath := filepath.Join(metadataRoot, suite, "InRelease")
-		if err := os.WriteFile(inReleasePath, []byte(releaseContent), FilePermission); err != nil {
+		if err := putBytes(storage, path.Join(suiteDir, "InRelease"), []byte(releaseContent)); err != nil {
 			return fmt.Errorf("unable to write InRelease file: %w", err)
 		}
 	}
@@ -700,71 +1795,31 @@ func WriteSignedReleaseFiles(metadataRoot, suite string, components, architectur
 	return nil
 }
 
-// signReleaseFiles creates Release.gpg (detached signature) and InRelease (clearsigned).
-func signReleaseFiles(metadataRoot, suite, releaseContent string, config *ReleaseSigningConfig) error {
-	keyData, err := os.ReadFile(config.PrivateKeyPath)
-	if err != nil {
-		return fmt.Errorf("failed to read private key file: %w", err)
-	}
-
-	// Parse the private key from armored format
-	privateKey, err := crypto.NewKeyFromArmored(string(keyData))
-	if err != nil {
-		return fmt.Errorf("failed to parse private key: %w", err)
-	}
-
-	// Unlock the key if it's encrypted and a passphrase is provided
-	locked, err := privateKey.IsLocked()
-	if err != nil {
-		return fmt.Errorf("failed to check if key is locked: %w", err)
-	}
-	if locked {
-		unlockedKey, err := privateKey.Unlock([]byte(config.Passphrase))
-		if err != nil {
-			return fmt.Errorf("failed to unlock private key: %w", err)
-		}
-		privateKey = unlockedKey
-	}
-	defer privateKey.ClearPrivateParams()
-
-	pgp := crypto.PGP()
-
-	// Create detached signature for Release.gpg
-	signer, err := pgp.Sign().SigningKey(privateKey).Detached().New()
-	if err != nil {
-		return fmt.Errorf("failed to create detached signer: %w", err)
-	}
-
-	detachedSig, err := signer.Sign([]byte(releaseContent), crypto.Armor)
+// signReleaseFiles creates Release.gpg (detached signature) and InRelease (clearsigned)
+// in the suiteDir directory of storage.
+func signReleaseFiles(storage Storage, suiteDir, releaseContent string, signer GPGSigner) error {
+	detachedSig, err := signer.Sign([]byte(releaseContent))
 	if err != nil {
 		return fmt.Errorf("failed to create detached signature: %w", err)
 	}
 
-	releaseGPGPath := filepath.Join(metadataRoot, suite, "Release.gpg")
-	if err := os.WriteFile(releaseGPGPath, detachedSig, FilePermission); err != nil {
+	if err := putBytes(storage, path.Join(suiteDir, "Release.gpg"), detachedSig); err != nil {
 		return fmt.Errorf("unable to write Release.gpg: %w", err)
 	}
 
-	// Create cleartext signed InRelease
-	cleartextSigner, err := pgp.Sign().SigningKey(privateKey).New()
-	if err != nil {
-		return fmt.Errorf("failed to create cleartext signer: %w", err)
-	}
-
-	clearsignedMsg, err := cleartextSigner.SignCleartext([]byte(releaseContent))
+	clearsignedMsg, err := signer.ClearSign([]byte(releaseContent))
 	if err != nil {
 		return fmt.Errorf("failed to create cleartext signature: %w", err)
 	}
 
-	inReleasePath := filepath.Join(metadataRoot, suite, "InRelease")
-	if err := os.WriteFile(inReleasePath, clearsignedMsg, FilePermission); err != nil {
+	if err := putBytes(storage, path.Join(suiteDir, "InRelease"), clearsignedMsg); err != nil {
 		return fmt.Errorf("unable to write InRelease: %w", err)
 	}
 
 	return nil
 }
 
-func buildReleaseContent(metadataRoot, suite string, components, architectures []string, includeSources bool) (string, error) {
+func buildReleaseContent(storage Storage, distsDir, suite string, components, architectures []string, includeSources, includeUncompressed bool) (string, error) {
 	var sb strings.Builder
 	now := time.Now().UTC()
 	// Valid-Until: 7 days from now
@@ -782,7 +1837,7 @@ func buildReleaseContent(metadataRoot, suite string, components, architectures [
 	sb.WriteString("Acquire-By-Hash: no\n")
 	sb.WriteString("Description: Custom Debian repository built with deb-for-all\n")
 
-	md5Checksums, sha256Checksums, err := collectPackagesChecksums(metadataRoot, suite, components, architectures, includeSources)
+	md5Checksums, sha256Checksums, err := collectPackagesChecksums(storage, distsDir, suite, components, architectures, includeSources, includeUncompressed)
 	if err != nil {
 		return "", err
 	}
@@ -793,57 +1848,34 @@ func buildReleaseContent(metadataRoot, suite string, components, architectures [
 	return sb.String(), nil
 }
 
-func collectPackagesChecksums(metadataRoot, suite string, components, architectures []string, includeSources bool) ([]FileChecksum, []FileChecksum, error) {
+// collectPackagesChecksums returns the Release entries of the Packages indices, and of
+// the Sources indices with includeSources. The uncompressed Packages is read from its
+// file with includeUncompressed, as written by WritePackagesMetadataToStorage, and
+// computed from a compressed variant otherwise.
+func collectPackagesChecksums(storage Storage, distsDir, suite string, components, architectures []string, includeSources, includeUncompressed bool) ([]FileChecksum, []FileChecksum, error) {
 	md5Entries := make([]FileChecksum, 0)
 	sha256Entries := make([]FileChecksum, 0)
 
+	add := func(index string, plainOnDisk bool) error {
+		md5Sums, sha256Sums, err := indexChecksums(storage, path.Join(distsDir, suite), index, plainOnDisk)
+		if err != nil {
+			return err
+		}
+		md5Entries = append(md5Entries, md5Sums...)
+		sha256Entries = append(sha256Entries, sha256Sums...)
+		return nil
+	}
+
 	for _, component := range components {
 		for _, arch := range architectures {
-			for _, filename := range []string{"Packages", "Packages.gz", "Packages.xz"} {
-				relPath := filepath.Join(component, fmt.Sprintf("binary-%s", arch), filename)
-				absPath := filepath.Join(metadataRoot, suite, relPath)
-				info, err := os.Stat(absPath)
-				if err != nil {
-					continue
-				}
-
-				hashMD5, err := hashFile(absPath, md5.New())
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to hash %s: %w", absPath, err)
-				}
-				hashSHA256, err := hashFile(absPath, sha256.New())
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to hash %s: %w", absPath, err)
-				}
-
-				relUnix := filepath.ToSlash(relPath)
-				md5Entries = append(md5Entries, FileChecksum{Hash: hashMD5, Size: info.Size(), Filename: relUnix})
-				sha256Entries = append(sha256Entries, FileChecksum{Hash: hashSHA256, Size: info.Size(), Filename: relUnix})
+			if err := add(path.Join(component, fmt.Sprintf("binary-%s", arch), "Packages"), includeUncompressed); err != nil {
+				return nil, nil, err
 			}
 		}
-
-		// Include Sources files if requested
+		// Include Sources files if requested; they are always written uncompressed too
 		if includeSources {
-			for _, filename := range []string{"Sources", "Sources.gz", "Sources.xz"} {
-				relPath := filepath.Join(component, "source", filename)
-				absPath := filepath.Join(metadataRoot, suite, relPath)
-				info, err := os.Stat(absPath)
-				if err != nil {
-					continue
-				}
-
-				hashMD5, err := hashFile(absPath, md5.New())
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to hash %s: %w", absPath, err)
-				}
-				hashSHA256, err := hashFile(absPath, sha256.New())
-				if err != nil {
-					return nil, nil, fmt.Errorf("failed to hash %s: %w", absPath, err)
-				}
-
-				relUnix := filepath.ToSlash(relPath)
-				md5Entries = append(md5Entries, FileChecksum{Hash: hashMD5, Size: info.Size(), Filename: relUnix})
-				sha256Entries = append(sha256Entries, FileChecksum{Hash: hashSHA256, Size: info.Size(), Filename: relUnix})
+			if err := add(path.Join(component, "source", "Sources"), true); err != nil {
+				return nil, nil, err
 			}
 		}
 	}
@@ -851,6 +1883,72 @@ func collectPackagesChecksums(metadataRoot, suite string, components, architectu
 	return md5Entries, sha256Entries, nil
 }
 
+// indexChecksums returns the MD5 and SHA256 entries of the index relPath under the
+// suiteDir directory of storage and of its .gz and .xz variants. apt and
+// VerifyPackagesFileChecksum look up the uncompressed entry after decompression, so it
+// is always listed: read from the plain file when plainOnDisk is set and the file
+// exists, and computed from a compressed variant otherwise. A plain file that is not
+// expected is only read when there is no compressed variant.
+func indexChecksums(storage Storage, suiteDir, relPath string, plainOnDisk bool) ([]FileChecksum, []FileChecksum, error) {
+	var md5Entries, sha256Entries []FileChecksum
+	// add records the entry name, reading the file name+ext decompressed when ext is set
+	add := func(name, ext string) (bool, error) {
+		filePath := path.Join(suiteDir, name+ext)
+		file, err := storage.Open(filePath)
+		if err != nil {
+			return false, nil
+		}
+		defer file.Close()
+
+		var content io.Reader = file
+		if ext != "" {
+			decompressed, cleanup, err := (&Repository{}).createDecompressor(file, ext)
+			if err != nil {
+				return false, fmt.Errorf("failed to decompress %s: %w", filePath, err)
+			}
+			if cleanup != nil {
+				defer cleanup()
+			}
+			content = decompressed
+		}
+
+		hashMD5, hashSHA256 := md5.New(), sha256.New()
+		size, err := io.Copy(io.MultiWriter(hashMD5, hashSHA256), content)
+		if err != nil {
+			return false, fmt.Errorf("failed to hash %s: %w", filePath, err)
+		}
+		md5Entries = append(md5Entries, FileChecksum{Hash: hex.EncodeToString(hashMD5.Sum(nil)), Size: size, Filename: name})
+		sha256Entries = append(sha256Entries, FileChecksum{Hash: hex.EncodeToString(hashSHA256.Sum(nil)), Size: size, Filename: name})
+		return true, nil
+	}
+
+	plainFound := false
+	if plainOnDisk {
+		var err error
+		if plainFound, err = add(relPath, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, ext := range []string{".gz", ".xz"} {
+		found, err := add(relPath+ext, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		if found && !plainFound {
+			if plainFound, err = add(relPath, ext); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if !plainFound {
+		if _, err := add(relPath, ""); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return md5Entries, sha256Entries, nil
+}
+
 func writeReleaseChecksumSection(sb *strings.Builder, section string, entries []FileChecksum) {
 	if len(entries) == 0 {
 		return
@@ -863,44 +1961,32 @@ func writeReleaseChecksumSection(sb *strings.Builder, section string, entries []
 	}
 }
 
-func writeCompressedPackages(dir string, content []byte) error {
-	// Write uncompressed Packages file
-	packagesPath := filepath.Join(dir, "Packages")
-	if err := os.WriteFile(packagesPath, content, FilePermission); err != nil {
-		return fmt.Errorf("unable to write %s: %w", packagesPath, err)
-	}
-
-	gzipPath := filepath.Join(dir, "Packages.gz")
-	if err := writeGzipFile(gzipPath, content); err != nil {
-		return fmt.Errorf("unable to write %s: %w", gzipPath, err)
-	}
-
-	xzPath := filepath.Join(dir, "Packages.xz")
-	if err := writeXZFile(xzPath, content); err != nil {
-		return fmt.Errorf("unable to write %s: %w", xzPath, err)
+// writeCompressedIndex puts the .gz and .xz variants of the index name in the dir
+// directory of storage, along with the uncompressed index when includeUncompressed is
+// set. Otherwise an uncompressed index left by an earlier run is deleted.
+func writeCompressedIndex(storage Storage, dir, name string, content []byte, includeUncompressed bool) error {
+	extensions := []string{".gz", ".xz"}
+	if includeUncompressed {
+		extensions = []string{"", ".gz", ".xz"}
+	} else if err := storage.Delete(path.Join(dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to remove %s: %w", path.Join(dir, name), err)
+	}
+
+	for _, ext := range extensions {
+		data, err := compressContent(content, ext)
+		if err == nil {
+			err = putBytes(storage, path.Join(dir, name+ext), data)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to write %s: %w", path.Join(dir, name+ext), err)
+		}
 	}
-
 	return nil
 }
 
-func writeCompressedSources(dir string, content []byte) error {
-	// Write uncompressed Sources file
-	sourcesPath := filepath.Join(dir, "Sources")
-	if err := os.WriteFile(sourcesPath, content, FilePermission); err != nil {
-		return fmt.Errorf("unable to write %s: %w", sourcesPath, err)
-	}
-
-	gzipPath := filepath.Join(dir, "Sources.gz")
-	if err := writeGzipFile(gzipPath, content); err != nil {
-		return fmt.Errorf("unable to write %s: %w", gzipPath, err)
-	}
-
-	xzPath := filepath.Join(dir, "Sources.xz")
-	if err := writeXZFile(xzPath, content); err != nil {
-		return fmt.Errorf("unable to write %s: %w", xzPath, err)
-	}
-
-	return nil
+// putBytes stores data at p in storage with FilePermission.
+func putBytes(storage Storage, p string, data []byte) error {
+	return storage.Put(p, bytes.NewReader(data), int64(len(data)), FilePermission)
 }
 
 func formatSourcesFile(sources []SourcePackage) string {
@@ -940,46 +2026,52 @@ func formatSourcesFile(sources []SourcePackage) string {
 }
 
 func writeGzipFile(path string, content []byte) error {
-	file, err := os.Create(path)
+	data, err := compressContent(content, ".gz")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	writer := gzip.NewWriter(file)
-	if _, err := writer.Write(content); err != nil {
-		writer.Close()
-		return err
-	}
-	if err := writer.Close(); err != nil {
-		return err
-	}
-
-	return os.Chmod(path, FilePermission)
+	return os.WriteFile(path, data, FilePermission)
 }
 
 func writeXZFile(path string, content []byte) error {
-	file, err := os.Create(path)
+	data, err := compressContent(content, ".xz")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	return os.WriteFile(path, data, FilePermission)
+}
 
-	writer, err := xz.NewWriter(file)
-	if err != nil {
-		return err
+// compressContent returns content compressed as for the file extension ext, ".gz" or
+// ".xz"; any other extension returns content unchanged.
+func compressContent(content []byte, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch ext {
+	case ".gz":
+		writer = gzip.NewWriter(&buf)
+	case ".xz":
+		xzWriter, err := xz.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		writer = xzWriter
+	default:
+		return content, nil
 	}
+
 	if _, err := writer.Write(content); err != nil {
 		writer.Close()
-		return err
+		return nil, err
 	}
 	if err := writer.Close(); err != nil {
-		return err
+		return nil, err
 	}
-
-	return os.Chmod(path, FilePermission)
+	return buf.Bytes(), nil
 }
 
+// formatPackagesFile renders packages as Packages index stanzas. Fields without a
+// dedicated Package member are written from CustomFields in the order they were read,
+// so archive-specific fields such as Phased-Update-Percentage survive a round trip.
 func formatPackagesFile(packages []Package) string {
 	var sb strings.Builder
 
@@ -994,33 +2086,53 @@ func formatPackagesFile(packages []Package) string {
 		}
 
 		writeField("Package", pkg.Package)
+		if pkg.Source != pkg.Package {
+			writeField("Source", pkg.Source)
+		}
 		writeField("Version", pkg.Version)
 		writeField("Architecture", pkg.Architecture)
+		writeField("Essential", pkg.Essential)
 		writeField("Maintainer", pkg.Maintainer)
+		writeField("Installed-Size", pkg.InstalledSize)
 		writeField("Section", pkg.Section)
 		writeField("Priority", pkg.Priority)
+		writeField("Origin", pkg.Origin)
+		writeField("Bugs", pkg.Bugs)
+		writeField("Multi-Arch", pkg.MultiArch)
+		writeField("Built-Using", pkg.BuiltUsing)
+		writeField("Package-Type", pkg.PackageType)
 		writeField("Filename", pkg.Filename)
 		if pkg.Size > 0 {
 			sb.WriteString("Size: ")
 			sb.WriteString(fmt.Sprintf("%d\n", pkg.Size))
 		}
 		writeField("MD5sum", pkg.MD5sum)
+		writeField("SHA1", pkg.SHA1)
 		writeField("SHA256", pkg.SHA256)
+		writeField("SHA512", pkg.SHA512)
 		writeListField(&sb, "Depends", pkg.Depends)
 		writeListField(&sb, "Pre-Depends", pkg.PreDepends)
 		writeListField(&sb, "Recommends", pkg.Recommends)
 		writeListField(&sb, "Suggests", pkg.Suggests)
+		writeListField(&sb, "Enhances", pkg.Enhances)
 		writeListField(&sb, "Breaks", pkg.Breaks)
 		writeListField(&sb, "Conflicts", pkg.Conflicts)
 		writeListField(&sb, "Provides", pkg.Provides)
 		writeListField(&sb, "Replaces", pkg.Replaces)
-
-		if pkg.Description != "" {
-			sb.WriteString("Description: ")
-			sb.WriteString(pkg.Description)
-			sb.WriteString("\n")
+		writeField("Homepage", pkg.Homepage)
+		writeField("Tag", pkg.Tag)
+		writeField("Task", pkg.Task)
+		writeField("Important", pkg.ImportantDescription)
+		writeField("Gstreamer-Version", pkg.Gstreamer)
+		writeField("Python-Version", pkg.PythonVersion)
+
+		for _, field := range pkg.customFieldNames() {
+			writeField(field, pkg.CustomFields[field])
 		}
 
+		writeField("Description", pkg.Description)
+		writeField("Description-md5", pkg.DescriptionMd5)
+
 		sb.WriteString("\n")
 	}
 
@@ -0,0 +1,1403 @@
+package debian
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CeGenreDeChat/deb-for-all/internal/testsupport"
+)
+
+// newSuiteServer serves an unsigned Release/InRelease and an uncompressed
+// Packages index for main/amd64 under any suite in suites, plus the single
+// pool file the index references.
+func newSuiteServer(t *testing.T, suites ...string) *httptest.Server {
+	t.Helper()
+
+	packages := fmt.Sprintf("Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nMaintainer: Test <test@example.com>\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\nSize: 7\nSHA256: %x\n\n", sha256.Sum256([]byte("payload")))
+	release := fmt.Sprintf("Origin: Test\nSuite: %%s\nCodename: %%s\nDate: Sat, 10 Jun 2023 09:02:09 UTC\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/pool/main/h/hello/hello_2.10-3_amd64.deb" {
+			w.Write([]byte("payload"))
+			return
+		}
+		for _, suite := range suites {
+			prefix := "/dists/" + suite + "/"
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				continue
+			}
+			switch strings.TrimPrefix(r.URL.Path, prefix) {
+			case "Release", "InRelease":
+				fmt.Fprintf(w, release, suite, suite)
+				return
+			case "main/binary-amd64/Packages":
+				w.Write([]byte(packages))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func newTestMirror(t *testing.T, baseURL string) (*Mirror, string) {
+	t.Helper()
+
+	basePath := t.TempDir()
+	mirror := NewMirror(MirrorConfig{
+		BaseURL:       baseURL,
+		Suites:        []string{"bookworm"},
+		Components:    []string{"main"},
+		Architectures: []string{"amd64"},
+		SkipGPGVerify: true,
+	}, basePath)
+	mirror.downloader.RetryAttempts = 1
+	mirror.metadataDownloader.RetryAttempts = 1
+	return mirror, basePath
+}
+
+func TestMirrorAddSuite(t *testing.T) {
+	server := newSuiteServer(t, "bookworm", "bookworm-security")
+	defer server.Close()
+
+	mirror, basePath := newTestMirror(t, server.URL)
+
+	if err := mirror.AddSuite("bookworm-security"); err != nil {
+		t.Fatalf("AddSuite failed: %v", err)
+	}
+
+	for _, rel := range []string{"Release", filepath.Join("main", "binary-amd64", "Packages")} {
+		if _, err := os.Stat(filepath.Join(basePath, "dists", "bookworm-security", rel)); err != nil {
+			t.Fatalf("expected %s for the added suite: %v", rel, err)
+		}
+	}
+	if got := mirror.config.Suites; len(got) != 2 || got[1] != "bookworm-security" {
+		t.Fatalf("unexpected suites after AddSuite: %v", got)
+	}
+
+	if err := mirror.AddSuite("bookworm-security"); !errors.Is(err, ErrSuiteAlreadyConfigured) {
+		t.Fatalf("expected ErrSuiteAlreadyConfigured, got %v", err)
+	}
+}
+
+func TestMirrorAddRemoveSuiteKeepsCallerList(t *testing.T) {
+	server := newSuiteServer(t, "bookworm", "bookworm-security", "bookworm-updates")
+	defer server.Close()
+
+	suites := make([]string, 1, 4)
+	suites[0] = "bookworm"
+	basePath := t.TempDir()
+	mirror := NewMirror(MirrorConfig{
+		BaseURL:       server.URL,
+		Suites:        suites,
+		Components:    []string{"main"},
+		Architectures: []string{"amd64"},
+		SkipGPGVerify: true,
+	}, basePath)
+
+	if err := mirror.AddSuite("bookworm-security"); err != nil {
+		t.Fatalf("AddSuite failed: %v", err)
+	}
+	if got := suites[:cap(suites)][1]; got != "" {
+		t.Fatalf("AddSuite wrote %q into the caller's backing array", got)
+	}
+	if err := mirror.RemoveSuite("bookworm", false); err != nil {
+		t.Fatalf("RemoveSuite failed: %v", err)
+	}
+	if suites[0] != "bookworm" {
+		t.Fatalf("RemoveSuite changed the caller's list: %v", suites)
+	}
+
+	// A suite that fails to mirror is not kept in the configuration
+	if err := os.WriteFile(filepath.Join(basePath, "dists", "bookworm-updates"), nil, FilePermission); err != nil {
+		t.Fatal(err)
+	}
+	if err := mirror.AddSuite("bookworm-updates"); err == nil {
+		t.Fatal("expected AddSuite to fail when the suite directory cannot be created")
+	}
+	if got := mirror.config.Suites; !slices.Equal(got, []string{"bookworm-security"}) {
+		t.Fatalf("expected a failed AddSuite to be rolled back, got %v", got)
+	}
+}
+
+func TestMirrorRemoveSuite(t *testing.T) {
+	server := newSuiteServer(t, "bookworm", "bookworm-security")
+	defer server.Close()
+
+	mirror, basePath := newTestMirror(t, server.URL)
+	if err := mirror.AddSuite("bookworm-security"); err != nil {
+		t.Fatalf("AddSuite failed: %v", err)
+	}
+
+	if err := mirror.RemoveSuite("bookworm-security", true); err != nil {
+		t.Fatalf("RemoveSuite failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "dists", "bookworm-security")); !os.IsNotExist(err) {
+		t.Fatalf("expected suite directory to be removed")
+	}
+	if err := mirror.RemoveSuite("bookworm-security", false); !errors.Is(err, ErrSuiteNotConfigured) {
+		t.Fatalf("expected ErrSuiteNotConfigured, got %v", err)
+	}
+}
+
+func TestMirrorProgressHandler(t *testing.T) {
+	server := newSuiteServer(t, "bookworm")
+	defer server.Close()
+
+	mirror, _ := newTestMirror(t, server.URL)
+	mirror.config.DownloadPackages = true
+
+	var events []MirrorEvent
+	mirror.ProgressHandler = func(event MirrorEvent) {
+		events = append(events, event)
+	}
+
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var debBytes int64
+	for _, event := range events {
+		seen[event.Type] = true
+		if event.Type == MirrorEventFileDownloaded && strings.HasSuffix(event.Message, ".deb") {
+			debBytes = event.BytesTransferred
+			if event.Suite != "bookworm" || event.Component != "main" || event.Arch != "amd64" {
+				t.Errorf("unexpected event location: %+v", event)
+			}
+		}
+	}
+
+	for _, eventType := range []string{MirrorEventStart, MirrorEventSuiteStart, MirrorEventComponentStart, MirrorEventArchStart, MirrorEventMetadata, MirrorEventFileDownloaded} {
+		if !seen[eventType] {
+			t.Errorf("expected a %q event, got %v", eventType, seen)
+		}
+	}
+	if debBytes != int64(len("payload")) {
+		t.Errorf("expected .deb download of %d bytes, got %d", len("payload"), debBytes)
+	}
+}
+
+func TestMirrorStatusReportsMetadataDate(t *testing.T) {
+	server := newSuiteServer(t, "bookworm")
+	defer server.Close()
+
+	mirror, _ := newTestMirror(t, server.URL)
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	status, err := mirror.GetMirrorStatus()
+	if err != nil {
+		t.Fatalf("GetMirrorStatus failed: %v", err)
+	}
+	dates, ok := status["metadata_dates"].(map[string]time.Time)
+	want := time.Date(2023, time.June, 10, 9, 2, 9, 0, time.UTC)
+	if !ok || !dates["bookworm"].Equal(want) {
+		t.Fatalf("expected the bookworm metadata date %v, got %v", want, status["metadata_dates"])
+	}
+}
+
+func TestMirrorDownloadStatistics(t *testing.T) {
+	mirror, basePath := newTestMirror(t, "http://deb.example/debian")
+
+	if count, err := mirror.GetDownloadedPackageCount(); err != nil || count != 0 {
+		t.Fatalf("expected no packages before any download, got %d (err %v)", count, err)
+	}
+
+	files := map[string]string{
+		"pool/main/h/hello/hello_2.10-3_amd64.deb":     "abc",
+		"pool/main/c/curl/curl_7.88.1-10_amd64.deb":    "abcde",
+		"pool/contrib/l/libfoo/libfoo_1.0_all.deb":     "abcdefg",
+		"pool/main/h/hello/hello_2.10-3.dsc":           "not a package",
+		"dists/bookworm/Release":                       "Suite: bookworm\n",
+		"dists/bookworm/main/binary-amd64/Packages.gz": "packages",
+	}
+	for name, content := range files {
+		path := filepath.Join(basePath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if count, err := mirror.GetDownloadedPackageCount(); err != nil || count != 3 {
+		t.Fatalf("expected 3 packages, got %d (err %v)", count, err)
+	}
+	if size, err := mirror.GetTotalDownloadedSize(); err != nil || size != 15 {
+		t.Fatalf("expected 15 package bytes, got %d (err %v)", size, err)
+	}
+	if size, err := mirror.GetMetadataSize(); err != nil || size != 24 {
+		t.Fatalf("expected 24 metadata bytes, got %d (err %v)", size, err)
+	}
+
+	status, err := mirror.GetMirrorStatus()
+	if err != nil {
+		t.Fatalf("GetMirrorStatus failed: %v", err)
+	}
+	if status["package_count"] != 3 || status["package_bytes"] != int64(15) || status["metadata_bytes"] != int64(24) {
+		t.Fatalf("unexpected statistics in status %v", status)
+	}
+}
+
+func TestMirrorExpandsAllAvailableArchitectures(t *testing.T) {
+	server := newSuiteServer(t, "bookworm")
+	defer server.Close()
+
+	mirror, basePath := newTestMirror(t, server.URL)
+	mirror.config.Architectures = []string{"*"}
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if got := mirror.ArchitecturesForSuite("bookworm"); !slices.Equal(got, []string{"amd64"}) {
+		t.Fatalf("expected * to expand to the Release architectures, got %v", got)
+	}
+	expanded, ok := mirror.GetMirrorInfo()["expanded_architectures"].(map[string][]string)
+	if !ok || !slices.Equal(expanded["bookworm"], []string{"amd64"}) {
+		t.Fatalf("expected GetMirrorInfo to report the expansion, got %v", mirror.GetMirrorInfo()["expanded_architectures"])
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "dists", "bookworm", "main", "binary-amd64", "Packages")); err != nil {
+		t.Fatalf("expected the amd64 index to be mirrored: %v", err)
+	}
+}
+
+func TestMirrorDryRunWritesNoPoolFiles(t *testing.T) {
+	server := newSuiteServer(t, "bookworm")
+	defer server.Close()
+
+	for _, writeMetadata := range []bool{false, true} {
+		mirror, basePath := newTestMirror(t, server.URL)
+		mirror.config.DownloadPackages = true
+		mirror.config.DryRun = true
+		mirror.config.WriteMetadata = writeMetadata
+
+		if err := mirror.Clone(); err != nil {
+			t.Fatalf("dry run failed: %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(basePath, "pool")); !os.IsNotExist(err) {
+			t.Fatalf("dry run must not create pool/ (writeMetadata=%v)", writeMetadata)
+		}
+		_, err := os.Stat(filepath.Join(basePath, "dists", "bookworm", "Release"))
+		if writeMetadata && err != nil {
+			t.Fatalf("expected Release with WriteMetadata: %v", err)
+		}
+		if !writeMetadata && !os.IsNotExist(err) {
+			t.Fatalf("dists/ must stay empty without WriteMetadata")
+		}
+
+		plan := mirror.Plan()
+		if plan == nil || len(plan.Entries) != 1 {
+			t.Fatalf("expected a plan with one entry, got %+v", plan)
+		}
+		entry := plan.Entries[0]
+		if entry.URL != server.URL+"/pool/main/h/hello/hello_2.10-3_amd64.deb" || entry.Size != 7 || entry.Skip {
+			t.Fatalf("unexpected plan entry: %+v", entry)
+		}
+		if plan.DownloadFiles != 1 || plan.DownloadBytes != 7 {
+			t.Fatalf("unexpected plan totals: %+v", plan)
+		}
+	}
+}
+
+func TestDuplicateStanzaAcrossSections(t *testing.T) {
+	packages := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\nSize: 7\n\n"
+	var release strings.Builder
+	release.WriteString("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64\nComponents: main contrib\nSHA256:\n")
+	for _, component := range []string{"main", "contrib"} {
+		fmt.Fprintf(&release, " %x %d %s/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages), component)
+	}
+
+	var poolHits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			w.Write([]byte(release.String()))
+		case "/dists/bookworm/main/binary-amd64/Packages", "/dists/bookworm/contrib/binary-amd64/Packages":
+			w.Write([]byte(packages))
+		case "/pool/main/h/hello/hello_2.10-3_amd64.deb":
+			if r.Method == http.MethodGet {
+				poolHits.Add(1)
+			}
+			w.Write([]byte("payload"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repo := NewRepository("dup", server.URL, "dup", "bookworm", []string{"main", "contrib"}, []string{"amd64"})
+	repo.DisableSignatureVerification()
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+	if len(repo.PackageMetadata) != 1 {
+		t.Fatalf("expected a single metadata entry, got %d", len(repo.PackageMetadata))
+	}
+	if got := repo.PackageMetadata[0].Sections; len(got) != 2 || got[0] != "main" || got[1] != "contrib" {
+		t.Fatalf("expected both sections recorded, got %v", got)
+	}
+
+	mirror, _ := newTestMirror(t, server.URL)
+	mirror.config.Components = []string{"main", "contrib"}
+	mirror.config.DownloadPackages = true
+	mirror.downloader.AlwaysVerifyChecksums = true // no size-based skip: a second listing would download again
+	mirror.ProgressHandler = func(MirrorEvent) {}
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if hits := poolHits.Load(); hits != 1 {
+		t.Fatalf("expected the pool file to be downloaded once, got %d downloads", hits)
+	}
+}
+
+// parseStanzas splits a Packages index into field maps keyed by package name.
+// Fields with continuation lines are recorded in multiline.
+func parseStanzas(t *testing.T, content string) (map[string]map[string]string, map[string]bool) {
+	t.Helper()
+
+	stanzas := make(map[string]map[string]string)
+	multiline := make(map[string]bool)
+	for _, block := range strings.Split(strings.TrimSpace(content), "\n\n") {
+		fields := make(map[string]string)
+		var last string
+		for _, line := range strings.Split(block, "\n") {
+			if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+				multiline[last] = true
+				continue
+			}
+			name, value, ok := strings.Cut(line, ":")
+			if !ok {
+				t.Fatalf("malformed line %q", line)
+			}
+			last = name
+			fields[name] = strings.TrimSpace(value)
+		}
+		stanzas[fields["Package"]] = fields
+	}
+	return stanzas, multiline
+}
+
+func TestPackagesRoundTripPreservesUbuntuFields(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "jammy_Packages"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dists/jammy/main/binary-amd64/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(original)
+	}))
+	defer server.Close()
+
+	repo := NewRepository("ubuntu", server.URL, "ubuntu", "jammy", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+
+	subset := []string{"bash", "curl", "libcurl4"}
+	var selected []Package
+	for _, name := range subset {
+		pkg, err := repo.GetPackageMetadata(name)
+		if err != nil {
+			t.Fatalf("package %s not found: %v", name, err)
+		}
+		selected = append(selected, *pkg)
+	}
+
+	metadataRoot := t.TempDir()
+	if err := WritePackagesMetadataToStorage(NewLocalStorage(metadataRoot), "", "jammy", map[string]map[string][]Package{"main": {"amd64": selected}}, true); err != nil {
+		t.Fatalf("WritePackagesMetadataToStorage failed: %v", err)
+	}
+	regenerated, err := os.ReadFile(filepath.Join(metadataRoot, "jammy", "main", "binary-amd64", "Packages"))
+	if err != nil {
+		t.Fatalf("failed to read regenerated index: %v", err)
+	}
+
+	want, multiline := parseStanzas(t, string(original))
+	got, _ := parseStanzas(t, string(regenerated))
+	if len(got) != len(subset) {
+		t.Fatalf("expected %d stanzas, got %d", len(subset), len(got))
+	}
+
+	for _, name := range subset {
+		// The Packages parser keeps only the first line of a field, so multi-line
+		// fields are the only intentional omissions.
+		for field, value := range want[name] {
+			if multiline[field] {
+				continue
+			}
+			if got[name][field] != value {
+				t.Errorf("%s: field %s = %q, want %q", name, field, got[name][field], value)
+			}
+		}
+		for field := range got[name] {
+			if _, ok := want[name][field]; !ok {
+				t.Errorf("%s: unexpected field %s", name, field)
+			}
+		}
+	}
+}
+
+func TestGeneratedReleaseVerifiesUncompressedPackages(t *testing.T) {
+	selected := []Package{{Name: "hello", Package: "hello", Version: "2.10-3", Architecture: "amd64", Filename: "pool/main/h/hello/hello_2.10-3_amd64.deb", Size: 5}}
+
+	for _, tc := range []struct {
+		name                string
+		includeUncompressed bool
+	}{
+		{"plain index on disk", true},
+		{"compressed indices only", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			storage := NewLocalStorage(filepath.Join(root, "dists"))
+			metadataRoot := storage.Root
+			if err := WritePackagesMetadataToStorage(storage, "", "custom", map[string]map[string][]Package{"main": {"amd64": selected}}, tc.includeUncompressed); err != nil {
+				t.Fatalf("WritePackagesMetadataToStorage failed: %v", err)
+			}
+			if err := WriteSignedReleaseFilesToStorage(storage, "", "custom", []string{"main"}, []string{"amd64"}, false, tc.includeUncompressed, nil); err != nil {
+				t.Fatalf("WriteSignedReleaseFilesToStorage failed: %v", err)
+			}
+
+			release, err := os.ReadFile(filepath.Join(metadataRoot, "custom", "Release"))
+			if err != nil {
+				t.Fatalf("failed to read Release: %v", err)
+			}
+			if strings.Count(string(release), " main/binary-amd64/Packages\n") != 2 {
+				t.Fatalf("expected MD5Sum and SHA256 entries for the uncompressed index:\n%s", release)
+			}
+
+			server := httptest.NewServer(http.FileServer(http.Dir(root)))
+			defer server.Close()
+
+			repo := NewRepository("custom", server.URL, "custom", "custom", []string{"main"}, []string{"amd64"})
+			repo.DisableSignatureVerification()
+			if !repo.VerifyRelease {
+				t.Fatal("expected Release verification to be enabled by default")
+			}
+			if _, err := repo.FetchPackages(); err != nil {
+				t.Fatalf("FetchPackages failed on the generated repository: %v", err)
+			}
+			if _, err := repo.GetPackageMetadata("hello"); err != nil {
+				t.Fatalf("hello not found: %v", err)
+			}
+		})
+	}
+}
+
+func TestWritePackagesMetadataIncludeUncompressed(t *testing.T) {
+	selected := []Package{{Name: "base-files", Package: "base-files", Version: "12.4", Architecture: "amd64", Filename: "pool/main/b/base-files/base-files_12.4_amd64.deb", Size: 5}}
+	storage := NewLocalStorage(t.TempDir())
+	archDir := filepath.Join(storage.Root, "custom", "main", "binary-amd64")
+
+	for _, includeUncompressed := range []bool{true, false} {
+		if err := WritePackagesMetadataToStorage(storage, "", "custom", map[string]map[string][]Package{"main": {"amd64": selected}}, includeUncompressed); err != nil {
+			t.Fatalf("WritePackagesMetadataToStorage failed: %v", err)
+		}
+		if err := WriteSignedReleaseFilesToStorage(storage, "", "custom", []string{"main"}, []string{"amd64"}, false, includeUncompressed, nil); err != nil {
+			t.Fatalf("WriteSignedReleaseFilesToStorage failed: %v", err)
+		}
+		release, err := os.ReadFile(filepath.Join(storage.Root, "custom", "Release"))
+		if err != nil {
+			t.Fatalf("failed to read Release: %v", err)
+		}
+
+		// The uncompressed entry is listed either way, apt looks it up after decompression
+		for _, name := range []string{"Packages", "Packages.gz", "Packages.xz"} {
+			if strings.Count(string(release), " main/binary-amd64/"+name+"\n") != 2 {
+				t.Fatalf("includeUncompressed=%v: expected MD5Sum and SHA256 entries for %s:\n%s", includeUncompressed, name, release)
+			}
+		}
+		for _, name := range []string{"Packages.gz", "Packages.xz"} {
+			assertPackagesIndex(t, filepath.Join(archDir, name), []string{"base-files"})
+		}
+
+		// Turning the option off removes the file written by the previous run
+		if includeUncompressed {
+			assertPackagesIndex(t, filepath.Join(archDir, "Packages"), []string{"base-files"})
+		} else if _, err := os.Stat(filepath.Join(archDir, "Packages")); !os.IsNotExist(err) {
+			t.Fatalf("expected no uncompressed Packages, got %v", err)
+		}
+	}
+}
+
+func TestMergePackagesMetadata(t *testing.T) {
+	stanza := func(name, version string) Package {
+		return Package{Name: name, Package: name, Version: version, Architecture: "amd64", Filename: "pool/main/" + name + "_" + version + "_amd64.deb", Size: 5}
+	}
+	metadataRoot := t.TempDir()
+	if err := WritePackagesMetadata(metadataRoot, "custom", map[string]map[string][]Package{"main": {"amd64": {stanza("curl", "1.0"), stanza("bash", "5.2")}}}); err != nil {
+		t.Fatalf("WritePackagesMetadata failed: %v", err)
+	}
+
+	added := map[string]map[string][]Package{
+		"main":    {"amd64": {stanza("libc6", "2.36"), stanza("curl", "1.1")}},
+		"contrib": {"amd64": {stanza("unrar", "6.2")}},
+	}
+	if err := MergePackagesMetadata(metadataRoot, "custom", added, true); err != nil {
+		t.Fatalf("MergePackagesMetadata failed: %v", err)
+	}
+
+	for _, name := range []string{"Packages", "Packages.gz", "Packages.xz"} {
+		assertPackagesIndex(t, filepath.Join(metadataRoot, "custom", "main", "binary-amd64", name), []string{"bash", "curl", "libc6"})
+		assertPackagesIndex(t, filepath.Join(metadataRoot, "custom", "contrib", "binary-amd64", name), []string{"unrar"})
+	}
+	merged, err := readPackagesIndex(&Repository{}, filepath.Join(metadataRoot, "custom", "main", "binary-amd64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged[1].Name != "curl" || merged[1].Version != "1.1" {
+		t.Fatalf("expected the added curl to replace the existing one, got %+v", merged[1])
+	}
+}
+
+func TestMirrorConfigSuiteOverrides(t *testing.T) {
+	config := MirrorConfig{
+		Components:    []string{"main", "contrib"},
+		Architectures: []string{"amd64", "arm64"},
+		SuiteOverrides: map[string]SuiteConfig{
+			"bookworm-security":  {Components: []string{"main"}},
+			"bookworm-backports": {Architectures: []string{"amd64"}},
+		},
+	}
+
+	if got := config.GetComponentsForSuite("bookworm-security"); len(got) != 1 || got[0] != "main" {
+		t.Fatalf("expected override components, got %v", got)
+	}
+	if got := config.GetArchitecturesForSuite("bookworm-security"); len(got) != 2 {
+		t.Fatalf("expected global architectures without an override, got %v", got)
+	}
+	if got := config.GetArchitecturesForSuite("bookworm-backports"); len(got) != 1 || got[0] != "amd64" {
+		t.Fatalf("expected override architectures, got %v", got)
+	}
+	if got := config.GetComponentsForSuite("bookworm"); len(got) != 2 {
+		t.Fatalf("expected global components for a suite without override, got %v", got)
+	}
+
+	config.SuiteOverrides["trixie"] = SuiteConfig{Components: []string{" "}}
+	config.BaseURL, config.Suites = "http://deb.example.com/debian", []string{"bookworm"}
+	if err := config.Validate(); err == nil {
+		t.Fatal("expected Validate to reject a blank override entry")
+	}
+
+	delete(config.SuiteOverrides, "trixie")
+	config.CompressionPreference = []string{".xz", ".lzma"}
+	if err := config.Validate(); err == nil {
+		t.Fatal("expected Validate to reject an unsupported compression format")
+	}
+
+	config.CompressionPreference = nil
+	config.RequireFingerprint = "6ED0E7B82643E131"
+	if err := config.Validate(); err == nil {
+		t.Fatal("expected Validate to reject a key ID as required fingerprint")
+	}
+	config.RequireFingerprint, config.SkipGPGVerify = "4CB5 0190 207B 4758 A3F7 3A79 6ED0 E7B8 2643 E131", true
+	if err := config.Validate(); err == nil {
+		t.Fatal("expected Validate to reject a required fingerprint without GPG verification")
+	}
+}
+
+func TestMirrorSuiteUsesOverride(t *testing.T) {
+	server := newSuiteServer(t, "bookworm", "bookworm-security")
+	defer server.Close()
+
+	mirror, basePath := newTestMirror(t, server.URL)
+	// The server only has main; contrib would fail unless the override applies.
+	mirror.config.Components = []string{"main", "contrib"}
+	mirror.config.SuiteOverrides = map[string]SuiteConfig{"bookworm-security": {Components: []string{"main"}}}
+
+	if err := mirror.AddSuite("bookworm-security"); err != nil {
+		t.Fatalf("AddSuite failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "dists", "bookworm-security", "contrib")); !os.IsNotExist(err) {
+		t.Fatal("expected contrib to be skipped for the overridden suite")
+	}
+}
+
+func TestMirrorAutoAdjustComponents(t *testing.T) {
+	server := newSuiteServer(t, "bookworm", "bullseye")
+	defer server.Close()
+
+	mirror, basePath := newTestMirror(t, server.URL)
+	// The server only lists main; non-free-firmware must be skipped, not fail the suite.
+	mirror.config.Components = []string{"main", "non-free-firmware"}
+	mirror.config.AutoAdjustComponents = true
+
+	var warnings []MirrorEvent
+	mirror.ProgressHandler = func(event MirrorEvent) {
+		if event.Type == MirrorEventWarning {
+			warnings = append(warnings, event)
+		}
+	}
+
+	if err := mirror.AddSuite("bullseye"); err != nil {
+		t.Fatalf("AddSuite failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "dists", "bullseye", "main", "binary-amd64", "Packages")); err != nil {
+		t.Fatalf("expected main to be mirrored: %v", err)
+	}
+	if len(warnings) == 0 || warnings[0].Component != "non-free-firmware" {
+		t.Fatalf("expected a warning for the dropped component, got %+v", warnings)
+	}
+}
+
+func TestMirrorVerifyLocalPackages(t *testing.T) {
+	server := newSuiteServer(t, "bookworm")
+	defer server.Close()
+
+	mirror, basePath := newTestMirror(t, server.URL)
+	mirror.config.DownloadPackages = true
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+
+	report, err := mirror.VerifyLocalPackages("bookworm", "main", "amd64")
+	if err != nil {
+		t.Fatalf("VerifyLocalPackages: %v", err)
+	}
+	if report.Checked != 1 || report.Valid != 1 || len(report.Errors) != 0 {
+		t.Fatalf("expected the fresh mirror to verify, got %+v", report)
+	}
+
+	// Same size, different content: only the checksum can catch it
+	debPath := filepath.Join(basePath, "pool", "main", "h", "hello", "hello_2.10-3_amd64.deb")
+	if err := os.WriteFile(debPath, []byte("tamper!"), FilePermission); err != nil {
+		t.Fatalf("tamper failed: %v", err)
+	}
+	report, err = mirror.VerifyLocalPackages("bookworm", "main", "amd64")
+	if err != nil {
+		t.Fatalf("VerifyLocalPackages: %v", err)
+	}
+	if report.Corrupted != 1 || report.Valid != 0 || len(report.Errors) != 1 || !errors.Is(report.Errors[0], ErrPoolFileCorrupted) {
+		t.Fatalf("expected the tampered file to be reported as corrupted, got %+v", report)
+	}
+	if report.Errors[0].Path != "pool/main/h/hello/hello_2.10-3_amd64.deb" {
+		t.Fatalf("unexpected path in report: %s", report.Errors[0].Path)
+	}
+
+	os.Remove(debPath)
+	report, _ = mirror.VerifyLocalPackages("bookworm", "main", "amd64")
+	if report.Missing != 1 || !errors.Is(report.Errors[0], ErrPoolFileMissing) {
+		t.Fatalf("expected the removed file to be reported as missing, got %+v", report)
+	}
+
+	if _, err := mirror.VerifyLocalPackages("bookworm", "contrib", "amd64"); err == nil {
+		t.Fatal("expected an error without a local Packages index")
+	}
+}
+
+func TestMirrorPackagesFileReportsEveryVariantFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dists/bookworm/main/binary-amd64/Packages.gz" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	mirror, _ := newTestMirror(t, server.URL)
+	err := mirror.downloadPackagesFile("bookworm", "main", "amd64")
+	if err == nil {
+		t.Fatal("expected every variant to fail")
+	}
+
+	indexURL := server.URL + "/dists/bookworm/main/binary-amd64/Packages"
+	for _, ext := range CompressionExtensions {
+		if !strings.Contains(err.Error(), indexURL+ext+": ") {
+			t.Errorf("error %q does not mention %s", err, indexURL+ext)
+		}
+	}
+	var status *HTTPStatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the 403 of Packages.gz, got %v", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Fatal("a forbidden variant must not match ErrNotFound")
+	}
+}
+
+func TestMirrorAllIndexVariants(t *testing.T) {
+	packages := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\n\n"
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(packages))
+	zw.Close()
+	release := fmt.Sprintf("Suite: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			fmt.Fprint(w, release)
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			fmt.Fprint(w, packages)
+		case "/dists/bookworm/main/binary-amd64/Packages.gz":
+			w.Write(compressed.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name                string
+		allVariants         bool
+		includeUncompressed bool
+		want                []string
+	}{
+		{"first variant only", false, false, []string{"Packages.gz"}},
+		{"every variant found upstream", true, false, []string{"Packages", "Packages.gz"}},
+		{"uncompressed index decompressed", false, true, []string{"Packages", "Packages.gz"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := MirrorConfig{
+				BaseURL:                server.URL,
+				Suites:                 []string{"bookworm"},
+				Components:             []string{"main"},
+				Architectures:          []string{"amd64"},
+				SkipGPGVerify:          true,
+				CompressionPreference:  []string{".gz", ".xz", ""},
+				MirrorAllIndexVariants: tc.allVariants,
+				IncludeUncompressed:    tc.includeUncompressed,
+			}
+			if err := config.Validate(); err != nil {
+				t.Fatalf("invalid configuration: %v", err)
+			}
+			basePath := t.TempDir()
+			mirror := NewMirror(config, basePath)
+			mirror.downloader.RetryAttempts = 1
+			mirror.metadataDownloader.RetryAttempts = 1
+
+			if err := mirror.Clone(); err != nil {
+				t.Fatalf("clone failed: %v", err)
+			}
+			if warnings := mirror.GetWarnings(); len(warnings) != 0 {
+				t.Fatalf("a missing variant must not warn, got %+v", warnings)
+			}
+
+			var got []string
+			for _, name := range []string{"Packages", "Packages.gz", "Packages.xz"} {
+				if _, err := os.Stat(filepath.Join(mirror.buildArchPath("bookworm", "main", "amd64"), name)); err == nil {
+					got = append(got, name)
+				}
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("mirrored %v under %s, want %v", got, basePath, tc.want)
+			}
+			plain, err := os.ReadFile(filepath.Join(mirror.buildArchPath("bookworm", "main", "amd64"), "Packages"))
+			if err == nil && string(plain) != packages {
+				t.Fatalf("unexpected uncompressed Packages:\n%s", plain)
+			}
+		})
+	}
+}
+
+func TestMirrorSkipMissingArchitecture(t *testing.T) {
+	packages := fmt.Sprintf("Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\nSize: 7\nSHA256: %x\n\n", sha256.Sum256([]byte("payload")))
+	release := fmt.Sprintf("Suite: bookworm\nArchitectures: amd64 arm64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+
+	for _, tc := range []struct {
+		name        string
+		arm64Status int
+		skipMissing bool
+		wantErr     bool
+	}{
+		{"not found is skipped", http.StatusNotFound, true, false},
+		{"not found aborts by default", http.StatusNotFound, false, true},
+		{"server error still aborts", http.StatusInternalServerError, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/dists/bookworm/Release" || r.URL.Path == "/dists/bookworm/InRelease":
+					fmt.Fprint(w, release)
+				case r.URL.Path == "/dists/bookworm/main/binary-amd64/Packages":
+					fmt.Fprint(w, packages)
+				case r.URL.Path == "/pool/main/h/hello/hello_2.10-3_amd64.deb":
+					fmt.Fprint(w, "payload")
+				case strings.HasPrefix(r.URL.Path, "/dists/bookworm/main/binary-arm64/"):
+					w.WriteHeader(tc.arm64Status)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			mirror, basePath := newTestMirror(t, server.URL)
+			mirror.config.Architectures = []string{"amd64", "arm64"}
+			mirror.config.DownloadPackages = true
+			mirror.config.SkipMissing = tc.skipMissing
+
+			err := mirror.Clone()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected the run to abort")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("clone failed: %v", err)
+			}
+
+			if got := mirror.SkippedCombinations(); !slices.Equal(got, []string{"bookworm/main/arm64"}) {
+				t.Fatalf("unexpected skipped combinations: %v", got)
+			}
+			warnings := mirror.GetWarnings()
+			if len(warnings) != 1 || warnings[0].Kind != WarningMissingIndex || warnings[0].Subject != "bookworm/main/arm64" {
+				t.Fatalf("expected a missing_index warning, got %+v", warnings)
+			}
+
+			for _, rel := range []string{"dists/bookworm/main/binary-amd64/Packages", "pool/main/h/hello/hello_2.10-3_amd64.deb"} {
+				if _, err := os.Stat(filepath.Join(basePath, filepath.FromSlash(rel))); err != nil {
+					t.Fatalf("expected the amd64 data to be complete: %v", err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(basePath, "dists", "bookworm", "main", "binary-arm64")); !os.IsNotExist(err) {
+				t.Fatalf("expected no directory for the skipped architecture, got %v", err)
+			}
+		})
+	}
+}
+
+// newComponentsServer serves bookworm with the given number of components, each
+// holding packagesPerComponent small pool files, and counts the TCP connections
+// opened to it.
+func newComponentsServer(tb testing.TB, components, packagesPerComponent int) (*httptest.Server, []string, *atomic.Int64) {
+	tb.Helper()
+
+	files := make(map[string]string)
+	var names []string
+	var release strings.Builder
+	release.WriteString("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64\n")
+	var checksums strings.Builder
+	for c := 0; c < components; c++ {
+		component := fmt.Sprintf("comp%d", c)
+		names = append(names, component)
+
+		var packages strings.Builder
+		for p := 0; p < packagesPerComponent; p++ {
+			name := fmt.Sprintf("pkg%d-%d", c, p)
+			poolPath := fmt.Sprintf("pool/%s/p/%s/%s_1.0_amd64.deb", component, name, name)
+			files["/"+poolPath] = name
+			fmt.Fprintf(&packages, "Package: %s\nVersion: 1.0\nArchitecture: amd64\nFilename: %s\nSize: %d\nSHA256: %x\n\n", name, poolPath, len(name), sha256.Sum256([]byte(name)))
+		}
+		files["/dists/bookworm/"+component+"/binary-amd64/Packages"] = packages.String()
+		fmt.Fprintf(&checksums, " %x %d %s/binary-amd64/Packages\n", sha256.Sum256([]byte(packages.String())), packages.Len(), component)
+	}
+	fmt.Fprintf(&release, "Components: %s\nSHA256:\n%s", strings.Join(names, " "), checksums.String())
+	files["/dists/bookworm/Release"] = release.String()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if content, ok := files[r.URL.Path]; ok {
+			w.Write([]byte(content))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	connections := &atomic.Int64{}
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	return server, names, connections
+}
+
+// countingTransport counts the requests it forwards to the default transport.
+type countingTransport struct {
+	indices, pool atomic.Int64
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, "/pool/") {
+		c.pool.Add(1)
+	} else {
+		c.indices.Add(1)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestMirrorSharedHTTPClient(t *testing.T) {
+	server, components, _ := newComponentsServer(t, 3, 2)
+	defer server.Close()
+
+	mirror := NewMirror(MirrorConfig{
+		BaseURL:          server.URL,
+		Suites:           []string{"bookworm"},
+		Components:       components,
+		Architectures:    []string{"amd64"},
+		DownloadPackages: true,
+		SkipGPGVerify:    true,
+	}, t.TempDir())
+	mirror.downloader.RetryAttempts = 1
+	mirror.metadataDownloader.RetryAttempts = 1
+	if mirror.SharedHTTPClient == nil {
+		t.Fatal("NewMirror did not set a shared HTTP client")
+	}
+
+	transport := &countingTransport{}
+	mirror.SharedHTTPClient = &http.Client{Transport: transport}
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+
+	if got := transport.pool.Load(); got != 6 {
+		t.Errorf("pool requests through the shared client = %d, want 6", got)
+	}
+	if transport.indices.Load() == 0 {
+		t.Error("no index request went through the shared client")
+	}
+}
+
+func BenchmarkMirrorSharedHTTPClient(b *testing.B) {
+	server, components, connections := newComponentsServer(b, 10, 20)
+	defer server.Close()
+
+	for _, shared := range []bool{true, false} {
+		b.Run(fmt.Sprintf("shared=%v", shared), func(b *testing.B) {
+			connections.Store(0)
+			for i := 0; i < b.N; i++ {
+				mirror := NewMirror(MirrorConfig{
+					BaseURL:          server.URL,
+					Suites:           []string{"bookworm"},
+					Components:       components,
+					Architectures:    []string{"amd64"},
+					DownloadPackages: true,
+					SkipGPGVerify:    true,
+					Queue:            NewDownloadQueue(defaultConcurrency),
+				}, b.TempDir())
+				if !shared {
+					// A transport of its own per mirror, as http.DefaultTransport would be
+					// for a single run, with its default of 2 idle connections per host
+					mirror.SharedHTTPClient = &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}
+				}
+				if err := mirror.Clone(); err != nil {
+					b.Fatalf("Clone failed: %v", err)
+				}
+				mirror.SharedHTTPClient.CloseIdleConnections()
+			}
+			b.ReportMetric(float64(connections.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
+
+func TestMirrorSkipMissingComponentFixture(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{
+			{Name: "hello", Version: "2.10-3"},
+			{Name: "firmware-misc", Version: "20230210-5", Component: "non-free-firmware"},
+		},
+	})
+	for _, ext := range []string{"", ".gz", ".xz"} {
+		fixture.NotFound("dists/bookworm/non-free-firmware/binary-amd64/Packages" + ext)
+	}
+
+	mirror, basePath := newTestMirror(t, fixture.URL)
+	mirror.config.Components = []string{"main", "non-free-firmware"}
+	mirror.config.DownloadPackages = true
+	mirror.config.SkipMissing = true
+
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	if got := mirror.SkippedCombinations(); !slices.Equal(got, []string{"bookworm/non-free-firmware/amd64"}) {
+		t.Fatalf("unexpected skipped combinations: %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, filepath.FromSlash(fixture.Filename("hello", "amd64")))); err != nil {
+		t.Fatalf("expected the main component to be mirrored: %v", err)
+	}
+}
+
+func TestMirrorIncludeInstaller(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Components: []string{"main", "contrib"},
+		Packages: []testsupport.Package{
+			{Name: "hello", Version: "2.10-3"},
+			{Name: "debootstrap-udeb", Version: "1.0.128", Architecture: "all", Source: "debootstrap", Udeb: true},
+			{Name: "netcfg", Version: "1.187", Udeb: true},
+		},
+	})
+
+	mirror, basePath := newTestMirror(t, fixture.URL)
+	mirror.config.Components = []string{"main", "contrib"}
+	mirror.config.DownloadPackages = true
+	mirror.config.IncludeInstaller = true
+
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+
+	for _, rel := range []string{
+		fixture.Filename("hello", "amd64"),
+		fixture.Filename("debootstrap-udeb", "all"),
+		fixture.Filename("netcfg", "amd64"),
+	} {
+		if !strings.HasPrefix(rel, "pool/main/") {
+			t.Fatalf("unexpected fixture path %q", rel)
+		}
+		if _, err := os.Stat(filepath.Join(basePath, filepath.FromSlash(rel))); err != nil {
+			t.Fatalf("expected %s in the mirror: %v", rel, err)
+		}
+	}
+	if matches, _ := filepath.Glob(filepath.Join(basePath, "dists", "bookworm", "main", "debian-installer", "binary-amd64", "Packages*")); len(matches) == 0 {
+		t.Fatal("expected the debian-installer index in the mirror")
+	}
+	for _, rel := range []string{"dists/bookworm/contrib/debian-installer", "pool/main/debian-installer"} {
+		if _, err := os.Stat(filepath.Join(basePath, filepath.FromSlash(rel))); !os.IsNotExist(err) {
+			t.Fatalf("expected no %s, got %v", rel, err)
+		}
+	}
+	if got := mirror.SkippedCombinations(); len(got) != 0 {
+		t.Fatalf("unexpected skipped combinations: %v", got)
+	}
+	if err := mirror.VerifyMirrorIntegrity("bookworm"); err != nil {
+		t.Fatalf("VerifyMirrorIntegrity failed: %v", err)
+	}
+
+	t.Run("corrupted installer index", func(t *testing.T) {
+		for _, ext := range []string{"", ".gz", ".xz"} {
+			fixture.Corrupt("dists/bookworm/main/debian-installer/binary-amd64/Packages" + ext)
+		}
+		t.Cleanup(fixture.Reset)
+
+		mirror, _ := newTestMirror(t, fixture.URL)
+		mirror.config.IncludeInstaller = true
+		if err := mirror.Clone(); err == nil {
+			t.Fatal("expected a corrupted debian-installer index to fail the clone")
+		}
+	})
+}
+
+func TestMirrorExtraIndices(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Architectures: []string{"amd64", "arm64"},
+		Packages:      []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+		Extra: map[string]string{
+			"main/dep11/Components-amd64.yml.gz": "amd64 components",
+			"main/dep11/Components-arm64.yml.gz": "arm64 components",
+			"main/dep11/Components-amd64.yml":    "listed but not served",
+			"main/dep11/icons-48x48.tar.gz":      "icons",
+			"main/cnf/Commands-amd64.xz":         "commands",
+			"main/i18n/Translation-en.bz2":       "translations",
+		},
+	})
+	fixture.NotFound("dists/bookworm/main/dep11/Components-amd64.yml")
+
+	mirror, basePath := newTestMirror(t, fixture.URL)
+	mirror.config.IncludeDEP11 = true
+	mirror.config.IncludeExtraIndices = []string{"cnf/Commands-*"}
+
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+
+	suiteDir := filepath.Join(basePath, "dists", "bookworm")
+	for rel, want := range map[string]bool{
+		"main/dep11/Components-amd64.yml.gz": true,
+		"main/dep11/icons-48x48.tar.gz":      true,
+		"main/cnf/Commands-amd64.xz":         true,
+		"main/dep11/Components-arm64.yml.gz": false,
+		"main/dep11/Components-amd64.yml":    false,
+		"main/i18n/Translation-en.bz2":       false,
+	} {
+		if _, err := os.Stat(filepath.Join(suiteDir, filepath.FromSlash(rel))); (err == nil) != want {
+			t.Errorf("%s mirrored: %v, want %v", rel, err == nil, want)
+		}
+	}
+	if err := mirror.VerifyMirrorIntegrity("bookworm"); err != nil {
+		t.Fatalf("VerifyMirrorIntegrity failed: %v", err)
+	}
+
+	// A Sync keeps the files already matching the Release file
+	requests := fixture.Requests("dists/bookworm/main/dep11/icons-48x48.tar.gz")
+	if err := mirror.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if got := fixture.Requests("dists/bookworm/main/dep11/icons-48x48.tar.gz"); got != requests {
+		t.Fatalf("expected the unchanged icons not to be downloaded again, got %d requests", got-requests)
+	}
+
+	if err := os.WriteFile(filepath.Join(suiteDir, "main", "cnf", "Commands-amd64.xz"), []byte("tampered"), FilePermission); err != nil {
+		t.Fatalf("failed to tamper with the index: %v", err)
+	}
+	if err := mirror.VerifyMirrorIntegrity("bookworm"); !errors.Is(err, ErrExtraIndexCorrupted) {
+		t.Fatalf("expected ErrExtraIndexCorrupted, got %v", err)
+	}
+
+	t.Run("corrupted upstream", func(t *testing.T) {
+		fixture.Corrupt("dists/bookworm/main/dep11/icons-48x48.tar.gz")
+		t.Cleanup(fixture.Reset)
+
+		mirror, _ := newTestMirror(t, fixture.URL)
+		mirror.config.IncludeDEP11 = true
+		if err := mirror.Clone(); !errors.Is(err, ErrExtraIndexCorrupted) {
+			t.Fatalf("expected ErrExtraIndexCorrupted, got %v", err)
+		}
+	})
+}
+
+func TestMirrorConfigRejectsInvalidExtraIndices(t *testing.T) {
+	for _, pattern := range []string{"", "/dep11/", "../cnf/", "cnf/[", "dep11/../../x"} {
+		config := MirrorConfig{
+			BaseURL:             "http://deb.debian.org/debian",
+			Suites:              []string{"bookworm"},
+			Components:          []string{"main"},
+			Architectures:       []string{"amd64"},
+			IncludeExtraIndices: []string{pattern},
+		}
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected pattern %q to be rejected", pattern)
+		}
+	}
+}
+
+func TestMirrorAllIndices(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Components:    []string{"main", "contrib"},
+		Architectures: []string{"amd64", "arm64"},
+		Packages:      []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+		Extra: map[string]string{
+			"main/i18n/Translation-en.bz2":    "translations",
+			"main/Contents-amd64.gz":          "contents",
+			"main/Contents-arm64.gz":          "arm64 contents",
+			"contrib/i18n/Translation-en.bz2": "contrib translations",
+		},
+	})
+
+	for _, tc := range []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"everything", nil, nil, []string{"main/Contents-amd64.gz", "main/i18n/Translation-en.bz2"}},
+		{"excluded translations", nil, []string{"Translation-*"}, []string{"main/Contents-amd64.gz"}},
+		{"included directory", []string{"main/i18n/"}, nil, []string{"main/i18n/Translation-en.bz2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mirror, basePath := newTestMirror(t, fixture.URL)
+			mirror.config.MirrorAllIndices = true
+			mirror.config.IndexInclude = tc.include
+			mirror.config.IndexExclude = tc.exclude
+			if err := mirror.Clone(); err != nil {
+				t.Fatalf("clone failed: %v", err)
+			}
+
+			suiteDir := filepath.Join(basePath, "dists", "bookworm")
+			var got []string
+			filepath.WalkDir(suiteDir, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					rel, _ := filepath.Rel(suiteDir, path)
+					if !strings.HasPrefix(rel, "main/binary-amd64/") && rel != "Release" && rel != "InRelease" {
+						got = append(got, filepath.ToSlash(rel))
+					}
+				}
+				return nil
+			})
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("mirrored %v, want %v", got, tc.want)
+			}
+			for _, variant := range []string{"Packages", "Packages.gz", "Packages.xz"} {
+				if _, err := os.Stat(filepath.Join(suiteDir, "main", "binary-amd64", variant)); err != nil && tc.include == nil {
+					t.Fatalf("expected every listed variant of the Packages index: %v", err)
+				}
+			}
+			if err := mirror.VerifyMirrorIntegrity("bookworm"); err != nil {
+				t.Fatalf("VerifyMirrorIntegrity failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestMirrorTimeouts(t *testing.T) {
+	mirror, _ := newTestMirror(t, "http://localhost")
+	if mirror.metadataDownloader.Timeout != defaultMetadataTimeout || mirror.downloader.Timeout != defaultPackageTimeout {
+		t.Fatalf("expected default timeouts, got %v and %v", mirror.metadataDownloader.Timeout, mirror.downloader.Timeout)
+	}
+
+	config := mirror.config
+	config.MetadataTimeout = time.Second
+	config.PackageTimeout = time.Hour
+	if err := mirror.UpdateConfiguration(config); err != nil {
+		t.Fatalf("UpdateConfiguration failed: %v", err)
+	}
+	if mirror.metadataDownloader.Timeout != time.Second || mirror.repository.MetadataTimeout != time.Second {
+		t.Errorf("MetadataTimeout not applied: %v, %v", mirror.metadataDownloader.Timeout, mirror.repository.MetadataTimeout)
+	}
+	if mirror.downloader.Timeout != time.Hour || mirror.repository.PackageTimeout != time.Hour {
+		t.Errorf("PackageTimeout not applied: %v, %v", mirror.downloader.Timeout, mirror.repository.PackageTimeout)
+	}
+}
+
+func TestMirrorStorage(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+	})
+	pool := fixture.Filename("hello", "amd64")
+
+	storage := NewMemoryStorage()
+	mirror, basePath := newTestMirror(t, fixture.URL)
+	mirror.config.DownloadPackages = true
+	mirror.config.Storage = storage
+	staging := t.TempDir()
+	mirror.downloader.TempDir = staging
+
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "pool")); !os.IsNotExist(err) {
+		t.Fatalf("expected no pool directory under the base path, got %v", err)
+	}
+	if entries, _ := os.ReadDir(staging); len(entries) != 0 {
+		t.Fatalf("expected the staging directory to be cleaned up, got %v", entries)
+	}
+	stored := storage.Paths()
+	for _, want := range []string{"dists/bookworm/Release", "dists/bookworm/main/binary-amd64/Packages", pool} {
+		if !slices.Contains(stored, want) {
+			t.Errorf("expected %s in storage, got %v", want, stored)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(basePath, filepath.FromSlash(pool))); !os.IsNotExist(err) {
+		t.Fatalf("expected pool files to only be kept in storage, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(basePath, "dists", "bookworm", "Release")); err != nil {
+		t.Fatalf("expected dists/ to be kept as working copy: %v", err)
+	}
+
+	requests := fixture.Requests(pool)
+	if err := mirror.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if got := fixture.Requests(pool); got != requests {
+		t.Fatalf("expected the stored pool file to be kept, got %d more requests", got-requests)
+	}
+
+	storage.Put(pool, strings.NewReader("corrupted"), -1, FilePermission)
+	if err := mirror.Sync(); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if got := fixture.Requests(pool); got != requests+1 {
+		t.Fatalf("expected a corrupted stored file to be downloaded again, got %d requests", got-requests)
+	}
+}
+
+// failingPoolStorage is a MemoryStorage refusing pool files.
+type failingPoolStorage struct {
+	*MemoryStorage
+}
+
+func (s failingPoolStorage) Put(p string, r io.Reader, size int64, mode os.FileMode) error {
+	if strings.HasPrefix(p, "pool/") {
+		return errors.New("bucket unavailable")
+	}
+	return s.MemoryStorage.Put(p, r, size, mode)
+}
+
+func TestMirrorStorageFailedPut(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+	})
+
+	mirror, _ := newTestMirror(t, fixture.URL)
+	mirror.config.DownloadPackages = true
+	mirror.config.Storage = failingPoolStorage{NewMemoryStorage()}
+	mirror.downloader.TempDir = t.TempDir()
+
+	if err := mirror.Clone(); err == nil || !strings.Contains(err.Error(), "bucket unavailable") {
+		t.Fatalf("expected a failed Put of a pool file to fail the mirror, got %v", err)
+	}
+}
+
+func TestWriteMetadataToStorage(t *testing.T) {
+	selected := []Package{{Name: "base-files", Package: "base-files", Version: "12.4", Architecture: "amd64", Filename: "pool/main/b/base-files/base-files_12.4_amd64.deb", Size: 5}}
+
+	storage := NewMemoryStorage()
+	if err := WritePackagesMetadataToStorage(storage, "dists", "custom", map[string]map[string][]Package{"main": {"amd64": selected}}, true); err != nil {
+		t.Fatalf("WritePackagesMetadataToStorage failed: %v", err)
+	}
+	if err := WriteSignedReleaseFilesToStorage(storage, "dists", "custom", []string{"main"}, []string{"amd64"}, false, true, nil); err != nil {
+		t.Fatalf("WriteSignedReleaseFilesToStorage failed: %v", err)
+	}
+
+	want := []string{
+		"dists/custom/InRelease",
+		"dists/custom/Release",
+		"dists/custom/main/binary-amd64/Packages",
+		"dists/custom/main/binary-amd64/Packages.gz",
+		"dists/custom/main/binary-amd64/Packages.xz",
+	}
+	if got := storage.Paths(); !slices.Equal(got, want) {
+		t.Fatalf("expected %v in storage, got %v", want, got)
+	}
+
+	file, err := storage.Open("dists/custom/Release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	release, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Packages", "Packages.gz", "Packages.xz"} {
+		if strings.Count(string(release), " main/binary-amd64/"+name+"\n") != 2 {
+			t.Fatalf("expected MD5Sum and SHA256 entries for %s:\n%s", name, release)
+		}
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	root := t.TempDir()
+	storage := NewLocalStorage(root)
+
+	if err := storage.Put("pool/main/h/hello.deb", strings.NewReader("payload"), 7, FilePermission); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := storage.Put("pool/main/h/short.deb", strings.NewReader("pay"), 7, FilePermission); !errors.Is(err, ErrTruncatedDownload) {
+		t.Fatalf("expected a short Put to fail, got %v", err)
+	}
+	if _, err := storage.Stat("pool/main/h/short.deb"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("a failed Put must not leave a file, got %v", err)
+	}
+	if err := storage.Put("../escape", strings.NewReader(""), 0, FilePermission); err == nil {
+		t.Fatal("expected a path outside the root to be rejected")
+	}
+
+	if err := storage.Rename("pool/main/h/hello.deb", "pool/main/h/hello_2.10-3_amd64.deb"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	pkg := &Package{Name: "hello", Size: 7, SHA256: fmt.Sprintf("%x", sha256.Sum256([]byte("payload")))}
+	d := NewDownloader()
+	if skip, err := d.ShouldSkipDownloadInStorage(storage, pkg, "pool/main/h/hello_2.10-3_amd64.deb"); err != nil || !skip {
+		t.Fatalf("expected the stored file to match, got skip=%v err=%v", skip, err)
+	}
+	if err := storage.Delete("pool/main/h/hello_2.10-3_amd64.deb"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if skip, err := d.ShouldSkipDownloadInStorage(storage, pkg, "pool/main/h/hello_2.10-3_amd64.deb"); err != nil || skip {
+		t.Fatalf("expected a deleted file to be downloaded, got skip=%v err=%v", skip, err)
+	}
+}
@@ -0,0 +1,235 @@
+package debian
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// nameIndexFilename is the name of the package name index stored next to the text
+// Packages cache of a suite by FetchAndCachePackages.
+const nameIndexFilename = "names.idx"
+
+// nameIndexHeader starts every name index file; it is bumped whenever the layout changes.
+const nameIndexHeader = "deb-for-all name index 1"
+
+// NameIndexEntry is what a NameIndex records of one binary package entry.
+type NameIndexEntry struct {
+	Name         string
+	Version      string
+	Architecture string
+	Section      string
+}
+
+// NameIndex is a compact index of the binary packages of a suite, sorted by name, for
+// looking packages up by name or name prefix in O(log n) without loading their metadata.
+type NameIndex struct {
+	entries []NameIndexEntry
+}
+
+// NewNameIndex returns the index of entries.
+func NewNameIndex(entries []NameIndexEntry) *NameIndex {
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b NameIndexEntry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return &NameIndex{entries: sorted}
+}
+
+// nameIndexOf returns the index of the packages of metadata.
+func nameIndexOf(metadata []Package) *NameIndex {
+	entries := make([]NameIndexEntry, len(metadata))
+	for i := range metadata {
+		entries[i] = NameIndexEntry{
+			Name:         metadata[i].Name,
+			Version:      metadata[i].Version,
+			Architecture: metadata[i].Architecture,
+			Section:      metadata[i].Section,
+		}
+	}
+	return NewNameIndex(entries)
+}
+
+// Len returns the number of entries of the index.
+func (x *NameIndex) Len() int {
+	return len(x.entries)
+}
+
+// Lookup returns the entries of the package named name, one per version and
+// architecture, or nil when the index has none.
+func (x *NameIndex) Lookup(name string) []NameIndexEntry {
+	start, found := slices.BinarySearchFunc(x.entries, name, compareEntryName)
+	if !found {
+		return nil
+	}
+	end := start + 1
+	for end < len(x.entries) && x.entries[end].Name == name {
+		end++
+	}
+	return slices.Clone(x.entries[start:end])
+}
+
+// Prefix returns the sorted distinct package names starting with prefix.
+func (x *NameIndex) Prefix(prefix string) []string {
+	start, _ := slices.BinarySearchFunc(x.entries, prefix, compareEntryName)
+	var names []string
+	for i := start; i < len(x.entries) && strings.HasPrefix(x.entries[i].Name, prefix); i++ {
+		if len(names) == 0 || names[len(names)-1] != x.entries[i].Name {
+			names = append(names, x.entries[i].Name)
+		}
+	}
+	return names
+}
+
+func compareEntryName(entry NameIndexEntry, name string) int {
+	return strings.Compare(entry.Name, name)
+}
+
+// Save writes the index to path, one entry per line.
+func (x *NameIndex) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), DirPermission); err != nil {
+		return fmt.Errorf("unable to create name index directory: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(nameIndexHeader + "\n")
+	for _, entry := range x.entries {
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\n", entry.Name, entry.Version, entry.Architecture, entry.Section)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), FilePermission); err != nil {
+		return fmt.Errorf("unable to write name index: %w", err)
+	}
+	return nil
+}
+
+// ReadNameIndex reads the index saved at path.
+func ReadNameIndex(path string) (*NameIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header, body, _ := bytes.Cut(data, []byte("\n"))
+	if string(header) != nameIndexHeader {
+		return nil, fmt.Errorf("%s is not a name index of this version", path)
+	}
+
+	entries := make([]NameIndexEntry, 0, bytes.Count(body, []byte("\n")))
+	for len(body) > 0 {
+		var line []byte
+		line, body, _ = bytes.Cut(body, []byte("\n"))
+		fields := strings.Split(string(line), "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("malformed name index entry %q in %s", line, path)
+		}
+		entries = append(entries, NameIndexEntry{Name: fields[0], Version: fields[1], Architecture: fields[2], Section: fields[3]})
+	}
+	// Entries were saved sorted; checking is cheaper than sorting again.
+	if !slices.IsSortedFunc(entries, func(a, b NameIndexEntry) int { return strings.Compare(a.Name, b.Name) }) {
+		return nil, fmt.Errorf("name index %s is not sorted", path)
+	}
+	return &NameIndex{entries: entries}, nil
+}
+
+// NameIndexPath returns the location of the name index for a suite inside a cache
+// directory populated by FetchAndCachePackages.
+func NameIndexPath(cacheDir, suite string) string {
+	return filepath.Join(cacheDir, suite, nameIndexFilename)
+}
+
+// LoadNameIndex reads the name index FetchAndCachePackages saved for the suite of r in
+// cacheDir and makes it the NameIndex of r until packages are loaded.
+func (r *Repository) LoadNameIndex(cacheDir string) (*NameIndex, error) {
+	if r.Suite == "" {
+		return nil, fmt.Errorf("suite is required to load the name index")
+	}
+	index, err := ReadNameIndex(NameIndexPath(cacheDir, r.Suite))
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nameIndex, r.nameIndexSource = index, nil
+	return index, nil
+}
+
+// NameIndex returns the name index of the loaded PackageMetadata, built on first use
+// after each fetch or load, or the index read by LoadNameIndex when no packages are
+// loaded. It returns nil when there is neither.
+func (r *Repository) NameIndex() *NameIndex {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nameIndex != nil && sameSlice(r.nameIndexSource, r.PackageMetadata) {
+		return r.nameIndex
+	}
+	if len(r.PackageMetadata) == 0 {
+		return nil
+	}
+	r.nameIndex, r.nameIndexSource = nameIndexOf(r.PackageMetadata), r.PackageMetadata
+	return r.nameIndex
+}
+
+// sameSlice reports whether a and b share the same elements in memory.
+func sameSlice(a, b []Package) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// CachedPackageNames returns the sorted names starting with prefix among the packages
+// indexed for suite in cacheDir, found by binary search so that shell completion
+// stays fast on a full suite.
+func CachedPackageNames(cacheDir, suite, prefix string) ([]string, error) {
+	index, err := ReadNameIndex(NameIndexPath(cacheDir, suite))
+	if err != nil {
+		return nil, err
+	}
+	return index.Prefix(prefix), nil
+}
+
+// scanNameIndexEntries returns the index entries of the stanzas of the Packages data,
+// reading only the fields the index keeps. Lines are not limited in length.
+func scanNameIndexEntries(data []byte) ([]NameIndexEntry, error) {
+	var entries []NameIndexEntry
+	var entry NameIndexEntry
+	flush := func() {
+		if entry.Name != "" {
+			entries = append(entries, entry)
+		}
+		entry = NameIndexEntry{}
+	}
+
+	lines := newLineReader(bytes.NewReader(data))
+	for lines.Scan() {
+		line := lines.line
+		if len(bytes.TrimSpace(line)) == 0 {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		key, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok {
+			continue
+		}
+		value = bytes.TrimSpace(value)
+		switch string(key) {
+		case "Package":
+			entry.Name = string(value)
+		case "Version":
+			entry.Version = string(value)
+		case "Architecture":
+			entry.Architecture = string(value)
+		case "Section":
+			entry.Section = string(value)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("unable to scan Packages data: %w", err)
+	}
+	flush()
+	return entries, nil
+}
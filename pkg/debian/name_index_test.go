@@ -0,0 +1,193 @@
+package debian
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/CeGenreDeChat/deb-for-all/internal/testsupport"
+)
+
+func TestNameIndex(t *testing.T) {
+	index := NewNameIndex([]NameIndexEntry{
+		{Name: "hello", Version: "2.10-3", Architecture: "amd64", Section: "devel"},
+		{Name: "bash", Version: "5.2.15-2", Architecture: "amd64", Section: "shells"},
+		{Name: "hello", Version: "2.10-3", Architecture: "arm64", Section: "devel"},
+		{Name: "hello-traditional", Version: "2.10-6", Architecture: "amd64", Section: "devel"},
+	})
+
+	if index.Len() != 4 {
+		t.Fatalf("expected 4 entries, got %d", index.Len())
+	}
+	if got := index.Lookup("hello"); len(got) != 2 || got[0].Architecture != "amd64" || got[1].Architecture != "arm64" {
+		t.Errorf("unexpected entries for hello: %+v", got)
+	}
+	if got := index.Lookup("hell"); got != nil {
+		t.Errorf("expected no entry for a prefix, got %+v", got)
+	}
+	if got := index.Prefix("hel"); !slices.Equal(got, []string{"hello", "hello-traditional"}) {
+		t.Errorf("unexpected prefix match: %v", got)
+	}
+	if got := index.Prefix(""); !slices.Equal(got, []string{"bash", "hello", "hello-traditional"}) {
+		t.Errorf("expected every name for an empty prefix, got %v", got)
+	}
+	if got := index.Prefix("zz"); len(got) != 0 {
+		t.Errorf("expected no match, got %v", got)
+	}
+
+	path := NameIndexPath(t.TempDir(), "bookworm")
+	if err := index.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	read, err := ReadNameIndex(path)
+	if err != nil {
+		t.Fatalf("ReadNameIndex failed: %v", err)
+	}
+	if !reflect.DeepEqual(read, index) {
+		t.Errorf("index changed by a round trip: %+v", read)
+	}
+
+	if err := os.WriteFile(path, []byte("hello\n"), FilePermission); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadNameIndex(path); err == nil {
+		t.Error("expected a file of another format to be rejected")
+	}
+}
+
+func TestFetchAndCachePackagesNameIndex(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Components: []string{"main", "contrib"},
+		Packages: []testsupport.Package{
+			{Name: "hello", Version: "2.10-3", Component: "main", Section: "devel"},
+			{Name: "hello", Version: "2.10-4", Component: "contrib", Section: "devel"},
+			{Name: "help2man", Version: "1.49.3-1", Component: "main", Section: "devel"},
+			{Name: "bash", Version: "5.2.15-2", Component: "main", Section: "shells"},
+		},
+	})
+	cacheDir := t.TempDir()
+
+	repo := newFilterTestRepository(fixture.URL, []string{"main", "contrib"})
+	if err := repo.FetchAndCachePackages(cacheDir); err != nil {
+		t.Fatalf("FetchAndCachePackages failed: %v", err)
+	}
+
+	names, err := CachedPackageNames(cacheDir, "bookworm", "hel")
+	if err != nil {
+		t.Fatalf("CachedPackageNames failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"hello", "help2man"}) {
+		t.Errorf("unexpected cached names: %v", names)
+	}
+	if _, err := CachedPackageNames(cacheDir, "trixie", "hel"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing index to be reported, got %v", err)
+	}
+
+	loaded := newFilterTestRepository(fixture.URL, []string{"main", "contrib"})
+	index, err := loaded.LoadNameIndex(cacheDir)
+	if err != nil {
+		t.Fatalf("LoadNameIndex failed: %v", err)
+	}
+	if loaded.NameIndex() != index {
+		t.Error("expected the loaded index to be used while no packages are loaded")
+	}
+	want := []NameIndexEntry{
+		{Name: "hello", Version: "2.10-3", Architecture: "amd64", Section: "devel"},
+		{Name: "hello", Version: "2.10-4", Architecture: "amd64", Section: "devel"},
+	}
+	if got := index.Lookup("hello"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := loaded.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+	fetched := loaded.NameIndex()
+	if fetched == index || fetched.Len() != len(loaded.PackageMetadata) {
+		t.Fatalf("expected the index of the fetched packages, got %d entries", fetched.Len())
+	}
+	if got := fetched.Lookup("hello"); len(got) != 1 || got[0].Version != "2.10-3" {
+		t.Errorf("unexpected fetched entries for hello: %+v", got)
+	}
+	if loaded.NameIndex() != fetched {
+		t.Error("expected the fetched index to be built once")
+	}
+}
+
+// bookwormPackageCount is about the number of amd64 packages of bookworm main.
+const bookwormPackageCount = 63000
+
+func BenchmarkCachedPackageNames(b *testing.B) {
+	cacheDir := b.TempDir()
+	data, err := os.ReadFile(writeTextCache(b, cacheDir, bookwormPackageCount))
+	if err != nil {
+		b.Fatal(err)
+	}
+	entries, err := scanNameIndexEntries(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := NewNameIndex(entries).Save(NameIndexPath(cacheDir, "bookworm")); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if names, err := CachedPackageNames(cacheDir, "bookworm", "pkg4242"); err != nil || len(names) == 0 {
+			b.Fatalf("lookup failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkPackageNamesFullParse is the prefix lookup of BenchmarkCachedPackageNames
+// done by parsing the text cache.
+func BenchmarkPackageNamesFullParse(b *testing.B) {
+	cacheDir := b.TempDir()
+	writeTextCache(b, cacheDir, bookwormPackageCount)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		names, err := newCacheTestRepository().LoadCachedPackages(cacheDir)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var matches []string
+		for _, name := range names {
+			if strings.HasPrefix(name, "pkg4242") {
+				matches = append(matches, name)
+			}
+		}
+		if len(matches) == 0 {
+			b.Fatal("no match")
+		}
+	}
+}
+
+func TestScanLongStanzaLines(t *testing.T) {
+	provides := "Provides: " + strings.Repeat("virtual-package, ", 2*packagesBufferSize/17)
+	data := "Package: huge\nVersion: 1.0\nArchitecture: amd64\n" + provides + "\nSection: misc\n\nPackage: hello\nVersion: 2.10-3\n"
+
+	entries, err := scanNameIndexEntries([]byte(data))
+	if err != nil {
+		t.Fatalf("scanNameIndexEntries failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Section != "misc" || entries[1].Name != "hello" {
+		t.Fatalf("unexpected entries after a long line: %+v", entries)
+	}
+
+	var names []string
+	err = scanStanzas(strings.NewReader(data), func(stanza deb822Stanza) {
+		names = append(names, stanza.fields[0].value)
+	}, func(line int, msg string) {
+		t.Errorf("unexpected malformed line %d: %s", line, msg)
+	})
+	if err != nil {
+		t.Fatalf("scanStanzas failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"huge", "hello"}) {
+		t.Fatalf("unexpected stanzas after a long line: %v", names)
+	}
+}
@@ -0,0 +1,252 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObjectCache stores downloaded pool files by SHA256 so that builds writing to
+// different destination pools fetch each file only once. Objects are hard-linked
+// into place when the cache and the destination share a filesystem, and copied
+// otherwise.
+//
+// Use is recorded in the modification time of a stamp file next to each object,
+// Dir/<first 2 hex digits>/<sha256>.used, rather than in the access time of the
+// object, which is often not maintained (noatime, relatime). The times of objects
+// are left alone, as they are shared with the files hard-linked to them; eviction
+// removes the least recently used objects first.
+type ObjectCache struct {
+	Dir      string // Root directory; objects live in Dir/<first 2 hex digits>/<sha256>
+	MaxBytes int64  // Size limit enforced after each Put; 0 means unlimited
+
+	mu sync.Mutex
+}
+
+// NewObjectCache creates the cache directory if needed and returns a cache limited
+// to maxBytes (0 for no limit).
+func NewObjectCache(dir string, maxBytes int64) (*ObjectCache, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, fmt.Errorf("object cache directory is required")
+	}
+	if err := os.MkdirAll(dir, DirPermission); err != nil {
+		return nil, fmt.Errorf("unable to create object cache directory: %w", err)
+	}
+	return &ObjectCache{Dir: dir, MaxBytes: maxBytes}, nil
+}
+
+// Get places the object with the given SHA256 at destPath and reports whether it
+// was cached. A cached object whose content no longer matches its hash is removed
+// and reported as a miss.
+func (c *ObjectCache) Get(sha256sum, destPath string) (bool, error) {
+	objectPath, err := c.objectPath(sha256sum)
+	if err != nil {
+		return false, err
+	}
+
+	actual, err := sha256File(objectPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to read cached object %s: %w", sha256sum, err)
+	}
+	if actual != strings.ToLower(sha256sum) {
+		os.Remove(objectPath)
+		os.Remove(objectPath + stampSuffix)
+		return false, nil
+	}
+
+	if err := touchStamp(objectPath); err != nil {
+		return false, fmt.Errorf("unable to update cached object %s: %w", sha256sum, err)
+	}
+
+	if sameFile(objectPath, destPath) {
+		return true, nil // Already linked in place
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), DirPermission); err != nil {
+		return false, fmt.Errorf("unable to create parent directory: %w", err)
+	}
+	if err := linkOrCopy(objectPath, destPath); err != nil {
+		return false, fmt.Errorf("unable to place cached object at %s: %w", destPath, err)
+	}
+
+	return true, nil
+}
+
+// Put adds srcPath to the cache under sha256sum, which the caller must have
+// verified, then evicts old objects beyond MaxBytes.
+func (c *ObjectCache) Put(sha256sum, srcPath string) error {
+	objectPath, err := c.objectPath(sha256sum)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(objectPath); err == nil {
+		return touchStamp(objectPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), DirPermission); err != nil {
+		return fmt.Errorf("unable to create object cache directory: %w", err)
+	}
+	if err := linkOrCopy(srcPath, objectPath); err != nil {
+		return fmt.Errorf("unable to store %s in object cache: %w", filepath.Base(srcPath), err)
+	}
+	if err := touchStamp(objectPath); err != nil {
+		return fmt.Errorf("unable to store %s in object cache: %w", filepath.Base(srcPath), err)
+	}
+
+	return c.Evict()
+}
+
+// Evict removes the least recently used objects until the cache fits in MaxBytes.
+func (c *ObjectCache) Evict() error {
+	if c.MaxBytes <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type object struct {
+		path    string
+		size    int64
+		lastUse time.Time
+	}
+
+	var objects []object
+	var total int64
+	err := filepath.WalkDir(c.Dir, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if entry.IsDir() || strings.HasSuffix(path, ".partial") || strings.HasSuffix(path, stampSuffix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil // Removed concurrently
+		}
+		lastUse := info.ModTime()
+		if stamp, err := os.Stat(path + stampSuffix); err == nil {
+			lastUse = stamp.ModTime()
+		}
+		objects = append(objects, object{path: path, size: info.Size(), lastUse: lastUse})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to scan object cache: %w", err)
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].lastUse.Before(objects[j].lastUse)
+	})
+
+	for _, obj := range objects {
+		if total <= c.MaxBytes {
+			break
+		}
+		if err := os.Remove(obj.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("unable to evict %s: %w", filepath.Base(obj.path), err)
+		}
+		os.Remove(obj.path + stampSuffix)
+		total -= obj.size
+	}
+
+	return nil
+}
+
+// stampSuffix names the stamp file recording the last use of an object.
+const stampSuffix = ".used"
+
+// touchStamp records that the object at objectPath was used now.
+func touchStamp(objectPath string) error {
+	stampPath := objectPath + stampSuffix
+	now := time.Now()
+	if err := os.Chtimes(stampPath, now, now); err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(stampPath, nil, FilePermission)
+}
+
+// sameFile reports whether a and b are the same file, e.g. hard links to one inode.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// objectPath returns where the object for sha256sum is stored.
+func (c *ObjectCache) objectPath(sha256sum string) (string, error) {
+	sum := strings.ToLower(sha256sum)
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid SHA256 %q", sha256sum)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("invalid SHA256 %q", sha256sum)
+	}
+	return filepath.Join(c.Dir, sum[:2], sum), nil
+}
+
+// sha256File returns the hex SHA256 of the file at path.
+func sha256File(path string) (string, error) {
+	return hashFile(path, sha256.New())
+}
+
+// linkOrCopy makes dst a hard link to src, falling back to copying through a
+// staging file that keeps the modification time of src. An existing dst is replaced.
+func linkOrCopy(src, dst string) error {
+	os.Remove(dst)
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.partial")
+	if err != nil {
+		return err
+	}
+	stagingPath := out.Name()
+	defer os.Remove(stagingPath)
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(stagingPath, FilePermission); err != nil {
+		return err
+	}
+	if err := os.Chtimes(stagingPath, time.Time{}, info.ModTime()); err != nil {
+		return err
+	}
+
+	return os.Rename(stagingPath, dst)
+}
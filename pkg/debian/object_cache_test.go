@@ -0,0 +1,192 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeObject writes content to a temp file and returns its path and SHA256.
+func writeObject(t *testing.T, dir, name, content string) (string, string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), FilePermission); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+	return path, fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+}
+
+func TestObjectCacheGetPut(t *testing.T) {
+	cache, err := NewObjectCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewObjectCache failed: %v", err)
+	}
+	src, sum := writeObject(t, t.TempDir(), "hello.deb", "payload")
+
+	dest := filepath.Join(t.TempDir(), "pool", "main", "h", "hello", "hello.deb")
+	if hit, err := cache.Get(sum, dest); err != nil || hit {
+		t.Fatalf("expected a miss on an empty cache, got hit=%v err=%v", hit, err)
+	}
+	if err := cache.Put(sum, src); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if hit, err := cache.Get(sum, dest); err != nil || !hit {
+		t.Fatalf("expected a hit after Put, got hit=%v err=%v", hit, err)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "payload" {
+		t.Fatalf("unexpected content at destination: %q", data)
+	}
+
+	// A corrupted object is dropped instead of being served
+	objectPath, _ := cache.objectPath(sum)
+	os.Remove(objectPath)
+	os.WriteFile(objectPath, []byte("tampered"), FilePermission)
+	if hit, err := cache.Get(sum, filepath.Join(t.TempDir(), "again.deb")); err != nil || hit {
+		t.Fatalf("expected a corrupted object to miss, got hit=%v err=%v", hit, err)
+	}
+	if _, err := os.Stat(objectPath); !os.IsNotExist(err) {
+		t.Fatal("expected the corrupted object to be removed")
+	}
+
+	if err := cache.Put("not-a-hash", src); err == nil {
+		t.Fatal("expected Put to reject an invalid SHA256")
+	}
+}
+
+func TestObjectCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, err := NewObjectCache(t.TempDir(), 20)
+	if err != nil {
+		t.Fatalf("NewObjectCache failed: %v", err)
+	}
+	srcDir := t.TempDir()
+
+	var sums []string
+	for i, name := range []string{"a", "b", "c"} {
+		src, sum := writeObject(t, srcDir, name, fmt.Sprintf("object-%s-0", name)) // 10 bytes each
+		if err := cache.Put(sum, src); err != nil {
+			t.Fatalf("Put %s failed: %v", name, err)
+		}
+		objectPath, _ := cache.objectPath(sum)
+		used := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(objectPath+stampSuffix, used, used)
+		sums = append(sums, sum)
+
+		if i == 1 {
+			// Using a makes b the least recently used object
+			if hit, _ := cache.Get(sums[0], filepath.Join(t.TempDir(), "a")); !hit {
+				t.Fatal("expected a to be cached")
+			}
+		}
+	}
+
+	for i, want := range []bool{true, false, true} {
+		objectPath, _ := cache.objectPath(sums[i])
+		_, err := os.Stat(objectPath)
+		if present := err == nil; present != want {
+			t.Errorf("object %d present=%v, want %v", i, present, want)
+		}
+	}
+}
+
+func TestObjectCacheKeepsObjectTimes(t *testing.T) {
+	cache, err := NewObjectCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewObjectCache failed: %v", err)
+	}
+	src, sum := writeObject(t, t.TempDir(), "hello.deb", "payload")
+	upstream := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(src, upstream, upstream)
+
+	if err := cache.Put(sum, src); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "hello.deb")
+	for range 2 {
+		if hit, err := cache.Get(sum, dest); err != nil || !hit {
+			t.Fatalf("expected a hit, got hit=%v err=%v", hit, err)
+		}
+	}
+	if err := cache.Put(sum, src); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	for _, path := range []string{src, dest} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(upstream) {
+			t.Errorf("expected %s to keep its modification time %v, got %v", path, upstream, info.ModTime())
+		}
+	}
+	if !sameFile(src, dest) {
+		t.Error("expected the destination to stay linked to the cached object")
+	}
+	objectPath, _ := cache.objectPath(sum)
+	if stamp, err := os.Stat(objectPath + stampSuffix); err != nil || !stamp.ModTime().After(upstream) {
+		t.Errorf("expected use to be recorded in the stamp file, got %v", err)
+	}
+}
+
+func TestDownloadMultipleSharedCacheFetchesOnce(t *testing.T) {
+	contents := map[string]string{"/pool/a.deb": "package-a", "/pool/b.deb": "package-b", "/pool/c.deb": "package-c"}
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := contents[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	packagesFor := func(paths ...string) []*Package {
+		var pkgs []*Package
+		for _, path := range paths {
+			pkgs = append(pkgs, &Package{
+				Name:        filepath.Base(path),
+				DownloadURL: server.URL + path,
+				Filename:    filepath.Base(path),
+				SHA256:      fmt.Sprintf("%x", sha256.Sum256([]byte(contents[path]))),
+			})
+		}
+		return pkgs
+	}
+
+	cache, err := NewObjectCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("NewObjectCache failed: %v", err)
+	}
+	downloader := NewDownloader()
+	downloader.Cache = cache
+
+	for _, paths := range [][]string{{"/pool/a.deb", "/pool/b.deb"}, {"/pool/b.deb", "/pool/c.deb"}} {
+		destDir := t.TempDir()
+		if errs := downloader.DownloadMultiple(packagesFor(paths...), destDir, 2); len(errs) > 0 {
+			t.Fatalf("DownloadMultiple failed: %v", errs)
+		}
+		for _, path := range paths {
+			if data, _ := os.ReadFile(filepath.Join(destDir, filepath.Base(path))); string(data) != contents[path] {
+				t.Fatalf("unexpected content for %s: %q", path, data)
+			}
+		}
+	}
+
+	for path := range contents {
+		if hits[path] != 1 {
+			t.Errorf("%s fetched %d times, want 1", path, hits[path])
+		}
+	}
+}
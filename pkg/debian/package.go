@@ -1,12 +1,21 @@
 package debian
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // File permission constants used across the package.
@@ -15,9 +24,33 @@ const (
 	FilePermission = 0644 // Default file permission
 )
 
-// Compression extensions supported for Packages files.
+// changelogBaseURL is the root of the Debian changelog service.
+var changelogBaseURL = "https://changelogs.debian.org/changelogs"
+
+// archiveAreas lists the Debian archive areas a Section may name directly.
+var archiveAreas = []string{"main", "contrib", "non-free", "non-free-firmware"}
+
+// Compression extensions supported for Packages files, in the default order they are
+// tried; "" is the uncompressed index.
 var CompressionExtensions = []string{"", ".gz", ".xz"}
 
+// validateCompressionPreference checks that exts is a non-empty list of supported
+// compression extensions without duplicates.
+func validateCompressionPreference(exts []string) error {
+	if len(exts) == 0 {
+		return fmt.Errorf("compression preference lists no format")
+	}
+	for i, ext := range exts {
+		if !slices.Contains(CompressionExtensions, ext) {
+			return fmt.Errorf("unsupported compression extension %q (supported: \"\", .gz, .xz)", ext)
+		}
+		if slices.Contains(exts[:i], ext) {
+			return fmt.Errorf("compression extension %q listed twice", ext)
+		}
+	}
+	return nil
+}
+
 // Package represents a Debian binary package with all standard control file fields.
 // It is the central abstraction for package metadata in the library.
 type Package struct {
@@ -36,10 +69,12 @@ type Package struct {
 	MD5sum      string
 	SHA1        string
 	SHA256      string
+	SHA512      string
 
 	// Classification fields
 	Source        string
 	Section       string
+	Sections      []string // Repository components (e.g. main, contrib) that list this exact stanza
 	Priority      string
 	Essential     string
 	InstalledSize string
@@ -85,9 +120,11 @@ type Package struct {
 	// Origin and distribution
 	Origin string
 	Bugs   string
+	Suite  string // Suite of the repository the entry was read from; not a control field
 
 	// Custom fields (X- prefixed or unknown)
-	CustomFields map[string]string
+	CustomFields     map[string]string
+	CustomFieldOrder []string // Names of CustomFields in the order they were read
 }
 
 // SourcePackage represents a Debian source package with its associated files.
@@ -100,6 +137,18 @@ type SourcePackage struct {
 	Files       []SourceFile // Associated source files
 }
 
+// IsValidDirectory reports whether Directory is a pool path safe to build file URLs
+// from: once its leading and trailing slashes are trimmed, it starts with "pool/" and
+// has no ".." segment. An absolute http(s) URL, which some third-party repositories
+// publish, is accepted under the same ".." rule.
+func (s *SourcePackage) IsValidDirectory() bool {
+	if checkSourceDirectory(s.Directory) != nil {
+		return false
+	}
+	directory := normalizeSourceDirectory(s.Directory)
+	return hasHTTPScheme(directory) || strings.HasPrefix(directory, "pool/")
+}
+
 // SourceFile represents a single file within a source package.
 type SourceFile struct {
 	Name      string
@@ -146,6 +195,45 @@ func NewSourcePackage(name, version, maintainer, description, directory string)
 	}
 }
 
+// ToJSON encodes p as JSON, every field under its Go name, e.g. for a "show --json"
+// output or to store selected packages. PackageFromJSON decodes it.
+func (p *Package) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// ToJSONIndented is ToJSON indented with two spaces, for display.
+func (p *Package) ToJSONIndented() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// PackageFromJSON decodes a Package encoded by ToJSON.
+func PackageFromJSON(data []byte) (*Package, error) {
+	var pkg Package
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("invalid package JSON: %w", err)
+	}
+	return &pkg, nil
+}
+
+// ToJSON encodes sp and its files as JSON. SourcePackageFromJSON decodes it.
+func (sp *SourcePackage) ToJSON() ([]byte, error) {
+	return json.Marshal(sp)
+}
+
+// ToJSONIndented is ToJSON indented with two spaces, for display.
+func (sp *SourcePackage) ToJSONIndented() ([]byte, error) {
+	return json.MarshalIndent(sp, "", "  ")
+}
+
+// SourcePackageFromJSON decodes a SourcePackage encoded by ToJSON.
+func SourcePackageFromJSON(data []byte) (*SourcePackage, error) {
+	var sp SourcePackage
+	if err := json.Unmarshal(data, &sp); err != nil {
+		return nil, fmt.Errorf("invalid source package JSON: %w", err)
+	}
+	return &sp, nil
+}
+
 // AddFile adds a source file to the package.
 func (sp *SourcePackage) AddFile(name, url string, size int64, md5sum, sha256sum, fileType string) {
 	sp.Files = append(sp.Files, SourceFile{
@@ -173,6 +261,31 @@ func (sp *SourcePackage) GetDSCFile() *SourceFile {
 	return sp.findFileByType("dsc", ".dsc")
 }
 
+// GetAllFiles returns a copy of the package's files sorted by name.
+func (sp *SourcePackage) GetAllFiles() []SourceFile {
+	files := slices.Clone(sp.Files)
+	slices.SortFunc(files, func(a, b SourceFile) int { return strings.Compare(a.Name, b.Name) })
+	return files
+}
+
+// GetFilesByType returns the files whose Type is fileType ("dsc", "orig", "debian"
+// or "file"), sorted by name.
+func (sp *SourcePackage) GetFilesByType(fileType string) []SourceFile {
+	var files []SourceFile
+	for _, file := range sp.GetAllFiles() {
+		if file.Type == fileType {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// HasAllRequiredFiles reports whether the package has a .dsc, an .orig.tar.* and a
+// .debian.tar.* file, i.e. everything a non-native source package needs to build.
+func (sp *SourcePackage) HasAllRequiredFiles() bool {
+	return sp.GetDSCFile() != nil && sp.GetOrigTarball() != nil && sp.GetDebianTarball() != nil
+}
+
 // findFileByType searches for a file by type or name pattern.
 func (sp *SourcePackage) findFileByType(fileType, namePattern string) *SourceFile {
 	for i := range sp.Files {
@@ -198,6 +311,45 @@ func (sp *SourcePackage) DownloadWithProgress(destDir string, progressCallback f
 	return sp.downloadFiles(destDir, true, progressCallback)
 }
 
+// DownloadParallel downloads the source files concurrently, at most maxConcurrent at a
+// time (all of them when maxConcurrent is not positive), without console output. Each
+// file is verified against its checksum once downloaded. progressCallback may be
+// called from several goroutines at once. The error of the first failing file, in
+// the order of Files, is returned once every download has finished.
+func (sp *SourcePackage) DownloadParallel(destDir string, maxConcurrent int, progressCallback func(filename string, downloaded, total int64)) error {
+	if len(sp.Files) == 0 {
+		return fmt.Errorf("no files to download for source package %s", sp.Name)
+	}
+	if err := os.MkdirAll(destDir, DirPermission); err != nil {
+		return fmt.Errorf("unable to create destination directory: %w", err)
+	}
+	if maxConcurrent <= 0 || maxConcurrent > len(sp.Files) {
+		maxConcurrent = len(sp.Files)
+	}
+
+	downloader := NewDownloader()
+	errs := make([]error, len(sp.Files))
+	slots := make(chan struct{}, maxConcurrent)
+	var wg sync.WaitGroup
+	for i, file := range sp.Files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			errs[i] = sp.downloadSingleFile(downloader, file, destDir, false, progressCallback)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // downloadFiles is the internal implementation for downloading source files.
 func (sp *SourcePackage) downloadFiles(destDir string, verbose bool, progressCallback func(string, int64, int64)) error {
 	if len(sp.Files) == 0 {
@@ -225,6 +377,13 @@ func (sp *SourcePackage) downloadFiles(destDir string, verbose bool, progressCal
 
 // downloadSingleFile downloads and verifies a single source file.
 func (sp *SourcePackage) downloadSingleFile(downloader *Downloader, file SourceFile, destDir string, verbose bool, progressCallback func(string, int64, int64)) error {
+	if file.URL == "" {
+		return fmt.Errorf("no URL specified for file %s", file.Name)
+	}
+	if err := checkFileName(file.Name); err != nil {
+		return err
+	}
+
 	destPath := filepath.Join(destDir, file.Name)
 
 	if verbose {
@@ -305,11 +464,127 @@ func (sp *SourcePackage) String() string {
 }
 
 // GetSourceName returns the source package name, falling back to the package name.
+// A version in parentheses ("Source: curl (7.81.0-1)") is not part of the name.
 func (p *Package) GetSourceName() string {
-	if p.Source == "" {
-		return p.Name
+	if name, _, _ := strings.Cut(p.Source, " "); name != "" {
+		return name
+	}
+	return p.Name
+}
+
+// ErrUnknownDependencyType is returned by GetDependencyCount for a relationship type
+// that is not one of the Package dependency fields.
+var ErrUnknownDependencyType = fmt.Errorf("unknown dependency type")
+
+// GetDependencyCount returns the number of entries of one relationship field. depType
+// is a control field name ("Pre-Depends") or the Package field name ("PreDepends"),
+// compared case-insensitively.
+func (p *Package) GetDependencyCount(depType string) (int, error) {
+	getter, ok := dependencyFieldGetters[normalizeDependencyType(depType)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownDependencyType, depType)
+	}
+	return len(getter(p)), nil
+}
+
+// GetTotalDependencyCount returns the number of entries across all relationship fields.
+func (p *Package) GetTotalDependencyCount() int {
+	total := 0
+	for _, getter := range dependencyFieldGetters {
+		total += len(getter(p))
+	}
+	return total
+}
+
+// dependencyFieldNames lists the relationship control fields in the order summaries
+// present them.
+var dependencyFieldNames = []string{"Depends", "Pre-Depends", "Recommends", "Suggests", "Enhances", "Breaks", "Conflicts", "Provides", "Replaces"}
+
+// GetDependencySummary returns the entries of each non-empty relationship field,
+// keyed by control field name ("Pre-Depends").
+func (p *Package) GetDependencySummary() map[string][]string {
+	summary := make(map[string][]string)
+	for _, field := range dependencyFieldNames {
+		if entries := dependencyFieldGetters[normalizeDependencyType(field)](p); len(entries) > 0 {
+			summary[field] = entries
+		}
+	}
+	return summary
+}
+
+// GetFormattedDependencies returns one line per non-empty relationship field, such
+// as "Recommends (2): curl, ca-certificates", Depends first. It is empty for a
+// package without relationships.
+func (p *Package) GetFormattedDependencies() string {
+	summary := p.GetDependencySummary()
+	lines := make([]string, 0, len(summary))
+	for _, field := range dependencyFieldNames {
+		if entries, ok := summary[field]; ok {
+			lines = append(lines, fmt.Sprintf("%s (%d): %s", field, len(entries), strings.Join(entries, ", ")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// normalizeDependencyType maps "Pre-Depends", "pre-depends" and "PreDepends" to one key.
+func normalizeDependencyType(depType string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(depType)), "-", "")
+}
+
+// getSourceVersion returns the version of the source package, given in parentheses
+// in Source when it differs from Version (binNMUs, e.g. "foo (1.0-1)" for 1.0-1+b1).
+func (p *Package) getSourceVersion() string {
+	_, rest, _ := strings.Cut(p.Source, " ")
+	if version, found := strings.CutPrefix(strings.TrimSpace(rest), "("); found {
+		if version, found = strings.CutSuffix(version, ")"); found && strings.TrimSpace(version) != "" {
+			return strings.TrimSpace(version)
+		}
 	}
-	return p.Source
+	return p.Version
+}
+
+// GetChangelogURL returns the URL of the package's changelog on the Debian
+// changelog service. The archive area comes from Section ("contrib/games" is in
+// contrib) and defaults to main; the version is that of the source package and the
+// epoch is not part of the path.
+func (p *Package) GetChangelogURL() string {
+	area := "main"
+	if before, _, found := strings.Cut(p.Section, "/"); found {
+		area = before
+	} else if slices.Contains(archiveAreas, p.Section) {
+		area = p.Section
+	}
+
+	sourceName := p.GetSourceName()
+	version := p.getSourceVersion()
+	if _, upstream, found := strings.Cut(version, ":"); found {
+		version = upstream
+	}
+
+	return fmt.Sprintf("%s/pool/%s/%s/%s/%s_%s/changelog", changelogBaseURL, area, getPoolPrefix(sourceName), sourceName, sourceName, version)
+}
+
+// HasConflictWith reports whether p and other conflict, checking the Conflicts
+// field of both packages. Versioned conflicts such as "libssl1.0 (<< 1.1)" only
+// apply when the other package's version satisfies the constraint.
+func (p *Package) HasConflictWith(other Package) bool {
+	return conflictsWith(p.Conflicts, other) || conflictsWith(other.Conflicts, *p)
+}
+
+// conflictsWith reports whether any entry in conflicts matches target.
+func conflictsWith(conflicts []string, target Package) bool {
+	for _, entry := range conflicts {
+		for _, alternative := range strings.Split(entry, "|") {
+			name, op, version := parseRelation(alternative)
+			if name != target.Name {
+				continue
+			}
+			if versionSatisfies(target.Version, op, version) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // GetDownloadInfo fetches HTTP metadata for the package via a HEAD request.
@@ -333,6 +608,51 @@ func (p *Package) GetDownloadInfo() (*DownloadInfo, error) {
 	}, nil
 }
 
+// ComputeSHA512 sets SHA512 to the checksum of the package file at path.
+func (p *Package) ComputeSHA512(path string) error {
+	checksum, err := hashFile(path, sha512.New())
+	if err != nil {
+		return fmt.Errorf("unable to compute SHA512 of %s: %w", path, err)
+	}
+	p.SHA512 = checksum
+	return nil
+}
+
+// ComputeMissingChecksums fills in the SHA256 and MD5 checksums that the files of sp
+// lack by hashing their copies in destDir, where Download puts them. Files not
+// downloaded yet are left as they are.
+func (sp *SourcePackage) ComputeMissingChecksums(destDir string) error {
+	for i := range sp.Files {
+		file := &sp.Files[i]
+		if file.SHA256Sum != "" && file.MD5Sum != "" {
+			continue
+		}
+		if err := checkFileName(file.Name); err != nil {
+			return err
+		}
+
+		path := filepath.Join(destDir, file.Name)
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if file.SHA256Sum == "" {
+			checksum, err := hashFile(path, sha256.New())
+			if err != nil {
+				return fmt.Errorf("unable to compute SHA256 of %s: %w", path, err)
+			}
+			file.SHA256Sum = checksum
+		}
+		if file.MD5Sum == "" {
+			checksum, err := hashFile(path, md5.New())
+			if err != nil {
+				return fmt.Errorf("unable to compute MD5 of %s: %w", path, err)
+			}
+			file.MD5Sum = checksum
+		}
+	}
+	return nil
+}
+
 // ReadControlFile parses a Debian control file and returns a Package.
 func ReadControlFile(filePath string) (*Package, error) {
 	data, err := os.ReadFile(filePath)
@@ -351,6 +671,105 @@ func (p *Package) WriteControlFile(filePath string) error {
 	return nil
 }
 
+// AppendToPackagesFile appends the Packages index stanza of p, formatted as by
+// WritePackagesMetadata, to the uncompressed Packages file at path, creating it when
+// missing. A repository built one .deb at a time thus avoids rewriting its index;
+// compress it once the batch is complete.
+func (p *Package) AppendToPackagesFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, FilePermission)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", path, err)
+	}
+	tail := make([]byte, min(info.Size(), 2))
+	if _, err := file.ReadAt(tail, info.Size()-int64(len(tail))); err != nil {
+		return fmt.Errorf("unable to read %s: %w", path, err)
+	}
+
+	if _, err := file.WriteString(stanzaSeparator(tail) + formatPackagesFile([]Package{*p})); err != nil {
+		return fmt.Errorf("unable to append to %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// AppendToCompressedPackagesFile is AppendToPackagesFile for a Packages.gz or
+// Packages.xz file, chosen by the extension of path; other paths are taken as
+// uncompressed. Neither format can be appended to in place: the whole index is
+// decompressed and rewritten, so for many packages append to the uncompressed file
+// and compress it periodically instead.
+func (p *Package) AppendToCompressedPackagesFile(path string) error {
+	ext := filepath.Ext(path)
+	var write func(string, []byte) error
+	switch ext {
+	case ".gz":
+		write = writeGzipFile
+	case ".xz":
+		write = writeXZFile
+	case "":
+		return p.AppendToPackagesFile(path)
+	default:
+		return fmt.Errorf("unsupported compression format: %s", ext)
+	}
+
+	var content []byte
+	file, err := os.Open(path)
+	switch {
+	case err == nil:
+		content, err = readDecompressed(file, ext)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("unable to open %s: %w", path, err)
+	}
+
+	content = append(content, stanzaSeparator(content[max(len(content)-2, 0):])...)
+	content = append(content, formatPackagesFile([]Package{*p})...)
+
+	// Replace the index only once the new one is complete
+	partialPath := path + ".partial"
+	if err := write(partialPath, content); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	if err := os.Rename(partialPath, path); err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("unable to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// readDecompressed returns the content of r decompressed according to ext.
+func readDecompressed(r io.Reader, ext string) ([]byte, error) {
+	decompressed, cleanup, err := (&Repository{}).createDecompressor(r, ext)
+	if err != nil {
+		return nil, err
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+	return io.ReadAll(decompressed)
+}
+
+// stanzaSeparator returns what to write after an index ending with tail, its last
+// bytes, so that a new stanza starts after a blank line.
+func stanzaSeparator(tail []byte) string {
+	switch {
+	case len(tail) == 0 || strings.HasSuffix(string(tail), "\n\n"):
+		return ""
+	case strings.HasSuffix(string(tail), "\n"):
+		return "\n"
+	default:
+		return "\n\n"
+	}
+}
+
 // FormatAsControl formats the package metadata as a Debian control file string.
 func (p *Package) FormatAsControl() string {
 	var sb strings.Builder
@@ -430,10 +849,8 @@ func (p *Package) FormatAsControl() string {
 		}
 	}
 
-	if p.CustomFields != nil {
-		for field, value := range p.CustomFields {
-			sb.WriteString(field + ": " + value + "\n")
-		}
+	for _, field := range p.customFieldNames() {
+		sb.WriteString(field + ": " + p.CustomFields[field] + "\n")
 	}
 
 	if p.Description != "" {
@@ -494,6 +911,19 @@ var dependencyFieldMapping = map[string]func(*Package, []string){
 	"replaces":    func(p *Package, v []string) { p.Replaces = v },
 }
 
+// dependencyFieldGetters maps normalized dependency field names to Package slice getters.
+var dependencyFieldGetters = map[string]func(*Package) []string{
+	"depends":    func(p *Package) []string { return p.Depends },
+	"predepends": func(p *Package) []string { return p.PreDepends },
+	"recommends": func(p *Package) []string { return p.Recommends },
+	"suggests":   func(p *Package) []string { return p.Suggests },
+	"enhances":   func(p *Package) []string { return p.Enhances },
+	"breaks":     func(p *Package) []string { return p.Breaks },
+	"conflicts":  func(p *Package) []string { return p.Conflicts },
+	"provides":   func(p *Package) []string { return p.Provides },
+	"replaces":   func(p *Package) []string { return p.Replaces },
+}
+
 // parseControlData parses a Debian control file content into a Package.
 func parseControlData(content string) (*Package, error) {
 	lines := strings.Split(content, "\n")
@@ -528,7 +958,7 @@ func parseControlData(content string) (*Package, error) {
 		}
 
 		// Unknown field - store in CustomFields
-		pkg.CustomFields[field] = value
+		pkg.setCustomField(field, value)
 	}
 
 	if pkg.Package == "" || pkg.Version == "" || pkg.Architecture == "" || pkg.Maintainer == "" {
@@ -538,6 +968,40 @@ func parseControlData(content string) (*Package, error) {
 	return pkg, nil
 }
 
+// setCustomField stores an unknown field, remembering the order of first appearance.
+func (p *Package) setCustomField(field, value string) {
+	if p.CustomFields == nil {
+		p.CustomFields = make(map[string]string)
+	}
+	if _, exists := p.CustomFields[field]; !exists {
+		p.CustomFieldOrder = append(p.CustomFieldOrder, field)
+	}
+	p.CustomFields[field] = value
+}
+
+// customFieldNames returns the CustomFields names in the order they were read,
+// followed by any set directly on the map, sorted.
+func (p *Package) customFieldNames() []string {
+	names := make([]string, 0, len(p.CustomFields))
+	seen := make(map[string]bool, len(p.CustomFields))
+	for _, field := range p.CustomFieldOrder {
+		if _, ok := p.CustomFields[field]; ok && !seen[field] {
+			names = append(names, field)
+			seen[field] = true
+		}
+	}
+
+	var extra []string
+	for field := range p.CustomFields {
+		if !seen[field] {
+			extra = append(extra, field)
+		}
+	}
+	sort.Strings(extra)
+
+	return append(names, extra...)
+}
+
 // parsePackageList parses a comma-separated dependency list.
 func parsePackageList(value string) []string {
 	if value == "" {
@@ -0,0 +1,461 @@
+package debian
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestHasConflictWith(t *testing.T) {
+	mta := Package{Name: "postfix", Version: "3.7.6-0+deb12u2", Conflicts: []string{"exim4-daemon-light", "sendmail-bin"}}
+	exim := Package{Name: "exim4-daemon-light", Version: "4.96-15"}
+	hello := Package{Name: "hello", Version: "2.10-3"}
+
+	if !mta.HasConflictWith(exim) || !exim.HasConflictWith(mta) {
+		t.Fatalf("expected postfix and exim4-daemon-light to conflict in both directions")
+	}
+	if mta.HasConflictWith(hello) {
+		t.Fatalf("did not expect postfix to conflict with hello")
+	}
+}
+
+func TestHasConflictWithVersionConstraint(t *testing.T) {
+	pkg := Package{Name: "libssl3", Version: "3.0.11-1", Conflicts: []string{"libssl1.0 (<< 1.1)"}}
+
+	if !pkg.HasConflictWith(Package{Name: "libssl1.0", Version: "1.0.2u-1"}) {
+		t.Fatalf("expected conflict with libssl1.0 below 1.1")
+	}
+	if pkg.HasConflictWith(Package{Name: "libssl1.0", Version: "1.1.0-1"}) {
+		t.Fatalf("did not expect conflict with libssl1.0 at 1.1")
+	}
+}
+
+func TestVersionSatisfies(t *testing.T) {
+	tests := []struct {
+		version, op, constraint string
+		want                    bool
+	}{
+		{"1.0", "<<", "1.0", false},
+		{"0.9", "<<", "1.0", true},
+		{"1.0", "<=", "1.0", true},
+		{"1.0", "<", "1.0", true}, // Deprecated, means <=
+		{"1.1", "<", "1.0", false},
+		{"1.0", "=", "1.0", true},
+		{"1.0", ">=", "1.0", true},
+		{"1.0", ">", "1.0", true}, // Deprecated, means >=
+		{"0.9", ">", "1.0", false},
+		{"1.0", ">>", "1.0", false},
+		{"1.1", ">>", "1.0", true},
+		{"1.0", "", "", true},
+	}
+	for _, tt := range tests {
+		if got := versionSatisfies(tt.version, tt.op, tt.constraint); got != tt.want {
+			t.Errorf("%s %s %s: got %v, want %v", tt.version, tt.op, tt.constraint, got, tt.want)
+		}
+	}
+
+	pkg := Package{Name: "bar", Version: "2.0", Conflicts: []string{"foo (< 1.0)"}}
+	if !pkg.HasConflictWith(Package{Name: "foo", Version: "1.0"}) {
+		t.Fatal("expected foo (< 1.0) to match foo 1.0")
+	}
+}
+
+func TestFindConflicts(t *testing.T) {
+	repo := &Repository{}
+	pkgs := []Package{
+		{Name: "postfix", Version: "3.7.6", Conflicts: []string{"exim4-daemon-light"}},
+		{Name: "exim4-daemon-light", Version: "4.96-15"},
+		{Name: "hello", Version: "2.10-3"},
+	}
+
+	conflicts := repo.FindConflicts(pkgs)
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflicting pair, got %d", len(conflicts))
+	}
+	if conflicts[0][0].Name != "postfix" || conflicts[0][1].Name != "exim4-daemon-light" {
+		t.Fatalf("unexpected conflicting pair: %s/%s", conflicts[0][0].Name, conflicts[0][1].Name)
+	}
+}
+
+func TestGetChangelogURL(t *testing.T) {
+	tests := []struct {
+		pkg  Package
+		want string
+	}{
+		{Package{Name: "hello", Version: "2.10-2", Section: "main"}, "https://changelogs.debian.org/changelogs/pool/main/h/hello/hello_2.10-2/changelog"},
+		{Package{Name: "hello", Version: "2.10-2"}, "https://changelogs.debian.org/changelogs/pool/main/h/hello/hello_2.10-2/changelog"},
+		{Package{Name: "hello", Version: "2.10-2", Section: "devel"}, "https://changelogs.debian.org/changelogs/pool/main/h/hello/hello_2.10-2/changelog"},
+		{Package{Name: "frozen-bubble", Version: "2.212-12", Section: "contrib/games"}, "https://changelogs.debian.org/changelogs/pool/contrib/f/frozen-bubble/frozen-bubble_2.212-12/changelog"},
+		{Package{Name: "libcurl4", Version: "7.88.1-10", Source: "curl", Section: "libs"}, "https://changelogs.debian.org/changelogs/pool/main/c/curl/curl_7.88.1-10/changelog"},
+		{Package{Name: "libc6", Version: "1:2.36-9", Source: "glibc"}, "https://changelogs.debian.org/changelogs/pool/main/g/glibc/glibc_2.36-9/changelog"},
+		{Package{Name: "libfoo1", Version: "1.0-1+b1", Source: "foo (1.0-1)"}, "https://changelogs.debian.org/changelogs/pool/main/f/foo/foo_1.0-1/changelog"},
+		{Package{Name: "libbar1", Version: "1:2.0-3+b2", Source: "bar (1:2.0-3)"}, "https://changelogs.debian.org/changelogs/pool/main/b/bar/bar_2.0-3/changelog"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.pkg.GetChangelogURL(); got != tt.want {
+			t.Errorf("GetChangelogURL(%s %s) = %s, want %s", tt.pkg.Name, tt.pkg.Version, got, tt.want)
+		}
+	}
+}
+
+func TestGetDependencyCount(t *testing.T) {
+	deps := func(n int) []string {
+		result := make([]string, n)
+		for i := range result {
+			result[i] = fmt.Sprintf("lib%d", i)
+		}
+		return result
+	}
+	repo := &Repository{PackageMetadata: []Package{
+		{Name: "base-files", Depends: deps(0)},
+		{Name: "hello", Depends: deps(3), Recommends: deps(1)},
+		{Name: "gimp", Depends: deps(7), PreDepends: deps(2), Suggests: deps(4)},
+	}}
+
+	gimp := &repo.PackageMetadata[2]
+	for _, depType := range []string{"Depends", "depends", "DEPENDS"} {
+		if n, err := gimp.GetDependencyCount(depType); err != nil || n != 7 {
+			t.Fatalf("GetDependencyCount(%q) = %d, %v; want 7", depType, n, err)
+		}
+	}
+	for _, depType := range []string{"Pre-Depends", "PreDepends"} {
+		if n, err := gimp.GetDependencyCount(depType); err != nil || n != 2 {
+			t.Fatalf("GetDependencyCount(%q) = %d, %v; want 2", depType, n, err)
+		}
+	}
+	if _, err := gimp.GetDependencyCount("Requires"); !errors.Is(err, ErrUnknownDependencyType) {
+		t.Fatalf("expected ErrUnknownDependencyType, got %v", err)
+	}
+	if got := gimp.GetTotalDependencyCount(); got != 13 {
+		t.Fatalf("GetTotalDependencyCount() = %d, want 13", got)
+	}
+
+	names := func(pkgs []Package) []string {
+		var result []string
+		for _, p := range pkgs {
+			result = append(result, p.Name)
+		}
+		return result
+	}
+	if got := names(repo.GetPackagesByDependencyCount("Depends", 3)); !reflect.DeepEqual(got, []string{"hello", "gimp"}) {
+		t.Fatalf("unexpected packages with at least 3 depends: %v", got)
+	}
+	if got := names(repo.GetPackagesByDependencyCount("depends", 0)); len(got) != 3 {
+		t.Fatalf("expected every package for minCount 0, got %v", got)
+	}
+	if got := repo.GetPackagesByDependencyCount("Requires", 0); got != nil {
+		t.Fatalf("expected no packages for an unknown type, got %v", names(got))
+	}
+}
+
+func TestSourcePackageFiles(t *testing.T) {
+	sp := NewSourcePackage("hello", "2.10-3", "", "", "pool/main/h/hello")
+	sp.AddFile("hello_2.10.orig.tar.gz", "", 0, "", "", "orig")
+	sp.AddFile("hello_2.10-3.dsc", "", 0, "", "", "dsc")
+	sp.AddFile("hello_2.10.orig.tar.gz.asc", "", 0, "", "", "file")
+
+	names := func(files []SourceFile) []string {
+		var result []string
+		for _, f := range files {
+			result = append(result, f.Name)
+		}
+		return result
+	}
+	if got := names(sp.GetAllFiles()); !reflect.DeepEqual(got, []string{"hello_2.10-3.dsc", "hello_2.10.orig.tar.gz", "hello_2.10.orig.tar.gz.asc"}) {
+		t.Fatalf("unexpected file order: %v", got)
+	}
+	if sp.Files[0].Name != "hello_2.10.orig.tar.gz" {
+		t.Fatal("GetAllFiles must not reorder Files")
+	}
+	if got := names(sp.GetFilesByType("dsc")); !reflect.DeepEqual(got, []string{"hello_2.10-3.dsc"}) {
+		t.Fatalf("unexpected dsc files: %v", got)
+	}
+	if got := sp.GetFilesByType("debian"); got != nil {
+		t.Fatalf("expected no debian files, got %v", names(got))
+	}
+
+	if sp.HasAllRequiredFiles() {
+		t.Fatal("a package without a .debian.tar must be incomplete")
+	}
+	sp.AddFile("hello_2.10-3.debian.tar.xz", "", 0, "", "", "debian")
+	if !sp.HasAllRequiredFiles() {
+		t.Fatal("expected a complete package")
+	}
+	if (&SourcePackage{}).HasAllRequiredFiles() {
+		t.Fatal("a package without files must be incomplete")
+	}
+}
+
+func TestSourcePackageComputeMissingChecksums(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello_2.10-3.dsc"), []byte("Format: 3.0 (quilt)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hello_2.10-3.debian.tar.xz"), []byte("debian"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sp := NewSourcePackage("hello", "2.10-3", "", "", "pool/main/h/hello")
+	sp.AddFile("hello_2.10-3.dsc", "", 0, "f9d3a4f1c3e1fa1e4e2e5d7b3a3f1d2c", "", "dsc")
+	sp.AddFile("hello_2.10-3.debian.tar.xz", "", 0, "", "recorded", "debian")
+	sp.AddFile("hello_2.10.orig.tar.gz", "", 0, "", "", "orig")
+
+	if err := sp.ComputeMissingChecksums(dir); err != nil {
+		t.Fatalf("ComputeMissingChecksums failed: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("Format: 3.0 (quilt)\n"))); sp.Files[0].SHA256Sum != want {
+		t.Fatalf("got SHA256 %q, want %q", sp.Files[0].SHA256Sum, want)
+	}
+	if sp.Files[0].MD5Sum != "f9d3a4f1c3e1fa1e4e2e5d7b3a3f1d2c" {
+		t.Fatalf("the recorded MD5 must be kept, got %q", sp.Files[0].MD5Sum)
+	}
+	if sp.Files[1].SHA256Sum != "recorded" || sp.Files[1].MD5Sum != "6e9552c9bd8e61c8f277c21220160234" {
+		t.Fatalf("expected only the MD5 to be computed, got %+v", sp.Files[1])
+	}
+	if sp.Files[2].SHA256Sum != "" || sp.Files[2].MD5Sum != "" {
+		t.Fatalf("a file not downloaded must be left alone, got %+v", sp.Files[2])
+	}
+}
+
+func TestSourcePackageDownloadParallel(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	contents := map[string]string{
+		"hello_2.10-3.dsc":           "Format: 3.0 (quilt)\n",
+		"hello_2.10.orig.tar.gz":     "upstream",
+		"hello_2.10-3.debian.tar.xz": "packaging",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := contents[path.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		time.Sleep(delay)
+		io.WriteString(w, content)
+	}))
+	defer server.Close()
+
+	newSource := func() *SourcePackage {
+		sp := NewSourcePackage("hello", "2.10-3", "", "", "")
+		for _, name := range []string{"hello_2.10-3.dsc", "hello_2.10.orig.tar.gz", "hello_2.10-3.debian.tar.xz"} {
+			sp.AddFile(name, server.URL+"/pool/main/h/hello/"+name, int64(len(contents[name])), "", fmt.Sprintf("%x", sha256.Sum256([]byte(contents[name]))), "")
+		}
+		return sp
+	}
+
+	destDir := t.TempDir()
+	var progress sync.Map
+	start := time.Now()
+	if err := newSource().DownloadParallel(destDir, 3, func(filename string, downloaded, total int64) {
+		progress.Store(filename, downloaded)
+	}); err != nil {
+		t.Fatalf("DownloadParallel failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 3*delay {
+		t.Fatalf("expected concurrent downloads to take less than %v, took %v", 3*delay, elapsed)
+	}
+	for name, content := range contents {
+		got, err := os.ReadFile(filepath.Join(destDir, name))
+		if err != nil || string(got) != content {
+			t.Fatalf("unexpected %s: %q (err: %v)", name, got, err)
+		}
+		if _, ok := progress.Load(name); !ok {
+			t.Fatalf("expected progress for %s", name)
+		}
+	}
+
+	t.Run("first error in file order", func(t *testing.T) {
+		sp := newSource()
+		sp.Files[1].SHA256Sum = strings.Repeat("0", 64)
+		sp.Files[2].SHA256Sum = strings.Repeat("0", 64)
+		err := sp.DownloadParallel(t.TempDir(), 1, nil)
+		if err == nil || !strings.Contains(err.Error(), "hello_2.10.orig.tar.gz") {
+			t.Fatalf("expected the orig tarball error, got %v", err)
+		}
+	})
+}
+
+func TestGetFormattedDependencies(t *testing.T) {
+	pkg := &Package{
+		Name:       "curl",
+		Depends:    []string{"libc6 (>= 2.34)", "libcurl4 (= 7.88.1-10)", "zlib1g"},
+		PreDepends: []string{"dpkg (>= 1.17.14)"},
+		Recommends: []string{"ca-certificates"},
+		Suggests:   []string{"libcurl4-doc"},
+		Enhances:   []string{"wget"},
+		Breaks:     []string{"curl-old (<< 7)"},
+		Conflicts:  []string{"curl-minimal"},
+		Provides:   []string{"http-client"},
+		Replaces:   []string{"curl-old (<< 7)"},
+	}
+
+	want := "Depends (3): libc6 (>= 2.34), libcurl4 (= 7.88.1-10), zlib1g\n" +
+		"Pre-Depends (1): dpkg (>= 1.17.14)\n" +
+		"Recommends (1): ca-certificates\n" +
+		"Suggests (1): libcurl4-doc\n" +
+		"Enhances (1): wget\n" +
+		"Breaks (1): curl-old (<< 7)\n" +
+		"Conflicts (1): curl-minimal\n" +
+		"Provides (1): http-client\n" +
+		"Replaces (1): curl-old (<< 7)"
+	if got := pkg.GetFormattedDependencies(); got != want {
+		t.Fatalf("GetFormattedDependencies() =\n%s\nwant:\n%s", got, want)
+	}
+
+	summary := pkg.GetDependencySummary()
+	if len(summary) != 9 || !reflect.DeepEqual(summary["Pre-Depends"], pkg.PreDepends) || !reflect.DeepEqual(summary["Depends"], pkg.Depends) {
+		t.Fatalf("unexpected summary: %v", summary)
+	}
+
+	empty := &Package{Name: "base-files", Recommends: []string{}}
+	if got := empty.GetFormattedDependencies(); got != "" {
+		t.Fatalf("expected no lines for a package without relationships, got %q", got)
+	}
+	if got := empty.GetDependencySummary(); len(got) != 0 {
+		t.Fatalf("expected an empty summary, got %v", got)
+	}
+}
+
+func TestAppendToPackagesFile(t *testing.T) {
+	hello := Package{Package: "hello", Version: "2.10-3", Architecture: "amd64", Filename: "pool/main/h/hello/hello_2.10-3_amd64.deb", Size: 53000, Depends: []string{"libc6 (>= 2.34)"}}
+	curl := Package{Package: "curl", Version: "7.88.1-10", Architecture: "amd64", Filename: "pool/main/c/curl/curl_7.88.1-10_amd64.deb", Size: 315000}
+	// An index written by hand, whose last stanza lacks the trailing blank line
+	existing := "Package: base-files\nVersion: 12.4\nArchitecture: amd64\n"
+
+	for _, name := range []string{"Packages", "Packages.gz", "Packages.xz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := hello.AppendToCompressedPackagesFile(path); err != nil {
+				t.Fatalf("append to a missing index failed: %v", err)
+			}
+			if err := curl.AppendToCompressedPackagesFile(path); err != nil {
+				t.Fatalf("append failed: %v", err)
+			}
+			assertPackagesIndex(t, path, []string{"hello", "curl"})
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "Packages")
+	if err := os.WriteFile(path, []byte(existing), FilePermission); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+	if err := hello.AppendToPackagesFile(path); err != nil {
+		t.Fatalf("AppendToPackagesFile failed: %v", err)
+	}
+	assertPackagesIndex(t, path, []string{"base-files", "hello"})
+
+	if err := hello.AppendToCompressedPackagesFile(path + ".bz2"); err == nil {
+		t.Fatal("expected an unsupported compression format to be refused")
+	}
+}
+
+// assertPackagesIndex checks that the Packages index at path, compressed according to
+// its extension, parses into the named packages, in order.
+func assertPackagesIndex(t *testing.T, path string, want []string) {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if ext := filepath.Ext(path); ext != "" {
+		decompressed, cleanup, err := (&Repository{}).createDecompressor(file, ext)
+		if err != nil {
+			t.Fatalf("failed to decompress %s: %v", path, err)
+		}
+		if cleanup != nil {
+			defer cleanup()
+		}
+		reader = decompressed
+	}
+
+	names, packages, err := (&Repository{}).parsePackagesFromReader(context.Background(), reader)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got packages %v, want %v", names, want)
+	}
+	for _, pkg := range packages {
+		if pkg.Package == "hello" && (pkg.Size != 53000 || len(pkg.Depends) != 1) {
+			t.Fatalf("hello did not round-trip: %+v", pkg)
+		}
+	}
+}
+
+func TestPackageJSON(t *testing.T) {
+	pkg := NewPackage("hello", "2.10-3", "amd64", "Test <test@example.com>", "greeting", "http://example.invalid/hello.deb", "pool/main/h/hello/hello_2.10-3_amd64.deb", 53000)
+	pkg.Depends = []string{"libc6 (>= 2.34)"}
+	pkg.Provides = []string{}
+	pkg.setCustomField("X-Cargo-Built-Using", "rust-foo (= 1.0)")
+
+	data, err := pkg.ToJSONIndented()
+	if err != nil {
+		t.Fatalf("ToJSONIndented failed: %v", err)
+	}
+	got, err := PackageFromJSON(data)
+	if err != nil {
+		t.Fatalf("PackageFromJSON failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, pkg) {
+		t.Fatalf("round trip changed the package:\ngot  %+v\nwant %+v", got, pkg)
+	}
+	if _, err := PackageFromJSON([]byte("{")); err == nil {
+		t.Fatal("expected invalid JSON to be rejected")
+	}
+
+	src := NewSourcePackage("hello", "2.10-3", "Test <test@example.com>", "greeting", "pool/main/h/hello")
+	src.AddFile("hello_2.10-3.dsc", "http://example.invalid/hello_2.10-3.dsc", 1234, "", "abc", "dsc")
+	data, err = src.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON failed: %v", err)
+	}
+	if gotSrc, err := SourcePackageFromJSON(data); err != nil || !reflect.DeepEqual(gotSrc, src) {
+		t.Fatalf("source round trip: got %+v, %v, want %+v", gotSrc, err, src)
+	}
+}
+
+func FuzzPackageJSONRoundTrip(f *testing.F) {
+	f.Add("hello", "2.10-3", "libc6 (>= 2.34), libfoo | libbar", "X-Custom", "value", int64(53000))
+	f.Add("", "", "", "", "", int64(0))
+	f.Fuzz(func(t *testing.T, name, version, depends, customField, customValue string, size int64) {
+		for _, s := range []string{name, version, depends, customField, customValue} {
+			if !utf8.ValidString(s) {
+				t.Skip("JSON strings are UTF-8")
+			}
+		}
+
+		pkg := NewPackage(name, version, "amd64", "", "", "", "", size)
+		pkg.Depends = strings.Split(depends, ",")
+		pkg.setCustomField(customField, customValue)
+
+		data, err := pkg.ToJSON()
+		if err != nil {
+			t.Fatalf("ToJSON failed: %v", err)
+		}
+		got, err := PackageFromJSON(data)
+		if err != nil {
+			t.Fatalf("PackageFromJSON failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, pkg) {
+			t.Fatalf("round trip changed the package:\ngot  %+v\nwant %+v", got, pkg)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package debian
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// packageSelection is the set of packages a partial mirror keeps in its pool for one
+// suite and architecture. A nil selection keeps everything.
+type packageSelection struct {
+	versions map[string]string // Package name to the listed version, "" for any
+	missing  []PackageSpec     // Listed packages the metadata does not provide
+}
+
+// includes reports whether pkg belongs to the selection.
+func (s *packageSelection) includes(pkg *Package) bool {
+	if s == nil {
+		return true
+	}
+	version, ok := s.versions[pkg.Name]
+	return ok && (version == "" || version == pkg.Version)
+}
+
+// isPartial reports whether the mirror only keeps MirrorConfig.PackageList in its pool.
+func (m *Mirror) isPartial() bool {
+	return len(m.config.PackageList) > 0
+}
+
+// packageSelection returns the pool packages kept for suite and arch: the configured
+// PackageList, plus its dependency closure when PackageListDependencies is set. The
+// closure is computed over every component of the suite, with metadata returning the
+// packages of the suite for arch, and listed packages it lacks are reported as
+// warnings. The result is cached until the next Clone, and is nil when the mirror is
+// not partial.
+func (m *Mirror) packageSelection(suite, arch string, metadata func() ([]Package, error)) (*packageSelection, error) {
+	if !m.isPartial() {
+		return nil, nil
+	}
+
+	key := suite + "/" + arch
+	if selection, ok := m.selections[key]; ok {
+		return selection, nil
+	}
+
+	packages, err := metadata()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load metadata for the package list of %s: %w", key, err)
+	}
+
+	selection := &packageSelection{versions: make(map[string]string)}
+	seeds := make([]PackageSpec, 0, len(m.config.PackageList))
+	for _, spec := range m.config.PackageList {
+		name := strings.TrimSpace(spec.Name)
+		if name == "" {
+			continue
+		}
+		version := strings.TrimSpace(spec.Version)
+		if !slices.ContainsFunc(packages, func(p Package) bool {
+			return p.Name == name && (version == "" || p.Version == version)
+		}) {
+			selection.missing = append(selection.missing, spec)
+			continue
+		}
+		selection.versions[name] = version
+		// The listed version is enforced by includes; the closure follows the indexed one
+		seeds = append(seeds, PackageSpec{Name: name})
+	}
+
+	if m.config.PackageListDependencies && len(seeds) > 0 {
+		index := &Repository{PackageMetadata: packages}
+		closure, err := index.ResolveDependencies(seeds, m.config.PackageListExclude)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve the package list of %s: %w", key, err)
+		}
+		for name := range closure {
+			if _, listed := selection.versions[name]; !listed {
+				selection.versions[name] = ""
+			}
+		}
+	}
+
+	if m.selections == nil {
+		m.selections = make(map[string]*packageSelection)
+	}
+	m.selections[key] = selection
+	m.reportMissingListedPackages(suite, arch, selection)
+	return selection, nil
+}
+
+// remoteSuiteMetadata fetches the packages of every component of suite for arch from
+// the upstream repository.
+func (m *Mirror) remoteSuiteMetadata(suite, arch string) func() ([]Package, error) {
+	return func() ([]Package, error) {
+		components, err := m.releaseComponentsForSuite(suite, false)
+		if err != nil {
+			return nil, err
+		}
+
+		m.repository.SetSuite(suite)
+		m.repository.SetComponents(components)
+		m.repository.SetArchitectures([]string{arch})
+		if _, err := m.repository.FetchPackages(); err != nil {
+			return nil, err
+		}
+		return slices.Clone(m.repository.GetAllPackageMetadata()), nil
+	}
+}
+
+// localSuiteMetadata reads the packages of every component of suite for arch from the
+// mirror's local Packages indices. Components without a local index are skipped.
+func (m *Mirror) localSuiteMetadata(suite, arch string) func() ([]Package, error) {
+	return func() ([]Package, error) {
+		var packages []Package
+		for _, component := range m.config.GetComponentsForSuite(suite) {
+			indexed, err := m.readLocalPackagesIndex(suite, component, arch)
+			if err != nil {
+				continue
+			}
+			packages = append(packages, indexed...)
+		}
+		return packages, nil
+	}
+}
+
+// reportMissingListedPackages warns about the listed packages the metadata of suite
+// does not provide for arch.
+func (m *Mirror) reportMissingListedPackages(suite, arch string, selection *packageSelection) {
+	for _, spec := range selection.missing {
+		name := spec.Name
+		if spec.Version != "" {
+			name += " " + spec.Version
+		}
+		m.warn(WarningOther, spec.Name, MirrorEvent{Type: MirrorEventWarning, Suite: suite, Arch: arch, Message: fmt.Sprintf("Warning: listed package %s is not available in %s for %s", name, suite, arch)})
+	}
+}
@@ -0,0 +1,147 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newPartialSuiteServer serves bookworm with hello (depending on libfoo), libfoo and
+// bash in main/amd64, along with their pool files.
+func newPartialSuiteServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	pool := map[string]string{
+		"/pool/main/h/hello/hello_2.10-3_amd64.deb":     "hello",
+		"/pool/main/libf/libfoo/libfoo_1.0-1_amd64.deb": "libfoo",
+		"/pool/main/b/bash/bash_5.2-1_amd64.deb":        "bash",
+	}
+	var packages strings.Builder
+	for _, entry := range []struct{ name, version, depends string }{
+		{"hello", "2.10-3", "libfoo (>= 1.0)"},
+		{"libfoo", "1.0-1", ""},
+		{"bash", "5.2-1", ""},
+	} {
+		path := fmt.Sprintf("pool/main/%s/%s/%s_%s_amd64.deb", getPoolPrefix(entry.name), entry.name, entry.name, entry.version)
+		fmt.Fprintf(&packages, "Package: %s\nVersion: %s\nArchitecture: amd64\n", entry.name, entry.version)
+		if entry.depends != "" {
+			fmt.Fprintf(&packages, "Depends: %s\n", entry.depends)
+		}
+		fmt.Fprintf(&packages, "Filename: %s\nSize: %d\nSHA256: %x\n\n", path, len(pool["/"+path]), sha256.Sum256([]byte(pool["/"+path])))
+	}
+	index := packages.String()
+	release := fmt.Sprintf("Suite: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(index)), len(index))
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			fmt.Fprint(w, release)
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			fmt.Fprint(w, index)
+		default:
+			content, ok := pool[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, content)
+		}
+	}))
+}
+
+func TestPartialMirrorDownloadsListedPackagesOnly(t *testing.T) {
+	server := newPartialSuiteServer(t)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name         string
+		dependencies bool
+		present      []string
+		absent       []string
+	}{
+		{"list only", false, []string{"h/hello/hello_2.10-3_amd64.deb"}, []string{"libf/libfoo/libfoo_1.0-1_amd64.deb", "b/bash/bash_5.2-1_amd64.deb"}},
+		{"with dependencies", true, []string{"h/hello/hello_2.10-3_amd64.deb", "libf/libfoo/libfoo_1.0-1_amd64.deb"}, []string{"b/bash/bash_5.2-1_amd64.deb"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mirror, basePath := newTestMirror(t, server.URL)
+			mirror.config.DownloadPackages = true
+			mirror.config.PackageList = []PackageSpec{{Name: "hello"}, {Name: "missing"}}
+			mirror.config.PackageListDependencies = tc.dependencies
+
+			if err := mirror.Clone(); err != nil {
+				t.Fatalf("clone failed: %v", err)
+			}
+
+			// Metadata is mirrored verbatim, so apt still sees every package...
+			index, err := os.ReadFile(filepath.Join(basePath, "dists", "bookworm", "main", "binary-amd64", "Packages"))
+			if err != nil || !strings.Contains(string(index), "Package: bash") {
+				t.Fatalf("expected the upstream Packages index to be kept, got %v", err)
+			}
+
+			// ...but unlisted pool files answer 404 to apt clients
+			local := httptest.NewServer(http.FileServer(http.Dir(basePath)))
+			defer local.Close()
+			for _, rel := range tc.present {
+				if resp, err := http.Get(local.URL + "/pool/main/" + rel); err != nil || resp.StatusCode != http.StatusOK {
+					t.Fatalf("expected %s to be served, got %v %v", rel, resp, err)
+				}
+			}
+			for _, rel := range tc.absent {
+				if resp, err := http.Get(local.URL + "/pool/main/" + rel); err != nil || resp.StatusCode != http.StatusNotFound {
+					t.Fatalf("expected a 404 for unlisted %s, got %v %v", rel, resp, err)
+				}
+			}
+
+			warnings := mirror.GetWarnings()
+			if len(warnings) != 1 || warnings[0].Kind != WarningOther || warnings[0].Subject != "missing" {
+				t.Fatalf("expected a warning for the unavailable listed package, got %+v", warnings)
+			}
+		})
+	}
+}
+
+func TestPartialMirrorVerificationSeparatesOmittedFiles(t *testing.T) {
+	server := newPartialSuiteServer(t)
+	defer server.Close()
+
+	mirror, basePath := newTestMirror(t, server.URL)
+	mirror.config.DownloadPackages = true
+	mirror.config.PackageList = []PackageSpec{{Name: "hello"}}
+	mirror.config.PackageListDependencies = true
+	if err := mirror.Clone(); err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+
+	report, err := mirror.VerifyLocalPackages("bookworm", "main", "amd64")
+	if err != nil {
+		t.Fatalf("VerifyLocalPackages: %v", err)
+	}
+	if report.Checked != 3 || report.Valid != 2 || report.Omitted != 1 || report.Missing != 0 || len(report.Errors) != 0 {
+		t.Fatalf("expected bash to be omitted rather than missing, got %+v", report)
+	}
+
+	// A listed file that disappears is still an error
+	if err := os.Remove(filepath.Join(basePath, "pool", "main", "libf", "libfoo", "libfoo_1.0-1_amd64.deb")); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	report, _ = mirror.VerifyLocalPackages("bookworm", "main", "amd64")
+	if report.Missing != 1 || report.Omitted != 1 || !errors.Is(report.Errors[0], ErrPoolFileMissing) || report.Errors[0].Package != "libfoo" {
+		t.Fatalf("expected libfoo to be reported as missing, got %+v", report)
+	}
+
+	// Listed packages absent from the local indices are reported by VerifyMirrorIntegrity
+	mirror.config.PackageList = append(mirror.config.PackageList, PackageSpec{Name: "hello", Version: "9.9-9"})
+	if err := mirror.VerifyMirrorIntegrity("bookworm"); err != nil {
+		t.Fatalf("VerifyMirrorIntegrity: %v", err)
+	}
+	warnings := mirror.GetWarnings()
+	if len(warnings) == 0 || !strings.Contains(warnings[len(warnings)-1].Message, "hello 9.9-9") {
+		t.Fatalf("expected a warning for the unavailable listed version, got %+v", warnings)
+	}
+}
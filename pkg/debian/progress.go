@@ -0,0 +1,108 @@
+package debian
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// Progress reporting while Packages indices are parsed.
+const (
+	progressReportLines  = 1000 // Lines parsed between OnProgress calls
+	packagesAvgLineBytes = 50   // Average Packages line length used to estimate totals
+)
+
+// Typical compression ratios of Packages indices, used to estimate their line count
+// from the download size.
+var packagesCompressionRatios = map[string]int64{"": 1, ".gz": 4, ".xz": 6}
+
+// ProgressReporter receives progress updates from long-running operations such as
+// FetchPackages. For each Packages index, OnStart is called once the download begins
+// with an approximate number of lines (0 when unknown), OnProgress with the number of
+// lines parsed so far, and OnComplete when the index is done, successfully or not.
+type ProgressReporter interface {
+	OnStart(description string, total int)
+	OnProgress(done int)
+	OnComplete()
+}
+
+// NoOpProgressReporter ignores all progress updates.
+type NoOpProgressReporter struct{}
+
+func (NoOpProgressReporter) OnStart(string, int) {}
+func (NoOpProgressReporter) OnProgress(int)      {}
+func (NoOpProgressReporter) OnComplete()         {}
+
+// TextProgressReporter prints progress as a single updating line.
+type TextProgressReporter struct {
+	Writer io.Writer // Destination; os.Stderr for NewTextProgressReporter
+
+	description string
+	total       int
+}
+
+// NewTextProgressReporter returns a TextProgressReporter writing to stderr.
+func NewTextProgressReporter() *TextProgressReporter {
+	return &TextProgressReporter{Writer: os.Stderr}
+}
+
+func (t *TextProgressReporter) OnStart(description string, total int) {
+	t.description = description
+	t.total = total
+	fmt.Fprintf(t.Writer, "%s...", description)
+}
+
+func (t *TextProgressReporter) OnProgress(done int) {
+	if t.total > 0 {
+		fmt.Fprintf(t.Writer, "\r%s: %d/~%d lines", t.description, done, max(done, t.total))
+		return
+	}
+	fmt.Fprintf(t.Writer, "\r%s: %d lines", t.description, done)
+}
+
+func (t *TextProgressReporter) OnComplete() {
+	fmt.Fprintf(t.Writer, "\r%s: done\n", t.description)
+}
+
+// SetProgressReporter sets the reporter notified while FetchPackages downloads and
+// parses Packages indices. A nil reporter disables reporting.
+func (r *Repository) SetProgressReporter(p ProgressReporter) {
+	r.progress = p
+}
+
+// startIndexProgress reports the start of a Packages index download and returns the
+// reporter to use for it. contentLength is the download size, or -1 when unknown.
+func (r *Repository) startIndexProgress(component, architecture, packagesURL, extension string, contentLength int64) ProgressReporter {
+	if r.progress == nil {
+		return NoOpProgressReporter{}
+	}
+
+	total := 0
+	if ratio, ok := packagesCompressionRatios[extension]; ok && contentLength > 0 {
+		total = int(contentLength * ratio / packagesAvgLineBytes)
+	}
+
+	r.progress.OnStart(fmt.Sprintf("%s/binary-%s/%s", component, architecture, path.Base(packagesURL)), total)
+	return r.progress
+}
+
+// lineProgressReader reports the number of lines read through it every
+// progressReportLines lines and once more at the end of the stream.
+type lineProgressReader struct {
+	reader   io.Reader
+	progress ProgressReporter
+	lines    int
+	reported int
+}
+
+func (l *lineProgressReader) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	l.lines += bytes.Count(p[:n], []byte{'\n'})
+	if l.lines-l.reported >= progressReportLines || (err == io.EOF && l.lines != l.reported) {
+		l.reported = l.lines
+		l.progress.OnProgress(l.lines)
+	}
+	return n, err
+}
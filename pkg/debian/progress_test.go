@@ -0,0 +1,81 @@
+package debian
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// recordingReporter records the calls it receives.
+type recordingReporter struct {
+	starts      []string
+	totals      []int
+	progress    []int
+	completions int
+}
+
+func (r *recordingReporter) OnStart(description string, total int) {
+	r.starts = append(r.starts, description)
+	r.totals = append(r.totals, total)
+}
+func (r *recordingReporter) OnProgress(done int) { r.progress = append(r.progress, done) }
+func (r *recordingReporter) OnComplete()         { r.completions++ }
+
+func TestFetchPackagesReportsProgress(t *testing.T) {
+	var index strings.Builder
+	for i := range 1500 {
+		fmt.Fprintf(&index, "Package: pkg%d\nVersion: 1.0-1\nArchitecture: amd64\nFilename: pool/main/p/pkg%d/pkg%d_1.0-1_amd64.deb\n\n", i, i, i)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dists/bookworm/main/binary-amd64/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(index.Len()))
+		fmt.Fprint(w, index.String())
+	}))
+	defer server.Close()
+
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+	reporter := &recordingReporter{}
+	repo.SetProgressReporter(reporter)
+
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages: %v", err)
+	}
+
+	if len(reporter.starts) != 1 || reporter.starts[0] != "main/binary-amd64/Packages" || reporter.totals[0] <= 0 {
+		t.Fatalf("unexpected OnStart calls: %v %v", reporter.starts, reporter.totals)
+	}
+	if len(reporter.progress) < 2 {
+		t.Fatalf("expected several OnProgress calls, got %v", reporter.progress)
+	}
+	for i := 1; i < len(reporter.progress); i++ {
+		if reporter.progress[i] <= reporter.progress[i-1] {
+			t.Fatalf("progress must increase: %v", reporter.progress)
+		}
+	}
+	if last := reporter.progress[len(reporter.progress)-1]; last != 1500*5 {
+		t.Fatalf("expected the final progress to count every line, got %d", last)
+	}
+	if reporter.completions != 1 {
+		t.Fatalf("expected one OnComplete call, got %d", reporter.completions)
+	}
+}
+
+func TestTextProgressReporter(t *testing.T) {
+	var out bytes.Buffer
+	reporter := &TextProgressReporter{Writer: &out}
+
+	reporter.OnStart("main/binary-amd64/Packages.xz", 2000)
+	reporter.OnProgress(1000)
+	reporter.OnComplete()
+
+	if got := out.String(); !strings.Contains(got, "1000/~2000 lines") || !strings.HasSuffix(got, "main/binary-amd64/Packages.xz: done\n") {
+		t.Fatalf("unexpected output %q", got)
+	}
+}
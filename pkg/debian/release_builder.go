@@ -0,0 +1,73 @@
+package debian
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// BuildReleaseFile writes a Release file for the suite of r to w, e.g. to republish
+// its metadata. The header comes from ReleaseInfo when the Release file was fetched,
+// otherwise from the configuration: Name as origin and label, Suite as suite and
+// codename, Description, Components and Architectures, dated now. When metadataRoot
+// is set, the checksums are computed from the Packages and Sources indices found
+// under metadataRoot/<suite>, laid out as by WritePackagesMetadata; otherwise those
+// of ReleaseInfo are kept. Unlike WriteReleaseFiles, nothing is written to disk.
+func (r *Repository) BuildReleaseFile(w io.Writer, metadataRoot string) error {
+	release := r.releaseHeader()
+
+	if metadataRoot != "" {
+		md5Sums, sha256Sums, err := collectPackagesChecksums(NewLocalStorage(metadataRoot), "", r.Suite, r.Components, r.BinaryArchitectures(), true, true)
+		if err != nil {
+			return fmt.Errorf("unable to compute index checksums: %w", err)
+		}
+		release.MD5Sum, release.SHA1, release.SHA256, release.SHA512 = md5Sums, nil, sha256Sums, nil
+	}
+
+	var sb strings.Builder
+	writeReleaseField(&sb, "Origin", release.Origin)
+	writeReleaseField(&sb, "Label", release.Label)
+	writeReleaseField(&sb, "Suite", release.Suite)
+	writeReleaseField(&sb, "Version", release.Version)
+	writeReleaseField(&sb, "Codename", release.Codename)
+	writeReleaseField(&sb, "Date", release.Date)
+	writeReleaseField(&sb, "Valid-Until", release.ValidUntil)
+	writeReleaseField(&sb, "Architectures", strings.Join(release.Architectures, " "))
+	writeReleaseField(&sb, "Components", strings.Join(release.Components, " "))
+	writeReleaseField(&sb, "Description", release.Description)
+	writeReleaseChecksumSection(&sb, "MD5Sum", release.MD5Sum)
+	writeReleaseChecksumSection(&sb, "SHA1", release.SHA1)
+	writeReleaseChecksumSection(&sb, "SHA256", release.SHA256)
+	writeReleaseChecksumSection(&sb, "SHA512", release.SHA512)
+
+	if _, err := io.WriteString(w, sb.String()); err != nil {
+		return fmt.Errorf("unable to write Release file: %w", err)
+	}
+	return nil
+}
+
+// releaseHeader returns a copy of ReleaseInfo, or a ReleaseFile built from the
+// configuration of r when no Release file was fetched.
+func (r *Repository) releaseHeader() ReleaseFile {
+	if release := r.GetReleaseInfo(); release != nil {
+		return *release
+	}
+	return ReleaseFile{
+		Origin:        r.Name,
+		Label:         r.Name,
+		Suite:         r.Suite,
+		Codename:      r.Suite,
+		Date:          time.Now().UTC().Format(time.RFC1123Z),
+		Description:   r.Description,
+		Architectures: r.BinaryArchitectures(),
+		Components:    r.Components,
+	}
+}
+
+// writeReleaseField writes a Release header field, leaving it out when value is empty.
+func writeReleaseField(sb *strings.Builder, name, value string) {
+	if value != "" {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", name, value))
+	}
+}
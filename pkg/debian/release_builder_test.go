@@ -0,0 +1,68 @@
+package debian
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildReleaseFile(t *testing.T) {
+	metadataRoot := t.TempDir()
+	pkgs := []Package{{Name: "hello", Package: "hello", Version: "2.10-3", Architecture: "amd64", Filename: "pool/main/h/hello/hello_2.10-3_amd64.deb", Size: 7}}
+	if err := WritePackagesMetadata(metadataRoot, "stable", map[string]map[string][]Package{"main": {"amd64": pkgs}}); err != nil {
+		t.Fatalf("WritePackagesMetadata failed: %v", err)
+	}
+	if err := WriteReleaseFiles(metadataRoot, "stable", []string{"main"}, []string{"amd64"}, false); err != nil {
+		t.Fatalf("WriteReleaseFiles failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(metadataRoot, "stable", "Release"))
+	if err != nil {
+		t.Fatalf("failed to read Release: %v", err)
+	}
+	repo := NewRepository("republish", "http://deb.example.com/debian", "Republished suite", "stable", []string{"main"}, []string{"amd64"})
+	want, err := repo.parseReleaseFile(string(data))
+	if err != nil {
+		t.Fatalf("failed to parse the reference Release: %v", err)
+	}
+
+	t.Run("from configuration", func(t *testing.T) {
+		var sb strings.Builder
+		if err := repo.BuildReleaseFile(&sb, metadataRoot); err != nil {
+			t.Fatalf("BuildReleaseFile failed: %v", err)
+		}
+		got, err := repo.parseReleaseFile(sb.String())
+		if err != nil {
+			t.Fatalf("failed to parse the built Release: %v", err)
+		}
+
+		if got.Origin != "republish" || got.Suite != "stable" || got.Description != "Republished suite" || got.ParsedDate.IsZero() {
+			t.Fatalf("unexpected header: %+v", got)
+		}
+		if !slices.Equal(got.Components, want.Components) || !slices.Equal(got.Architectures, want.Architectures) {
+			t.Fatalf("got components %v and architectures %v, want %v and %v", got.Components, got.Architectures, want.Components, want.Architectures)
+		}
+		if !reflect.DeepEqual(got.MD5Sum, want.MD5Sum) || !reflect.DeepEqual(got.SHA256, want.SHA256) {
+			t.Fatalf("checksums differ from WriteReleaseFiles:\n%s\nwant:\n%s", sb.String(), data)
+		}
+	})
+
+	t.Run("from ReleaseInfo", func(t *testing.T) {
+		repo.ReleaseInfo = want
+		defer func() { repo.ReleaseInfo = nil }()
+
+		var sb strings.Builder
+		if err := repo.BuildReleaseFile(&sb, ""); err != nil {
+			t.Fatalf("BuildReleaseFile failed: %v", err)
+		}
+		got, err := repo.parseReleaseFile(sb.String())
+		if err != nil {
+			t.Fatalf("failed to parse the built Release: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Release does not round-trip:\n%s\nwant:\n%s", sb.String(), data)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package debian
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+// GPGSigner produces the signatures written next to a generated Release file.
+type GPGSigner interface {
+	Sign(data []byte) ([]byte, error)      // Armored detached signature (Release.gpg)
+	ClearSign(data []byte) ([]byte, error) // Cleartext signed message (InRelease)
+}
+
+// KeyFileSigner signs in pure Go with an armored private key. Close it once done
+// signing to wipe the unlocked key from memory.
+type KeyFileSigner struct {
+	key *crypto.Key
+}
+
+// GPGCommandSigner signs by running the gpg executable.
+type GPGCommandSigner struct {
+	KeyID   string // Key to sign with (--local-user)
+	GPGHome string // GnuPG home directory (--homedir), empty for gpg's default
+	Command string // gpg executable, "gpg" when empty
+}
+
+// NewKeyFileSigner reads an armored private key from path and unlocks it with
+// passphrase when the key is protected.
+func NewKeyFileSigner(path, passphrase string) (*KeyFileSigner, error) {
+	keyData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	privateKey, err := crypto.NewKeyFromArmored(string(keyData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	locked, err := privateKey.IsLocked()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if key is locked: %w", err)
+	}
+	if locked {
+		unlockedKey, err := privateKey.Unlock([]byte(passphrase))
+		privateKey.ClearPrivateParams()
+		if err != nil {
+			return nil, fmt.Errorf("failed to unlock private key: %w", err)
+		}
+		privateKey = unlockedKey
+	}
+
+	return &KeyFileSigner{key: privateKey}, nil
+}
+
+// Close clears the private key material of s; s cannot sign afterwards.
+func (s *KeyFileSigner) Close() error {
+	s.key.ClearPrivateParams()
+	return nil
+}
+
+// Sign returns an armored detached signature of data.
+func (s *KeyFileSigner) Sign(data []byte) ([]byte, error) {
+	signer, err := crypto.PGP().Sign().SigningKey(s.key).Detached().New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create detached signer: %w", err)
+	}
+	return signer.Sign(data, crypto.Armor)
+}
+
+// ClearSign returns data as a cleartext signed message.
+func (s *KeyFileSigner) ClearSign(data []byte) ([]byte, error) {
+	signer, err := crypto.PGP().Sign().SigningKey(s.key).New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cleartext signer: %w", err)
+	}
+	return signer.SignCleartext(data)
+}
+
+// Sign returns an armored detached signature of data (gpg --armor --detach-sign).
+func (s *GPGCommandSigner) Sign(data []byte) ([]byte, error) {
+	return s.run(data, "--armor", "--detach-sign")
+}
+
+// ClearSign returns data as a cleartext signed message (gpg --clearsign).
+func (s *GPGCommandSigner) ClearSign(data []byte) ([]byte, error) {
+	return s.run(data, "--clearsign")
+}
+
+// run feeds data to gpg on stdin and returns its stdout.
+func (s *GPGCommandSigner) run(data []byte, mode ...string) ([]byte, error) {
+	command := s.Command
+	if command == "" {
+		command = "gpg"
+	}
+
+	args := []string{"--batch", "--yes", "--digest-algo", "SHA256"}
+	if s.GPGHome != "" {
+		args = append(args, "--homedir", s.GPGHome)
+	}
+	if s.KeyID != "" {
+		args = append(args, "--local-user", s.KeyID)
+	}
+	args = append(args, mode...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg signing failed: %w: %s", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// signer returns the GPGSigner selected by the configuration, or nil when the
+// Release files should stay unsigned.
+func (c *ReleaseSigningConfig) signer() (GPGSigner, error) {
+	switch {
+	case c == nil:
+		return nil, nil
+	case c.Signer != nil:
+		return c.Signer, nil
+	case c.PrivateKeyPath != "":
+		return NewKeyFileSigner(c.PrivateKeyPath, c.Passphrase)
+	case c.KeyID != "":
+		return &GPGCommandSigner{KeyID: c.KeyID, GPGHome: c.GPGHome}, nil
+	default:
+		return nil, nil
+	}
+}
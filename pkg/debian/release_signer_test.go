@@ -0,0 +1,138 @@
+package debian
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+const testSigningKeyPath = "testdata/release-signing-key.asc"
+
+// writeSignedTestSuite generates metadata for a one-package suite and signs it.
+func writeSignedTestSuite(t *testing.T, config *ReleaseSigningConfig) string {
+	t.Helper()
+
+	metadataRoot := t.TempDir()
+	pkgs := []Package{{Name: "hello", Package: "hello", Version: "2.10-3", Architecture: "amd64", Filename: "pool/main/h/hello/hello_2.10-3_amd64.deb", Size: 7}}
+	if err := WritePackagesMetadata(metadataRoot, "stable", map[string]map[string][]Package{"main": {"amd64": pkgs}}); err != nil {
+		t.Fatalf("WritePackagesMetadata failed: %v", err)
+	}
+	if err := WriteSignedReleaseFiles(metadataRoot, "stable", []string{"main"}, []string{"amd64"}, false, config); err != nil {
+		t.Fatalf("WriteSignedReleaseFiles failed: %v", err)
+	}
+	return filepath.Join(metadataRoot, "stable")
+}
+
+// verifySignedSuite checks Release.gpg and InRelease against the test key.
+func verifySignedSuite(t *testing.T, suiteDir string) {
+	t.Helper()
+
+	keyData, err := os.ReadFile(testSigningKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read test key: %v", err)
+	}
+	key, err := crypto.NewKeyFromArmored(string(keyData))
+	if err != nil {
+		t.Fatalf("failed to parse test key: %v", err)
+	}
+	publicKey, err := key.ToPublic()
+	if err != nil {
+		t.Fatalf("failed to extract public key: %v", err)
+	}
+	verifier, err := crypto.PGP().Verify().VerificationKey(publicKey).New()
+	if err != nil {
+		t.Fatalf("failed to create verifier: %v", err)
+	}
+
+	release, _ := os.ReadFile(filepath.Join(suiteDir, "Release"))
+	signature, _ := os.ReadFile(filepath.Join(suiteDir, "Release.gpg"))
+	result, err := verifier.VerifyDetached(release, signature, crypto.Armor)
+	if err != nil {
+		t.Fatalf("failed to read Release.gpg: %v", err)
+	}
+	if err := result.SignatureError(); err != nil {
+		t.Fatalf("Release.gpg does not verify: %v", err)
+	}
+
+	inRelease, _ := os.ReadFile(filepath.Join(suiteDir, "InRelease"))
+	cleartext, err := verifier.VerifyCleartext(inRelease)
+	if err != nil {
+		t.Fatalf("failed to read InRelease: %v", err)
+	}
+	if err := cleartext.SignatureError(); err != nil {
+		t.Fatalf("InRelease does not verify: %v", err)
+	}
+	if strings.TrimSpace(string(cleartext.Cleartext())) != strings.TrimSpace(string(release)) {
+		t.Fatal("InRelease content differs from Release")
+	}
+}
+
+func TestWriteSignedReleaseFilesWithKeyFile(t *testing.T) {
+	suiteDir := writeSignedTestSuite(t, &ReleaseSigningConfig{PrivateKeyPath: testSigningKeyPath})
+	verifySignedSuite(t, suiteDir)
+}
+
+func TestWriteSignedReleaseFilesWithGPGCommand(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg executable not available")
+	}
+
+	gpgHome := t.TempDir()
+	t.Cleanup(func() { exec.Command("gpgconf", "--homedir", gpgHome, "--kill", "gpg-agent").Run() })
+	importCmd := exec.Command("gpg", "--batch", "--homedir", gpgHome, "--import", testSigningKeyPath)
+	if output, err := importCmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to import test key: %v: %s", err, output)
+	}
+
+	suiteDir := writeSignedTestSuite(t, &ReleaseSigningConfig{KeyID: "test@example.com", GPGHome: gpgHome})
+	verifySignedSuite(t, suiteDir)
+}
+
+type recordingSigner struct {
+	calls []string
+}
+
+func (s *recordingSigner) Sign(data []byte) ([]byte, error) {
+	s.calls = append(s.calls, "sign")
+	return []byte("detached"), nil
+}
+
+func (s *recordingSigner) ClearSign(data []byte) ([]byte, error) {
+	s.calls = append(s.calls, "clearsign")
+	return append([]byte("clearsigned\n"), data...), nil
+}
+
+func TestWriteSignedReleaseFilesUsesInjectedSigner(t *testing.T) {
+	signer := &recordingSigner{}
+	suiteDir := writeSignedTestSuite(t, &ReleaseSigningConfig{Signer: signer, PrivateKeyPath: "unused"})
+
+	if len(signer.calls) != 2 {
+		t.Fatalf("expected Sign and ClearSign calls, got %v", signer.calls)
+	}
+	if data, _ := os.ReadFile(filepath.Join(suiteDir, "Release.gpg")); string(data) != "detached" {
+		t.Fatalf("unexpected Release.gpg content %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(suiteDir, "InRelease")); !strings.HasPrefix(string(data), "clearsigned\n") {
+		t.Fatalf("unexpected InRelease content %q", data)
+	}
+}
+
+func TestKeyFileSignerClose(t *testing.T) {
+	signer, err := NewKeyFileSigner(testSigningKeyPath, "")
+	if err != nil {
+		t.Fatalf("NewKeyFileSigner failed: %v", err)
+	}
+	if _, err := signer.Sign([]byte("Release")); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := signer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := signer.Sign([]byte("Release")); err == nil {
+		t.Fatal("expected a closed signer to have no private key left to sign with")
+	}
+}
@@ -3,35 +3,74 @@ package debian
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ulikunitz/xz"
 )
 
 // Repository configuration constants.
 const (
-	packagesBufferSize   = 1024 * 1024 // 1MB buffer for Packages file parsing
-	packagesInitialAlloc = 64 * 1024   // Initial allocation for scanner buffer
+	packagesBufferSize   = 1024 * 1024 // 1MB line cap for .changes and md5sums parsing
+	packagesInitialAlloc = 64 * 1024   // Initial allocation for scanner and line reader buffers
+
+	packagesCancelCheckLines = 1000 // Lines parsed between cancellation checks
+
+	defaultSourcesConcurrency = 4 // Components whose Sources FetchSources fetches at once
 )
 
 // Default repository components for package search.
 // Note: non-free-firmware was introduced in Debian 12 (Bookworm).
 var defaultComponents = []string{"main", "contrib", "non-free", "non-free-firmware"}
 
+// Components involved in the Debian 12 firmware split.
+const (
+	nonFreeComponent         = "non-free"
+	nonFreeFirmwareComponent = "non-free-firmware"
+)
+
+// ErrNoSourceMetadata is returned by source searches before any Sources index has been loaded.
+var ErrNoSourceMetadata = fmt.Errorf("no source metadata available - call FetchSources() first")
+
+// ErrInvalidSourceDirectory is returned for a source package whose Directory is empty
+// or climbs out of the repository with a ".." segment.
+var ErrInvalidSourceDirectory = fmt.Errorf("invalid source package directory")
+
+// ErrMetadataLimitExceeded is returned by FetchPackages when the metadata download limit
+// was reached; the packages returned alongside it may be incomplete.
+var ErrMetadataLimitExceeded = fmt.Errorf("metadata download limit exceeded")
+
+// ErrEmptyPackageName is returned when a pool path is requested for an empty package name.
+var ErrEmptyPackageName = fmt.Errorf("package name is empty")
+
+// ErrStrictMode is wrapped by the errors of a repository in strict mode when a
+// Release file or a setting would weaken verification (see EnableStrictMode).
+var ErrStrictMode = fmt.Errorf("not allowed in strict mode")
+
 // ErrGPGNotFound is returned when gpgv executable cannot be found on Windows.
 var ErrGPGNotFound = fmt.Errorf("gpgv executable not found: please install Gpg4win from https://www.gpg4win.org/ or add gpgv.exe to your PATH")
 
@@ -158,12 +197,49 @@ type ReleaseFile struct {
 	Version       string
 	Codename      string
 	Date          string
+	ValidUntil    string
 	Description   string
 	Architectures []string
 	Components    []string
 	MD5Sum        []FileChecksum
 	SHA1          []FileChecksum
 	SHA256        []FileChecksum
+	SHA512        []FileChecksum
+
+	ParsedDate       time.Time // Date as a time; zero when missing or invalid
+	ParsedValidUntil time.Time // Valid-Until as a time; zero when missing or invalid
+	ParseWarnings    []string  // Problems that did not prevent parsing, such as invalid dates
+}
+
+// releaseDateLayouts lists the Date and Valid-Until formats seen in Release files:
+// RFC 1123 with a zone name or a numeric offset, single-digit days, and RFC 3339.
+var releaseDateLayouts = []string{
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 -0700 (MST)",
+	"2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+}
+
+// parseReleaseDate parses a Release file date in any of releaseDateLayouts.
+func parseReleaseDate(value string) (time.Time, error) {
+	value = strings.Join(strings.Fields(value), " ")
+	for _, layout := range releaseDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", value)
+}
+
+// Age returns how long ago the Release file was generated, or 0 when its Date is
+// unknown.
+func (rf *ReleaseFile) Age() time.Duration {
+	if rf.ParsedDate.IsZero() {
+		return 0
+	}
+	return time.Since(rf.ParsedDate)
 }
 
 // FileChecksum represents a single checksum entry from a Release file.
@@ -175,6 +251,16 @@ type FileChecksum struct {
 
 // Repository handles interactions with a Debian repository, including
 // fetching Release files, Packages metadata, and downloading packages.
+//
+// A Repository may be shared by several goroutines once configured. Fetches and loads
+// (FetchPackages and its variants, FetchSources, FetchReleaseFile, LoadCachedPackages,
+// LoadMetadata) run one at a time and publish their results at once, so the query
+// methods (SearchPackage, GetPackageMetadata, ResolveDependencies...) see either the
+// previous metadata or the new one, never a partial fetch. Slices returned by the
+// query methods must not be modified. Setters such as SetSuite and SetComponents, and
+// direct access to the exported fields, are not synchronized: configure the repository
+// before sharing it, and read Packages, PackageMetadata, SourceMetadata and ReleaseInfo
+// through the methods while other goroutines may fetch.
 type Repository struct {
 	Name            string
 	URL             string
@@ -190,6 +276,92 @@ type Repository struct {
 	VerifySignature bool
 	KeyringPaths    []string
 	WarningHandler  func(string)
+	Warnings        *WarningCollector // Records non-fatal warnings for GetWarnings; nil discards them
+	TempDir         string            // Directory for GPG verification temp files and download staging; defaults to os.TempDir()
+	Deduplication   DeduplicationStrategy
+
+	// DistributionAliases maps suite names to the codenames whose dists/ directory the
+	// Release file and index URLs use instead, e.g. stable to bookworm. NewRepository
+	// fills it from DefaultDistributionAliases; see AddDistributionAlias.
+	DistributionAliases map[string]string
+
+	// SourceOnly marks a repository used for Sources indices only (see NewSourceRepository):
+	// Architectures may be empty and binary Packages indices are never fetched.
+	SourceOnly bool
+
+	// StrictMode requires signed Release files with SHA256 checksums and never falls
+	// back to MD5; set it with EnableStrictMode.
+	StrictMode bool
+
+	// AutoAdjustComponents reconciles Components with the Release file once it is
+	// fetched: components the suite does not have are dropped with a warning, and
+	// non-free-firmware is added when non-free was requested on a suite that has it.
+	AutoAdjustComponents bool
+
+	// CacheSignedRelease keeps the signed Release data of each verified fetch, InRelease
+	// or Release and Release.gpg, under SignedReleaseDir/<suite>/, so that
+	// VerifyLocalRelease can check it again later without network access.
+	CacheSignedRelease bool
+	SignedReleaseDir   string
+
+	// MetadataTimeout bounds connecting and waiting for the response headers of the
+	// requests for Release files and indices, and PackageTimeout those of the package
+	// downloads (see Downloader.Timeout); 0 keeps the Downloader default. Bodies are
+	// only bounded by the Downloader idle timeout.
+	MetadataTimeout time.Duration
+	PackageTimeout  time.Duration
+
+	MetadataDownloadLimit int64 // Maximum bytes of Packages data FetchPackages downloads; 0 means unlimited
+	metadataReceived      int64
+	metadataLimitHit      bool
+	indexDigests          map[string]string             // SHA256 of each Packages index body read by the last FetchPackages, guarded by mu
+	progress              ProgressReporter              // Set with SetProgressReporter; nil disables reporting
+	pendingRelease        *releaseFetch                 // Fetch started by FetchReleaseFileAsync, until waited for by an index fetch
+	transport             http.RoundTripper             // Set with SetTLSConfig; shared by the downloaders of r
+	compression           []string                      // Set with SetCompressionPreference; nil means CompressionExtensions
+	retryPolicy           RetryPolicy                   // Set with SetRetryPolicy; shared by the downloaders of r
+	requiredFingerprint   string                        // Set with RequireSignerFingerprint; normalized, empty when not pinned
+	signatures            []SignatureInfo               // Valid signatures of the last verified Release file
+	fieldFilter           func(name, value string) bool // Set during FetchPackagesWithFilter
+	packageFilter         func(Package) bool            // Set during FetchPackagesFiltered
+	installerIndices      bool                          // Set during FetchInstallerPackages
+
+	mu             sync.RWMutex      // Guards Packages, PackageMetadata, SourceMetadata, ReleaseInfo and indexDigests, replaced but never modified in place
+	fetchMu        sync.Mutex        // Serializes fetches and loads
+	fetched        []Package         // Packages metadata gathered by the fetch in progress, published when it ends
+	fetchedDigests map[string]string // Packages index digests gathered by the fetch in progress, published with fetched
+
+	nameIndex       *NameIndex // Built by NameIndex or read by LoadNameIndex, guarded by mu
+	nameIndexSource []Package  // PackageMetadata nameIndex was built from, nil when read from a cache
+}
+
+// DeduplicationStrategy controls how FetchPackages merges entries for the same
+// package name and architecture found in more than one section.
+type DeduplicationStrategy int
+
+const (
+	// StrategyFirstWins keeps the entry from the first section that provides it (default).
+	StrategyFirstWins DeduplicationStrategy = iota
+	// StrategyNewestWins keeps the entry with the highest Debian version. On equal
+	// versions, e.g. the same upload published under different filenames, the entry
+	// from the earlier section in the configured order wins.
+	StrategyNewestWins
+	// StrategyKeepAll keeps every entry, e.g. for multi-arch mirrors.
+	StrategyKeepAll
+)
+
+// SourceArchitecture is the pseudo-architecture of source packages. Release files never
+// list it and it has no binary-<arch> indices; sources live in <component>/source/Sources.
+const SourceArchitecture = "source"
+
+// ErrNoBinaryArchitectures is returned when binary indices are requested from a
+// repository configured without any binary architecture.
+var ErrNoBinaryArchitectures = fmt.Errorf("no binary architecture configured")
+
+// releaseFetch is a Release file fetch running in the background.
+type releaseFetch struct {
+	done chan struct{} // Closed once err is set
+	err  error
 }
 
 // PackageSpec represents a package name/version request.
@@ -201,47 +373,288 @@ type PackageSpec struct {
 // NewRepository creates a new Repository instance with the specified configuration.
 func NewRepository(name, url, description, suite string, components, architectures []string) *Repository {
 	return &Repository{
-		Name:            name,
-		URL:             url,
-		Description:     description,
-		Suite:           suite,
-		Components:      components,
-		Architectures:   architectures,
-		VerifyRelease:   true,
-		VerifySignature: true,
+		Name:                name,
+		URL:                 url,
+		Description:         description,
+		Suite:               suite,
+		Components:          components,
+		Architectures:       architectures,
+		VerifyRelease:       true,
+		VerifySignature:     true,
+		Warnings:            NewWarningCollector(),
+		DistributionAliases: maps.Clone(DefaultDistributionAliases),
+	}
+}
+
+// DefaultDistributionAliases maps the Debian suite names to the codenames they stand
+// for in the current release train. It must follow Debian releases.
+var DefaultDistributionAliases = map[string]string{
+	"stable":   "bookworm",
+	"testing":  "trixie",
+	"unstable": "sid",
+}
+
+// AddDistributionAlias makes the URLs of suite alias use the dists/ directory of
+// target. An empty target removes the alias, e.g. for a third-party repository
+// really publishing a suite named stable.
+func (r *Repository) AddDistributionAlias(alias, target string) {
+	if target == "" {
+		delete(r.DistributionAliases, alias)
+		return
+	}
+	if r.DistributionAliases == nil {
+		r.DistributionAliases = make(map[string]string)
+	}
+	r.DistributionAliases[alias] = target
+}
+
+// resolveDistribution returns the codename suite is an alias of, or suite itself.
+func (r *Repository) resolveDistribution(suite string) string {
+	if target, ok := r.DistributionAliases[suite]; ok {
+		return target
+	}
+	return suite
+}
+
+// NewSourceRepository creates a Repository for source package operations. It has no
+// architectures: FetchSources reads <component>/source/Sources, and validation
+// against the Release file only checks the components.
+func NewSourceRepository(name, url, description, suite string, components []string) *Repository {
+	repo := NewRepository(name, url, description, suite, components, nil)
+	repo.SourceOnly = true
+	return repo
+}
+
+// BinaryArchitectures returns the configured architectures that have binary-<arch>
+// indices, i.e. Architectures without SourceArchitecture.
+func (r *Repository) BinaryArchitectures() []string {
+	archs := make([]string, 0, len(r.Architectures))
+	for _, arch := range r.Architectures {
+		if arch != SourceArchitecture {
+			archs = append(archs, arch)
+		}
 	}
+	return archs
 }
 
+// downloader returns a Downloader for the metadata requests of r, with MetadataTimeout.
 func (r *Repository) downloader() *Downloader {
-	return NewDownloader()
+	d := NewDownloader()
+	d.TempDir = r.TempDir
+	d.Warnings = r.Warnings
+	d.transport = r.transport
+	d.retryPolicy = r.retryPolicy
+	if r.MetadataTimeout > 0 {
+		d.Timeout = r.MetadataTimeout
+	}
+	return d
+}
+
+// packageDownloader returns a Downloader for the package downloads of r, with
+// PackageTimeout.
+func (r *Repository) packageDownloader() *Downloader {
+	d := r.downloader()
+	d.Timeout = defaultTimeout
+	if r.PackageTimeout > 0 {
+		d.Timeout = r.PackageTimeout
+	}
+	return d
+}
+
+// SetRetryPolicy makes policy decide which failed requests of r, including the
+// downloads it starts, are retried and when. A nil policy restores the default.
+func (r *Repository) SetRetryPolicy(policy RetryPolicy) {
+	r.retryPolicy = policy
 }
 
 // FetchPackages fetches and parses Packages files from the repository.
 // Returns a list of package names found across all configured sections and architectures.
 func (r *Repository) FetchPackages() ([]string, error) {
-	if r.VerifyRelease {
-		if err := r.FetchReleaseFile(); err != nil {
-			return nil, fmt.Errorf("error retrieving Release file: %w", err)
-		}
+	return r.FetchPackagesWithContext(context.Background())
+}
+
+// FetchPackagesWithRetryPolicy is FetchPackages with policy governing the retries of
+// its requests instead of the policy set with SetRetryPolicy.
+func (r *Repository) FetchPackagesWithRetryPolicy(policy RetryPolicy) ([]string, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	previous := r.retryPolicy
+	r.retryPolicy = policy
+	defer func() { r.retryPolicy = previous }()
+	return r.fetchPackages(context.Background())
+}
+
+// FetchPackagesWithFilter is FetchPackages keeping only the packages whose fields all
+// pass filter, which is called with the name and value of each field of a stanza as
+// it is parsed, starting with Package. The stanza is dropped as soon as a field is
+// rejected and its remaining lines are skipped unparsed, so that rejecting on the
+// Package field avoids most of the parsing and memory of unwanted packages; the whole
+// indices are still downloaded and verified. The packages kept are returned and set
+// as PackageMetadata.
+func (r *Repository) FetchPackagesWithFilter(filter func(name, value string) bool) ([]Package, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	r.fieldFilter = filter
+	defer func() { r.fieldFilter = nil }()
+	return r.fetchFilteredPackages()
+}
+
+// FetchPackagesFiltered is FetchPackages keeping only the packages for which predicate
+// returns true. Unlike FetchPackagesWithFilter, predicate sees each package once its
+// stanza is fully parsed, before it is added to PackageMetadata. The packages kept are
+// returned.
+func (r *Repository) FetchPackagesFiltered(predicate func(Package) bool) ([]Package, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	r.packageFilter = predicate
+	defer func() { r.packageFilter = nil }()
+	return r.fetchFilteredPackages()
+}
+
+// fetchFilteredPackages runs fetchPackages with the filters set on r and returns the
+// packages kept, along with ErrMetadataLimitExceeded when the limit cut the fetch short.
+func (r *Repository) fetchFilteredPackages() ([]Package, error) {
+	_, err := r.fetchPackages(context.Background())
+	if err != nil && !errors.Is(err, ErrMetadataLimitExceeded) {
+		return nil, err
+	}
+	return slices.Clone(r.packageMetadata()), err
+}
+
+// packageState returns the published Packages and PackageMetadata.
+func (r *Repository) packageState() ([]string, []Package) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.Packages, r.PackageMetadata
+}
+
+// packageMetadata returns the published PackageMetadata.
+func (r *Repository) packageMetadata() []Package {
+	_, metadata := r.packageState()
+	return metadata
+}
+
+// setPackages publishes new Packages and PackageMetadata.
+func (r *Repository) setPackages(names []string, metadata []Package) {
+	// Record where each entry comes from, for operations combining several suites
+	for i := range metadata {
+		metadata[i].Suite = r.Suite
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Packages = names
+	r.PackageMetadata = metadata
+}
+
+// sourceMetadata returns the published SourceMetadata.
+func (r *Repository) sourceMetadata() []SourcePackage {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.SourceMetadata
+}
+
+// setIndexDigests publishes the Packages index digests of the last FetchPackages.
+func (r *Repository) setIndexDigests(digests map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.indexDigests = digests
+}
+
+// setReleaseInfo publishes a new ReleaseInfo.
+func (r *Repository) setReleaseInfo(info *ReleaseFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ReleaseInfo = info
+}
+
+// FetchPackagesWithContext is FetchPackages with cancellation. Cancelling ctx aborts
+// the HTTP transfers, the decompression and the parsing of Packages indices; the
+// context's error is then returned and no partial metadata is kept.
+func (r *Repository) FetchPackagesWithContext(ctx context.Context) ([]string, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	return r.fetchPackages(ctx)
+}
+
+// releaseProvides reports whether the loaded Release file declares component in its
+// Components field, where security suites may prefix it as in updates/main, and arch
+// in its Architectures field. A field the Release file leaves out, a missing Release
+// file and the all architecture, which older Release files do not declare, are
+// assumed to provide everything.
+func (r *Repository) releaseProvides(component, arch string) bool {
+	release := r.GetReleaseInfo()
+	if release == nil {
+		return true
+	}
+	if len(release.Components) > 0 && !slices.ContainsFunc(release.Components, func(c string) bool {
+		return c == component || strings.HasSuffix(c, "/"+component)
+	}) {
+		return false
+	}
+	return len(release.Architectures) == 0 || arch == "all" || slices.Contains(release.Architectures, arch)
+}
+
+// fetchPackages implements FetchPackagesWithContext; fetchMu must be held.
+func (r *Repository) fetchPackages(ctx context.Context) ([]string, error) {
+	// A background Release fetch may still be expanding Architectures; its error is
+	// reported by loadReleaseForIndices
+	_ = r.WaitForRelease()
+	if len(r.BinaryArchitectures()) == 0 {
+		return nil, ErrNoBinaryArchitectures
+	}
+	if err := r.loadReleaseForIndices(); err != nil {
+		return nil, err
+	}
+	if err := r.expandArchitectures(); err != nil {
+		return nil, err
 	}
 
-	// Reset metadata to avoid accumulation across multiple calls
-	r.PackageMetadata = r.PackageMetadata[:0]
+	// Gather metadata aside, readers keep the previous metadata until it is published
+	r.fetched = nil
+	r.fetchedDigests = make(map[string]string)
+	defer func() { r.fetched, r.fetchedDigests = nil, nil }()
+	r.metadataReceived = 0
+	r.metadataLimitHit = false
 
 	allPackages := make(map[string]bool)
 	var lastErr error
 	foundAtLeastOne := false
+	unavailable := 0
 
 	for _, component := range r.Components {
-		for _, arch := range r.Architectures {
-			packages, err := r.fetchPackagesForComponentArch(component, arch)
+		if r.installerIndices {
+			component = InstallerComponent(component)
+		}
+		for _, arch := range r.BinaryArchitectures() {
+			if r.metadataLimitHit {
+				break
+			}
+			if r.installerIndices && !r.releaseListsPackagesIndex(component, arch) {
+				continue
+			}
+			// Spare a request bound to fail with 404
+			if !r.installerIndices && !r.releaseProvides(component, arch) {
+				r.warn(WarningReleaseMismatch, component+"/binary-"+arch, fmt.Sprintf("Warning: suite %s provides no component '%s', architecture '%s' according to its Release file, skipping", r.Suite, component, arch))
+				unavailable++
+				continue
+			}
+			start := len(r.fetched)
+			packages, err := r.fetchPackagesForComponentArch(ctx, component, arch)
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				r.setPackages(nil, nil)
+				r.setIndexDigests(nil)
+				return nil, ctxErr
+			}
 			if err != nil {
-				if r.WarningHandler != nil {
-					r.WarningHandler(fmt.Sprintf("Warning: unable to fetch packages for component '%s', architecture '%s': %v", component, arch, err))
-				}
-				lastErr = err
+				r.warn(WarningFileFailed, component+"/binary-"+arch, fmt.Sprintf("Warning: unable to fetch packages for component '%s', architecture '%s': %v", component, arch, err))
+				lastErr = significantError(lastErr, err)
 				continue
 			}
+			r.fetched = mergeSectionPackages(r.fetched, start, component, r.Deduplication)
 
 			for _, pkg := range packages {
 				allPackages[pkg] = true
@@ -250,7 +663,15 @@ func (r *Repository) FetchPackages() ([]string, error) {
 		}
 	}
 
-	if !foundAtLeastOne {
+	if !foundAtLeastOne && !r.metadataLimitHit {
+		r.setPackages(nil, nil)
+		r.setIndexDigests(nil)
+		if lastErr == nil && r.installerIndices {
+			lastErr = fmt.Errorf("no debian-installer Packages index listed in the Release file: %w", ErrNotFound)
+		}
+		if lastErr == nil && unavailable > 0 {
+			lastErr = fmt.Errorf("none of the configured components and architectures is listed in the Release file: %w", ErrNotFound)
+		}
 		return nil, fmt.Errorf("unable to fetch packages from suite %s: %w", r.Suite, lastErr)
 	}
 
@@ -259,21 +680,292 @@ func (r *Repository) FetchPackages() ([]string, error) {
 		result = append(result, pkg)
 	}
 
-	r.Packages = result
+	r.setPackages(result, r.fetched)
+	r.setIndexDigests(r.fetchedDigests)
+	if r.metadataLimitHit {
+		return result, ErrMetadataLimitExceeded
+	}
 	return result, nil
 }
 
+// SetMetadataDownloadLimit caps how many bytes of Packages data FetchPackages downloads.
+// Once the limit is reached the transfer is cut short, the data received so far is
+// parsed and FetchPackages returns ErrMetadataLimitExceeded. Zero disables the limit.
+func (r *Repository) SetMetadataDownloadLimit(bytes int64) {
+	r.MetadataDownloadLimit = bytes
+}
+
+// metadataLimitReader counts Packages bytes received against MetadataDownloadLimit
+// and closes the response body once the limit is reached.
+type metadataLimitReader struct {
+	repo      *Repository
+	body      io.ReadCloser
+	done      bool
+	truncated bool // Data remained on the wire when the limit was reached
+}
+
+func (l *metadataLimitReader) Read(p []byte) (int, error) {
+	if l.done {
+		return 0, io.EOF
+	}
+
+	remaining := l.repo.MetadataDownloadLimit - l.repo.metadataReceived
+	if remaining <= 0 {
+		// Probe for one more byte so a file ending exactly at the limit is not reported as truncated.
+		var probe [1]byte
+		if n, _ := io.ReadFull(l.body, probe[:]); n > 0 {
+			l.truncated = true
+			l.repo.metadataLimitHit = true
+		}
+		l.done = true
+		l.body.Close()
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.body.Read(p)
+	l.repo.metadataReceived += int64(n)
+	return n, err
+}
+
+// truncatedStreamReader reports a decompression error caused by a truncated
+// download as a clean end of stream so the data received so far can be parsed.
+// Data after the last complete stanza is held back until more arrives, and dropped
+// when the download was truncated, so that a stanza cut in the middle of a field is
+// not parsed as a package with a partial name or version.
+type truncatedStreamReader struct {
+	reader  io.Reader
+	limit   *metadataLimitReader
+	ready   []byte // Complete stanzas not yet returned
+	pending []byte // Data after the last complete stanza
+	err     error  // End of the underlying stream, returned once ready is drained
+}
+
+func (t *truncatedStreamReader) Read(p []byte) (int, error) {
+	for len(t.ready) == 0 {
+		if t.err != nil {
+			if t.err == io.EOF && !t.limit.truncated && len(t.pending) > 0 {
+				t.ready, t.pending = t.pending, nil
+				break
+			}
+			return 0, t.err
+		}
+
+		buf := make([]byte, max(len(p), packagesInitialAlloc))
+		n, err := t.reader.Read(buf)
+		t.pending = append(t.pending, buf[:n]...)
+		if end := stanzasEnd(t.pending); end > 0 {
+			t.ready = t.pending[:end:end]
+			t.pending = slices.Clone(t.pending[end:])
+		}
+		if err != nil {
+			if err != io.EOF && t.limit.truncated {
+				err = io.EOF
+			}
+			t.err = err
+		}
+	}
+
+	n := copy(p, t.ready)
+	t.ready = t.ready[n:]
+	return n, nil
+}
+
+// stanzasEnd returns the length of the complete stanzas at the start of data, up to
+// and including the blank line ending the last one, or 0 when there is none.
+func stanzasEnd(data []byte) int {
+	end := 0
+	if i := bytes.LastIndex(data, []byte("\n\n")); i >= 0 {
+		end = i + 2
+	}
+	if i := bytes.LastIndex(data, []byte("\n\r\n")); i >= 0 {
+		end = max(end, i+3)
+	}
+	return end
+}
+
+// digestReader hashes a Packages response body as it is read, so the index can be
+// identified later without keeping a copy of it.
+type digestReader struct {
+	reader io.ReadCloser
+	hash   hash.Hash
+}
+
+func newDigestReader(body io.ReadCloser) *digestReader {
+	return &digestReader{reader: body, hash: sha256.New()}
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	d.hash.Write(p[:n])
+	return n, err
+}
+
+func (d *digestReader) Close() error {
+	return d.reader.Close()
+}
+
+// recordIndexDigest stores the SHA256 of a Packages index once it has been parsed,
+// to be published when the fetch ends.
+func (r *Repository) recordIndexDigest(component, architecture, packagesURL string, digest *digestReader) {
+	if r.fetchedDigests == nil {
+		r.fetchedDigests = make(map[string]string)
+	}
+	key := fmt.Sprintf("%s/binary-%s/%s", component, architecture, path.Base(packagesURL))
+	r.fetchedDigests[key] = fmt.Sprintf("%x", digest.hash.Sum(nil))
+}
+
+// PackagesIndexDigests returns the SHA256 of every Packages index parsed by the last
+// FetchPackages call, keyed by path relative to the suite, e.g. main/binary-amd64/Packages.xz.
+func (r *Repository) PackagesIndexDigests() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	digests := make(map[string]string, len(r.indexDigests))
+	for key, sum := range r.indexDigests {
+		digests[key] = sum
+	}
+	return digests
+}
+
+// limitMetadataBody applies MetadataDownloadLimit to a Packages response body.
+// The returned limiter is nil when no limit is configured.
+func (r *Repository) limitMetadataBody(body io.ReadCloser) (io.Reader, *metadataLimitReader) {
+	if r.MetadataDownloadLimit <= 0 {
+		return body, nil
+	}
+	limiter := &metadataLimitReader{repo: r, body: body}
+	return limiter, limiter
+}
+
+// SetDeduplicationStrategy sets how entries found in several sections are merged.
+func (r *Repository) SetDeduplicationStrategy(s DeduplicationStrategy) {
+	r.Deduplication = s
+}
+
+// SetCompressionPreference sets the compression extensions tried for Packages and
+// Sources indices, in order, e.g. []string{".xz", ".gz"} to ignore a stale
+// uncompressed index, or []string{""} for uncompressed indices only. Formats left
+// out are never fetched. A nil list restores CompressionExtensions.
+func (r *Repository) SetCompressionPreference(exts []string) error {
+	if exts == nil {
+		r.compression = nil
+		return nil
+	}
+	if err := validateCompressionPreference(exts); err != nil {
+		return err
+	}
+	r.compression = slices.Clone(exts)
+	return nil
+}
+
+// compressionExtensions returns the compression extensions to try, in order.
+func (r *Repository) compressionExtensions() []string {
+	if r.compression != nil {
+		return r.compression
+	}
+	return CompressionExtensions
+}
+
+// mergeSectionPackages merges the entries appended to metadata from index start onward,
+// all parsed from section, into the entries merged from earlier sections. Stanzas
+// identical on name, version, architecture and filename are collapsed into one entry
+// listing every contributing section; other entries sharing a name and architecture
+// are resolved with strategy, ties going to the earlier section. Duplicates within a
+// single section are always kept.
+func mergeSectionPackages(metadata []Package, start int, section string, strategy DeduplicationStrategy) []Package {
+	for i := start; i < len(metadata); i++ {
+		metadata[i].Sections = []string{section}
+	}
+	if start == 0 {
+		return metadata
+	}
+
+	key := func(p *Package) string { return p.Name + "\x00" + p.Architecture }
+	identityKey := func(p *Package) string { return key(p) + "\x00" + p.Version + "\x00" + p.Filename }
+
+	earlier := make(map[string][]int)
+	identical := make(map[string]int)
+	for i := 0; i < start; i++ {
+		p := &metadata[i]
+		earlier[key(p)] = append(earlier[key(p)], i)
+		identical[identityKey(p)] = i
+	}
+
+	removed := make(map[int]bool)
+	for i := start; i < len(metadata); i++ {
+		p := &metadata[i]
+		if idx, exists := identical[identityKey(p)]; exists {
+			if !slices.Contains(metadata[idx].Sections, section) {
+				metadata[idx].Sections = append(metadata[idx].Sections, section)
+			}
+			removed[i] = true
+			continue
+		}
+
+		previous, exists := earlier[key(p)]
+		if !exists || strategy == StrategyKeepAll {
+			continue
+		}
+
+		if strategy != StrategyNewestWins {
+			removed[i] = true
+			continue
+		}
+
+		newest := true
+		for _, idx := range previous {
+			if !removed[idx] && CompareVersions(metadata[idx].Version, p.Version) >= 0 {
+				newest = false
+				break
+			}
+		}
+		if !newest {
+			removed[i] = true
+			continue
+		}
+		for _, idx := range previous {
+			removed[idx] = true
+		}
+	}
+
+	if len(removed) == 0 {
+		return metadata
+	}
+
+	merged := make([]Package, 0, len(metadata)-len(removed))
+	for i := range metadata {
+		if !removed[i] {
+			merged = append(merged, metadata[i])
+		}
+	}
+	return merged
+}
+
 // FetchAndCachePackages downloads Packages metadata for all configured components and architectures
-// and writes the decompressed files to the provided cache directory.
+// and writes the decompressed files to the provided cache directory. The Sources files the
+// Release file lists are cached too, as by FetchAndCacheSources; those failing are
+// reported as warnings.
 func (r *Repository) FetchAndCachePackages(cacheDir string) error {
 	if cacheDir == "" {
 		return fmt.Errorf("cache directory is required")
 	}
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
 
-	if r.VerifyRelease {
-		if err := r.FetchReleaseFile(); err != nil {
-			return fmt.Errorf("error retrieving Release file: %w", err)
-		}
+	// A background Release fetch may still be expanding Architectures; its error is
+	// reported by loadReleaseForIndices
+	_ = r.WaitForRelease()
+	if len(r.BinaryArchitectures()) == 0 {
+		return ErrNoBinaryArchitectures
+	}
+
+	if err := r.loadReleaseForIndices(); err != nil {
+		return err
+	}
+	if err := r.expandArchitectures(); err != nil {
+		return err
 	}
 
 	if err := os.MkdirAll(cacheDir, DirPermission); err != nil {
@@ -282,13 +974,16 @@ func (r *Repository) FetchAndCachePackages(cacheDir string) error {
 
 	var lastErr error
 	foundAtLeastOne := false
+	var entries []NameIndexEntry
 
 	for _, component := range r.Components {
-		for _, arch := range r.Architectures {
-			if err := r.cachePackagesForComponentArch(cacheDir, component, arch); err != nil {
+		for _, arch := range r.BinaryArchitectures() {
+			sectionEntries, err := r.cachePackagesForComponentArch(cacheDir, component, arch)
+			if err != nil {
 				lastErr = err
 				continue
 			}
+			entries = append(entries, sectionEntries...)
 			foundAtLeastOne = true
 		}
 	}
@@ -297,35 +992,173 @@ func (r *Repository) FetchAndCachePackages(cacheDir string) error {
 		return fmt.Errorf("unable to cache packages from suite %s: %w", r.Suite, lastErr)
 	}
 
+	for _, component := range r.Components {
+		if !r.releaseListsSourcesIndex(component) {
+			continue
+		}
+		if err := r.cacheSourcesForComponent(cacheDir, component); err != nil {
+			r.warn(WarningFileFailed, component+"/source", fmt.Sprintf("Warning: unable to cache sources for component '%s': %v", component, err))
+		}
+	}
+
+	return NewNameIndex(entries).Save(NameIndexPath(cacheDir, r.Suite))
+}
+
+// FetchAndCacheSources downloads the Sources files of all configured components and
+// writes them decompressed to <cacheDir>/<suite>/<component>/source/Sources, for
+// LoadCachedSources to read without network access. Components that fail are
+// reported as warnings; it only fails when none could be cached.
+func (r *Repository) FetchAndCacheSources(cacheDir string) error {
+	if cacheDir == "" {
+		return fmt.Errorf("cache directory is required")
+	}
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	if err := r.loadReleaseForIndices(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, DirPermission); err != nil {
+		return fmt.Errorf("unable to create cache directory: %w", err)
+	}
+
+	var errs []error
+	for _, component := range r.Components {
+		if err := r.cacheSourcesForComponent(cacheDir, component); err != nil {
+			r.warn(WarningFileFailed, component+"/source", fmt.Sprintf("Warning: unable to cache sources for component '%s': %v", component, err))
+			errs = append(errs, fmt.Errorf("component %s: %w", component, err))
+		}
+	}
+
+	if len(errs) == len(r.Components) {
+		return fmt.Errorf("unable to cache sources from suite %s: %w", r.Suite, errors.Join(errs...))
+	}
 	return nil
 }
 
+// cachedSourcesPath returns the location of the cached Sources file of component.
+func cachedSourcesPath(cacheDir, suite, component string) string {
+	return filepath.Join(cacheDir, suite, component, "source", "Sources")
+}
+
+func (r *Repository) cacheSourcesForComponent(cacheDir, component string) error {
+	failures := &IndexFetchError{}
+
+	for _, ext := range r.compressionExtensions() {
+		sourcesURL := r.buildSourcesURL(r.Suite, component) + ext
+
+		if err := r.headURLContext(context.Background(), sourcesURL); err != nil {
+			failures.add(sourcesURL, fmt.Errorf("Sources file not accessible: %w", err))
+			continue
+		}
+
+		data, err := r.downloadSourcesData(sourcesURL, ext, component)
+		if err != nil {
+			failures.add(sourcesURL, err)
+			continue
+		}
+
+		targetPath := cachedSourcesPath(cacheDir, r.Suite, component)
+		if err := os.MkdirAll(filepath.Dir(targetPath), DirPermission); err != nil {
+			return fmt.Errorf("unable to create cache directory: %w", err)
+		}
+		if err := os.WriteFile(targetPath, data, FilePermission); err != nil {
+			return fmt.Errorf("error writing Sources cache: %w", err)
+		}
+
+		return nil
+	}
+
+	return failures
+}
+
+// downloadSourcesData returns the decompressed content of the Sources file at
+// sourcesURL, verified against the Release file when VerifyRelease is set.
+func (r *Repository) downloadSourcesData(sourcesURL, extension, component string) ([]byte, error) {
+	resp, err := r.downloader().doRequestWithRetry(http.MethodGet, sourcesURL, true)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving Sources file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var reader io.Reader = resp.Body
+	if extension != "" {
+		decompressed, cleanup, err := r.createDecompressor(resp.Body, extension)
+		if err != nil {
+			return nil, err
+		}
+		if cleanup != nil {
+			defer cleanup()
+		}
+		reader = decompressed
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Sources file: %w", err)
+	}
+
+	if r.VerifyRelease && r.ReleaseInfo != nil {
+		if err := r.VerifySourcesFileChecksum(component, data); err != nil {
+			return nil, fmt.Errorf("failed to verify checksum: %w", err)
+		}
+	}
+
+	return data, nil
+}
+
 // FetchSources fetches and parses Sources files from the repository.
 // Returns a list of source package names found across all configured components.
+// Components are fetched defaultSourcesConcurrency at a time, see FetchSourcesParallel.
 func (r *Repository) FetchSources() ([]string, error) {
-	if r.VerifyRelease {
-		if err := r.FetchReleaseFile(); err != nil {
-			return nil, fmt.Errorf("error retrieving Release file: %w", err)
-		}
+	return r.FetchSourcesParallel(defaultSourcesConcurrency)
+}
+
+// FetchSourcesParallel is FetchSources fetching the Sources files of at most
+// maxGoroutines components at a time, all of them when maxGoroutines is not
+// positive. SourceMetadata keeps the order of Components whatever order the fetches
+// complete in. Components that fail are reported as warnings, WarningHandler
+// possibly being called from several goroutines at once, and the others are still
+// loaded; the fetch only fails, with the error of every component, when none
+// succeeds.
+func (r *Repository) FetchSourcesParallel(maxGoroutines int) ([]string, error) {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	if err := r.loadReleaseForIndices(); err != nil {
+		return nil, err
 	}
+	if maxGoroutines <= 0 || maxGoroutines > len(r.Components) {
+		maxGoroutines = len(r.Components)
+	}
+
+	results := make([][]SourcePackage, len(r.Components))
+	errs := make([]error, len(r.Components))
+	slots := make(chan struct{}, maxGoroutines)
+	var wg sync.WaitGroup
+	for i, component := range r.Components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i], errs[i] = r.fetchSourcesForComponent(component)
+		}()
+	}
+	wg.Wait()
 
 	allSources := make(map[string]bool)
 	metadata := make([]SourcePackage, 0)
-
-	var lastErr error
 	foundAtLeastOne := false
 
-	for _, component := range r.Components {
-		sources, err := r.fetchSourcesForComponent(component)
-		if err != nil {
-			if r.WarningHandler != nil {
-				r.WarningHandler(fmt.Sprintf("Warning: unable to fetch sources for component '%s': %v", component, err))
-			}
-			lastErr = err
+	for i, component := range r.Components {
+		if errs[i] != nil {
+			r.warn(WarningFileFailed, component+"/source", fmt.Sprintf("Warning: unable to fetch sources for component '%s': %v", component, errs[i]))
+			errs[i] = fmt.Errorf("component %s: %w", component, errs[i])
 			continue
 		}
 
-		for _, sp := range sources {
+		for _, sp := range results[i] {
 			metadata = append(metadata, sp)
 			allSources[sp.Name] = true
 		}
@@ -334,10 +1167,12 @@ func (r *Repository) FetchSources() ([]string, error) {
 	}
 
 	if !foundAtLeastOne {
-		return nil, fmt.Errorf("unable to fetch source packages from suite %s: %w", r.Suite, lastErr)
+		return nil, fmt.Errorf("unable to fetch source packages from suite %s: %w", r.Suite, errors.Join(errs...))
 	}
 
+	r.mu.Lock()
 	r.SourceMetadata = metadata
+	r.mu.Unlock()
 
 	result := make([]string, 0, len(allSources))
 	for name := range allSources {
@@ -349,13 +1184,13 @@ func (r *Repository) FetchSources() ([]string, error) {
 }
 
 func (r *Repository) fetchSourcesForComponent(component string) ([]SourcePackage, error) {
-	var lastErr error
+	failures := &IndexFetchError{}
 
-	for _, ext := range CompressionExtensions {
+	for _, ext := range r.compressionExtensions() {
 		sourcesURL := r.buildSourcesURL(r.Suite, component) + ext
 
-		if !r.checkURLExists(sourcesURL) {
-			lastErr = fmt.Errorf("Sources file not accessible: %s", sourcesURL)
+		if err := r.headURLContext(context.Background(), sourcesURL); err != nil {
+			failures.add(sourcesURL, fmt.Errorf("Sources file not accessible: %w", err))
 			continue
 		}
 
@@ -369,21 +1204,19 @@ func (r *Repository) fetchSourcesForComponent(component string) ([]SourcePackage
 		}
 
 		if err != nil {
-			lastErr = err
+			failures.add(sourcesURL, err)
 			continue
 		}
 
 		return sources, nil
 	}
 
-	return nil, lastErr
+	return nil, failures
 }
 
 // parseSourcesFromReader parses source metadata directly from an io.Reader.
 func (r *Repository) parseSourcesFromReader(reader io.Reader, component string) ([]SourcePackage, error) {
-	scanner := bufio.NewScanner(reader)
-	buf := make([]byte, 0, packagesInitialAlloc)
-	scanner.Buffer(buf, packagesBufferSize)
+	scanner := newLineReader(reader)
 
 	var sources []SourcePackage
 	var current *SourcePackage
@@ -394,8 +1227,11 @@ func (r *Repository) parseSourcesFromReader(reader io.Reader, component string)
 		if current == nil {
 			return
 		}
-		r.finalizeSourcePackage(current, files, component)
-		sources = append(sources, *current)
+		if err := r.finalizeSourcePackage(current, files, component); err != nil {
+			r.warn(WarningMalformedMetadata, current.Name, fmt.Sprintf("Warning: ignoring %v", err))
+		} else {
+			sources = append(sources, *current)
+		}
 		current = nil
 		files = make(map[string]*SourceFile)
 		currentField = ""
@@ -543,7 +1379,7 @@ func (r *Repository) downloadAndParseCompressedSourcesWithVerification(sourcesUR
 			if checksum.Filename == filename {
 				found = true
 				if actualHash != strings.ToLower(checksum.Hash) {
-					return nil, fmt.Errorf("invalid sha256 checksum for %s. Expected: %s, Actual: %s", filename, checksum.Hash, actualHash)
+					return nil, &ChecksumMismatchError{File: filename, Algorithm: "sha256", Expected: checksum.Hash, Actual: actualHash}
 				}
 				break
 			}
@@ -562,15 +1398,19 @@ func (r *Repository) downloadAndParseCompressedSourcesWithVerification(sourcesUR
 func (r *Repository) parseSourceFileEntry(line string, files map[string]*SourceFile, checksumType string) {
 	parts := strings.Fields(line)
 	if len(parts) < 3 {
+		r.warn(WarningMalformedMetadata, "", fmt.Sprintf("Warning: ignoring malformed Sources %s line %q", checksumType, strings.TrimSpace(line)))
 		return
 	}
 
 	hash := strings.ToLower(parts[0])
 	size, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
+		r.warn(WarningMalformedMetadata, "", fmt.Sprintf("Warning: ignoring malformed Sources %s line %q", checksumType, strings.TrimSpace(line)))
 		return
 	}
-	name := parts[2]
+	// Everything after the size is the name; some vendor repositories ship names with spaces
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), parts[0]))
+	name := strings.TrimSpace(strings.TrimPrefix(rest, parts[1]))
 
 	file, ok := files[name]
 	if !ok {
@@ -592,42 +1432,141 @@ func (r *Repository) parseSourceFileEntry(line string, files map[string]*SourceF
 	}
 }
 
-func (r *Repository) finalizeSourcePackage(pkg *SourcePackage, files map[string]*SourceFile, component string) {
+func (r *Repository) finalizeSourcePackage(pkg *SourcePackage, files map[string]*SourceFile, component string) error {
 	if pkg == nil {
-		return
+		return nil
+	}
+
+	pkg.Directory = normalizeSourceDirectory(pkg.Directory)
+	if pkg.Directory == "" {
+		pkg.Directory = r.buildSourceDirectory(component, pkg.Name)
+	}
+	if err := checkSourceDirectory(pkg.Directory); err != nil {
+		return fmt.Errorf("source package %s: %w", pkg.Name, err)
+	}
+	if !pkg.IsValidDirectory() {
+		r.warn(WarningMalformedMetadata, pkg.Name, fmt.Sprintf("Warning: source package %s: directory %s is not under pool/", pkg.Name, pkg.Directory))
+	}
+
+	fileNames := make([]string, 0, len(files))
+	for name := range files {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+
+	for _, name := range fileNames {
+		file := files[name]
+		if file == nil {
+			continue
+		}
+
+		if file.URL == "" {
+			fileURL, err := sourceFileURL(r.URL, pkg.Directory, file.Name)
+			if err != nil {
+				// The file is kept without a URL so downloading the package fails loudly
+				r.warn(WarningMalformedMetadata, pkg.Name, fmt.Sprintf("Warning: source package %s: %v", pkg.Name, err))
+			}
+			file.URL = fileURL
+		}
+
+		pkg.Files = append(pkg.Files, *file)
+	}
+	return nil
+}
+
+// normalizeSourceDirectory trims the spaces and the leading and trailing slashes of a
+// Directory field, keeping the scheme of an absolute http(s) URL intact.
+func normalizeSourceDirectory(dir string) string {
+	dir = strings.TrimSpace(dir)
+	if hasHTTPScheme(dir) {
+		return strings.TrimRight(dir, "/")
+	}
+	return strings.Trim(dir, "/")
+}
+
+// checkSourceDirectory fails with ErrInvalidSourceDirectory when dir is empty or has a
+// ".." segment, which would let source file URLs escape the repository.
+func checkSourceDirectory(dir string) error {
+	path := normalizeSourceDirectory(dir)
+	if path == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidSourceDirectory)
+	}
+	if hasHTTPScheme(path) {
+		parsed, err := url.Parse(path)
+		if err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidSourceDirectory, dir, err)
+		}
+		path = parsed.Path
+	}
+	if slices.Contains(strings.Split(path, "/"), "..") {
+		return fmt.Errorf("%w %q: contains ..", ErrInvalidSourceDirectory, dir)
+	}
+	return nil
+}
+
+// sourceFileURL builds the download URL of a source file. Directory is normally relative
+// to the repository root, but some third-party repositories publish an absolute URL,
+// used as-is, or a host-relative path such as /pool/..., whose leading slashes are
+// dropped. Path segments and the file name are percent-encoded, and the result must be
+// an absolute http(s) URL.
+func sourceFileURL(baseURL, directory, fileName string) (string, error) {
+	var prefix string
+	if parsed, err := url.Parse(directory); err == nil && parsed.Host != "" && hasHTTPScheme(directory) {
+		prefix = strings.TrimSuffix(directory, "/")
+	} else {
+		var segments []string
+		for _, segment := range strings.Split(strings.TrimLeft(directory, "/"), "/") {
+			if segment != "" && segment != "." {
+				segments = append(segments, url.PathEscape(segment))
+			}
+		}
+		prefix = strings.TrimSuffix(baseURL, "/")
+		if len(segments) > 0 {
+			prefix += "/" + strings.Join(segments, "/")
+		}
 	}
 
-	if pkg.Directory == "" {
-		pkg.Directory = r.buildSourceDirectory(component, pkg.Name)
+	fileURL := prefix + "/" + url.PathEscape(fileName)
+	parsed, err := url.Parse(fileURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL for %s: %w", fileName, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid URL for %s: %s is not an absolute http(s) URL", fileName, fileURL)
 	}
 
-	baseURL := strings.TrimSuffix(r.URL, "/")
-	dir := strings.TrimPrefix(pkg.Directory, "/")
+	return fileURL, nil
+}
 
-	fileNames := make([]string, 0, len(files))
-	for name := range files {
-		fileNames = append(fileNames, name)
+// PoolURL returns the URL of the file at relPath, a slash-separated path relative
+// to the repository root such as the Filename of a Packages entry, under baseURL.
+// Each path segment is percent-encoded, so spaces and UTF-8 in vendor file names
+// yield a valid URL.
+func PoolURL(baseURL, relPath string) string {
+	segments := strings.Split(strings.TrimLeft(relPath, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
 	}
-	sort.Strings(fileNames)
-
-	for _, name := range fileNames {
-		file := files[name]
-		if file == nil {
-			continue
-		}
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.Join(segments, "/")
+}
 
-		if file.URL == "" {
-			joined := path.Join(dir, file.Name)
-			file.URL = fmt.Sprintf("%s/%s", baseURL, joined)
-		}
+// ErrInvalidFileName is returned for a file name, given by an index or decoded from
+// a URL, that cannot be stored as a single file: empty, "." or "..", or containing
+// a path separator.
+var ErrInvalidFileName = fmt.Errorf("invalid file name")
 
-		pkg.Files = append(pkg.Files, *file)
+// checkFileName returns an error wrapping ErrInvalidFileName unless name can be
+// joined to a destination directory as a single file.
+func checkFileName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
 	}
+	return nil
 }
 
-func (r *Repository) buildSourceDirectory(section, packageName string) string {
-	prefix := getPoolPrefix(packageName)
-	return fmt.Sprintf("pool/%s/%s/%s", section, prefix, packageName)
+func (r *Repository) buildSourceDirectory(section, sourceName string) string {
+	prefix := getPoolPrefix(sourceName)
+	return fmt.Sprintf("pool/%s/%s/%s", section, prefix, sourceName)
 }
 
 func detectSourceFileType(filename string) string {
@@ -643,37 +1582,37 @@ func detectSourceFileType(filename string) string {
 	}
 }
 
-func (r *Repository) cachePackagesForComponentArch(cacheDir, component, architecture string) error {
-	var lastErr error
+func (r *Repository) cachePackagesForComponentArch(cacheDir, component, architecture string) ([]NameIndexEntry, error) {
+	failures := &IndexFetchError{}
 
-	for _, ext := range CompressionExtensions {
+	for _, ext := range r.compressionExtensions() {
 		packagesURL := r.buildPackagesURL(r.Suite, component, architecture) + ext
 
-		if !r.checkURLExists(packagesURL) {
-			lastErr = fmt.Errorf("Packages file not accessible: %s", packagesURL)
+		if err := r.headURLContext(context.Background(), packagesURL); err != nil {
+			failures.add(packagesURL, fmt.Errorf("Packages file not accessible: %w", err))
 			continue
 		}
 
 		data, err := r.downloadPackagesData(packagesURL, ext, component, architecture)
 		if err != nil {
-			lastErr = err
+			failures.add(packagesURL, err)
 			continue
 		}
 
 		targetDir := filepath.Join(cacheDir, r.Suite, component, fmt.Sprintf("binary-%s", architecture))
 		if err := os.MkdirAll(targetDir, DirPermission); err != nil {
-			return fmt.Errorf("unable to create cache directory: %w", err)
+			return nil, fmt.Errorf("unable to create cache directory: %w", err)
 		}
 
 		targetPath := filepath.Join(targetDir, "Packages")
 		if err := os.WriteFile(targetPath, data, FilePermission); err != nil {
-			return fmt.Errorf("error writing Packages cache: %w", err)
+			return nil, fmt.Errorf("error writing Packages cache: %w", err)
 		}
 
-		return nil
+		return scanNameIndexEntries(data)
 	}
 
-	return lastErr
+	return nil, failures
 }
 
 func (r *Repository) downloadPackagesData(packagesURL, extension, component, architecture string) ([]byte, error) {
@@ -731,7 +1670,7 @@ func (r *Repository) downloadPackagesData(packagesURL, extension, component, arc
 		}
 		// Try MD5 if SHA256 not found
 		if !verified {
-			for _, checksum := range r.ReleaseInfo.MD5Sum {
+			for _, checksum := range r.md5Checksums() {
 				if checksum.Filename == filename {
 					if err := r.verifyDataChecksum(data, checksum.Hash, "md5"); err != nil {
 						return nil, err
@@ -752,14 +1691,14 @@ func (r *Repository) downloadPackagesData(packagesURL, extension, component, arc
 }
 
 // fetchPackagesForComponentArch tries to fetch Packages file for a specific component/arch combination.
-func (r *Repository) fetchPackagesForComponentArch(component, arch string) ([]string, error) {
-	var lastErr error
+func (r *Repository) fetchPackagesForComponentArch(ctx context.Context, component, arch string) ([]string, error) {
+	failures := &IndexFetchError{}
 
-	for _, ext := range CompressionExtensions {
+	for _, ext := range r.compressionExtensions() {
 		packagesURL := r.buildPackagesURL(r.Suite, component, arch) + ext
 
-		if !r.checkURLExists(packagesURL) {
-			lastErr = fmt.Errorf("Packages file not accessible: %s", packagesURL)
+		if err := r.headURLContext(ctx, packagesURL); err != nil {
+			failures.add(packagesURL, fmt.Errorf("Packages file not accessible: %w", err))
 			continue
 		}
 
@@ -767,43 +1706,61 @@ func (r *Repository) fetchPackagesForComponentArch(component, arch string) ([]st
 		var err error
 
 		if ext == "" {
-			packages, err = r.downloadAndParsePackagesWithVerification(packagesURL, component, arch)
+			packages, err = r.downloadAndParsePackagesWithVerification(ctx, packagesURL, component, arch)
 		} else {
-			packages, err = r.downloadAndParseCompressedPackagesWithVerification(packagesURL, ext, component, arch)
+			packages, err = r.downloadAndParseCompressedPackagesWithVerification(ctx, packagesURL, ext, component, arch)
 		}
 
 		if err != nil {
-			lastErr = err
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			failures.add(packagesURL, err)
 			continue
 		}
 
 		return packages, nil
 	}
 
-	return nil, lastErr
+	return nil, failures
 }
 
 // checkURLExists performs a HEAD request to check if a URL is accessible.
 func (r *Repository) checkURLExists(url string) bool {
-	resp, err := r.downloader().doRequestWithRetry(http.MethodHead, url, true)
+	return r.checkURLExistsContext(context.Background(), url)
+}
+
+// checkURLExistsContext is checkURLExists with cancellation.
+func (r *Repository) checkURLExistsContext(ctx context.Context, url string) bool {
+	return r.headURLContext(ctx, url) == nil
+}
+
+// headURLContext performs a HEAD request on url and returns why it is not accessible,
+// or nil when it is. A 404 or 410 answer matches ErrNotFound.
+func (r *Repository) headURLContext(ctx context.Context, url string) error {
+	resp, err := r.downloader().doRequestWithRetryContext(ctx, http.MethodHead, url, true)
 	if err != nil {
-		return false
+		return err
 	}
 	resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	if resp.StatusCode != http.StatusOK {
+		return &HTTPStatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
 }
 
 // SearchPackage searches for packages by name (exact and partial matches).
 // Returns exact matches first, followed by partial matches.
 func (r *Repository) SearchPackage(packageName string) ([]string, error) {
-	if len(r.Packages) == 0 {
+	names, _ := r.packageState()
+	if len(names) == 0 {
 		return nil, fmt.Errorf("no packages available - call FetchPackages() first")
 	}
 
 	packageNameLower := strings.ToLower(packageName)
 	var exactMatches, partialMatches []string
 
-	for _, pkg := range r.Packages {
+	for _, pkg := range names {
 		pkgLower := strings.ToLower(pkg)
 		switch {
 		case pkg == packageName || pkgLower == packageNameLower:
@@ -823,22 +1780,188 @@ func (r *Repository) SearchPackage(packageName string) ([]string, error) {
 	return result, nil
 }
 
-// DownloadPackage downloads a package by name, version, and architecture.
+// SearchSources returns the source packages whose name or description contains term,
+// ignoring case.
+func (r *Repository) SearchSources(term string) ([]SourcePackage, error) {
+	termLower := strings.ToLower(term)
+	return r.filterSources(func(src *SourcePackage) bool {
+		return strings.Contains(strings.ToLower(src.Name), termLower) ||
+			strings.Contains(strings.ToLower(src.Description), termLower)
+	})
+}
+
+// SearchSourcesRegex returns the source packages whose name or description matches pattern.
+func (r *Repository) SearchSourcesRegex(pattern string) ([]SourcePackage, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid search pattern: %w", err)
+	}
+	return r.filterSources(func(src *SourcePackage) bool {
+		return re.MatchString(src.Name) || re.MatchString(src.Description)
+	})
+}
+
+// filterSources returns the source packages for which match reports true.
+func (r *Repository) filterSources(match func(src *SourcePackage) bool) ([]SourcePackage, error) {
+	sources := r.sourceMetadata()
+	if len(sources) == 0 {
+		return nil, ErrNoSourceMetadata
+	}
+
+	var result []SourcePackage
+	for i := range sources {
+		if match(&sources[i]) {
+			result = append(result, sources[i])
+		}
+	}
+	return result, nil
+}
+
+// DownloadPackage downloads a package by name, version, and architecture. When Packages
+// metadata is loaded, the entry is looked up there and fetched from its indexed pool path;
+// otherwise the pool URL is guessed from the name.
 func (r *Repository) DownloadPackage(packageName, version, architecture, destDir string) error {
-	pkg := r.buildPackageStruct(packageName, version, architecture, r.buildPackageURL(packageName, version, architecture))
-	return NewDownloader().DownloadToDirSilent(pkg, destDir)
+	if strings.TrimSpace(packageName) == "" {
+		return ErrEmptyPackageName
+	}
+	if r.IsMetadataLoaded() {
+		return r.downloadPackageFromMetadata(packageName, version, architecture, destDir)
+	}
+
+	packageURL, err := r.buildPackageURL(packageName, version, architecture)
+	if err != nil {
+		return err
+	}
+	r.warn(WarningFallbackURL, packageURL, fmt.Sprintf("Warning: no Packages metadata loaded, guessed the pool URL of %s: %s", packageName, packageURL))
+	pkg := r.buildPackageStruct(packageName, version, architecture, packageURL)
+	return r.packageDownloader().DownloadToDirSilent(pkg, destDir)
+}
+
+// downloadPackageFromMetadata downloads the Packages entry matching the request from
+// the pool path the index gives, verifying its SHA256 when the index lists one.
+func (r *Repository) downloadPackageFromMetadata(packageName, version, architecture, destDir string) error {
+	entry := r.findPackageInMetadata(packageName, version, architecture)
+	if entry == nil {
+		return fmt.Errorf("package %s_%s_%s not found in metadata", packageName, version, architecture)
+	}
+
+	pkg := *entry
+	pkg.Filename = path.Base(entry.Filename)
+	if err := checkFileName(pkg.Filename); err != nil {
+		return err
+	}
+	if pkg.DownloadURL == "" {
+		pkg.DownloadURL = PoolURL(r.URL, entry.Filename)
+	}
+
+	if err := r.packageDownloader().DownloadToDirSilent(&pkg, destDir); err != nil {
+		return err
+	}
+	if pkg.SHA256 == "" {
+		return nil
+	}
+
+	destPath := filepath.Join(destDir, pkg.Filename)
+	actual, err := sha256File(destPath)
+	if err != nil {
+		return fmt.Errorf("unable to verify %s: %w", pkg.Filename, err)
+	}
+	if actual != strings.ToLower(pkg.SHA256) {
+		os.Remove(destPath)
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", pkg.Filename, pkg.SHA256, actual)
+	}
+	return nil
+}
+
+// DownloadPackageByURL downloads a package from a direct URL and returns the
+// package inferred from it. Query strings are not part of the local filename.
+func (r *Repository) DownloadPackageByURL(packageURL, destDir string) (*Package, error) {
+	return r.DownloadPackageByURLWithChecksum(packageURL, destDir, "", 0)
+}
+
+// DownloadPackageByURLWithChecksum downloads a package from a direct URL and verifies
+// it against the expected SHA256 checksum and size. An empty checksum or a size of
+// zero skips the corresponding check.
+func (r *Repository) DownloadPackageByURLWithChecksum(packageURL, destDir, sha256sum string, size int64) (*Package, error) {
+	pkg, err := packageFromURL(packageURL)
+	if err != nil {
+		return nil, err
+	}
+	pkg.SHA256 = strings.ToLower(sha256sum)
+	pkg.Size = size
+
+	d := r.packageDownloader()
+	destPath := filepath.Join(destDir, pkg.Filename)
+	if err := d.DownloadSilent(pkg, destPath); err != nil {
+		return nil, err
+	}
+
+	if size > 0 {
+		info, err := os.Stat(destPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to stat downloaded file: %w", err)
+		}
+		if info.Size() != size {
+			os.Remove(destPath)
+			return nil, fmt.Errorf("size mismatch for %s: expected %d, got %d", pkg.Filename, size, info.Size())
+		}
+	}
+
+	if pkg.SHA256 != "" {
+		if err := d.verifyChecksum(destPath, pkg.SHA256, "sha256"); err != nil {
+			os.Remove(destPath)
+			return nil, fmt.Errorf("SHA256 verification failed for %s: %w", pkg.Filename, err)
+		}
+	}
+
+	return pkg, nil
 }
 
-// DownloadPackageByURL downloads a package from a direct URL.
-func (r *Repository) DownloadPackageByURL(packageURL, destDir string) error {
-	parts := strings.Split(packageURL, "/")
-	filename := parts[len(parts)-1]
+// packageFromURL builds a Package from a direct download URL. Name, Version and
+// Architecture are taken from the filename when it follows the
+// name_version_arch.deb convention; otherwise only Name is set.
+func packageFromURL(packageURL string) (*Package, error) {
+	u, err := url.Parse(packageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid package URL %q: %w", packageURL, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid package URL %q: missing scheme or host", packageURL)
+	}
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("package URL %q does not reference a file", packageURL)
+	}
+
+	// The file name is the last segment of the escaped path, decoded, so an encoded
+	// slash is rejected instead of splitting it.
+	escaped := u.EscapedPath()
+	filename, err := url.PathUnescape(escaped[strings.LastIndex(escaped, "/")+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid package URL %q: %w", packageURL, err)
+	}
+	if err := checkFileName(filename); err != nil {
+		return nil, fmt.Errorf("package URL %q has an invalid filename: %w", packageURL, err)
+	}
+
 	pkg := &Package{
-		Name:        strings.Split(filename, "_")[0],
 		DownloadURL: packageURL,
 		Filename:    filename,
 	}
-	return NewDownloader().DownloadToDirSilent(pkg, destDir)
+
+	stem := filename
+	for _, ext := range []string{".deb", ".udeb", ".ddeb"} {
+		if strings.HasSuffix(filename, ext) {
+			stem = strings.TrimSuffix(filename, ext)
+			break
+		}
+	}
+	if fields := strings.Split(stem, "_"); stem != filename && len(fields) == 3 && fields[0] != "" {
+		pkg.Name, pkg.Version, pkg.Architecture = fields[0], fields[1], fields[2]
+	} else {
+		pkg.Name = strings.Split(stem, "_")[0]
+	}
+
+	return pkg, nil
 }
 
 // buildPackageStruct creates a Package struct with the given parameters.
@@ -852,31 +1975,186 @@ func (r *Repository) buildPackageStruct(name, version, architecture, downloadURL
 	}
 }
 
-// getPoolPrefix returns the pool directory prefix for a package name.
-// For lib* packages, returns the first 4 characters; otherwise, the first character.
-func getPoolPrefix(packageName string) string {
-	if len(packageName) >= 4 && strings.HasPrefix(packageName, "lib") {
-		return packageName[:4]
+// getPoolPrefix returns the pool directory prefix for a source package name, as
+// the archive computes it: the first 4 characters for lib* names, otherwise the
+// first character, always lowercase. An empty name has no prefix and yields "".
+func getPoolPrefix(sourceName string) string {
+	name := strings.ToLower(sourceName)
+	if name == "" {
+		return ""
+	}
+	if strings.HasPrefix(name, "lib") {
+		return name[:min(4, len(name))]
+	}
+	return name[:1]
+}
+
+// poolSourceName returns the source package that owns packageName in the pool,
+// using loaded metadata when available and the binary name otherwise.
+func (r *Repository) poolSourceName(packageName string) string {
+	if pkg, err := r.GetPackageMetadata(packageName); err == nil {
+		return pkg.GetSourceName()
 	}
-	return string(packageName[0])
+	return packageName
 }
 
 // buildPackageURL constructs the download URL for a package in the default component.
-func (r *Repository) buildPackageURL(packageName, version, architecture string) string {
+func (r *Repository) buildPackageURL(packageName, version, architecture string) (string, error) {
 	return r.buildPackageURLWithComponent(packageName, version, architecture, "main")
 }
 
 // buildPackageURLWithComponent constructs the download URL for a package in a specific component.
-func (r *Repository) buildPackageURLWithComponent(packageName, version, architecture, component string) string {
-	baseURL := strings.TrimSuffix(r.URL, "/")
+// The pool directory is derived from the source package name.
+func (r *Repository) buildPackageURLWithComponent(packageName, version, architecture, component string) (string, error) {
+	if strings.TrimSpace(packageName) == "" {
+		return "", ErrEmptyPackageName
+	}
+
 	filename := fmt.Sprintf("%s_%s_%s.deb", packageName, version, architecture)
-	prefix := getPoolPrefix(packageName)
-	return fmt.Sprintf("%s/pool/%s/%s/%s/%s", baseURL, component, prefix, packageName, filename)
+	sourceName := r.poolSourceName(packageName)
+	prefix := getPoolPrefix(sourceName)
+	return PoolURL(r.URL, path.Join("pool", component, prefix, sourceName, filename)), nil
+}
+
+// FetchChangelog downloads the changelog of a package version from the Debian
+// changelog service. Loaded metadata supplies the section and source name; without
+// it the package is assumed to be its own source in main. An empty version picks
+// the version found in metadata.
+func (r *Repository) FetchChangelog(packageName, version string) (string, error) {
+	pkg, err := r.GetPackageMetadataWithArch(packageName, version, nil)
+	if err != nil {
+		if version == "" {
+			return "", err
+		}
+		pkg = &Package{Name: packageName, Package: packageName, Version: version}
+	}
+
+	changelogURL := pkg.GetChangelogURL()
+	resp, err := r.downloader().doRequestWithRetry(http.MethodGet, changelogURL, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch changelog %s: %w", changelogURL, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read changelog %s: %w", changelogURL, err)
+	}
+
+	return string(data), nil
+}
+
+// CheckPackageAvailabilityFromMetadata reports whether the loaded Packages metadata has an
+// entry for packageName at version built for architecture. Architecture-independent
+// ("all") packages match any architecture; an empty version or architecture matches any.
+func (r *Repository) CheckPackageAvailabilityFromMetadata(packageName, version, architecture string) bool {
+	return r.findPackageInMetadata(packageName, version, architecture) != nil
+}
+
+// findPackageInMetadata returns the PackageMetadata entry matching the request, preferring
+// an exact architecture match over an "all" package, or nil when there is none.
+func (r *Repository) findPackageInMetadata(packageName, version, architecture string) *Package {
+	metadata := r.packageMetadata()
+	var fallback *Package
+	for i := range metadata {
+		p := &metadata[i]
+		if p.Name != packageName || (version != "" && p.Version != version) {
+			continue
+		}
+		if architecture == "" || p.Architecture == architecture {
+			return p
+		}
+		if p.Architecture == "all" && fallback == nil {
+			fallback = p
+		}
+	}
+	return fallback
+}
+
+// CheckPackageAvailabilityRemote checks with a HEAD request whether a package exists at
+// its guessed pool URL, without needing Packages metadata.
+func (r *Repository) CheckPackageAvailabilityRemote(packageName, version, architecture string) (bool, error) {
+	packageURL, err := r.buildPackageURL(packageName, version, architecture)
+	if err != nil {
+		return false, err
+	}
+	return r.checkURLExists(packageURL), nil
+}
+
+// Sources of a PackageAvailability.
+const (
+	AvailabilityFromMetadata = "metadata" // Found in, or absent from, the loaded Packages metadata
+	AvailabilityFromProbe    = "probe"    // Found by HEAD requests on guessed pool URLs
+)
+
+// PackageAvailability is the result of CheckPackageAvailabilityDetailed.
+type PackageAvailability struct {
+	Found   bool
+	Section string // Component the package was found in, e.g. "contrib"
+	URL     string // Download URL of the .deb
+	Size    int64  // Size in bytes; -1 when a probe got no Content-Length
+	Source  string // AvailabilityFromMetadata or AvailabilityFromProbe
 }
 
-// CheckPackageAvailability checks if a package exists at the expected URL.
-func (r *Repository) CheckPackageAvailability(packageName, version, architecture string) (bool, error) {
-	return r.checkURLExists(r.buildPackageURL(packageName, version, architecture)), nil
+// CheckPackageAvailabilityDetailed reports where a package is available. The loaded
+// Packages metadata is searched first, as by CheckPackageAvailabilityFromMetadata; when
+// the package is not in it, the default sections the metadata does not cover (all of
+// them without metadata) are probed with HEAD requests on guessed pool URLs, which
+// needs an exact version and architecture. Found is false with a nil error when the
+// metadata and every probe agree that the package is absent; an error is returned when
+// a probe failed for another reason than a 404, so that absence could not be
+// determined.
+func (r *Repository) CheckPackageAvailabilityDetailed(packageName, version, architecture string) (*PackageAvailability, error) {
+	if strings.TrimSpace(packageName) == "" {
+		return nil, ErrEmptyPackageName
+	}
+
+	if pkg := r.findPackageInMetadata(packageName, version, architecture); pkg != nil {
+		availability := &PackageAvailability{Found: true, Size: pkg.Size, Source: AvailabilityFromMetadata}
+		if len(pkg.Sections) > 0 {
+			availability.Section = pkg.Sections[0]
+		}
+		if pkg.Filename != "" {
+			availability.URL = PoolURL(r.URL, pkg.Filename)
+		}
+		return availability, nil
+	}
+
+	sections := defaultComponents
+	source := AvailabilityFromProbe
+	if r.IsMetadataLoaded() {
+		sections = slices.DeleteFunc(slices.Clone(defaultComponents), func(section string) bool { return slices.Contains(r.Components, section) })
+		source = AvailabilityFromMetadata
+	}
+	if len(sections) == 0 {
+		return &PackageAvailability{Source: source}, nil
+	}
+	if version == "" || architecture == "" {
+		return nil, fmt.Errorf("unable to determine availability of %s: probing %s needs a version and architecture", packageName, strings.Join(sections, ", "))
+	}
+
+	var undetermined error
+	for _, section := range sections {
+		packageURL, err := r.buildPackageURLWithComponent(packageName, version, architecture, section)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := r.downloader().doRequestWithRetry(http.MethodHead, packageURL, true)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				undetermined = fmt.Errorf("section %s: %w", section, err)
+			}
+			continue
+		}
+		resp.Body.Close()
+		return &PackageAvailability{Found: true, Section: section, URL: packageURL, Size: resp.ContentLength, Source: AvailabilityFromProbe}, nil
+	}
+
+	if undetermined != nil {
+		return &PackageAvailability{Source: AvailabilityFromProbe}, fmt.Errorf("unable to determine availability of %s_%s_%s: %w", packageName, version, architecture, undetermined)
+	}
+	return &PackageAvailability{Source: AvailabilityFromProbe}, nil
 }
 
 // DownloadPackageFromSources tries to download a package from multiple components.
@@ -887,11 +2165,14 @@ func (r *Repository) DownloadPackageFromSources(packageName, version, architectu
 
 	var lastErr error
 	for _, component := range components {
-		url := r.buildPackageURLWithComponent(packageName, version, architecture, component)
+		url, err := r.buildPackageURLWithComponent(packageName, version, architecture, component)
+		if err != nil {
+			return err
+		}
 
 		if r.checkURLExists(url) {
 			pkg := r.buildPackageStruct(packageName, version, architecture, url)
-			return NewDownloader().DownloadToDirSilent(pkg, destDir)
+			return r.packageDownloader().DownloadToDirSilent(pkg, destDir)
 		}
 
 		lastErr = fmt.Errorf("package not found in component %s", component)
@@ -903,7 +2184,10 @@ func (r *Repository) DownloadPackageFromSources(packageName, version, architectu
 // SearchPackageInComponents searches for a package across all default components.
 func (r *Repository) SearchPackageInComponents(packageName, version, architecture string) (*PackageInfo, error) {
 	for _, component := range defaultComponents {
-		url := r.buildPackageURLWithComponent(packageName, version, architecture, component)
+		url, err := r.buildPackageURLWithComponent(packageName, version, architecture, component)
+		if err != nil {
+			return nil, err
+		}
 
 		resp, err := r.downloader().doRequestWithRetry(http.MethodHead, url, true)
 		if err != nil {
@@ -930,13 +2214,13 @@ func (r *Repository) SearchPackageInComponents(packageName, version, architectur
 // buildPackagesURL constructs the URL for a Packages file.
 func (r *Repository) buildPackagesURL(suite, component, architecture string) string {
 	baseURL := strings.TrimSuffix(r.URL, "/")
-	return fmt.Sprintf("%s/dists/%s/%s/binary-%s/Packages", baseURL, suite, component, architecture)
+	return fmt.Sprintf("%s/dists/%s/%s/binary-%s/Packages", baseURL, r.resolveDistribution(suite), component, architecture)
 }
 
 // buildSourcesURL constructs the URL for a Sources file.
 func (r *Repository) buildSourcesURL(suite, component string) string {
 	baseURL := strings.TrimSuffix(r.URL, "/")
-	return fmt.Sprintf("%s/dists/%s/%s/source/Sources", baseURL, suite, component)
+	return fmt.Sprintf("%s/dists/%s/%s/source/Sources", baseURL, r.resolveDistribution(suite), component)
 }
 
 // EnableReleaseVerification enables checksum verification for downloaded files.
@@ -955,8 +2239,37 @@ func (r *Repository) EnableSignatureVerification() {
 }
 
 // DisableSignatureVerification disables GPG verification for Release/InRelease files.
-func (r *Repository) DisableSignatureVerification() {
+// It returns an error wrapping ErrStrictMode, and keeps verification on, in strict mode.
+func (r *Repository) DisableSignatureVerification() error {
+	if r.StrictMode {
+		return fmt.Errorf("signature verification cannot be disabled: %w", ErrStrictMode)
+	}
 	r.VerifySignature = false
+	return nil
+}
+
+// EnableStrictMode turns on signature and checksum verification and keeps them on:
+// Release files must then be signed and list SHA256 checksums, MD5 checksums are
+// never used, and DisableSignatureVerification fails. It suits environments where
+// MD5 is not acceptable.
+func (r *Repository) EnableStrictMode() {
+	r.StrictMode = true
+	r.VerifySignature = true
+	r.VerifyRelease = true
+}
+
+// DisableStrictMode lifts the restrictions of EnableStrictMode; verification stays
+// enabled until disabled explicitly.
+func (r *Repository) DisableStrictMode() {
+	r.StrictMode = false
+}
+
+// md5Checksums returns the MD5 checksums of the Release file, or none in strict mode.
+func (r *Repository) md5Checksums() []FileChecksum {
+	if r.StrictMode {
+		return nil
+	}
+	return r.ReleaseInfo.MD5Sum
 }
 
 // SetKeyringPaths sets the keyring file paths used for signature verification.
@@ -1055,6 +2368,8 @@ func ResolveKeyringPathsExternal(paths, dirs []string) []string {
 
 // GetReleaseInfo returns the parsed Release file information.
 func (r *Repository) GetReleaseInfo() *ReleaseFile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.ReleaseInfo
 }
 
@@ -1064,20 +2379,35 @@ func (r *Repository) IsReleaseVerificationEnabled() bool {
 }
 
 // downloadAndParsePackagesWithVerification downloads and parses an uncompressed Packages file.
-func (r *Repository) downloadAndParsePackagesWithVerification(packagesURL, component, architecture string) ([]string, error) {
-	resp, err := r.downloader().doRequestWithRetry(http.MethodGet, packagesURL, true)
+func (r *Repository) downloadAndParsePackagesWithVerification(ctx context.Context, packagesURL, component, architecture string) (names []string, err error) {
+	resp, err := r.downloader().doRequestWithRetryContext(ctx, http.MethodGet, packagesURL, true)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving Packages file: %w", err)
 	}
 	defer resp.Body.Close()
 
+	digest := newDigestReader(resp.Body)
+	defer func() {
+		if err == nil {
+			r.recordIndexDigest(component, architecture, packagesURL, digest)
+		}
+	}()
+
+	body, limiter := r.limitMetadataBody(&contextReader{ctx: ctx, reader: digest})
+	if limiter != nil {
+		body = &truncatedStreamReader{reader: body, limit: limiter}
+	}
+
+	progress := r.startIndexProgress(component, architecture, packagesURL, "", resp.ContentLength)
+	defer progress.OnComplete()
+
 	if !r.VerifyRelease || r.ReleaseInfo == nil {
 		// If no verification required, stream parse directly from response body
-		packagedNames, metadata, err := r.parsePackagesFromReader(resp.Body)
+		packagedNames, metadata, err := r.parsePackagesFromReader(ctx, &lineProgressReader{reader: body, progress: progress})
 		if err != nil {
 			return nil, err
 		}
-		r.PackageMetadata = append(r.PackageMetadata, metadata...)
+		r.fetched = append(r.fetched, metadata...)
 		return packagedNames, nil
 	}
 
@@ -1086,33 +2416,58 @@ func (r *Repository) downloadAndParsePackagesWithVerification(packagesURL, compo
 	// because we need to parse valid data only. Here we buffer to verify first.
 	// Optimization: If memory is an issue, consider computing hash via TeeReader
 	// but this risks parsing corrupted data before verification fails.
-	data, err := io.ReadAll(resp.Body)
+	data, err := io.ReadAll(body)
 	if err != nil {
 		return nil, fmt.Errorf("error reading Packages file: %w", err)
 	}
 
-	if err = r.VerifyPackagesFileChecksum(component, architecture, data); err != nil {
-		return nil, fmt.Errorf("failed to verify checksum: %w", err)
+	// A truncated download cannot match the Release checksum; callers are told via ErrMetadataLimitExceeded
+	if limiter == nil || !limiter.truncated {
+		if err = r.VerifyPackagesFileChecksum(component, architecture, data); err != nil {
+			return nil, fmt.Errorf("failed to verify checksum: %w", err)
+		}
 	}
 
-	return r.parsePackagesData(data)
+	packagedNames, metadata, err := r.parsePackagesFromReader(ctx, &lineProgressReader{reader: bytes.NewReader(data), progress: progress})
+	if err != nil {
+		return nil, err
+	}
+	r.fetched = append(r.fetched, metadata...)
+	return packagedNames, nil
 }
 
 // downloadAndParseCompressedPackagesWithVerification downloads and parses a compressed Packages file.
-func (r *Repository) downloadAndParseCompressedPackagesWithVerification(packagesURL, extension, component, architecture string) ([]string, error) {
-	resp, err := r.downloader().doRequestWithRetry(http.MethodGet, packagesURL, true)
+func (r *Repository) downloadAndParseCompressedPackagesWithVerification(ctx context.Context, packagesURL, extension, component, architecture string) (names []string, err error) {
+	resp, err := r.downloader().doRequestWithRetryContext(ctx, http.MethodGet, packagesURL, true)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving compressed Packages file: %w", err)
 	}
 	defer resp.Body.Close()
 
-	reader, cleanup, err := r.createDecompressor(resp.Body, extension)
+	digest := newDigestReader(resp.Body)
+	defer func() {
+		if err == nil {
+			r.recordIndexDigest(component, architecture, packagesURL, digest)
+		}
+	}()
+
+	// The context wrapper sits below the decompressor so a cancelled fetch stops decoding too
+	body, limiter := r.limitMetadataBody(&contextReader{ctx: ctx, reader: digest})
+
+	reader, cleanup, err := r.createDecompressor(body, extension)
 	if err != nil {
 		return nil, err
 	}
 	if cleanup != nil {
 		defer cleanup()
 	}
+	if limiter != nil {
+		reader = &truncatedStreamReader{reader: reader, limit: limiter}
+	}
+
+	progress := r.startIndexProgress(component, architecture, packagesURL, extension, resp.ContentLength)
+	defer progress.OnComplete()
+	reader = &lineProgressReader{reader: reader, progress: progress}
 
 	// Stream parsing with simultaneous checksum verification using TeeReader
 	if r.VerifyRelease && r.ReleaseInfo != nil {
@@ -1121,11 +2476,16 @@ func (r *Repository) downloadAndParseCompressedPackagesWithVerification(packages
 		hasher := sha256.New()
 		teeReader := io.TeeReader(reader, hasher)
 
-		packagedNames, metadata, parseErr := r.parsePackagesFromReader(teeReader)
+		packagedNames, metadata, parseErr := r.parsePackagesFromReader(ctx, teeReader)
 		if parseErr != nil {
 			return nil, parseErr
 		}
 
+		if limiter != nil && limiter.truncated {
+			r.fetched = append(r.fetched, metadata...)
+			return packagedNames, nil
+		}
+
 		// Verify checksum AFTER parsing is complete
 		actualHash := fmt.Sprintf("%x", hasher.Sum(nil))
 		filename := fmt.Sprintf("%s/binary-%s/Packages", component, architecture)
@@ -1136,7 +2496,7 @@ func (r *Repository) downloadAndParseCompressedPackagesWithVerification(packages
 			if checksum.Filename == filename {
 				found = true
 				if actualHash != strings.ToLower(checksum.Hash) {
-					return nil, fmt.Errorf("invalid sha256 checksum for %s. Expected: %s, Actual: %s", filename, checksum.Hash, actualHash)
+					return nil, &ChecksumMismatchError{File: filename, Algorithm: "sha256", Expected: checksum.Hash, Actual: actualHash}
 				}
 				break
 			}
@@ -1154,17 +2514,17 @@ func (r *Repository) downloadAndParseCompressedPackagesWithVerification(packages
 			return nil, fmt.Errorf("no SHA256 checksum found for %s (streaming verification requires SHA256)", filename)
 		}
 
-		r.PackageMetadata = append(r.PackageMetadata, metadata...)
+		r.fetched = append(r.fetched, metadata...)
 		return packagedNames, nil
 	}
 
 	// No verification needed, just stream parse
-	packagedNames, metadata, err := r.parsePackagesFromReader(reader)
+	packagedNames, metadata, err := r.parsePackagesFromReader(ctx, reader)
 	if err != nil {
 		return nil, err
 	}
 
-	r.PackageMetadata = append(r.PackageMetadata, metadata...)
+	r.fetched = append(r.fetched, metadata...)
 	return packagedNames, nil
 }
 
@@ -1191,18 +2551,32 @@ func (r *Repository) createDecompressor(body io.Reader, extension string) (io.Re
 	}
 }
 
+// keepPackage reports whether pkg, fully parsed, passes the predicate of
+// FetchPackagesFiltered, if any.
+func (r *Repository) keepPackage(pkg *Package) bool {
+	return r.packageFilter == nil || r.packageFilter(*pkg)
+}
+
 // parsePackagesFromReader parses package metadata directly from an io.Reader.
-func (r *Repository) parsePackagesFromReader(reader io.Reader) ([]string, []Package, error) {
+// ctx is checked every packagesCancelCheckLines lines; once it is cancelled the
+// context's error is returned instead of the packages parsed so far.
+func (r *Repository) parsePackagesFromReader(ctx context.Context, reader io.Reader) ([]string, []Package, error) {
 	var packages []string
 	var packageMetadata []Package
 
-	scanner := bufio.NewScanner(reader)
-	buf := make([]byte, 0, packagesInitialAlloc)
-	scanner.Buffer(buf, packagesBufferSize)
+	scanner := newLineReader(reader)
 
 	var currentPackage *Package
+	lineCount := 0
 
 	for scanner.Scan() {
+		lineCount++
+		if lineCount%packagesCancelCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+
 		line := scanner.Text()
 		trimmedLine := strings.TrimSpace(line)
 
@@ -1210,8 +2584,10 @@ func (r *Repository) parsePackagesFromReader(reader io.Reader) ([]string, []Pack
 		if trimmedLine == "" {
 			if currentPackage != nil && currentPackage.Name != "" {
 				r.finalizePackage(currentPackage)
-				packageMetadata = append(packageMetadata, *currentPackage)
-				packages = append(packages, currentPackage.Name)
+				if r.keepPackage(currentPackage) {
+					packageMetadata = append(packageMetadata, *currentPackage)
+					packages = append(packages, currentPackage.Name)
+				}
 			}
 			currentPackage = nil
 			continue
@@ -1225,6 +2601,7 @@ func (r *Repository) parsePackagesFromReader(reader io.Reader) ([]string, []Pack
 		// Parse field: value pairs
 		colonIndex := strings.Index(trimmedLine, ":")
 		if colonIndex == -1 {
+			r.warn(WarningMalformedMetadata, "", fmt.Sprintf("Warning: ignoring malformed Packages line %d: %q", lineCount, trimmedLine))
 			continue
 		}
 
@@ -1237,47 +2614,123 @@ func (r *Repository) parsePackagesFromReader(reader io.Reader) ([]string, []Pack
 				Name:    value,
 				Package: value,
 			}
-			continue
 		}
-
-		// Skip if no current package
-		if currentPackage == nil {
-			continue
+
+		// Skip if no current package, or its block was rejected
+		if currentPackage == nil {
+			continue
+		}
+
+		// A rejected block is dropped and its remaining lines skipped
+		if r.fieldFilter != nil && !r.fieldFilter(field, value) {
+			currentPackage = nil
+			continue
+		}
+		if field == "Package" {
+			continue
+		}
+
+		// Parse field using mapping or special handling
+		r.parsePackageField(currentPackage, field, value)
+	}
+
+	// Handle last package if file doesn't end with empty line
+	if currentPackage != nil && currentPackage.Name != "" {
+		r.finalizePackage(currentPackage)
+		if r.keepPackage(currentPackage) {
+			packageMetadata = append(packageMetadata, *currentPackage)
+			packages = append(packages, currentPackage.Name)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading Packages file: %w", err)
+	}
+
+	return packages, packageMetadata, nil
+}
+
+// contextReader fails reads with the context's error once ctx is cancelled.
+type contextReader struct {
+	ctx    context.Context
+	reader io.ReadCloser
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(p)
+}
+
+func (c *contextReader) Close() error {
+	return c.reader.Close()
+}
+
+// lineReader reads lines like bufio.Scanner, but without a cap on their length:
+// Packages and Sources indices may hold fields of several megabytes, such as huge
+// Provides lists. Memory stays bounded by the longest line, as lines are streamed.
+type lineReader struct {
+	reader *bufio.Reader
+	line   []byte
+	err    error
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{reader: bufio.NewReaderSize(r, packagesInitialAlloc)}
+}
+
+// Scan advances to the next line, without its line ending, returning false at the
+// end of the input or on a read error, reported by Err.
+func (l *lineReader) Scan() bool {
+	l.line = l.line[:0]
+	for {
+		fragment, isPrefix, err := l.reader.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+				return false
+			}
+			// A last line filling the buffer exactly is only ended by EOF
+			return len(l.line) > 0
+		}
+		l.line = append(l.line, fragment...)
+		if !isPrefix {
+			return true
 		}
-
-		// Parse field using mapping or special handling
-		r.parsePackageField(currentPackage, field, value)
-	}
-
-	// Handle last package if file doesn't end with empty line
-	if currentPackage != nil && currentPackage.Name != "" {
-		r.finalizePackage(currentPackage)
-		packageMetadata = append(packageMetadata, *currentPackage)
-		packages = append(packages, currentPackage.Name)
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, nil, fmt.Errorf("error reading Packages file: %w", err)
-	}
+// Text returns the line read by the last Scan.
+func (l *lineReader) Text() string {
+	return string(l.line)
+}
 
-	return packages, packageMetadata, nil
+// Err returns the first read error other than io.EOF.
+func (l *lineReader) Err() error {
+	return l.err
 }
 
 // parsePackagesData parses package metadata from Packages file content.
 // Deprecated: use parsePackagesFromReader instead.
 func (r *Repository) parsePackagesData(data []byte) ([]string, error) {
-	packagedNames, metadata, err := r.parsePackagesFromReader(bytes.NewReader(data))
+	packagedNames, metadata, err := r.parsePackagesFromReader(context.Background(), bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 
 	// Accumulate metadata instead of replacing it
-	r.PackageMetadata = append(r.PackageMetadata, metadata...)
+	r.mu.Lock()
+	r.PackageMetadata = append(slices.Clip(r.PackageMetadata), metadata...)
+	r.mu.Unlock()
 	return packagedNames, nil
 }
 
-func (r *Repository) parsePackagesDataInternal(data []byte) ([]string, []Package, error) {
-	return r.parsePackagesFromReader(bytes.NewReader(data))
+func (r *Repository) parsePackagesDataInternal(ctx context.Context, data []byte) ([]string, []Package, error) {
+	return r.parsePackagesFromReader(ctx, bytes.NewReader(data))
 }
 
 // finalizePackage sets default values for a package before storing.
@@ -1307,8 +2760,7 @@ func (r *Repository) parsePackageField(pkg *Package, field, value string) {
 	switch field {
 	case "Filename":
 		pkg.Filename = value
-		baseURL := strings.TrimSuffix(r.URL, "/")
-		pkg.DownloadURL = fmt.Sprintf("%s/%s", baseURL, value)
+		pkg.DownloadURL = PoolURL(r.URL, value)
 	case "Size":
 		if size, err := strconv.ParseInt(value, 10, 64); err == nil {
 			pkg.Size = size
@@ -1319,12 +2771,11 @@ func (r *Repository) parsePackageField(pkg *Package, field, value string) {
 		pkg.SHA1 = value
 	case "SHA256":
 		pkg.SHA256 = value
+	case "SHA512":
+		pkg.SHA512 = value
 	default:
 		// Custom fields (X- prefixed or unknown)
-		if pkg.CustomFields == nil {
-			pkg.CustomFields = make(map[string]string)
-		}
-		pkg.CustomFields[field] = value
+		pkg.setCustomField(field, value)
 	}
 }
 
@@ -1336,7 +2787,7 @@ func (r *Repository) verifyDecompressedFileChecksum(filename string, data []byte
 		}
 	}
 
-	for _, checksum := range r.ReleaseInfo.MD5Sum {
+	for _, checksum := range r.md5Checksums() {
 		if checksum.Filename == filename {
 			return r.verifyDataChecksum(data, checksum.Hash, "md5")
 		}
@@ -1355,6 +2806,14 @@ func (r *Repository) LoadCachedPackages(cacheDir string) ([]string, error) {
 	if r.Suite == "" {
 		return nil, fmt.Errorf("suite is required to load cache")
 	}
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	// Prefer the parsed metadata cache written by the update command when it is current.
+	if err := r.loadMetadataCache(MetadataCachePath(cacheDir, r.Suite), true); err == nil {
+		names, _ := r.packageState()
+		return names, nil
+	}
 
 	allPackages := make(map[string]bool)
 	metadata := make([]Package, 0)
@@ -1362,7 +2821,7 @@ func (r *Repository) LoadCachedPackages(cacheDir string) ([]string, error) {
 	found := false
 
 	for _, component := range r.Components {
-		for _, arch := range r.Architectures {
+		for _, arch := range r.BinaryArchitectures() {
 			cachePath := filepath.Join(cacheDir, r.Suite, component, fmt.Sprintf("binary-%s", arch), "Packages")
 
 			data, err := os.ReadFile(cachePath)
@@ -1373,7 +2832,7 @@ func (r *Repository) LoadCachedPackages(cacheDir string) ([]string, error) {
 				continue
 			}
 
-			names, pkgMetadata, err := r.parsePackagesDataInternal(data)
+			names, pkgMetadata, err := r.parsePackagesDataInternal(context.Background(), data)
 			if err != nil {
 				lastErr = err
 				continue
@@ -1382,7 +2841,8 @@ func (r *Repository) LoadCachedPackages(cacheDir string) ([]string, error) {
 			for _, name := range names {
 				allPackages[name] = true
 			}
-			metadata = append(metadata, pkgMetadata...)
+			start := len(metadata)
+			metadata = mergeSectionPackages(append(metadata, pkgMetadata...), start, component, r.Deduplication)
 			found = true
 		}
 	}
@@ -1399,12 +2859,77 @@ func (r *Repository) LoadCachedPackages(cacheDir string) ([]string, error) {
 		packages = append(packages, name)
 	}
 
-	r.PackageMetadata = metadata
-	r.Packages = packages
+	r.setPackages(packages, metadata)
 
+	// Sources are optional in the cache: they are kept when FetchAndCacheSources ran
+	if _, err := r.loadCachedSources(cacheDir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
 	return packages, nil
 }
 
+// LoadCachedSources loads the Sources files cached by FetchAndCacheSources (or
+// FetchAndCachePackages) in cacheDir into SourceMetadata without any network request,
+// and returns the sorted names of the source packages. It fails when no component has
+// a cached Sources file.
+func (r *Repository) LoadCachedSources(cacheDir string) ([]string, error) {
+	if cacheDir == "" {
+		return nil, fmt.Errorf("cache directory is required")
+	}
+	if r.Suite == "" {
+		return nil, fmt.Errorf("suite is required to load cache")
+	}
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	return r.loadCachedSources(cacheDir)
+}
+
+// loadCachedSources implements LoadCachedSources. The error wraps fs.ErrNotExist when
+// no Sources file is cached.
+func (r *Repository) loadCachedSources(cacheDir string) ([]string, error) {
+	var metadata []SourcePackage
+	allSources := make(map[string]bool)
+	found := false
+
+	for _, component := range r.Components {
+		file, err := os.Open(cachedSourcesPath(cacheDir, r.Suite, component))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read cached sources: %w", err)
+		}
+
+		sources, err := r.parseSourcesFromReader(file, component)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse cached sources of component %s: %w", component, err)
+		}
+
+		for _, sp := range sources {
+			allSources[sp.Name] = true
+		}
+		metadata = append(metadata, sources...)
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no cached sources found for %s: %w", r.Suite, fs.ErrNotExist)
+	}
+
+	r.mu.Lock()
+	r.SourceMetadata = metadata
+	r.mu.Unlock()
+
+	names := make([]string, 0, len(allSources))
+	for name := range allSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 // SetSuite sets the active suite.
 func (r *Repository) SetSuite(suite string) {
 	r.Suite = suite
@@ -1440,13 +2965,14 @@ func (r *Repository) GetPackageMetadata(packageName string) (*Package, error) {
 // when archOrder is empty, the repository architectures are used; when both are empty,
 // the first match is returned.
 func (r *Repository) GetPackageMetadataWithArch(packageName, version string, archOrder []string) (*Package, error) {
-	if len(r.PackageMetadata) == 0 {
+	metadata := r.packageMetadata()
+	if len(metadata) == 0 {
 		return nil, fmt.Errorf("no package metadata available - call FetchPackages() first")
 	}
 
 	matches := make([]*Package, 0)
-	for i := range r.PackageMetadata {
-		p := &r.PackageMetadata[i]
+	for i := range metadata {
+		p := &metadata[i]
 		if p.Name != packageName {
 			continue
 		}
@@ -1494,23 +3020,294 @@ func (r *Repository) GetPackageMetadataWithArch(packageName, version string, arc
 
 // GetAllPackageMetadata returns all package metadata.
 func (r *Repository) GetAllPackageMetadata() []Package {
-	return r.PackageMetadata
+	return r.packageMetadata()
+}
+
+// GetPackageCount returns the number of Packages entries loaded, 0 before any fetch.
+func (r *Repository) GetPackageCount() int {
+	return len(r.packageMetadata())
+}
+
+// IsMetadataLoaded reports whether Packages metadata has been fetched or loaded and
+// holds at least one entry.
+func (r *Repository) IsMetadataLoaded() bool {
+	return r.GetPackageCount() > 0
+}
+
+// ListAllVersions returns every version found in the metadata for each package name,
+// sorted newest-first using Debian version ordering.
+func (r *Repository) ListAllVersions() map[string][]string {
+	metadata := r.packageMetadata()
+	seen := make(map[string]map[string]bool)
+	result := make(map[string][]string)
+
+	for i := range metadata {
+		p := &metadata[i]
+		if seen[p.Name] == nil {
+			seen[p.Name] = make(map[string]bool)
+		}
+		if seen[p.Name][p.Version] {
+			continue
+		}
+		seen[p.Name][p.Version] = true
+		result[p.Name] = append(result[p.Name], p.Version)
+	}
+
+	for _, versions := range result {
+		sort.Slice(versions, func(i, j int) bool {
+			return CompareVersions(versions[i], versions[j]) > 0
+		})
+	}
+
+	return result
+}
+
+// ListAllArchitectures returns the distinct architectures for which a package is available.
+func (r *Repository) ListAllArchitectures(packageName string) []string {
+	metadata := r.packageMetadata()
+	seen := make(map[string]bool)
+	var result []string
+
+	for i := range metadata {
+		p := &metadata[i]
+		if p.Name != packageName || seen[p.Architecture] {
+			continue
+		}
+		seen[p.Architecture] = true
+		result = append(result, p.Architecture)
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// GetAvailableArchitectures returns the architectures the loaded metadata offers
+// packageName for, sorted. It is ListAllArchitectures under the Get* naming of the
+// other metadata accessors.
+func (r *Repository) GetAvailableArchitectures(packageName string) []string {
+	return r.ListAllArchitectures(packageName)
+}
+
+// SortPackagesByName sorts PackageMetadata alphabetically by package name, then
+// newest version first, and reorders Packages to match.
+func (r *Repository) SortPackagesByName() {
+	r.sortPackages(func(a, b *Package) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// SortPackagesByVersion sorts PackageMetadata newest version first, using Debian
+// version ordering, and reorders Packages to match. Equal versions are ordered by name.
+func (r *Repository) SortPackagesByVersion() {
+	r.sortPackages(func(a, b *Package) int {
+		return CompareVersions(b.Version, a.Version)
+	})
+}
+
+// SortPackagesBySize sorts PackageMetadata by Size (the .deb size), largest first, and
+// reorders Packages to match. Equal sizes are ordered by name.
+func (r *Repository) SortPackagesBySize() {
+	r.sortPackages(func(a, b *Package) int {
+		return cmp.Compare(b.Size, a.Size)
+	})
+}
+
+// SortPackagesByInstalledSize sorts PackageMetadata by Installed-Size, largest first,
+// and reorders Packages to match. A missing or unparsable Installed-Size counts as 0.
+func (r *Repository) SortPackagesByInstalledSize() {
+	r.sortPackages(func(a, b *Package) int {
+		return cmp.Compare(parseInstalledSize(b.InstalledSize), parseInstalledSize(a.InstalledSize))
+	})
+}
+
+// sortPackages replaces PackageMetadata with a copy sorted by compare, breaking ties by
+// name then newest version, and rebuilds Packages as the distinct names in the new order.
+// The published slice is not sorted in place, as readers may be iterating over it.
+func (r *Repository) sortPackages(compare func(a, b *Package) int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	metadata := slices.Clone(r.PackageMetadata)
+	sort.Slice(metadata, func(i, j int) bool {
+		a, b := &metadata[i], &metadata[j]
+		if c := compare(a, b); c != 0 {
+			return c < 0
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return CompareVersions(a.Version, b.Version) > 0
+	})
+
+	seen := make(map[string]bool, len(metadata))
+	names := make([]string, 0, len(metadata))
+	for i := range metadata {
+		name := metadata[i].Name
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	r.PackageMetadata = metadata
+	r.Packages = names
+}
+
+// parseInstalledSize returns the Installed-Size value in KiB, or 0 when it is unset
+// or not a number.
+func parseInstalledSize(value string) int64 {
+	size, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// GetPackagesByMaintainer returns the packages whose Maintainer contains query, compared
+// case-insensitively, so either an email address or a team name matches.
+func (r *Repository) GetPackagesByMaintainer(query string) []Package {
+	metadata := r.packageMetadata()
+	queryLower := strings.ToLower(query)
+	var result []Package
+
+	for i := range metadata {
+		if strings.Contains(strings.ToLower(metadata[i].Maintainer), queryLower) {
+			result = append(result, metadata[i])
+		}
+	}
+
+	return result
+}
+
+// GetPackagesByDependencyCount returns the packages with at least minCount entries of
+// the given relationship type (see Package.GetDependencyCount). An unknown type
+// matches no package.
+func (r *Repository) GetPackagesByDependencyCount(depType string, minCount int) []Package {
+	metadata := r.packageMetadata()
+	var result []Package
+
+	for i := range metadata {
+		count, err := metadata[i].GetDependencyCount(depType)
+		if err != nil {
+			return nil
+		}
+		if count >= minCount {
+			result = append(result, metadata[i])
+		}
+	}
+
+	return result
+}
+
+// HardwiredPackage is a package returned by GetPackagesWithHardwiredDeps, with the
+// Depends alternatives that pin an exact version.
+type HardwiredPackage struct {
+	Package Package
+	Pins    []string // e.g. "libfoo (= 1.0-1)"
+}
+
+// GetPackagesWithHardwiredDeps returns the packages with at least one Depends
+// alternative using the = operator. Such exact pins break as soon as the dependency is
+// upgraded alone, which makes them worth auditing.
+func (r *Repository) GetPackagesWithHardwiredDeps() []HardwiredPackage {
+	metadata := r.packageMetadata()
+	var result []HardwiredPackage
+
+	for i := range metadata {
+		var pins []string
+		for _, entry := range metadata[i].Depends {
+			for _, alternative := range strings.Split(entry, "|") {
+				if _, op, _ := parseRelation(alternative); op == "=" {
+					pins = append(pins, strings.TrimSpace(alternative))
+				}
+			}
+		}
+		if len(pins) > 0 {
+			result = append(result, HardwiredPackage{Package: metadata[i], Pins: pins})
+		}
+	}
+
+	return result
+}
+
+// GetReverseConflicts returns the packages whose Conflicts field names packageName,
+// whatever version constraint is attached, e.g. to assess the impact of an update.
+func (r *Repository) GetReverseConflicts(packageName string) []Package {
+	return r.packagesRelatingTo(packageName, func(p *Package) []string { return p.Conflicts })
+}
+
+// GetReverseBreaks returns the packages whose Breaks field names packageName, whatever
+// version constraint is attached.
+func (r *Repository) GetReverseBreaks(packageName string) []Package {
+	return r.packagesRelatingTo(packageName, func(p *Package) []string { return p.Breaks })
+}
+
+// packagesRelatingTo returns the packages for which field lists packageName in any
+// alternative. Version constraints and architecture qualifiers are ignored.
+func (r *Repository) packagesRelatingTo(packageName string, field func(*Package) []string) []Package {
+	metadata := r.packageMetadata()
+	var result []Package
+
+	for i := range metadata {
+		p := &metadata[i]
+		if relationNames(field(p), packageName) {
+			result = append(result, *p)
+		}
+	}
+
+	return result
+}
+
+// relationNames reports whether any alternative of the relationship entries names packageName.
+func relationNames(entries []string, packageName string) bool {
+	for _, entry := range entries {
+		for _, alternative := range strings.Split(entry, "|") {
+			if name, _, _ := parseRelation(alternative); name == packageName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetMaintainerList returns the distinct Maintainer values of the loaded metadata, sorted.
+func (r *Repository) GetMaintainerList() []string {
+	metadata := r.packageMetadata()
+	seen := make(map[string]bool)
+	var result []string
+
+	for i := range metadata {
+		maintainer := metadata[i].Maintainer
+		if maintainer == "" || seen[maintainer] {
+			continue
+		}
+		seen[maintainer] = true
+		result = append(result, maintainer)
+	}
+
+	sort.Strings(result)
+	return result
 }
 
 // GetSourcePackageMetadata returns source package metadata, optionally filtered by version.
-// When version is empty, the first matching entry is returned.
+// When version is empty, the first matching entry is returned. An entry whose Directory
+// is empty or contains ".." is refused with ErrInvalidSourceDirectory.
 func (r *Repository) GetSourcePackageMetadata(packageName, version string) (*SourcePackage, error) {
-	if len(r.SourceMetadata) == 0 {
+	sources := r.sourceMetadata()
+	if len(sources) == 0 {
 		return nil, fmt.Errorf("no source package metadata available - call FetchSources() first")
 	}
 
-	for i := range r.SourceMetadata {
-		sp := &r.SourceMetadata[i]
+	for i := range sources {
+		sp := &sources[i]
 		if sp.Name != packageName {
 			continue
 		}
 
 		if version == "" || sp.Version == version {
+			// Metadata loaded from a cache or set directly was not checked by the parser
+			if err := checkSourceDirectory(sp.Directory); err != nil {
+				return nil, fmt.Errorf("source package %s: %w", sp.Name, err)
+			}
 			return sp, nil
 		}
 	}
@@ -1522,9 +3319,59 @@ func (r *Repository) GetSourcePackageMetadata(packageName, version string) (*Sou
 	return nil, fmt.Errorf("version %s not found for source package %s", version, packageName)
 }
 
+// GetSourcePackageMetadataAll returns a copy of every source package entry named
+// packageName, newest version first.
+func (r *Repository) GetSourcePackageMetadataAll(packageName string) []SourcePackage {
+	sources := r.sourceMetadata()
+	var result []SourcePackage
+	for i := range sources {
+		if sources[i].Name == packageName {
+			result = append(result, sources[i])
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return CompareVersions(result[i].Version, result[j].Version) > 0
+	})
+	return result
+}
+
+// GetSourcePackageLatest returns the newest version of the source package named
+// packageName.
+func (r *Repository) GetSourcePackageLatest(packageName string) (*SourcePackage, error) {
+	sources := r.sourceMetadata()
+	if len(sources) == 0 {
+		return nil, ErrNoSourceMetadata
+	}
+
+	var latest *SourcePackage
+	for i := range sources {
+		sp := &sources[i]
+		if sp.Name == packageName && (latest == nil || CompareVersions(sp.Version, latest.Version) > 0) {
+			latest = sp
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("source package '%s' not found in metadata", packageName)
+	}
+	return latest, nil
+}
+
 // GetAllSourceMetadata returns all source package metadata.
 func (r *Repository) GetAllSourceMetadata() []SourcePackage {
-	return r.SourceMetadata
+	return r.sourceMetadata()
+}
+
+// GetSourcePackageCount returns the number of Sources entries loaded, 0 before any
+// fetch.
+func (r *Repository) GetSourcePackageCount() int {
+	return len(r.sourceMetadata())
+}
+
+// IsSourceMetadataLoaded reports whether Sources metadata has been fetched and holds
+// at least one entry.
+func (r *Repository) IsSourceMetadataLoaded() bool {
+	return r.GetSourcePackageCount() > 0
 }
 
 // ResolveDependencies returns all packages required for the given specs, following dependency
@@ -1533,13 +3380,14 @@ func (r *Repository) GetAllSourceMetadata() []SourcePackage {
 // Default behavior (exclude empty) mirrors apt: Depends + Pre-Depends + Recommends; other
 // relationships are included unless explicitly excluded.
 func (r *Repository) ResolveDependencies(specs []PackageSpec, exclude map[string]bool) (map[string]Package, error) {
-	if len(r.PackageMetadata) == 0 {
+	if r.GetPackageCount() == 0 {
 		return nil, fmt.Errorf("no package metadata available - call FetchPackages() first")
 	}
+	metadata := r.packageMetadata()
 
-	index := make(map[string]*Package, len(r.PackageMetadata))
-	for i := range r.PackageMetadata {
-		p := &r.PackageMetadata[i]
+	index := make(map[string]*Package, len(metadata))
+	for i := range metadata {
+		p := &metadata[i]
 		if _, exists := index[p.Name]; !exists {
 			index[p.Name] = p
 		}
@@ -1602,6 +3450,19 @@ func (r *Repository) collectDependencies(pkg *Package, exclude map[string]bool)
 	return deps
 }
 
+// FindConflicts checks every pair in pkgs and returns the pairs that conflict.
+func (r *Repository) FindConflicts(pkgs []Package) [][2]Package {
+	var conflicts [][2]Package
+	for i := range pkgs {
+		for j := i + 1; j < len(pkgs); j++ {
+			if pkgs[i].HasConflictWith(pkgs[j]) {
+				conflicts = append(conflicts, [2]Package{pkgs[i], pkgs[j]})
+			}
+		}
+	}
+	return conflicts
+}
+
 // chooseAvailableAlternative returns the first available package name from an OR expression.
 func chooseAvailableAlternative(expr string, index map[string]*Package) string {
 	parts := strings.Split(expr, "|")
@@ -1622,13 +3483,76 @@ func chooseAvailableAlternative(expr string, index map[string]*Package) string {
 
 // FetchReleaseFile downloads and parses the Release file from the repository.
 func (r *Repository) FetchReleaseFile() error {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+	return r.fetchReleaseFile(context.Background())
+}
+
+// FetchReleaseFileAsync starts FetchReleaseFile in a goroutine and returns at once.
+// The returned channel receives exactly one value, nil or the error, when the fetch
+// is done; cancelling ctx aborts the downloads. The repository must not be used by
+// the caller until then: receive from the channel or call WaitForRelease first.
+// FetchPackages, FetchAndCachePackages and FetchSources wait for a pending fetch and
+// reuse its result instead of downloading the Release file again.
+func (r *Repository) FetchReleaseFileAsync(ctx context.Context) <-chan error {
+	fetch := &releaseFetch{done: make(chan struct{})}
+	r.pendingRelease = fetch
+
+	result := make(chan error, 1)
+	go func() {
+		fetch.err = r.fetchReleaseFile(ctx)
+		close(fetch.done)
+		result <- fetch.err
+	}()
+	return result
+}
+
+// WaitForRelease blocks until a fetch started by FetchReleaseFileAsync completes and
+// returns its result. It returns nil at once when no fetch was started.
+func (r *Repository) WaitForRelease() error {
+	if r.pendingRelease == nil {
+		return nil
+	}
+	<-r.pendingRelease.done
+	return r.pendingRelease.err
+}
+
+// loadReleaseForIndices prepares the Release file before index downloads. It waits for
+// a fetch started by FetchReleaseFileAsync and, with VerifyRelease, fetches the Release
+// file unless that pending fetch just did.
+func (r *Repository) loadReleaseForIndices() error {
+	pending := r.pendingRelease != nil
+	err := r.WaitForRelease()
+	r.pendingRelease = nil
+
+	if !r.VerifyRelease {
+		return nil
+	}
+	if !pending {
+		err = r.fetchReleaseFile(context.Background())
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving Release file: %w", err)
+	}
+	return nil
+}
+
+// fetchReleaseFile is FetchReleaseFile bound to ctx.
+func (r *Repository) fetchReleaseFile(ctx context.Context) error {
 	var releaseData []byte
 	var err error
 
+	if r.StrictMode && !r.VerifySignature {
+		return fmt.Errorf("unsigned Release file for suite %s: %w", r.Suite, ErrStrictMode)
+	}
+	if r.requiredFingerprint != "" && !r.VerifySignature {
+		return fmt.Errorf("unsigned Release file for suite %s: %w", r.Suite, ErrSignerMismatch)
+	}
+	r.signatures = nil
 	if r.VerifySignature {
-		releaseData, err = r.fetchSignedRelease()
+		releaseData, err = r.fetchSignedRelease(ctx)
 	} else {
-		releaseData, err = r.fetchUnsignedRelease()
+		releaseData, err = r.fetchUnsignedRelease(ctx)
 	}
 
 	if err != nil {
@@ -1639,52 +3563,75 @@ func (r *Repository) FetchReleaseFile() error {
 	if err != nil {
 		return fmt.Errorf("error parsing Release file: %w", err)
 	}
+	if r.StrictMode && len(releaseInfo.SHA256) == 0 {
+		return fmt.Errorf("Release file for suite %s has no SHA256 checksums: %w", r.Suite, ErrStrictMode)
+	}
 
-	r.ReleaseInfo = releaseInfo
+	r.setReleaseInfo(releaseInfo)
+	for _, warning := range releaseInfo.ParseWarnings {
+		r.warn(WarningMalformedMetadata, r.Suite, fmt.Sprintf("Warning: Release file for suite %s: %s", r.Suite, warning))
+	}
+	if target := r.resolveDistribution(r.Suite); releaseInfo.Codename != r.Suite && releaseInfo.Codename == target {
+		r.warn(WarningReleaseMismatch, r.Suite, fmt.Sprintf("Notice: suite %s resolved to codename %s", r.Suite, target))
+	}
+	if err := r.expandArchitectures(); err != nil {
+		return err
+	}
+	if r.AutoAdjustComponents {
+		return r.adjustComponentsToRelease()
+	}
 	return nil
 }
 
 // buildReleaseURL constructs the URL for the Release file.
 func (r *Repository) buildReleaseURL() string {
 	baseURL := strings.TrimSuffix(r.URL, "/")
-	return fmt.Sprintf("%s/dists/%s/Release", baseURL, r.Suite)
+	return fmt.Sprintf("%s/dists/%s/Release", baseURL, r.resolveDistribution(r.Suite))
 }
 
 // buildInReleaseURL constructs the URL for the InRelease file.
 func (r *Repository) buildInReleaseURL() string {
 	baseURL := strings.TrimSuffix(r.URL, "/")
-	return fmt.Sprintf("%s/dists/%s/InRelease", baseURL, r.Suite)
+	return fmt.Sprintf("%s/dists/%s/InRelease", baseURL, r.resolveDistribution(r.Suite))
 }
 
 // fetchUnsignedRelease downloads the Release file without signature verification.
-func (r *Repository) fetchUnsignedRelease() ([]byte, error) {
-	return r.fetchURL(r.buildReleaseURL())
+func (r *Repository) fetchUnsignedRelease(ctx context.Context) ([]byte, error) {
+	return r.fetchURL(ctx, r.buildReleaseURL())
 }
 
 // fetchSignedRelease downloads and verifies InRelease or Release+Release.gpg.
-func (r *Repository) fetchSignedRelease() ([]byte, error) {
+func (r *Repository) fetchSignedRelease(ctx context.Context) ([]byte, error) {
 	// Prefer InRelease (clearsigned)
 	inReleaseURL := r.buildInReleaseURL()
-	inReleaseData, err := r.fetchURL(inReleaseURL)
+	inReleaseData, err := r.fetchURL(ctx, inReleaseURL)
 	if err == nil {
-		if err := r.verifyClearsigned(inReleaseData); err == nil {
+		if err = r.verifyClearsigned(inReleaseData); err == nil {
 			content, extractErr := extractClearsignedContent(inReleaseData)
 			if extractErr != nil {
 				return nil, extractErr
 			}
+			if err := r.cacheSignedRelease(map[string][]byte{"InRelease": inReleaseData}); err != nil {
+				return nil, err
+			}
 			return content, nil
 		}
+		// Release.gpg is made with the same keys: falling back cannot satisfy the pin
+		if errors.Is(err, ErrSignerMismatch) {
+			return nil, err
+		}
 	}
 
 	// Fallback to Release + Release.gpg
+	r.warn(WarningSignatureDowngrade, inReleaseURL, fmt.Sprintf("Warning: InRelease for suite %s unusable (%v), falling back to Release and Release.gpg", r.Suite, err))
 	releaseURL := r.buildReleaseURL()
-	releaseData, err := r.fetchURL(releaseURL)
+	releaseData, err := r.fetchURL(ctx, releaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch Release file: %w", err)
 	}
 
 	signatureURL := releaseURL + ".gpg"
-	signatureData, err := r.fetchURL(signatureURL)
+	signatureData, err := r.fetchURL(ctx, signatureURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch Release.gpg: %w", err)
 	}
@@ -1692,6 +3639,9 @@ func (r *Repository) fetchSignedRelease() ([]byte, error) {
 	if err := r.verifyDetachedSignature(releaseData, signatureData); err != nil {
 		return nil, err
 	}
+	if err := r.cacheSignedRelease(map[string][]byte{"Release": releaseData, "Release.gpg": signatureData}); err != nil {
+		return nil, err
+	}
 
 	return releaseData, nil
 }
@@ -1704,6 +3654,7 @@ func (r *Repository) parseReleaseFile(content string) (*ReleaseFile, error) {
 		MD5Sum:        make([]FileChecksum, 0),
 		SHA1:          make([]FileChecksum, 0),
 		SHA256:        make([]FileChecksum, 0),
+		SHA512:        make([]FileChecksum, 0),
 	}
 
 	lines := strings.Split(content, "\n")
@@ -1726,12 +3677,16 @@ func (r *Repository) parseReleaseFile(content string) (*ReleaseFile, error) {
 		} else if line == "SHA256:" {
 			currentSection = "SHA256"
 			continue
+		} else if line == "SHA512:" {
+			currentSection = "SHA512"
+			continue
 		}
 
 		if currentSection != "" && strings.HasPrefix(originalLine, " ") {
 			checksum, err := r.parseChecksumLine(originalLine)
 			if err != nil {
-				continue // ignore malformed checksum lines
+				release.ParseWarnings = append(release.ParseWarnings, fmt.Sprintf("ignoring malformed %s line %q", currentSection, line))
+				continue
 			}
 
 			switch currentSection {
@@ -1741,6 +3696,8 @@ func (r *Repository) parseReleaseFile(content string) (*ReleaseFile, error) {
 				release.SHA1 = append(release.SHA1, *checksum)
 			case "SHA256":
 				release.SHA256 = append(release.SHA256, *checksum)
+			case "SHA512":
+				release.SHA512 = append(release.SHA512, *checksum)
 			}
 			continue
 		}
@@ -1767,6 +3724,10 @@ func (r *Repository) parseReleaseFile(content string) (*ReleaseFile, error) {
 				release.Codename = value
 			case "Date":
 				release.Date = value
+				release.ParsedDate = release.parseDateField(key, value)
+			case "Valid-Until":
+				release.ValidUntil = value
+				release.ParsedValidUntil = release.parseDateField(key, value)
 			case "Description":
 				release.Description = value
 			case "Architectures":
@@ -1780,8 +3741,17 @@ func (r *Repository) parseReleaseFile(content string) (*ReleaseFile, error) {
 	return release, nil
 }
 
-func (r *Repository) fetchURL(url string) ([]byte, error) {
-	resp, err := r.downloader().doRequestWithRetry(http.MethodGet, url, true)
+// parseDateField parses a date field, recording a parse warning when it is invalid.
+func (rf *ReleaseFile) parseDateField(key, value string) time.Time {
+	t, err := parseReleaseDate(value)
+	if err != nil {
+		rf.ParseWarnings = append(rf.ParseWarnings, fmt.Sprintf("invalid %s field: %v", key, err))
+	}
+	return t
+}
+
+func (r *Repository) fetchURL(ctx context.Context, url string) ([]byte, error) {
+	resp, err := r.downloader().doRequestWithRetryContext(ctx, http.MethodGet, url, true)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving %s: %w", url, err)
 	}
@@ -1796,47 +3766,49 @@ func (r *Repository) fetchURL(url string) ([]byte, error) {
 }
 
 func (r *Repository) verifyClearsigned(data []byte) error {
-	return r.verifyWithGPG(data, nil, true)
+	signatures, err := verifyWithGPG(data, nil, true, r.KeyringPaths, r.TempDir)
+	if err != nil {
+		return err
+	}
+	return r.acceptSignatures(signatures)
 }
 
 func (r *Repository) verifyDetachedSignature(payload, signature []byte) error {
-	return r.verifyWithGPG(payload, signature, false)
+	signatures, err := verifyWithGPG(payload, signature, false, r.KeyringPaths, r.TempDir)
+	if err != nil {
+		return err
+	}
+	return r.acceptSignatures(signatures)
 }
 
-func (r *Repository) verifyWithGPG(payload, signature []byte, clearsigned bool) error {
+// verifyWithGPG checks a clearsigned payload, or a payload with its detached
+// signature, against the given keyrings using gpgv, and returns the valid signatures
+// it reported. Temporary files are created in tempDir (os.TempDir() when empty) and
+// removed on every return path.
+func verifyWithGPG(payload, signature []byte, clearsigned bool, keyrings []string, tempDir string) ([]SignatureInfo, error) {
 	// Get gpgv executable (OS-aware, returns error on Windows if not found)
 	gpgvPath, err := getGPGVCommand()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	releaseFile, err := os.CreateTemp("", "deb-release-*.txt")
+	releasePath, err := writeTempFile(tempDir, "deb-release-*.txt", payload)
 	if err != nil {
-		return fmt.Errorf("unable to create temp file for release: %w", err)
-	}
-	defer os.Remove(releaseFile.Name())
-
-	if err := os.WriteFile(releaseFile.Name(), payload, FilePermission); err != nil {
-		return fmt.Errorf("unable to write release data: %w", err)
+		return nil, fmt.Errorf("unable to write release data: %w", err)
 	}
+	defer os.Remove(releasePath)
 
 	var signatureFile string
 	if !clearsigned {
-		sig, err := os.CreateTemp("", "deb-release-sig-*.gpg")
+		signatureFile, err = writeTempFile(tempDir, "deb-release-sig-*.gpg", signature)
 		if err != nil {
-			return fmt.Errorf("unable to create temp signature file: %w", err)
-		}
-		defer os.Remove(sig.Name())
-
-		if err := os.WriteFile(sig.Name(), signature, FilePermission); err != nil {
-			return fmt.Errorf("unable to write signature data: %w", err)
+			return nil, fmt.Errorf("unable to write signature data: %w", err)
 		}
-
-		signatureFile = sig.Name()
+		defer os.Remove(signatureFile)
 	}
 
 	args := []string{"--status-fd", "1"}
-	for _, keyring := range r.KeyringPaths {
+	for _, keyring := range keyrings {
 		trimmed := strings.TrimSpace(keyring)
 		if trimmed != "" {
 			args = append(args, "--keyring", trimmed)
@@ -1844,18 +3816,38 @@ func (r *Repository) verifyWithGPG(payload, signature []byte, clearsigned bool)
 	}
 
 	if clearsigned {
-		args = append(args, releaseFile.Name())
+		args = append(args, releasePath)
 	} else {
-		args = append(args, signatureFile, releaseFile.Name())
+		args = append(args, signatureFile, releasePath)
 	}
 
 	cmd := exec.Command(gpgvPath, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("gpg verification failed: %w: %s", err, string(output))
+		return nil, fmt.Errorf("gpg verification failed: %w: %s", err, string(output))
 	}
 
-	return nil
+	return parseGPGStatus(output), nil
+}
+
+// writeTempFile writes data to a new temporary file in dir and returns its path.
+// The file is removed again if writing fails.
+func writeTempFile(dir, pattern string, data []byte) (string, error) {
+	file, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
 }
 
 func extractClearsignedContent(data []byte) ([]byte, error) {
@@ -1912,7 +3904,7 @@ func (r *Repository) parseChecksumLine(line string) (*FileChecksum, error) {
 }
 
 // VerifyPackagesFileChecksum verifies the checksum of a Packages file against
-// the checksums in the Release file. It prefers SHA256 over MD5.
+// the checksums in the Release file. It prefers SHA512, then SHA256, then MD5.
 func (r *Repository) VerifyPackagesFileChecksum(section, architecture string, data []byte) error {
 	if r.ReleaseInfo == nil {
 		return fmt.Errorf("Release information unavailable - call FetchReleaseFile() first")
@@ -1920,14 +3912,19 @@ func (r *Repository) VerifyPackagesFileChecksum(section, architecture string, da
 
 	filename := fmt.Sprintf("%s/binary-%s/Packages", section, architecture)
 
-	// Prefer SHA256 over MD5
+	for _, checksum := range r.ReleaseInfo.SHA512 {
+		if checksum.Filename == filename {
+			return r.verifyDataChecksum(data, checksum.Hash, "sha512")
+		}
+	}
+
 	for _, checksum := range r.ReleaseInfo.SHA256 {
 		if checksum.Filename == filename {
 			return r.verifyDataChecksum(data, checksum.Hash, "sha256")
 		}
 	}
 
-	for _, checksum := range r.ReleaseInfo.MD5Sum {
+	for _, checksum := range r.md5Checksums() {
 		if checksum.Filename == filename {
 			return r.verifyDataChecksum(data, checksum.Hash, "md5")
 		}
@@ -1951,7 +3948,7 @@ func (r *Repository) VerifySourcesFileChecksum(section string, data []byte) erro
 		}
 	}
 
-	for _, checksum := range r.ReleaseInfo.MD5Sum {
+	for _, checksum := range r.md5Checksums() {
 		if checksum.Filename == filename {
 			return r.verifyDataChecksum(data, checksum.Hash, "md5")
 		}
@@ -1960,6 +3957,22 @@ func (r *Repository) VerifySourcesFileChecksum(section string, data []byte) erro
 	return fmt.Errorf("no checksum found for file %s", filename)
 }
 
+// ChecksumMismatchError reports index content whose checksum differs from the one
+// listed in the Release file.
+type ChecksumMismatchError struct {
+	File      string // Empty when the caller names the file itself
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("invalid %s checksum. Expected: %s, Actual: %s", e.Algorithm, e.Expected, e.Actual)
+	}
+	return fmt.Sprintf("invalid %s checksum for %s. Expected: %s, Actual: %s", e.Algorithm, e.File, e.Expected, e.Actual)
+}
+
 // verifyDataChecksum computes and verifies a checksum against expected value.
 func (r *Repository) verifyDataChecksum(data []byte, expectedHash, hashType string) error {
 	var hasher hash.Hash
@@ -1969,6 +3982,8 @@ func (r *Repository) verifyDataChecksum(data []byte, expectedHash, hashType stri
 		hasher = md5.New()
 	case "sha256":
 		hasher = sha256.New()
+	case "sha512":
+		hasher = sha512.New()
 	default:
 		return fmt.Errorf("unsupported hash type: %s", hashType)
 	}
@@ -1977,7 +3992,7 @@ func (r *Repository) verifyDataChecksum(data []byte, expectedHash, hashType stri
 	actualHash := fmt.Sprintf("%x", hasher.Sum(nil))
 
 	if actualHash != strings.ToLower(expectedHash) {
-		return fmt.Errorf("invalid %s checksum. Expected: %s, Actual: %s", hashType, expectedHash, actualHash)
+		return &ChecksumMismatchError{Algorithm: hashType, Expected: expectedHash, Actual: actualHash}
 	}
 
 	return nil
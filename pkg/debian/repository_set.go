@@ -0,0 +1,176 @@
+package debian
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RepositorySet searches several suites of an archive, e.g. bookworm,
+// bookworm-updates and bookworm-security, so that a version published in only one of
+// them is found without knowing which. Load the metadata of every repository before
+// searching the set.
+type RepositorySet struct {
+	Repositories []*Repository // Searched in order for a given version
+}
+
+// NewRepositorySet creates a set searching repos in the given order.
+func NewRepositorySet(repos ...*Repository) *RepositorySet {
+	return &RepositorySet{Repositories: repos}
+}
+
+// SuiteVersions lists the versions of a package one suite provides, newest first.
+type SuiteVersions struct {
+	Suite    string
+	Versions []string
+}
+
+// VersionNotFoundError reports a version of a package that no suite of a
+// RepositorySet provides, although some suites provide other versions.
+type VersionNotFoundError struct {
+	Package   string
+	Version   string
+	Available []SuiteVersions // In search order; suites without the package are left out
+}
+
+func (e *VersionNotFoundError) Error() string {
+	parts := make([]string, len(e.Available))
+	for i, suite := range e.Available {
+		parts[i] = suite.Suite + ": " + strings.Join(suite.Versions, ", ")
+	}
+	return fmt.Sprintf("version %s of package %s not found in any suite (available: %s)", e.Version, e.Package, strings.Join(parts, "; "))
+}
+
+// FindPackage returns the binary package packageName at version from the first
+// repository of s listing it, along with the suite of that repository. When version
+// is empty, the latest version across all repositories is returned, the first
+// repository winning ties, so that e.g. a security update is preferred over the
+// version of the base suite. archOrder ranks the architectures as for
+// Repository.GetPackageMetadataWithArch. When the version is in no suite but the
+// package is, the error is a *VersionNotFoundError listing the versions of each suite.
+func (s *RepositorySet) FindPackage(packageName, version string, archOrder []string) (*Package, string, error) {
+	if len(s.Repositories) == 0 {
+		return nil, "", fmt.Errorf("no repository to search for package %s", packageName)
+	}
+
+	var (
+		found      *Package
+		foundSuite string
+		firstErr   error
+	)
+	for _, repo := range s.Repositories {
+		pkg, err := repo.GetPackageMetadataWithArch(packageName, version, archOrder)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if version != "" {
+			return pkg, repo.Suite, nil
+		}
+		if found == nil || CompareVersions(pkg.Version, found.Version) > 0 {
+			found, foundSuite = pkg, repo.Suite
+		}
+	}
+	if found != nil {
+		return found, foundSuite, nil
+	}
+
+	var available []SuiteVersions
+	for _, repo := range s.Repositories {
+		if versions := repo.packageVersions(packageName); len(versions) > 0 {
+			available = append(available, SuiteVersions{Suite: repo.Suite, Versions: versions})
+		}
+	}
+	return nil, "", s.notFound(packageName, version, available, firstErr)
+}
+
+// FindSourcePackage is FindPackage for source packages, searching the Sources
+// metadata of each repository.
+func (s *RepositorySet) FindSourcePackage(packageName, version string) (*SourcePackage, string, error) {
+	if len(s.Repositories) == 0 {
+		return nil, "", fmt.Errorf("no repository to search for source package %s", packageName)
+	}
+
+	var (
+		found      *SourcePackage
+		foundSuite string
+		firstErr   error
+	)
+	for _, repo := range s.Repositories {
+		// GetSourcePackageMetadata checks the directory of the entry it returns
+		lookup := version
+		if lookup == "" {
+			if latest, err := repo.GetSourcePackageLatest(packageName); err == nil {
+				lookup = latest.Version
+			}
+		}
+		sp, err := repo.GetSourcePackageMetadata(packageName, lookup)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if version != "" {
+			return sp, repo.Suite, nil
+		}
+		if found == nil || CompareVersions(sp.Version, found.Version) > 0 {
+			found, foundSuite = sp, repo.Suite
+		}
+	}
+	if found != nil {
+		return found, foundSuite, nil
+	}
+
+	var available []SuiteVersions
+	for _, repo := range s.Repositories {
+		var versions []string
+		for _, sp := range repo.GetSourcePackageMetadataAll(packageName) {
+			if len(versions) == 0 || versions[len(versions)-1] != sp.Version {
+				versions = append(versions, sp.Version)
+			}
+		}
+		if len(versions) > 0 {
+			available = append(available, SuiteVersions{Suite: repo.Suite, Versions: versions})
+		}
+	}
+	return nil, "", s.notFound(packageName, version, available, firstErr)
+}
+
+// notFound returns the error of a search for packageName that found nothing:
+// a *VersionNotFoundError when other versions are available, and otherwise the error
+// of the first repository, naming the suites searched when there are several.
+func (s *RepositorySet) notFound(packageName, version string, available []SuiteVersions, firstErr error) error {
+	if version != "" && len(available) > 0 {
+		return &VersionNotFoundError{Package: packageName, Version: version, Available: available}
+	}
+	if len(s.Repositories) == 1 {
+		return firstErr
+	}
+	suites := make([]string, len(s.Repositories))
+	for i, repo := range s.Repositories {
+		suites[i] = repo.Suite
+	}
+	return fmt.Errorf("%w (suites searched: %s)", firstErr, strings.Join(suites, ", "))
+}
+
+// packageVersions returns the versions of packageName in the Packages metadata,
+// newest first.
+func (r *Repository) packageVersions(packageName string) []string {
+	seen := make(map[string]bool)
+	var versions []string
+	metadata := r.packageMetadata()
+	for i := range metadata {
+		p := &metadata[i]
+		if p.Name == packageName && !seen[p.Version] {
+			seen[p.Version] = true
+			versions = append(versions, p.Version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) > 0
+	})
+	return versions
+}
@@ -0,0 +1,72 @@
+package debian
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRepositorySetFindPackage(t *testing.T) {
+	bookworm := NewRepository("bookworm", "http://deb.example/debian", "", "bookworm", []string{"main"}, []string{"amd64"})
+	bookworm.setPackages([]string{"openssl", "hello"}, []Package{
+		{Name: "openssl", Version: "3.0.14-1~deb12u2", Architecture: "amd64"},
+		{Name: "hello", Version: "2.10-3", Architecture: "amd64"},
+	})
+	bookworm.SourceMetadata = []SourcePackage{{Name: "openssl", Version: "3.0.14-1~deb12u2", Directory: "pool/main/o/openssl"}}
+	security := NewRepository("security", "http://deb.example/debian", "", "bookworm-security", []string{"main"}, []string{"amd64"})
+	security.setPackages([]string{"openssl"}, []Package{
+		{Name: "openssl", Version: "3.0.15-1~deb12u1", Architecture: "amd64"},
+		{Name: "openssl", Version: "3.0.13-1~deb12u1", Architecture: "amd64"},
+	})
+	security.SourceMetadata = []SourcePackage{
+		{Name: "openssl", Version: "3.0.13-1~deb12u1", Directory: "pool/updates/main/o/openssl"},
+		{Name: "openssl", Version: "3.0.15-1~deb12u1", Directory: "pool/updates/main/o/openssl"},
+	}
+	set := NewRepositorySet(bookworm, security)
+
+	pkg, suite, err := set.FindPackage("openssl", "3.0.15-1~deb12u1", nil)
+	if err != nil || suite != "bookworm-security" || pkg.Version != "3.0.15-1~deb12u1" {
+		t.Fatalf("expected the version of bookworm-security, got %v from %q (err %v)", pkg, suite, err)
+	}
+	if pkg, suite, err := set.FindPackage("openssl", "", nil); err != nil || suite != "bookworm-security" || pkg.Version != "3.0.15-1~deb12u1" {
+		t.Fatalf("expected the latest version, only in the second suite, without a version, got %v from %q (err %v)", pkg, suite, err)
+	}
+	if pkg, suite, err := set.FindPackage("hello", "", nil); err != nil || suite != "bookworm" || pkg.Version != "2.10-3" {
+		t.Fatalf("expected hello from the only suite listing it, got %v from %q (err %v)", pkg, suite, err)
+	}
+
+	_, _, err = set.FindPackage("openssl", "3.0.16-1", nil)
+	var versionErr *VersionNotFoundError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("expected a *VersionNotFoundError, got %v", err)
+	}
+	want := []SuiteVersions{
+		{Suite: "bookworm", Versions: []string{"3.0.14-1~deb12u2"}},
+		{Suite: "bookworm-security", Versions: []string{"3.0.15-1~deb12u1", "3.0.13-1~deb12u1"}},
+	}
+	if !reflect.DeepEqual(versionErr.Available, want) {
+		t.Fatalf("got available versions %+v, want %+v", versionErr.Available, want)
+	}
+	if !strings.Contains(err.Error(), "bookworm-security: 3.0.15-1~deb12u1, 3.0.13-1~deb12u1") {
+		t.Fatalf("expected the error to list the versions per suite, got %v", err)
+	}
+
+	if _, _, err := set.FindPackage("missing", "1.0", nil); err == nil || errors.As(err, &versionErr) || !strings.Contains(err.Error(), "bookworm, bookworm-security") {
+		t.Fatalf("expected an error naming the suites searched, got %v", err)
+	}
+
+	sp, suite, err := set.FindSourcePackage("openssl", "")
+	if err != nil || suite != "bookworm-security" || sp.Version != "3.0.15-1~deb12u1" {
+		t.Fatalf("expected the latest source, from bookworm-security, got %v from %q (err %v)", sp, suite, err)
+	}
+	if sp, suite, err := set.FindSourcePackage("openssl", "3.0.14-1~deb12u2"); err != nil || suite != "bookworm" || sp.Directory != "pool/main/o/openssl" {
+		t.Fatalf("expected the source of bookworm, got %v from %q (err %v)", sp, suite, err)
+	}
+	if sp, suite, err := set.FindSourcePackage("openssl", "3.0.15-1~deb12u1"); err != nil || suite != "bookworm-security" || sp.Directory != "pool/updates/main/o/openssl" {
+		t.Fatalf("expected the source of bookworm-security, got %v from %q (err %v)", sp, suite, err)
+	}
+	if _, _, err := set.FindSourcePackage("openssl", "3.0.16-1"); !errors.As(err, &versionErr) || len(versionErr.Available) != 2 {
+		t.Fatalf("expected a *VersionNotFoundError listing both suites, got %v", err)
+	}
+}
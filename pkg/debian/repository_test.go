@@ -0,0 +1,1862 @@
+package debian
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CeGenreDeChat/deb-for-all/internal/testsupport"
+	"github.com/ProtonMail/gopenpgp/v3/crypto"
+)
+
+func TestListAllVersionsSortedNewestFirst(t *testing.T) {
+	repo := &Repository{PackageMetadata: []Package{
+		{Name: "hello", Version: "2.10-2", Architecture: "amd64"},
+		{Name: "hello", Version: "2.10-10", Architecture: "amd64"},
+		{Name: "hello", Version: "1:1.0-1", Architecture: "amd64"},
+		{Name: "hello", Version: "2.10-2", Architecture: "arm64"},
+		{Name: "hello", Version: "2.10~rc1-1", Architecture: "amd64"},
+		{Name: "bash", Version: "5.2-2", Architecture: "amd64"},
+	}}
+
+	versions := repo.ListAllVersions()
+
+	want := []string{"1:1.0-1", "2.10-10", "2.10-2", "2.10~rc1-1"}
+	if !reflect.DeepEqual(versions["hello"], want) {
+		t.Fatalf("unexpected versions for hello: got %v, want %v", versions["hello"], want)
+	}
+	if !reflect.DeepEqual(versions["bash"], []string{"5.2-2"}) {
+		t.Fatalf("unexpected versions for bash: %v", versions["bash"])
+	}
+}
+
+func TestListAllArchitecturesDeduplicated(t *testing.T) {
+	repo := &Repository{PackageMetadata: []Package{
+		{Name: "hello", Version: "2.10-2", Architecture: "arm64"},
+		{Name: "hello", Version: "2.10-3", Architecture: "arm64"},
+		{Name: "hello", Version: "2.10-2", Architecture: "amd64"},
+		{Name: "bash", Version: "5.2-2", Architecture: "i386"},
+	}}
+
+	got := repo.ListAllArchitectures("hello")
+	if !reflect.DeepEqual(got, []string{"amd64", "arm64"}) {
+		t.Fatalf("unexpected architectures: %v", got)
+	}
+	if archs := repo.ListAllArchitectures("missing"); len(archs) != 0 {
+		t.Fatalf("expected no architectures for unknown package, got %v", archs)
+	}
+}
+
+func TestSortPackages(t *testing.T) {
+	metadata := []Package{
+		{Name: "hello", Version: "2.10-2", Size: 100, InstalledSize: "280"},
+		{Name: "bash", Version: "5.2-2", Size: 1500, InstalledSize: "7000"},
+		{Name: "zsh", Version: "5.9-4", Size: 900, InstalledSize: ""},
+		{Name: "hello", Version: "2.10-10", Size: 120, InstalledSize: "290"},
+		{Name: "coreutils", Version: "9.1-1", Size: 3000, InstalledSize: "18000"},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		sort     func(*Repository)
+		want     []string // name_version of PackageMetadata
+		packages []string
+	}{
+		{
+			name:     "name",
+			sort:     (*Repository).SortPackagesByName,
+			want:     []string{"bash_5.2-2", "coreutils_9.1-1", "hello_2.10-10", "hello_2.10-2", "zsh_5.9-4"},
+			packages: []string{"bash", "coreutils", "hello", "zsh"},
+		},
+		{
+			name:     "version",
+			sort:     (*Repository).SortPackagesByVersion,
+			want:     []string{"coreutils_9.1-1", "zsh_5.9-4", "bash_5.2-2", "hello_2.10-10", "hello_2.10-2"},
+			packages: []string{"coreutils", "zsh", "bash", "hello"},
+		},
+		{
+			name:     "size",
+			sort:     (*Repository).SortPackagesBySize,
+			want:     []string{"coreutils_9.1-1", "bash_5.2-2", "zsh_5.9-4", "hello_2.10-10", "hello_2.10-2"},
+			packages: []string{"coreutils", "bash", "zsh", "hello"},
+		},
+		{
+			name:     "installed size",
+			sort:     (*Repository).SortPackagesByInstalledSize,
+			want:     []string{"coreutils_9.1-1", "bash_5.2-2", "hello_2.10-10", "hello_2.10-2", "zsh_5.9-4"},
+			packages: []string{"coreutils", "bash", "hello", "zsh"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &Repository{PackageMetadata: slices.Clone(metadata)}
+			tc.sort(repo)
+
+			got := make([]string, len(repo.PackageMetadata))
+			for i, p := range repo.PackageMetadata {
+				got[i] = p.Name + "_" + p.Version
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("unexpected order: got %v, want %v", got, tc.want)
+			}
+			if !slices.Equal(repo.Packages, tc.packages) {
+				t.Fatalf("unexpected Packages: got %v, want %v", repo.Packages, tc.packages)
+			}
+		})
+	}
+}
+
+func TestGetPackagesByMaintainer(t *testing.T) {
+	goTeam := "Debian Go Packaging Team <team+pkg-go@tracker.debian.org>"
+	repo := &Repository{PackageMetadata: []Package{
+		{Name: "golang-1.19", Version: "1.19.8-2", Maintainer: goTeam},
+		{Name: "golang-golang-x-text-dev", Version: "0.7.0-1", Maintainer: goTeam},
+		{Name: "hello", Version: "2.10-3", Maintainer: "Santiago Vila <sanvila@debian.org>"},
+		{Name: "bash", Version: "5.2.15-2", Maintainer: "Matthias Klose <doko@debian.org>"},
+	}}
+
+	names := func(pkgs []Package) []string {
+		var result []string
+		for _, p := range pkgs {
+			result = append(result, p.Name)
+		}
+		return result
+	}
+
+	if got := names(repo.GetPackagesByMaintainer("go packaging team")); !reflect.DeepEqual(got, []string{"golang-1.19", "golang-golang-x-text-dev"}) {
+		t.Fatalf("unexpected packages for the Go team: %v", got)
+	}
+	if got := names(repo.GetPackagesByMaintainer("SANVILA@debian.org")); !reflect.DeepEqual(got, []string{"hello"}) {
+		t.Fatalf("unexpected packages for an email query: %v", got)
+	}
+	if got := repo.GetPackagesByMaintainer("nobody"); len(got) != 0 {
+		t.Fatalf("expected no packages, got %v", names(got))
+	}
+
+	want := []string{goTeam, "Matthias Klose <doko@debian.org>", "Santiago Vila <sanvila@debian.org>"}
+	if got := repo.GetMaintainerList(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected maintainer list: %v", got)
+	}
+}
+
+func TestGetReverseConflictsAndBreaks(t *testing.T) {
+	repo := &Repository{PackageMetadata: []Package{
+		{Name: "libfoo", Version: "2.0-1"},
+		{Name: "libfoo-legacy", Version: "1.0-1", Conflicts: []string{"libfoo (<< 2.0)"}},
+		{Name: "foo-tools", Version: "1.2-1", Conflicts: []string{"bar", "libfoo:amd64 | libfoo-ng"}, Breaks: []string{"libfoo (<= 1.9)"}},
+		{Name: "libfoobar", Version: "1.0-1", Conflicts: []string{"libfoobar-dev"}},
+	}}
+
+	names := func(pkgs []Package) []string {
+		var result []string
+		for _, p := range pkgs {
+			result = append(result, p.Name)
+		}
+		return result
+	}
+
+	if got := names(repo.GetReverseConflicts("libfoo")); !reflect.DeepEqual(got, []string{"libfoo-legacy", "foo-tools"}) {
+		t.Fatalf("unexpected reverse conflicts: %v", got)
+	}
+	if got := names(repo.GetReverseBreaks("libfoo")); !reflect.DeepEqual(got, []string{"foo-tools"}) {
+		t.Fatalf("unexpected reverse breaks: %v", got)
+	}
+	if got := repo.GetReverseConflicts("libfoobar"); len(got) != 0 {
+		t.Fatalf("expected no reverse conflicts, got %v", names(got))
+	}
+}
+
+func TestGetPackagesWithHardwiredDeps(t *testing.T) {
+	repo := &Repository{PackageMetadata: []Package{
+		{Name: "foo-tools", Version: "1.0-1", Depends: []string{"libfoo (= 1.0-1)", "libc6 (>= 2.34)"}},
+		{Name: "bar-tools", Version: "2.0-1", Depends: []string{"libbar (>= 2.0)"}},
+		{Name: "foo-plugins", Version: "1.0-1", Depends: []string{"libfoo-ng (>= 2) | libfoo (=1.0-1)"}},
+	}}
+
+	got := repo.GetPackagesWithHardwiredDeps()
+	want := []HardwiredPackage{
+		{Package: repo.PackageMetadata[0], Pins: []string{"libfoo (= 1.0-1)"}},
+		{Package: repo.PackageMetadata[2], Pins: []string{"libfoo (=1.0-1)"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMergeSectionPackagesStrategies(t *testing.T) {
+	sections := func() []Package {
+		return []Package{
+			{Name: "hello", Version: "2.10-2", Architecture: "amd64", Section: "main"},
+			{Name: "bash", Version: "5.2-2", Architecture: "amd64", Section: "main"},
+			{Name: "hello", Version: "2.10-3", Architecture: "amd64", Section: "contrib"},
+			{Name: "bash", Version: "5.1-1", Architecture: "amd64", Section: "contrib"},
+			{Name: "hello", Version: "2.10-3", Architecture: "arm64", Section: "contrib"},
+		}
+	}
+
+	tests := []struct {
+		strategy DeduplicationStrategy
+		want     []string
+	}{
+		{StrategyFirstWins, []string{"hello 2.10-2 amd64", "bash 5.2-2 amd64", "hello 2.10-3 arm64"}},
+		{StrategyNewestWins, []string{"bash 5.2-2 amd64", "hello 2.10-3 amd64", "hello 2.10-3 arm64"}},
+		{StrategyKeepAll, []string{"hello 2.10-2 amd64", "bash 5.2-2 amd64", "hello 2.10-3 amd64", "bash 5.1-1 amd64", "hello 2.10-3 arm64"}},
+	}
+
+	for _, tt := range tests {
+		merged := mergeSectionPackages(sections(), 2, "contrib", tt.strategy)
+
+		var got []string
+		for _, pkg := range merged {
+			got = append(got, pkg.Name+" "+pkg.Version+" "+pkg.Architecture)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("strategy %d: got %v, want %v", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestFetchPackagesNewestWins(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Components: []string{"main", "updates"},
+		Packages: []testsupport.Package{
+			{Name: "curl", Version: "7.88.1-10+deb12u5", Component: "main"},
+			{Name: "curl", Version: "7.88.1-10+deb12u6", Component: "updates"},
+			{Name: "bash", Version: "5.2.15-2+b2", Component: "main"},
+			{Name: "bash", Version: "5.2.15-2", Component: "updates"},
+			{Name: "hello", Version: "2.10-3", Component: "main"},
+			{Name: "hello", Version: "2.10-3", Component: "updates"},
+		},
+	})
+
+	for _, order := range [][]string{{"main", "updates"}, {"updates", "main"}} {
+		repo := newFilterTestRepository(fixture.URL, order)
+		repo.SetDeduplicationStrategy(StrategyNewestWins)
+		if _, err := repo.FetchPackages(); err != nil {
+			t.Fatalf("%v: FetchPackages failed: %v", order, err)
+		}
+
+		got := make(map[string]string)
+		for _, pkg := range repo.PackageMetadata {
+			if _, dup := got[pkg.Name]; dup {
+				t.Fatalf("%v: %s kept twice", order, pkg.Name)
+			}
+			got[pkg.Name] = pkg.Version + " " + strings.Join(pkg.Sections, ",")
+		}
+		want := map[string]string{
+			"curl":  "7.88.1-10+deb12u6 updates",
+			"bash":  "5.2.15-2+b2 main",
+			"hello": "2.10-3 " + order[0],
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestPackageFromURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		filename string
+		name     string
+		version  string
+		arch     string
+	}{
+		{"http://deb.debian.org/debian/pool/main/h/hello/hello_2.10-3_amd64.deb", "hello_2.10-3_amd64.deb", "hello", "2.10-3", "amd64"},
+		{"https://bucket.s3.amazonaws.com/pool/hello_2.10-3_arm64.deb?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=abc%2Fdef", "hello_2.10-3_arm64.deb", "hello", "2.10-3", "arm64"},
+		{"https://example.com/pool/lib%2Bfoo_1.0%7E1_all.udeb", "lib+foo_1.0~1_all.udeb", "lib+foo", "1.0~1", "all"},
+		{"https://example.com/download/custom-build.deb", "custom-build.deb", "custom-build", "", ""},
+	}
+
+	for _, tt := range tests {
+		pkg, err := packageFromURL(tt.url)
+		if err != nil {
+			t.Fatalf("packageFromURL(%q) failed: %v", tt.url, err)
+		}
+		if pkg.Filename != tt.filename || pkg.Name != tt.name || pkg.Version != tt.version || pkg.Architecture != tt.arch {
+			t.Errorf("packageFromURL(%q) = %s/%s/%s/%s", tt.url, pkg.Filename, pkg.Name, pkg.Version, pkg.Architecture)
+		}
+		if pkg.DownloadURL != tt.url {
+			t.Errorf("DownloadURL not preserved: %q", pkg.DownloadURL)
+		}
+	}
+}
+
+func TestPackageFromURLRejectsDirectories(t *testing.T) {
+	for _, u := range []string{"https://example.com/pool/main/", "https://example.com", "pool/hello_1.0_amd64.deb", "https://example.com/pool/..%2F..%2Fetc%2Fevil_1.0_amd64.deb", "https://example.com/pool/%2E%2E"} {
+		if _, err := packageFromURL(u); err == nil {
+			t.Errorf("expected error for %q", u)
+		}
+	}
+}
+
+func TestDownloadPackageWithSpecialCharacters(t *testing.T) {
+	files := map[string]string{
+		"/pool/main/w/weird/weird%20name+1.0~beta_amd64.deb": "weird payload",
+		"/pool/main/t/tool/tool_1.0~rc1_amd64.deb":           "tool payload",
+	}
+	var packages strings.Builder
+	for _, entry := range []struct{ name, version, filename, content string }{
+		{"weird", "1.0~beta", "pool/main/w/weird/weird name+1.0~beta_amd64.deb", "weird payload"},
+		{"tool", "1.0~rc1", "pool/main/t/tool/tool_1.0~rc1_amd64.deb", "tool payload"},
+	} {
+		fmt.Fprintf(&packages, "Package: %s\nVersion: %s\nArchitecture: amd64\nFilename: %s\nSize: %d\nSHA256: %x\n\n",
+			entry.name, entry.version, entry.filename, len(entry.content), sha256.Sum256([]byte(entry.content)))
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dists/bookworm/main/binary-amd64/Packages" {
+			fmt.Fprint(w, packages.String())
+			return
+		}
+		// The escaped path is what the client sent: spaces must arrive encoded.
+		if content, ok := files[r.URL.EscapedPath()]; ok {
+			fmt.Fprint(w, content)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	repo := NewRepository("special", server.URL, "special", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+	weird, err := repo.GetPackageMetadata("weird")
+	if err != nil {
+		t.Fatalf("weird not found: %v", err)
+	}
+	if want := server.URL + "/pool/main/w/weird/weird%20name+1.0~beta_amd64.deb"; weird.DownloadURL != want {
+		t.Fatalf("DownloadURL = %s, want %s", weird.DownloadURL, want)
+	}
+
+	destDir := t.TempDir()
+	for _, tc := range []struct{ name, version, local string }{
+		{"weird", "1.0~beta", "weird name+1.0~beta_amd64.deb"},
+		{"tool", "1.0~rc1", "tool_1.0~rc1_amd64.deb"},
+	} {
+		if err := repo.DownloadPackage(tc.name, tc.version, "amd64", destDir); err != nil {
+			t.Fatalf("DownloadPackage(%s) failed: %v", tc.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(destDir, tc.local)); err != nil {
+			t.Fatalf("expected %s in the destination: %v", tc.local, err)
+		}
+	}
+
+	byURL := t.TempDir()
+	pkg, err := repo.DownloadPackageByURL(weird.DownloadURL, byURL)
+	if err != nil {
+		t.Fatalf("DownloadPackageByURL failed: %v", err)
+	}
+	if pkg.Filename != "weird name+1.0~beta_amd64.deb" {
+		t.Fatalf("unexpected package from URL: %+v", pkg)
+	}
+	if data, err := os.ReadFile(filepath.Join(byURL, "weird name+1.0~beta_amd64.deb")); err != nil || string(data) != "weird payload" {
+		t.Fatalf("expected the decoded file name on disk, got %q (%v)", data, err)
+	}
+
+	if _, err := repo.DownloadPackageByURL(server.URL+"/pool/..%2Fescape_1.0_amd64.deb", byURL); !errors.Is(err, ErrInvalidFileName) {
+		t.Fatalf("expected ErrInvalidFileName for an encoded separator, got %v", err)
+	}
+}
+
+func TestDownloadPackageByURLWithChecksum(t *testing.T) {
+	content := []byte("fake deb payload")
+	sum := sha256.Sum256(content)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("X-Amz-Signature") == "" {
+			http.Error(w, "missing signature", http.StatusForbidden)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	repo := newCacheTestRepository()
+	destDir := t.TempDir()
+	packageURL := server.URL + "/pool/hello_2.10-3_amd64.deb?X-Amz-Expires=300&X-Amz-Signature=deadbeef"
+
+	pkg, err := repo.DownloadPackageByURLWithChecksum(packageURL, destDir, hex.EncodeToString(sum[:]), int64(len(content)))
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if pkg.Version != "2.10-3" || pkg.Architecture != "amd64" {
+		t.Fatalf("unexpected package metadata: %+v", pkg)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "hello_2.10-3_amd64.deb")); err != nil {
+		t.Fatalf("expected file without query string: %v", err)
+	}
+
+	destPath := filepath.Join(destDir, "hello_2.10-3_amd64.deb")
+	if _, err := repo.DownloadPackageByURLWithChecksum(packageURL, destDir, strings.Repeat("0", 64), 0); err == nil {
+		t.Fatal("expected checksum mismatch error")
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the file failing its checksum to be removed, got %v", err)
+	}
+	if _, err := repo.DownloadPackageByURLWithChecksum(packageURL, destDir, "", 1); err == nil {
+		t.Fatal("expected size mismatch error")
+	}
+	if _, err := os.Stat(destPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the file of the wrong size to be removed, got %v", err)
+	}
+}
+
+func newSourceSearchRepository() *Repository {
+	return &Repository{SourceMetadata: []SourcePackage{
+		{Name: "nginx", Version: "1.22.1-9", Description: "small, powerful, scalable web/proxy server"},
+		{Name: "libnginx-mod-http-echo", Version: "0.63-4", Description: "echo module"},
+		{Name: "apache2", Version: "2.4.57-2", Description: "Apache HTTP Server, often used instead of NGINX"},
+		{Name: "hello", Version: "2.10-3", Description: "example package based on GNU hello"},
+	}}
+}
+
+func TestSearchSources(t *testing.T) {
+	repo := newSourceSearchRepository()
+
+	results, err := repo.SearchSources("NGINX")
+	if err != nil {
+		t.Fatalf("SearchSources failed: %v", err)
+	}
+
+	var names []string
+	for _, src := range results {
+		names = append(names, src.Name)
+	}
+	want := []string{"nginx", "libnginx-mod-http-echo", "apache2"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected matches: got %v, want %v", names, want)
+	}
+
+	if _, err := (&Repository{}).SearchSources("nginx"); !errors.Is(err, ErrNoSourceMetadata) {
+		t.Fatalf("expected ErrNoSourceMetadata, got %v", err)
+	}
+}
+
+func TestGetSourcePackageLatest(t *testing.T) {
+	repo := &Repository{SourceMetadata: []SourcePackage{
+		{Name: "curl", Version: "7.88.1-10+deb12u5"},
+		{Name: "hello", Version: "2.10-3"},
+		{Name: "curl", Version: "8.5.0-2"},
+	}}
+
+	all := repo.GetSourcePackageMetadataAll("curl")
+	if len(all) != 2 || all[0].Version != "8.5.0-2" || all[1].Version != "7.88.1-10+deb12u5" {
+		t.Fatalf("expected both curl versions newest first, got %+v", all)
+	}
+
+	latest, err := repo.GetSourcePackageLatest("curl")
+	if err != nil {
+		t.Fatalf("GetSourcePackageLatest failed: %v", err)
+	}
+	if latest.Version != "8.5.0-2" {
+		t.Fatalf("expected the newer curl, got %s", latest.Version)
+	}
+
+	if _, err := repo.GetSourcePackageLatest("wget"); err == nil {
+		t.Fatal("expected an error for an unknown source package")
+	}
+	if _, err := (&Repository{}).GetSourcePackageLatest("curl"); !errors.Is(err, ErrNoSourceMetadata) {
+		t.Fatalf("expected ErrNoSourceMetadata, got %v", err)
+	}
+}
+
+func TestSearchSourcesRegex(t *testing.T) {
+	repo := newSourceSearchRepository()
+
+	results, err := repo.SearchSourcesRegex(`^(lib)?nginx`)
+	if err != nil {
+		t.Fatalf("SearchSourcesRegex failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Name != "nginx" || results[1].Name != "libnginx-mod-http-echo" {
+		t.Fatalf("unexpected matches: %+v", results)
+	}
+
+	if _, err := repo.SearchSourcesRegex("("); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
+
+func TestFetchPackagesMetadataDownloadLimit(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; sb.Len() < 1000; i++ {
+		fmt.Fprintf(&sb, "Package: pkg%02d\nVersion: 1.0-%d\nArchitecture: amd64\n\n", i, i)
+	}
+	packages := sb.String()[:1000]
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dists/bookworm/main/binary-amd64/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(packages))
+	}))
+	defer server.Close()
+
+	repo := NewRepository("limit", server.URL, "limit", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+	repo.SetMetadataDownloadLimit(100)
+
+	names, err := repo.FetchPackages()
+	if !errors.Is(err, ErrMetadataLimitExceeded) {
+		t.Fatalf("expected ErrMetadataLimitExceeded, got %v", err)
+	}
+	if len(names) == 0 || len(names) > 3 {
+		t.Fatalf("expected only the packages from the first 100 bytes, got %v", names)
+	}
+	if repo.metadataReceived > 100 {
+		t.Fatalf("received %d bytes, limit is 100", repo.metadataReceived)
+	}
+
+	// A stanza cut in the middle of a field is dropped rather than parsed
+	repo.SetMetadataDownloadLimit(120)
+	names, err = repo.FetchPackages()
+	if !errors.Is(err, ErrMetadataLimitExceeded) {
+		t.Fatalf("expected ErrMetadataLimitExceeded, got %v", err)
+	}
+	if slices.Sort(names); !slices.Equal(names, []string{"pkg00", "pkg01"}) {
+		t.Fatalf("expected only the complete stanzas, got %v", names)
+	}
+
+	repo.SetMetadataDownloadLimit(0)
+	if names, err := repo.FetchPackages(); err != nil || len(names) < 10 {
+		t.Fatalf("expected the full index without a limit, got %d packages, err %v", len(names), err)
+	}
+}
+
+func TestFetchPackagesReportsEveryVariantFailure(t *testing.T) {
+	packages := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\n\n"
+	var corrupted bytes.Buffer
+	zw := gzip.NewWriter(&corrupted)
+	zw.Write([]byte("Package: tampered\nVersion: 1.0\nArchitecture: amd64\n\n"))
+	zw.Close()
+
+	release := fmt.Sprintf("Suite: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			fmt.Fprint(w, release)
+		case "/dists/bookworm/main/binary-amd64/Packages.gz":
+			w.Write(corrupted.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repo := NewRepository("variants", server.URL, "variants", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.DisableSignatureVerification()
+
+	_, err := repo.FetchPackages()
+	if err == nil {
+		t.Fatal("expected every variant to fail")
+	}
+
+	indexURL := server.URL + "/dists/bookworm/main/binary-amd64/Packages"
+	for _, want := range []string{indexURL + ".gz: invalid sha256 checksum", indexURL + ".xz: Packages file not accessible", "statut HTTP 404"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) || mismatch.File != "main/binary-amd64/Packages" {
+		t.Fatalf("expected a ChecksumMismatchError, got %v", err)
+	}
+	var fetchErr *IndexFetchError
+	if !errors.As(err, &fetchErr) || len(fetchErr.Attempts) != len(CompressionExtensions) {
+		t.Fatalf("expected an IndexFetchError listing every variant, got %v", err)
+	}
+	if !errors.Is(fetchErr.Attempts[2].Err, ErrNotFound) {
+		t.Fatalf("expected the .xz attempt to be not found, got %v", fetchErr.Attempts[2].Err)
+	}
+	// A corrupted index is not a missing one.
+	if errors.Is(err, ErrNotFound) {
+		t.Fatal("a checksum failure must not match ErrNotFound")
+	}
+}
+
+func TestSetCompressionPreference(t *testing.T) {
+	stale := "Package: hello\nVersion: 2.10-2\nArchitecture: amd64\n\n"
+	fresh := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\n\n"
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte(fresh))
+	zw.Close()
+
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, path.Base(r.URL.Path))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			fmt.Fprint(w, stale)
+		case "/dists/bookworm/main/binary-amd64/Packages.gz":
+			w.Write(compressed.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repo := NewRepository("compression", server.URL, "compression", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+	for _, invalid := range [][]string{{}, {".bz2"}, {".gz", ".gz"}} {
+		if err := repo.SetCompressionPreference(invalid); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+	if err := repo.SetCompressionPreference([]string{".xz", ".gz"}); err != nil {
+		t.Fatalf("SetCompressionPreference failed: %v", err)
+	}
+
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+	if got := repo.PackageMetadata[0].Version; got != "2.10-3" {
+		t.Fatalf("expected the version of Packages.gz, got %s", got)
+	}
+	if slices.Contains(requested, "Packages") {
+		t.Fatalf("the excluded uncompressed index was requested: %v", requested)
+	}
+
+	if err := repo.SetCompressionPreference(nil); err != nil || !slices.Equal(repo.compressionExtensions(), CompressionExtensions) {
+		t.Fatalf("expected nil to restore the default order, got %v (%v)", repo.compressionExtensions(), err)
+	}
+}
+
+func TestFetchPackagesWithContextCancelledMidParse(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&sb, "Package: pkg%05d\nVersion: 1.0-%d\nArchitecture: amd64\n\n", i, i)
+	}
+	packages := sb.String()
+	half := len(packages) / 2
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dists/bookworm/main/binary-amd64/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write([]byte(packages[:half]))
+		w.(http.Flusher).Flush()
+		cancel()
+		w.Write([]byte(packages[half:]))
+	}))
+	defer server.Close()
+
+	repo := NewRepository("cancel", server.URL, "cancel", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+
+	names, err := repo.FetchPackagesWithContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if names != nil || len(repo.PackageMetadata) != 0 {
+		t.Fatalf("expected no partial result, got %d names and %d packages", len(names), len(repo.PackageMetadata))
+	}
+}
+
+func TestParseReleaseFileDates(t *testing.T) {
+	want := time.Date(2023, time.June, 3, 9, 2, 9, 0, time.UTC)
+	for _, date := range []string{
+		"Sat, 03 Jun 2023 09:02:09 UTC",
+		"Sat, 3 Jun 2023 09:02:09 UTC",
+		"Sat, 03 Jun 2023 11:02:09 +0200",
+		"Sat,  3 Jun 2023 09:02:09 +0000 (UTC)",
+		"2023-06-03T09:02:09Z",
+	} {
+		release, err := (&Repository{}).parseReleaseFile("Suite: bookworm\nDate: " + date + "\nValid-Until: " + date + "\n")
+		if err != nil {
+			t.Fatalf("parseReleaseFile(%q): %v", date, err)
+		}
+		if !release.ParsedDate.Equal(want) || !release.ParsedValidUntil.Equal(want) || len(release.ParseWarnings) != 0 {
+			t.Errorf("date %q parsed as %v / %v, warnings %v", date, release.ParsedDate, release.ParsedValidUntil, release.ParseWarnings)
+		}
+		if release.Age() < time.Since(want)-time.Minute {
+			t.Errorf("unexpected age %v for %q", release.Age(), date)
+		}
+	}
+
+	release, err := (&Repository{}).parseReleaseFile("Suite: bookworm\nDate: yesterday\n")
+	if err != nil {
+		t.Fatalf("an invalid date must not fail parsing: %v", err)
+	}
+	if release.Date != "yesterday" || !release.ParsedDate.IsZero() || release.Age() != 0 {
+		t.Fatalf("unexpected parse of an invalid date: %+v", release)
+	}
+	if len(release.ParseWarnings) != 1 || !strings.Contains(release.ParseWarnings[0], "Date") {
+		t.Fatalf("expected a warning for the invalid date, got %v", release.ParseWarnings)
+	}
+}
+
+func TestFetchReleaseFileAsync(t *testing.T) {
+	packages := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\n\n"
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release":
+			<-release
+			fmt.Fprintf(w, "Suite: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			fmt.Fprint(w, packages)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, r.Method+" "+path.Base(r.URL.Path))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.DisableSignatureVerification()
+
+	done := repo.FetchReleaseFileAsync(context.Background())
+	select {
+	case err := <-done:
+		t.Fatalf("FetchReleaseFileAsync must return before the fetch completes, got %v", err)
+	default:
+	}
+
+	// FetchPackages waits for the background fetch instead of racing or repeating it
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("background fetch failed: %v", err)
+	}
+	if err := repo.WaitForRelease(); err != nil || repo.GetReleaseInfo() == nil {
+		t.Fatalf("expected the Release file to be loaded, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) == 0 || requests[0] != "GET Release" || slices.Index(requests[1:], "GET Release") != -1 {
+		t.Fatalf("expected a single Release fetch before the Packages index, got %v", requests)
+	}
+}
+
+func TestFetchReleaseFileAsyncCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	repo := NewRepository("test", "http://127.0.0.1:1", "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.DisableSignatureVerification()
+
+	if err := <-repo.FetchReleaseFileAsync(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if err := repo.WaitForRelease(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected WaitForRelease to report the same error, got %v", err)
+	}
+}
+
+func TestGetPoolPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"hello", "h"},
+		{"libc6", "libc"},
+		{"libssl3", "libs"},
+		{"lib", "lib"},
+		{"li", "l"},
+		{"x", "x"},
+		{"0ad", "0"},
+		{"", ""},
+		{"LibreOffice", "libr"},
+		{"Hello", "h"},
+	}
+
+	for _, tt := range tests {
+		if got := getPoolPrefix(tt.name); got != tt.want {
+			t.Errorf("getPoolPrefix(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSourceFileURLsFromMalformedDirectories(t *testing.T) {
+	served := map[string]string{
+		"/debian/pool/main/h/hello/hello_2.10.orig.tar.gz":      "relative",
+		"/debian/var/www/pool/main/v/vendor/vendor_1.0.dsc":     "host-relative",
+		"/elsewhere/pool/e/ext/ext_3.0.tar.xz":                  "absolute",
+		"/debian/pool/non-free/s/spaced/spaced 1.0+dfsg#1.orig": "escaped",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := served[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	stanza := func(name, directory, file string) string {
+		return fmt.Sprintf("Package: %s\nVersion: 1.0-1\nDirectory: %s\nFiles:\n d41d8cd98f00b204e9800998ecf8427e 0 %s\n\n", name, directory, file)
+	}
+	sources := stanza("hello", "pool/main/h/hello", "hello_2.10.orig.tar.gz") +
+		stanza("vendor", "//var/www/pool/main/v/vendor/", "vendor_1.0.dsc") +
+		stanza("ext", server.URL+"/elsewhere/pool/e/ext/", "ext_3.0.tar.xz") +
+		stanza("spaced", "/pool/non-free/s/spaced", "spaced 1.0+dfsg#1.orig")
+
+	repo := &Repository{URL: server.URL + "/debian/"}
+	parsed, err := repo.parseSourcesFromReader(strings.NewReader(sources), "main")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	want := map[string]string{
+		"hello":  server.URL + "/debian/pool/main/h/hello/hello_2.10.orig.tar.gz",
+		"vendor": server.URL + "/debian/var/www/pool/main/v/vendor/vendor_1.0.dsc",
+		"ext":    server.URL + "/elsewhere/pool/e/ext/ext_3.0.tar.xz",
+		"spaced": server.URL + "/debian/pool/non-free/s/spaced/spaced%201.0+dfsg%231.orig",
+	}
+	wantBody := map[string]string{"hello": "relative", "vendor": "host-relative", "ext": "absolute", "spaced": "escaped"}
+	for _, sp := range parsed {
+		file := sp.Files[0]
+		if file.URL != want[sp.Name] {
+			t.Fatalf("%s: expected URL %s, got %s", sp.Name, want[sp.Name], file.URL)
+		}
+
+		// Clear the placeholder checksums so only the transfer is exercised
+		sp.Files[0].MD5Sum = ""
+		destDir := t.TempDir()
+		if err := NewDownloader().DownloadSourcePackageSilent(&sp, destDir); err != nil {
+			t.Fatalf("%s: download failed: %v", sp.Name, err)
+		}
+		data, err := os.ReadFile(filepath.Join(destDir, file.Name))
+		if err != nil || string(data) != wantBody[sp.Name] {
+			t.Fatalf("%s: unexpected downloaded file %q: %v", sp.Name, data, err)
+		}
+	}
+
+	var warnings []string
+	broken := &Repository{URL: "not a url", WarningHandler: func(msg string) { warnings = append(warnings, msg) }}
+	parsed, _ = broken.parseSourcesFromReader(strings.NewReader(stanza("hello", "pool/main/h/hello", "hello.dsc")), "main")
+	if len(parsed) != 1 || parsed[0].Files[0].URL != "" || len(warnings) != 1 {
+		t.Fatalf("expected an unusable URL to be dropped with a warning, got %+v, %v", parsed, warnings)
+	}
+	if err := NewDownloader().DownloadSourcePackageSilent(&parsed[0], t.TempDir()); err == nil {
+		t.Fatal("expected downloading a file without URL to fail")
+	}
+}
+
+func TestParseIndicesWithVeryLongLines(t *testing.T) {
+	// Beyond the 1MB line cap of bufio.Scanner used before
+	provides := strings.TrimSuffix(strings.Repeat("virtual-package, ", 5*1024*1024/17), ", ")
+
+	packages := "Package: huge\nVersion: 1.0\nProvides: " + provides + "\n\nPackage: small\nVersion: 2.0\n"
+	_, parsed, err := (&Repository{}).parsePackagesFromReader(context.Background(), strings.NewReader(packages))
+	if err != nil {
+		t.Fatalf("parse Packages failed: %v", err)
+	}
+	if len(parsed) != 2 || parsed[0].Name != "huge" || len(parsed[0].Provides) != 5*1024*1024/17 || parsed[1].Name != "small" {
+		t.Fatalf("unexpected packages: %d parsed", len(parsed))
+	}
+
+	description := strings.Repeat("x", 5*1024*1024)
+	sources := "Package: huge\nVersion: 1.0\nDescription: " + description + "\n\nPackage: small\nVersion: 2.0"
+	sourcePackages, err := (&Repository{}).parseSourcesFromReader(strings.NewReader(sources), "main")
+	if err != nil {
+		t.Fatalf("parse Sources failed: %v", err)
+	}
+	if len(sourcePackages) != 2 || sourcePackages[0].Description != description || sourcePackages[1].Version != "2.0" {
+		t.Fatalf("unexpected sources: %d parsed", len(sourcePackages))
+	}
+}
+
+func newFilterTestRepository(url string, components []string) *Repository {
+	repo := NewRepository("test", url, "test", "bookworm", components, []string{"amd64"})
+	repo.VerifySignature = false
+	return repo
+}
+
+func TestFetchPackagesWithFilter(t *testing.T) {
+	server, components, _ := newComponentsServer(t, 2, 3)
+	defer server.Close()
+
+	var fields []string
+	repo := newFilterTestRepository(server.URL, components)
+	kept, err := repo.FetchPackagesWithFilter(func(name, value string) bool {
+		fields = append(fields, name)
+		return name != "Package" || strings.HasSuffix(value, "-1")
+	})
+	if err != nil {
+		t.Fatalf("FetchPackagesWithFilter failed: %v", err)
+	}
+	if len(kept) != 2 || kept[0].Name != "pkg0-1" || kept[1].Name != "pkg1-1" || kept[0].Version != "1.0" {
+		t.Fatalf("unexpected packages kept: %+v", kept)
+	}
+	if len(repo.PackageMetadata) != 2 {
+		t.Fatalf("PackageMetadata holds %d packages, want 2", len(repo.PackageMetadata))
+	}
+	// Only the Package field of the 4 rejected stanzas is seen, and the 6 fields of the others
+	if len(fields) != 4+2*6 || fields[1] != "Package" || fields[2] != "Version" {
+		t.Fatalf("unexpected fields passed to the filter: %v", fields)
+	}
+
+	kept, err = repo.FetchPackagesFiltered(func(pkg Package) bool { return pkg.Name == "pkg1-2" && pkg.Filename != "" })
+	if err != nil {
+		t.Fatalf("FetchPackagesFiltered failed: %v", err)
+	}
+	if len(kept) != 1 || kept[0].Name != "pkg1-2" {
+		t.Fatalf("unexpected packages kept: %+v", kept)
+	}
+
+	// The filters only apply to the call they were given to
+	if _, err := repo.FetchPackages(); err != nil || len(repo.PackageMetadata) != 6 {
+		t.Fatalf("FetchPackages kept %d packages: %v", len(repo.PackageMetadata), err)
+	}
+}
+
+func TestRepositoryConcurrentUse(t *testing.T) {
+	server, components, _ := newComponentsServer(t, 2, 50)
+	defer server.Close()
+
+	repo := newFilterTestRepository(server.URL, components)
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+
+	// Readers must see the complete metadata of one fetch or another, never a partial one
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if _, err := repo.FetchPackages(); err != nil {
+					errs <- fmt.Errorf("FetchPackages: %w", err)
+				}
+				repo.SortPackagesByName()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if names, err := repo.SearchPackage("pkg1-4"); err != nil || len(names) != 11 {
+					errs <- fmt.Errorf("SearchPackage: %v, %v", names, err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if pkg, err := repo.GetPackageMetadata("pkg1-49"); err != nil || pkg.Version != "1.0" {
+					errs <- fmt.Errorf("GetPackageMetadata: %v", err)
+				}
+				if all := repo.GetAllPackageMetadata(); len(all) != 100 {
+					errs <- fmt.Errorf("GetAllPackageMetadata: %d packages", len(all))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func BenchmarkFetchPackagesWithFilter(b *testing.B) {
+	server, components, _ := newComponentsServer(b, 1, 20000)
+	defer server.Close()
+
+	b.Run("all", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := newFilterTestRepository(server.URL, components).FetchPackages(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("filtered", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := newFilterTestRepository(server.URL, components).FetchPackagesWithFilter(func(name, value string) bool {
+				return name != "Package" || strings.HasPrefix(value, "pkg0-1")
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSourcePackageDirectoryNormalization(t *testing.T) {
+	stanza := func(name, directory string) string {
+		return fmt.Sprintf("Package: %s\nVersion: 1.0\nDirectory: %s\nFiles:\n d41d8cd98f00b204e9800998ecf8427e 0 %s.dsc\n\n", name, directory, name)
+	}
+	sources := stanza("leading", "/pool/main/l/leading") +
+		stanza("trailing", "pool/main/t/trailing//") +
+		stanza("both", "//pool/main/b/both/") +
+		stanza("escape", "pool/../../etc") +
+		stanza("vendor", "srv/pool/v/vendor")
+
+	var warnings []string
+	repo := &Repository{URL: "http://deb.example.com/debian", WarningHandler: func(msg string) { warnings = append(warnings, msg) }}
+	parsed, err := repo.parseSourcesFromReader(strings.NewReader(sources), "main")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	want := map[string]string{
+		"leading":  "pool/main/l/leading",
+		"trailing": "pool/main/t/trailing",
+		"both":     "pool/main/b/both",
+		"vendor":   "srv/pool/v/vendor",
+	}
+	if len(parsed) != len(want) {
+		t.Fatalf("expected the package escaping the repository to be dropped, got %d packages", len(parsed))
+	}
+	for _, sp := range parsed {
+		if sp.Directory != want[sp.Name] {
+			t.Errorf("%s: Directory = %q, want %q", sp.Name, sp.Directory, want[sp.Name])
+		}
+		if wantURL := "http://deb.example.com/debian/" + want[sp.Name] + "/" + sp.Name + ".dsc"; sp.Files[0].URL != wantURL {
+			t.Errorf("%s: URL = %q, want %q", sp.Name, sp.Files[0].URL, wantURL)
+		}
+	}
+	// One warning for the dropped package, one for the directory outside pool/
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "escape") || !strings.Contains(warnings[1], "vendor") {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	for directory, valid := range map[string]bool{
+		"pool/main/h/hello":                   true,
+		"/pool/main/h/hello/":                 true,
+		"https://cdn.example.com/pool/h/ext/": true,
+		"":                                    false,
+		"/":                                   false,
+		"dists/bookworm":                      false,
+		"pool/main/../../etc":                 false,
+		"https://cdn.example.com/pool/../x":   false,
+	} {
+		if got := (&SourcePackage{Directory: directory}).IsValidDirectory(); got != valid {
+			t.Errorf("IsValidDirectory(%q) = %v, want %v", directory, got, valid)
+		}
+	}
+
+	repo.SourceMetadata = []SourcePackage{{Name: "cached", Version: "1.0", Directory: "pool/../cached"}}
+	if _, err := repo.GetSourcePackageMetadata("cached", ""); !errors.Is(err, ErrInvalidSourceDirectory) {
+		t.Fatalf("expected ErrInvalidSourceDirectory, got %v", err)
+	}
+}
+
+func TestBuildPackageURLUsesSourceName(t *testing.T) {
+	repo := NewRepository("test", "http://deb.example.com/debian/", "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.PackageMetadata = []Package{{Name: "libcurl4", Package: "libcurl4", Version: "7.88.1-10", Architecture: "amd64", Source: "curl (7.88.1-10)"}}
+
+	got, err := repo.buildPackageURLWithComponent("libcurl4", "7.88.1-10", "amd64", "main")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "http://deb.example.com/debian/pool/main/c/curl/libcurl4_7.88.1-10_amd64.deb"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	got, err = repo.buildPackageURLWithComponent("hello", "2.10-3", "amd64", "main")
+	if err != nil || got != "http://deb.example.com/debian/pool/main/h/hello/hello_2.10-3_amd64.deb" {
+		t.Fatalf("unexpected fallback URL %s (err %v)", got, err)
+	}
+
+	if _, err := repo.buildPackageURLWithComponent("", "1.0", "amd64", "main"); !errors.Is(err, ErrEmptyPackageName) {
+		t.Fatalf("expected ErrEmptyPackageName, got %v", err)
+	}
+	if err := repo.DownloadPackage("", "1.0", "amd64", t.TempDir()); !errors.Is(err, ErrEmptyPackageName) {
+		t.Fatalf("expected DownloadPackage to reject an empty name, got %v", err)
+	}
+}
+
+func TestCheckPackageAvailabilityFromMetadata(t *testing.T) {
+	repo := NewRepository("test", "http://deb.example.com/debian", "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.PackageMetadata = []Package{
+		{Name: "hello", Version: "2.10-3", Architecture: "amd64"},
+		{Name: "tzdata", Version: "2024a-0+deb12u1", Architecture: "all"},
+	}
+
+	tests := []struct {
+		name, version, arch string
+		want                bool
+	}{
+		{"hello", "2.10-3", "amd64", true},
+		{"hello", "2.10-2", "amd64", false},
+		{"hello", "2.10-3", "arm64", false},
+		{"hello", "", "", true},
+		{"tzdata", "2024a-0+deb12u1", "arm64", true},
+		{"missing", "1.0", "amd64", false},
+	}
+	for _, tt := range tests {
+		if got := repo.CheckPackageAvailabilityFromMetadata(tt.name, tt.version, tt.arch); got != tt.want {
+			t.Errorf("CheckPackageAvailabilityFromMetadata(%q, %q, %q) = %v, want %v", tt.name, tt.version, tt.arch, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPackageAvailabilityDetailed(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/debian/pool/contrib/h/hello/hello_1.0_amd64.deb":
+			w.Header().Set("Content-Length", "1234")
+		case "/debian/pool/main/b/broken/broken_1.0_amd64.deb":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	newRepo := func(components ...string) *Repository {
+		repo := NewRepository("test", server.URL+"/debian", "test", "bookworm", components, []string{"amd64"})
+		repo.SetRetryPolicy(DefaultRetryPolicy{Attempts: 1})
+		return repo
+	}
+
+	// Without metadata, every default section is probed
+	got, err := newRepo("main").CheckPackageAvailabilityDetailed("hello", "1.0", "amd64")
+	want := &PackageAvailability{Found: true, Section: "contrib", URL: server.URL + "/debian/pool/contrib/h/hello/hello_1.0_amd64.deb", Size: 1234, Source: AvailabilityFromProbe}
+	if err != nil || *got != *want {
+		t.Fatalf("probe: got %+v, %v, want %+v", got, err, want)
+	}
+
+	got, err = newRepo("main").CheckPackageAvailabilityDetailed("missing", "1.0", "amd64")
+	if err != nil || got.Found || got.Source != AvailabilityFromProbe {
+		t.Fatalf("missing package: got %+v, %v", got, err)
+	}
+
+	if _, err := newRepo("main").CheckPackageAvailabilityDetailed("broken", "1.0", "amd64"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a 500 answer to leave availability undetermined, got %v", err)
+	}
+
+	// Metadata answers for the sections it covers; the others are still probed
+	repo := newRepo("contrib")
+	repo.PackageMetadata = []Package{{Name: "hello", Version: "1.0", Architecture: "amd64", Filename: "pool/contrib/h/hello/hello_1.0_amd64.deb", Size: 99, Sections: []string{"contrib"}}}
+	requests.Store(0)
+	got, err = repo.CheckPackageAvailabilityDetailed("hello", "", "")
+	want = &PackageAvailability{Found: true, Section: "contrib", URL: want.URL, Size: 99, Source: AvailabilityFromMetadata}
+	if err != nil || *got != *want || requests.Load() != 0 {
+		t.Fatalf("metadata: got %+v, %v after %d requests, want %+v", got, err, requests.Load(), want)
+	}
+
+	repo = newRepo("main")
+	repo.PackageMetadata = []Package{{Name: "other", Version: "1.0", Architecture: "amd64", Sections: []string{"main"}}}
+	got, err = repo.CheckPackageAvailabilityDetailed("hello", "1.0", "amd64")
+	if err != nil || !got.Found || got.Section != "contrib" || got.Source != AvailabilityFromProbe {
+		t.Fatalf("probe beyond metadata: got %+v, %v", got, err)
+	}
+
+	repo = newRepo(defaultComponents...)
+	repo.PackageMetadata = []Package{{Name: "other", Version: "1.0", Architecture: "amd64"}}
+	requests.Store(0)
+	got, err = repo.CheckPackageAvailabilityDetailed("hello", "1.0", "amd64")
+	if err != nil || got.Found || got.Source != AvailabilityFromMetadata || requests.Load() != 0 {
+		t.Fatalf("absent per metadata: got %+v, %v after %d requests", got, err, requests.Load())
+	}
+}
+
+func TestDownloadPackagePrefersMetadata(t *testing.T) {
+	content := []byte("deb from the index")
+	sum := sha256.Sum256(content)
+	indexedPath := "/pool/main/h/hello-src/hello_2.10-3_amd64.deb"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != indexedPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.PackageMetadata = []Package{{
+		Name:         "hello",
+		Version:      "2.10-3",
+		Architecture: "amd64",
+		Filename:     strings.TrimPrefix(indexedPath, "/"),
+		SHA256:       hex.EncodeToString(sum[:]),
+	}}
+
+	destDir := t.TempDir()
+	if err := repo.DownloadPackage("hello", "2.10-3", "amd64", destDir); err != nil {
+		t.Fatalf("DownloadPackage: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(destDir, "hello_2.10-3_amd64.deb")); err != nil || string(data) != string(content) {
+		t.Fatalf("expected the indexed file in %s, got %q (err %v)", destDir, data, err)
+	}
+
+	if err := repo.DownloadPackage("hello", "9.9-9", "amd64", destDir); err == nil || !strings.Contains(err.Error(), "not found in metadata") {
+		t.Fatalf("expected a metadata lookup failure, got %v", err)
+	}
+}
+
+func TestFetchChangelog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/changelogs/pool/main/h/hello/hello_2.10-2/changelog" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("hello (2.10-2) unstable; urgency=low\n"))
+	}))
+	defer server.Close()
+
+	defer func(base string) { changelogBaseURL = base }(changelogBaseURL)
+	changelogBaseURL = server.URL + "/changelogs"
+
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	changelog, err := repo.FetchChangelog("hello", "2.10-2")
+	if err != nil {
+		t.Fatalf("FetchChangelog failed: %v", err)
+	}
+	if !strings.HasPrefix(changelog, "hello (2.10-2)") {
+		t.Fatalf("unexpected changelog %q", changelog)
+	}
+}
+
+// writeTestKeyring writes the public part of the test signing key to a gpgv keyring
+// and returns its path with the key's fingerprint.
+func writeTestKeyring(t *testing.T) (string, string) {
+	t.Helper()
+
+	keyData, err := os.ReadFile(testSigningKeyPath)
+	if err != nil {
+		t.Fatalf("failed to read test key: %v", err)
+	}
+	key, err := crypto.NewKeyFromArmored(string(keyData))
+	if err != nil {
+		t.Fatalf("failed to parse test key: %v", err)
+	}
+	publicKey, err := key.GetPublicKey()
+	if err != nil {
+		t.Fatalf("failed to extract public key: %v", err)
+	}
+	keyring := filepath.Join(t.TempDir(), "test.gpg")
+	if err := os.WriteFile(keyring, publicKey, FilePermission); err != nil {
+		t.Fatalf("failed to write keyring: %v", err)
+	}
+	return keyring, key.GetFingerprint()
+}
+
+func TestRequireSignerFingerprint(t *testing.T) {
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not available")
+	}
+
+	keyring, fingerprint := writeTestKeyring(t)
+	signer, err := NewKeyFileSigner(testSigningKeyPath, "")
+	if err != nil {
+		t.Fatalf("failed to load signer: %v", err)
+	}
+	inRelease, err := signer.ClearSign([]byte("Suite: bookworm\nArchitectures: amd64\nComponents: main\n"))
+	if err != nil {
+		t.Fatalf("failed to sign Release: %v", err)
+	}
+
+	var releaseRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/InRelease":
+			w.Write(inRelease)
+		default:
+			releaseRequests++
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repo := NewRepository("pinned", server.URL, "pinned", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.SetKeyringPaths([]string{keyring})
+	if err := repo.RequireSignerFingerprint("6ED0E7B82643E131"); err == nil {
+		t.Fatal("expected a key ID to be refused as fingerprint")
+	}
+
+	if err := repo.FetchReleaseFile(); err != nil {
+		t.Fatalf("FetchReleaseFile failed: %v", err)
+	}
+	signatures := repo.GetSignatureInfo()
+	if len(signatures) != 1 || signatures[0].Fingerprint != strings.ToUpper(fingerprint) || !strings.HasSuffix(signatures[0].Fingerprint, signatures[0].KeyID) || signatures[0].Created.IsZero() {
+		t.Fatalf("unexpected signatures %+v for key %s", signatures, fingerprint)
+	}
+
+	// gpg prints fingerprints in groups of four digits
+	var grouped []string
+	for i := 0; i < len(fingerprint); i += 4 {
+		grouped = append(grouped, fingerprint[i:i+4])
+	}
+	if err := repo.RequireSignerFingerprint(strings.Join(grouped, " ")); err != nil {
+		t.Fatalf("RequireSignerFingerprint failed: %v", err)
+	}
+	if err := repo.FetchReleaseFile(); err != nil {
+		t.Fatalf("expected the pinned key to be accepted: %v", err)
+	}
+
+	if err := repo.RequireSignerFingerprint(strings.Repeat("AB", 20)); err != nil {
+		t.Fatalf("RequireSignerFingerprint failed: %v", err)
+	}
+	if err := repo.FetchReleaseFile(); !errors.Is(err, ErrSignerMismatch) {
+		t.Fatalf("expected ErrSignerMismatch, got %v", err)
+	}
+	if len(repo.GetSignatureInfo()) != 0 || releaseRequests != 0 {
+		t.Fatalf("a mismatch must neither record signatures nor fall back to Release.gpg (%d requests)", releaseRequests)
+	}
+}
+
+func TestParseGPGStatus(t *testing.T) {
+	output := `[GNUPG:] NEWSIG
+gpgv: Good signature from "Debian Archive Automatic Signing Key (12/bookworm) <ftpmaster@debian.org>"
+[GNUPG:] GOODSIG 6ED0E7B82643E131 Debian Archive Automatic Signing Key (12/bookworm) <ftpmaster@debian.org>
+[GNUPG:] VALIDSIG 4CB50190207B4758A3F73A796ED0E7B82643E131 2024-06-29 1719655315 0 4 0 1 10 01 4CB50190207B4758A3F73A796ED0E7B82643E131
+[GNUPG:] NEWSIG
+[GNUPG:] GOODSIG 254CF3B5AEC0A8F0 Debian Stable Release Key (12/bookworm)
+[GNUPG:] VALIDSIG 1F89983E0081FDE018F3CC9673A4F27B8DD47936 2024-06-29 1719655316 0 4 0 1 10 01 B8B80B5B623EAB6AD8775C45B7C5D7D6350947F8
+`
+	got := parseGPGStatus([]byte(output))
+	want := []SignatureInfo{
+		{
+			KeyID:              "6ED0E7B82643E131",
+			Fingerprint:        "4CB50190207B4758A3F73A796ED0E7B82643E131",
+			PrimaryFingerprint: "4CB50190207B4758A3F73A796ED0E7B82643E131",
+			UserID:             "Debian Archive Automatic Signing Key (12/bookworm) <ftpmaster@debian.org>",
+			Created:            time.Unix(1719655315, 0).UTC(),
+		},
+		{
+			KeyID:              "254CF3B5AEC0A8F0",
+			Fingerprint:        "1F89983E0081FDE018F3CC9673A4F27B8DD47936",
+			PrimaryFingerprint: "B8B80B5B623EAB6AD8775C45B7C5D7D6350947F8",
+			UserID:             "Debian Stable Release Key (12/bookworm)",
+			Created:            time.Unix(1719655316, 0).UTC(),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if !got[1].matches("B8B80B5B623EAB6AD8775C45B7C5D7D6350947F8") {
+		t.Fatal("a subkey signature must match the fingerprint of its primary key")
+	}
+}
+
+func TestStrictModeRejectsMD5OnlyRelease(t *testing.T) {
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not available")
+	}
+
+	keyring, _ := writeTestKeyring(t)
+	signer, err := NewKeyFileSigner(testSigningKeyPath, "")
+	if err != nil {
+		t.Fatalf("failed to load signer: %v", err)
+	}
+	packages := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\n\n"
+	inRelease, err := signer.ClearSign([]byte(fmt.Sprintf("Suite: bookworm\nArchitectures: amd64\nComponents: main\nMD5Sum:\n %x %d main/binary-amd64/Packages\n", md5.Sum([]byte(packages)), len(packages))))
+	if err != nil {
+		t.Fatalf("failed to sign Release: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/InRelease":
+			w.Write(inRelease)
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			fmt.Fprint(w, packages)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repo := NewRepository("strict", server.URL, "strict", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.SetKeyringPaths([]string{keyring})
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("MD5-only Release must be accepted outside strict mode: %v", err)
+	}
+
+	repo.EnableStrictMode()
+	if err := repo.DisableSignatureVerification(); !errors.Is(err, ErrStrictMode) || !repo.VerifySignature {
+		t.Fatalf("expected signature verification to stay enabled, got %v", err)
+	}
+	if _, err := repo.FetchPackages(); !errors.Is(err, ErrStrictMode) {
+		t.Fatalf("expected ErrStrictMode for an MD5-only Release, got %v", err)
+	}
+
+	repo.DisableStrictMode()
+	if err := repo.DisableSignatureVerification(); err != nil || repo.VerifySignature {
+		t.Fatalf("expected signature verification to be disabled, got %v", err)
+	}
+}
+
+func TestFixtureRepositorySignedFetchAndDownload(t *testing.T) {
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not available")
+	}
+
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{
+			{Name: "hello", Version: "2.10-3", Depends: "libc6 (>= 2.34)", Files: map[string]string{"usr/bin/hello": "#!/bin/sh\n"}},
+			{Name: "libc6", Version: "2.36-9", Source: "glibc"},
+			{Name: "tzdata", Version: "2024a-0", Architecture: "all"},
+		},
+		Sign: true,
+	})
+
+	repo := NewRepository("fixture", fixture.URL, "fixture", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.SetKeyringPaths([]string{fixture.KeyringPath})
+	names, err := repo.FetchPackages()
+	if err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"hello", "libc6", "tzdata"}) {
+		t.Fatalf("unexpected packages %v", names)
+	}
+	if signatures := repo.GetSignatureInfo(); len(signatures) != 1 || signatures[0].Fingerprint != fixture.Fingerprint {
+		t.Fatalf("expected a signature by %s, got %+v", fixture.Fingerprint, signatures)
+	}
+
+	destDir := t.TempDir()
+	if err := repo.DownloadPackage("hello", "2.10-3", "amd64", destDir); err != nil {
+		t.Fatalf("DownloadPackage failed: %v", err)
+	}
+	entries, err := ReadDebFileList(filepath.Join(destDir, "hello_2.10-3_amd64.deb"))
+	if err != nil {
+		t.Fatalf("ReadDebFileList failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Path != "/usr/bin/hello" {
+		t.Fatalf("unexpected deb entries %+v", entries)
+	}
+}
+
+func TestFixtureRepositoryCorruption(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+	})
+
+	t.Run("index", func(t *testing.T) {
+		for _, ext := range []string{"", ".gz", ".xz"} {
+			fixture.Corrupt("dists/bookworm/main/binary-amd64/Packages" + ext)
+		}
+		t.Cleanup(func() { fixture.Reset() })
+
+		repo := newFilterTestRepository(fixture.URL, []string{"main"})
+		if _, err := repo.FetchPackages(); err == nil {
+			t.Fatal("expected corrupted Packages indices to be refused")
+		}
+	})
+
+	t.Run("package", func(t *testing.T) {
+		fixture.Corrupt(fixture.Filename("hello", "amd64"))
+		t.Cleanup(func() { fixture.Reset() })
+
+		repo := newFilterTestRepository(fixture.URL, []string{"main"})
+		if _, err := repo.FetchPackages(); err != nil {
+			t.Fatalf("FetchPackages failed: %v", err)
+		}
+		destDir := t.TempDir()
+		err := repo.DownloadPackage("hello", "2.10-3", "amd64", destDir)
+		if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("expected a checksum mismatch, got %v", err)
+		}
+		assertEmptyDir(t, destDir)
+	})
+}
+
+func TestVerifyLocalRelease(t *testing.T) {
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv not available")
+	}
+
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+		Sign:     true,
+	})
+
+	for _, tc := range []struct {
+		name     string
+		tampered string
+		notFound string
+	}{
+		{"InRelease", "InRelease", ""},
+		{"Release and Release.gpg", "Release", "dists/bookworm/InRelease"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.notFound != "" {
+				fixture.NotFound(tc.notFound)
+				t.Cleanup(fixture.Reset)
+			}
+
+			repo := NewRepository("fixture", fixture.URL, "fixture", "bookworm", []string{"main"}, []string{"amd64"})
+			repo.SetKeyringPaths([]string{fixture.KeyringPath})
+			repo.SetRetryPolicy(DefaultRetryPolicy{Attempts: 1})
+			repo.CacheSignedRelease = true
+			repo.SignedReleaseDir = t.TempDir()
+			if err := repo.FetchReleaseFile(); err != nil {
+				t.Fatalf("FetchReleaseFile failed: %v", err)
+			}
+
+			entries, err := os.ReadDir(filepath.Join(repo.SignedReleaseDir, "bookworm"))
+			if err != nil {
+				t.Fatalf("expected a cached signed Release: %v", err)
+			}
+			if tc.notFound == "" && len(entries) != 1 || tc.notFound != "" && len(entries) != 2 {
+				t.Fatalf("unexpected cached files %v", entries)
+			}
+
+			offline := NewRepository("offline", "http://127.0.0.1:1", "offline", "bookworm", []string{"main"}, []string{"amd64"})
+			offline.SetKeyringPaths([]string{fixture.KeyringPath})
+			offline.SignedReleaseDir = repo.SignedReleaseDir
+			if err := offline.VerifyLocalRelease("bookworm"); err != nil {
+				t.Fatalf("VerifyLocalRelease failed: %v", err)
+			}
+			if signatures := offline.GetSignatureInfo(); len(signatures) != 1 || signatures[0].Fingerprint != fixture.Fingerprint {
+				t.Fatalf("unexpected signatures %+v", signatures)
+			}
+
+			path := filepath.Join(repo.SignedReleaseDir, "bookworm", tc.tampered)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unable to read cached %s: %v", tc.tampered, err)
+			}
+			if err := os.WriteFile(path, bytes.Replace(data, []byte("Suite: bookworm"), []byte("Suite: trixie"), 1), 0o644); err != nil {
+				t.Fatalf("unable to tamper with %s: %v", tc.tampered, err)
+			}
+			if err := offline.VerifyLocalRelease("bookworm"); err == nil {
+				t.Fatalf("expected the modified %s to fail verification", tc.tampered)
+			}
+		})
+	}
+
+	repo := NewRepository("fixture", fixture.URL, "fixture", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.SignedReleaseDir = t.TempDir()
+	if err := repo.VerifyLocalRelease("../bookworm"); err == nil {
+		t.Fatal("expected a suite escaping SignedReleaseDir to be refused")
+	}
+	if err := repo.VerifyLocalRelease("trixie"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected os.ErrNotExist for an uncached suite, got %v", err)
+	}
+}
+
+func TestRepositoryMetadataCounts(t *testing.T) {
+	repo := NewRepository("counts", "http://example.invalid", "counts", "bookworm", []string{"main"}, []string{"amd64"})
+	if repo.GetPackageCount() != 0 || repo.IsMetadataLoaded() || repo.GetSourcePackageCount() != 0 || repo.IsSourceMetadataLoaded() {
+		t.Fatal("expected no metadata before any fetch")
+	}
+	if _, err := repo.ResolveDependencies([]PackageSpec{{Name: "hello"}}, nil); err == nil {
+		t.Fatal("expected ResolveDependencies to require metadata")
+	}
+
+	repo.setPackages([]string{"hello", "libc6"}, []Package{{Name: "hello"}, {Name: "libc6"}})
+	repo.SourceMetadata = []SourcePackage{{Name: "hello"}}
+	if repo.GetPackageCount() != 2 || !repo.IsMetadataLoaded() {
+		t.Fatalf("expected 2 packages loaded, got %d", repo.GetPackageCount())
+	}
+	if repo.GetSourcePackageCount() != 1 || !repo.IsSourceMetadataLoaded() {
+		t.Fatalf("expected 1 source package loaded, got %d", repo.GetSourcePackageCount())
+	}
+}
+
+func TestFetchInstallerPackages(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Components: []string{"main", "contrib"},
+		Packages: []testsupport.Package{
+			{Name: "hello", Version: "2.10-3"},
+			{Name: "netcfg", Version: "1.187", Udeb: true},
+		},
+	})
+
+	repo := newFilterTestRepository(fixture.URL, []string{"main", "contrib"})
+	names, err := repo.FetchInstallerPackages()
+	if err != nil {
+		t.Fatalf("FetchInstallerPackages failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"netcfg"}) {
+		t.Fatalf("unexpected installer packages %v", names)
+	}
+	pkg, err := repo.GetPackageMetadata("netcfg")
+	if err != nil {
+		t.Fatalf("GetPackageMetadata failed: %v", err)
+	}
+	if !strings.HasSuffix(pkg.Filename, ".udeb") || !slices.Equal(pkg.Sections, []string{"main/debian-installer"}) {
+		t.Fatalf("unexpected udeb entry %+v", pkg)
+	}
+	for _, warning := range repo.GetWarnings() {
+		if strings.Contains(warning.Subject, "contrib") {
+			t.Fatalf("contrib has no installer index and should be skipped quietly, got %+v", warning)
+		}
+	}
+
+	// The regular fetch is unaffected
+	if names, err := repo.FetchPackages(); err != nil || !slices.Equal(names, []string{"hello"}) {
+		t.Fatalf("FetchPackages returned %v, %v", names, err)
+	}
+
+	repo.SetComponents([]string{"contrib"})
+	if _, err := repo.FetchInstallerPackages(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound without any listed installer index, got %v", err)
+	}
+}
+
+// newSourcesFixture serves one source package, src-<i>, in each of count components
+// named c<i>.
+func newSourcesFixture(tb testing.TB, count int) (*testsupport.Repository, []string) {
+	var components []string
+	var sources []testsupport.Source
+	for i := range count {
+		components = append(components, fmt.Sprintf("c%d", i))
+		sources = append(sources, testsupport.Source{Name: fmt.Sprintf("src-%d", i), Version: "1.0-1", Component: components[i]})
+	}
+	return testsupport.NewRepository(tb, testsupport.Config{Components: components, Sources: sources}), components
+}
+
+func TestFetchSourcesParallel(t *testing.T) {
+	fixture, components := newSourcesFixture(t, 5)
+	for _, ext := range []string{"", ".gz", ".xz"} {
+		fixture.NotFound("dists/bookworm/c2/source/Sources" + ext)
+	}
+
+	repo := newFilterTestRepository(fixture.URL, components)
+	names, err := repo.FetchSourcesParallel(2)
+	if err != nil {
+		t.Fatalf("FetchSourcesParallel failed: %v", err)
+	}
+	want := []string{"src-0", "src-1", "src-3", "src-4"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("got sources %v, want %v", names, want)
+	}
+	var loaded []string
+	for _, src := range repo.SourceMetadata {
+		loaded = append(loaded, src.Name)
+	}
+	if !slices.Equal(loaded, want) {
+		t.Fatalf("expected SourceMetadata in component order, got %v", loaded)
+	}
+	if !slices.ContainsFunc(repo.GetWarnings(), func(w Warning) bool { return w.Subject == "c2/source" }) {
+		t.Fatalf("expected a warning for c2, got %+v", repo.GetWarnings())
+	}
+
+	repo.SetComponents([]string{"c2"})
+	if _, err := repo.FetchSources(); !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "component c2") {
+		t.Fatalf("expected the missing c2 index to fail the fetch, got %v", err)
+	}
+}
+
+func TestLoadCachedSources(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Components: []string{"main", "contrib"},
+		Packages: []testsupport.Package{
+			{Name: "hello", Version: "2.10-3"},
+			{Name: "unrar", Version: "6.2.6-1", Component: "contrib"},
+		},
+		Sources: []testsupport.Source{
+			{Name: "hello", Version: "2.10-3"},
+			{Name: "bash", Version: "5.2.15-2"},
+		},
+	})
+	cacheDir := t.TempDir()
+
+	repo := newFilterTestRepository(fixture.URL, []string{"main", "contrib"})
+	if err := repo.FetchAndCachePackages(cacheDir); err != nil {
+		t.Fatalf("FetchAndCachePackages failed: %v", err)
+	}
+	// contrib has no Sources in the Release file, so none is requested
+	if warnings := repo.GetWarnings(); len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %+v", warnings)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "bookworm", "contrib", "source", "Sources")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected no cached contrib Sources, got %v", err)
+	}
+
+	// Sources can also be cached on their own
+	sourcesOnly := t.TempDir()
+	if err := newFilterTestRepository(fixture.URL, []string{"main"}).FetchAndCacheSources(sourcesOnly); err != nil {
+		t.Fatalf("FetchAndCacheSources failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sourcesOnly, "bookworm", "main", "source", "Sources")); err != nil {
+		t.Fatalf("Sources not cached: %v", err)
+	}
+
+	// The cache is read without any request, the repository being unreachable
+	const offlineURL = "http://example.invalid/debian"
+	offline := newFilterTestRepository(offlineURL, []string{"main", "contrib"})
+	names, err := offline.LoadCachedSources(cacheDir)
+	if err != nil {
+		t.Fatalf("LoadCachedSources failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"bash", "hello"}) {
+		t.Fatalf("unexpected cached sources: %v", names)
+	}
+	src, err := offline.GetSourcePackageMetadata("hello", "2.10-3")
+	if err != nil {
+		t.Fatalf("GetSourcePackageMetadata failed: %v", err)
+	}
+	if src.Directory == "" || len(src.Files) == 0 {
+		t.Fatalf("expected the files of hello, got %+v", src)
+	}
+
+	withPackages := newFilterTestRepository(offlineURL, []string{"main", "contrib"})
+	if _, err := withPackages.LoadCachedPackages(cacheDir); err != nil {
+		t.Fatalf("LoadCachedPackages failed: %v", err)
+	}
+	if _, err := withPackages.GetSourcePackageMetadata("bash", ""); err != nil {
+		t.Fatalf("expected LoadCachedPackages to load the cached sources: %v", err)
+	}
+
+	if _, err := newFilterTestRepository(offlineURL, []string{"contrib"}).LoadCachedSources(cacheDir); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected missing cached sources to be reported, got %v", err)
+	}
+}
+
+func BenchmarkFetchSourcesParallel(b *testing.B) {
+	fixture, components := newSourcesFixture(b, 5)
+	for _, component := range components {
+		for _, ext := range []string{"", ".gz", ".xz"} {
+			fixture.Slow("dists/bookworm/"+component+"/source/Sources"+ext, 10*time.Millisecond)
+		}
+	}
+
+	for _, workers := range []int{1, defaultSourcesConcurrency, len(components)} {
+		b.Run(fmt.Sprintf("goroutines=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := newFilterTestRepository(fixture.URL, components).FetchSourcesParallel(workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRepositoryMetadataTimeout(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{})
+	for _, name := range []string{"InRelease", "Release", "Release.gpg"} {
+		fixture.Slow("dists/bookworm/"+name, 5*time.Second)
+	}
+
+	repo := NewRepository("test", fixture.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.DisableSignatureVerification()
+	repo.SetRetryPolicy(DefaultRetryPolicy{Attempts: 1})
+	repo.MetadataTimeout = 200 * time.Millisecond
+	repo.PackageTimeout = time.Minute
+
+	start := time.Now()
+	if err := repo.FetchReleaseFile(); err == nil {
+		t.Fatal("expected FetchReleaseFile to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("FetchReleaseFile took %v, expected MetadataTimeout to bound it", elapsed)
+	}
+
+	if d := repo.packageDownloader(); d.Timeout != time.Minute {
+		t.Fatalf("expected package downloads to use PackageTimeout, got %v", d.Timeout)
+	}
+}
+
+func TestDistributionAliases(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+	})
+
+	repo := newFilterTestRepository(fixture.URL, []string{"main"})
+	repo.SetSuite("stable")
+	names, err := repo.FetchPackages()
+	if err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"hello"}) {
+		t.Fatalf("unexpected packages %v", names)
+	}
+	if fixture.Requests("dists/bookworm/Release") != 1 || fixture.Requests("dists/stable/Release") != 0 {
+		t.Fatal("expected the Release file of stable to be fetched from dists/bookworm")
+	}
+	var notices []string
+	for _, warning := range repo.GetWarnings() {
+		if warning.Kind == WarningReleaseMismatch {
+			notices = append(notices, warning.Message)
+		}
+	}
+	if !slices.Equal(notices, []string{"Notice: suite stable resolved to codename bookworm"}) {
+		t.Fatalf("unexpected notices %v", notices)
+	}
+
+	repo.AddDistributionAlias("production", "bookworm")
+	repo.AddDistributionAlias("stable", "")
+	if got := repo.buildPackagesURL("production", "main", "amd64"); got != fixture.URL+"/dists/bookworm/main/binary-amd64/Packages" {
+		t.Fatalf("unexpected Packages URL %s", got)
+	}
+	if got := repo.buildReleaseURL(); got != fixture.URL+"/dists/stable/Release" {
+		t.Fatalf("unexpected Release URL %s", got)
+	}
+}
+
+func TestFetchPackagesSkipsCombinationsMissingFromRelease(t *testing.T) {
+	fixture := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{{Name: "hello", Version: "2.10-3"}},
+	})
+	requests := func(component, arch string) int {
+		count := 0
+		for _, ext := range CompressionExtensions {
+			count += fixture.Requests("dists/bookworm/" + component + "/binary-" + arch + "/Packages" + ext)
+		}
+		return count
+	}
+
+	repo := newFilterTestRepository(fixture.URL, []string{"main", "contrib"})
+	repo.SetArchitectures([]string{"amd64", "i386"})
+	names, err := repo.FetchPackages()
+	if err != nil {
+		t.Fatalf("FetchPackages failed: %v", err)
+	}
+	if !slices.Equal(names, []string{"hello"}) {
+		t.Fatalf("unexpected packages %v", names)
+	}
+	if requests("main", "amd64") == 0 || requests("contrib", "amd64")+requests("main", "i386")+requests("contrib", "i386") != 0 {
+		t.Fatal("expected only main/binary-amd64 to be requested")
+	}
+	var skipped []string
+	for _, warning := range repo.GetWarnings() {
+		if warning.Kind == WarningReleaseMismatch {
+			skipped = append(skipped, warning.Subject)
+		}
+	}
+	if !slices.Equal(skipped, []string{"main/binary-i386", "contrib/binary-amd64", "contrib/binary-i386"}) {
+		t.Fatalf("unexpected warnings for %v", skipped)
+	}
+
+	repo = newFilterTestRepository(fixture.URL, []string{"contrib"})
+	if _, err := repo.FetchPackages(); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound without any combination left, got %v", err)
+	}
+}
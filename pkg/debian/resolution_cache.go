@@ -0,0 +1,217 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// resolutionCacheVersion is bumped whenever the key inputs or the file layout change.
+const resolutionCacheVersion = 1
+
+// resolutionCacheFilename is the name of the resolution cache stored per suite.
+const resolutionCacheFilename = "resolution.json"
+
+// ErrResolutionNotCacheable is returned by ResolutionKey when the loaded metadata
+// cannot be identified, e.g. it was not fetched by FetchPackages or was truncated
+// by MetadataDownloadLimit.
+var ErrResolutionNotCacheable = errors.New("dependency resolution cannot be cached")
+
+// ResolutionCache stores the outcome of ResolveDependencies as JSON so that a later
+// run over unchanged metadata can skip the dependency walk. Each suite keeps a single
+// entry; any change to the inputs replaces it.
+type ResolutionCache struct {
+	Dir string // Root directory; entries live in Dir/<suite>/resolution.json
+}
+
+// resolutionCacheEntry is the on-disk representation of a cached resolution.
+type resolutionCacheEntry struct {
+	Version  int                  `json:"version"`
+	Key      string               `json:"key"`
+	Packages []resolutionCacheRef `json:"packages"`
+}
+
+// resolutionCacheRef identifies one resolved package.
+type resolutionCacheRef struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// NewResolutionCache creates the cache directory if needed.
+func NewResolutionCache(dir string) (*ResolutionCache, error) {
+	if strings.TrimSpace(dir) == "" {
+		return nil, fmt.Errorf("resolution cache directory is required")
+	}
+	if err := os.MkdirAll(dir, DirPermission); err != nil {
+		return nil, fmt.Errorf("unable to create resolution cache directory: %w", err)
+	}
+	return &ResolutionCache{Dir: dir}, nil
+}
+
+// path returns where the entry for suite is stored.
+func (c *ResolutionCache) path(suite string) string {
+	return filepath.Join(c.Dir, suite, resolutionCacheFilename)
+}
+
+// ResolutionKey identifies a ResolveDependencies call: it hashes the specs, the
+// exclude set and the Packages indices loaded by the last FetchPackages call, along
+// with everything that shapes the merged metadata (suite, component and architecture
+// order, deduplication strategy). Any input that can change the result must be part
+// of the key.
+func (r *Repository) ResolutionKey(specs []PackageSpec, exclude map[string]bool) (string, error) {
+	r.mu.RLock()
+	digests := r.indexDigests
+	r.mu.RUnlock()
+	if len(digests) == 0 {
+		return "", fmt.Errorf("%w: no Packages index was fetched", ErrResolutionNotCacheable)
+	}
+	if r.metadataLimitHit {
+		return "", fmt.Errorf("%w: metadata was truncated by the download limit", ErrResolutionNotCacheable)
+	}
+
+	excluded := make([]string, 0, len(exclude))
+	for kind, skip := range exclude {
+		if skip {
+			excluded = append(excluded, strings.ToLower(kind))
+		}
+	}
+	sort.Strings(excluded)
+
+	indices := make([]string, 0, len(digests))
+	for index := range digests {
+		indices = append(indices, index)
+	}
+	sort.Strings(indices)
+
+	// Each input is written on its own tagged line with %q so values cannot run together
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "version %d\n", resolutionCacheVersion)
+	fmt.Fprintf(hasher, "suite %q\n", r.Suite)
+	fmt.Fprintf(hasher, "components %q\n", r.Components)
+	fmt.Fprintf(hasher, "architectures %q\n", r.Architectures)
+	fmt.Fprintf(hasher, "deduplication %d\n", r.Deduplication)
+	for _, spec := range specs {
+		fmt.Fprintf(hasher, "spec %q %q\n", strings.TrimSpace(spec.Name), spec.Version)
+	}
+	fmt.Fprintf(hasher, "exclude %q\n", excluded)
+	for _, index := range indices {
+		fmt.Fprintf(hasher, "index %q %s\n", index, digests[index])
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// ResolveDependenciesCached is ResolveDependencies backed by cache. The second result
+// reports whether the resolution was served from the cache. A nil cache, metadata that
+// cannot be keyed, or an unreadable entry fall back to a full resolution; failing to
+// store the new entry is reported through WarningHandler.
+func (r *Repository) ResolveDependenciesCached(cache *ResolutionCache, specs []PackageSpec, exclude map[string]bool) (map[string]Package, bool, error) {
+	if cache == nil {
+		resolved, err := r.ResolveDependencies(specs, exclude)
+		return resolved, false, err
+	}
+
+	key, err := r.ResolutionKey(specs, exclude)
+	if err != nil {
+		resolved, err := r.ResolveDependencies(specs, exclude)
+		return resolved, false, err
+	}
+
+	if resolved, ok := r.loadResolution(cache.path(r.Suite), key); ok {
+		return resolved, true, nil
+	}
+
+	resolved, err := r.ResolveDependencies(specs, exclude)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := saveResolution(cache.path(r.Suite), key, resolved); err != nil {
+		r.warn(WarningOther, r.Suite, fmt.Sprintf("Warning: unable to store dependency resolution for %s: %v", r.Suite, err))
+	}
+
+	return resolved, false, nil
+}
+
+// loadResolution returns the cached resolution at path when it was stored under key
+// and every package it lists is still the one ResolveDependencies would pick.
+func (r *Repository) loadResolution(path, key string) (map[string]Package, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry resolutionCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	if entry.Version != resolutionCacheVersion || entry.Key != key {
+		return nil, false
+	}
+
+	// Same first-wins lookup as ResolveDependencies
+	metadata := r.packageMetadata()
+	index := make(map[string]*Package, len(metadata))
+	for i := range metadata {
+		p := &metadata[i]
+		if _, exists := index[p.Name]; !exists {
+			index[p.Name] = p
+		}
+	}
+
+	resolved := make(map[string]Package, len(entry.Packages))
+	for _, ref := range entry.Packages {
+		pkg := index[ref.Name]
+		if pkg == nil || pkg.Version != ref.Version {
+			return nil, false
+		}
+		resolved[ref.Name] = *pkg
+	}
+
+	return resolved, true
+}
+
+// saveResolution writes resolved to path under key, replacing any previous entry.
+func saveResolution(path, key string, resolved map[string]Package) error {
+	entry := resolutionCacheEntry{Version: resolutionCacheVersion, Key: key}
+	for name, pkg := range resolved {
+		entry.Packages = append(entry.Packages, resolutionCacheRef{Name: name, Version: pkg.Version})
+	}
+	sort.Slice(entry.Packages, func(i, j int) bool {
+		return entry.Packages[i].Name < entry.Packages[j].Name
+	})
+
+	data, err := json.MarshalIndent(&entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding resolution cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), DirPermission); err != nil {
+		return fmt.Errorf("unable to create resolution cache directory: %w", err)
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), resolutionCacheFilename+".*.partial")
+	if err != nil {
+		return fmt.Errorf("unable to create resolution cache: %w", err)
+	}
+	stagingPath := out.Name()
+	defer os.Remove(stagingPath)
+
+	_, err = out.Write(data)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing resolution cache: %w", err)
+	}
+	if err := os.Chmod(stagingPath, FilePermission); err != nil {
+		return fmt.Errorf("error writing resolution cache: %w", err)
+	}
+
+	return os.Rename(stagingPath, path)
+}
@@ -0,0 +1,175 @@
+package debian
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newResolutionTestRepository returns a repository whose metadata looks fetched.
+func newResolutionTestRepository() *Repository {
+	return &Repository{
+		Suite:         "bookworm",
+		Components:    []string{"main", "contrib"},
+		Architectures: []string{"amd64", "arm64"},
+		PackageMetadata: []Package{
+			{Name: "hello", Version: "2.10-3", Depends: []string{"libc6 (>= 2.34)"}},
+			{Name: "libc6", Version: "2.36-9", Recommends: []string{"libidn2-0"}},
+			{Name: "libidn2-0", Version: "2.3.3-1"},
+		},
+		indexDigests: map[string]string{
+			"main/binary-amd64/Packages.xz":    "aaaa",
+			"contrib/binary-amd64/Packages.xz": "bbbb",
+		},
+	}
+}
+
+func TestResolutionKeyCoversEveryInput(t *testing.T) {
+	specs := []PackageSpec{{Name: "hello"}, {Name: "libc6", Version: "2.36-9"}}
+	exclude := map[string]bool{"suggests": true}
+
+	base, err := newResolutionTestRepository().ResolutionKey(specs, exclude)
+	if err != nil {
+		t.Fatalf("ResolutionKey: %v", err)
+	}
+
+	// Inputs that cannot change the result must not invalidate the cache
+	same, _ := newResolutionTestRepository().ResolutionKey(specs, map[string]bool{"suggests": true, "enhances": false})
+	if same != base {
+		t.Fatal("a false exclude entry changed the key")
+	}
+
+	flips := map[string]struct {
+		mutate  func(r *Repository)
+		specs   []PackageSpec
+		exclude map[string]bool
+	}{
+		"spec name":     {specs: []PackageSpec{{Name: "bash"}, {Name: "libc6", Version: "2.36-9"}}},
+		"spec version":  {specs: []PackageSpec{{Name: "hello"}, {Name: "libc6", Version: "2.36-10"}}},
+		"spec order":    {specs: []PackageSpec{{Name: "libc6", Version: "2.36-9"}, {Name: "hello"}}},
+		"spec added":    {specs: append([]PackageSpec{{Name: "bash"}}, specs...)},
+		"exclude set":   {exclude: map[string]bool{"suggests": true, "recommends": true}},
+		"exclude empty": {exclude: map[string]bool{}},
+		"index digest":  {mutate: func(r *Repository) { r.indexDigests["main/binary-amd64/Packages.xz"] = "cccc" }},
+		"index added":   {mutate: func(r *Repository) { r.indexDigests["main/binary-arm64/Packages.xz"] = "dddd" }},
+		"index renamed": {mutate: func(r *Repository) {
+			delete(r.indexDigests, "contrib/binary-amd64/Packages.xz")
+			r.indexDigests["contrib/binary-amd64/Packages.gz"] = "bbbb"
+		}},
+		"suite":              {mutate: func(r *Repository) { r.Suite = "trixie" }},
+		"component order":    {mutate: func(r *Repository) { r.Components = []string{"contrib", "main"} }},
+		"architecture order": {mutate: func(r *Repository) { r.Architectures = []string{"arm64", "amd64"} }},
+		"deduplication":      {mutate: func(r *Repository) { r.Deduplication = StrategyNewestWins }},
+	}
+
+	for name, flip := range flips {
+		t.Run(name, func(t *testing.T) {
+			repo := newResolutionTestRepository()
+			if flip.mutate != nil {
+				flip.mutate(repo)
+			}
+			flipSpecs, flipExclude := specs, exclude
+			if flip.specs != nil {
+				flipSpecs = flip.specs
+			}
+			if flip.exclude != nil {
+				flipExclude = flip.exclude
+			}
+
+			key, err := repo.ResolutionKey(flipSpecs, flipExclude)
+			if err != nil {
+				t.Fatalf("ResolutionKey: %v", err)
+			}
+			if key == base {
+				t.Fatalf("changing the %s did not change the key", name)
+			}
+		})
+	}
+}
+
+func TestResolutionKeyRejectsUnidentifiedMetadata(t *testing.T) {
+	repo := newResolutionTestRepository()
+	repo.indexDigests = nil
+	if _, err := repo.ResolutionKey(nil, nil); err == nil {
+		t.Fatal("expected an error without index digests")
+	}
+
+	repo = newResolutionTestRepository()
+	repo.metadataLimitHit = true
+	if _, err := repo.ResolutionKey(nil, nil); err == nil {
+		t.Fatal("expected an error for truncated metadata")
+	}
+}
+
+func TestResolveDependenciesCached(t *testing.T) {
+	cache, err := NewResolutionCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewResolutionCache: %v", err)
+	}
+	specs := []PackageSpec{{Name: "hello"}}
+
+	repo := newResolutionTestRepository()
+	resolved, hit, err := repo.ResolveDependenciesCached(cache, specs, nil)
+	if err != nil || hit {
+		t.Fatalf("expected a miss on the first run, hit=%v err=%v", hit, err)
+	}
+	if len(resolved) != 3 {
+		t.Fatalf("expected 3 packages, got %d", len(resolved))
+	}
+
+	repo = newResolutionTestRepository()
+	cached, hit, err := repo.ResolveDependenciesCached(cache, specs, nil)
+	if err != nil || !hit {
+		t.Fatalf("expected a hit on the second run, hit=%v err=%v", hit, err)
+	}
+	if len(cached) != 3 || cached["libc6"].Version != "2.36-9" || len(cached["hello"].Depends) != 1 {
+		t.Fatalf("unexpected cached resolution: %+v", cached)
+	}
+
+	// Metadata that disagrees with the entry is a miss even under the same key
+	repo = newResolutionTestRepository()
+	repo.PackageMetadata[1].Version = "2.36-10"
+	if _, hit, _ := repo.ResolveDependenciesCached(cache, specs, nil); hit {
+		t.Fatal("expected a miss when a cached version is no longer in the metadata")
+	}
+
+	repo = newResolutionTestRepository()
+	if _, hit, _ := repo.ResolveDependenciesCached(cache, specs, map[string]bool{"recommends": true}); hit {
+		t.Fatal("expected a miss for a different exclude set")
+	}
+
+	// A corrupt entry falls back to resolving
+	os.WriteFile(filepath.Join(cache.Dir, "bookworm", resolutionCacheFilename), []byte("{"), FilePermission)
+	if _, hit, err := newResolutionTestRepository().ResolveDependenciesCached(cache, specs, nil); err != nil || hit {
+		t.Fatalf("expected a miss for a corrupt entry, hit=%v err=%v", hit, err)
+	}
+}
+
+func TestFetchPackagesRecordsIndexDigests(t *testing.T) {
+	packages := []byte("Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\n\n")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dists/bookworm/main/binary-amd64/Packages" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(packages)
+	}))
+	defer server.Close()
+
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages: %v", err)
+	}
+
+	digests := repo.PackagesIndexDigests()
+	want := fmt.Sprintf("%x", sha256.Sum256(packages))
+	if len(digests) != 1 || digests["main/binary-amd64/Packages"] != want {
+		t.Fatalf("unexpected index digests: %v", digests)
+	}
+}
@@ -0,0 +1,66 @@
+package debian
+
+import (
+	"net/http"
+	"time"
+)
+
+// RetryPolicy decides whether a failed request is tried again and how long to wait
+// before the next attempt. 429 Too Many Requests answers are handled by the throttle
+// gate and never reach the policy.
+type RetryPolicy interface {
+	// ShouldRetry reports whether to try again after attempt (1 for the first request)
+	// failed. err is the network error or an *HTTPStatusError; resp is the server's
+	// answer, nil on network errors, with its body already closed.
+	ShouldRetry(attempt int, err error, resp *http.Response) bool
+	// Delay returns how long to wait after attempt failed before trying again.
+	Delay(attempt int) time.Duration
+}
+
+// DefaultRetryPolicy is the policy of a Downloader without SetRetryPolicy: up to
+// Attempts requests in total, retryDelay apart, whatever the failure.
+type DefaultRetryPolicy struct {
+	Attempts int
+}
+
+// ShouldRetry reports whether fewer than Attempts requests were made.
+func (p DefaultRetryPolicy) ShouldRetry(attempt int, err error, resp *http.Response) bool {
+	return attempt < p.Attempts
+}
+
+// Delay returns the fixed retryDelay.
+func (p DefaultRetryPolicy) Delay(attempt int) time.Duration {
+	return retryDelay
+}
+
+// ExponentialRetryPolicy retries network errors, 408 Request Timeout and 5xx answers
+// up to MaxAttempts requests in total, waiting Base, 2*Base, 4*Base... capped at Max
+// when Max is positive. Other statuses, such as 404 Not Found, fail at once.
+type ExponentialRetryPolicy struct {
+	Base        time.Duration
+	Max         time.Duration
+	MaxAttempts int
+}
+
+// ShouldRetry reports whether the failure is transient and MaxAttempts is not reached.
+func (p ExponentialRetryPolicy) ShouldRetry(attempt int, err error, resp *http.Response) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if resp == nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// Delay returns Base doubled attempt-1 times, capped at Max.
+func (p ExponentialRetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.Base
+	for i := 1; i < attempt && delay > 0 && (p.Max <= 0 || delay < p.Max); i++ {
+		delay *= 2
+	}
+	if p.Max > 0 && delay > p.Max {
+		return p.Max
+	}
+	return delay
+}
@@ -0,0 +1,79 @@
+package debian
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// SatisfiabilityResult is the report of CheckSatisfiability.
+type SatisfiabilityResult struct {
+	Satisfiable  bool
+	UnmetDepends []string // e.g. "hello: libc6 (>= 2.34)"
+	Conflicts    []string // e.g. "exim4 conflicts with postfix"
+}
+
+// String returns a one-line summary of the result.
+func (r *SatisfiabilityResult) String() string {
+	if r.Satisfiable {
+		return "package set is satisfiable"
+	}
+	return fmt.Sprintf("package set is not satisfiable: %d unmet dependencies, %d conflicts", len(r.UnmetDepends), len(r.Conflicts))
+}
+
+// CheckSatisfiability reports whether pkgs can be installed together: every Depends
+// and Pre-Depends entry must name a package of the set, or a virtual package one of
+// them Provides, in at least one alternative, and no package may Conflict with or
+// Break another. Dependency version constraints are ignored; those of Conflicts and
+// Breaks are honored. An error is returned for a package without a name.
+func CheckSatisfiability(pkgs []Package) (*SatisfiabilityResult, error) {
+	available := make(map[string]bool, len(pkgs))
+	for i := range pkgs {
+		if strings.TrimSpace(pkgs[i].Name) == "" {
+			return nil, fmt.Errorf("package at index %d has no name", i)
+		}
+		available[pkgs[i].Name] = true
+		for _, provided := range pkgs[i].Provides {
+			if name, _, _ := parseRelation(provided); name != "" {
+				available[name] = true
+			}
+		}
+	}
+
+	result := &SatisfiabilityResult{}
+	for i := range pkgs {
+		p := &pkgs[i]
+		for _, entry := range slices.Concat(p.PreDepends, p.Depends) {
+			if !relationAvailable(entry, available) {
+				result.UnmetDepends = append(result.UnmetDepends, fmt.Sprintf("%s: %s", p.Name, strings.TrimSpace(entry)))
+			}
+		}
+
+		for j := range pkgs {
+			other := &pkgs[j]
+			if p.Name == other.Name {
+				continue
+			}
+			if conflictsWith(p.Conflicts, *other) {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s conflicts with %s", p.Name, other.Name))
+			}
+			if conflictsWith(p.Breaks, *other) {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s breaks %s", p.Name, other.Name))
+			}
+		}
+	}
+
+	result.Satisfiable = len(result.UnmetDepends) == 0 && len(result.Conflicts) == 0
+	return result, nil
+}
+
+// relationAvailable reports whether any alternative of a relationship entry names a
+// package in available, whatever version constraint is attached.
+func relationAvailable(entry string, available map[string]bool) bool {
+	for _, alternative := range strings.Split(entry, "|") {
+		if name, _, _ := parseRelation(alternative); available[name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package debian
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckSatisfiability(t *testing.T) {
+	satisfiable := []Package{
+		{Name: "hello", Version: "2.10-3", Depends: []string{"libc6 (>= 2.34)"}, PreDepends: []string{"dpkg (>= 1.15)"}},
+		{Name: "libc6", Version: "2.36-9", Breaks: []string{"hurd (<< 1:0.9)"}},
+		{Name: "dpkg", Version: "1.21.22"},
+		{Name: "mutt", Version: "2.2.9-1", Depends: []string{"default-mta | mail-transport-agent"}},
+		{Name: "postfix", Version: "3.7.6-0", Provides: []string{"mail-transport-agent"}, Conflicts: []string{"mail-transport-agent"}},
+		{Name: "hurd", Version: "1:0.9.git20230520-1"},
+	}
+
+	result, err := CheckSatisfiability(satisfiable)
+	if err != nil {
+		t.Fatalf("CheckSatisfiability: %v", err)
+	}
+	if !result.Satisfiable || len(result.UnmetDepends) != 0 || len(result.Conflicts) != 0 {
+		t.Fatalf("expected the set to be satisfiable, got %+v", result)
+	}
+
+	unsatisfiable := []Package{
+		{Name: "hello", Version: "2.10-3", Depends: []string{"libc6 (>= 2.34)", "libfoo | libbar"}},
+		{Name: "libc6", Version: "2.36-9", Breaks: []string{"hurd (<< 1:0.9)"}},
+		{Name: "hurd", Version: "1:0.8-1"},
+		{Name: "exim4", Version: "4.96-15", Conflicts: []string{"postfix"}},
+		{Name: "postfix", Version: "3.7.6-0"},
+	}
+
+	result, err = CheckSatisfiability(unsatisfiable)
+	if err != nil {
+		t.Fatalf("CheckSatisfiability: %v", err)
+	}
+	if result.Satisfiable {
+		t.Fatal("expected the set to be unsatisfiable")
+	}
+	if want := []string{"hello: libfoo | libbar"}; !slices.Equal(result.UnmetDepends, want) {
+		t.Fatalf("unexpected unmet dependencies: got %v, want %v", result.UnmetDepends, want)
+	}
+	if want := []string{"libc6 breaks hurd", "exim4 conflicts with postfix"}; !slices.Equal(result.Conflicts, want) {
+		t.Fatalf("unexpected conflicts: got %v, want %v", result.Conflicts, want)
+	}
+	if got := result.String(); got != "package set is not satisfiable: 1 unmet dependencies, 2 conflicts" {
+		t.Fatalf("unexpected summary: %q", got)
+	}
+
+	if _, err := CheckSatisfiability([]Package{{Version: "1.0"}}); err == nil {
+		t.Fatal("expected an error for a package without a name")
+	}
+}
@@ -0,0 +1,213 @@
+package debian
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSignerMismatch is returned when a Release file carries valid signatures, but
+// none made with the key pinned by RequireSignerFingerprint.
+var ErrSignerMismatch = fmt.Errorf("Release file not signed by the required key")
+
+// SignatureInfo describes a valid signature found on a Release file.
+type SignatureInfo struct {
+	KeyID              string    // Long key ID of the signing key, e.g. "6ED0E7B82643E131"
+	Fingerprint        string    // Fingerprint of the signing key, which may be a subkey
+	PrimaryFingerprint string    // Fingerprint of the primary key the signing key belongs to
+	UserID             string    // User ID of the key as reported by gpgv
+	Created            time.Time // Creation time of the signature; zero when unknown
+}
+
+// matches reports whether fingerprint, normalized, is that of the signing key or of
+// its primary key.
+func (s SignatureInfo) matches(fingerprint string) bool {
+	return fingerprint != "" && (s.Fingerprint == fingerprint || s.PrimaryFingerprint == fingerprint)
+}
+
+// GetSignatureInfo returns the valid signatures of the Release file verified by the
+// last FetchReleaseFile, in the order gpgv reported them; Debian archives sign with
+// several keys. It is empty when signature verification is disabled or no Release
+// file was fetched.
+func (r *Repository) GetSignatureInfo() []SignatureInfo {
+	return slices.Clone(r.signatures)
+}
+
+// RequireSignerFingerprint pins r to the key with the given fingerprint: a Release
+// file whose signatures are all valid against the keyrings, but none made with that
+// key or one of its subkeys, is rejected with ErrSignerMismatch. Spaces and a 0x
+// prefix are ignored, so gpg's grouped output can be pasted as is. An empty
+// fingerprint removes the pin.
+func (r *Repository) RequireSignerFingerprint(fp string) error {
+	normalized, err := NormalizeFingerprint(fp)
+	if err != nil {
+		return err
+	}
+	r.requiredFingerprint = normalized
+	return nil
+}
+
+// pinSigner is RequireSignerFingerprint for a configuration that may not have been
+// validated: an invalid fingerprint is kept as is, so that no signature matches it.
+func (r *Repository) pinSigner(fp string) {
+	if err := r.RequireSignerFingerprint(fp); err != nil {
+		r.requiredFingerprint = fp
+	}
+}
+
+// NormalizeFingerprint returns fp in upper case without spaces or 0x prefix, failing
+// unless it is empty or the 40 (OpenPGP v4) or 64 (v5 and v6) hexadecimal digits of a
+// full fingerprint. Key IDs are refused: they are too short to pin a key safely.
+func NormalizeFingerprint(fp string) (string, error) {
+	normalized := strings.ToUpper(strings.Join(strings.Fields(fp), ""))
+	normalized = strings.TrimPrefix(normalized, "0X")
+	if normalized == "" {
+		return "", nil
+	}
+	if _, err := hex.DecodeString(normalized); err != nil || (len(normalized) != 40 && len(normalized) != 64) {
+		return "", fmt.Errorf("invalid key fingerprint %q: expected 40 or 64 hexadecimal digits", fp)
+	}
+	return normalized, nil
+}
+
+// acceptSignatures records the signatures of a verified Release file, failing with
+// ErrSignerMismatch when a fingerprint is required and none of them matches it.
+func (r *Repository) acceptSignatures(signatures []SignatureInfo) error {
+	if r.requiredFingerprint != "" && !slices.ContainsFunc(signatures, func(s SignatureInfo) bool { return s.matches(r.requiredFingerprint) }) {
+		signers := make([]string, len(signatures))
+		for i, s := range signatures {
+			signers[i] = s.Fingerprint
+		}
+		return fmt.Errorf("%w: expected %s, signed by %s", ErrSignerMismatch, r.requiredFingerprint, strings.Join(signers, ", "))
+	}
+	r.signatures = signatures
+	return nil
+}
+
+// parseGPGStatus extracts the valid signatures from gpgv --status-fd output: a
+// GOODSIG line, carrying the key ID and user ID, is followed by the VALIDSIG line of
+// the same signature with the fingerprints and creation time.
+func parseGPGStatus(output []byte) []SignatureInfo {
+	var signatures []SignatureInfo
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+
+		switch fields[1] {
+		case "GOODSIG":
+			signatures = append(signatures, SignatureInfo{KeyID: fields[2], UserID: strings.Join(fields[3:], " ")})
+		case "VALIDSIG":
+			if len(signatures) == 0 || signatures[len(signatures)-1].Fingerprint != "" {
+				signatures = append(signatures, SignatureInfo{})
+			}
+			sig := &signatures[len(signatures)-1]
+			sig.Fingerprint = fields[2]
+			sig.PrimaryFingerprint = fields[2]
+			if len(fields) > 11 {
+				sig.PrimaryFingerprint = fields[11]
+			}
+			if len(fields) > 4 {
+				if seconds, err := strconv.ParseInt(fields[4], 10, 64); err == nil {
+					sig.Created = time.Unix(seconds, 0).UTC()
+				}
+			}
+			if sig.KeyID == "" && len(sig.Fingerprint) >= 16 {
+				sig.KeyID = sig.Fingerprint[len(sig.Fingerprint)-16:]
+			}
+		}
+	}
+	return signatures
+}
+
+// signedReleaseFiles lists the files cacheSignedRelease may write for a suite.
+var signedReleaseFiles = []string{"InRelease", "Release", "Release.gpg"}
+
+// signedReleaseCacheDir returns the directory holding the cached signed Release of
+// suite, refusing suites that would escape SignedReleaseDir.
+func (r *Repository) signedReleaseCacheDir(suite string) (string, error) {
+	if r.SignedReleaseDir == "" {
+		return "", fmt.Errorf("no signed Release directory configured")
+	}
+	if suite == "" || !filepath.IsLocal(filepath.FromSlash(suite)) {
+		return "", fmt.Errorf("invalid suite name %q", suite)
+	}
+	return filepath.Join(r.SignedReleaseDir, filepath.FromSlash(suite)), nil
+}
+
+// cacheSignedRelease writes the verified files of the Release of r.Suite, keyed by
+// name, when CacheSignedRelease is set. Files of the other signature form are removed
+// so that the cache only holds what was last verified.
+func (r *Repository) cacheSignedRelease(files map[string][]byte) error {
+	if !r.CacheSignedRelease {
+		return nil
+	}
+	dir, err := r.signedReleaseCacheDir(r.Suite)
+	if err != nil {
+		return fmt.Errorf("unable to cache signed Release: %w", err)
+	}
+	if err := os.MkdirAll(dir, DirPermission); err != nil {
+		return fmt.Errorf("unable to cache signed Release: %w", err)
+	}
+
+	for _, name := range signedReleaseFiles {
+		path := filepath.Join(dir, name)
+		data, ok := files[name]
+		if !ok {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("unable to cache signed Release: %w", err)
+			}
+			continue
+		}
+		if err := os.WriteFile(path, data, FilePermission); err != nil {
+			return fmt.Errorf("unable to cache signed Release: %w", err)
+		}
+	}
+	return nil
+}
+
+// VerifyLocalRelease verifies again, against KeyringPaths and without network access,
+// the signed Release of suite cached by a fetch with CacheSignedRelease: InRelease
+// when present, Release and Release.gpg otherwise. The pin set with
+// RequireSignerFingerprint applies, and GetSignatureInfo then reports the signatures
+// found.
+func (r *Repository) VerifyLocalRelease(suite string) error {
+	r.fetchMu.Lock()
+	defer r.fetchMu.Unlock()
+
+	dir, err := r.signedReleaseCacheDir(suite)
+	if err != nil {
+		return err
+	}
+
+	inRelease, err := os.ReadFile(filepath.Join(dir, "InRelease"))
+	if err == nil {
+		if err := r.verifyClearsigned(inRelease); err != nil {
+			return fmt.Errorf("cached InRelease for suite %s: %w", suite, err)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to read cached InRelease: %w", err)
+	}
+
+	release, err := os.ReadFile(filepath.Join(dir, "Release"))
+	if err != nil {
+		return fmt.Errorf("no cached signed Release for suite %s: %w", suite, err)
+	}
+	signature, err := os.ReadFile(filepath.Join(dir, "Release.gpg"))
+	if err != nil {
+		return fmt.Errorf("no cached Release.gpg for suite %s: %w", suite, err)
+	}
+	if err := r.verifyDetachedSignature(release, signature); err != nil {
+		return fmt.Errorf("cached Release for suite %s: %w", suite, err)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package debian
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is where a Mirror publishes the files it mirrors, e.g. an S3 bucket
+// implemented out of tree. Paths are slash-separated and relative to the root of
+// the mirror, such as dists/bookworm/Release or pool/main/h/hello/hello_2.10-3_amd64.deb.
+// Implementations must be safe for concurrent use; missing files are reported with
+// errors wrapping fs.ErrNotExist.
+type Storage interface {
+	// Put stores size bytes read from r at path, replacing any existing file only
+	// once all of them were received. size is -1 when unknown.
+	Put(path string, r io.Reader, size int64, mode os.FileMode) error
+	Stat(path string) (fs.FileInfo, error)
+	Open(path string) (io.ReadCloser, error)
+	Delete(path string) error
+	Rename(oldPath, newPath string) error
+}
+
+// ChecksumStorage is implemented by the Storage backends able to report the checksum
+// of a stored file without it being read back, e.g. from object metadata. algo is
+// "sha256" or "md5"; the checksum is returned in lowercase hex.
+type ChecksumStorage interface {
+	Storage
+	Checksum(path, algo string) (string, error)
+}
+
+// LocalStorage stores files under the Root directory, the default of a Mirror.
+type LocalStorage struct {
+	Root string
+}
+
+// NewLocalStorage returns a LocalStorage rooted at root.
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{Root: root}
+}
+
+// localPath returns the file path of p under Root, rejecting paths escaping it.
+func (s *LocalStorage) localPath(p string) (string, error) {
+	native := filepath.FromSlash(p)
+	if !filepath.IsLocal(native) {
+		return "", fmt.Errorf("invalid storage path %q", p)
+	}
+	return filepath.Join(s.Root, native), nil
+}
+
+// Put writes r to a staging file next to path, then renames it into place.
+func (s *LocalStorage) Put(p string, r io.Reader, size int64, mode os.FileMode) error {
+	dest, err := s.localPath(p)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), DirPermission); err != nil {
+		return fmt.Errorf("unable to create parent directory: %w", err)
+	}
+
+	staging, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.partial")
+	if err != nil {
+		return fmt.Errorf("unable to create staging file: %w", err)
+	}
+	stagingPath := staging.Name()
+	defer os.Remove(stagingPath)
+
+	written, err := io.Copy(staging, r)
+	if closeErr := staging.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", p, err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("%w: %s: received %d of %d bytes", ErrTruncatedDownload, p, written, size)
+	}
+	if err := os.Chmod(stagingPath, mode); err != nil {
+		return fmt.Errorf("unable to set permissions on staging file: %w", err)
+	}
+	return os.Rename(stagingPath, dest)
+}
+
+// Stat returns the file information of path.
+func (s *LocalStorage) Stat(p string) (fs.FileInfo, error) {
+	dest, err := s.localPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(dest)
+}
+
+// Open opens path for reading.
+func (s *LocalStorage) Open(p string) (io.ReadCloser, error) {
+	dest, err := s.localPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(dest)
+}
+
+// Delete removes path.
+func (s *LocalStorage) Delete(p string) error {
+	dest, err := s.localPath(p)
+	if err != nil {
+		return err
+	}
+	return os.Remove(dest)
+}
+
+// Rename moves oldPath to newPath, replacing it.
+func (s *LocalStorage) Rename(oldPath, newPath string) error {
+	src, err := s.localPath(oldPath)
+	if err != nil {
+		return err
+	}
+	dest, err := s.localPath(newPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), DirPermission); err != nil {
+		return fmt.Errorf("unable to create parent directory: %w", err)
+	}
+	return os.Rename(src, dest)
+}
+
+// Checksum hashes the file at path with algo.
+func (s *LocalStorage) Checksum(p, algo string) (string, error) {
+	dest, err := s.localPath(p)
+	if err != nil {
+		return "", err
+	}
+	h, err := newChecksumHash(algo)
+	if err != nil {
+		return "", err
+	}
+	return hashFile(dest, h)
+}
+
+// MemoryStorage keeps files in memory. It serves as an example Storage and lets tests
+// inspect what a Mirror publishes.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// NewMemoryStorage returns an empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string]memoryFile)}
+}
+
+// Put stores the content of r at path.
+func (s *MemoryStorage) Put(p string, r io.Reader, size int64, mode os.FileMode) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", p, err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("%w: %s: received %d of %d bytes", ErrTruncatedDownload, p, len(data), size)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path.Clean(p)] = memoryFile{data: data, mode: mode, modTime: time.Now()}
+	return nil
+}
+
+// Stat returns the file information of path.
+func (s *MemoryStorage) Stat(p string) (fs.FileInfo, error) {
+	file, err := s.file(p)
+	if err != nil {
+		return nil, err
+	}
+	return memoryFileInfo{name: path.Base(p), file: file}, nil
+}
+
+// Open returns a reader on the content of path.
+func (s *MemoryStorage) Open(p string) (io.ReadCloser, error) {
+	file, err := s.file(p)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(file.data)), nil
+}
+
+// Delete removes path.
+func (s *MemoryStorage) Delete(p string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[path.Clean(p)]; !ok {
+		return &fs.PathError{Op: "delete", Path: p, Err: fs.ErrNotExist}
+	}
+	delete(s.files, path.Clean(p))
+	return nil
+}
+
+// Rename moves oldPath to newPath, replacing it.
+func (s *MemoryStorage) Rename(oldPath, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.files[path.Clean(oldPath)]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrNotExist}
+	}
+	delete(s.files, path.Clean(oldPath))
+	s.files[path.Clean(newPath)] = file
+	return nil
+}
+
+// Checksum hashes the content of path with algo.
+func (s *MemoryStorage) Checksum(p, algo string) (string, error) {
+	file, err := s.file(p)
+	if err != nil {
+		return "", err
+	}
+	h, err := newChecksumHash(algo)
+	if err != nil {
+		return "", err
+	}
+	h.Write(file.data)
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// Paths returns the stored paths, sorted.
+func (s *MemoryStorage) Paths() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	paths := make([]string, 0, len(s.files))
+	for p := range s.files {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func (s *MemoryStorage) file(p string) (memoryFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, ok := s.files[path.Clean(p)]
+	if !ok {
+		return memoryFile{}, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	return file, nil
+}
+
+// memoryFileInfo describes a file of a MemoryStorage.
+type memoryFileInfo struct {
+	name string
+	file memoryFile
+}
+
+func (i memoryFileInfo) Name() string       { return i.name }
+func (i memoryFileInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i memoryFileInfo) Mode() fs.FileMode  { return i.file.mode }
+func (i memoryFileInfo) ModTime() time.Time { return i.file.modTime }
+func (i memoryFileInfo) IsDir() bool        { return false }
+func (i memoryFileInfo) Sys() any           { return nil }
+
+// newChecksumHash returns the hash of algo, "sha256" or "md5".
+func newChecksumHash(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "sha256":
+		return sha256.New(), nil
+	case "md5":
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum type: %s", algo)
+	}
+}
+
+// storageChecksum returns the checksum of path in storage, read back and hashed when
+// storage does not implement ChecksumStorage.
+func storageChecksum(storage Storage, p, algo string) (string, error) {
+	if cs, ok := storage.(ChecksumStorage); ok {
+		return cs.Checksum(p, algo)
+	}
+	h, err := newChecksumHash(algo)
+	if err != nil {
+		return "", err
+	}
+	r, err := storage.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("error computing checksum: %w", err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,107 @@
+package debian
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting (HTTP 429) handling.
+const (
+	throttleMaxRetries    = 10              // 429 responses tolerated per request; they do not use RetryAttempts
+	throttleWarnThreshold = 3               // 429 responses for one request before a warning is reported
+	throttleMaxDelay      = 5 * time.Minute // Upper bound for Retry-After and the fallback backoff
+)
+
+// ErrThrottled is returned when a server kept answering 429 Too Many Requests after
+// throttleMaxRetries pauses, as opposed to a generic download failure.
+var ErrThrottled = fmt.Errorf("server is rate limiting requests")
+
+// throttleGate is a backoff signal shared by every request of a Downloader or a
+// DownloadQueue: once a server answers 429, all workers wait until the pause ends
+// instead of each retrying on its own schedule.
+type throttleGate struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+func newThrottleGate() *throttleGate {
+	return &throttleGate{}
+}
+
+// backoff pauses requests through the gate for at least delay.
+func (g *throttleGate) backoff(delay time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if until := time.Now().Add(delay); until.After(g.until) {
+		g.until = until
+	}
+}
+
+// wait blocks until the current pause is over or ctx is cancelled. The deadline is
+// re-read after each sleep since another worker may have extended it.
+func (g *throttleGate) wait(ctx context.Context) error {
+	for {
+		g.mu.Lock()
+		remaining := time.Until(g.until)
+		g.mu.Unlock()
+
+		if remaining <= 0 {
+			return nil
+		}
+		if err := sleepContext(ctx, remaining); err != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext sleeps for delay unless ctx is cancelled first.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttleDelay returns how long to pause after the n-th 429 response for a request:
+// the Retry-After value when the server sent a valid one, otherwise retryDelay
+// doubled for each previous 429. The result never exceeds throttleMaxDelay.
+func throttleDelay(resp *http.Response, n int) time.Duration {
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		delay = retryDelay << min(n-1, 8)
+	}
+	return min(delay, throttleMaxDelay)
+}
+
+// parseRetryAfter parses a Retry-After header, given either as delay-seconds or as
+// an HTTP-date, into a delay relative to now. Dates in the past yield zero.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return min(time.Duration(seconds), throttleMaxDelay/time.Second) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
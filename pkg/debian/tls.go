@@ -0,0 +1,46 @@
+package debian
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+)
+
+// TLSConfig customizes the TLS connections of a Downloader or Repository, e.g. for a
+// private repository behind a proxy presenting certificates of a corporate CA.
+type TLSConfig struct {
+	// InsecureSkipVerify accepts any server certificate and host name. The connection
+	// is then open to interception: a man in the middle can serve anything, and only
+	// Release signature and checksum verification still protect the content. Prefer
+	// RootCAs, and never combine it with SkipGPGVerify.
+	InsecureSkipVerify bool
+	RootCAs            *x509.CertPool  // CAs trusted instead of the system pool when set
+	ClientCert         tls.Certificate // Presented to servers requiring a client certificate when set
+}
+
+// transport returns an HTTP transport applying c on top of http.DefaultTransport.
+func (c TLSConfig) transport() *http.Transport {
+	config := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		RootCAs:            c.RootCAs,
+	}
+	if len(c.ClientCert.Certificate) > 0 {
+		config.Certificates = []tls.Certificate{c.ClientCert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}
+
+// SetTLSConfig applies cfg to every later request of d. The connections opened
+// with it are shared by those requests.
+func (d *Downloader) SetTLSConfig(cfg TLSConfig) {
+	d.transport = cfg.transport()
+}
+
+// SetTLSConfig applies cfg to every later request of r, including the downloads it
+// starts.
+func (r *Repository) SetTLSConfig(cfg TLSConfig) {
+	r.transport = cfg.transport()
+}
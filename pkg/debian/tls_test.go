@@ -0,0 +1,62 @@
+package debian
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSConfigTrustsCustomCA(t *testing.T) {
+	packages := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\n\n"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			fmt.Fprint(w, packages)
+		case "/file":
+			fmt.Fprint(w, "content")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// The test server certificate is self-signed, so it acts as its own CA
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	dest := filepath.Join(t.TempDir(), "file")
+
+	untrusted := NewDownloaderWithOptions(WithRetryAttempts(1))
+	if err := untrusted.DownloadURL(server.URL+"/file", dest); err == nil {
+		t.Fatal("expected the self-signed certificate to be rejected by default")
+	}
+
+	for name, cfg := range map[string]TLSConfig{
+		"custom CA":   {RootCAs: pool},
+		"skip verify": {InsecureSkipVerify: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := NewDownloaderWithOptions(WithRetryAttempts(1))
+			d.SetTLSConfig(cfg)
+			if err := d.DownloadURL(server.URL+"/file", dest); err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			if data, err := os.ReadFile(dest); err != nil || string(data) != "content" {
+				t.Fatalf("unexpected content %q (err %v)", data, err)
+			}
+		})
+	}
+
+	repo := NewRepository("private", server.URL, "private", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.VerifyRelease = false
+	repo.SetTLSConfig(TLSConfig{RootCAs: pool})
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages through the custom CA failed: %v", err)
+	}
+	if _, err := repo.GetPackageMetadata("hello"); err != nil {
+		t.Fatalf("hello not found: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package debian
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ReleaseMismatchError is returned by ValidateAgainstRelease when configured
+// components or architectures are not listed in the Release file.
+type ReleaseMismatchError struct {
+	UnknownComponents      []string
+	AvailableComponents    []string
+	UnknownArchitectures   []string
+	AvailableArchitectures []string
+}
+
+func (e *ReleaseMismatchError) Error() string {
+	var parts []string
+	if len(e.UnknownComponents) > 0 {
+		parts = append(parts, fmt.Sprintf("unknown components: %s (available: %s)", strings.Join(e.UnknownComponents, ", "), strings.Join(e.AvailableComponents, ", ")))
+	}
+	if len(e.UnknownArchitectures) > 0 {
+		parts = append(parts, fmt.Sprintf("unknown architectures: %s (available: %s)", strings.Join(e.UnknownArchitectures, ", "), strings.Join(e.AvailableArchitectures, ", ")))
+	}
+	return strings.Join(parts, " ; ")
+}
+
+// NewRepositoryValidated creates a Repository like NewRepository and returns the
+// result of Validate along with it.
+func NewRepositoryValidated(name, url, description, suite string, components, architectures []string) (*Repository, error) {
+	repo := NewRepository(name, url, description, suite, components, architectures)
+	if err := repo.Validate(); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// Validate checks the repository configuration without network access, using the
+// same rules as MirrorConfig.Validate. Whitespace-only list entries are rejected.
+func (r *Repository) Validate() error {
+	if strings.TrimSpace(r.URL) == "" {
+		return fmt.Errorf("URL is required")
+	}
+	if !hasHTTPScheme(r.URL) {
+		return fmt.Errorf("URL must start with http:// or https://")
+	}
+	if strings.TrimSpace(r.Suite) == "" {
+		return fmt.Errorf("suite is required")
+	}
+	if err := validateList("component", r.Components); err != nil {
+		return err
+	}
+	if r.SourceOnly && len(r.Architectures) == 0 {
+		return nil
+	}
+	return validateList("architecture", r.Architectures)
+}
+
+// ValidateAgainstRelease checks that the configured components and architectures are
+// listed in the Release file, fetching it first when ReleaseInfo is not loaded.
+// SourceArchitecture is always accepted, and architectures are not checked at all
+// for a SourceOnly repository. Mismatches are reported as a *ReleaseMismatchError.
+func (r *Repository) ValidateAgainstRelease() error {
+	if err := r.loadReleaseInfo(); err != nil {
+		return err
+	}
+	if err := r.ExpandArchitectures(); err != nil {
+		return err
+	}
+	if r.AutoAdjustComponents {
+		if err := r.adjustComponentsToRelease(); err != nil {
+			return err
+		}
+	}
+
+	release := r.GetReleaseInfo()
+	mismatch := &ReleaseMismatchError{
+		UnknownComponents:      FindUnknownValues(r.Components, release.Components),
+		AvailableComponents:    release.Components,
+		AvailableArchitectures: release.Architectures,
+	}
+	if !r.SourceOnly {
+		mismatch.UnknownArchitectures = FindUnknownValues(r.BinaryArchitectures(), release.Architectures)
+	}
+	if len(mismatch.UnknownComponents) > 0 || len(mismatch.UnknownArchitectures) > 0 {
+		return mismatch
+	}
+	return nil
+}
+
+// loadReleaseInfo fetches the Release file unless ReleaseInfo is already loaded.
+func (r *Repository) loadReleaseInfo() error {
+	if r.GetReleaseInfo() == nil {
+		if err := r.FetchReleaseFile(); err != nil {
+			return fmt.Errorf("failed to fetch Release file: %w", err)
+		}
+	}
+	if r.GetReleaseInfo() == nil {
+		return fmt.Errorf("Release information unavailable for validation")
+	}
+	return nil
+}
+
+// GetAvailableComponents returns the components the Release file of the suite lists,
+// fetching it when ReleaseInfo is not loaded.
+func (r *Repository) GetAvailableComponents() ([]string, error) {
+	if err := r.loadReleaseInfo(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(r.GetReleaseInfo().Components), nil
+}
+
+// GetAvailableSuiteArchitectures returns the architectures the Release file of the
+// suite lists, fetching it when ReleaseInfo is not loaded. GetAvailableArchitectures
+// reports those of a single package instead.
+func (r *Repository) GetAvailableSuiteArchitectures() ([]string, error) {
+	if err := r.loadReleaseInfo(); err != nil {
+		return nil, err
+	}
+	return slices.Clone(r.GetReleaseInfo().Architectures), nil
+}
+
+// SupportsComponent reports whether the Release file of the suite lists component,
+// compared case-insensitively as ValidateAgainstRelease does. It is false as well
+// when the Release file cannot be fetched; GetAvailableComponents returns why.
+func (r *Repository) SupportsComponent(component string) bool {
+	available, err := r.GetAvailableComponents()
+	return err == nil && strings.TrimSpace(component) != "" && len(FindUnknownValues([]string{component}, available)) == 0
+}
+
+// SupportsArchitecture reports whether the Release file of the suite lists arch,
+// like SupportsComponent.
+func (r *Repository) SupportsArchitecture(arch string) bool {
+	available, err := r.GetAvailableSuiteArchitectures()
+	return err == nil && strings.TrimSpace(arch) != "" && len(FindUnknownValues([]string{arch}, available)) == 0
+}
+
+// ReleaseComponents returns the components listed in the fetched Release file, or nil
+// when FetchReleaseFile has not been called yet.
+func (r *Repository) ReleaseComponents() []string {
+	release := r.GetReleaseInfo()
+	if release == nil {
+		return nil
+	}
+	return slices.Clone(release.Components)
+}
+
+// adjustComponentsToRelease applies AdjustComponentsToRelease to r.Components,
+// reporting changes as warnings. It fails only when no configured component is left.
+func (r *Repository) adjustComponentsToRelease() error {
+	available := r.ReleaseComponents()
+	if len(available) == 0 {
+		return nil // Some flat or third-party repositories omit Components
+	}
+
+	kept, dropped, added := AdjustComponentsToRelease(r.Components, available)
+	if len(kept) == 0 {
+		return &ReleaseMismatchError{UnknownComponents: dropped, AvailableComponents: available}
+	}
+	for _, component := range dropped {
+		r.warn(WarningReleaseMismatch, component, fmt.Sprintf("Warning: component '%s' is not available in suite %s, skipping it", component, r.Suite))
+	}
+	for _, component := range added {
+		r.warn(WarningReleaseMismatch, component, fmt.Sprintf("Warning: suite %s splits firmware into '%s', adding it", r.Suite, component))
+	}
+
+	r.Components = kept
+	return nil
+}
+
+// AdjustComponentsToRelease reconciles configured components with those a Release file
+// lists. Components the suite does not have are returned in dropped. When non-free is
+// kept and the suite has the non-free-firmware split (Debian 12 and later) that was not
+// configured, non-free-firmware is added right after non-free and returned in added.
+func AdjustComponentsToRelease(configured, available []string) (kept, dropped, added []string) {
+	dropped = FindUnknownValues(configured, available)
+	for _, component := range configured {
+		if strings.TrimSpace(component) != "" && !slices.Contains(dropped, component) {
+			kept = append(kept, component)
+		}
+	}
+
+	hasComponent := func(list []string, name string) bool {
+		return slices.ContainsFunc(list, func(c string) bool { return strings.EqualFold(strings.TrimSpace(c), name) })
+	}
+	if hasComponent(kept, nonFreeComponent) && hasComponent(available, nonFreeFirmwareComponent) && !hasComponent(kept, nonFreeFirmwareComponent) {
+		idx := slices.IndexFunc(kept, func(c string) bool { return strings.EqualFold(strings.TrimSpace(c), nonFreeComponent) })
+		kept = slices.Insert(kept, idx+1, nonFreeFirmwareComponent)
+		added = append(added, nonFreeFirmwareComponent)
+	}
+
+	return kept, dropped, added
+}
+
+// FindUnknownValues returns the entries of values missing from allowed, compared
+// case-insensitively. Blank entries are ignored.
+func FindUnknownValues(values, allowed []string) []string {
+	allowedSet := make(map[string]struct{}, len(allowed))
+	for _, item := range allowed {
+		key := strings.ToLower(strings.TrimSpace(item))
+		if key != "" {
+			allowedSet[key] = struct{}{}
+		}
+	}
+
+	var unknown []string
+	for _, value := range values {
+		key := strings.ToLower(strings.TrimSpace(value))
+		if key == "" {
+			continue
+		}
+		if _, ok := allowedSet[key]; !ok {
+			unknown = append(unknown, value)
+		}
+	}
+
+	return unknown
+}
+
+// validateList checks that a configuration list is non-empty and has no blank entries.
+func validateList(kind string, values []string) error {
+	if len(values) == 0 {
+		return fmt.Errorf("at least one %s is required", kind)
+	}
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("%s list contains an empty entry", kind)
+		}
+	}
+	return nil
+}
+
+// hasHTTPScheme reports whether rawURL starts with http:// or https://.
+func hasHTTPScheme(rawURL string) bool {
+	return strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://")
+}
@@ -0,0 +1,221 @@
+package debian
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRepositoryValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		repo    *Repository
+		wantErr bool
+	}{
+		{"valid", NewRepository("r", "https://deb.debian.org/debian", "", "bookworm", []string{"main"}, []string{"amd64"}), false},
+		{"empty URL", NewRepository("r", "", "", "bookworm", []string{"main"}, []string{"amd64"}), true},
+		{"unsupported scheme", NewRepository("r", "ftp://deb.debian.org/debian", "", "bookworm", []string{"main"}, []string{"amd64"}), true},
+		{"empty suite", NewRepository("r", "http://deb.debian.org/debian", "", " ", []string{"main"}, []string{"amd64"}), true},
+		{"no components", NewRepository("r", "http://deb.debian.org/debian", "", "bookworm", nil, []string{"amd64"}), true},
+		{"blank architecture", NewRepository("r", "http://deb.debian.org/debian", "", "bookworm", []string{"main"}, []string{"amd64", "  "}), true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.repo.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	if _, err := NewRepositoryValidated("r", "", "", "bookworm", []string{"main"}, []string{"amd64"}); err == nil {
+		t.Error("NewRepositoryValidated should reject an empty URL")
+	}
+}
+
+func TestValidateAgainstRelease(t *testing.T) {
+	repo := NewRepository("r", "http://deb.debian.org/debian", "", "bookworm", []string{"main", "Contrib", "extra"}, []string{"amd64", "sparc"})
+	repo.ReleaseInfo = &ReleaseFile{
+		Components:    []string{"main", "contrib", "non-free"},
+		Architectures: []string{"amd64", "arm64"},
+	}
+
+	err := repo.ValidateAgainstRelease()
+	var mismatch *ReleaseMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected ReleaseMismatchError, got %v", err)
+	}
+	if !reflect.DeepEqual(mismatch.UnknownComponents, []string{"extra"}) || !reflect.DeepEqual(mismatch.UnknownArchitectures, []string{"sparc"}) {
+		t.Fatalf("unexpected mismatch: %+v", mismatch)
+	}
+
+	repo.SetComponents([]string{"main"})
+	repo.SetArchitectures([]string{"arm64"})
+	if err := repo.ValidateAgainstRelease(); err != nil {
+		t.Fatalf("expected configuration to match Release, got %v", err)
+	}
+}
+
+func TestAdjustComponentsToRelease(t *testing.T) {
+	bullseye := []string{"main", "contrib", "non-free"}
+	bookworm := []string{"main", "contrib", "non-free", "non-free-firmware"}
+
+	tests := []struct {
+		name                 string
+		configured           []string
+		available            []string
+		kept, dropped, added []string
+	}{
+		{"bookworm config on bullseye", []string{"main", "non-free", "non-free-firmware"}, bullseye, []string{"main", "non-free"}, []string{"non-free-firmware"}, nil},
+		{"bullseye config on bookworm", []string{"main", "non-free", "contrib"}, bookworm, []string{"main", "non-free", "non-free-firmware", "contrib"}, nil, []string{"non-free-firmware"}},
+		{"already split", []string{"main", "non-free", "non-free-firmware"}, bookworm, []string{"main", "non-free", "non-free-firmware"}, nil, nil},
+		{"no non-free", []string{"main"}, bookworm, []string{"main"}, nil, nil},
+	}
+
+	for _, tt := range tests {
+		kept, dropped, added := AdjustComponentsToRelease(tt.configured, tt.available)
+		if !reflect.DeepEqual(kept, tt.kept) || !reflect.DeepEqual(dropped, tt.dropped) || !reflect.DeepEqual(added, tt.added) {
+			t.Errorf("%s: got kept=%v dropped=%v added=%v, want %v %v %v", tt.name, kept, dropped, added, tt.kept, tt.dropped, tt.added)
+		}
+	}
+}
+
+func TestValidateAgainstReleaseAutoAdjust(t *testing.T) {
+	configured := []string{"main", "non-free-firmware"}
+	repo := NewRepository("r", "http://deb.debian.org/debian", "", "bullseye", configured, []string{"amd64"})
+	repo.ReleaseInfo = &ReleaseFile{
+		Components:    []string{"main", "contrib", "non-free"},
+		Architectures: []string{"amd64"},
+	}
+	repo.AutoAdjustComponents = true
+	var warnings []string
+	repo.WarningHandler = func(msg string) { warnings = append(warnings, msg) }
+
+	if err := repo.ValidateAgainstRelease(); err != nil {
+		t.Fatalf("expected the unknown component to be dropped, got %v", err)
+	}
+	if !reflect.DeepEqual(repo.Components, []string{"main"}) || len(warnings) != 1 {
+		t.Fatalf("unexpected components %v and warnings %v", repo.Components, warnings)
+	}
+	if configured[1] != "non-free-firmware" {
+		t.Fatal("the caller's component slice must not be modified")
+	}
+	if got := repo.ReleaseComponents(); !reflect.DeepEqual(got, []string{"main", "contrib", "non-free"}) {
+		t.Fatalf("unexpected ReleaseComponents %v", got)
+	}
+
+	repo.SetComponents([]string{"non-free-firmware"})
+	var mismatch *ReleaseMismatchError
+	if err := repo.ValidateAgainstRelease(); !errors.As(err, &mismatch) {
+		t.Fatalf("expected ReleaseMismatchError when no component is left, got %v", err)
+	}
+}
+
+func TestSourceArchitectureValidation(t *testing.T) {
+	release := &ReleaseFile{Components: []string{"main", "contrib"}, Architectures: []string{"amd64", "arm64"}}
+
+	repo := NewSourceRepository("r", "http://deb.debian.org/debian", "", "bookworm", []string{"main"})
+	if err := repo.Validate(); err != nil {
+		t.Fatalf("a source repository needs no architecture, got %v", err)
+	}
+	repo.ReleaseInfo = release
+	if err := repo.ValidateAgainstRelease(); err != nil {
+		t.Fatalf("expected a source repository to pass validation, got %v", err)
+	}
+	if _, err := repo.FetchPackages(); !errors.Is(err, ErrNoBinaryArchitectures) {
+		t.Fatalf("expected ErrNoBinaryArchitectures, got %v", err)
+	}
+
+	repo.SetComponents([]string{"non-free"})
+	var mismatch *ReleaseMismatchError
+	if err := repo.ValidateAgainstRelease(); !errors.As(err, &mismatch) || len(mismatch.UnknownComponents) != 1 {
+		t.Fatalf("expected components to be checked for a source repository, got %v", err)
+	}
+
+	// "source" next to binary architectures is accepted and skipped for binary indices
+	mixed := NewRepository("r", "http://deb.debian.org/debian", "", "bookworm", []string{"main"}, []string{"amd64", SourceArchitecture})
+	mixed.ReleaseInfo = release
+	if err := mixed.ValidateAgainstRelease(); err != nil {
+		t.Fatalf("expected the source pseudo-architecture to be accepted, got %v", err)
+	}
+	if got := mixed.BinaryArchitectures(); !reflect.DeepEqual(got, []string{"amd64"}) {
+		t.Fatalf("unexpected binary architectures %v", got)
+	}
+
+	binary := NewRepository("r", "http://deb.debian.org/debian", "", "bookworm", []string{"main"}, nil)
+	if err := binary.Validate(); err == nil {
+		t.Fatal("expected a binary repository without architectures to be rejected")
+	}
+}
+
+func TestReleaseComponentsAndArchitectures(t *testing.T) {
+	tests := []struct {
+		fixture       string
+		suite         string
+		components    []string
+		architectures []string
+		supported     []string
+		unsupported   []string
+	}{
+		{
+			fixture:       "bookworm_Release",
+			suite:         "bookworm",
+			components:    []string{"main", "contrib", "non-free-firmware", "non-free"},
+			architectures: []string{"all", "amd64", "arm64", "armel", "armhf", "i386", "mips64el", "mipsel", "ppc64el", "s390x"},
+			supported:     []string{"Non-Free-Firmware", "MIPS64EL"},
+			unsupported:   []string{"universe", "riscv64"},
+		},
+		{
+			fixture:       "jammy_Release",
+			suite:         "jammy",
+			components:    []string{"main", "restricted", "universe", "multiverse"},
+			architectures: []string{"amd64", "arm64", "armhf", "i386", "ppc64el", "riscv64", "s390x"},
+			supported:     []string{"Universe", "riscv64"},
+			unsupported:   []string{"non-free", "mips64el"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.suite, func(t *testing.T) {
+			release, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatalf("failed to read fixture: %v", err)
+			}
+			var fetches atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/dists/"+tt.suite+"/Release" {
+					http.NotFound(w, r)
+					return
+				}
+				fetches.Add(1)
+				w.Write(release)
+			}))
+			defer server.Close()
+
+			repo := NewRepository("r", server.URL, "", tt.suite, []string{"main"}, []string{"amd64"})
+			repo.DisableSignatureVerification()
+
+			components, err := repo.GetAvailableComponents()
+			if err != nil || !reflect.DeepEqual(components, tt.components) {
+				t.Fatalf("GetAvailableComponents() = %v, %v; want %v", components, err, tt.components)
+			}
+			architectures, err := repo.GetAvailableSuiteArchitectures()
+			if err != nil || !reflect.DeepEqual(architectures, tt.architectures) {
+				t.Fatalf("GetAvailableSuiteArchitectures() = %v, %v; want %v", architectures, err, tt.architectures)
+			}
+
+			if !repo.SupportsComponent(tt.supported[0]) || !repo.SupportsArchitecture(tt.supported[1]) {
+				t.Fatalf("expected %v to be supported", tt.supported)
+			}
+			if repo.SupportsComponent(tt.unsupported[0]) || repo.SupportsArchitecture(tt.unsupported[1]) || repo.SupportsComponent(" ") {
+				t.Fatalf("expected %v to be unsupported", tt.unsupported)
+			}
+			if n := fetches.Load(); n != 1 {
+				t.Fatalf("expected the Release file to be fetched once, got %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package debian
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares two Debian version strings using dpkg ordering rules.
+// It returns -1 if a < b, 0 if a == b, and 1 if a > b.
+func CompareVersions(a, b string) int {
+	epochA, upstreamA, revisionA := splitVersion(a)
+	epochB, upstreamB, revisionB := splitVersion(b)
+
+	if epochA != epochB {
+		if epochA < epochB {
+			return -1
+		}
+		return 1
+	}
+
+	if result := compareVersionPart(upstreamA, upstreamB); result != 0 {
+		return result
+	}
+
+	return compareVersionPart(revisionA, revisionB)
+}
+
+// splitVersion splits a version into epoch, upstream version, and Debian revision.
+func splitVersion(version string) (int, string, string) {
+	version = strings.TrimSpace(version)
+
+	epoch := 0
+	if idx := strings.Index(version, ":"); idx != -1 {
+		if value, err := strconv.Atoi(version[:idx]); err == nil {
+			epoch = value
+		}
+		version = version[idx+1:]
+	}
+
+	revision := ""
+	if idx := strings.LastIndex(version, "-"); idx != -1 {
+		revision = version[idx+1:]
+		version = version[:idx]
+	}
+
+	return epoch, version, revision
+}
+
+// compareVersionPart compares upstream or revision strings by alternating
+// non-digit and digit segments, as dpkg does.
+func compareVersionPart(a, b string) int {
+	for a != "" || b != "" {
+		var textA, textB string
+		textA, a = splitLeading(a, false)
+		textB, b = splitLeading(b, false)
+		if result := compareVersionText(textA, textB); result != 0 {
+			return result
+		}
+
+		var numA, numB string
+		numA, a = splitLeading(a, true)
+		numB, b = splitLeading(b, true)
+		if result := compareVersionNumber(numA, numB); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+// splitLeading returns the leading run of digits (or non-digits) and the remainder.
+func splitLeading(s string, digits bool) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// versionCharOrder returns the dpkg sort weight of a character:
+// '~' sorts before everything, letters before non-letters.
+func versionCharOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case isDigit(c):
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+func compareVersionText(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var orderA, orderB int
+		if i < len(a) {
+			orderA = versionCharOrder(a[i])
+		}
+		if i < len(b) {
+			orderB = versionCharOrder(b[i])
+		}
+		if orderA != orderB {
+			if orderA < orderB {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func compareVersionNumber(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// parseRelation splits a single relationship atom such as "libssl1.0 (<< 1.1)"
+// into package name, operator, and version. Architecture qualifiers (":any")
+// and restriction lists ("[amd64]", "<!nocheck>") are dropped.
+func parseRelation(expr string) (name, op, version string) {
+	expr = strings.TrimSpace(expr)
+
+	if idx := strings.IndexAny(expr, "[<"); idx != -1 && !strings.Contains(expr[:idx], "(") {
+		expr = strings.TrimSpace(expr[:idx])
+	}
+
+	name = expr
+	if open := strings.Index(expr, "("); open != -1 {
+		name = strings.TrimSpace(expr[:open])
+		constraint := expr[open+1:]
+		if end := strings.Index(constraint, ")"); end != -1 {
+			constraint = constraint[:end]
+		}
+		constraint = strings.TrimSpace(constraint)
+
+		i := 0
+		for i < len(constraint) && strings.ContainsRune("<>=", rune(constraint[i])) {
+			i++
+		}
+		op = constraint[:i]
+		version = strings.TrimSpace(constraint[i:])
+	}
+
+	if colon := strings.Index(name, ":"); colon != -1 {
+		name = name[:colon]
+	}
+	name = strings.TrimSpace(name)
+
+	return name, op, version
+}
+
+// versionSatisfies reports whether version satisfies the relation "op constraint".
+// An empty operator matches any version.
+func versionSatisfies(version, op, constraint string) bool {
+	if op == "" || constraint == "" {
+		return true
+	}
+
+	result := CompareVersions(version, constraint)
+	switch op {
+	case "<<":
+		return result < 0
+	case "<=", "<": // The deprecated "<" means "<=" (Debian Policy §7.1)
+		return result <= 0
+	case "=":
+		return result == 0
+	case ">=", ">": // Likewise, the deprecated ">" means ">="
+		return result >= 0
+	case ">>":
+		return result > 0
+	default:
+		return false
+	}
+}
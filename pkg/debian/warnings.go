@@ -0,0 +1,103 @@
+package debian
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// WarningKind classifies a Warning.
+type WarningKind string
+
+// Warning kinds reported by Repository, Mirror and Downloader.
+const (
+	WarningMalformedMetadata  WarningKind = "malformed_metadata"  // A metadata line or entry could not be parsed and was ignored
+	WarningMissingChecksum    WarningKind = "missing_checksum"    // A file was accepted without a checksum to verify it
+	WarningFallbackURL        WarningKind = "fallback_url"        // A URL or pool path was guessed because metadata did not provide it
+	WarningSignatureDowngrade WarningKind = "signature_downgrade" // A weaker signature path was used, e.g. Release.gpg after InRelease failed
+	WarningFileFailed         WarningKind = "file_failed"         // A file failed without aborting the bulk operation
+	WarningReleaseMismatch    WarningKind = "release_mismatch"    // The configuration was adjusted to the Release file
+	WarningThrottled          WarningKind = "throttled"           // The server kept answering HTTP 429
+	WarningMissingIndex       WarningKind = "missing_index"       // An index was not found upstream and skipped, see MirrorConfig.SkipMissing
+	WarningUnsatisfiable      WarningKind = "unsatisfiable"       // A selected package set is not installable as a whole, see CheckSatisfiability
+	WarningSuiteConflict      WarningKind = "suite_conflict"      // Suites resolved a package to different versions and one was picked for all
+	WarningOther              WarningKind = "other"
+)
+
+// Warning is a non-fatal issue met during a fetch, mirror or download operation.
+type Warning struct {
+	Kind    WarningKind `json:"kind"`
+	Subject string      `json:"subject,omitempty"` // File, URL, package or suite concerned
+	Message string      `json:"message"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("[%s] %s", w.Kind, w.Message)
+}
+
+// WarningCollector accumulates warnings. It is safe for concurrent use, so parallel
+// downloads may share one, and a nil collector discards everything.
+type WarningCollector struct {
+	mu       sync.Mutex
+	warnings []Warning
+}
+
+// NewWarningCollector returns an empty WarningCollector.
+func NewWarningCollector() *WarningCollector {
+	return &WarningCollector{}
+}
+
+// Add records w.
+func (c *WarningCollector) Add(w Warning) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings = append(c.warnings, w)
+}
+
+// Warnings returns a copy of the recorded warnings in the order they were added.
+func (c *WarningCollector) Warnings() []Warning {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.warnings)
+}
+
+// Reset drops the recorded warnings.
+func (c *WarningCollector) Reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings = nil
+}
+
+// GetWarnings returns the warnings recorded by the repository and the downloads it
+// started.
+func (r *Repository) GetWarnings() []Warning {
+	return r.Warnings.Warnings()
+}
+
+// warn records a warning and passes its message to WarningHandler.
+func (r *Repository) warn(kind WarningKind, subject, msg string) {
+	r.Warnings.Add(Warning{Kind: kind, Subject: subject, Message: msg})
+	if r.WarningHandler != nil {
+		r.WarningHandler(msg)
+	}
+}
+
+// GetWarnings returns the warnings recorded by the downloader.
+func (d *Downloader) GetWarnings() []Warning {
+	return d.Warnings.Warnings()
+}
+
+// GetWarnings returns the warnings recorded while mirroring, including those of the
+// mirror's repository and downloader.
+func (m *Mirror) GetWarnings() []Warning {
+	return m.warnings.Warnings()
+}
@@ -0,0 +1,140 @@
+package debian
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWarningCollectorConcurrentAdd(t *testing.T) {
+	collector := NewWarningCollector()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collector.Add(Warning{Kind: WarningFileFailed, Message: fmt.Sprint(i)})
+		}()
+	}
+	wg.Wait()
+
+	if got := len(collector.Warnings()); got != 50 {
+		t.Fatalf("expected 50 warnings, got %d", got)
+	}
+	collector.Reset()
+	if got := collector.Warnings(); got != nil {
+		t.Fatalf("expected no warnings after Reset, got %v", got)
+	}
+
+	var nilCollector *WarningCollector
+	nilCollector.Add(Warning{Kind: WarningOther})
+	if nilCollector.Warnings() != nil {
+		t.Fatal("a nil collector must discard warnings")
+	}
+}
+
+// warningKinds counts warnings per kind.
+func warningKinds(warnings []Warning) map[WarningKind]int {
+	kinds := make(map[WarningKind]int)
+	for _, w := range warnings {
+		kinds[w.Kind]++
+	}
+	return kinds
+}
+
+func TestRepositoryRecordsMetadataWarnings(t *testing.T) {
+	packages := "Package: hello\nVersion: 2.10-3\nthis line has no field name\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\n\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release":
+			fmt.Fprint(w, "Suite: bookworm\nDate: someday\nSHA256:\n not-a-checksum-line\n")
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			fmt.Fprint(w, packages)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	repo := NewRepository("test", server.URL, "test", "bookworm", []string{"main"}, []string{"amd64"})
+	repo.DisableSignatureVerification()
+	var handled []string
+	repo.WarningHandler = func(msg string) { handled = append(handled, msg) }
+
+	if err := repo.FetchReleaseFile(); err != nil {
+		t.Fatalf("FetchReleaseFile: %v", err)
+	}
+	repo.VerifyRelease = false
+	if _, err := repo.FetchPackages(); err != nil {
+		t.Fatalf("FetchPackages: %v", err)
+	}
+
+	warnings := repo.GetWarnings()
+	if got := warningKinds(warnings)[WarningMalformedMetadata]; got != 3 {
+		t.Fatalf("expected 3 malformed metadata warnings (date, checksum line, Packages line), got %v", warnings)
+	}
+	if len(handled) != len(warnings) {
+		t.Fatalf("expected WarningHandler to receive every warning, got %v", handled)
+	}
+}
+
+func TestDownloaderRecordsBulkFailuresAndMissingChecksums(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok.deb" {
+			fmt.Fprint(w, "payload")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	d := NewDownloader()
+	d.RetryAttempts = 1
+	destDir := t.TempDir()
+
+	errs := d.DownloadMultiple([]*Package{
+		{Name: "ok", DownloadURL: server.URL + "/ok.deb", Filename: "ok.deb"},
+		{Name: "missing", DownloadURL: server.URL + "/missing.deb", Filename: "missing.deb"},
+	}, destDir, 2)
+	if len(errs) != 1 {
+		t.Fatalf("expected one failure, got %v", errs)
+	}
+
+	existing := filepath.Join(destDir, "ok.deb")
+	if err := os.WriteFile(existing, []byte("payload"), FilePermission); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if skip, err := d.ShouldSkipDownload(&Package{Name: "ok", Size: 7}, existing); err != nil || !skip {
+		t.Fatalf("expected a size-only skip, got %v, %v", skip, err)
+	}
+
+	kinds := warningKinds(d.GetWarnings())
+	if kinds[WarningFileFailed] != 1 || kinds[WarningMissingChecksum] != 1 {
+		t.Fatalf("unexpected warnings: %v", d.GetWarnings())
+	}
+}
+
+func TestDownloadPlanJSONIncludesWarnings(t *testing.T) {
+	plan := &DownloadPlan{Warnings: []Warning{{Kind: WarningFallbackURL, Subject: "hello", Message: "guessed"}}}
+
+	var out bytes.Buffer
+	if err := plan.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded struct {
+		Warnings []Warning `json:"warnings"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(decoded.Warnings) != 1 || decoded.Warnings[0].Kind != WarningFallbackURL || decoded.Warnings[0].Subject != "hello" {
+		t.Fatalf("unexpected warnings in plan JSON: %s", out.String())
+	}
+}
@@ -0,0 +1,776 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// CustomRepoOptions configures CustomRepoOperation.
+type CustomRepoOptions struct {
+	Source
+	DestDir        string
+	Packages       []debian.PackageSpec
+	ExcludeDeps    map[string]bool // Dependency kinds not followed, e.g. "recommends"
+	IncludeSources bool
+	RateDelay      time.Duration
+	// IncludeUncompressed also writes the uncompressed Packages files next to
+	// Packages.gz and Packages.xz, for clients that cannot decompress.
+	IncludeUncompressed bool
+	// Signing signs the Release files; they are left unsigned when nil.
+	Signing *debian.ReleaseSigningConfig
+	// InstallScript writes install-<suite>.sh to DestDir, installing the packages of
+	// the suite batch by batch in the order of CustomRepoResult.InstallOrder.
+	InstallScript bool
+
+	// DryRun plans the downloads without performing them; dists/ is only written
+	// when WriteMetadata is set.
+	DryRun        bool
+	WriteMetadata bool
+
+	// KeepGoing records the packages failing to download in CustomRepoResult.Failures
+	// and goes on with the others, leaving them out of the metadata; the operation
+	// then fails with a *PartialBuildError once every suite is written.
+	KeepGoing bool
+	// RetryFailed downloads only these packages, the Failures of an earlier KeepGoing
+	// build into the same DestDir, and merges them into the Packages files it wrote
+	// before regenerating the Release files. Packages is ignored then.
+	RetryFailed []CustomRepoFailure
+	// ConflictPolicy settles the packages the suites resolve to different versions or
+	// files, which would otherwise be stored twice in the shared pool.
+	ConflictPolicy ConflictPolicy
+
+	Cache           *debian.ObjectCache     // Shared content-addressed cache, optional
+	ResolutionCache *debian.ResolutionCache // Reuses unchanged dependency resolutions, optional
+	Log             Logger
+}
+
+// ConflictPolicy tells CustomRepoOperation what to do with a package the suites of a
+// build resolve differently.
+type ConflictPolicy int
+
+const (
+	// ConflictHighestVersion lists the highest version in every suite resolving the
+	// package, with a warning. Package.Suite tells which suite it was taken from.
+	ConflictHighestVersion ConflictPolicy = iota
+	// ConflictError fails the build with a *SuiteConflictError.
+	ConflictError
+)
+
+// SuiteConflictError reports a package the suites of a build resolve to different
+// versions, or to different files under the same version.
+type SuiteConflictError struct {
+	Package      string
+	Architecture string
+	Entries      []debian.Package // Resolved by each suite, in build order; Suite tells which
+}
+
+func (e *SuiteConflictError) Error() string {
+	return fmt.Sprintf("package %s:%s resolves differently across suites: %s", e.Package, e.Architecture, describeEntries(e.Entries))
+}
+
+// CustomRepoResult is the outcome of CustomRepoOperation.
+type CustomRepoResult struct {
+	Plan     *debian.DownloadPlan        // Set for a dry run, with the warnings
+	Packages map[string][]debian.Package // Selected packages by suite
+	// Signatures holds, by suite, the verified signatures of the upstream Release
+	// file, to record which key vouched for the packages.
+	Signatures map[string][]debian.SignatureInfo
+	// InstallOrder holds, by suite, the batches to install the packages in (see
+	// debian.TopologicalInstallOrder). A suite is missing when its packages
+	// Pre-Depend on each other, which is reported as a warning.
+	InstallOrder map[string][][]string
+	Warnings     []debian.Warning
+	// Failures lists the packages a KeepGoing build could not download.
+	Failures []CustomRepoFailure
+}
+
+// CustomRepoFailure is a package a KeepGoing custom repository build could not
+// download, as saved by WriteFailures for a later RetryFailed build.
+type CustomRepoFailure struct {
+	Suite        string `json:"suite"`
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	Architecture string `json:"architecture"`
+	Component    string `json:"component"`
+	// Origin is the suite the package was taken from when it is not Suite, see
+	// ConflictHighestVersion.
+	Origin string `json:"origin,omitempty"`
+	Error  string `json:"error"`
+}
+
+// PartialBuildError is returned by a KeepGoing CustomRepoOperation that could not
+// download every package; the repository holds all the others.
+type PartialBuildError struct {
+	Failures []CustomRepoFailure
+}
+
+func (e *PartialBuildError) Error() string {
+	return fmt.Sprintf("%d packages could not be downloaded", len(e.Failures))
+}
+
+// customRepoFailures is the layout of a failures file.
+type customRepoFailures struct {
+	Failures []CustomRepoFailure `json:"failures"`
+}
+
+// WriteFailures saves failures to path as JSON, for ReadFailures.
+func WriteFailures(path string, failures []CustomRepoFailure) error {
+	data, err := json.MarshalIndent(customRepoFailures{Failures: failures}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), debian.FilePermission); err != nil {
+		return fmt.Errorf("unable to write failures file: %w", err)
+	}
+	return nil
+}
+
+// ReadFailures reads the failures saved by WriteFailures.
+func ReadFailures(path string) ([]CustomRepoFailure, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read failures file: %w", err)
+	}
+	var file customRepoFailures
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid failures file %s: %w", path, err)
+	}
+	return file.Failures, nil
+}
+
+// CustomRepoOperation builds a repository in opts.DestDir holding opts.Packages and
+// their dependencies, resolved across every component of each suite, and writes its
+// metadata. The context is checked between suites and cancels metadata fetches.
+func CustomRepoOperation(ctx context.Context, opts CustomRepoOptions) (*CustomRepoResult, error) {
+	retrying := len(opts.RetryFailed) > 0
+	if len(opts.Packages) == 0 && !retrying {
+		return nil, fmt.Errorf("at least one package is required")
+	}
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.DestDir, debian.DirPermission); err != nil {
+		return nil, fmt.Errorf("unable to create destination directory: %w", err)
+	}
+
+	// Warnings of every suite's repository and downloader
+	warnings := debian.NewWarningCollector()
+	result := &CustomRepoResult{
+		Packages:     make(map[string][]debian.Package),
+		Signatures:   make(map[string][]debian.SignatureInfo),
+		InstallOrder: make(map[string][][]string),
+	}
+	defer func() { result.Warnings = warnings.Warnings() }()
+	if opts.DryRun {
+		result.Plan = &debian.DownloadPlan{}
+	}
+	writeDists := !opts.DryRun || opts.WriteMetadata
+
+	metadataRoot := filepath.Join(opts.DestDir, "dists")
+	if writeDists {
+		if err := os.MkdirAll(metadataRoot, debian.DirPermission); err != nil {
+			return nil, fmt.Errorf("unable to create metadata directory: %w", err)
+		}
+	}
+
+	// Resolve every suite before downloading, so that a package the suites resolve
+	// differently is settled once for all of them
+	var builds []*suiteBuild
+	buildsBySuite := make(map[string]*suiteBuild)
+	for _, suite := range opts.Suites {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		retried := retriedPackages(opts.RetryFailed, suite)
+		if retrying && len(retried) == 0 && !originOfFailure(opts.RetryFailed, suite) {
+			continue
+		}
+
+		build, err := resolveSuite(ctx, suite, opts, result, warnings)
+		if err != nil {
+			return result, err
+		}
+		build.retried = retried
+		builds = append(builds, build)
+		buildsBySuite[suite] = build
+	}
+
+	if retrying {
+		// The earlier build resolved and reconciled the set; only its failed downloads are missing
+		for _, build := range builds {
+			if len(build.retried) == 0 {
+				continue
+			}
+			resolved, err := findRetriedPackages(buildsBySuite, build.suite, build.retried)
+			if err != nil {
+				return result, fmt.Errorf("failed to find the packages to retry for %s: %w", build.suite, err)
+			}
+			build.resolved = resolved
+			opts.Log.printf("Suite %s: retrying %d failed packages", build.suite, len(resolved))
+		}
+	} else if err := reconcileSuites(builds, opts.ConflictPolicy, opts.Log, warnings); err != nil {
+		return result, err
+	}
+
+	for _, build := range builds {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if retrying && len(build.retried) == 0 {
+			continue
+		}
+		suite, resolved := build.suite, build.resolved
+		suiteComponents, suiteArchitectures := build.components, build.architectures
+
+		// apt installs from the generated repository alone, so the set must be self-contained
+		if !retrying {
+			if err := checkPackageSet(resolved, suite, opts.Log, warnings); err != nil {
+				return result, err
+			}
+		}
+
+		packageMetadata := make(map[string]map[string][]debian.Package)
+		sourceMetadata := make(map[string][]debian.SourcePackage)
+		downloader := debian.NewDownloader()
+		downloader.RateDelay = opts.RateDelay
+		downloader.Queue = debian.SharedDownloadQueue()
+		downloader.Cache = opts.Cache
+		downloader.Warnings = warnings
+
+		// Download packages and organize by their original component
+		type pendingPackage struct {
+			component string
+			arch      string
+			path      string
+			pkg       *debian.Package
+		}
+		var toDownload []*debian.Package
+		var pending []pendingPackage
+
+		for _, pkg := range resolved {
+			arch := pkg.Architecture
+			if arch == "" {
+				arch = suiteArchitectures[0]
+			}
+
+			// Extract component from Filename (e.g., pool/non-free/s/snmp/... -> non-free)
+			component := extractComponentFromPath(pkg.Filename, suiteComponents)
+			if component == "" {
+				// Fallback: use first component if extraction fails
+				component = suiteComponents[0]
+				opts.Log.printf("Warning: could not determine component for %s, using %s", pkg.Name, component)
+			}
+
+			relPath := pkg.Filename
+			if relPath == "" {
+				filename := filepath.Base(packageFilename(&pkg))
+				relPath = filepath.ToSlash(filepath.Join("pool", component, filename))
+			}
+
+			targetPath := filepath.Join(opts.DestDir, filepath.FromSlash(relPath))
+
+			skip, err := downloader.ShouldSkipDownload(&pkg, targetPath)
+			if err != nil {
+				return result, fmt.Errorf("failed to check existing file for %s: %w", pkg.Name, err)
+			}
+
+			pkg.Filename = filepath.ToSlash(relPath)
+			entry := pendingPackage{component: component, arch: arch, path: targetPath, pkg: &pkg}
+			pending = append(pending, entry)
+
+			if result.Plan != nil {
+				result.Plan.Add(debian.PlanEntry{URL: pkg.DownloadURL, DestPath: targetPath, Size: pkg.Size, Skip: skip, Suite: pkg.Suite})
+				continue
+			}
+			if skip {
+				opts.Log.printf("Suite %s: skipping %s from %s (already downloaded, checksum verified)", suite, pkg.Name, component)
+				continue
+			}
+			toDownload = append(toDownload, entry.pkg)
+		}
+
+		// Submit all downloads to the shared queue so concurrency stays bounded across suites
+		failed := make(map[*debian.Package]error)
+		if errs := downloader.DownloadMultiple(toDownload, opts.DestDir, 0); len(errs) > 0 {
+			if !opts.KeepGoing {
+				return result, fmt.Errorf("failed to download packages: %w", errors.Join(errs...))
+			}
+			for _, err := range errs {
+				var downloadErr *debian.PackageDownloadError
+				if !errors.As(err, &downloadErr) {
+					return result, fmt.Errorf("failed to download packages: %w", err)
+				}
+				failed[downloadErr.Package] = downloadErr.Err
+			}
+		}
+
+		for _, entry := range pending {
+			if err, ok := failed[entry.pkg]; ok {
+				opts.Log.printf("Suite %s: %s failed to download, leaving it out: %v", suite, entry.pkg.Name, err)
+				failure := CustomRepoFailure{
+					Suite:        suite,
+					Package:      entry.pkg.Name,
+					Version:      entry.pkg.Version,
+					Architecture: entry.pkg.Architecture,
+					Component:    entry.component,
+					Error:        err.Error(),
+				}
+				if entry.pkg.Suite != suite {
+					failure.Origin = entry.pkg.Suite
+				}
+				result.Failures = append(result.Failures, failure)
+				continue
+			}
+			// Upstream indices rarely carry SHA512; the Packages files written here always do
+			if result.Plan == nil && entry.pkg.SHA512 == "" {
+				if err := entry.pkg.ComputeSHA512(entry.path); err != nil {
+					return result, err
+				}
+			}
+			if _, ok := packageMetadata[entry.component]; !ok {
+				packageMetadata[entry.component] = make(map[string][]debian.Package)
+			}
+			packageMetadata[entry.component][entry.arch] = append(packageMetadata[entry.component][entry.arch], *entry.pkg)
+			result.Packages[suite] = append(result.Packages[suite], *entry.pkg)
+		}
+		slices.SortFunc(result.Packages[suite], func(a, b debian.Package) int { return strings.Compare(a.Name, b.Name) })
+
+		// A retry only holds part of the set, whose order was recorded by the first build
+		if !retrying {
+			if err := recordInstallOrder(result, suite, opts, warnings); err != nil {
+				return result, err
+			}
+		}
+
+		// Download source packages if requested; a retry keeps those of the first build
+		if opts.IncludeSources && !retrying {
+			resolvedSlice := make([]debian.Package, 0, len(resolved))
+			for _, pkg := range resolved {
+				resolvedSlice = append(resolvedSlice, pkg)
+			}
+
+			// Group source packages by component, each looked up in the suite its binaries come from
+			for _, component := range suiteComponents {
+				componentPkgs := make(map[string][]debian.Package)
+				for _, pkg := range resolvedSlice {
+					pkgComponent := extractComponentFromPath(pkg.Filename, suiteComponents)
+					if pkgComponent == component || pkgComponent == "" {
+						componentPkgs[pkg.Suite] = append(componentPkgs[pkg.Suite], pkg)
+					}
+				}
+
+				for _, origin := range slices.Sorted(maps.Keys(componentPkgs)) {
+					repo := build.repo
+					if originBuild, ok := buildsBySuite[origin]; ok {
+						repo = originBuild.repo
+					}
+					srcPkgs, err := downloadSourcePackages(repo, componentPkgs[origin], opts.DestDir, component, downloader, opts.Log, suite, result.Plan)
+					if err != nil {
+						return result, fmt.Errorf("failed to download source packages for %s/%s: %w", suite, component, err)
+					}
+					sourceMetadata[component] = append(sourceMetadata[component], srcPkgs...)
+				}
+			}
+		}
+
+		if !writeDists {
+			continue
+		}
+
+		var err error
+		if retrying {
+			err = debian.MergePackagesMetadata(metadataRoot, suite, packageMetadata, opts.IncludeUncompressed)
+		} else {
+			err = debian.WritePackagesMetadataToStorage(debian.NewLocalStorage(metadataRoot), "", suite, packageMetadata, opts.IncludeUncompressed)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if opts.IncludeSources && len(sourceMetadata) > 0 {
+			if err := debian.WriteSourcesMetadata(metadataRoot, suite, sourceMetadata); err != nil {
+				return result, err
+			}
+		}
+
+		if opts.Signing != nil {
+			opts.Log.printf("Suite %s: signing Release files with GPG key %s", suite, opts.Signing.PrivateKeyPath)
+		} else {
+			opts.Log.printf("Suite %s: no GPG key provided, Release files will be unsigned", suite)
+		}
+
+		includeSources := opts.IncludeSources && (retrying || len(sourceMetadata) > 0)
+		if err := debian.WriteSignedReleaseFilesToStorage(debian.NewLocalStorage(metadataRoot), "", suite, suiteComponents, suiteArchitectures, includeSources, opts.IncludeUncompressed, opts.Signing); err != nil {
+			return result, fmt.Errorf("failed to write Release files for suite %s: %w", suite, err)
+		}
+	}
+
+	if result.Plan != nil {
+		result.Plan.Warnings = warnings.Warnings()
+	}
+	if len(result.Failures) > 0 {
+		return result, &PartialBuildError{Failures: result.Failures}
+	}
+	return result, nil
+}
+
+// retriedPackages returns the failures of suite among failures.
+func retriedPackages(failures []CustomRepoFailure, suite string) []CustomRepoFailure {
+	var retried []CustomRepoFailure
+	for _, failure := range failures {
+		if failure.Suite == suite {
+			retried = append(retried, failure)
+		}
+	}
+	return retried
+}
+
+// originOfFailure reports whether a failure of failures was taken from suite for
+// another suite, whose retry then needs the metadata of suite.
+func originOfFailure(failures []CustomRepoFailure, suite string) bool {
+	for _, failure := range failures {
+		if failure.Origin == suite {
+			return true
+		}
+	}
+	return false
+}
+
+// findRetriedPackages looks the failed packages of suite up by name, version and
+// architecture, in the metadata of the suite they were taken from.
+func findRetriedPackages(builds map[string]*suiteBuild, suite string, failures []CustomRepoFailure) (map[string]debian.Package, error) {
+	packages := make(map[string]debian.Package, len(failures))
+	for _, failure := range failures {
+		origin := suite
+		if failure.Origin != "" {
+			origin = failure.Origin
+		}
+		build, ok := builds[origin]
+		if !ok {
+			return nil, fmt.Errorf("suite %s of package %s is not part of the build", origin, failure.Package)
+		}
+		pkg, err := build.repo.GetPackageMetadataWithArch(failure.Package, failure.Version, []string{failure.Architecture})
+		if err != nil {
+			return nil, err
+		}
+		packages[failure.Package+":"+failure.Architecture] = *pkg
+	}
+	return packages, nil
+}
+
+// suiteBuild holds a suite of a custom repository between the resolution of its
+// packages and their download.
+type suiteBuild struct {
+	suite         string
+	repo          *debian.Repository
+	components    []string // As validated against the Release file
+	architectures []string
+	resolved      map[string]debian.Package
+	retried       []CustomRepoFailure // Failures of the suite, when retrying
+}
+
+// resolveSuite validates suite against its Release file, recording the signatures in
+// result, fetches its metadata and, unless retrying, resolves opts.Packages in it.
+func resolveSuite(ctx context.Context, suite string, opts CustomRepoOptions, result *CustomRepoResult, warnings *debian.WarningCollector) (*suiteBuild, error) {
+	repo := opts.repository("custom-repo"+suite, "custom repo", suite)
+	repo.Warnings = warnings
+
+	// Validate all components and architectures first
+	if err := ValidateSuite(repo, opts.Lenient); err != nil {
+		return nil, &SuiteError{Suite: suite, Err: err}
+	}
+	if signatures := repo.GetSignatureInfo(); len(signatures) > 0 {
+		result.Signatures[suite] = signatures
+		for _, signature := range signatures {
+			opts.Log.printf("Suite %s: Release signed by %s (%s)", suite, signature.PrimaryFingerprint, signature.UserID)
+		}
+	}
+	// Lenient validation may have adjusted the components for this suite, and
+	// "*" or "host" architectures are expanded against its Release file
+	build := &suiteBuild{suite: suite, repo: repo, components: repo.Components, architectures: repo.Architectures}
+
+	// Fetch metadata for ALL components before resolving dependencies
+	opts.Log.printf("Suite %s: fetching metadata for all components (%s), architectures %s...", suite, strings.Join(build.components, ", "), strings.Join(build.architectures, ", "))
+
+	if _, err := repo.FetchPackagesWithContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch packages for %s: %w", suite, err)
+	}
+	if len(opts.RetryFailed) > 0 {
+		return build, nil
+	}
+
+	// Resolve dependencies across ALL components, reusing the previous result when nothing changed
+	resolved, cacheHit, err := repo.ResolveDependenciesCached(opts.ResolutionCache, opts.Packages, opts.ExcludeDeps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve dependencies for %s: %w", suite, err)
+	}
+	if cacheHit {
+		opts.Log.printf("Suite %s: dependency resolution cache hit", suite)
+	} else if opts.ResolutionCache != nil {
+		opts.Log.printf("Suite %s: dependency resolution cache miss", suite)
+	}
+	opts.Log.printf("Suite %s: %d packages to download across all components", suite, len(resolved))
+	build.resolved = resolved
+	return build, nil
+}
+
+// reconcileSuites finds the packages, by name and architecture, that builds resolved
+// differently: to another version, or to another file under the same version, which
+// would overwrite one another in the shared pool. With ConflictError the first one
+// fails the build with a *SuiteConflictError; with ConflictHighestVersion every suite
+// lists the highest version, taken from the earliest suite offering it, with a warning.
+func reconcileSuites(builds []*suiteBuild, policy ConflictPolicy, log Logger, warnings *debian.WarningCollector) error {
+	type occurrence struct {
+		build *suiteBuild
+		key   string // Key in build.resolved
+	}
+	occurrences := make(map[string][]occurrence)
+	var ids []string
+	for _, build := range builds {
+		for _, key := range slices.Sorted(maps.Keys(build.resolved)) {
+			pkg := build.resolved[key]
+			id := pkg.Name + ":" + pkg.Architecture
+			if _, ok := occurrences[id]; !ok {
+				ids = append(ids, id)
+			}
+			occurrences[id] = append(occurrences[id], occurrence{build: build, key: key})
+		}
+	}
+
+	for _, id := range ids {
+		entries := make([]debian.Package, 0, len(occurrences[id]))
+		for _, occ := range occurrences[id] {
+			entries = append(entries, occ.build.resolved[occ.key])
+		}
+		if !slices.ContainsFunc(entries[1:], func(pkg debian.Package) bool { return !samePoolFile(pkg, entries[0]) }) {
+			continue
+		}
+		if policy == ConflictError {
+			return &SuiteConflictError{Package: entries[0].Name, Architecture: entries[0].Architecture, Entries: entries}
+		}
+
+		winner := entries[0]
+		for _, pkg := range entries[1:] {
+			if debian.CompareVersions(pkg.Version, winner.Version) > 0 {
+				winner = pkg
+			}
+		}
+		for _, occ := range occurrences[id] {
+			occ.build.resolved[occ.key] = winner
+		}
+		message := fmt.Sprintf("Warning: %s resolves differently across suites (%s); using %s from %s in each of them", id, describeEntries(entries), winner.Version, winner.Suite)
+		log.printf("%s", message)
+		warnings.Add(debian.Warning{Kind: debian.WarningSuiteConflict, Subject: winner.Name, Message: message})
+	}
+	return nil
+}
+
+// samePoolFile reports whether a and b are the same version stored in the same pool file.
+func samePoolFile(a, b debian.Package) bool {
+	return a.Version == b.Version && a.Filename == b.Filename && a.Size == b.Size && a.SHA256 == b.SHA256 && a.MD5sum == b.MD5sum
+}
+
+// describeEntries lists the version each suite resolved, e.g. "2.10-2 in bookworm,
+// 2.10-3 in bookworm-updates".
+func describeEntries(entries []debian.Package) string {
+	parts := make([]string, len(entries))
+	for i, pkg := range entries {
+		parts[i] = pkg.Version + " in " + pkg.Suite
+	}
+	return strings.Join(parts, ", ")
+}
+
+// recordInstallOrder stores the installation batches of the packages selected for
+// suite in result and, when requested and not in a dry run, writes the script
+// installing them from opts.DestDir. A Pre-Depends cycle only fails the build when
+// the script is requested; otherwise it is recorded as a warning.
+func recordInstallOrder(result *CustomRepoResult, suite string, opts CustomRepoOptions, warnings *debian.WarningCollector) error {
+	selected := make(map[string]debian.Package, len(result.Packages[suite]))
+	for _, pkg := range result.Packages[suite] {
+		selected[pkg.Name] = pkg
+	}
+
+	order, err := debian.TopologicalInstallOrder(selected)
+	if err != nil {
+		if opts.InstallScript {
+			return fmt.Errorf("unable to order the installation of suite %s: %w", suite, err)
+		}
+		warnings.Add(debian.Warning{Kind: debian.WarningUnsatisfiable, Subject: suite, Message: fmt.Sprintf("Warning: suite %s: no installation order: %v", suite, err)})
+		return nil
+	}
+	result.InstallOrder[suite] = order
+	opts.Log.printf("Suite %s: installation in %d batches", suite, len(order))
+
+	if !opts.InstallScript || opts.DryRun {
+		return nil
+	}
+	var script strings.Builder
+	if err := debian.WriteInstallScript(&script, order, selected); err != nil {
+		return err
+	}
+	scriptPath := filepath.Join(opts.DestDir, "install-"+suite+".sh")
+	if err := os.WriteFile(scriptPath, []byte(script.String()), 0755); err != nil {
+		return fmt.Errorf("unable to write %s: %w", scriptPath, err)
+	}
+	return nil
+}
+
+// checkPackageSet records a warning for each dependency the resolved set leaves unmet
+// and each conflict within it.
+func checkPackageSet(resolved map[string]debian.Package, suite string, log Logger, warnings *debian.WarningCollector) error {
+	selected := make([]debian.Package, 0, len(resolved))
+	for _, pkg := range resolved {
+		selected = append(selected, pkg)
+	}
+	slices.SortFunc(selected, func(a, b debian.Package) int { return strings.Compare(a.Name, b.Name) })
+
+	result, err := debian.CheckSatisfiability(selected)
+	if err != nil {
+		return fmt.Errorf("failed to check the package set for %s: %w", suite, err)
+	}
+	log.printf("Suite %s: %s", suite, result)
+	for _, issue := range slices.Concat(result.UnmetDepends, result.Conflicts) {
+		warnings.Add(debian.Warning{Kind: debian.WarningUnsatisfiable, Subject: suite, Message: fmt.Sprintf("Warning: suite %s: %s", suite, issue)})
+	}
+	return nil
+}
+
+// downloadSourcePackages downloads source packages corresponding to the resolved binary packages.
+// When plan is non-nil, files are added to it instead of being downloaded.
+func downloadSourcePackages(repo *debian.Repository, resolved []debian.Package, destDir, component string, downloader *debian.Downloader, log Logger, suite string, plan *debian.DownloadPlan) ([]debian.SourcePackage, error) {
+	// Get unique source package names from binary packages
+	sourceNames := make(map[string]struct{})
+	for _, pkg := range resolved {
+		srcName := pkg.Source
+		if srcName == "" {
+			srcName = pkg.Package
+		}
+		// Strip version info if present (e.g., "foo (>= 1.0)" -> "foo")
+		if idx := strings.Index(srcName, " "); idx != -1 {
+			srcName = srcName[:idx]
+		}
+		sourceNames[srcName] = struct{}{}
+	}
+
+	// Fetch source packages metadata
+	if _, err := repo.FetchSources(); err != nil {
+		return nil, fmt.Errorf("failed to fetch source packages: %w", err)
+	}
+	sourcePkgs := repo.GetAllSourceMetadata()
+
+	var result []debian.SourcePackage
+	for srcName := range sourceNames {
+		srcPkg, found := findSourcePackage(sourcePkgs, srcName)
+		if !found {
+			log.printf("Suite %s component %s: source package %s not found, skipping", suite, component, srcName)
+			continue
+		}
+
+		log.printf("Suite %s component %s: downloading source %s", suite, component, srcName)
+
+		// Download all files for this source package
+		updatedFiles := make([]debian.SourceFile, 0, len(srcPkg.Files))
+		for _, file := range srcPkg.Files {
+			relPath := filepath.ToSlash(filepath.Join("pool", component, poolPrefix(srcName), srcName, file.Name))
+
+			targetPath := filepath.Join(destDir, filepath.FromSlash(relPath))
+			targetDir := filepath.Dir(targetPath)
+
+			downloadURL := file.URL
+			if downloadURL == "" {
+				downloadURL = debian.PoolURL(repo.URL, relPath)
+			}
+
+			// Check if file already exists with correct checksum
+			info, statErr := os.Stat(targetPath)
+			exists := statErr == nil && info.Size() == file.Size
+
+			if plan != nil {
+				plan.Add(debian.PlanEntry{URL: downloadURL, DestPath: targetPath, Size: file.Size, Skip: exists})
+				updatedFiles = append(updatedFiles, file)
+				continue
+			}
+
+			if err := os.MkdirAll(targetDir, debian.DirPermission); err != nil {
+				return nil, fmt.Errorf("unable to create pool directory %s: %w", targetDir, err)
+			}
+
+			if exists {
+				log.printf("Suite %s component %s: skipping source file %s (already exists)", suite, component, file.Name)
+				updatedFiles = append(updatedFiles, file)
+				continue
+			}
+
+			if err := downloader.DownloadURL(downloadURL, targetPath); err != nil {
+				return nil, fmt.Errorf("failed to download source file %s: %w", file.Name, err)
+			}
+
+			updatedFiles = append(updatedFiles, file)
+		}
+
+		srcPkg.Files = updatedFiles
+		srcPkg.Directory = filepath.ToSlash(filepath.Join("pool", component, poolPrefix(srcName), srcName))
+		result = append(result, srcPkg)
+	}
+
+	return result, nil
+}
+
+// findSourcePackage finds a source package by name in the list.
+func findSourcePackage(packages []debian.SourcePackage, name string) (debian.SourcePackage, bool) {
+	for _, pkg := range packages {
+		if pkg.Name == name {
+			return pkg, true
+		}
+	}
+	return debian.SourcePackage{}, false
+}
+
+// poolPrefix returns the pool prefix for a package name (lib* uses 4-char, others 1-char).
+func poolPrefix(name string) string {
+	if strings.HasPrefix(name, "lib") && len(name) > 3 {
+		return name[:4]
+	}
+	return name[:1]
+}
+
+// extractComponentFromPath extracts the component from a pool path.
+// Example: "pool/non-free/s/snmp/..." -> "non-free"
+// Returns empty string if component cannot be determined.
+func extractComponentFromPath(path string, validComponents []string) string {
+	if path == "" {
+		return ""
+	}
+
+	// Normalize path separators
+	path = filepath.ToSlash(path)
+
+	// Split path and look for pool/ prefix
+	parts := strings.Split(path, "/")
+	if len(parts) < 2 || parts[0] != "pool" {
+		return ""
+	}
+
+	// Component is typically the second part (after "pool/")
+	component := parts[1]
+
+	// Validate against the list of valid components
+	for _, valid := range validComponents {
+		if component == valid {
+			return component
+		}
+	}
+
+	// If not found in valid list, still return it (could be new component)
+	return component
+}
@@ -0,0 +1,57 @@
+//go:build live
+
+package ops
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// These tests run against deb.debian.org; run them with go test -tags live.
+
+func TestCustomRepoSystemdWithoutRecommendsLive(t *testing.T) {
+	destDir := t.TempDir()
+
+	_, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:      debianSource(),
+		DestDir:     destDir,
+		Packages:    []debian.PackageSpec{{Name: "systemd"}},
+		ExcludeDeps: map[string]bool{"recommends": true, "suggests": true},
+	})
+	if err != nil {
+		t.Fatalf("custom-repo build failed: %v", err)
+	}
+
+	for _, path := range findDebs(t, destDir) {
+		if strings.HasPrefix(filepath.Base(path), "systemd_") {
+			return
+		}
+	}
+	t.Fatalf("expected systemd package under %s", destDir)
+}
+
+func TestCustomRepoSinglePackageNoDependenciesLive(t *testing.T) {
+	destDir := t.TempDir()
+
+	_, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:      debianSource(),
+		DestDir:     destDir,
+		Packages:    []debian.PackageSpec{{Name: "hello"}},
+		ExcludeDeps: map[string]bool{"depends": true, "pre-depends": true, "recommends": true, "suggests": true, "enhances": true},
+	})
+	if err != nil {
+		t.Fatalf("custom-repo build failed: %v", err)
+	}
+
+	debs := findDebs(t, destDir)
+	if len(debs) != 1 {
+		t.Fatalf("expected 1 downloaded package, got %d", len(debs))
+	}
+	if base := filepath.Base(debs[0]); !strings.HasPrefix(base, "hello_") {
+		t.Fatalf("expected hello package, got %s", base)
+	}
+}
@@ -0,0 +1,509 @@
+package ops
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CeGenreDeChat/deb-for-all/internal/testsupport"
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// debianSource returns the Source of bookworm/main/amd64 on deb.debian.org.
+func debianSource() Source {
+	return Source{
+		BaseURL:       DefaultBaseURL,
+		Suites:        []string{"bookworm"},
+		Components:    []string{"main"},
+		Architectures: []string{"amd64"},
+		SkipGPGVerify: true,
+	}
+}
+
+// findDebs returns the .deb files under dir.
+func findDebs(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var debs []string
+	walkErr := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(strings.ToLower(path), ".deb") {
+			debs = append(debs, path)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		t.Fatalf("walk failed: %v", walkErr)
+	}
+	return debs
+}
+
+// fixtureSource returns a Source serving a fixture bookworm/main/amd64 repository.
+func fixtureSource(t *testing.T) Source {
+	t.Helper()
+
+	repo := testsupport.NewRepository(t, testsupport.Config{
+		Packages: []testsupport.Package{
+			{Name: "hello", Version: "2.10-3", Depends: "libc6 (>= 2.34)"},
+			{Name: "libc6", Version: "2.36-9", Source: "glibc", PreDepends: "libgcc-s1"},
+			{Name: "libgcc-s1", Version: "12.2.0-14", Source: "gcc-12"},
+			{Name: "systemd", Version: "252.30-1", PreDepends: "libc6 (>= 2.34)", Depends: "libsystemd-shared (= 252.30-1)", Recommends: "dbus"},
+			{Name: "libsystemd-shared", Version: "252.30-1", Source: "systemd", Depends: "libc6"},
+			{Name: "dbus", Version: "1.14.10-1"},
+		},
+	})
+	source := debianSource()
+	source.BaseURL = repo.URL
+	return source
+}
+
+func TestCustomRepoSystemdWithoutRecommendsIntegration(t *testing.T) {
+	destDir := t.TempDir()
+
+	_, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:      fixtureSource(t),
+		DestDir:     destDir,
+		Packages:    []debian.PackageSpec{{Name: "systemd"}},
+		ExcludeDeps: map[string]bool{"recommends": true, "suggests": true},
+	})
+	if err != nil {
+		t.Fatalf("custom-repo build failed: %v", err)
+	}
+
+	found := make(map[string]bool)
+	for _, path := range findDebs(t, destDir) {
+		name, _, _ := strings.Cut(filepath.Base(path), "_")
+		found[name] = true
+	}
+	for _, name := range []string{"systemd", "libsystemd-shared", "libc6", "libgcc-s1"} {
+		if !found[name] {
+			t.Fatalf("expected %s package under %s, got %v", name, destDir, found)
+		}
+	}
+	if found["dbus"] {
+		t.Fatalf("recommended dbus should have been excluded")
+	}
+}
+
+func TestCustomRepoSinglePackageNoDependenciesIntegration(t *testing.T) {
+	destDir := t.TempDir()
+
+	_, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:      fixtureSource(t),
+		DestDir:     destDir,
+		Packages:    []debian.PackageSpec{{Name: "hello"}},
+		ExcludeDeps: map[string]bool{"depends": true, "pre-depends": true, "recommends": true, "suggests": true, "enhances": true},
+	})
+	if err != nil {
+		t.Fatalf("custom-repo build failed: %v", err)
+	}
+
+	debs := findDebs(t, destDir)
+	if len(debs) != 1 {
+		t.Fatalf("expected 1 downloaded package, got %d", len(debs))
+	}
+	if base := filepath.Base(debs[0]); !strings.HasPrefix(base, "hello_") {
+		t.Fatalf("expected hello package, got %s", base)
+	}
+}
+
+func TestCustomRepoDryRunWritesNoPoolFiles(t *testing.T) {
+	packages := "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\nSize: 7\n\n"
+	release := fmt.Sprintf("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			io.WriteString(w, release)
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			io.WriteString(w, packages)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := debianSource()
+	source.BaseURL = server.URL
+	destDir := t.TempDir()
+	result, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:   source,
+		DestDir:  destDir,
+		Packages: []debian.PackageSpec{{Name: "hello"}},
+		DryRun:   true,
+	})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+
+	plan := result.Plan
+	if plan == nil || plan.DownloadFiles != 1 || plan.DownloadBytes != 7 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	if plan.Entries[0].URL != server.URL+"/pool/main/h/hello/hello_2.10-3_amd64.deb" {
+		t.Fatalf("unexpected plan URL: %s", plan.Entries[0].URL)
+	}
+	if selected := result.Packages["bookworm"]; len(selected) != 1 || selected[0].Name != "hello" {
+		t.Fatalf("unexpected selected packages: %+v", selected)
+	}
+
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatalf("unable to read destination: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("dry run must leave the destination empty, found %d entries", len(entries))
+	}
+}
+
+func TestCustomRepoSharedCacheDownloadsOncePerFile(t *testing.T) {
+	debs := map[string]string{"hello": "hello-deb", "curl": "curl-deb", "wget": "wget-deb"}
+	var packages strings.Builder
+	for _, name := range []string{"curl", "hello", "wget"} {
+		fmt.Fprintf(&packages, "Package: %s\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/%c/%s/%s_1.0_amd64.deb\nSize: %d\nSHA256: %x\n\n",
+			name, name[0], name, name, len(debs[name]), sha256.Sum256([]byte(debs[name])))
+	}
+	release := fmt.Sprintf("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages.String())), packages.Len())
+
+	var mu sync.Mutex
+	poolHits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			io.WriteString(w, release)
+			return
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			io.WriteString(w, packages.String())
+			return
+		}
+		name := strings.TrimSuffix(filepath.Base(r.URL.Path), "_1.0_amd64.deb")
+		if content, ok := debs[name]; ok && r.Method == http.MethodGet {
+			mu.Lock()
+			poolHits[name]++
+			mu.Unlock()
+			io.WriteString(w, content)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	cache, err := debian.NewObjectCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("unable to open shared cache: %v", err)
+	}
+	source := debianSource()
+	source.BaseURL = server.URL
+	for _, list := range [][]debian.PackageSpec{{{Name: "hello"}, {Name: "curl"}}, {{Name: "curl"}, {Name: "wget"}}} {
+		if _, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+			Source:   source,
+			DestDir:  t.TempDir(),
+			Packages: list,
+			Cache:    cache,
+		}); err != nil {
+			t.Fatalf("custom-repo build failed: %v", err)
+		}
+	}
+
+	for name := range debs {
+		if poolHits[name] != 1 {
+			t.Errorf("%s downloaded %d times, want 1", name, poolHits[name])
+		}
+	}
+}
+
+func TestCustomRepoInstallScript(t *testing.T) {
+	debs := map[string]string{"libc6": "libc6-deb", "curl": "curl-deb"}
+	packages := fmt.Sprintf("Package: curl\nVersion: 1.0\nArchitecture: amd64\nPre-Depends: libc6 (>= 2.34)\nFilename: pool/main/c/curl/curl_1.0_amd64.deb\nSize: 8\nSHA256: %x\n\n"+
+		"Package: libc6\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/l/libc6/libc6_1.0_amd64.deb\nSize: 9\nSHA256: %x\n\n",
+		sha256.Sum256([]byte(debs["curl"])), sha256.Sum256([]byte(debs["libc6"])))
+	release := fmt.Sprintf("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			io.WriteString(w, release)
+			return
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			io.WriteString(w, packages)
+			return
+		}
+		if content, ok := debs[strings.TrimSuffix(filepath.Base(r.URL.Path), "_1.0_amd64.deb")]; ok {
+			io.WriteString(w, content)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	source := debianSource()
+	source.BaseURL = server.URL
+	destDir := t.TempDir()
+	result, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:        source,
+		DestDir:       destDir,
+		Packages:      []debian.PackageSpec{{Name: "curl"}},
+		InstallScript: true,
+	})
+	if err != nil {
+		t.Fatalf("custom-repo build failed: %v", err)
+	}
+
+	if order := result.InstallOrder["bookworm"]; len(order) != 2 || order[0][0] != "libc6" || order[1][0] != "curl" {
+		t.Fatalf("expected libc6 to be installed before curl, got %v", order)
+	}
+	script, err := os.ReadFile(filepath.Join(destDir, "install-bookworm.sh"))
+	if err != nil {
+		t.Fatalf("install script not written: %v", err)
+	}
+	libc6 := strings.Index(string(script), "dpkg -i 'pool/main/l/libc6/libc6_1.0_amd64.deb'\n")
+	curl := strings.Index(string(script), "dpkg -i 'pool/main/c/curl/curl_1.0_amd64.deb'\n")
+	if libc6 < 0 || curl < libc6 {
+		t.Fatalf("unexpected install script:\n%s", script)
+	}
+
+	index, err := readGeneratedPackages(filepath.Join(destDir, "dists", "bookworm", "main", "binary-amd64"))
+	if err != nil {
+		t.Fatalf("Packages file not written: %v", err)
+	}
+	if want := fmt.Sprintf("SHA512: %x\n", sha512.Sum512([]byte(debs["curl"]))); !strings.Contains(string(index), want) {
+		t.Fatalf("expected %q in Packages file:\n%s", want, index)
+	}
+}
+
+func TestCustomRepoKeepGoingAndRetryFailed(t *testing.T) {
+	debs := map[string]string{"libc6": "libc6-deb", "curl": "curl-deb"}
+	packages := fmt.Sprintf("Package: curl\nVersion: 1.0\nArchitecture: amd64\nDepends: libc6\nFilename: pool/main/c/curl/curl_1.0_amd64.deb\nSize: 8\nSHA256: %x\n\n"+
+		"Package: libc6\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/l/libc6/libc6_1.0_amd64.deb\nSize: 9\nSHA256: %x\n\n",
+		sha256.Sum256([]byte(debs["curl"])), sha256.Sum256([]byte(debs["libc6"])))
+	release := fmt.Sprintf("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", sha256.Sum256([]byte(packages)), len(packages))
+	var libc6Missing sync.Map
+	libc6Missing.Store("libc6", true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			io.WriteString(w, release)
+			return
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			io.WriteString(w, packages)
+			return
+		}
+		name := strings.TrimSuffix(filepath.Base(r.URL.Path), "_1.0_amd64.deb")
+		if _, missing := libc6Missing.Load(name); missing {
+			http.Error(w, "unavailable", http.StatusForbidden)
+			return
+		}
+		if content, ok := debs[name]; ok {
+			io.WriteString(w, content)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	source := debianSource()
+	source.BaseURL = server.URL
+	destDir := t.TempDir()
+	packagesPath := filepath.Join(destDir, "dists", "bookworm", "main", "binary-amd64", "Packages")
+
+	result, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:              source,
+		DestDir:             destDir,
+		Packages:            []debian.PackageSpec{{Name: "curl"}},
+		KeepGoing:           true,
+		IncludeUncompressed: true,
+	})
+	var partial *PartialBuildError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected a partial build, got %v", err)
+	}
+	if len(partial.Failures) != 1 || partial.Failures[0].Package != "libc6" || partial.Failures[0].Component != "main" {
+		t.Fatalf("unexpected failures: %+v", partial.Failures)
+	}
+	if selected := result.Packages["bookworm"]; len(selected) != 1 || selected[0].Name != "curl" {
+		t.Fatalf("expected only curl in the repository, got %+v", selected)
+	}
+	index, err := os.ReadFile(packagesPath)
+	if err != nil {
+		t.Fatalf("Packages file not written: %v", err)
+	}
+	if !strings.Contains(string(index), "Package: curl\n") || strings.Contains(string(index), "Package: libc6\n") {
+		t.Fatalf("expected only curl in the Packages file:\n%s", index)
+	}
+
+	failuresPath := filepath.Join(t.TempDir(), "failures.json")
+	if err := WriteFailures(failuresPath, partial.Failures); err != nil {
+		t.Fatalf("WriteFailures failed: %v", err)
+	}
+	failures, err := ReadFailures(failuresPath)
+	if err != nil || len(failures) != 1 || failures[0] != partial.Failures[0] {
+		t.Fatalf("failures changed by a round trip: %+v, %v", failures, err)
+	}
+
+	libc6Missing.Delete("libc6")
+	if _, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:              source,
+		DestDir:             destDir,
+		RetryFailed:         failures,
+		IncludeUncompressed: true,
+	}); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	index, err = os.ReadFile(packagesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	curl := strings.Index(string(index), "Package: curl\n")
+	libc6 := strings.Index(string(index), "Package: libc6\n")
+	if curl < 0 || libc6 < curl {
+		t.Fatalf("expected curl and libc6 merged in the Packages file:\n%s", index)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "pool", "main", "l", "libc6", "libc6_1.0_amd64.deb")); err != nil {
+		t.Fatalf("libc6 not downloaded: %v", err)
+	}
+	releaseFile, err := os.ReadFile(filepath.Join(destDir, "dists", "bookworm", "Release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(index)); !strings.Contains(string(releaseFile), want) {
+		t.Fatalf("Release does not list the merged Packages file:\n%s", releaseFile)
+	}
+	problems, err := debian.ValidateRepoLayout(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		if problem.Severity == debian.ProblemError {
+			t.Errorf("merged repository unusable by apt: %s", problem)
+		}
+	}
+}
+
+func TestCustomRepoCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := CustomRepoOperation(ctx, CustomRepoOptions{
+		Source:   debianSource(),
+		DestDir:  t.TempDir(),
+		Packages: []debian.PackageSpec{{Name: "hello"}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestCustomRepoSuiteConflict(t *testing.T) {
+	debs := map[string]string{"hello_1.0": "hello-1.0-deb", "hello_1.1": "hello-1.1-deb", "libc6_1.0": "libc6-deb"}
+	stanza := func(name, version, depends string) string {
+		content := debs[name+"_"+version]
+		return fmt.Sprintf("Package: %s\nVersion: %s\nArchitecture: amd64\n%sFilename: pool/main/%c/%s/%s_%s_amd64.deb\nSize: %d\nSHA256: %x\n\n",
+			name, version, depends, name[0], name, name, version, len(content), sha256.Sum256([]byte(content)))
+	}
+	indices := map[string]string{
+		"bookworm":         stanza("hello", "1.0", "Depends: libc6\n") + stanza("libc6", "1.0", ""),
+		"bookworm-updates": stanza("hello", "1.1", "Depends: libc6\n") + stanza("libc6", "1.0", ""),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for suite, packages := range indices {
+			switch r.URL.Path {
+			case "/dists/" + suite + "/Release", "/dists/" + suite + "/InRelease":
+				fmt.Fprintf(w, "Suite: %s\nCodename: %s\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", suite, suite, sha256.Sum256([]byte(packages)), len(packages))
+				return
+			case "/dists/" + suite + "/main/binary-amd64/Packages":
+				io.WriteString(w, packages)
+				return
+			}
+		}
+		if content, ok := debs[strings.TrimSuffix(filepath.Base(r.URL.Path), "_amd64.deb")]; ok {
+			io.WriteString(w, content)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	source := debianSource()
+	source.BaseURL = server.URL
+	source.Suites = []string{"bookworm", "bookworm-updates"}
+
+	destDir := t.TempDir()
+	result, err := CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:   source,
+		DestDir:  destDir,
+		Packages: []debian.PackageSpec{{Name: "hello"}},
+	})
+	if err != nil {
+		t.Fatalf("custom-repo build failed: %v", err)
+	}
+	for _, suite := range source.Suites {
+		for _, pkg := range result.Packages[suite] {
+			want := suite
+			if pkg.Name == "hello" {
+				want = "bookworm-updates"
+				if pkg.Version != "1.1" {
+					t.Fatalf("suite %s: expected hello 1.1, got %s", suite, pkg.Version)
+				}
+			}
+			if pkg.Suite != want {
+				t.Fatalf("suite %s: expected %s to come from %s, got %q", suite, pkg.Name, want, pkg.Suite)
+			}
+		}
+		index, err := readGeneratedPackages(filepath.Join(destDir, "dists", suite, "main", "binary-amd64"))
+		if err != nil {
+			t.Fatalf("Packages file of %s not written: %v", suite, err)
+		}
+		if !strings.Contains(string(index), "hello_1.1_amd64.deb\n") || strings.Contains(string(index), "hello_1.0_amd64.deb\n") {
+			t.Fatalf("expected only hello 1.1 in the Packages file of %s:\n%s", suite, index)
+		}
+	}
+	if debs := findDebs(t, destDir); len(debs) != 2 {
+		t.Fatalf("expected hello 1.1 and libc6 in the pool, got %v", debs)
+	}
+	conflicts := 0
+	for _, warning := range result.Warnings {
+		if warning.Kind == debian.WarningSuiteConflict {
+			conflicts++
+		}
+	}
+	if conflicts != 1 {
+		t.Fatalf("expected one suite_conflict warning, got %v", result.Warnings)
+	}
+
+	_, err = CustomRepoOperation(context.Background(), CustomRepoOptions{
+		Source:         source,
+		DestDir:        t.TempDir(),
+		Packages:       []debian.PackageSpec{{Name: "hello"}},
+		ConflictPolicy: ConflictError,
+	})
+	var conflictErr *SuiteConflictError
+	if !errors.As(err, &conflictErr) || conflictErr.Package != "hello" || len(conflictErr.Entries) != 2 {
+		t.Fatalf("expected a conflict on hello, got %v", err)
+	}
+}
+
+// readGeneratedPackages returns the content of the Packages.gz index written in dir.
+func readGeneratedPackages(dir string) ([]byte, error) {
+	file, err := os.Open(filepath.Join(dir, "Packages.gz"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
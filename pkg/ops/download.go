@@ -0,0 +1,255 @@
+package ops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// Defaults of DownloadOptions for the fields of Source left empty.
+const (
+	DefaultBaseURL      = "http://deb.debian.org/debian"
+	DefaultSuite        = "bookworm"
+	DefaultComponent    = "main"
+	DefaultArchitecture = "amd64"
+)
+
+// DownloadOptions configures DownloadOperation. Every suite of Source is searched:
+// without Version, the package is taken from the suite with the latest version, the
+// first in order on a tie; with Version, from the first suite listing that version.
+type DownloadOptions struct {
+	Source
+	Package string
+	Version string // Latest when empty
+	// Arch only fetches the Packages indices of that architecture, where arch:all
+	// packages are listed too, instead of every architecture of Source.
+	Arch     string
+	DestDir  string
+	CacheDir string // Metadata cache written by UpdateOperation, optional
+
+	Log      Logger
+	Selected func(pkg *debian.Package)     // Called once the package is chosen, optional
+	Progress func(downloaded, total int64) // Download progress, optional
+}
+
+// DownloadResult is the outcome of DownloadOperation.
+type DownloadResult struct {
+	Package   *debian.Package
+	Path      string
+	Skipped   bool   // An existing file already matched the package checksum
+	Suite     string // Suite the package was taken from, as chosen by DownloadOptions
+	FromCache bool   // The package was found in the metadata cache
+	Warnings  []debian.Warning
+}
+
+// ArchUnavailableError reports a package that exists, but not for the requested
+// architecture.
+type ArchUnavailableError struct {
+	Package   string
+	Arch      string
+	Available []string
+}
+
+func (e *ArchUnavailableError) Error() string {
+	return fmt.Sprintf("package %s is not available for architecture %s (available: %s)", e.Package, e.Arch, strings.Join(e.Available, ", "))
+}
+
+// DownloadOperation looks up a binary package in the suites of opts.Source, in order
+// and in the metadata cache first when opts.CacheDir is set, and downloads it into
+// opts.DestDir. A version no suite lists fails with a *debian.VersionNotFoundError. The context is checked
+// before the metadata is fetched and before the download starts.
+func DownloadOperation(ctx context.Context, opts DownloadOptions) (*DownloadResult, error) {
+	if opts.Package == "" {
+		return nil, fmt.Errorf("package name is required")
+	}
+	if err := os.MkdirAll(opts.DestDir, debian.DirPermission); err != nil {
+		return nil, fmt.Errorf("unable to create destination directory: %w", err)
+	}
+
+	if len(opts.Suites) == 0 {
+		opts.Suites = []string{DefaultSuite}
+	}
+	if len(opts.Components) == 0 {
+		opts.Components = []string{DefaultComponent}
+	}
+	if len(opts.Architectures) == 0 {
+		opts.Architectures = []string{DefaultArchitecture}
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+
+	// The suites are searched in order, so that a version published in only one of
+	// them, e.g. bookworm-security, is found too
+	warnings := debian.NewWarningCollector()
+	result := &DownloadResult{}
+	defer func() { result.Warnings = warnings.Warnings() }()
+	repos := make([]*debian.Repository, len(opts.Suites))
+	for i, suite := range opts.Suites {
+		repo := opts.repository("download-repo", "Repository for package download", suite)
+		repo.Warnings = warnings
+		// An explicit Arch only fetches that architecture's Packages indices; arch:all
+		// packages are listed there too.
+		if opts.Arch != "" {
+			repo.SetArchitectures([]string{opts.Arch})
+		}
+		// "host", "*" and "all-available" must be expanded before they can order lookups
+		if err := repo.ExpandArchitectures(); err != nil {
+			return result, fmt.Errorf("error expanding architectures: %w", err)
+		}
+		repos[i] = repo
+	}
+	set := debian.NewRepositorySet(repos...)
+
+	// Without an explicit Arch, each suite orders lookups by its own architectures
+	var archOrder []string
+	if opts.Arch != "" {
+		archOrder = []string{opts.Arch, "all"}
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	cached := make(map[string]bool)
+	var toFetch []*debian.Repository
+	for _, repo := range repos {
+		if opts.CacheDir != "" {
+			if _, err := repo.LoadCachedPackages(opts.CacheDir); err == nil {
+				cached[repo.Suite] = true
+				continue
+			}
+			opts.Log.printf("Metadata cache missing or invalid in %s for %s, fetching remotely", opts.CacheDir, repo.Suite)
+		}
+		toFetch = append(toFetch, repo)
+	}
+	if err := fetchAll(ctx, toFetch); err != nil {
+		return result, err
+	}
+
+	pkg, suite, err := selectBinaryPackage(set, opts.Package, opts.Version, opts.Arch, archOrder)
+	if err != nil && len(cached) > 0 {
+		// The cache may predate the package
+		opts.Log.printf("Package not found in the metadata cache, fetching remotely")
+		var stale []*debian.Repository
+		for _, repo := range repos {
+			if cached[repo.Suite] {
+				stale = append(stale, repo)
+			}
+		}
+		clear(cached)
+		if fetchErr := fetchAll(ctx, stale); fetchErr != nil {
+			return result, fetchErr
+		}
+		pkg, suite, err = selectBinaryPackage(set, opts.Package, opts.Version, opts.Arch, archOrder)
+	}
+	var versionErr *debian.VersionNotFoundError
+	if err != nil && opts.Arch != "" && !errors.As(err, &versionErr) {
+		for _, repo := range repos {
+			archErr := archUnavailableError(ctx, repo, opts.Package, opts.Arch, opts.Architectures, err)
+			if archErr != err {
+				err = archErr
+				break
+			}
+		}
+	}
+	if err != nil {
+		return result, fmt.Errorf("error retrieving metadata for package %s: %w", opts.Package, err)
+	}
+
+	result.Suite = suite
+	result.FromCache = cached[suite]
+	result.Package = pkg
+	result.Path = filepath.Join(opts.DestDir, packageFilename(pkg))
+	if opts.Selected != nil {
+		opts.Selected(pkg)
+	}
+
+	downloader := debian.NewDownloader()
+	downloader.Warnings = warnings
+	skip, err := downloader.ShouldSkipDownload(pkg, result.Path)
+	if err != nil {
+		return result, fmt.Errorf("failed to check existing file for %s: %w", pkg.Name, err)
+	}
+	if skip {
+		result.Skipped = true
+		return result, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+
+	if opts.Progress != nil {
+		err = downloader.DownloadWithProgress(pkg, result.Path, opts.Progress)
+	} else {
+		err = downloader.DownloadSilent(pkg, result.Path)
+	}
+	if err != nil {
+		return result, fmt.Errorf("error downloading: %w", err)
+	}
+	return result, nil
+}
+
+// fetchAll fetches the Packages metadata of every repository.
+func fetchAll(ctx context.Context, repos []*debian.Repository) error {
+	for _, repo := range repos {
+		if _, err := repo.FetchPackagesWithContext(ctx); err != nil {
+			if len(repos) > 1 {
+				err = &SuiteError{Suite: repo.Suite, Err: err}
+			}
+			return fmt.Errorf("error retrieving packages: %w", err)
+		}
+	}
+	return nil
+}
+
+// selectBinaryPackage looks up packageName in the loaded metadata of set and returns
+// it with its suite. With an explicit arch, only packages built for it or for all
+// architectures qualify.
+func selectBinaryPackage(set *debian.RepositorySet, packageName, version, arch string, archOrder []string) (*debian.Package, string, error) {
+	pkg, suite, err := set.FindPackage(packageName, version, archOrder)
+	if err != nil {
+		return nil, "", err
+	}
+	if arch != "" && pkg.Architecture != arch && pkg.Architecture != "all" {
+		return nil, "", fmt.Errorf("package %s is not available for %s", packageName, arch)
+	}
+	return pkg, suite, nil
+}
+
+// archUnavailableError returns an *ArchUnavailableError listing the architectures
+// packageName is available for. Only arch was fetched, so the other configured
+// architectures are fetched to build the list. lookupErr is returned unchanged when
+// the package exists for no architecture at all.
+func archUnavailableError(ctx context.Context, repo *debian.Repository, packageName, arch string, architectures []string, lookupErr error) error {
+	var others []string
+	for _, candidate := range architectures {
+		if candidate != arch {
+			others = append(others, candidate)
+		}
+	}
+
+	var available []string
+	if len(others) > 0 {
+		repo.Architectures = others
+		if _, err := repo.FetchPackagesWithContext(ctx); err == nil {
+			available = repo.GetAvailableArchitectures(packageName)
+		}
+	}
+	if len(available) == 0 {
+		return lookupErr
+	}
+	return &ArchUnavailableError{Package: packageName, Arch: arch, Available: available}
+}
+
+// packageFilename returns the expected .deb filename for a package, honoring metadata when present.
+func packageFilename(pkg *debian.Package) string {
+	if pkg.Filename != "" {
+		return pkg.Filename
+	}
+	return pkg.Name + "_" + pkg.Version + "_" + pkg.Architecture + ".deb"
+}
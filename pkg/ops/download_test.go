@@ -0,0 +1,203 @@
+package ops
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// newArchServer serves bookworm/main indices for amd64 and i386. hello is built for
+// both architectures, amd64-only is not built for i386. Requested paths are recorded.
+func newArchServer(t *testing.T) (*httptest.Server, func() []string) {
+	t.Helper()
+
+	indices := map[string]string{
+		"amd64": "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nFilename: pool/main/h/hello/hello_2.10-3_amd64.deb\nSize: 5\n\n" +
+			"Package: amd64-only\nVersion: 1.0\nArchitecture: amd64\nFilename: pool/main/a/amd64-only/amd64-only_1.0_amd64.deb\nSize: 5\n\n",
+		"i386": "Package: hello\nVersion: 2.10-3\nArchitecture: i386\nFilename: pool/main/h/hello/hello_2.10-3_i386.deb\nSize: 4\n\n",
+	}
+
+	var release strings.Builder
+	release.WriteString("Suite: bookworm\nCodename: bookworm\nArchitectures: amd64 i386\nComponents: main\nSHA256:\n")
+	for arch, packages := range indices {
+		fmt.Fprintf(&release, " %x %d main/binary-%s/Packages\n", sha256.Sum256([]byte(packages)), len(packages), arch)
+	}
+
+	var mu sync.Mutex
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested = append(requested, r.URL.Path)
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/dists/bookworm/Release", "/dists/bookworm/InRelease":
+			io.WriteString(w, release.String())
+		case "/dists/bookworm/main/binary-amd64/Packages":
+			io.WriteString(w, indices["amd64"])
+		case "/dists/bookworm/main/binary-i386/Packages":
+			io.WriteString(w, indices["i386"])
+		case "/pool/main/h/hello/hello_2.10-3_i386.deb":
+			io.WriteString(w, "i386")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requested...)
+	}
+}
+
+// archSource returns the Source of bookworm/main on server for architectures.
+func archSource(server *httptest.Server, architectures ...string) Source {
+	return Source{
+		BaseURL:       server.URL,
+		Suites:        []string{"bookworm"},
+		Components:    []string{"main"},
+		Architectures: architectures,
+		SkipGPGVerify: true,
+	}
+}
+
+func TestDownloadForeignArch(t *testing.T) {
+	server, requested := newArchServer(t)
+	defer server.Close()
+
+	destDir := t.TempDir()
+	result, err := DownloadOperation(context.Background(), DownloadOptions{
+		Source:  archSource(server, "amd64"),
+		Package: "hello",
+		Arch:    "i386",
+		DestDir: destDir,
+	})
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+
+	wantPath := filepath.Join(destDir, "pool", "main", "h", "hello", "hello_2.10-3_i386.deb")
+	if result.Path != wantPath || result.Package.Architecture != "i386" || result.Skipped {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	data, err := os.ReadFile(wantPath)
+	if err != nil || string(data) != "i386" {
+		t.Fatalf("expected the i386 package, got %q (err %v)", data, err)
+	}
+	for _, path := range requested() {
+		if strings.Contains(path, "binary-amd64") {
+			t.Fatalf("Arch i386 must not fetch amd64 indices, requested %s", path)
+		}
+	}
+
+	// A second run finds the file in place
+	result, err = DownloadOperation(context.Background(), DownloadOptions{
+		Source:  archSource(server, "amd64"),
+		Package: "hello",
+		Arch:    "i386",
+		DestDir: destDir,
+	})
+	if err != nil || !result.Skipped {
+		t.Fatalf("expected the existing file to be kept, got %+v (err %v)", result, err)
+	}
+}
+
+func TestDownloadArchUnavailableListsAvailable(t *testing.T) {
+	server, _ := newArchServer(t)
+	defer server.Close()
+
+	_, err := DownloadOperation(context.Background(), DownloadOptions{
+		Source:  archSource(server, "amd64", "i386"),
+		Package: "amd64-only",
+		Arch:    "i386",
+		DestDir: t.TempDir(),
+	})
+	var archErr *ArchUnavailableError
+	if !errors.As(err, &archErr) {
+		t.Fatalf("expected an *ArchUnavailableError, got %v", err)
+	}
+	if archErr.Package != "amd64-only" || archErr.Arch != "i386" || !slices.Equal(archErr.Available, []string{"amd64"}) {
+		t.Fatalf("unexpected error %+v", archErr)
+	}
+}
+
+func TestDownloadFallsBackAcrossSuites(t *testing.T) {
+	debs := map[string]string{"openssl_3.0.14-1~deb12u2": "bookworm-deb", "openssl_3.0.15-1~deb12u1": "security-deb"}
+	stanza := func(version string) string {
+		content := debs["openssl_"+version]
+		return fmt.Sprintf("Package: openssl\nVersion: %s\nArchitecture: amd64\nFilename: pool/main/o/openssl/openssl_%s_amd64.deb\nSize: %d\nSHA256: %x\n\n",
+			version, version, len(content), sha256.Sum256([]byte(content)))
+	}
+	indices := map[string]string{
+		"bookworm":          stanza("3.0.14-1~deb12u2"),
+		"bookworm-security": stanza("3.0.15-1~deb12u1"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for suite, packages := range indices {
+			switch r.URL.Path {
+			case "/dists/" + suite + "/Release", "/dists/" + suite + "/InRelease":
+				fmt.Fprintf(w, "Suite: %s\nCodename: %s\nArchitectures: amd64\nComponents: main\nSHA256:\n %x %d main/binary-amd64/Packages\n", suite, suite, sha256.Sum256([]byte(packages)), len(packages))
+				return
+			case "/dists/" + suite + "/main/binary-amd64/Packages":
+				io.WriteString(w, packages)
+				return
+			}
+		}
+		if content, ok := debs[strings.TrimSuffix(filepath.Base(r.URL.Path), "_amd64.deb")]; ok {
+			io.WriteString(w, content)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	source := archSource(server, "amd64")
+	source.Suites = []string{"bookworm", "bookworm-security"}
+
+	destDir := t.TempDir()
+	result, err := DownloadOperation(context.Background(), DownloadOptions{
+		Source:  source,
+		Package: "openssl",
+		Version: "3.0.15-1~deb12u1",
+		DestDir: destDir,
+	})
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if result.Suite != "bookworm-security" || result.Package.Version != "3.0.15-1~deb12u1" {
+		t.Fatalf("expected the version of bookworm-security, got %s from %s", result.Package.Version, result.Suite)
+	}
+	if data, err := os.ReadFile(result.Path); err != nil || string(data) != "security-deb" {
+		t.Fatalf("expected the bookworm-security package, got %q (err %v)", data, err)
+	}
+
+	// Without a version, the latest version across the suites wins
+	result, err = DownloadOperation(context.Background(), DownloadOptions{Source: source, Package: "openssl", DestDir: t.TempDir()})
+	if err != nil || result.Suite != "bookworm-security" || result.Package.Version != "3.0.15-1~deb12u1" {
+		t.Fatalf("expected the latest version, from bookworm-security, got %+v (err %v)", result, err)
+	}
+
+	_, err = DownloadOperation(context.Background(), DownloadOptions{Source: source, Package: "openssl", Version: "3.0.16-1", DestDir: t.TempDir()})
+	var versionErr *debian.VersionNotFoundError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("expected a *debian.VersionNotFoundError, got %v", err)
+	}
+	want := []debian.SuiteVersions{{Suite: "bookworm", Versions: []string{"3.0.14-1~deb12u2"}}, {Suite: "bookworm-security", Versions: []string{"3.0.15-1~deb12u1"}}}
+	if !reflect.DeepEqual(versionErr.Available, want) {
+		t.Fatalf("got available versions %+v, want %+v", versionErr.Available, want)
+	}
+}
@@ -0,0 +1,149 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// MirrorOptions configures MirrorOperation.
+type MirrorOptions struct {
+	Source
+	DestDir          string
+	DownloadPackages bool
+	RateDelay        time.Duration
+	MetadataTimeout  time.Duration // Zero keeps the debian.MirrorConfig defaults
+	PackageTimeout   time.Duration
+	Cache            *debian.ObjectCache // Shared content-addressed cache, optional
+	Storage          debian.Storage      // Receives the mirrored files instead of DestDir, optional
+
+	// DryRun plans the pool downloads without performing them; dists/ is only
+	// written when WriteMetadata is set.
+	DryRun        bool
+	WriteMetadata bool
+
+	// SkipMissing skips the suite/component/arch combinations missing upstream with
+	// a warning, instead of failing.
+	SkipMissing bool
+
+	// IncludeInstaller also mirrors the debian-installer indices and their udebs.
+	IncludeInstaller bool
+
+	// IncludeUncompressed also stores each Packages index uncompressed (see
+	// debian.MirrorConfig.IncludeUncompressed).
+	IncludeUncompressed bool
+
+	// IncludeDEP11 and ExtraIndices also mirror the AppStream metadata and the
+	// Release-listed files matching these patterns (see
+	// debian.MirrorConfig.IncludeExtraIndices).
+	IncludeDEP11 bool
+	ExtraIndices []string
+
+	// AllIndices mirrors every file the Release file lists, restricted by
+	// IndexInclude and IndexExclude (see debian.MirrorConfig.MirrorAllIndices).
+	AllIndices   bool
+	IndexInclude []string
+	IndexExclude []string
+
+	// PackageList limits pool/ to the listed packages, with their dependencies when
+	// PackageListDependencies is set, while dists/ is mirrored unchanged.
+	PackageList             []debian.PackageSpec
+	PackageListDependencies bool
+	PackageListExclude      map[string]bool
+
+	// Progress receives the mirror events. Prepared is called with the configured
+	// mirror before cloning starts, e.g. to report its configuration.
+	Progress func(event debian.MirrorEvent)
+	Prepared func(mirror *debian.Mirror)
+}
+
+// MirrorResult is the outcome of MirrorOperation.
+type MirrorResult struct {
+	Mirror   *debian.Mirror
+	Plan     *debian.DownloadPlan // Set for a dry run
+	Skipped  []string             // suite/component/arch combinations missing upstream
+	Warnings []debian.Warning
+}
+
+// MirrorOperation validates every suite of opts against its Release file and mirrors
+// the repository into opts.DestDir. The context is checked before cloning starts.
+func MirrorOperation(ctx context.Context, opts MirrorOptions) (*MirrorResult, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	config := debian.MirrorConfig{
+		BaseURL:                 opts.BaseURL,
+		Suites:                  opts.Suites,
+		Components:              opts.Components,
+		Architectures:           opts.Architectures,
+		DownloadPackages:        opts.DownloadPackages,
+		KeyringPaths:            debian.ResolveKeyringPathsExternal(opts.Keyrings, opts.KeyringDirs),
+		SkipGPGVerify:           opts.SkipGPGVerify,
+		RequireFingerprint:      opts.RequireFingerprint,
+		RateDelay:               opts.RateDelay,
+		MetadataTimeout:         opts.MetadataTimeout,
+		PackageTimeout:          opts.PackageTimeout,
+		DryRun:                  opts.DryRun,
+		WriteMetadata:           opts.WriteMetadata,
+		Cache:                   opts.Cache,
+		Storage:                 opts.Storage,
+		AutoAdjustComponents:    opts.Lenient,
+		SkipMissing:             opts.SkipMissing,
+		IncludeInstaller:        opts.IncludeInstaller,
+		IncludeUncompressed:     opts.IncludeUncompressed,
+		IncludeDEP11:            opts.IncludeDEP11,
+		IncludeExtraIndices:     opts.ExtraIndices,
+		MirrorAllIndices:        opts.AllIndices,
+		IndexInclude:            opts.IndexInclude,
+		IndexExclude:            opts.IndexExclude,
+		PackageList:             opts.PackageList,
+		PackageListDependencies: opts.PackageListDependencies,
+		PackageListExclude:      opts.PackageListExclude,
+	}
+
+	for _, suite := range opts.Suites {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		repo := opts.repository("mirror-validate"+suite, "mirror validation", suite)
+		if opts.SkipMissing {
+			if err := dropMissingArchitectures(repo); err != nil {
+				return nil, fmt.Errorf("invalid %w", &SuiteError{Suite: suite, Err: err})
+			}
+		}
+		if err := ValidateSuite(repo, opts.Lenient); err != nil {
+			return nil, fmt.Errorf("invalid %w", &SuiteError{Suite: suite, Err: err})
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := os.MkdirAll(opts.DestDir, debian.DirPermission); err != nil {
+		return nil, fmt.Errorf("unable to create destination directory: %w", err)
+	}
+
+	mirror := debian.NewMirror(config, opts.DestDir)
+	mirror.ProgressHandler = opts.Progress
+	if opts.Prepared != nil {
+		opts.Prepared(mirror)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &MirrorResult{Mirror: mirror}
+	err := mirror.Clone()
+	result.Plan = mirror.Plan()
+	result.Skipped = mirror.SkippedCombinations()
+	result.Warnings = mirror.GetWarnings()
+	if err != nil {
+		return result, fmt.Errorf("failed to create mirror: %w", err)
+	}
+	return result, nil
+}
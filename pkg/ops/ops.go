@@ -0,0 +1,160 @@
+// Package ops runs the deb-for-all operations — mirroring a repository, building a
+// custom repository, updating the metadata cache and downloading a binary package —
+// with typed options and structured results, so Go programs can embed them instead
+// of shelling out to the command line. Progress messages go to the callbacks of the
+// options rather than to stdout, warnings are returned in the result, and errors are
+// typed where a caller may want to report them in its own words.
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// Source describes the upstream repository an operation reads from.
+type Source struct {
+	BaseURL       string
+	Suites        []string
+	Components    []string
+	Architectures []string // May contain "*", "all-available" or "host"
+	Keyrings      []string // Keyring files trusted to verify Release signatures
+	KeyringDirs   []string // Directories whose keyrings are trusted as well
+	SkipGPGVerify bool
+	// RequireFingerprint rejects Release files not signed with the key of this
+	// fingerprint, even when they verify against the keyrings.
+	RequireFingerprint string
+	// Lenient drops the components a suite does not provide, with a warning, and adds
+	// non-free-firmware where it was split from non-free.
+	Lenient bool
+}
+
+// check rejects a source without suites, components or architectures.
+func (s *Source) check() error {
+	if len(s.Suites) == 0 {
+		return fmt.Errorf("at least one suite is required")
+	}
+	if len(s.Components) == 0 {
+		return fmt.Errorf("at least one component is required")
+	}
+	if len(s.Architectures) == 0 {
+		return fmt.Errorf("at least one architecture is required")
+	}
+	if _, err := debian.NormalizeFingerprint(s.RequireFingerprint); err != nil {
+		return err
+	}
+	if s.RequireFingerprint != "" && s.SkipGPGVerify {
+		return fmt.Errorf("a required signer fingerprint cannot be checked without GPG verification")
+	}
+	return nil
+}
+
+// repository returns a Repository for suite of the source, with its keyrings.
+func (s *Source) repository(name, description, suite string) *debian.Repository {
+	repo := debian.NewRepository(name, s.BaseURL, description, suite, s.Components, s.Architectures)
+	repo.SetKeyringPathsWithDirs(s.Keyrings, s.KeyringDirs)
+	if s.SkipGPGVerify {
+		repo.DisableSignatureVerification()
+	}
+	// check validated the fingerprint
+	_ = repo.RequireSignerFingerprint(s.RequireFingerprint)
+	return repo
+}
+
+// Logger receives progress messages. A nil Logger discards them.
+type Logger func(format string, args ...any)
+
+func (l Logger) printf(format string, args ...any) {
+	if l != nil {
+		l(format, args...)
+	}
+}
+
+// SuiteError ties an error to the suite it occurred for.
+type SuiteError struct {
+	Suite string
+	Err   error
+}
+
+func (e *SuiteError) Error() string {
+	return fmt.Sprintf("suite %s: %v", e.Suite, e.Err)
+}
+
+func (e *SuiteError) Unwrap() error {
+	return e.Err
+}
+
+// ErrReleaseUnavailable is wrapped by a ReleaseFetchError when the Release file was
+// fetched without yielding Release information.
+var ErrReleaseUnavailable = errors.New("Release information unavailable")
+
+// ReleaseFetchError is returned by ValidateSuite when the Release file of the suite
+// cannot be fetched.
+type ReleaseFetchError struct {
+	Err error
+}
+
+func (e *ReleaseFetchError) Error() string {
+	return fmt.Sprintf("failed to fetch Release file: %v", e.Err)
+}
+
+func (e *ReleaseFetchError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateSuite checks the configuration of repo against the Release file of its
+// suite, fetching it when it is not loaded. With lenient, components the suite lacks
+// are dropped with a warning and non-free-firmware is added where it was split from
+// non-free. A configuration the Release file does not match is reported as a
+// *debian.ReleaseMismatchError.
+func ValidateSuite(repo *debian.Repository, lenient bool) error {
+	if err := repo.Validate(); err != nil {
+		return err
+	}
+	if lenient {
+		repo.AutoAdjustComponents = true
+	}
+	if err := ensureReleaseInfo(repo); err != nil {
+		return err
+	}
+	return repo.ValidateAgainstRelease()
+}
+
+func ensureReleaseInfo(repo *debian.Repository) error {
+	if repo.GetReleaseInfo() != nil {
+		return nil
+	}
+	if err := repo.FetchReleaseFile(); err != nil {
+		return &ReleaseFetchError{Err: err}
+	}
+	if repo.GetReleaseInfo() == nil {
+		return &ReleaseFetchError{Err: ErrReleaseUnavailable}
+	}
+	return nil
+}
+
+// dropMissingArchitectures limits repo to the architectures its suite's Release file
+// lists, so validation accepts a suite lacking some of them; the mirror then skips
+// those with a warning (see debian.MirrorConfig.SkipMissing). The list is kept as is
+// when the suite has none of them, for validation to report it.
+func dropMissingArchitectures(repo *debian.Repository) error {
+	if err := ensureReleaseInfo(repo); err != nil {
+		return err
+	}
+	if err := repo.ExpandArchitectures(); err != nil {
+		return err
+	}
+
+	if available, _ := repo.GetAvailableSuiteArchitectures(); len(available) == 0 {
+		return nil
+	}
+	kept := slices.DeleteFunc(slices.Clone(repo.Architectures), func(arch string) bool {
+		return !repo.SupportsArchitecture(arch)
+	})
+	if len(kept) > 0 {
+		repo.SetArchitectures(kept)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CeGenreDeChat/deb-for-all/pkg/debian"
+)
+
+// UpdateOptions configures UpdateOperation.
+type UpdateOptions struct {
+	Source
+	CacheDir string
+	OnSuite  func(suite string) // Called before each suite is fetched, optional
+}
+
+// UpdateResult is the outcome of UpdateOperation.
+type UpdateResult struct {
+	Suites   []string // Suites whose cache was refreshed
+	Warnings []debian.Warning
+}
+
+// UpdateOperation refreshes the metadata cache in opts.CacheDir for every suite of
+// opts: the Packages indices, their parsed form, which later downloads load instead
+// of fetching and re-parsing them, and the package names completed by the CLI. The context is checked between suites.
+func UpdateOperation(ctx context.Context, opts UpdateOptions) (*UpdateResult, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+
+	warnings := debian.NewWarningCollector()
+	result := &UpdateResult{}
+	defer func() { result.Warnings = warnings.Warnings() }()
+
+	for _, suite := range opts.Suites {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		repo := opts.repository("cache-"+suite, "cache update", suite)
+		repo.Warnings = warnings
+		if err := ValidateSuite(repo, opts.Lenient); err != nil {
+			return result, fmt.Errorf("validation failed for %w", &SuiteError{Suite: suite, Err: err})
+		}
+
+		if opts.OnSuite != nil {
+			opts.OnSuite(suite)
+		}
+		if err := repo.FetchAndCachePackages(opts.CacheDir); err != nil {
+			return result, fmt.Errorf("failed to update cache for suite %s: %w", suite, err)
+		}
+
+		// Store parsed metadata next to the text cache so later runs skip re-parsing.
+		if _, err := repo.LoadCachedPackages(opts.CacheDir); err != nil {
+			return result, fmt.Errorf("failed to parse cache for suite %s: %w", suite, err)
+		}
+		if err := repo.SaveMetadata(debian.MetadataCachePath(opts.CacheDir, suite)); err != nil {
+			return result, fmt.Errorf("failed to write metadata cache for suite %s: %w", suite, err)
+		}
+		result.Suites = append(result.Suites, suite)
+	}
+
+	return result, nil
+}